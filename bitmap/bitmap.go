@@ -0,0 +1,196 @@
+// Package bitmap stores, for selected commits, the set of objects reachable from them as a
+// bitmap over the objects of the multi-pack-index, so reachability queries can stop walking at
+// those commits. The files follow git's multi-pack-index bitmap format, with EWAH compressed
+// bitmaps.
+package bitmap
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/bits"
+)
+
+// Bitmap is a set of positions in the pseudo-pack order of a multi-pack-index.
+type Bitmap struct {
+	words []uint64
+}
+
+// New returns an empty bitmap.
+func New() *Bitmap {
+	return &Bitmap{}
+}
+
+// Set adds a position to the bitmap.
+func (b *Bitmap) Set(i int) {
+	for i/64 >= len(b.words) {
+		b.words = append(b.words, 0)
+	}
+	b.words[i/64] |= 1 << (i % 64)
+}
+
+// Has reports whether a position is in the bitmap.
+func (b *Bitmap) Has(i int) bool {
+	return i/64 < len(b.words) && b.words[i/64]&(1<<(i%64)) != 0
+}
+
+// Or adds the positions of another bitmap.
+func (b *Bitmap) Or(other *Bitmap) {
+	for len(b.words) < len(other.words) {
+		b.words = append(b.words, 0)
+	}
+	for i, word := range other.words {
+		b.words[i] |= word
+	}
+}
+
+// AndNot removes the positions of another bitmap.
+func (b *Bitmap) AndNot(other *Bitmap) {
+	for i := 0; i < len(b.words) && i < len(other.words); i++ {
+		b.words[i] &^= other.words[i]
+	}
+}
+
+// xor toggles the positions of another bitmap.
+func (b *Bitmap) xor(other *Bitmap) {
+	for len(b.words) < len(other.words) {
+		b.words = append(b.words, 0)
+	}
+	for i, word := range other.words {
+		b.words[i] ^= word
+	}
+}
+
+// Clone returns a copy of the bitmap.
+func (b *Bitmap) Clone() *Bitmap {
+	return &Bitmap{words: append([]uint64(nil), b.words...)}
+}
+
+// Count returns the number of positions in the bitmap.
+func (b *Bitmap) Count() int {
+	count := 0
+	for _, word := range b.words {
+		count += bits.OnesCount64(word)
+	}
+	return count
+}
+
+// Each calls fn for every position in the bitmap, in increasing order.
+func (b *Bitmap) Each(fn func(i int)) {
+	for w, word := range b.words {
+		for word != 0 {
+			fn(w*64 + bits.TrailingZeros64(word))
+			word &= word - 1
+		}
+	}
+}
+
+// Equal reports whether two bitmaps hold the same positions.
+func (b *Bitmap) Equal(other *Bitmap) bool {
+	for i := 0; i < max(len(b.words), len(other.words)); i++ {
+		var x, y uint64
+		if i < len(b.words) {
+			x = b.words[i]
+		}
+		if i < len(other.words) {
+			y = other.words[i]
+		}
+		if x != y {
+			return false
+		}
+	}
+	return true
+}
+
+// EWAH compression: the words of a bitmap are stored as a sequence of marker words, each
+// followed by literal words. A marker holds a fill bit in bit 0, the number of words of that
+// fill in the next 32 bits, and the number of literal words after it in the top 31 bits.
+const (
+	maxRunning  = 1<<32 - 1
+	maxLiterals = 1<<31 - 1
+)
+
+// encodeEWAH compresses a bitmap of size bits: the size, the number of words, the words and
+// the position of the last marker word.
+func (b *Bitmap) encodeEWAH(size int) []byte {
+	words := make([]uint64, (size+63)/64)
+	copy(words, b.words)
+
+	var buffer []uint64
+	marker := 0
+	for i := 0; i < len(words) || len(buffer) == 0; {
+		marker = len(buffer)
+		buffer = append(buffer, 0)
+
+		var fill uint64
+		running := 0
+		if i < len(words) && (words[i] == 0 || words[i] == ^uint64(0)) {
+			word := words[i]
+			for i < len(words) && words[i] == word && running < maxRunning {
+				i++
+				running++
+			}
+			if word != 0 {
+				fill = 1
+			}
+		}
+
+		literals := 0
+		for i < len(words) && words[i] != 0 && words[i] != ^uint64(0) && literals < maxLiterals {
+			buffer = append(buffer, words[i])
+			i++
+			literals++
+		}
+
+		buffer[marker] = fill | uint64(running)<<1 | uint64(literals)<<33
+	}
+
+	out := binary.BigEndian.AppendUint32(nil, uint32(size))
+	out = binary.BigEndian.AppendUint32(out, uint32(len(buffer)))
+	for _, word := range buffer {
+		out = binary.BigEndian.AppendUint64(out, word)
+	}
+	return binary.BigEndian.AppendUint32(out, uint32(marker))
+}
+
+// decodeEWAH reads a compressed bitmap, returning it and the number of bytes it took.
+func decodeEWAH(data []byte) (*Bitmap, int, error) {
+	if len(data) < 8 {
+		return nil, 0, fmt.Errorf("truncated bitmap")
+	}
+	size := int(binary.BigEndian.Uint32(data))
+	count := int(binary.BigEndian.Uint32(data[4:]))
+	end := 8 + count*8 + 4
+	if count < 0 || len(data) < end {
+		return nil, 0, fmt.Errorf("truncated bitmap")
+	}
+
+	total := (size + 63) / 64
+	words := make([]uint64, 0, total)
+	for i := 0; i < count; {
+		marker := binary.BigEndian.Uint64(data[8+i*8:])
+		i++
+
+		running := int(marker >> 1 & maxRunning)
+		literals := int(marker >> 33)
+		if running > total-len(words) || literals > count-i {
+			return nil, 0, fmt.Errorf("bitmap runs past its size")
+		}
+
+		var fill uint64
+		if marker&1 != 0 {
+			fill = ^uint64(0)
+		}
+		for ; running > 0; running-- {
+			words = append(words, fill)
+		}
+		for ; literals > 0; literals-- {
+			words = append(words, binary.BigEndian.Uint64(data[8+i*8:]))
+			i++
+		}
+	}
+	if len(words) > total {
+		return nil, 0, fmt.Errorf("bitmap runs past its size")
+	}
+
+	return &Bitmap{words: words}, end, nil
+}
@@ -0,0 +1,116 @@
+package bitmap
+
+import (
+	"testing"
+)
+
+func TestBitmap(t *testing.T) {
+	b := New()
+	for _, i := range []int{0, 3, 64, 200} {
+		b.Set(i)
+	}
+	if !b.Has(64) || b.Has(65) || b.Has(1000) || b.Count() != 4 {
+		t.Errorf("Unexpected bitmap %v", b.words)
+	}
+
+	other := New()
+	other.Set(3)
+	other.Set(500)
+	union := b.Clone()
+	union.Or(other)
+	if union.Count() != 5 || b.Count() != 4 {
+		t.Errorf("Expected Or to leave the clone's source alone, got %d and %d", union.Count(), b.Count())
+	}
+	union.AndNot(other)
+
+	var positions []int
+	union.Each(func(i int) { positions = append(positions, i) })
+	expected := []int{0, 64, 200}
+	if len(positions) != len(expected) {
+		t.Fatalf("Expected positions %v, got %v", expected, positions)
+	}
+	for i := range expected {
+		if positions[i] != expected[i] {
+			t.Fatalf("Expected positions %v, got %v", expected, positions)
+		}
+	}
+
+	// Trailing empty words do not matter
+	padded := New()
+	padded.Set(900)
+	padded.AndNot(padded.Clone())
+	if !padded.Equal(New()) || padded.Equal(b) {
+		t.Errorf("Expected bitmaps to compare by their positions")
+	}
+}
+
+func TestEWAH(t *testing.T) {
+	tests := map[string]struct {
+		size      int
+		positions []int
+	}{
+		"empty":    {size: 0},
+		"no bits":  {size: 1000},
+		"sparse":   {size: 1000, positions: []int{1, 99, 640, 999}},
+		"run":      {size: 70 * 64, positions: rangeOf(64, 64*65)},
+		"mixed":    {size: 5000, positions: append(rangeOf(0, 640), 700, 1500, 4999)},
+		"unsorted": {size: 130, positions: []int{129, 0, 64}},
+	}
+	for name, test := range tests {
+		b := New()
+		for _, i := range test.positions {
+			b.Set(i)
+		}
+
+		data := b.encodeEWAH(test.size)
+		decoded, n, err := decodeEWAH(append(data, 0xaa))
+		if err != nil {
+			t.Errorf("%s: decodeEWAH failed: %v", name, err)
+			continue
+		}
+		if n != len(data) {
+			t.Errorf("%s: Expected %d bytes read, got %d", name, len(data), n)
+		}
+		if !decoded.Equal(b) {
+			t.Errorf("%s: Expected %v after a round trip, got %v", name, b.words, decoded.words)
+		}
+	}
+
+	// Runs of identical words are compressed
+	full := New()
+	for _, i := range rangeOf(0, 64*1000) {
+		full.Set(i)
+	}
+	if data := full.encodeEWAH(64 * 1000); len(data) > 32 {
+		t.Errorf("Expected a run of full words to take one marker, got %d bytes", len(data))
+	}
+}
+
+func TestDecodeEWAHInvalid(t *testing.T) {
+	b := New()
+	b.Set(5)
+	data := b.encodeEWAH(64)
+
+	if _, _, err := decodeEWAH(data[:len(data)-1]); err == nil {
+		t.Errorf("Expected an error for a truncated bitmap")
+	}
+
+	// A run longer than the bitmap's size
+	b = New()
+	for _, i := range rangeOf(0, 256) {
+		b.Set(i)
+	}
+	data = b.encodeEWAH(256)
+	data[2], data[3] = 0, 64
+	if _, _, err := decodeEWAH(data); err == nil {
+		t.Errorf("Expected an error for a run past the size")
+	}
+}
+
+func rangeOf(from, to int) []int {
+	var positions []int
+	for i := from; i < to; i++ {
+		positions = append(positions, i)
+	}
+	return positions
+}
@@ -0,0 +1,242 @@
+package bitmap
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"orf/oid"
+	"orf/pack"
+	"orf/repository"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// ErrNotFound is returned when a repository has no bitmaps for its multi-pack-index.
+var ErrNotFound = errors.New("no reachability bitmaps")
+
+var signature = []byte("BITM")
+
+const (
+	version = 1
+
+	// optionFullDAG marks bitmaps holding every object reachable from their commit, the only
+	// kind git writes.
+	optionFullDAG = 0x1
+
+	headerSize = 12
+)
+
+// types are the object types with a type bitmap, in the order of the file.
+var types = []string{"commit", "tree", "blob", "tag"}
+
+// Index is an opened bitmap file of a multi-pack-index: a bitmap of the objects of each type,
+// and of the objects reachable from each selected commit.
+type Index struct {
+	Path string
+
+	midx    *pack.MultiPackIndex
+	types   map[string]*Bitmap
+	commits map[oid.ObjectID]*Bitmap
+}
+
+// Open reads the bitmap file of a multi-pack-index.
+func Open(path string, midx *pack.MultiPackIndex) (*Index, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	hashSize := len(midx.Checksum.Bytes())
+	if len(data) < headerSize+2*hashSize || !bytes.Equal(data[:4], signature) || binary.BigEndian.Uint16(data[4:]) != version {
+		return nil, fmt.Errorf("invalid bitmap file %s", path)
+	}
+	if binary.BigEndian.Uint16(data[6:])&optionFullDAG == 0 {
+		return nil, fmt.Errorf("bitmap file %s does not hold full reachability", path)
+	}
+	count := int(binary.BigEndian.Uint32(data[8:]))
+	if !bytes.Equal(data[headerSize:headerSize+hashSize], midx.Checksum.Bytes()) {
+		return nil, fmt.Errorf("bitmap file %s does not match the multi-pack-index", path)
+	}
+
+	index := &Index{
+		Path:    path,
+		midx:    midx,
+		types:   make(map[string]*Bitmap, len(types)),
+		commits: make(map[oid.ObjectID]*Bitmap, count),
+	}
+
+	at := headerSize + hashSize
+	body := data[:len(data)-hashSize]
+	for _, format := range types {
+		bitmap, n, err := decodeEWAH(body[at:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid bitmap file %s: %s bitmap: %w", path, format, err)
+		}
+		index.types[format] = bitmap
+		at += n
+	}
+
+	// Each entry may be stored xored with one of the entries before it
+	entries := make([]*Bitmap, 0, count)
+	for i := 0; i < count; i++ {
+		if at+6 > len(body) {
+			return nil, fmt.Errorf("invalid bitmap file %s: truncated entry", path)
+		}
+		position := int(binary.BigEndian.Uint32(body[at:]))
+		xorOffset := int(body[at+4])
+		at += 6
+
+		bitmap, n, err := decodeEWAH(body[at:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid bitmap file %s: entry %d: %w", path, i, err)
+		}
+		at += n
+
+		if xorOffset > i || position >= len(midx.IDs()) {
+			return nil, fmt.Errorf("invalid bitmap file %s: entry %d", path, i)
+		}
+		if xorOffset > 0 {
+			bitmap.xor(entries[i-xorOffset])
+		}
+		entries = append(entries, bitmap)
+		index.commits[midx.IDs()[position]] = bitmap
+	}
+
+	return index, nil
+}
+
+// Commit returns the objects reachable from a commit, reporting whether it has a bitmap. The
+// bitmap must not be modified.
+func (index *Index) Commit(id oid.ObjectID) (*Bitmap, bool) {
+	bitmap, ok := index.commits[id]
+	return bitmap, ok
+}
+
+// Commits returns the number of commits with a bitmap.
+func (index *Index) Commits() int {
+	return len(index.commits)
+}
+
+// Type returns the objects of a type ("commit", "tree", "blob" or "tag"). The bitmap must not
+// be modified.
+func (index *Index) Type(format string) *Bitmap {
+	if bitmap, ok := index.types[format]; ok {
+		return bitmap
+	}
+	return New()
+}
+
+// openedIndex is a cached Index, valid while its file is unchanged.
+type openedIndex struct {
+	modTime time.Time
+	size    int64
+	index   *Index
+}
+
+// opened caches the indexes read by Load, keyed by their path.
+var opened sync.Map
+
+// Load returns the bitmaps of the multi-pack-index of a .orf directory, or ErrNotFound if the
+// index or its bitmaps are missing or not usable. The file is only read again when it changes.
+func Load(directory string) (*Index, error) {
+	midx := pack.UsableMultiPackIndex(directory)
+	if midx == nil {
+		return nil, ErrNotFound
+	}
+
+	path := midx.BitmapPath()
+	info, err := os.Stat(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	if cached, ok := opened.Load(path); ok {
+		cached := cached.(*openedIndex)
+		if cached.modTime.Equal(info.ModTime()) && cached.size == info.Size() {
+			return cached.index, nil
+		}
+	}
+
+	index, err := Open(path, midx)
+	if err != nil {
+		return nil, err
+	}
+	opened.Store(path, &openedIndex{modTime: info.ModTime(), size: info.Size(), index: index})
+	return index, nil
+}
+
+// entry is a commit bitmap to write: the commit's position in the sorted ids of the
+// multi-pack-index and its bitmap.
+type entry struct {
+	position int
+	bitmap   *Bitmap
+}
+
+// writeFile writes the bitmap file of a multi-pack-index under a temporary name and renames
+// it into place.
+func writeFile(directory string, midx *pack.MultiPackIndex, typeBitmaps map[string]*Bitmap, entries []entry) error {
+	settings := repository.DirectorySettings(directory)
+	size := len(midx.IDs())
+
+	file, err := os.CreateTemp(filepath.Dir(midx.Path), "tmp_bitmap_")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	hasher := settings.Format.New()
+	buffered := bufio.NewWriter(file)
+	write := func(data []byte) {
+		// Errors stick to the buffered writer and are returned by Flush
+		buffered.Write(data)
+		hasher.Write(data)
+	}
+
+	header := make([]byte, headerSize)
+	copy(header, signature)
+	binary.BigEndian.PutUint16(header[4:], version)
+	binary.BigEndian.PutUint16(header[6:], optionFullDAG)
+	binary.BigEndian.PutUint32(header[8:], uint32(len(entries)))
+	write(header)
+	write(midx.Checksum.Bytes())
+
+	for _, format := range types {
+		bitmap, ok := typeBitmaps[format]
+		if !ok {
+			bitmap = New()
+		}
+		write(bitmap.encodeEWAH(size))
+	}
+	for _, e := range entries {
+		write(binary.BigEndian.AppendUint32(nil, uint32(e.position)))
+		write([]byte{0, 0}) // Neither xored with another entry, nor flagged
+		write(e.bitmap.encodeEWAH(size))
+	}
+
+	buffered.Write(hasher.Sum(nil))
+	if err := buffered.Flush(); err != nil {
+		return err
+	}
+
+	fsync := settings.Fsync&repository.FsyncPackMetadata != 0
+	if fsync {
+		if err := file.Sync(); err != nil {
+			return err
+		}
+	}
+	if err := file.Chmod(0644); err != nil {
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), midx.BitmapPath())
+}
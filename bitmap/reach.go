@@ -0,0 +1,369 @@
+package bitmap
+
+import (
+	"errors"
+	"fmt"
+	"orf/object"
+	"orf/oid"
+	"orf/pack"
+	"orf/repository"
+	"os"
+)
+
+// selectEvery is how often a commit of the history gets a bitmap, besides the tips.
+const selectEvery = 100
+
+// walker finds reachable objects. Objects of the multi-pack-index are recorded in bits, and
+// the walk stops at commits with a bitmap, taking their objects from it; other objects are
+// recorded in others, in the order they are found.
+type walker struct {
+	repo    *repository.Repo
+	midx    *pack.MultiPackIndex
+	bitmaps func(id oid.ObjectID) (*Bitmap, bool)
+
+	bits    *Bitmap
+	seen    map[oid.ObjectID]bool
+	others  []oid.ObjectID
+	pending []pending
+}
+
+// pending is an object to read, with the type it must have ("" if unknown).
+type pending struct {
+	id     oid.ObjectID
+	format string
+}
+
+// newWalker returns a walker over a repository, using its bitmaps unless pack.useBitmaps is
+// false.
+func newWalker(repo *repository.Repo) *walker {
+	if repository.DirectorySettings(repo.Directory).UseBitmaps {
+		if index, err := Load(repo.Directory); err == nil {
+			return walkerOver(repo, index.midx, index.Commit)
+		}
+	}
+	return walkerOver(repo, pack.UsableMultiPackIndex(repo.Directory), noBitmaps)
+}
+
+// walkerOver returns a walker over the objects of a multi-pack-index (which may be nil) that
+// takes the objects reachable from commits from bitmaps.
+func walkerOver(repo *repository.Repo, midx *pack.MultiPackIndex, bitmaps func(oid.ObjectID) (*Bitmap, bool)) *walker {
+	return &walker{
+		repo:    repo,
+		midx:    midx,
+		bitmaps: bitmaps,
+		bits:    New(),
+		seen:    make(map[oid.ObjectID]bool),
+	}
+}
+
+func noBitmaps(oid.ObjectID) (*Bitmap, bool) {
+	return nil, false
+}
+
+// visit records an object, and queues it to be read unless it is a blob or its objects come
+// from a bitmap.
+func (w *walker) visit(id oid.ObjectID, format string) {
+	if w.midx != nil {
+		if position, ok := w.midx.Position(id); ok {
+			if w.bits.Has(position) {
+				return
+			}
+			if format == "" || format == "commit" {
+				if bitmap, ok := w.bitmaps(id); ok {
+					w.bits.Or(bitmap)
+					return
+				}
+			}
+			w.bits.Set(position)
+			if format != "blob" {
+				w.pending = append(w.pending, pending{id: id, format: format})
+			}
+			return
+		}
+	}
+
+	if w.seen[id] {
+		return
+	}
+	w.seen[id] = true
+	w.others = append(w.others, id)
+	if format != "blob" {
+		w.pending = append(w.pending, pending{id: id, format: format})
+	}
+}
+
+// run reads the queued objects and visits the objects they link to. Missing objects are
+// recorded but not followed.
+func (w *walker) run() error {
+	for len(w.pending) > 0 {
+		next := w.pending[len(w.pending)-1]
+		w.pending = w.pending[:len(w.pending)-1]
+
+		obj, err := object.ReadObject(w.repo.Directory, next.id)
+		if errors.Is(err, object.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		switch o := obj.(type) {
+		case *object.Commit:
+			w.visit(o.TreeHash(), "tree")
+			for _, parent := range o.Parents() {
+				w.visit(parent, "commit")
+			}
+		case *object.Tree:
+			for _, leaf := range o.Leaves {
+				switch {
+				case leaf.IsTree():
+					w.visit(leaf.Hash, "tree")
+				case string(leaf.Mode) != "160000":
+					w.visit(leaf.Hash, "blob")
+				}
+			}
+		case *object.Tag:
+			w.visit(o.Target(), o.TargetType())
+		}
+	}
+	return nil
+}
+
+// ids returns the objects found: those of the multi-pack-index in pseudo-pack order, then the
+// others.
+func (w *walker) ids() []oid.ObjectID {
+	ids := make([]oid.ObjectID, 0, w.bits.Count()+len(w.others))
+	w.bits.Each(func(position int) {
+		ids = append(ids, w.midx.ObjectAt(position))
+	})
+	return append(ids, w.others...)
+}
+
+// Reachable returns every object reachable from roots, including those that are referenced
+// but missing: the objects of the multi-pack-index in pseudo-pack order, then the others in
+// the order they were found. Commits with a bitmap are not walked.
+func Reachable(repo *repository.Repo, roots []object.Root) ([]oid.ObjectID, error) {
+	w := newWalker(repo)
+	for _, root := range roots {
+		w.visit(root.ID, root.Type)
+	}
+	if err := w.run(); err != nil {
+		return nil, err
+	}
+	return w.ids(), nil
+}
+
+// Objects returns the objects reachable from include but not from exclude, as sending the
+// included commits to a repository holding the excluded ones requires.
+func Objects(repo *repository.Repo, include, exclude []oid.ObjectID) ([]oid.ObjectID, error) {
+	excluded := newWalker(repo)
+	for _, id := range exclude {
+		excluded.visit(id, "")
+	}
+	if err := excluded.run(); err != nil {
+		return nil, err
+	}
+
+	included := newWalker(repo)
+	for _, id := range include {
+		included.visit(id, "")
+	}
+	if err := included.run(); err != nil {
+		return nil, err
+	}
+
+	included.bits.AndNot(excluded.bits)
+	others := included.others[:0]
+	for _, id := range included.others {
+		if !excluded.seen[id] {
+			others = append(others, id)
+		}
+	}
+	included.others = others
+	return included.ids(), nil
+}
+
+// Write writes bitmaps for the multi-pack-index of a repository, replacing any it has, and
+// returns the number of commits given one: every commit a ref, reflog or HEAD-like file points
+// to and every 100th commit of their history. Commits reaching objects outside the index get
+// none. It returns pack.ErrNoMultiPackIndex without a usable multi-pack-index.
+func Write(repo *repository.Repo) (int, error) {
+	midx := pack.UsableMultiPackIndex(repo.Directory)
+	if midx == nil {
+		return 0, pack.ErrNoMultiPackIndex
+	}
+
+	formats, err := midx.Types()
+	if err != nil {
+		return 0, err
+	}
+	typeBitmaps := make(map[string]*Bitmap, len(types))
+	for position, format := range formats {
+		if typeBitmaps[format] == nil {
+			typeBitmaps[format] = New()
+		}
+		typeBitmaps[format].Set(position)
+	}
+
+	tips, err := tips(repo)
+	if err != nil {
+		return 0, err
+	}
+	commits, err := topological(repo, midx, tips)
+	if err != nil {
+		return 0, err
+	}
+
+	isTip := make(map[oid.ObjectID]bool, len(tips))
+	for _, tip := range tips {
+		isTip[tip] = true
+	}
+
+	computed := make(map[oid.ObjectID]*Bitmap)
+	var entries []entry
+	for i, id := range commits {
+		if !isTip[id] && i%selectEvery != selectEvery-1 {
+			continue
+		}
+
+		w := walkerOver(repo, midx, func(id oid.ObjectID) (*Bitmap, bool) {
+			bitmap, ok := computed[id]
+			return bitmap, ok
+		})
+		w.visit(id, "commit")
+		if err := w.run(); err != nil {
+			return 0, err
+		}
+		if len(w.others) > 0 {
+			continue
+		}
+
+		position, _ := midx.IndexPosition(id)
+		computed[id] = w.bits
+		entries = append(entries, entry{position: position, bitmap: w.bits})
+	}
+
+	if err := writeFile(repo.Directory, midx, typeBitmaps, entries); err != nil {
+		return 0, err
+	}
+	return len(entries), nil
+}
+
+// tips returns the commits the roots of a repository point to, peeling tags.
+func tips(repo *repository.Repo) ([]oid.ObjectID, error) {
+	roots, err := object.ListRoots(repo)
+	if err != nil {
+		return nil, err
+	}
+
+	var tips []oid.ObjectID
+	for _, root := range roots {
+		if root.Type == "blob" {
+			continue
+		}
+
+		id := root.ID
+		for {
+			obj, err := object.ReadObject(repo.Directory, id)
+			if err != nil {
+				break
+			}
+			if tag, ok := obj.(*object.Tag); ok {
+				id = tag.Target()
+				continue
+			}
+			if _, ok := obj.(*object.Commit); ok {
+				tips = append(tips, id)
+			}
+			break
+		}
+	}
+	return tips, nil
+}
+
+// topological returns the commits of the multi-pack-index reachable from tips, parents first.
+// The history stops at commits outside the index.
+func topological(repo *repository.Repo, midx *pack.MultiPackIndex, tips []oid.ObjectID) ([]oid.ObjectID, error) {
+	var order []oid.ObjectID
+	done := make(map[oid.ObjectID]bool)
+	parents := make(map[oid.ObjectID][]oid.ObjectID)
+
+	for _, tip := range tips {
+		stack := []oid.ObjectID{tip}
+		for len(stack) > 0 {
+			id := stack[len(stack)-1]
+			if done[id] || !midx.Contains(id) {
+				stack = stack[:len(stack)-1]
+				continue
+			}
+
+			if _, ok := parents[id]; !ok {
+				commit, err := object.ReadCommit(repo, id)
+				if err != nil {
+					return nil, err
+				}
+				parents[id] = append([]oid.ObjectID{}, commit.Parents()...)
+			}
+
+			pushed := false
+			for _, parent := range parents[id] {
+				if !done[parent] && midx.Contains(parent) {
+					stack = append(stack, parent)
+					pushed = true
+				}
+			}
+			if pushed {
+				continue
+			}
+
+			stack = stack[:len(stack)-1]
+			done[id] = true
+			order = append(order, id)
+		}
+	}
+	return order, nil
+}
+
+// Verify checks the bitmaps of a repository: the checksum of the file, the type of every
+// object, and that each commit bitmap holds exactly the objects reachable from the commit.
+// It returns ErrNotFound without bitmaps.
+func Verify(repo *repository.Repo) error {
+	index, err := Load(repo.Directory)
+	if err != nil {
+		return err
+	}
+
+	data, err := os.ReadFile(index.Path)
+	if err != nil {
+		return err
+	}
+	algorithm := repo.ObjectFormat()
+	hashSize := algorithm.Size()
+	if sum := algorithm.Sum(data[:len(data)-hashSize]); string(sum.Bytes()) != string(data[len(data)-hashSize:]) {
+		return fmt.Errorf("bitmap file %s does not match its checksum", index.Path)
+	}
+
+	formats, err := index.midx.Types()
+	if err != nil {
+		return err
+	}
+	for position, format := range formats {
+		for _, other := range types {
+			if index.Type(other).Has(position) != (other == format) {
+				return fmt.Errorf("bitmaps have the wrong type for %s, a %s", index.midx.ObjectAt(position), format)
+			}
+		}
+	}
+
+	for id, bitmap := range index.commits {
+		w := walkerOver(repo, index.midx, noBitmaps)
+		w.visit(id, "commit")
+		if err := w.run(); err != nil {
+			return err
+		}
+		if len(w.others) > 0 || !w.bits.Equal(bitmap) {
+			return fmt.Errorf("bitmap of %s does not match the objects it reaches", id)
+		}
+	}
+	return nil
+}
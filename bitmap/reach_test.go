@@ -0,0 +1,259 @@
+package bitmap
+
+import (
+	"errors"
+	"fmt"
+	"orf/kv"
+	"orf/object"
+	"orf/oid"
+	"orf/pack"
+	"orf/repository"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func createTestRepo(t *testing.T) *repository.Repo {
+	workTree := t.TempDir()
+	directory := filepath.Join(workTree, ".orf")
+
+	for _, dir := range []string{"objects", filepath.Join("refs", "heads"), filepath.Join("refs", "tags")} {
+		if err := os.MkdirAll(filepath.Join(directory, dir), os.ModePerm); err != nil {
+			t.Fatalf("Failed to create directories: %v", err)
+		}
+	}
+
+	return &repository.Repo{WorkTree: workTree, Directory: directory}
+}
+
+// writeTestCommit writes a commit of a tree holding one file, and returns it with every
+// object written.
+func writeTestCommit(t *testing.T, repo *repository.Repo, contents string, parents []oid.ObjectID) (oid.ObjectID, []oid.ObjectID) {
+	blob, err := object.WriteObject(repo.Directory, object.CreateBlob([]byte(contents)))
+	if err != nil {
+		t.Fatalf("Failed to write blob: %v", err)
+	}
+
+	tree := object.CreateTree(nil)
+	tree.Leaves = append(tree.Leaves, &object.Leaf{Mode: []byte("100644"), Path: "file.txt", Hash: blob})
+	data, err := tree.Serialize()
+	if err != nil {
+		t.Fatalf("Failed to serialize tree: %v", err)
+	}
+	treeHash, err := object.WriteObject(repo.Directory, object.CreateTree(data))
+	if err != nil {
+		t.Fatalf("Failed to write tree: %v", err)
+	}
+
+	kvData := kv.CreateOrderedMap()
+	kvData.Add("tree", treeHash.String())
+	for _, parent := range parents {
+		kvData.Add("parent", parent.String())
+	}
+	kvData.Add("author", "Test <test@example.com> 1700000000 +0000")
+	kvData.Add("committer", "Test <test@example.com> 1700000000 +0000")
+	kvData.Add("message", contents)
+
+	hash, err := object.WriteObject(repo.Directory, object.CreateCommit(kv.Serialize(kvData)))
+	if err != nil {
+		t.Fatalf("Failed to write commit: %v", err)
+	}
+	return hash, []oid.ObjectID{blob, treeHash, hash}
+}
+
+func writeTestRef(t *testing.T, repo *repository.Repo, name string, hash oid.ObjectID) {
+	if err := os.WriteFile(filepath.Join(repo.Directory, filepath.FromSlash(name)), []byte(hash.String()+"\n"), 0644); err != nil {
+		t.Fatalf("Failed to write ref: %v", err)
+	}
+}
+
+// writeTestHistory writes a line of commits on master and packs them under a multi-pack-index.
+func writeTestHistory(t *testing.T, repo *repository.Repo, count int) []oid.ObjectID {
+	var commits, ids []oid.ObjectID
+	for i := 0; i < count; i++ {
+		var parents []oid.ObjectID
+		if i > 0 {
+			parents = commits[i-1:]
+		}
+		commit, written := writeTestCommit(t, repo, fmt.Sprintf("commit %d", i), parents)
+		commits = append(commits, commit)
+		ids = append(ids, written...)
+	}
+	writeTestRef(t, repo, "refs/heads/master", commits[count-1])
+
+	_, err := pack.Write(repo.Directory, repo.ObjectFormat(), ids, func(id oid.ObjectID) (string, []byte, error) {
+		obj, err := object.ReadObject(repo.Directory, id)
+		if err != nil {
+			return "", nil, err
+		}
+		return obj.GetFormat(), obj.GetData(), nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to write pack: %v", err)
+	}
+	if _, err := pack.WriteMultiPackIndex(repo.Directory); err != nil {
+		t.Fatalf("Failed to write multi-pack-index: %v", err)
+	}
+	return commits
+}
+
+// walk returns the objects reachable from a commit without using bitmaps.
+func walk(t *testing.T, repo *repository.Repo, id oid.ObjectID) map[oid.ObjectID]bool {
+	w := walkerOver(repo, nil, noBitmaps)
+	w.visit(id, "commit")
+	if err := w.run(); err != nil {
+		t.Fatalf("Walk failed: %v", err)
+	}
+	return toSet(w.ids())
+}
+
+func toSet(ids []oid.ObjectID) map[oid.ObjectID]bool {
+	set := make(map[oid.ObjectID]bool, len(ids))
+	for _, id := range ids {
+		set[id] = true
+	}
+	return set
+}
+
+func sameSet(a, b map[oid.ObjectID]bool) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for id := range a {
+		if !b[id] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestWrite(t *testing.T) {
+	repo := createTestRepo(t)
+	commits := writeTestHistory(t, repo, 250)
+
+	count, err := Write(repo)
+	if err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	// The tip, and the 100th and 200th commits
+	if count != 3 {
+		t.Errorf("Expected 3 commits with a bitmap, got %d", count)
+	}
+
+	index, err := Load(repo.Directory)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	for _, id := range []oid.ObjectID{commits[99], commits[199], commits[249]} {
+		if _, ok := index.Commit(id); !ok {
+			t.Errorf("Expected a bitmap for %s", id)
+		}
+	}
+	if index.Type("commit").Count() != 250 || index.Type("blob").Count() != 250 || index.Type("tag").Count() != 0 {
+		t.Errorf("Unexpected type bitmaps")
+	}
+	if err := Verify(repo); err != nil {
+		t.Errorf("Verify failed: %v", err)
+	}
+
+	// Objects written since are found by walking
+	next, written := writeTestCommit(t, repo, "loose", commits[249:])
+	writeTestRef(t, repo, "refs/heads/master", next)
+	reachable, err := Reachable(repo, []object.Root{{ID: next, Type: "commit"}})
+	if err != nil {
+		t.Fatalf("Reachable failed: %v", err)
+	}
+	if expected := walk(t, repo, next); !sameSet(toSet(reachable), expected) || len(reachable) != len(expected) {
+		t.Errorf("Expected %d objects reachable, got %d", len(expected), len(reachable))
+	}
+	for i, id := range reachable[len(reachable)-len(written):] {
+		if !toSet(written)[id] {
+			t.Errorf("Expected the loose objects last, got %s at %d", id, i)
+		}
+	}
+}
+
+func TestObjects(t *testing.T) {
+	repo := createTestRepo(t)
+	commits := writeTestHistory(t, repo, 120)
+	if _, err := Write(repo); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	objects, err := Objects(repo, commits[119:], commits[50:51])
+	if err != nil {
+		t.Fatalf("Objects failed: %v", err)
+	}
+
+	expected := walk(t, repo, commits[119])
+	for id := range walk(t, repo, commits[50]) {
+		delete(expected, id)
+	}
+	if !sameSet(toSet(objects), expected) {
+		t.Errorf("Expected %d objects, got %d", len(expected), len(objects))
+	}
+	if len(objects) != 69*3 {
+		t.Errorf("Expected the objects of 69 commits, got %d", len(objects))
+	}
+}
+
+func TestVerifyDetectsWrongBitmap(t *testing.T) {
+	repo := createTestRepo(t)
+	commits := writeTestHistory(t, repo, 3)
+
+	midx := pack.UsableMultiPackIndex(repo.Directory)
+	position, _ := midx.IndexPosition(commits[2])
+	wrong := New()
+	tip, _ := midx.Position(commits[2])
+	wrong.Set(tip)
+	if err := writeFile(repo.Directory, midx, nil, []entry{{position: position, bitmap: wrong}}); err != nil {
+		t.Fatalf("writeFile failed: %v", err)
+	}
+
+	if err := Verify(repo); err == nil {
+		t.Errorf("Expected Verify to detect the wrong bitmaps")
+	}
+
+	// Reachability trusts the bitmap, which is why fsck verifies it
+	reachable, err := Reachable(repo, []object.Root{{ID: commits[2], Type: "commit"}})
+	if err != nil || len(reachable) != 1 {
+		t.Errorf("Expected only the tip from its bitmap, got %v (%v)", reachable, err)
+	}
+}
+
+func TestBitmapsDisabled(t *testing.T) {
+	repo := createTestRepo(t)
+	commits := writeTestHistory(t, repo, 3)
+	if _, err := Write(repo); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	index, err := Load(repo.Directory)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	midx := pack.UsableMultiPackIndex(repo.Directory)
+	position, _ := midx.IndexPosition(commits[2])
+	bitmap, _ := index.Commit(commits[2])
+	tip, _ := midx.Position(commits[2])
+	wrong := New()
+	wrong.Set(tip)
+	if err := writeFile(repo.Directory, midx, nil, []entry{{position: position, bitmap: wrong}}); err != nil {
+		t.Fatalf("writeFile failed: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(repo.Directory, "config"), []byte("[pack]\n\tuseBitmaps = false\n"), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+
+	reachable, err := Reachable(repo, []object.Root{{ID: commits[2], Type: "commit"}})
+	if err != nil || len(reachable) != bitmap.Count() {
+		t.Errorf("Expected the bitmaps to be ignored, got %d objects (%v)", len(reachable), err)
+	}
+
+	if err := os.Remove(midx.BitmapPath()); err != nil {
+		t.Fatalf("Failed to remove bitmaps: %v", err)
+	}
+	if _, err := Load(repo.Directory); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+}
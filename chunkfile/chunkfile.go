@@ -0,0 +1,62 @@
+// Package chunkfile reads and writes the table of contents shared by git's chunked file
+// formats, such as the commit-graph and the multi-pack-index.
+package chunkfile
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Chunk is a section of a chunked file: a 4 byte id and its data.
+type Chunk struct {
+	ID   uint32
+	Data []byte
+}
+
+// Read reads a table of count chunks starting at offset: one id and 8 byte offset per chunk,
+// and a final entry with id 0 holding the offset where the last chunk ends.
+func Read(data []byte, offset int, count int) (map[uint32][]byte, error) {
+	tableEnd := offset + (count+1)*12
+	if len(data) < tableEnd {
+		return nil, fmt.Errorf("truncated chunk table")
+	}
+
+	chunks := make(map[uint32][]byte, count)
+	for i := 0; i < count; i++ {
+		entry := data[offset+i*12:]
+		id := binary.BigEndian.Uint32(entry)
+		start := binary.BigEndian.Uint64(entry[4:])
+		end := binary.BigEndian.Uint64(entry[16:])
+
+		if id == 0 || start < uint64(tableEnd) || end < start || end > uint64(len(data)) {
+			return nil, fmt.Errorf("invalid chunk %08x", id)
+		}
+		chunks[id] = data[start:end]
+	}
+
+	return chunks, nil
+}
+
+// Write writes the table of chunks, whose data starts right after it at offset, then the data
+// of every chunk.
+func Write(out io.Writer, offset int, chunks []Chunk) error {
+	position := uint64(offset + (len(chunks)+1)*12)
+	entry := make([]byte, 12)
+
+	for _, c := range append(chunks, Chunk{}) {
+		binary.BigEndian.PutUint32(entry, c.ID)
+		binary.BigEndian.PutUint64(entry[4:], position)
+		if _, err := out.Write(entry); err != nil {
+			return err
+		}
+		position += uint64(len(c.Data))
+	}
+
+	for _, c := range chunks {
+		if _, err := out.Write(c.Data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
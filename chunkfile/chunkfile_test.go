@@ -0,0 +1,49 @@
+package chunkfile
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestWriteAndRead(t *testing.T) {
+	header := []byte("HEAD")
+	chunks := []Chunk{
+		{ID: 0x41414141, Data: []byte("first")},
+		{ID: 0x42424242, Data: nil},
+		{ID: 0x43434343, Data: []byte("third chunk")},
+	}
+
+	var out bytes.Buffer
+	out.Write(header)
+	if err := Write(&out, len(header), chunks); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	read, err := Read(out.Bytes(), len(header), len(chunks))
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	for _, c := range chunks {
+		if data, ok := read[c.ID]; !ok || !bytes.Equal(data, c.Data) {
+			t.Errorf("Expected chunk %08x to hold %q, got %q", c.ID, c.Data, data)
+		}
+	}
+}
+
+func TestReadInvalid(t *testing.T) {
+	var out bytes.Buffer
+	if err := Write(&out, 0, []Chunk{{ID: 1, Data: []byte("data")}}); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	data := out.Bytes()
+
+	if _, err := Read(data[:20], 0, 1); err == nil {
+		t.Errorf("Expected a truncated table to be rejected")
+	}
+	if _, err := Read(data[:len(data)-1], 0, 1); err == nil {
+		t.Errorf("Expected a chunk running past the end to be rejected")
+	}
+	if _, err := Read(data, 0, 2); err == nil {
+		t.Errorf("Expected a table of more chunks than written to be rejected")
+	}
+}
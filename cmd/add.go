@@ -12,7 +12,7 @@ import (
 
 func Add(paths []string) error {
 	// Find the repository
-	repo, err := repository.FindRepo(".", false)
+	repo, err := findRepo()
 	if err != nil {
 		return err
 	}
@@ -1,21 +1,118 @@
 package cmd
 
 import (
+	"bufio"
+	"errors"
 	"fmt"
+	"io"
 	"orf/object"
-	"orf/repository"
+	"strings"
 )
 
 func CatObject(hash string, format string) (object.Object, error) {
-	repo, err := repository.FindRepo(".", true)
+	repo, err := findRepo()
 	if err != nil {
 		return nil, fmt.Errorf("error finding repo: %v", err)
 	}
 
-	newObject, err := object.ReadObject(repo.Directory, object.FindObject(repo, hash, format, false))
+	sha, err := object.FindObject(repo, hash, format, false)
+	if err != nil {
+		return nil, err
+	}
+
+	newObject, err := object.ReadObject(repo.Directory, sha)
 	if err != nil {
 		return nil, fmt.Errorf("error reading object: %v", err)
 	}
 
 	return newObject, nil
 }
+
+// CatInfo prints the type (-t), size (-s) or pretty-printed contents (-p) of an object, whose
+// type need not be known: trees are listed like ls-tree, other objects printed as they are.
+func CatInfo(name string, mode string) error {
+	repo, err := findRepo()
+	if err != nil {
+		return fmt.Errorf("error finding repo: %w", err)
+	}
+
+	hash, err := object.FindObject(repo, name, "", false)
+	if err != nil {
+		return err
+	}
+
+	obj, err := object.ReadObject(repo.Directory, hash)
+	if err != nil {
+		return fmt.Errorf("error reading object: %w", err)
+	}
+
+	switch mode {
+	case "type":
+		fmt.Println(obj.GetFormat())
+	case "size":
+		fmt.Println(len(obj.GetData()))
+	default:
+		if tree, ok := obj.(*object.Tree); ok {
+			for _, leaf := range tree.Leaves {
+				fmt.Println(formatLeaf(leaf, leaf.Path))
+			}
+		} else {
+			fmt.Print(string(obj.GetData()))
+		}
+	}
+
+	return nil
+}
+
+// CatBatch reads object names from in, one per line, and writes to out an "<id> <type> <size>"
+// header for each, followed by the object's contents and a newline when contents is set.
+// Names that resolve to no object get "<name> missing", and names matching several objects
+// "<name> ambiguous". Each object is flushed as soon as it is written, so callers can send a
+// name and wait for its answer.
+func CatBatch(in io.Reader, out io.Writer, contents bool) error {
+	repo, err := findRepo()
+	if err != nil {
+		return fmt.Errorf("error finding repo: %w", err)
+	}
+
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	writer := bufio.NewWriter(out)
+
+	for scanner.Scan() {
+		name := strings.TrimSpace(scanner.Text())
+		if name == "" {
+			continue
+		}
+
+		hash, err := object.FindObject(repo, name, "", false)
+		var obj object.Object
+		if err == nil {
+			obj, err = object.ReadObject(repo.Directory, hash)
+		}
+
+		var ambiguousErr *object.AmbiguousError
+		switch {
+		case errors.As(err, &ambiguousErr):
+			fmt.Fprintf(writer, "%s ambiguous\n", name)
+		case errors.Is(err, object.ErrNotFound):
+			fmt.Fprintf(writer, "%s missing\n", name)
+		case err != nil:
+			writer.Flush()
+			return fmt.Errorf("error reading %s: %w", name, err)
+		default:
+			data := obj.GetData()
+			fmt.Fprintf(writer, "%s %s %d\n", hash, obj.GetFormat(), len(data))
+			if contents {
+				writer.Write(data)
+				writer.WriteByte('\n')
+			}
+		}
+
+		if err := writer.Flush(); err != nil {
+			return err
+		}
+	}
+
+	return scanner.Err()
+}
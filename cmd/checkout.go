@@ -2,6 +2,8 @@ package cmd
 
 import (
 	"fmt"
+	"orf/index"
+	"orf/merge"
 	"orf/object"
 	"orf/repository"
 	"os"
@@ -10,7 +12,7 @@ import (
 
 // Checkout checks out a commit into the specified path.
 func Checkout(hash string, path string) error {
-	repo, err := repository.FindRepo(".", true)
+	repo, err := findRepo()
 	if err != nil {
 		return fmt.Errorf("error finding repo: %w", err)
 	}
@@ -44,7 +46,12 @@ func Checkout(hash string, path string) error {
 
 // getObject reads the object by its hash, returning either a Commit object or a base.
 func getObject(repo *repository.Repo, hash string) (interface{}, error) {
-	obj, err := object.ReadObject(repo.Directory, object.FindObject(repo, hash, "commit", false))
+	sha, err := object.FindObject(repo, hash, "", false)
+	if err != nil {
+		return nil, err
+	}
+
+	obj, err := object.ReadObject(repo.Directory, sha)
 	if err != nil {
 		return nil, err
 	}
@@ -93,16 +100,9 @@ func prepareDirectory(path string) error {
 // getTreeFromCommit retrieves the tree object associated with a commit.
 func getTreeFromCommit(commit *object.Commit, repo *repository.Repo) (*object.Tree, error) {
 
-	tree, _ := commit.GetKVData().Get("tree")
-
-	var treeHash string
-	switch v := tree.(type) {
-	case string:
-		treeHash = v
-	case []byte:
-		treeHash = string(v)
-	default:
-		return nil, fmt.Errorf("unexpected type %T for 'tree'", v)
+	treeHash := commit.TreeHash()
+	if treeHash == "" {
+		return nil, fmt.Errorf("commit has no tree")
 	}
 
 	obj, err := object.ReadObject(repo.Directory, treeHash)
@@ -168,3 +168,54 @@ func writeData(dest string, data []byte) error {
 
 	return nil
 }
+
+// CheckoutStage resolves conflicted paths in the working tree to one side of the merge:
+// stage 2 (--ours) or stage 3 (--theirs). The index keeps its conflict entries until the
+// paths are added.
+func CheckoutStage(paths []string, stage uint16) error {
+	repo, err := findRepo()
+	if err != nil {
+		return fmt.Errorf("error finding repo: %w", err)
+	}
+
+	idx, err := index.ReadIndex(repo)
+	if err != nil {
+		return err
+	}
+
+	conflicts := make(map[string]merge.Conflict)
+	for _, conflict := range unmergedPaths(idx) {
+		conflicts[conflict.Path] = conflict
+	}
+
+	side := "our"
+	if stage == 3 {
+		side = "their"
+	}
+
+	for _, path := range paths {
+		relpath, err := worktreePath(repo, path)
+		if err != nil {
+			return err
+		}
+
+		conflict, ok := conflicts[relpath]
+		if !ok {
+			return fmt.Errorf("path %s is not unmerged", path)
+		}
+
+		leaf := conflict.Ours
+		if stage == 3 {
+			leaf = conflict.Theirs
+		}
+		if leaf == nil {
+			return fmt.Errorf("path %s does not have %s version", path, side)
+		}
+
+		if err := writeLeaf(repo, relpath, leaf); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,410 @@
+package cmd
+
+import (
+	"fmt"
+	"orf/index"
+	"orf/merge"
+	"orf/object"
+	"orf/oid"
+	"orf/repository"
+	"orf/revwalk"
+	"orf/sequencer"
+	"strings"
+	"time"
+)
+
+// CherryPick applies the changes introduced by each commit onto HEAD, one commit each.
+// Revisions may be commits or ranges such as A..B, which are picked oldest first.
+func CherryPick(revisions []string, opts sequencer.Options) error {
+	return startSequence(sequencer.Pick, revisions, opts)
+}
+
+// Revert applies the reverse of the changes introduced by each commit onto HEAD, one commit
+// each.
+func Revert(revisions []string, opts sequencer.Options) error {
+	return startSequence(sequencer.Revert, revisions, opts)
+}
+
+// SequencerContinue commits the resolved conflicts of the current cherry-pick or revert
+// and goes on with the remaining commits.
+func SequencerContinue() error {
+	repo, err := findRepo()
+	if err != nil {
+		return fmt.Errorf("error finding repo: %w", err)
+	}
+
+	state, err := sequencer.Read(repo)
+	if err != nil {
+		return err
+	}
+
+	idx, err := index.ReadIndex(repo)
+	if err != nil {
+		return err
+	}
+	if conflicts := unmergedPaths(idx); len(conflicts) > 0 {
+		return fmt.Errorf("you need to resolve your current index first: %s is unmerged", conflicts[0].Path)
+	}
+
+	// Commit the resolution, unless it was already committed
+	pending, err := pendingStep(repo)
+	if err != nil {
+		return err
+	}
+	if pending != "" {
+		message, err := readStateFile(repo, mergeMsgFile)
+		if err != nil {
+			return err
+		}
+		if _, err := commit(repo, stripComments(message)); err != nil {
+			return err
+		}
+	}
+
+	return nextStep(repo, state)
+}
+
+// SequencerSkip drops the current commit of a cherry-pick or revert, discarding its changes,
+// and goes on with the remaining commits.
+func SequencerSkip() error {
+	repo, err := findRepo()
+	if err != nil {
+		return fmt.Errorf("error finding repo: %w", err)
+	}
+
+	state, err := sequencer.Read(repo)
+	if err != nil {
+		return err
+	}
+
+	if err := resetWorkTree(repo, "HEAD"); err != nil {
+		return err
+	}
+	if err := removeStateFiles(repo, cherryPickHeadFile, revertHeadFile, mergeMsgFile); err != nil {
+		return err
+	}
+
+	return nextStep(repo, state)
+}
+
+// SequencerAbort stops a cherry-pick or revert, returning HEAD, the index and the working
+// tree to where they were before it started.
+func SequencerAbort() error {
+	repo, err := findRepo()
+	if err != nil {
+		return fmt.Errorf("error finding repo: %w", err)
+	}
+
+	state, err := sequencer.Read(repo)
+	if err != nil {
+		return err
+	}
+
+	if err := resetWorkTree(repo, state.Head.String()); err != nil {
+		return err
+	}
+
+	head, err := object.FindObject(repo, "HEAD", "commit", true)
+	if err != nil {
+		return err
+	}
+	if head != state.Head {
+		config, err := readOrfConfig()
+		if err != nil {
+			return err
+		}
+		if err := object.UpdateRef(repo, "HEAD", state.Head, getUserOrfConfig(config), "reset: moving to "+state.Head.String()); err != nil {
+			return err
+		}
+	}
+
+	if err := removeStateFiles(repo, cherryPickHeadFile, revertHeadFile, mergeMsgFile); err != nil {
+		return err
+	}
+	return sequencer.Remove(repo)
+}
+
+// startSequence saves the steps for the given commits and runs them.
+func startSequence(action sequencer.Action, revisions []string, opts sequencer.Options) error {
+	repo, err := findRepo()
+	if err != nil {
+		return fmt.Errorf("error finding repo: %w", err)
+	}
+
+	if sequencer.InProgress(repo) {
+		return fmt.Errorf("a cherry-pick or revert is already in progress; use --continue, --skip or --abort")
+	}
+	if heads, err := readMergeHeads(repo); err != nil {
+		return err
+	} else if len(heads) > 0 {
+		return fmt.Errorf("you have not concluded your merge (MERGE_HEAD exists)")
+	}
+
+	head, err := object.FindObject(repo, "HEAD", "commit", true)
+	if err != nil {
+		return err
+	}
+	headLeaves, err := commitLeaves(repo, head)
+	if err != nil {
+		return err
+	}
+	idx, err := index.ReadIndex(repo)
+	if err != nil {
+		return err
+	}
+	if err := checkClean(repo, idx, headLeaves); err != nil {
+		return err
+	}
+
+	commits, err := sequenceCommits(repo, revisions)
+	if err != nil {
+		return err
+	}
+	if len(commits) == 0 {
+		return fmt.Errorf("empty commit set passed")
+	}
+
+	state := &sequencer.State{Head: head, Options: opts}
+	for _, hash := range commits {
+		c, err := object.ReadCommit(repo, hash)
+		if err != nil {
+			return err
+		}
+		state.Todo = append(state.Todo, sequencer.Step{Action: action, Commit: hash, Subject: firstLine(c.Message())})
+	}
+
+	if err := state.Write(repo); err != nil {
+		return err
+	}
+	return runSequence(repo, state)
+}
+
+// sequenceCommits resolves the revisions to commits. A list of single revisions is kept in
+// order; ranges are walked oldest first.
+func sequenceCommits(repo *repository.Repo, revisions []string) ([]oid.ObjectID, error) {
+	revRange, err := object.ParseRange(repo, revisions)
+	if err != nil {
+		return nil, err
+	}
+	if len(revRange.Exclude) == 0 && !revRange.Symmetric {
+		return revRange.Include, nil
+	}
+
+	opts := revwalk.DefaultOptions()
+	opts.Reverse = true
+	walker, err := revwalk.New(repo, revRange, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var commits []oid.ObjectID
+	err = walker.ForEach(func(hash oid.ObjectID, commit *object.Commit) error {
+		commits = append(commits, hash)
+		return nil
+	})
+	return commits, err
+}
+
+// nextStep drops the current step and runs the rest.
+func nextStep(repo *repository.Repo, state *sequencer.State) error {
+	if len(state.Todo) > 0 {
+		state.Todo = state.Todo[1:]
+	}
+	if err := state.Write(repo); err != nil {
+		return err
+	}
+	return runSequence(repo, state)
+}
+
+// runSequence applies the steps in order, saving the state after each. It stops at the
+// first step that fails, which stays first in the todo list.
+func runSequence(repo *repository.Repo, state *sequencer.State) error {
+	config, err := readOrfConfig()
+	if err != nil {
+		return err
+	}
+	identity := getUserOrfConfig(config)
+
+	for len(state.Todo) > 0 {
+		if err := applyStep(repo, state.Todo[0], state.Options, identity); err != nil {
+			return err
+		}
+
+		state.Todo = state.Todo[1:]
+		if err := state.Write(repo); err != nil {
+			return err
+		}
+	}
+
+	return sequencer.Remove(repo)
+}
+
+// applyStep merges the changes of one commit (or their reverse) into HEAD and commits the
+// result. On conflicts, the working tree and index are left to be resolved, with
+// CHERRY_PICK_HEAD or REVERT_HEAD and MERGE_MSG recording the step.
+func applyStep(repo *repository.Repo, step sequencer.Step, opts sequencer.Options, identity string) error {
+	head, err := object.FindObject(repo, "HEAD", "commit", true)
+	if err != nil {
+		return err
+	}
+	headTree, err := commitTree(repo, head)
+	if err != nil {
+		return err
+	}
+
+	picked, err := object.ReadCommit(repo, step.Commit)
+	if err != nil {
+		return err
+	}
+	parent, err := mainlineParent(step.Commit, picked, opts.Mainline)
+	if err != nil {
+		return err
+	}
+
+	subject := firstLine(picked.Message())
+	label := fmt.Sprintf("%s (%s)", step.Commit.Short(), subject)
+
+	var message, pendingFile, verb string
+	switch step.Action {
+	case sequencer.Revert:
+		message = fmt.Sprintf("Revert \"%s\"\n\nThis reverts commit %s", subject, step.Commit)
+		if len(picked.Parents()) > 1 {
+			message += fmt.Sprintf(", reversing\nchanges made to %s", parent)
+		}
+		message += ".\n"
+		pendingFile, verb = revertHeadFile, "revert"
+
+	default:
+		message = picked.Message()
+		if opts.RecordOrigin {
+			message = strings.TrimRight(message, "\n") + fmt.Sprintf("\n\n(cherry picked from commit %s)\n", step.Commit)
+		}
+		pendingFile, verb = cherryPickHeadFile, "apply"
+	}
+
+	result, err := applyChanges(repo, head, picked, parent, step.Action == sequencer.Revert, label)
+	if err != nil {
+		return err
+	}
+
+	if len(result.Conflicts) > 0 {
+		if err := writeStateFile(repo, pendingFile, step.Commit.String()+"\n"); err != nil {
+			return err
+		}
+		if err := writeStateFile(repo, mergeMsgFile, conflictMessage(message, result.Conflicts)); err != nil {
+			return err
+		}
+		printConflicts(result.Conflicts, label)
+		return fmt.Errorf("could not %s %s... %s; resolve the conflicts, add them and run --continue (or --skip, --abort)", verb, step.Commit.Short(), subject)
+	}
+
+	if result.Tree == headTree {
+		fmt.Printf("Skipping %s... %s: its changes are already in HEAD\n", step.Commit.Short(), subject)
+		return nil
+	}
+
+	now := time.Now()
+	committer := fmt.Sprintf("%s %d %s", identity, now.Unix(), now.Format("-0700"))
+
+	// A picked commit keeps its author; a revert is authored by whoever reverts
+	author := committer
+	if step.Action == sequencer.Pick {
+		signature, err := picked.Author()
+		if err != nil {
+			return err
+		}
+		author = signature.String()
+	}
+
+	hash, err := writeCommit(repo, result.Tree, []oid.ObjectID{head}, author, committer, message)
+	if err != nil {
+		return err
+	}
+
+	reflogAction := "cherry-pick"
+	if step.Action == sequencer.Revert {
+		reflogAction = "revert"
+	}
+	if err := object.UpdateRef(repo, "HEAD", hash, identity, reflogAction+": "+firstLine(message)); err != nil {
+		return err
+	}
+
+	fmt.Printf("[%s] %s\n", hash.Short(), firstLine(message))
+	return nil
+}
+
+// mainlineParent returns the parent a commit's changes are taken against: its only parent,
+// or for a merge commit the mainline parent (starting at 1). A root commit has none.
+func mainlineParent(hash oid.ObjectID, commit *object.Commit, mainline int) (oid.ObjectID, error) {
+	parents := commit.Parents()
+	switch {
+	case len(parents) > 1:
+		if mainline == 0 {
+			return "", fmt.Errorf("commit %s is a merge but no -m option was given", hash.Short())
+		}
+		if mainline > len(parents) {
+			return "", fmt.Errorf("commit %s does not have parent %d", hash.Short(), mainline)
+		}
+		return parents[mainline-1], nil
+	case mainline != 0:
+		return "", fmt.Errorf("mainline was specified but commit %s is not a merge", hash.Short())
+	case len(parents) == 1:
+		return parents[0], nil
+	}
+	return "", nil
+}
+
+// applyChanges merges the changes a commit introduced relative to parent (or their reverse)
+// into head, and updates the working tree and index to the result. label names the commit
+// in conflict markers.
+func applyChanges(repo *repository.Repo, head oid.ObjectID, commit *object.Commit, parent oid.ObjectID, reverse bool, label string) (*merge.Result, error) {
+	headTree, err := commitTree(repo, head)
+	if err != nil {
+		return nil, err
+	}
+
+	var parentTree oid.ObjectID
+	if parent != "" {
+		if parentTree, err = commitTree(repo, parent); err != nil {
+			return nil, err
+		}
+	}
+
+	mergeOpts := mergeOptions(repo)
+	mergeOpts.OursLabel = "HEAD"
+
+	base, theirs := parentTree, commit.TreeHash()
+	mergeOpts.BaseLabel, mergeOpts.TheirsLabel = "parent of "+label, label
+	if reverse {
+		base, theirs = theirs, base
+		mergeOpts.BaseLabel, mergeOpts.TheirsLabel = mergeOpts.TheirsLabel, mergeOpts.BaseLabel
+	}
+
+	result, err := merge.Trees(repo, base, headTree, theirs, mergeOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	headLeaves, err := commitLeaves(repo, head)
+	if err != nil {
+		return nil, err
+	}
+	if err := updateWorkTree(repo, headLeaves, result.Leaves, result.Conflicts); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// pendingStep returns the commit of a cherry-pick or revert stopped by conflicts, if any.
+func pendingStep(repo *repository.Repo) (string, error) {
+	for _, name := range []string{cherryPickHeadFile, revertHeadFile} {
+		contents, err := readStateFile(repo, name)
+		if err != nil {
+			return "", err
+		}
+		if strings.TrimSpace(contents) != "" {
+			return strings.TrimSpace(contents), nil
+		}
+	}
+	return "", nil
+}
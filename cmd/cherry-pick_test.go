@@ -0,0 +1,174 @@
+package cmd
+
+import (
+	"orf/oid"
+	"orf/repository"
+	"orf/sequencer"
+	"strings"
+	"testing"
+)
+
+// pickSetup commits a.txt as the base, then on branch "topic" a commit adding b.txt and
+// one setting a.txt to theirs, and on the current branch one setting a.txt to ours. It
+// returns the base, the two topic commits and the current commit.
+func pickSetup(t *testing.T, repo *repository.Repo, ours, theirs string) (oid.ObjectID, []oid.ObjectID, oid.ObjectID) {
+	base := commitFiles(t, repo, map[string]string{"a.txt": "a\n"}, "base")
+	first := commitFiles(t, repo, map[string]string{"b.txt": "b\n"}, "add b")
+	second := commitFiles(t, repo, map[string]string{"a.txt": theirs}, "edit a")
+	branch(t, repo, "topic", second)
+	resetHard(t, base)
+	head := commitFiles(t, repo, map[string]string{"a.txt": ours}, "ours")
+	return base, []oid.ObjectID{first, second}, head
+}
+
+func TestCherryPick(t *testing.T) {
+	repo := createTestRepo(t)
+	base, picked, head := pickSetup(t, repo, "a\n", "A\n")
+
+	if _, err := captureOutput(t, func() error {
+		return CherryPick([]string{base.String() + "..topic"}, sequencer.Options{RecordOrigin: true})
+	}); err != nil {
+		t.Fatalf("CherryPick failed: %v", err)
+	}
+
+	if data := readFile(t, repo, "b.txt"); data != "b\n" {
+		t.Errorf("Expected b.txt picked, got %q", data)
+	}
+	if data := readFile(t, repo, "a.txt"); data != "A\n" {
+		t.Errorf("Expected a.txt picked, got %q", data)
+	}
+
+	tip := resolve(t, repo, "HEAD")
+	first := parents(t, repo, tip)[0]
+	if p := parents(t, repo, first); len(p) != 1 || p[0] != head {
+		t.Errorf("Expected the picks on top of %s, got %v", head, p)
+	}
+	if message := commitMessage(t, repo, tip); !strings.Contains(message, "(cherry picked from commit "+picked[1].String()+")") {
+		t.Errorf("Expected the origin recorded, got %q", message)
+	}
+	if sequencer.InProgress(repo) {
+		t.Error("Expected the sequence to be finished")
+	}
+}
+
+func TestCherryPickContinue(t *testing.T) {
+	repo := createTestRepo(t)
+	base, picked, head := pickSetup(t, repo, "ours\n", "theirs\n")
+
+	_, err := captureOutput(t, func() error { return CherryPick([]string{base.String() + "..topic"}, sequencer.Options{}) })
+	if err == nil {
+		t.Fatal("Expected the second pick to conflict")
+	}
+	if pending := readState(t, repo, cherryPickHeadFile); pending != picked[1].String() {
+		t.Errorf("Expected CHERRY_PICK_HEAD %s, got %q", picked[1], pending)
+	}
+	state, err := sequencer.Read(repo)
+	if err != nil {
+		t.Fatalf("Failed to read the sequence: %v", err)
+	}
+	if state.Head != head || len(state.Todo) != 1 || state.Todo[0].Commit != picked[1] {
+		t.Errorf("Expected the conflicted step left to do, got head %s and %v", state.Head, state.Todo)
+	}
+	if err := CherryPick([]string{"topic"}, sequencer.Options{}); err == nil {
+		t.Error("Expected a second cherry-pick to be refused")
+	}
+	if _, err := captureOutput(t, SequencerContinue); err == nil {
+		t.Error("Expected --continue to refuse unmerged paths")
+	}
+
+	writeFiles(t, repo, map[string]string{"a.txt": "resolved\n"})
+	stageFiles(t, repo, "a.txt")
+	if _, err := captureOutput(t, SequencerContinue); err != nil {
+		t.Fatalf("SequencerContinue failed: %v", err)
+	}
+
+	tip := resolve(t, repo, "HEAD")
+	if message := commitMessage(t, repo, tip); !strings.HasPrefix(message, "edit a") {
+		t.Errorf("Expected the resolution committed with the picked message, got %q", message)
+	}
+	if p := parents(t, repo, parents(t, repo, tip)[0]); len(p) != 1 || p[0] != head {
+		t.Errorf("Expected both picks on top of %s, got %v", head, p)
+	}
+	if readState(t, repo, cherryPickHeadFile) != "" || sequencer.InProgress(repo) {
+		t.Error("Expected --continue to finish the sequence")
+	}
+}
+
+func TestCherryPickAbort(t *testing.T) {
+	repo := createTestRepo(t)
+	base, _, head := pickSetup(t, repo, "ours\n", "theirs\n")
+
+	captureOutput(t, func() error { return CherryPick([]string{base.String() + "..topic"}, sequencer.Options{}) })
+	if resolve(t, repo, "HEAD") == head {
+		t.Fatal("Expected the first pick to be committed before the conflict")
+	}
+
+	if _, err := captureOutput(t, SequencerAbort); err != nil {
+		t.Fatalf("SequencerAbort failed: %v", err)
+	}
+	if tip := resolve(t, repo, "HEAD"); tip != head {
+		t.Errorf("Expected --abort to return HEAD to %s, got %s", head, tip)
+	}
+	if data := readFile(t, repo, "a.txt"); data != "ours\n" {
+		t.Errorf("Expected a.txt restored, got %q", data)
+	}
+	if data := readFile(t, repo, "b.txt"); data != "<missing>" {
+		t.Errorf("Expected the first pick undone, got b.txt %q", data)
+	}
+	if readState(t, repo, cherryPickHeadFile) != "" || sequencer.InProgress(repo) {
+		t.Error("Expected --abort to remove the sequence")
+	}
+	if _, err := captureOutput(t, SequencerAbort); err == nil {
+		t.Error("Expected --abort without a sequence to fail")
+	}
+}
+
+func TestRevert(t *testing.T) {
+	repo := createTestRepo(t)
+	commitFiles(t, repo, map[string]string{"a.txt": "1\n", "b.txt": "b\n"}, "first")
+	second := commitFiles(t, repo, map[string]string{"a.txt": "2\n"}, "second")
+	third := commitFiles(t, repo, map[string]string{"b.txt": "B\n"}, "third")
+
+	if _, err := captureOutput(t, func() error { return Revert([]string{third.String()}, sequencer.Options{}) }); err != nil {
+		t.Fatalf("Revert failed: %v", err)
+	}
+	tip := resolve(t, repo, "HEAD")
+	if message := commitMessage(t, repo, tip); !strings.HasPrefix(message, "Revert \"third\"\n\nThis reverts commit "+third.String()+".") {
+		t.Errorf("Unexpected revert message %q", message)
+	}
+	if data := readFile(t, repo, "b.txt"); data != "b\n" {
+		t.Errorf("Expected b.txt reverted, got %q", data)
+	}
+
+	// A conflicting revert stops with REVERT_HEAD; --skip drops it
+	commitFiles(t, repo, map[string]string{"a.txt": "3\n"}, "fourth")
+	tip = resolve(t, repo, "HEAD")
+	if _, err := captureOutput(t, func() error { return Revert([]string{second.String()}, sequencer.Options{}) }); err == nil {
+		t.Fatal("Expected the revert to conflict")
+	}
+	if pending := readState(t, repo, revertHeadFile); pending != second.String() {
+		t.Errorf("Expected REVERT_HEAD %s, got %q", second, pending)
+	}
+	if _, err := captureOutput(t, SequencerSkip); err != nil {
+		t.Fatalf("SequencerSkip failed: %v", err)
+	}
+	if head := resolve(t, repo, "HEAD"); head != tip {
+		t.Errorf("Expected --skip to leave HEAD at %s, got %s", tip, head)
+	}
+	if data := readFile(t, repo, "a.txt"); data != "3\n" {
+		t.Errorf("Expected --skip to discard the revert, got %q", data)
+	}
+	if readState(t, repo, revertHeadFile) != "" || sequencer.InProgress(repo) {
+		t.Error("Expected --skip to finish the sequence")
+	}
+}
+
+func TestCherryPickConflictStyleSetting(t *testing.T) {
+	repo := createTestRepo(t, "[merge]", "conflictStyle = diff3")
+	_, picked, _ := pickSetup(t, repo, "ours\n", "theirs\n")
+
+	captureOutput(t, func() error { return CherryPick([]string{picked[1].String()}, sequencer.Options{}) })
+	if data := readFile(t, repo, "a.txt"); !strings.Contains(data, "|||||||") {
+		t.Errorf("Expected diff3 conflict markers with merge.conflictStyle = diff3, got %q", data)
+	}
+}
@@ -0,0 +1,245 @@
+package cmd
+
+import (
+	"bytes"
+	"io"
+	"orf/index"
+	"orf/object"
+	"orf/oid"
+	"orf/repository"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// createTestRepo initializes a repository in a temporary directory, with config appended to
+// its config file, and makes it the repository and current directory commands run in. The
+// committer is Test <test@example.com>, and the editor leaves messages as they are.
+func createTestRepo(t *testing.T, config ...string) *repository.Repo {
+	workTree := t.TempDir()
+	repo, err := repository.CreateRepo(workTree)
+	if err != nil {
+		t.Fatalf("CreateRepo failed: %v", err)
+	}
+	if len(config) > 0 {
+		file, err := os.OpenFile(filepath.Join(repo.Directory, "config"), os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			t.Fatalf("Failed to open config: %v", err)
+		}
+		defer file.Close()
+		if _, err := file.WriteString("\n" + strings.Join(config, "\n") + "\n"); err != nil {
+			t.Fatalf("Failed to write config: %v", err)
+		}
+	}
+
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(home, ".config"))
+	t.Setenv("ORF_EDITOR", "true")
+	if err := os.WriteFile(filepath.Join(home, ".orfconfig"), []byte("[user]\nname = Test\nemail = test@example.com\n"), 0644); err != nil {
+		t.Fatalf("Failed to write user config: %v", err)
+	}
+
+	previous := findRepo
+	findRepo = func() (*repository.Repo, error) {
+		return repo, nil
+	}
+	t.Cleanup(func() { findRepo = previous })
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Getwd failed: %v", err)
+	}
+	if err := os.Chdir(workTree); err != nil {
+		t.Fatalf("Chdir failed: %v", err)
+	}
+	t.Cleanup(func() { os.Chdir(cwd) })
+
+	return repo
+}
+
+// writeFiles writes files of the working tree, creating their directories.
+func writeFiles(t *testing.T, repo *repository.Repo, files map[string]string) {
+	for path, contents := range files {
+		file := filepath.Join(repo.WorkTree, filepath.FromSlash(path))
+		if err := os.MkdirAll(filepath.Dir(file), os.ModePerm); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(file, []byte(contents), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", path, err)
+		}
+	}
+}
+
+// removeFile deletes a working tree file.
+func removeFile(t *testing.T, repo *repository.Repo, path string) {
+	if err := os.Remove(filepath.Join(repo.WorkTree, filepath.FromSlash(path))); err != nil {
+		t.Fatalf("Failed to remove %s: %v", path, err)
+	}
+}
+
+// readFile returns the contents of a working tree file, or "<missing>".
+func readFile(t *testing.T, repo *repository.Repo, path string) string {
+	data, err := os.ReadFile(filepath.Join(repo.WorkTree, filepath.FromSlash(path)))
+	if os.IsNotExist(err) {
+		return "<missing>"
+	}
+	if err != nil {
+		t.Fatalf("Failed to read %s: %v", path, err)
+	}
+	return string(data)
+}
+
+// stageFiles stages the working tree version of paths, removing those missing from it.
+func stageFiles(t *testing.T, repo *repository.Repo, paths ...string) {
+	for _, path := range paths {
+		idx, err := index.ReadIndex(repo)
+		if err != nil {
+			t.Fatalf("Failed to read index: %v", err)
+		}
+		if err := stageResolved(repo, idx, path, []byte("100644")); err != nil {
+			t.Fatalf("Failed to stage %s: %v", path, err)
+		}
+	}
+}
+
+// commitFiles writes files, removes those mapped to "", stages them all and commits.
+func commitFiles(t *testing.T, repo *repository.Repo, files map[string]string, message string) oid.ObjectID {
+	var paths []string
+	written := make(map[string]string)
+	for path, contents := range files {
+		paths = append(paths, path)
+		if contents != "" {
+			written[path] = contents
+		} else if err := os.Remove(filepath.Join(repo.WorkTree, filepath.FromSlash(path))); err != nil && !os.IsNotExist(err) {
+			t.Fatalf("Failed to remove %s: %v", path, err)
+		}
+	}
+	writeFiles(t, repo, written)
+	stageFiles(t, repo, paths...)
+
+	if _, err := captureOutput(t, func() error { return Commit(message) }); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	return resolve(t, repo, "HEAD")
+}
+
+// resolve returns the commit a revision names.
+func resolve(t *testing.T, repo *repository.Repo, revision string) oid.ObjectID {
+	t.Helper()
+	id, err := object.ResolveRevision(repo, revision)
+	if err != nil {
+		t.Fatalf("Failed to resolve %s: %v", revision, err)
+	}
+	return id
+}
+
+// branch points refs/heads/<name> at a commit.
+func branch(t *testing.T, repo *repository.Repo, name string, commit oid.ObjectID) {
+	if err := object.CreateRef(repo, "heads/"+name, commit); err != nil {
+		t.Fatalf("Failed to create branch %s: %v", name, err)
+	}
+}
+
+// resetHard moves the current branch to a commit, checking it out.
+func resetHard(t *testing.T, commit oid.ObjectID) {
+	if _, err := captureOutput(t, func() error { return Reset(commit.String(), ResetHard) }); err != nil {
+		t.Fatalf("Reset failed: %v", err)
+	}
+}
+
+// readState returns the trimmed contents of a state file such as ORIG_HEAD, or "" if missing.
+func readState(t *testing.T, repo *repository.Repo, name string) string {
+	contents, err := readStateFile(repo, name)
+	if err != nil {
+		t.Fatalf("Failed to read %s: %v", name, err)
+	}
+	return strings.TrimSpace(contents)
+}
+
+// parents returns the parents of a commit.
+func parents(t *testing.T, repo *repository.Repo, commit oid.ObjectID) []oid.ObjectID {
+	c, err := object.ReadCommit(repo, commit)
+	if err != nil {
+		t.Fatalf("ReadCommit failed: %v", err)
+	}
+	return c.Parents()
+}
+
+// stagedFiles returns the contents of the stage 0 index entries, by path.
+func stagedFiles(t *testing.T, repo *repository.Repo) map[string]string {
+	idx, err := index.ReadIndex(repo)
+	if err != nil {
+		t.Fatalf("Failed to read index: %v", err)
+	}
+	files := make(map[string]string)
+	for _, entry := range idx.Entries {
+		if entry.FlagStaged != 0 {
+			continue
+		}
+		obj, err := object.ReadObject(repo.Directory, entry.Sha)
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", entry.Name, err)
+		}
+		files[filepath.ToSlash(entry.Name)] = string(obj.GetData())
+	}
+	return files
+}
+
+// assertFiles checks the contents of files (in the index or the working tree), with
+// "<missing>" for files that must not exist.
+func assertFiles(t *testing.T, where string, files map[string]string, expected map[string]string) {
+	t.Helper()
+	for path, contents := range expected {
+		actual, ok := files[path]
+		if !ok {
+			actual = "<missing>"
+		}
+		if actual != contents {
+			t.Errorf("Expected %s %s = %q, got %q", where, path, contents, actual)
+		}
+	}
+}
+
+// worktreeFiles returns the contents of the given working tree files.
+func worktreeFiles(t *testing.T, repo *repository.Repo, paths ...string) map[string]string {
+	files := make(map[string]string)
+	for _, path := range paths {
+		if data := readFile(t, repo, path); data != "<missing>" {
+			files[path] = data
+		}
+	}
+	return files
+}
+
+// commitMessage returns the message of a commit.
+func commitMessage(t *testing.T, repo *repository.Repo, commit oid.ObjectID) string {
+	c, err := object.ReadCommit(repo, commit)
+	if err != nil {
+		t.Fatalf("ReadCommit failed: %v", err)
+	}
+	return c.Message()
+}
+
+// captureOutput runs fn and returns what it printed to stdout.
+func captureOutput(t *testing.T, fn func() error) (string, error) {
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("Pipe failed: %v", err)
+	}
+	stdout := os.Stdout
+	os.Stdout = writer
+
+	output := make(chan string)
+	go func() {
+		var buffer bytes.Buffer
+		io.Copy(&buffer, reader)
+		output <- buffer.String()
+	}()
+
+	err = fn()
+	os.Stdout = stdout
+	writer.Close()
+	return <-output, err
+}
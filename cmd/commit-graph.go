@@ -0,0 +1,32 @@
+package cmd
+
+import (
+	"fmt"
+	"orf/commitgraph"
+)
+
+// CommitGraphWrite writes the commit-graph of every reachable commit.
+func CommitGraphWrite() error {
+	repo, err := findRepo()
+	if err != nil {
+		return fmt.Errorf("error finding repo: %w", err)
+	}
+
+	count, err := commitgraph.Write(repo)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Wrote %d commits to %s\n", count, commitgraph.Path(repo.Directory))
+	return nil
+}
+
+// CommitGraphVerify checks the commit-graph against the commits it records.
+func CommitGraphVerify() error {
+	repo, err := findRepo()
+	if err != nil {
+		return fmt.Errorf("error finding repo: %w", err)
+	}
+
+	return commitgraph.Verify(repo)
+}
@@ -1,13 +1,16 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
 	"orf/index"
+	"orf/kv"
 	"orf/object"
+	"orf/oid"
 	"orf/repository"
 	"os"
 	"path/filepath"
-	"sort"
+	"strings"
 	"time"
 
 	"github.com/go-ini/ini"
@@ -15,175 +18,176 @@ import (
 
 func Commit(message string) error {
 	// Find the repository
-	repo, err := repository.FindRepo(".", false)
+	repo, err := findRepo()
 	if err != nil {
 		return err
 	}
 
+	// Without a message, use the one prepared by a merge
+	for _, name := range []string{mergeMsgFile, squashMsgFile} {
+		if message != "" {
+			break
+		}
+		if message, err = readStateFile(repo, name); err != nil {
+			return err
+		}
+		message = stripComments(message)
+	}
+	if message == "" {
+		return fmt.Errorf("empty commit message")
+	}
+
+	_, err = commit(repo, message)
+	return err
+}
+
+// commit records the index as a new commit on HEAD. While a merge is in progress the
+// merged commits become further parents, and the merge state is cleared.
+func commit(repo *repository.Repo, message string) (oid.ObjectID, error) {
 	// Read the index
 	index, err := index.ReadIndex(repo)
 	if err != nil {
-		return err
+		return "", err
 	}
 
 	// Create the tree and get the SHA for the root tree
 	tree, err := TreeFromIndex(repo, index.Entries)
 	if err != nil {
-		return err
+		return "", err
 	}
 
-	parent := object.FindObject(repo, "HEAD", "commit", false)
+	// The first commit on a branch has no parent
+	var parents []oid.ObjectID
+	parent, err := object.FindObject(repo, "HEAD", "commit", false)
+	if err != nil && !errors.Is(err, object.ErrNotFound) {
+		return "", err
+	}
+	if parent != "" {
+		parents = append(parents, parent)
+	}
 
-	// Get the author from the orf config (simulated here)
-	config, err := readOrfConfig()
+	mergeHeads, err := readMergeHeads(repo)
 	if err != nil {
-		return err
+		return "", err
 	}
+	parents = append(parents, mergeHeads...)
 
-	author := getUserOrfConfig(config)
-
-	// Create the commit
-	commit, err := WriteCommit(repo, tree, parent, author, time.Now(), message)
+	// Get the author from the orf config (simulated here)
+	config, err := readOrfConfig()
 	if err != nil {
-		return err
+		return "", err
 	}
 
-	// Get the active branch
-	activeBranch, err := GetBranch(repo)
+	identity := getUserOrfConfig(config)
+	now := time.Now()
+	committer := fmt.Sprintf("%s %d %s", identity, now.Unix(), now.Format("-0700"))
+
+	// A resolved cherry-pick keeps the author of the picked commit
+	author := committer
+	picked, err := readStateFile(repo, cherryPickHeadFile)
 	if err != nil {
-		return err
+		return "", err
 	}
-
-	// Update the HEAD or the active branch reference
-	if activeBranch != "" {
-		// If on a branch, update the ref
-		refPath, err := repository.GetFilePath(repo.WorkTree, false, "refs/heads/"+activeBranch)
+	if picked = strings.TrimSpace(picked); picked != "" {
+		original, err := object.ReadCommit(repo, oid.ObjectID(picked))
 		if err != nil {
-			return err
+			return "", err
 		}
-
-		err = os.WriteFile(refPath, []byte(commit+"\n"), 0644)
+		signature, err := original.Author()
 		if err != nil {
-			return err
+			return "", err
 		}
+		author = signature.String()
+	}
 
-	} else {
-		// If not on a branch, update HEAD itself
-		headPath, err := repository.GetFilePath(repo.WorkTree, false, "HEAD")
-		if err != nil {
-			return err
-		}
-		err = os.WriteFile(headPath, []byte(commit+"\n"), 0644)
-		if err != nil {
-			return err
-		}
+	// Create the commit
+	commit, err := writeCommit(repo, tree, parents, author, committer, message)
+	if err != nil {
+		return "", err
+	}
+
+	// Move HEAD (or the branch it points to) to the new commit, recording it in the reflog
+	reflogMessage := "commit: " + firstLine(message)
+	if parent == "" {
+		reflogMessage = "commit (initial): " + firstLine(message)
+	} else if len(mergeHeads) > 0 {
+		reflogMessage = "commit (merge): " + firstLine(message)
+	} else if picked != "" {
+		reflogMessage = "commit (cherry-pick): " + firstLine(message)
+	}
+	if err := object.UpdateRef(repo, "HEAD", commit, identity, reflogMessage); err != nil {
+		return "", err
+	}
+
+	if err := removeStateFiles(repo, mergeHeadFile, mergeMsgFile, squashMsgFile, cherryPickHeadFile, revertHeadFile); err != nil {
+		return "", err
 	}
 
 	fmt.Println("Commit successful!")
-	return nil
+	return commit, nil
 }
 
-func WriteCommit(repo *repository.Repo, tree, parent, author string, timestamp time.Time, message string) (string, error) {
-	commit := object.CreateCommit(nil)
+// firstLine returns the first line of a message.
+func firstLine(message string) string {
+	line, _, _ := strings.Cut(strings.TrimSpace(message), "\n")
+	return line
+}
 
-	// Add tree and parent to the commit
-	commit.GetKVData().Add("tree", []byte(tree))
+// WriteCommit writes a commit object for tree with the given parents (none for a root
+// commit) and returns its id. The author is also recorded as the committer.
+func WriteCommit(repo *repository.Repo, tree oid.ObjectID, parents []oid.ObjectID, author string, timestamp time.Time, message string) (oid.ObjectID, error) {
+	// Format the author and committer string
+	authorString := fmt.Sprintf("%s %d %s", author, timestamp.Unix(), timestamp.Format("-0700"))
+	return writeCommit(repo, tree, parents, authorString, authorString, message)
+}
 
-	if parent != "" {
-		commit.GetKVData().Add("parent", []byte(parent))
-	}
+// writeCommit writes a commit object with separate author and committer signatures, each
+// "Name <email> <unix seconds> <+hhmm>".
+func writeCommit(repo *repository.Repo, tree oid.ObjectID, parents []oid.ObjectID, author string, committer string, message string) (oid.ObjectID, error) {
+	kvData := kv.CreateOrderedMap()
 
-	// Format the timestamp and timezone
-	offset := int(timestamp.UTC().Sub(timestamp).Seconds())
-	hours := offset / 3600
-	minutes := (offset % 3600) / 60
-	tz := fmt.Sprintf("%+03d%02d", hours, minutes)
+	// Add tree and parents to the commit
+	kvData.Add("tree", tree.String())
 
-	// Format the author and committer string
-	authorString := fmt.Sprintf("%s %d %s", author, timestamp.Unix(), tz)
-	commit.GetKVData().Add("author", []byte(authorString))
-	commit.GetKVData().Add("committer", []byte(authorString))
+	if len(parents) == 1 {
+		kvData.Add("parent", parents[0].String())
+	} else if len(parents) > 1 {
+		var values []string
+		for _, parent := range parents {
+			values = append(values, parent.String())
+		}
+		kvData.Add("parent", values)
+	}
 
-	// Add the commit message
-	commit.GetKVData().Add("]", []byte(authorString))
+	kvData.Add("author", author)
+	kvData.Add("committer", committer)
 
-	// Simulate writing the commit object to the object store and return a SHA hash
-	// In a real implementation, this would interact with the Orf object store.
-	return "dummy-sha-commit", nil
-}
+	// Add the commit message (serializing adds the final newline)
+	kvData.Add("message", strings.TrimRight(message, "\n"))
 
-func TreeFromIndex(repo *repository.Repo, indexEntries []index.IndexEntry) (string, error) {
-	contents := make(map[string][]interface{}) // Directory -> list of entries (files or trees)
+	return object.WriteObject(repo.Directory, object.CreateCommit(kv.Serialize(kvData)))
+}
 
-	// Initialize the contents map with the root directory
-	contents[""] = []interface{}{}
+// TreeFromIndex writes the tree of the stage 0 index entries and returns its id. The index
+// must not have unmerged entries.
+func TreeFromIndex(repo *repository.Repo, indexEntries []index.IndexEntry) (oid.ObjectID, error) {
+	leaves := make(map[string]*object.Leaf)
 
-	// Iterate over index entries to categorize them into directories
 	for _, entry := range indexEntries {
-		dirname := filepath.Dir(entry.Name)
-
-		// Ensure every directory in the path exists in contents
-		key := dirname
-		for key != "" {
-			if _, exists := contents[key]; !exists {
-				contents[key] = []interface{}{}
-			}
-			key = filepath.Dir(key)
+		if entry.FlagStaged != 0 {
+			return "", fmt.Errorf("cannot write a tree with unmerged path %s", entry.Name)
 		}
 
-		// Add the entry to the directory list
-		contents[dirname] = append(contents[dirname], entry)
-	}
-
-	// Sort directories by length in descending order to process child dirs first
-	var sortedPaths []string
-	for path := range contents {
-		sortedPaths = append(sortedPaths, path)
-	}
-	sort.Slice(sortedPaths, func(i, j int) bool {
-		return len(sortedPaths[i]) > len(sortedPaths[j])
-	})
-
-	var sha string
-
-	// Process each directory path, starting from the deepest directory
-	for _, path := range sortedPaths {
-		tree := &object.Tree{}
-
-		// Add entries (files or subdirectories) to the tree
-		for _, entry := range contents[path] {
-			switch e := entry.(type) {
-			case index.IndexEntry: // Regular file entry
-				// Convert mode to octal format (simplified)
-				leafMode := fmt.Sprintf("%02o%04o", e.ModeType, e.ModePerms)
-				leaf := &object.Leaf{
-					Mode: []byte(leafMode),
-					Path: filepath.Base(e.Name),
-					Hash: e.Sha,
-				}
-				tree.Leaves = append(tree.Leaves, leaf)
-			default:
-				// Handle other types, e.g., subtrees (not implemented here)
-			}
+		// Convert mode to octal format (simplified)
+		leafMode := fmt.Sprintf("%02o%04o", entry.ModeType, entry.ModePerms)
+		leaves[filepath.ToSlash(entry.Name)] = &object.Leaf{
+			Mode: []byte(leafMode),
+			Path: filepath.ToSlash(entry.Name),
+			Hash: entry.Sha,
 		}
-
-		// Write the tree to the object store and get its SHA
-		var err error
-		sha, err = object.WriteObject(repo.Directory, tree)
-		if err != nil {
-			return "", err
-		}
-
-		// Add the new tree hash to the parent directory
-		parent := filepath.Dir(path)
-		base := filepath.Base(path)
-		contents[parent] = append(contents[parent], struct {
-			Name string
-			Sha  string
-		}{base, sha})
 	}
 
-	return sha, nil
+	return object.WriteTreeLeaves(repo, leaves)
 }
 
 func readOrfConfig() (*ini.File, error) {
@@ -0,0 +1,83 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"orf/stats"
+	"os"
+)
+
+// CountObjects prints the number of loose objects and the disk space they take. With verbose
+// it also prints the packs and the garbage files, sizes in KiB unless human, which picks a
+// unit for each. With jsonOutput everything is printed as JSON, sizes in bytes.
+func CountObjects(verbose bool, human bool, jsonOutput bool) error {
+	repo, err := findRepo()
+	if err != nil {
+		return fmt.Errorf("error finding repo: %w", err)
+	}
+
+	counts, err := stats.CountObjects(repo.Directory)
+	if err != nil {
+		return err
+	}
+
+	if jsonOutput {
+		return printJSON(counts)
+	}
+
+	size := func(bytes int64) string {
+		if human {
+			return formatSize(bytes)
+		}
+		return fmt.Sprint(bytes / 1024)
+	}
+
+	if !verbose {
+		if human {
+			fmt.Printf("%d objects, %s\n", counts.Count, formatSize(counts.Size))
+		} else {
+			fmt.Printf("%d objects, %d kilobytes\n", counts.Count, counts.Size/1024)
+		}
+		return nil
+	}
+
+	fmt.Printf("count: %d\n", counts.Count)
+	fmt.Printf("size: %s\n", size(counts.Size))
+	fmt.Printf("in-pack: %d\n", counts.InPack)
+	fmt.Printf("packs: %d\n", counts.Packs)
+	fmt.Printf("size-pack: %s\n", size(counts.SizePack))
+	fmt.Printf("prune-packable: %d\n", counts.PrunePackable)
+	fmt.Printf("garbage: %d\n", len(counts.Garbage))
+	fmt.Printf("size-garbage: %s\n", size(counts.SizeGarbage))
+	for _, path := range counts.Garbage {
+		fmt.Fprintf(os.Stderr, "warning: garbage found: %s\n", path)
+	}
+	return nil
+}
+
+// printJSON prints a value as indented JSON.
+func printJSON(value any) error {
+	data, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(data))
+	return nil
+}
+
+// formatSize prints a number of bytes in the largest unit that keeps it above 1, e.g.
+// "12.50 KiB".
+func formatSize(bytes int64) string {
+	if bytes < 1024 {
+		return fmt.Sprintf("%d bytes", bytes)
+	}
+
+	size := float64(bytes)
+	for _, unit := range []string{"KiB", "MiB", "GiB"} {
+		size /= 1024
+		if size < 1024 || unit == "GiB" {
+			return fmt.Sprintf("%.2f %s", size, unit)
+		}
+	}
+	return ""
+}
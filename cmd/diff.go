@@ -0,0 +1,227 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"orf/diff"
+	"orf/index"
+	"orf/object"
+	"orf/oid"
+	"orf/repository"
+	"os"
+)
+
+// DiffOptions selects the snapshots diff compares and how it prints their differences.
+type DiffOptions struct {
+	// Cached compares a commit (HEAD by default) with the index instead of the working tree.
+	Cached bool
+	// NameStatus prints the status and paths of each change instead of a patch.
+	NameStatus bool
+}
+
+// diffContext is the number of unchanged lines shown around each change of a patch.
+const diffContext = 3
+
+// Diff prints the changes between two snapshots: the index and the working tree without
+// revisions, a commit and the working tree (or the index, when cached) with one, and two
+// commits with two. Renamed and copied files are paired as diff.renames, diff.renameThreshold
+// and diff.renameLimit configure.
+func Diff(revisions []string, opts DiffOptions) error {
+	repo, err := findRepo()
+	if err != nil {
+		return fmt.Errorf("error finding repo: %w", err)
+	}
+
+	if len(revisions) > 2 {
+		return fmt.Errorf("expected at most two revisions")
+	}
+	if opts.Cached && len(revisions) > 1 {
+		return fmt.Errorf("--cached takes at most one revision")
+	}
+
+	idx, err := index.ReadIndex(repo)
+	if err != nil {
+		return err
+	}
+	staged := make(map[string]oid.ObjectID)
+	for _, entry := range idx.Entries {
+		// Unmerged paths have no single staged version
+		if entry.FlagStaged == 0 {
+			staged[entry.Name] = entry.Sha
+		}
+	}
+
+	// Working tree files are hashed but not stored, their contents are kept for the patch
+	contents := make(map[oid.ObjectID][]byte)
+	worktree := func(paths map[string]oid.ObjectID) (map[string]oid.ObjectID, error) {
+		files := make(map[string]oid.ObjectID)
+		for path := range paths {
+			data, err := readWorktreeFile(repo, path)
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			hash, err := GetHash(data, "blob", repo.ObjectFormat(), "")
+			if err != nil {
+				return nil, err
+			}
+			files[path] = hash
+			contents[hash] = data
+		}
+		return files, nil
+	}
+
+	var old, new map[string]oid.ObjectID
+	switch {
+	case len(revisions) == 2:
+		if old, err = treeToDict(repo, revisions[0]); err != nil {
+			return err
+		}
+		new, err = treeToDict(repo, revisions[1])
+	case opts.Cached:
+		revision := "HEAD"
+		if len(revisions) == 1 {
+			revision = revisions[0]
+		}
+		old, err = treeToDict(repo, revision)
+		// Before the first commit, everything in the index is new
+		if len(revisions) == 0 && errors.Is(err, object.ErrNotFound) {
+			old, err = map[string]oid.ObjectID{}, nil
+		}
+		new = staged
+	case len(revisions) == 1:
+		if old, err = treeToDict(repo, revisions[0]); err != nil {
+			return err
+		}
+		tracked := make(map[string]oid.ObjectID, len(old)+len(staged))
+		for path, hash := range old {
+			tracked[path] = hash
+		}
+		for path, hash := range staged {
+			tracked[path] = hash
+		}
+		new, err = worktree(tracked)
+	default:
+		old = staged
+		new, err = worktree(staged)
+	}
+	if err != nil {
+		return err
+	}
+
+	load := func(hash oid.ObjectID) ([]byte, error) {
+		if data, ok := contents[hash]; ok {
+			return data, nil
+		}
+		return blobLoader(repo)(hash)
+	}
+	changes, err := diff.DetectRenames(diff.Compare(old, new), old, renameOptions(repo), load)
+	if err != nil {
+		return err
+	}
+
+	for _, change := range changes {
+		if opts.NameStatus {
+			printNameStatus(change)
+			continue
+		}
+		if err := printPatch(change, load); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// renameOptions returns the rename detection settings of a repository.
+func renameOptions(repo *repository.Repo) diff.Options {
+	return diff.OptionsFromConfig(repository.DirectorySettings(repo.Directory).Config)
+}
+
+// printNameStatus prints a change as a status letter and its paths, with the similarity of
+// renames and copies, e.g. "R087	old.txt	new.txt".
+func printNameStatus(change diff.Change) {
+	switch change.Type {
+	case diff.Added:
+		fmt.Printf("A\t%s\n", change.NewPath)
+	case diff.Deleted:
+		fmt.Printf("D\t%s\n", change.OldPath)
+	case diff.Modified:
+		fmt.Printf("M\t%s\n", change.NewPath)
+	case diff.Renamed:
+		fmt.Printf("R%03d\t%s\t%s\n", change.Score, change.OldPath, change.NewPath)
+	case diff.Copied:
+		fmt.Printf("C%03d\t%s\t%s\n", change.Score, change.OldPath, change.NewPath)
+	}
+}
+
+// printPatch prints a change as a unified diff.
+func printPatch(change diff.Change, load diff.Loader) error {
+	oldPath, newPath := change.OldPath, change.NewPath
+	if change.Type == diff.Added {
+		oldPath = newPath
+	}
+	if change.Type == diff.Deleted {
+		newPath = oldPath
+	}
+	fmt.Printf("diff --orf a/%s b/%s\n", oldPath, newPath)
+
+	switch change.Type {
+	case diff.Added:
+		fmt.Println("new file")
+	case diff.Deleted:
+		fmt.Println("deleted file")
+	case diff.Renamed, diff.Copied:
+		verb := "rename"
+		if change.Type == diff.Copied {
+			verb = "copy"
+		}
+		fmt.Printf("similarity index %d%%\n%s from %s\n%s to %s\n", change.Score, verb, change.OldPath, verb, change.NewPath)
+	}
+	if change.OldHash == change.NewHash {
+		return nil
+	}
+
+	var oldLines, newLines []string
+	for _, side := range []struct {
+		hash  oid.ObjectID
+		lines *[]string
+	}{{change.OldHash, &oldLines}, {change.NewHash, &newLines}} {
+		if side.hash == "" {
+			continue
+		}
+		data, err := load(side.hash)
+		if err != nil {
+			return err
+		}
+		*side.lines = diff.SplitLines(data)
+	}
+
+	from, to := "a/"+oldPath, "b/"+newPath
+	if change.Type == diff.Added {
+		from = "/dev/null"
+	}
+	if change.Type == diff.Deleted {
+		to = "/dev/null"
+	}
+	fmt.Printf("--- %s\n+++ %s\n", from, to)
+
+	for _, hunk := range diff.Hunks(diff.Lines(oldLines, newLines), diffContext) {
+		fmt.Printf("@@ -%d,%d +%d,%d @@\n", hunk.OldStart, hunk.OldLines, hunk.NewStart, hunk.NewLines)
+		for _, edit := range hunk.Edits {
+			prefix := " "
+			switch edit.Op {
+			case diff.Insert:
+				prefix = "+"
+			case diff.Delete:
+				prefix = "-"
+			}
+			fmt.Print(prefix + edit.Text)
+			if len(edit.Text) == 0 || edit.Text[len(edit.Text)-1] != '\n' {
+				fmt.Println("\n\\ No newline at end of file")
+			}
+		}
+	}
+	return nil
+}
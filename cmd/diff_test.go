@@ -0,0 +1,59 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	repo := createTestRepo(t)
+	lines := "one\ntwo\nthree\nfour\nfive\nsix\n"
+	first := commitFiles(t, repo, map[string]string{"old.txt": lines, "edit.txt": "a\nb\n"}, "first")
+	commitFiles(t, repo, map[string]string{"old.txt": "", "new.txt": lines + "seven\n"}, "rename")
+
+	output, err := captureOutput(t, func() error {
+		return Diff([]string{first.String(), "HEAD"}, DiffOptions{NameStatus: true})
+	})
+	if err != nil {
+		t.Fatalf("Diff failed: %v", err)
+	}
+	if output != "R082\told.txt\tnew.txt\n" {
+		t.Errorf("Expected the rename to be detected, got %q", output)
+	}
+
+	// Unstaged changes, then staged ones
+	writeFiles(t, repo, map[string]string{"edit.txt": "a\nB\n"})
+	output, err = captureOutput(t, func() error { return Diff(nil, DiffOptions{}) })
+	if err != nil {
+		t.Fatalf("Diff failed: %v", err)
+	}
+	expected := "diff --orf a/edit.txt b/edit.txt\n--- a/edit.txt\n+++ b/edit.txt\n@@ -1,2 +1,2 @@\n a\n-b\n+B\n"
+	if output != expected {
+		t.Errorf("Expected patch %q, got %q", expected, output)
+	}
+
+	if output, _ := captureOutput(t, func() error { return Diff(nil, DiffOptions{Cached: true}) }); output != "" {
+		t.Errorf("Expected nothing staged, got %q", output)
+	}
+	stageFiles(t, repo, "edit.txt")
+	output, _ = captureOutput(t, func() error { return Diff(nil, DiffOptions{Cached: true, NameStatus: true}) })
+	if output != "M\tedit.txt\n" {
+		t.Errorf("Expected the staged change, got %q", output)
+	}
+}
+
+func TestDiffRenameSettings(t *testing.T) {
+	repo := createTestRepo(t, "[diff]", "renames = false")
+	first := commitFiles(t, repo, map[string]string{"old.txt": "same\n"}, "first")
+	commitFiles(t, repo, map[string]string{"old.txt": "", "new.txt": "same\n"}, "rename")
+
+	output, err := captureOutput(t, func() error {
+		return Diff([]string{first.String(), "HEAD"}, DiffOptions{NameStatus: true})
+	})
+	if err != nil {
+		t.Fatalf("Diff failed: %v", err)
+	}
+	if lines := strings.Split(strings.TrimSpace(output), "\n"); len(lines) != 2 || lines[0] != "A\tnew.txt" || lines[1] != "D\told.txt" {
+		t.Errorf("Expected a delete and an add with diff.renames=false, got %q", output)
+	}
+}
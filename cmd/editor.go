@@ -0,0 +1,69 @@
+package cmd
+
+import (
+	"fmt"
+	"orf/repository"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// editor returns the command used to edit messages and todo lists: ORF_EDITOR, core.editor,
+// VISUAL or EDITOR, in that order, defaulting to vi.
+func editor(repo *repository.Repo) string {
+	if editor := os.Getenv("ORF_EDITOR"); editor != "" {
+		return editor
+	}
+	if config := repository.DirectorySettings(repo.Directory).Config; config != nil {
+		if editor := config.Section("core").Key("editor").String(); editor != "" {
+			return editor
+		}
+	}
+	for _, name := range []string{"VISUAL", "EDITOR"} {
+		if editor := os.Getenv(name); editor != "" {
+			return editor
+		}
+	}
+	return "vi"
+}
+
+// editFile opens a file in the editor and waits for it to exit. The editor command is run
+// by the shell, so it may include arguments.
+func editFile(repo *repository.Repo, path string) error {
+	command := editor(repo)
+
+	cmd := exec.Command("sh", "-c", command+` "$@"`, command, path)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("there was a problem with the editor '%s': %v", command, err)
+	}
+	return nil
+}
+
+// editMessage lets the user edit a commit message in COMMIT_EDITMSG and returns it without
+// comment lines. An empty message is an error.
+func editMessage(repo *repository.Repo, message string) (string, error) {
+	path := filepath.Join(repo.Directory, commitEditMsgFile)
+
+	contents := strings.TrimRight(message, "\n") + "\n\n" +
+		"# Please enter the commit message for your changes. Lines starting\n" +
+		"# with '#' will be ignored, and an empty message aborts the commit.\n"
+	if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+		return "", err
+	}
+	if err := editFile(repo, path); err != nil {
+		return "", err
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	if message = stripComments(string(data)); message == "" {
+		return "", fmt.Errorf("aborting commit due to empty commit message")
+	}
+	return message, nil
+}
@@ -0,0 +1,18 @@
+package cmd
+
+import "testing"
+
+func TestEditor(t *testing.T) {
+	repo := createTestRepo(t, "[core]", "editor = configured")
+	t.Setenv("VISUAL", "visual")
+	t.Setenv("EDITOR", "editor")
+
+	if command := editor(repo); command != "true" {
+		t.Errorf("Expected ORF_EDITOR first, got %q", command)
+	}
+
+	t.Setenv("ORF_EDITOR", "")
+	if command := editor(repo); command != "configured" {
+		t.Errorf("Expected core.editor, got %q", command)
+	}
+}
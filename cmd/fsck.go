@@ -0,0 +1,57 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"orf/fsck"
+	"orf/object"
+)
+
+// Fsck verifies the object database and prints its problems: corrupt and missing objects,
+// the links to missing objects, and dangling (or, with unreachable, all unreachable)
+// objects. With quarantine, loose objects whose contents do not match their id are moved to
+// objects/quarantine. It reports whether no object is corrupt or missing.
+func Fsck(unreachable bool, quarantine bool) (bool, error) {
+	repo, err := findRepo()
+	if err != nil {
+		return false, fmt.Errorf("error finding repo: %w", err)
+	}
+
+	report, err := fsck.Check(repo)
+	if err != nil {
+		return false, err
+	}
+
+	fmt.Printf("Checked %d objects\n", report.Checked)
+	for _, problem := range report.Corrupt {
+		fmt.Printf("error in %s: %v\n", problem.Entry, problem.Err)
+
+		var corruptErr *object.CorruptObjectError
+		if !quarantine || problem.Type == "pack" || !errors.As(problem.Err, &corruptErr) {
+			continue
+		}
+		if path, err := object.QuarantineObject(repo.Directory, problem.ID); err != nil {
+			fmt.Printf("cannot quarantine %s: %v\n", problem.ID, err)
+		} else {
+			fmt.Printf("quarantined %s to %s\n", problem.ID, path)
+		}
+	}
+	for _, link := range report.BrokenLinks {
+		fmt.Printf("broken link from %s\n              to %s\n", link.From, link.To)
+	}
+	for _, entry := range report.Missing {
+		fmt.Printf("missing %s\n", entry)
+	}
+
+	if unreachable {
+		for _, entry := range report.Unreachable {
+			fmt.Printf("unreachable %s\n", entry)
+		}
+	} else {
+		for _, entry := range report.Dangling {
+			fmt.Printf("dangling %s\n", entry)
+		}
+	}
+
+	return report.OK(), nil
+}
@@ -0,0 +1,86 @@
+package cmd
+
+import (
+	"fmt"
+	"orf/gc"
+	"orf/object"
+	"orf/repository"
+	"time"
+)
+
+// GC expires old reflog entries, packs refs and reachable objects, and prunes unreachable loose
+// objects older than gc.pruneExpire (or pruneExpire when set).
+func GC(pruneExpire string) error {
+	repo, err := findRepo()
+	if err != nil {
+		return fmt.Errorf("error finding repo: %w", err)
+	}
+
+	opts, err := gcOptions(repo, pruneExpire)
+	if err != nil {
+		return err
+	}
+
+	result, err := gc.Run(repo, opts)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Expired %d reflog entries, packed %d refs and %d objects, pruned %d objects\n",
+		result.ExpiredEntries, result.PackedRefs, result.Packed, len(result.Pruned))
+	if opts.WriteBitmaps {
+		fmt.Printf("Wrote reachability bitmaps for %d commits\n", result.Bitmaps)
+	}
+	if opts.WriteCommitGraph {
+		fmt.Printf("Wrote %d commits to the commit-graph\n", result.CommitGraph)
+	}
+	return nil
+}
+
+// Prune deletes the unreachable loose objects older than gc.pruneExpire (or expire when set).
+// With dryRun, the objects are only listed.
+func Prune(expire string, dryRun bool) error {
+	repo, err := findRepo()
+	if err != nil {
+		return fmt.Errorf("error finding repo: %w", err)
+	}
+
+	opts, err := gcOptions(repo, expire)
+	if err != nil {
+		return err
+	}
+	opts.DryRun = dryRun
+
+	pruned, err := gc.Prune(repo, opts)
+	if err != nil {
+		return err
+	}
+
+	if dryRun {
+		for _, id := range pruned {
+			format := "unknown"
+			if obj, err := object.ReadObject(repo.Directory, id); err == nil {
+				format = obj.GetFormat()
+			}
+			fmt.Printf("%s %s\n", id, format)
+		}
+	}
+	return nil
+}
+
+// gcOptions reads the gc options from the repository config, overriding gc.pruneExpire with
+// pruneExpire when it is set.
+func gcOptions(repo *repository.Repo, pruneExpire string) (gc.Options, error) {
+	now := time.Now()
+	opts, err := gc.OptionsFromConfig(repository.DirectorySettings(repo.Directory).Config, now)
+	if err != nil {
+		return opts, err
+	}
+
+	if pruneExpire != "" {
+		if opts.PruneExpire, err = gc.ParseExpiry(pruneExpire, now); err != nil {
+			return opts, err
+		}
+	}
+	return opts, nil
+}
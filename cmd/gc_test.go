@@ -0,0 +1,56 @@
+package cmd
+
+import (
+	"orf/object"
+	"orf/repository"
+	"os"
+	"testing"
+	"time"
+)
+
+// writeStaleBlob writes an unreachable blob last modified two days ago.
+func writeStaleBlob(t *testing.T, repo *repository.Repo) string {
+	hash, err := object.WriteObject(repo.Directory, object.CreateBlob([]byte("stale\n")))
+	if err != nil {
+		t.Fatalf("WriteObject failed: %v", err)
+	}
+	path := object.LoosePath(repo.Directory, hash)
+	when := time.Now().Add(-48 * time.Hour)
+	if err := os.Chtimes(path, when, when); err != nil {
+		t.Fatalf("Failed to age %s: %v", hash, err)
+	}
+	return path
+}
+
+func TestPruneConfiguredExpiry(t *testing.T) {
+	// Within the default two weeks, the blob is kept
+	repo := createTestRepo(t)
+	commitFiles(t, repo, map[string]string{"a.txt": "a\n"}, "first")
+	path := writeStaleBlob(t, repo)
+	if err := Prune("", false); err != nil {
+		t.Fatalf("Prune failed: %v", err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("Expected the blob to be kept by default: %v", err)
+	}
+
+	// gc.pruneExpire shortens the grace period
+	repo = createTestRepo(t, "[gc]", "\tpruneExpire = 1.day.ago")
+	commitFiles(t, repo, map[string]string{"a.txt": "a\n"}, "first")
+	path = writeStaleBlob(t, repo)
+	if err := Prune("", false); err != nil {
+		t.Fatalf("Prune failed: %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("Expected gc.pruneExpire to prune the blob, got %v", err)
+	}
+
+	// An explicit expiry overrides the setting
+	path = writeStaleBlob(t, repo)
+	if err := Prune("3.days.ago", false); err != nil {
+		t.Fatalf("Prune failed: %v", err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("Expected --expire to keep the blob: %v", err)
+	}
+}
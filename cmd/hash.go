@@ -3,21 +3,28 @@ package cmd
 import (
 	"fmt"
 	"orf/object"
+	"orf/oid"
 	"orf/repository"
 	"os"
 )
 
-func HashObject(path string, format string, store bool) (string, error) {
+func HashObject(path string, format string, store bool) (oid.ObjectID, error) {
+
+	// Objects are named with the repository's object format, if there is a repository
+	repo, err := repository.FindRepo(path, true)
+	if err != nil {
+		return "", err
+	}
 
 	directory := ""
-	if store {
+	algorithm := oid.Default
+	if repo != nil {
+		algorithm = repo.ObjectFormat()
+
 		// If true, store object in .orf
-		repo, err := repository.FindRepo(path, true)
-		if err != nil {
-			return "", err
+		if store {
+			directory = repo.Directory
 		}
-
-		directory = repo.Directory
 	}
 
 	data, err := os.ReadFile(path)
@@ -25,14 +32,16 @@ func HashObject(path string, format string, store bool) (string, error) {
 		return "", fmt.Errorf("error reading file: %v", err)
 	}
 
-	hash, err := GetHash(data, format, directory)
+	hash, err := GetHash(data, format, algorithm, directory)
 	if err != nil {
 		return "", fmt.Errorf("error getting hash: %v", err)
 	}
 	return hash, nil
 }
 
-func GetHash(data []byte, format string, directory string) (string, error) {
+// GetHash returns the id of data as an object of the given format. If a directory is given,
+// the object is also written to it (using that repository's object format).
+func GetHash(data []byte, format string, algorithm oid.Algorithm, directory string) (oid.ObjectID, error) {
 
 	var o object.Object
 	switch format {
@@ -43,6 +52,10 @@ func GetHash(data []byte, format string, directory string) (string, error) {
 		return "", fmt.Errorf("invalid format")
 	}
 
+	if directory == "" {
+		return object.HashObject(algorithm, o), nil
+	}
+
 	hash, err := object.WriteObject(directory, o)
 	if err != nil {
 		return "", fmt.Errorf("error writing object: %v", err)
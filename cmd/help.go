@@ -10,11 +10,107 @@ func Help() {
 
 	green("Usage: orf [command]\n")
 	bold("Available commands:\n")
-	yellow("•  init <path>            Initialize a new repository at the specified path\n")
+	yellow("•  init [flag] <path>     Initialize a new repository at the specified path\n")
+	boldYellow("   Options for init:\n")
+	yellow("    • --object-format <f> Hash algorithm for object ids (sha1, sha256; default sha256)\n")
 	yellow("•  cat <format> <hash>    Display the content of an object with the given hash in the specified format (blob, commit, tag, tree)\n")
+	yellow("•  cat -t|-s|-p <object>  Print the type, size or pretty-printed contents of an object of any type\n")
+	yellow("•  cat --batch|--batch-check  Print \"<id> <type> <size>\" (and with --batch the contents) for each object named on stdin\n")
 	yellow("•  hash [flag] <path>     Compute the hash of the object at the specified path\n")
 	boldYellow("   Options for hash:\n")
 	yellow("    • -w                  Write the object to the object directory\n")
 	yellow("    • --format <format>   Specify the format (i.e. blob, commit, tag, tree)\n")
+	yellow("•  log [flags] [<rev>...] [-- <path>...]  Show commit history (default HEAD)\n")
+	boldYellow("   Options for log:\n")
+	yellow("    • --oneline           Show each commit as its abbreviated id and subject\n")
+	yellow("    • --format=<format>   medium, oneline, dot (Graphviz) or placeholders such as \"%%h %%an %%s\"\n")
+	yellow("    • --graph             Draw the history graph beside the log\n")
+	yellow("    • --decorate          Show the refs pointing at each commit\n")
+	yellow("    • --author/--committer <re>  Show commits by a matching author or committer\n")
+	yellow("    • --grep <re>         Show commits whose message matches\n")
+	yellow("    • -S <string>         Show commits changing the number of occurrences of a string\n")
+	yellow("    • -G <re>             Show commits adding or removing matching lines\n")
+	yellow("    • --follow            With a single path, follow it across renames\n")
+	yellow("    • -- <path>...        Show commits touching the paths; log also takes the rev-list options below\n")
+	yellow("•  diff [flags] [<rev> [<rev>]]  Show changes between the index and the working tree, or a commit and either, or two commits\n")
+	boldYellow("   Options for diff:\n")
+	yellow("    • --cached            Compare a commit (default HEAD) with the index\n")
+	yellow("    • --name-status       Show the status and paths of each change instead of a patch\n")
+	yellow("      Renames and copies follow diff.renames, diff.renameThreshold and diff.renameLimit\n")
+	yellow("•  rev-list [flags] <rev>... [-- <path>...]  List commits reachable from the revisions (A..B, A...B and ^A exclude commits)\n")
+	boldYellow("   Options for rev-list:\n")
+	yellow("    • -n, --max-count <n> Limit the number of commits\n")
+	yellow("    • --skip <count>      Skip a number of commits before listing\n")
+	yellow("    • --since/--until <d> Limit by commit date (unix time, RFC 3339 or YYYY-MM-DD)\n")
+	yellow("    • --topo-order        Show no parents before their children, keeping lines of history together\n")
+	yellow("    • --date-order        Show no parents before their children, otherwise by commit date\n")
+	yellow("    • --reverse           Show commits in reverse order\n")
+	yellow("    • --first-parent      Follow only the first parent of merge commits\n")
+	yellow("    • --author, --committer, --grep, -S, -G and --follow as for log\n")
+	yellow("•  merge-base [flags] <commit>...  Find the best common ancestor of commits\n")
+	boldYellow("   Options for merge-base:\n")
+	yellow("    • --all               Print all best common ancestors\n")
+	yellow("    • --octopus           Find the common ancestors of all the commits\n")
+	yellow("    • --is-ancestor <a> <b>  Exit with status 0 if a is an ancestor of b\n")
+	yellow("    • --fork-point <ref> [<commit>]  Find where commit forked from ref, using its reflog\n")
+	yellow("•  merge [flags] <branch>  Merge a branch into HEAD, fast-forwarding when possible\n")
+	boldYellow("   Options for merge:\n")
+	yellow("    • --no-ff             Create a merge commit even when a fast-forward is possible\n")
+	yellow("    • --squash            Update the index and working tree without committing\n")
+	yellow("    • --abort             Abandon a conflicted merge\n")
+	yellow("    • --continue          Commit a merge once its conflicts are resolved and added\n")
+	yellow("      Conflict markers use merge.conflictStyle (merge or diff3) from the repository config\n")
+	yellow("•  cherry-pick [flags] <commit>...  Apply the changes of commits (or ranges A..B) onto HEAD\n")
+	yellow("•  revert [flags] <commit>...       Commit the reverse of the changes of commits\n")
+	boldYellow("   Options for cherry-pick and revert:\n")
+	yellow("    • -x                  Record the picked commit id in the message (cherry-pick only)\n")
+	yellow("    • -m <parent>         Diff merge commits against this parent (starting from 1)\n")
+	yellow("    • --continue          Commit the resolved conflicts and continue\n")
+	yellow("    • --skip              Drop the current commit and continue\n")
+	yellow("    • --abort             Cancel, restoring HEAD to where it started\n")
+	yellow("•  reset [flags] [<commit>]  Point the current branch at a commit (HEAD by default)\n")
+	boldYellow("   Options for reset:\n")
+	yellow("    • --soft              Only move the branch\n")
+	yellow("    • --mixed             Also reset the index (default)\n")
+	yellow("    • --hard              Also reset the working tree, discarding local changes\n")
+	yellow("    • --keep              Like --hard, but refuse if files that change have local changes\n")
+	yellow("•  reset [<commit>] -- <path>...  Unstage paths, setting their index entries to the commit's version\n")
+	yellow("•  restore [flags] <path>...  Restore working tree files or index entries\n")
+	boldYellow("   Options for restore:\n")
+	yellow("    • --worktree          Restore the working tree files (default), from the index unless --source is given\n")
+	yellow("    • --staged            Restore the index entries, from HEAD unless --source is given\n")
+	yellow("    • --source=<tree>     Restore from a commit or tree\n")
+	yellow("•  stash [push] [-u] [-m <message>]  Set the index and working tree changes aside (-u: untracked files too)\n")
+	yellow("•  stash apply|pop [--index] [<stash>]  Apply a stash (stash@{0} by default); pop also drops it\n")
+	yellow("•  stash list|show|drop|clear [<stash>]  List, summarize, drop or clear stashes\n")
+	yellow("•  rebase [flags] <upstream> [<branch>]  Replay the commits of a branch not in upstream onto it\n")
+	boldYellow("   Options for rebase:\n")
+	yellow("    • --onto <commit>     Replay onto this commit instead of the upstream\n")
+	yellow("    • -i                  Edit the todo list (pick, reword, edit, squash, fixup, drop, exec) first\n")
+	yellow("    • --autosquash        Move fixup! and squash! commits after the commits they name\n")
+	yellow("    • --continue          Commit the resolved conflicts or amended changes and continue\n")
+	yellow("    • --skip              Drop the current commit and continue\n")
+	yellow("    • --abort             Cancel, restoring the original branch\n")
+	yellow("      The editor is $ORF_EDITOR, core.editor, $VISUAL or $EDITOR\n")
+	yellow("•  gc [--prune=<date>]    Expire reflogs, pack refs and reachable objects, and prune old unreachable objects\n")
+	yellow("      Dates are like 2.weeks.ago, now or never; see gc.pruneExpire, gc.reflogExpire and gc.reflogExpireUnreachable\n")
+	yellow("•  prune [--dry-run] [--expire <date>]  Delete unreachable loose objects older than gc.pruneExpire\n")
+	yellow("•  commit-graph write|verify  Record the parents, trees, dates and generations of reachable commits in objects/info/commit-graph\n")
+	yellow("      Speeds up log, merge-base and ancestry checks; gc writes it too (gc.writeCommitGraph), core.commitGraph=false ignores it\n")
+	yellow("      Also stores the paths each commit changes as Bloom filters, so log -- <path> skips the others (commitGraph.changedPaths)\n")
+	yellow("•  multi-pack-index [--bitmap] write|verify  Index the objects of every pack in one lookup table, objects/pack/multi-pack-index\n")
+	yellow("      --bitmap also stores the objects reachable from the ref tips and every 100th commit; gc writes both (repack.writeBitmaps)\n")
+	yellow("      core.multiPackIndex=false and pack.useBitmaps=false ignore them\n")
+	yellow("•  fsck [--unreachable] [--quarantine]  Verify every object and its links; exits non-zero on corrupt or missing objects\n")
+	yellow("      Lists dangling objects (unreachable ones no other object points to), or all unreachable ones\n")
+	yellow("      --quarantine moves loose objects that do not hash to their id to objects/quarantine\n")
+	yellow("•  count-objects [-v] [-H] [--json]  Count loose objects and their disk usage; -v adds packs and garbage files\n")
+	yellow("•  stats [-n <count>] [--json]  Report object totals, the largest blobs, deepest trees, biggest commits and directories\n")
+	yellow("      Commits and directories are sized by the blobs they add to the history\n")
+	yellow("•  checkout --ours|--theirs <path>...  Check out one side of unmerged paths\n")
+	yellow("•  ls-files [-v] [-u]     List the index (-u: conflict stages of unmerged paths)\n")
+	yellow("•  mergetool [--tool <tool>] [<path>...]  Resolve unmerged paths with an external tool (merge.tool, mergetool.<tool>.cmd)\n")
+	yellow("      The command gets $BASE, $LOCAL, $REMOTE and $MERGED; set mergetool.<tool>.trustExitCode to trust its exit status\n")
 	yellow("•  help                   Print all available commands\n")
+	yellow("      Set ORF_TRACE to print object cache (sized by core.objectCacheLimit) and changed-path filter statistics on exit\n")
 }
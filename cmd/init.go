@@ -2,11 +2,17 @@ package cmd
 
 import (
 	"fmt"
+	"orf/oid"
 	"orf/repository"
 )
 
-func Init(path string) error {
-	_, err := repository.CreateRepo(path)
+func Init(path string, objectFormat string) error {
+	format, err := oid.ParseAlgorithm(objectFormat)
+	if err != nil {
+		return fmt.Errorf("error initializing repo: %v", err)
+	}
+
+	_, err = repository.CreateRepoWithFormat(path, format)
 	if err != nil {
 		return fmt.Errorf("error initializing repo: %v", err)
 	}
@@ -2,83 +2,114 @@ package cmd
 
 import (
 	"fmt"
+	"orf/logfmt"
 	"orf/object"
-	"orf/repository"
+	"orf/oid"
+	"orf/revwalk"
 	"strings"
 )
 
-func Log(commit string) error {
-	repo, err := repository.FindRepo(".", true)
+// LogOptions selects the commits log shows and how it prints them.
+type LogOptions struct {
+	// Format is "medium" (the default), "oneline", "dot" for a Graphviz digraph,
+	// or a template of placeholders (see logfmt.Format).
+	Format   string
+	Graph    bool
+	Decorate bool
+	Walk     revwalk.Options
+}
+
+func Log(revisions []string, opts LogOptions) error {
+	repo, err := findRepo()
 	if err != nil {
 		return fmt.Errorf("error finding repo: %w", err)
 	}
 
-	fmt.Println("digraph orflog{")
-	fmt.Println("  node[shape=rect]")
-
-	// Start the log from the commit hash provided in args
-	log(repo, object.FindObject(repo, commit, "commit", false), make(map[string]struct{}))
-
-	fmt.Println("}")
-
-	return nil
-}
-
-func log(repo *repository.Repo, hash string, seen map[string]struct{}) error {
-
-	// If hash already exists, return
-	if _, exists := seen[hash]; exists {
-		return nil
+	if len(revisions) == 0 {
+		revisions = []string{"HEAD"}
 	}
 
-	// Add hash to set
-	seen[hash] = struct{}{}
-
-	commit, err := object.ReadObject(repo.Directory, hash)
+	revRange, err := object.ParseRange(repo, revisions)
 	if err != nil {
-		return fmt.Errorf("error reading commit: %w", err)
+		return err
 	}
 
-	c, ok := commit.(*object.Commit)
-	if !ok {
-		return fmt.Errorf("unexpected type %T for Commit, expected *Commit", commit)
+	// The graph can only be drawn if no commit is shown before its children
+	if opts.Graph && opts.Walk.Sort == revwalk.SortDefault {
+		opts.Walk.Sort = revwalk.SortTopo
 	}
 
-	message, err := extractMessage(c)
+	walker, err := revwalk.New(repo, revRange, opts.Walk)
 	if err != nil {
 		return err
 	}
-	fmt.Printf("  c_%s [label=\"%s: %s\"]\n", hash, hash[:8], message)
 
-	// Ensure the format is commit
-	if string(c.GetFormat()) != "commit" {
-		return fmt.Errorf("no commit object found from hash %v", hash)
+	if opts.Format == "dot" {
+		return logDot(walker)
 	}
 
-	parents, exists := c.GetKVData().Get("parent")
-	if !exists {
-		// Check if initial commit has no parent
-		return nil
+	var decorations map[oid.ObjectID][]string
+	if opts.Decorate || strings.Contains(opts.Format, "%d") || strings.Contains(opts.Format, "%D") {
+		decorations, err = logfmt.Decorations(repo)
+		if err != nil {
+			return err
+		}
 	}
 
-	// Recursively check all parents from commit
-	var parentHashes []string
-	switch v := parents.(type) {
-	case string:
-		parentHashes = []string{v}
-	case []string:
-		parentHashes = v
-	default:
-		return fmt.Errorf("unexpected type for parent: %v", v)
+	var graph *logfmt.Graph
+	if opts.Graph {
+		graph = logfmt.NewGraph()
 	}
 
-	for _, parentHash := range parentHashes {
-		fmt.Printf("  c_%s -> c_%s;\n", hash, parentHash)
-		if err := log(repo, parentHash, seen); err != nil {
+	return walker.ForEach(func(hash oid.ObjectID, c *object.Commit) error {
+		entry := &logfmt.Entry{Hash: hash, Commit: c, Refs: decorations[hash]}
+
+		var output string
+		switch opts.Format {
+		case "", "medium":
+			output = logfmt.Medium(entry, opts.Decorate)
+		case "oneline":
+			output = logfmt.Oneline(entry, opts.Decorate)
+		default:
+			output = logfmt.Format(opts.Format, entry)
+		}
+
+		if graph == nil {
+			fmt.Print(output)
+			return nil
+		}
+
+		lines := strings.Split(strings.TrimSuffix(output, "\n"), "\n")
+		for _, line := range graph.Render(hash, c.Parents(), lines) {
+			fmt.Println(line)
+		}
+		return nil
+	})
+}
+
+// logDot prints the walked history as a Graphviz digraph.
+func logDot(walker *revwalk.Walker) error {
+	fmt.Println("digraph orflog{")
+	fmt.Println("  node[shape=rect]")
+
+	err := walker.ForEach(func(hash oid.ObjectID, c *object.Commit) error {
+		message, err := extractMessage(c)
+		if err != nil {
 			return err
 		}
+		fmt.Printf("  c_%s [label=\"%s: %s\"]\n", hash, hash.Short(), message)
+
+		for _, parentHash := range c.Parents() {
+			fmt.Printf("  c_%s -> c_%s;\n", hash, parentHash)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 
+	fmt.Println("}")
+
 	return nil
 }
 
@@ -3,14 +3,15 @@ package cmd
 import (
 	"fmt"
 	"orf/index"
-	"orf/repository"
 )
 
-func ListFiles(isVerbose bool) error {
+// ListFiles prints the paths in the index. With unmerged, it lists only the conflict
+// entries, as "<mode> <hash> <stage>\t<path>".
+func ListFiles(isVerbose bool, unmerged bool) error {
 
 	// TODO: Implement 8.3 ls-files command
 
-	repo, err := repository.FindRepo(".", false)
+	repo, err := findRepo()
 	if err != nil {
 		return err
 	}
@@ -22,6 +23,13 @@ func ListFiles(isVerbose bool) error {
 	}
 
 	for _, entry := range index.Entries {
+		if unmerged {
+			if entry.FlagStaged != 0 {
+				fmt.Printf("%s %s %d\t%s\n", entryLeaf(entry).Mode, entry.Sha, entry.FlagStaged>>12, entry.Name)
+			}
+			continue
+		}
+
 		// Print entry
 		fmt.Printf("%s\n", entry.Name)
 		if isVerbose {
@@ -3,12 +3,11 @@ package cmd
 import (
 	"fmt"
 	"orf/object"
-	"orf/repository"
 )
 
 func ListRefs() {
 
-	repo, err := repository.FindRepo(".", true)
+	repo, err := findRepo()
 	if err != nil {
 		fmt.Printf("error finding repo: %v", err)
 	}
@@ -0,0 +1,112 @@
+package cmd
+
+import (
+	"fmt"
+	"orf/mergebase"
+	"orf/object"
+	"orf/oid"
+	"orf/repository"
+)
+
+// MergeBase prints the best common ancestor of the given commits (all of them with all).
+// With octopus, it finds the common ancestors of every commit rather than of the first
+// against a merge of the rest.
+func MergeBase(revisions []string, all bool, octopus bool) error {
+	repo, err := findRepo()
+	if err != nil {
+		return fmt.Errorf("error finding repo: %w", err)
+	}
+
+	commits, err := resolveCommits(repo, revisions)
+	if err != nil {
+		return err
+	}
+
+	var bases []oid.ObjectID
+	if octopus {
+		bases, err = mergebase.Octopus(repo, commits...)
+	} else {
+		if len(commits) < 2 {
+			return fmt.Errorf("expected at least two commits")
+		}
+		bases, err = mergebase.All(repo, commits[0], commits[1:]...)
+	}
+	if err != nil {
+		return err
+	}
+
+	if len(bases) == 0 {
+		return fmt.Errorf("no common ancestor")
+	}
+
+	if !all {
+		bases = bases[:1]
+	}
+	for _, base := range bases {
+		fmt.Println(base)
+	}
+
+	return nil
+}
+
+// IsAncestor reports whether the first revision is an ancestor of the second.
+func IsAncestor(ancestor string, descendant string) (bool, error) {
+	repo, err := findRepo()
+	if err != nil {
+		return false, fmt.Errorf("error finding repo: %w", err)
+	}
+
+	commits, err := resolveCommits(repo, []string{ancestor, descendant})
+	if err != nil {
+		return false, err
+	}
+
+	return mergebase.IsAncestor(repo, commits[0], commits[1])
+}
+
+// ForkPoint prints where commit forked from ref, using ref's reflog.
+func ForkPoint(ref string, commit string) error {
+	repo, err := findRepo()
+	if err != nil {
+		return fmt.Errorf("error finding repo: %w", err)
+	}
+
+	commits, err := resolveCommits(repo, []string{commit})
+	if err != nil {
+		return err
+	}
+
+	// Find the full name of the ref, whose reflog is read
+	fullName := ref
+	refs, err := object.ListAllRefs(repo)
+	if err != nil {
+		return err
+	}
+	for _, prefix := range []string{"", "refs/", "refs/heads/", "refs/remotes/"} {
+		if _, ok := refs[prefix+ref]; ok {
+			fullName = prefix + ref
+			break
+		}
+	}
+
+	forkPoint, err := mergebase.ForkPoint(repo, fullName, commits[0])
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(forkPoint)
+	return nil
+}
+
+// resolveCommits resolves each revision to a commit.
+func resolveCommits(repo *repository.Repo, revisions []string) ([]oid.ObjectID, error) {
+	var commits []oid.ObjectID
+	for _, revision := range revisions {
+		hash, err := object.ResolveCommit(repo, revision)
+		if err != nil {
+			return nil, err
+		}
+		commits = append(commits, hash)
+	}
+	return commits, nil
+}
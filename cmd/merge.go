@@ -0,0 +1,266 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"orf/index"
+	"orf/merge"
+	"orf/mergebase"
+	"orf/object"
+	"orf/oid"
+	"orf/repository"
+	"strings"
+	"time"
+)
+
+// MergeOptions controls how Merge records its result. NoFF creates a merge commit even when
+// the branch could be fast-forwarded; Squash updates the index and working tree without
+// committing or recording the merge.
+type MergeOptions struct {
+	NoFF   bool
+	Squash bool
+}
+
+// Merge merges branch into HEAD. If HEAD is an ancestor of branch it is fast-forwarded;
+// otherwise the two are merged recursively and, if there are no conflicts, the result is
+// committed with both as parents. Conflicted paths get conflict markers in the working tree
+// and stage 1, 2 and 3 entries in the index, to be resolved and committed.
+func Merge(branch string, opts MergeOptions) error {
+	repo, err := findRepo()
+	if err != nil {
+		return fmt.Errorf("error finding repo: %w", err)
+	}
+
+	if heads, err := readMergeHeads(repo); err != nil {
+		return err
+	} else if len(heads) > 0 {
+		return fmt.Errorf("you have not concluded your merge (MERGE_HEAD exists)")
+	}
+
+	head, err := object.FindObject(repo, "HEAD", "commit", true)
+	if err != nil {
+		return err
+	}
+	theirs, err := object.ResolveCommit(repo, branch)
+	if err != nil {
+		return err
+	}
+
+	headLeaves, err := commitLeaves(repo, head)
+	if err != nil {
+		return err
+	}
+	idx, err := index.ReadIndex(repo)
+	if err != nil {
+		return err
+	}
+	if err := checkClean(repo, idx, headLeaves); err != nil {
+		return err
+	}
+
+	if upToDate, err := mergebase.IsAncestor(repo, theirs, head); err != nil {
+		return err
+	} else if upToDate {
+		fmt.Println("Already up to date.")
+		return nil
+	}
+
+	config, err := readOrfConfig()
+	if err != nil {
+		return err
+	}
+	author := getUserOrfConfig(config)
+
+	fastForward, err := mergebase.IsAncestor(repo, head, theirs)
+	if err != nil {
+		return err
+	}
+
+	if fastForward && !opts.NoFF && !opts.Squash {
+		fmt.Printf("Updating %s..%s\nFast-forward\n", head.Short(), theirs.Short())
+
+		theirLeaves, err := commitLeaves(repo, theirs)
+		if err != nil {
+			return err
+		}
+		if err := updateWorkTree(repo, headLeaves, theirLeaves, nil); err != nil {
+			return err
+		}
+		if err := writeStateFile(repo, origHeadFile, head.String()+"\n"); err != nil {
+			return err
+		}
+		return object.UpdateRef(repo, "HEAD", theirs, author, fmt.Sprintf("merge %s: Fast-forward", branch))
+	}
+
+	mergeOpts := mergeOptions(repo)
+	mergeOpts.OursLabel = "HEAD"
+	mergeOpts.BaseLabel = "merged common ancestors"
+	mergeOpts.TheirsLabel = branch
+
+	result, err := merge.Commits(repo, head, theirs, mergeOpts)
+	if err != nil {
+		return err
+	}
+
+	if err := updateWorkTree(repo, headLeaves, result.Leaves, result.Conflicts); err != nil {
+		return err
+	}
+	if err := writeStateFile(repo, origHeadFile, head.String()+"\n"); err != nil {
+		return err
+	}
+
+	message := fmt.Sprintf("Merge branch '%s'", branch)
+
+	if opts.Squash {
+		if err := writeStateFile(repo, squashMsgFile, fmt.Sprintf("Squashed commit of branch '%s'\n", branch)); err != nil {
+			return err
+		}
+		if len(result.Conflicts) > 0 {
+			printConflicts(result.Conflicts, branch)
+			return errMergeConflicts
+		}
+		fmt.Println("Squash commit -- not updating HEAD")
+		return nil
+	}
+
+	if len(result.Conflicts) > 0 {
+		if err := writeStateFile(repo, mergeHeadFile, theirs.String()+"\n"); err != nil {
+			return err
+		}
+		if err := writeStateFile(repo, mergeMsgFile, conflictMessage(message, result.Conflicts)); err != nil {
+			return err
+		}
+		printConflicts(result.Conflicts, branch)
+		return errMergeConflicts
+	}
+
+	commit, err := WriteCommit(repo, result.Tree, []oid.ObjectID{head, theirs}, author, time.Now(), message)
+	if err != nil {
+		return err
+	}
+	if err := object.UpdateRef(repo, "HEAD", commit, author, fmt.Sprintf("merge %s: Merge made by the 'recursive' strategy.", branch)); err != nil {
+		return err
+	}
+
+	fmt.Println("Merge made by the 'recursive' strategy.")
+	return nil
+}
+
+// MergeAbort abandons a conflicted merge, restoring the index and working tree to HEAD.
+func MergeAbort() error {
+	repo, err := findRepo()
+	if err != nil {
+		return fmt.Errorf("error finding repo: %w", err)
+	}
+
+	heads, err := readMergeHeads(repo)
+	if err != nil {
+		return err
+	}
+	if len(heads) == 0 {
+		return fmt.Errorf("there is no merge to abort (MERGE_HEAD missing)")
+	}
+
+	if err := resetWorkTree(repo, "HEAD"); err != nil {
+		return err
+	}
+
+	return removeStateFiles(repo, mergeHeadFile, mergeMsgFile)
+}
+
+// MergeContinue commits a merge once its conflicts are resolved and staged.
+func MergeContinue() error {
+	repo, err := findRepo()
+	if err != nil {
+		return fmt.Errorf("error finding repo: %w", err)
+	}
+
+	heads, err := readMergeHeads(repo)
+	if err != nil {
+		return err
+	}
+	if len(heads) == 0 {
+		return fmt.Errorf("there is no merge in progress (MERGE_HEAD missing)")
+	}
+
+	idx, err := index.ReadIndex(repo)
+	if err != nil {
+		return err
+	}
+	for _, entry := range idx.Entries {
+		if entry.FlagStaged != 0 {
+			return fmt.Errorf("you need to resolve your current index first: %s is unmerged", entry.Name)
+		}
+	}
+
+	message, err := readStateFile(repo, mergeMsgFile)
+	if err != nil {
+		return err
+	}
+
+	_, err = commit(repo, stripComments(message))
+	return err
+}
+
+// updateWorkTree checks out the new leaves over the old ones and writes the matching index.
+func updateWorkTree(repo *repository.Repo, old, new map[string]*object.Leaf, conflicts []merge.Conflict) error {
+	if err := checkUntracked(repo, old, new); err != nil {
+		return err
+	}
+	if err := checkoutLeaves(repo, old, new); err != nil {
+		return err
+	}
+
+	idx, err := indexFromLeaves(repo, new, conflicts)
+	if err != nil {
+		return err
+	}
+	return idx.WriteIndex(repo)
+}
+
+// mergeOptions returns the merge settings of a repository: its conflict style and rename
+// detection.
+func mergeOptions(repo *repository.Repo) merge.Options {
+	return merge.OptionsFromConfig(repository.DirectorySettings(repo.Directory).Config)
+}
+
+// errMergeConflicts ends a merge that left conflicts to resolve.
+var errMergeConflicts = errors.New("automatic merge failed; fix conflicts and then commit the result")
+
+// printConflicts prints a line for each conflict. theirs names the side being merged in.
+func printConflicts(conflicts []merge.Conflict, theirs string) {
+	for _, conflict := range conflicts {
+		switch conflict.Type {
+		case merge.BothAdded:
+			fmt.Printf("CONFLICT (add/add): Merge conflict in %s\n", conflict.Path)
+		case merge.DeletedByUs:
+			fmt.Printf("CONFLICT (modify/delete): %s deleted in HEAD and modified in %s. Version %s of %s left in tree.\n", conflict.Path, theirs, theirs, conflict.Path)
+		case merge.DeletedByThem:
+			fmt.Printf("CONFLICT (modify/delete): %s deleted in %s and modified in HEAD. Version HEAD of %s left in tree.\n", conflict.Path, theirs, conflict.Path)
+		default:
+			fmt.Printf("CONFLICT (content): Merge conflict in %s\n", conflict.Path)
+		}
+	}
+}
+
+// conflictMessage prepares the message of a commit resolving conflicts, with the conflicted
+// paths listed in comments.
+func conflictMessage(message string, conflicts []merge.Conflict) string {
+	var output strings.Builder
+	output.WriteString(strings.TrimRight(message, "\n") + "\n\n# Conflicts:\n")
+	for _, conflict := range conflicts {
+		output.WriteString("#\t" + conflict.Path + "\n")
+	}
+	return output.String()
+}
+
+// stripComments removes the lines starting with '#' from a prepared commit message.
+func stripComments(message string) string {
+	var lines []string
+	for _, line := range strings.Split(message, "\n") {
+		if !strings.HasPrefix(line, "#") {
+			lines = append(lines, line)
+		}
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
@@ -0,0 +1,216 @@
+package cmd
+
+import (
+	"errors"
+	"orf/index"
+	"orf/object"
+	"orf/oid"
+	"orf/repository"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// divergedBranches commits base, then topic (as branch "topic") and, if ours is given, a
+// commit on the current branch after resetting it to base.
+func divergedBranches(t *testing.T, repo *repository.Repo, base, topic, ours map[string]string) (oid.ObjectID, oid.ObjectID) {
+	baseCommit := commitFiles(t, repo, base, "base")
+	topicCommit := commitFiles(t, repo, topic, "topic")
+	branch(t, repo, "topic", topicCommit)
+	resetHard(t, baseCommit)
+	if ours != nil {
+		commitFiles(t, repo, ours, "ours")
+	}
+	return baseCommit, topicCommit
+}
+
+func TestMergeFastForward(t *testing.T) {
+	repo := createTestRepo(t)
+	base, topic := divergedBranches(t, repo, map[string]string{"a.txt": "a\n"}, map[string]string{"b.txt": "b\n"}, nil)
+
+	output, err := captureOutput(t, func() error { return Merge("topic", MergeOptions{}) })
+	if err != nil {
+		t.Fatalf("Merge failed: %v", err)
+	}
+	if !strings.Contains(output, "Fast-forward") {
+		t.Errorf("Expected a fast-forward, got %q", output)
+	}
+	if head := resolve(t, repo, "HEAD"); head != topic {
+		t.Errorf("Expected HEAD at %s, got %s", topic, head)
+	}
+	if orig := readState(t, repo, origHeadFile); orig != base.String() {
+		t.Errorf("Expected ORIG_HEAD %s, got %q", base, orig)
+	}
+	if data := readFile(t, repo, "b.txt"); data != "b\n" {
+		t.Errorf("Expected b.txt checked out, got %q", data)
+	}
+}
+
+func TestMergeNoFastForward(t *testing.T) {
+	repo := createTestRepo(t)
+	base, topic := divergedBranches(t, repo, map[string]string{"a.txt": "a\n"}, map[string]string{"b.txt": "b\n"}, nil)
+
+	if _, err := captureOutput(t, func() error { return Merge("topic", MergeOptions{NoFF: true}) }); err != nil {
+		t.Fatalf("Merge failed: %v", err)
+	}
+	head := resolve(t, repo, "HEAD")
+	if p := parents(t, repo, head); len(p) != 2 || p[0] != base || p[1] != topic {
+		t.Errorf("Expected a merge commit of %s and %s, got parents %v", base, topic, p)
+	}
+	if data := readFile(t, repo, "b.txt"); data != "b\n" {
+		t.Errorf("Expected b.txt checked out, got %q", data)
+	}
+}
+
+func TestMergeSquash(t *testing.T) {
+	repo := createTestRepo(t)
+	divergedBranches(t, repo, map[string]string{"a.txt": "a\n"}, map[string]string{"b.txt": "b\n"}, map[string]string{"c.txt": "c\n"})
+	ours := resolve(t, repo, "HEAD")
+
+	if _, err := captureOutput(t, func() error { return Merge("topic", MergeOptions{Squash: true}) }); err != nil {
+		t.Fatalf("Merge failed: %v", err)
+	}
+	if head := resolve(t, repo, "HEAD"); head != ours {
+		t.Errorf("Expected a squash merge to leave HEAD at %s, got %s", ours, head)
+	}
+	if readState(t, repo, mergeHeadFile) != "" {
+		t.Error("Expected a squash merge not to record MERGE_HEAD")
+	}
+	if msg := readState(t, repo, squashMsgFile); !strings.Contains(msg, "topic") {
+		t.Errorf("Expected SQUASH_MSG to name the branch, got %q", msg)
+	}
+	if data := readFile(t, repo, "b.txt"); data != "b\n" {
+		t.Errorf("Expected b.txt checked out, got %q", data)
+	}
+
+	// Committing the squash makes a single-parent commit
+	if _, err := captureOutput(t, func() error { return Commit("squashed") }); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	if p := parents(t, repo, resolve(t, repo, "HEAD")); len(p) != 1 || p[0] != ours {
+		t.Errorf("Expected the squash commit to have parent %s, got %v", ours, p)
+	}
+}
+
+func TestMergeConflicts(t *testing.T) {
+	repo := createTestRepo(t)
+	_, topic := divergedBranches(t, repo, map[string]string{"a.txt": "a\n"}, map[string]string{"a.txt": "theirs\n"}, map[string]string{"a.txt": "ours\n"})
+	ours := resolve(t, repo, "HEAD")
+
+	_, err := captureOutput(t, func() error { return Merge("topic", MergeOptions{}) })
+	if !errors.Is(err, errMergeConflicts) {
+		t.Fatalf("Expected merge conflicts, got %v", err)
+	}
+	if data := readFile(t, repo, "a.txt"); data != "<<<<<<< HEAD:a.txt\nours\n=======\ntheirs\n>>>>>>> topic:a.txt\n" {
+		t.Errorf("Expected conflict markers, got %q", data)
+	}
+	if head := readState(t, repo, mergeHeadFile); head != topic.String() {
+		t.Errorf("Expected MERGE_HEAD %s, got %q", topic, head)
+	}
+	if err := Merge("topic", MergeOptions{}); err == nil {
+		t.Error("Expected a second merge to be refused while one is in progress")
+	}
+	if err := MergeContinue(); err == nil {
+		t.Error("Expected --continue to refuse unmerged paths")
+	}
+
+	// --abort restores HEAD's version
+	if _, err := captureOutput(t, MergeAbort); err != nil {
+		t.Fatalf("MergeAbort failed: %v", err)
+	}
+	if data := readFile(t, repo, "a.txt"); data != "ours\n" {
+		t.Errorf("Expected --abort to restore a.txt, got %q", data)
+	}
+	if readState(t, repo, mergeHeadFile) != "" {
+		t.Error("Expected --abort to remove MERGE_HEAD")
+	}
+
+	// Resolving and continuing commits the merge
+	captureOutput(t, func() error { return Merge("topic", MergeOptions{}) })
+	writeFiles(t, repo, map[string]string{"a.txt": "resolved\n"})
+	stageFiles(t, repo, "a.txt")
+	if _, err := captureOutput(t, MergeContinue); err != nil {
+		t.Fatalf("MergeContinue failed: %v", err)
+	}
+	head := resolve(t, repo, "HEAD")
+	if p := parents(t, repo, head); len(p) != 2 || p[0] != ours || p[1] != topic {
+		t.Errorf("Expected a merge commit of %s and %s, got parents %v", ours, topic, p)
+	}
+	if readState(t, repo, mergeHeadFile) != "" {
+		t.Error("Expected --continue to remove MERGE_HEAD")
+	}
+}
+
+func TestMergeFileDirectory(t *testing.T) {
+	repo := createTestRepo(t)
+	divergedBranches(t, repo, map[string]string{"README": "readme\n"}, map[string]string{"a/x.txt": "nested\n"}, map[string]string{"a": "file\n"})
+
+	_, err := captureOutput(t, func() error { return Merge("topic", MergeOptions{}) })
+	if !errors.Is(err, errMergeConflicts) {
+		t.Fatalf("Expected a file/directory conflict, got %v", err)
+	}
+	if data := readFile(t, repo, "a~HEAD"); data != "file\n" {
+		t.Errorf("Expected our file moved to a~HEAD, got %q", data)
+	}
+	if data := readFile(t, repo, "a/x.txt"); data != "nested\n" {
+		t.Errorf("Expected their directory checked out, got %q", data)
+	}
+
+	idx, err := index.ReadIndex(repo)
+	if err != nil {
+		t.Fatalf("Failed to read index: %v", err)
+	}
+	if unmerged := unmergedPaths(idx); len(unmerged) != 1 || unmerged[0].Path != "a" || unmerged[0].Ours == nil {
+		t.Errorf("Expected our version of a unmerged in the index, got %+v", unmerged)
+	}
+
+	if _, err := captureOutput(t, MergeAbort); err != nil {
+		t.Fatalf("MergeAbort failed: %v", err)
+	}
+	assertFiles(t, "working tree", worktreeFiles(t, repo, "a", "a~HEAD"), map[string]string{"a": "file\n", "a~HEAD": "<missing>"})
+}
+
+func TestMergeConflictStyleSetting(t *testing.T) {
+	repo := createTestRepo(t, "[merge]", "conflictStyle = diff3")
+	divergedBranches(t, repo, map[string]string{"a.txt": "a\n"}, map[string]string{"a.txt": "theirs\n"}, map[string]string{"a.txt": "ours\n"})
+
+	if _, err := captureOutput(t, func() error { return Merge("topic", MergeOptions{}) }); !errors.Is(err, errMergeConflicts) {
+		t.Fatalf("Expected merge conflicts, got %v", err)
+	}
+	if data := readFile(t, repo, "a.txt"); !strings.Contains(data, "||||||| merged common ancestors:a.txt\na\n") {
+		t.Errorf("Expected diff3 conflict markers with merge.conflictStyle = diff3, got %q", data)
+	}
+}
+
+func TestMergeRenames(t *testing.T) {
+	repo := createTestRepo(t)
+	lines := "1\n2\n3\n4\n5\n6\n"
+	divergedBranches(t, repo, map[string]string{"old.txt": lines}, map[string]string{"old.txt": "1\n2\n3\n4\n5\nsix\n"}, map[string]string{"old.txt": "", "new.txt": lines})
+
+	if _, err := captureOutput(t, func() error { return Merge("topic", MergeOptions{}) }); err != nil {
+		t.Fatalf("Merge failed: %v", err)
+	}
+	if data := readFile(t, repo, "new.txt"); data != "1\n2\n3\n4\n5\nsix\n" {
+		t.Errorf("Expected the edit merged into the renamed file, got %q", data)
+	}
+	if data := readFile(t, repo, "old.txt"); data != "<missing>" {
+		t.Errorf("Expected old.txt to stay renamed, got %q", data)
+	}
+}
+
+func TestCheckoutLeavesOutsideWorktree(t *testing.T) {
+	repo := createTestRepo(t)
+	outside := filepath.Join(filepath.Dir(repo.WorkTree), "outside.txt")
+	if err := os.WriteFile(outside, []byte("keep\n"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	old := map[string]*object.Leaf{"../outside.txt": {Mode: []byte("100644"), Path: "../outside.txt"}}
+	if err := checkoutLeaves(repo, old, nil); err == nil {
+		t.Error("Expected removing a path outside the worktree to be refused")
+	}
+	if _, err := os.Stat(outside); err != nil {
+		t.Errorf("Expected the file outside the worktree to be kept: %v", err)
+	}
+}
@@ -0,0 +1,171 @@
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"orf/index"
+	"orf/merge"
+	"orf/object"
+	"orf/repository"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Mergetool runs an external merge tool on each unmerged path (or only the given paths).
+// The tool is named by merge.tool, or by tool if given, and its command line is read from
+// mergetool.<tool>.cmd. The command runs in a shell with $BASE, $LOCAL and $REMOTE naming
+// temporary files holding the three versions and $MERGED naming the file to resolve. A path
+// is staged once the tool succeeds: when mergetool.<tool>.trustExitCode is set, that means
+// exiting with status 0, otherwise the merged file must also have changed. Paths the tool
+// fails on are reported and left unmerged, and the remaining paths are merged still.
+func Mergetool(paths []string, tool string) error {
+	repo, err := findRepo()
+	if err != nil {
+		return fmt.Errorf("error finding repo: %w", err)
+	}
+
+	config := repository.DirectorySettings(repo.Directory).Config
+	if config == nil {
+		return fmt.Errorf("no merge tool configured; set merge.tool")
+	}
+	if tool == "" {
+		tool = config.Section("merge").Key("tool").String()
+	}
+	if tool == "" {
+		return fmt.Errorf("no merge tool configured; set merge.tool")
+	}
+
+	section := config.Section(fmt.Sprintf("mergetool \"%s\"", tool))
+	command := section.Key("cmd").String()
+	if command == "" {
+		return fmt.Errorf("no command configured for merge tool %s; set mergetool.%s.cmd", tool, tool)
+	}
+	trustExitCode, _ := section.Key("trustExitCode").Bool()
+
+	idx, err := index.ReadIndex(repo)
+	if err != nil {
+		return err
+	}
+
+	conflicts := unmergedPaths(idx)
+	if len(paths) > 0 {
+		selected := make(map[string]bool)
+		for _, path := range paths {
+			relpath, err := worktreePath(repo, path)
+			if err != nil {
+				return err
+			}
+			selected[relpath] = true
+		}
+
+		var filtered []merge.Conflict
+		for _, conflict := range conflicts {
+			if selected[conflict.Path] {
+				filtered = append(filtered, conflict)
+			}
+		}
+		conflicts = filtered
+	}
+
+	if len(conflicts) == 0 {
+		fmt.Println("No files need merging")
+		return nil
+	}
+
+	for _, conflict := range conflicts {
+		fmt.Printf("Merging %s (%s)\n", conflict.Path, conflict.Type)
+
+		resolved, err := runMergetool(repo, conflict, command, trustExitCode)
+		if err != nil {
+			return err
+		}
+		if !resolved {
+			continue
+		}
+
+		mode := []byte("100644")
+		if conflict.Ours != nil {
+			mode = conflict.Ours.Mode
+		} else if conflict.Theirs != nil {
+			mode = conflict.Theirs.Mode
+		}
+		if err := stageResolved(repo, idx, conflict.Path, mode); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// runMergetool writes the three versions of a conflicted path next to it, runs the tool
+// and reports whether it resolved the path. When it did not, it says why.
+func runMergetool(repo *repository.Repo, conflict merge.Conflict, command string, trustExitCode bool) (bool, error) {
+	merged := filepath.Join(repo.WorkTree, filepath.FromSlash(conflict.Path))
+	before, _ := os.ReadFile(merged)
+
+	env := os.Environ()
+	for _, version := range []struct {
+		name string
+		leaf *object.Leaf
+	}{{"BASE", conflict.Base}, {"LOCAL", conflict.Ours}, {"REMOTE", conflict.Theirs}} {
+		path, err := writeVersion(repo, merged, version.name, version.leaf)
+		if err != nil {
+			return false, err
+		}
+		defer os.Remove(path)
+
+		env = append(env, version.name+"="+path)
+	}
+	env = append(env, "MERGED="+merged)
+
+	tool := exec.Command("sh", "-c", command)
+	tool.Dir = repo.WorkTree
+	tool.Env = env
+	tool.Stdin = os.Stdin
+	tool.Stdout = os.Stdout
+	tool.Stderr = os.Stderr
+
+	if err := tool.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) {
+			return false, fmt.Errorf("failed to run merge tool: %v", err)
+		}
+		fmt.Printf("merge of %s failed: %v; leaving it unmerged\n", conflict.Path, err)
+		return false, nil
+	}
+
+	if trustExitCode {
+		return true, nil
+	}
+
+	after, _ := os.ReadFile(merged)
+	if bytes.Equal(before, after) {
+		fmt.Printf("%s seems unchanged; leaving it unmerged\n", conflict.Path)
+		return false, nil
+	}
+	return true, nil
+}
+
+// writeVersion writes one version of a conflicted file to a temporary file beside it, e.g.
+// file_BASE_1234.txt. A version that does not exist is written as an empty file.
+func writeVersion(repo *repository.Repo, merged string, name string, leaf *object.Leaf) (string, error) {
+	ext := filepath.Ext(merged)
+	path := fmt.Sprintf("%s_%s_%d%s", strings.TrimSuffix(merged, ext), name, os.Getpid(), ext)
+
+	var data []byte
+	if leaf != nil {
+		obj, err := object.ReadObject(repo.Directory, leaf.Hash)
+		if err != nil {
+			return "", fmt.Errorf("failed to read object %s: %v", leaf.Hash, err)
+		}
+		data = obj.GetData()
+	}
+
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return "", err
+	}
+	return path, nil
+}
@@ -0,0 +1,59 @@
+package cmd
+
+import (
+	"errors"
+	"orf/index"
+	"strings"
+	"testing"
+)
+
+func TestMergetool(t *testing.T) {
+	repo := createTestRepo(t, "[merge]", "tool = take", `[mergetool "take"]`, `cmd = cp "$REMOTE" "$MERGED"`, "trustExitCode = true")
+	divergedBranches(t, repo, map[string]string{"a.txt": "a\n"}, map[string]string{"a.txt": "theirs\n"}, map[string]string{"a.txt": "ours\n"})
+
+	if _, err := captureOutput(t, func() error { return Merge("topic", MergeOptions{}) }); !errors.Is(err, errMergeConflicts) {
+		t.Fatalf("Expected merge conflicts, got %v", err)
+	}
+	if _, err := captureOutput(t, func() error { return Mergetool(nil, "") }); err != nil {
+		t.Fatalf("Mergetool failed: %v", err)
+	}
+
+	if data := readFile(t, repo, "a.txt"); data != "theirs\n" {
+		t.Errorf("Expected the configured tool to resolve a.txt, got %q", data)
+	}
+	idx, err := index.ReadIndex(repo)
+	if err != nil {
+		t.Fatalf("Failed to read index: %v", err)
+	}
+	if conflicts := unmergedPaths(idx); len(conflicts) != 0 {
+		t.Errorf("Expected a.txt staged, got %v", conflicts)
+	}
+}
+
+func TestMergetoolFailure(t *testing.T) {
+	// The tool fails on a.txt, and resolves b.txt
+	repo := createTestRepo(t, "[merge]", "tool = picky", `[mergetool "picky"]`, `cmd = test "$(basename "$MERGED")" != a.txt && cp "$REMOTE" "$MERGED"`, "trustExitCode = true")
+	divergedBranches(t, repo, map[string]string{"a.txt": "a\n", "b.txt": "b\n"}, map[string]string{"a.txt": "theirs\n", "b.txt": "theirs\n"}, map[string]string{"a.txt": "ours\n", "b.txt": "ours\n"})
+
+	if _, err := captureOutput(t, func() error { return Merge("topic", MergeOptions{}) }); !errors.Is(err, errMergeConflicts) {
+		t.Fatalf("Expected merge conflicts, got %v", err)
+	}
+	output, err := captureOutput(t, func() error { return Mergetool(nil, "") })
+	if err != nil {
+		t.Fatalf("Mergetool failed: %v", err)
+	}
+	if !strings.Contains(output, "merge of a.txt failed") {
+		t.Errorf("Expected the failure to be reported, got %q", output)
+	}
+
+	if data := readFile(t, repo, "b.txt"); data != "theirs\n" {
+		t.Errorf("Expected the tool to go on and resolve b.txt, got %q", data)
+	}
+	idx, err := index.ReadIndex(repo)
+	if err != nil {
+		t.Fatalf("Failed to read index: %v", err)
+	}
+	if conflicts := unmergedPaths(idx); len(conflicts) != 1 || conflicts[0].Path != "a.txt" {
+		t.Errorf("Expected only a.txt left unmerged, got %v", conflicts)
+	}
+}
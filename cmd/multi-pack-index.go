@@ -0,0 +1,53 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"orf/bitmap"
+	"orf/pack"
+)
+
+// MultiPackIndexWrite writes the multi-pack-index over every pack and, with bitmaps, the
+// reachability bitmaps of its commits.
+func MultiPackIndexWrite(bitmaps bool) error {
+	repo, err := findRepo()
+	if err != nil {
+		return fmt.Errorf("error finding repo: %w", err)
+	}
+
+	midx, err := pack.WriteMultiPackIndex(repo.Directory)
+	if errors.Is(err, pack.ErrNoMultiPackIndex) {
+		fmt.Println("No packs to index")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Indexed %d objects of %d packs in %s\n", len(midx.IDs()), len(midx.PackNames), midx.Path)
+
+	if bitmaps {
+		count, err := bitmap.Write(repo)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Wrote bitmaps for %d commits to %s\n", count, midx.BitmapPath())
+	}
+	return nil
+}
+
+// MultiPackIndexVerify checks the multi-pack-index against the packs it covers, and its
+// bitmaps against the objects their commits reach.
+func MultiPackIndexVerify() error {
+	repo, err := findRepo()
+	if err != nil {
+		return fmt.Errorf("error finding repo: %w", err)
+	}
+
+	if err := pack.VerifyMultiPackIndex(repo.Directory); err != nil {
+		return err
+	}
+	if err := bitmap.Verify(repo); err != nil && !errors.Is(err, bitmap.ErrNotFound) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,713 @@
+package cmd
+
+import (
+	"fmt"
+	"orf/index"
+	"orf/mergebase"
+	"orf/object"
+	"orf/oid"
+	"orf/repository"
+	"orf/revwalk"
+	"orf/sequencer"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// RebaseOptions control a rebase. Onto is the new base (the upstream by default);
+// Interactive lets the user edit the todo list first, and Autosquash moves "fixup!" and
+// "squash!" commits after the commits they name.
+type RebaseOptions struct {
+	Onto        string
+	Interactive bool
+	Autosquash  bool
+}
+
+// Rebase replays the commits of the current branch that are not in upstream onto upstream
+// (or opts.Onto), then moves the branch to the result. With a branch name, that branch is
+// checked out first.
+func Rebase(upstream string, branch string, opts RebaseOptions) error {
+	repo, err := findRepo()
+	if err != nil {
+		return fmt.Errorf("error finding repo: %w", err)
+	}
+
+	if sequencer.RebaseInProgress(repo) {
+		return fmt.Errorf("a rebase is already in progress; use --continue, --skip or --abort")
+	}
+	if sequencer.InProgress(repo) {
+		return fmt.Errorf("a cherry-pick or revert is in progress; use --continue or --abort first")
+	}
+	if heads, err := readMergeHeads(repo); err != nil {
+		return err
+	} else if len(heads) > 0 {
+		return fmt.Errorf("you have not concluded your merge (MERGE_HEAD exists)")
+	}
+
+	config, err := readOrfConfig()
+	if err != nil {
+		return err
+	}
+	identity := getUserOrfConfig(config)
+
+	head, err := object.FindObject(repo, "HEAD", "commit", true)
+	if err != nil {
+		return err
+	}
+	headLeaves, err := commitLeaves(repo, head)
+	if err != nil {
+		return err
+	}
+	idx, err := index.ReadIndex(repo)
+	if err != nil {
+		return err
+	}
+	if err := checkClean(repo, idx, headLeaves); err != nil {
+		return err
+	}
+
+	if branch != "" {
+		if head, err = switchBranch(repo, branch, headLeaves); err != nil {
+			return err
+		}
+		if headLeaves, err = commitLeaves(repo, head); err != nil {
+			return err
+		}
+	}
+
+	upstreamID, err := object.ResolveCommit(repo, upstream)
+	if err != nil {
+		return err
+	}
+	onto, ontoName := upstreamID, upstream
+	if opts.Onto != "" {
+		if onto, err = object.ResolveCommit(repo, opts.Onto); err != nil {
+			return err
+		}
+		ontoName = opts.Onto
+	}
+
+	steps, err := rebaseSteps(repo, head, upstreamID)
+	if err != nil {
+		return err
+	}
+
+	headName, err := object.SymbolicRef(repo, "HEAD")
+	if err != nil {
+		return err
+	}
+
+	if !opts.Interactive {
+		upToDate, err := rebaseUpToDate(repo, head, onto, steps)
+		if err != nil {
+			return err
+		}
+		if upToDate {
+			fmt.Printf("Current branch %s is up to date.\n", strings.TrimPrefix(headName, "refs/heads/"))
+			return nil
+		}
+	}
+
+	if opts.Autosquash {
+		steps = sequencer.Autosquash(steps)
+	}
+
+	state := &sequencer.RebaseState{
+		HeadName:    headName,
+		OrigHead:    head,
+		Onto:        onto,
+		Todo:        steps,
+		Interactive: opts.Interactive,
+	}
+	if err := state.Write(repo); err != nil {
+		return err
+	}
+
+	if opts.Interactive {
+		if state.Todo, err = editTodo(repo, steps, head, onto); err != nil {
+			sequencer.RemoveRebase(repo)
+			return err
+		}
+		if len(state.Todo) == 0 {
+			fmt.Println("Nothing to do")
+			return sequencer.RemoveRebase(repo)
+		}
+		if err := state.Write(repo); err != nil {
+			return err
+		}
+	}
+
+	if err := writeStateFile(repo, origHeadFile, head.String()+"\n"); err != nil {
+		return err
+	}
+
+	// Start from onto with a detached HEAD; the branch only moves once every step is done
+	ontoLeaves, err := commitLeaves(repo, onto)
+	if err != nil {
+		return err
+	}
+	if err := updateWorkTree(repo, headLeaves, ontoLeaves, nil); err != nil {
+		return err
+	}
+	if err := object.DetachHead(repo, onto, identity, "rebase (start): checkout "+ontoName); err != nil {
+		return err
+	}
+
+	return runRebase(repo, state, identity)
+}
+
+// RebaseContinue commits the resolved conflicts, or the changes made after an edit step
+// stopped, and goes on with the remaining steps.
+func RebaseContinue() error {
+	repo, err := findRepo()
+	if err != nil {
+		return fmt.Errorf("error finding repo: %w", err)
+	}
+
+	state, err := sequencer.ReadRebase(repo)
+	if err != nil {
+		return err
+	}
+
+	config, err := readOrfConfig()
+	if err != nil {
+		return err
+	}
+	identity := getUserOrfConfig(config)
+
+	idx, err := index.ReadIndex(repo)
+	if err != nil {
+		return err
+	}
+	if conflicts := unmergedPaths(idx); len(conflicts) > 0 {
+		return fmt.Errorf("you need to resolve your current index first: %s is unmerged", conflicts[0].Path)
+	}
+	tree, err := TreeFromIndex(repo, idx.Entries)
+	if err != nil {
+		return err
+	}
+
+	head, err := object.FindObject(repo, "HEAD", "commit", true)
+	if err != nil {
+		return err
+	}
+	headTree, err := commitTree(repo, head)
+	if err != nil {
+		return err
+	}
+
+	pending, err := readStateFile(repo, rebaseHeadFile)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case strings.TrimSpace(pending) != "" && len(state.Todo) > 0:
+		// Commit the resolution of the step that stopped
+		message, err := readStateFile(repo, mergeMsgFile)
+		if err != nil {
+			return err
+		}
+		if err := commitRebaseStep(repo, state, tree, stripComments(message), identity); err != nil {
+			return err
+		}
+		step := state.Todo[0]
+		state.Done = append(state.Done, step)
+		state.Todo = state.Todo[1:]
+
+		if step.Action == sequencer.Edit {
+			if err := removeStateFiles(repo, rebaseHeadFile, mergeMsgFile); err != nil {
+				return err
+			}
+			return stopForEdit(repo, state, step)
+		}
+
+	case tree == headTree:
+		// Nothing was changed since the rebase stopped
+
+	case state.Amend != "" && state.Amend == head:
+		// Amend the commit an edit step stopped at with the staged changes
+		current, err := object.ReadCommit(repo, head)
+		if err != nil {
+			return err
+		}
+		author, err := current.Author()
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		committer := fmt.Sprintf("%s %d %s", identity, now.Unix(), now.Format("-0700"))
+
+		hash, err := writeCommit(repo, tree, current.Parents(), author.String(), committer, current.Message())
+		if err != nil {
+			return err
+		}
+		if err := object.DetachHead(repo, hash, identity, "rebase (amend): "+firstLine(current.Message())); err != nil {
+			return err
+		}
+
+	default:
+		return fmt.Errorf("you have staged changes in your working tree; commit them, then run orf rebase --continue")
+	}
+
+	state.Amend = ""
+	if err := removeStateFiles(repo, rebaseHeadFile, mergeMsgFile); err != nil {
+		return err
+	}
+	if err := state.Write(repo); err != nil {
+		return err
+	}
+	return runRebase(repo, state, identity)
+}
+
+// RebaseSkip drops the step the rebase stopped at, discarding its changes, and goes on with
+// the remaining steps.
+func RebaseSkip() error {
+	repo, err := findRepo()
+	if err != nil {
+		return fmt.Errorf("error finding repo: %w", err)
+	}
+
+	state, err := sequencer.ReadRebase(repo)
+	if err != nil {
+		return err
+	}
+
+	config, err := readOrfConfig()
+	if err != nil {
+		return err
+	}
+	identity := getUserOrfConfig(config)
+
+	if err := resetWorkTree(repo, "HEAD"); err != nil {
+		return err
+	}
+
+	// A step that stopped with conflicts is still first in the todo list
+	pending, err := readStateFile(repo, rebaseHeadFile)
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(pending) != "" && len(state.Todo) > 0 {
+		state.Todo = state.Todo[1:]
+	}
+
+	state.Amend = ""
+	if err := removeStateFiles(repo, rebaseHeadFile, mergeMsgFile); err != nil {
+		return err
+	}
+	if err := state.Write(repo); err != nil {
+		return err
+	}
+	return runRebase(repo, state, identity)
+}
+
+// RebaseAbort stops a rebase, returning HEAD, the index and the working tree to where they
+// were before it started.
+func RebaseAbort() error {
+	repo, err := findRepo()
+	if err != nil {
+		return fmt.Errorf("error finding repo: %w", err)
+	}
+
+	state, err := sequencer.ReadRebase(repo)
+	if err != nil {
+		return err
+	}
+
+	config, err := readOrfConfig()
+	if err != nil {
+		return err
+	}
+	identity := getUserOrfConfig(config)
+
+	if err := resetWorkTree(repo, state.OrigHead.String()); err != nil {
+		return err
+	}
+
+	message := "rebase (abort): returning to " + state.OrigHead.String()
+	if state.HeadName != "" {
+		message = "rebase (abort): returning to " + state.HeadName
+	}
+	if err := object.DetachHead(repo, state.OrigHead, identity, message); err != nil {
+		return err
+	}
+	if state.HeadName != "" {
+		if err := object.WriteSymbolicRef(repo, "HEAD", state.HeadName); err != nil {
+			return err
+		}
+	}
+
+	if err := removeStateFiles(repo, rebaseHeadFile, mergeMsgFile); err != nil {
+		return err
+	}
+	return sequencer.RemoveRebase(repo)
+}
+
+// switchBranch checks out a branch before rebasing it and returns its commit. The working
+// tree must already be clean.
+func switchBranch(repo *repository.Repo, branch string, headLeaves map[string]*object.Leaf) (oid.ObjectID, error) {
+	ref := "refs/heads/" + branch
+	target, err := object.ResolveCommit(repo, ref)
+	if err != nil {
+		return "", fmt.Errorf("invalid branch %s: %w", branch, err)
+	}
+
+	leaves, err := commitLeaves(repo, target)
+	if err != nil {
+		return "", err
+	}
+	if err := updateWorkTree(repo, headLeaves, leaves, nil); err != nil {
+		return "", err
+	}
+	if err := object.WriteSymbolicRef(repo, "HEAD", ref); err != nil {
+		return "", err
+	}
+	return target, nil
+}
+
+// rebaseSteps returns a pick step for each commit reachable from head but not from
+// upstream, oldest first. Merge commits are left out, flattening the history.
+func rebaseSteps(repo *repository.Repo, head, upstream oid.ObjectID) ([]sequencer.Step, error) {
+	opts := revwalk.DefaultOptions()
+	opts.Reverse = true
+	walker, err := revwalk.New(repo, &object.Range{Include: []oid.ObjectID{head}, Exclude: []oid.ObjectID{upstream}}, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var steps []sequencer.Step
+	err = walker.ForEach(func(hash oid.ObjectID, commit *object.Commit) error {
+		if len(commit.Parents()) > 1 {
+			return nil
+		}
+		steps = append(steps, sequencer.Step{Action: sequencer.Pick, Commit: hash, Subject: firstLine(commit.Message())})
+		return nil
+	})
+	return steps, err
+}
+
+// rebaseUpToDate reports whether replaying the steps onto onto would recreate head: onto is
+// an ancestor of head and the steps already form a chain starting on it.
+func rebaseUpToDate(repo *repository.Repo, head, onto oid.ObjectID, steps []sequencer.Step) (bool, error) {
+	if head == onto {
+		return true, nil
+	}
+	if isAncestor, err := mergebase.IsAncestor(repo, onto, head); err != nil || !isAncestor {
+		return false, err
+	}
+
+	parent := onto
+	for _, step := range steps {
+		commit, err := object.ReadCommit(repo, step.Commit)
+		if err != nil {
+			return false, err
+		}
+		if parents := commit.Parents(); len(parents) != 1 || parents[0] != parent {
+			return false, nil
+		}
+		parent = step.Commit
+	}
+	return parent == head, nil
+}
+
+// editTodo lets the user edit the todo list of an interactive rebase and returns the
+// edited steps, with abbreviated commit ids resolved.
+func editTodo(repo *repository.Repo, steps []sequencer.Step, head, onto oid.ObjectID) ([]sequencer.Step, error) {
+	var todo strings.Builder
+	for _, step := range steps {
+		step.Commit = oid.ObjectID(step.Commit.Short())
+		todo.WriteString(step.String() + "\n")
+	}
+	fmt.Fprintf(&todo, "\n# Rebase %s..%s onto %s (%d commands)\n", onto.Short(), head.Short(), onto.Short(), len(steps))
+	todo.WriteString(sequencer.TodoHelp)
+
+	path := sequencer.TodoPath(repo)
+	if err := os.WriteFile(path, []byte(todo.String()), 0644); err != nil {
+		return nil, err
+	}
+	if err := editFile(repo, path); err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	edited, err := sequencer.ParseTodo(string(data))
+	if err != nil {
+		return nil, err
+	}
+
+	for i, step := range edited {
+		if step.Action == sequencer.Exec {
+			continue
+		}
+		if step.Action == sequencer.Revert {
+			return nil, fmt.Errorf("invalid todo action %q", step.Action)
+		}
+		if i == 0 && (step.Action == sequencer.Squash || step.Action == sequencer.Fixup) {
+			return nil, fmt.Errorf("cannot '%s' without a previous commit", step.Action)
+		}
+		if edited[i].Commit, err = object.ResolveCommit(repo, step.Commit.String()); err != nil {
+			return nil, err
+		}
+	}
+	return edited, nil
+}
+
+// runRebase runs the remaining steps in order, saving the state after each, then finishes
+// the rebase. It stops at a step with conflicts (which stays first in the todo list), after
+// an edit step, and after a failed exec step.
+func runRebase(repo *repository.Repo, state *sequencer.RebaseState, identity string) error {
+	for len(state.Todo) > 0 {
+		step := state.Todo[0]
+
+		if step.Action == sequencer.Exec {
+			// The command counts as done even if it fails, so --continue goes on after it
+			state.Done = append(state.Done, step)
+			state.Todo = state.Todo[1:]
+			if err := state.Write(repo); err != nil {
+				return err
+			}
+			if err := runExec(repo, step.Command); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := rebaseStep(repo, state, step, identity); err != nil {
+			return err
+		}
+
+		state.Done = append(state.Done, step)
+		state.Todo = state.Todo[1:]
+
+		if step.Action == sequencer.Edit {
+			return stopForEdit(repo, state, step)
+		}
+
+		if err := state.Write(repo); err != nil {
+			return err
+		}
+	}
+
+	return finishRebase(repo, state, identity)
+}
+
+// stopForEdit saves the state after an edit step, recording HEAD as the commit to amend.
+func stopForEdit(repo *repository.Repo, state *sequencer.RebaseState, step sequencer.Step) error {
+	head, err := object.FindObject(repo, "HEAD", "commit", true)
+	if err != nil {
+		return err
+	}
+	state.Amend = head
+	if err := state.Write(repo); err != nil {
+		return err
+	}
+
+	fmt.Printf("Stopped at %s... %s\n", step.Commit.Short(), step.Subject)
+	fmt.Println("You can amend the commit now: make your changes, add them and run orf rebase --continue")
+	return nil
+}
+
+// rebaseStep applies one pick, reword, edit, squash, fixup or drop step onto HEAD. On
+// conflicts, the working tree and index are left to be resolved, with REBASE_HEAD and
+// MERGE_MSG recording the step.
+func rebaseStep(repo *repository.Repo, state *sequencer.RebaseState, step sequencer.Step, identity string) error {
+	if step.Action == sequencer.Drop {
+		return nil
+	}
+
+	head, err := object.FindObject(repo, "HEAD", "commit", true)
+	if err != nil {
+		return err
+	}
+	picked, err := object.ReadCommit(repo, step.Commit)
+	if err != nil {
+		return err
+	}
+
+	var parent oid.ObjectID
+	if parents := picked.Parents(); len(parents) > 0 {
+		parent = parents[0]
+	}
+
+	// A commit whose parent is already HEAD is reused as it is
+	if parent == head && (step.Action == sequencer.Pick || step.Action == sequencer.Edit) {
+		headLeaves, err := commitLeaves(repo, head)
+		if err != nil {
+			return err
+		}
+		pickedLeaves, err := commitLeaves(repo, step.Commit)
+		if err != nil {
+			return err
+		}
+		if err := updateWorkTree(repo, headLeaves, pickedLeaves, nil); err != nil {
+			return err
+		}
+		return object.DetachHead(repo, step.Commit, identity, "rebase: fast-forward")
+	}
+
+	subject := firstLine(picked.Message())
+	label := fmt.Sprintf("%s (%s)", step.Commit.Short(), subject)
+
+	result, err := applyChanges(repo, head, picked, parent, false, label)
+	if err != nil {
+		return err
+	}
+
+	if len(result.Conflicts) > 0 {
+		if err := writeStateFile(repo, rebaseHeadFile, step.Commit.String()+"\n"); err != nil {
+			return err
+		}
+		if err := writeStateFile(repo, mergeMsgFile, conflictMessage(picked.Message(), result.Conflicts)); err != nil {
+			return err
+		}
+		printConflicts(result.Conflicts, label)
+		return fmt.Errorf("could not apply %s... %s; resolve the conflicts, add them and run orf rebase --continue (or --skip, --abort)", step.Commit.Short(), subject)
+	}
+
+	headTree, err := commitTree(repo, head)
+	if err != nil {
+		return err
+	}
+	if result.Tree == headTree && step.Action != sequencer.Squash && step.Action != sequencer.Fixup {
+		fmt.Printf("Skipping %s... %s: its changes are already upstream\n", step.Commit.Short(), subject)
+		return nil
+	}
+
+	if err := commitRebaseStep(repo, state, result.Tree, picked.Message(), identity); err != nil {
+		// Keep the step so --continue can commit it
+		writeStateFile(repo, rebaseHeadFile, step.Commit.String()+"\n")
+		writeStateFile(repo, mergeMsgFile, picked.Message())
+		return err
+	}
+	return nil
+}
+
+// commitRebaseStep commits tree for the first step of the todo list, with the picked
+// commit's author and message. Squash and fixup steps replace HEAD instead of adding to it;
+// a squash combines the messages, and the combined message is edited at the end of the
+// chain of squashes, as is the message of a reword step.
+func commitRebaseStep(repo *repository.Repo, state *sequencer.RebaseState, tree oid.ObjectID, message string, identity string) error {
+	step := state.Todo[0]
+
+	head, err := object.FindObject(repo, "HEAD", "commit", true)
+	if err != nil {
+		return err
+	}
+	picked, err := object.ReadCommit(repo, step.Commit)
+	if err != nil {
+		return err
+	}
+	author, err := picked.Author()
+	if err != nil {
+		return err
+	}
+	parents := []oid.ObjectID{head}
+
+	if step.Action == sequencer.Squash || step.Action == sequencer.Fixup {
+		current, err := object.ReadCommit(repo, head)
+		if err != nil {
+			return err
+		}
+		if author, err = current.Author(); err != nil {
+			return err
+		}
+		parents = current.Parents()
+
+		if step.Action == sequencer.Squash {
+			message = squashMessage(current.Message(), message)
+			state.EditSquash = true
+		} else {
+			message = current.Message()
+		}
+	}
+
+	chainEnds := len(state.Todo) < 2 || (state.Todo[1].Action != sequencer.Squash && state.Todo[1].Action != sequencer.Fixup)
+	if step.Action == sequencer.Reword || (state.EditSquash && chainEnds) {
+		if message, err = editMessage(repo, message); err != nil {
+			return err
+		}
+		state.EditSquash = false
+	}
+
+	now := time.Now()
+	committer := fmt.Sprintf("%s %d %s", identity, now.Unix(), now.Format("-0700"))
+
+	hash, err := writeCommit(repo, tree, parents, author.String(), committer, message)
+	if err != nil {
+		return err
+	}
+	if err := object.DetachHead(repo, hash, identity, fmt.Sprintf("rebase (%s): %s", step.Action, firstLine(message))); err != nil {
+		return err
+	}
+
+	fmt.Printf("[%s] %s\n", hash.Short(), firstLine(message))
+	return nil
+}
+
+// squashMessage combines the message of a commit with that of a commit squashed into it. A
+// "squash! " subject line is dropped, since it only named the target.
+func squashMessage(message string, squashed string) string {
+	if subject, body, _ := strings.Cut(squashed, "\n"); strings.HasPrefix(subject, "squash! ") {
+		squashed = body
+	}
+
+	message = strings.TrimRight(message, "\n")
+	if squashed = strings.TrimSpace(squashed); squashed != "" {
+		message += "\n\n" + squashed
+	}
+	return message + "\n"
+}
+
+// runExec runs the command of an exec step in the working tree.
+func runExec(repo *repository.Repo, command string) error {
+	fmt.Printf("Executing: %s\n", command)
+
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Dir = repo.WorkTree
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("execution failed: %s (%v); fix it, then run orf rebase --continue", command, err)
+	}
+	return nil
+}
+
+// finishRebase moves the rebased branch to HEAD, checks it out again and removes the
+// rebase state.
+func finishRebase(repo *repository.Repo, state *sequencer.RebaseState, identity string) error {
+	head, err := object.FindObject(repo, "HEAD", "commit", true)
+	if err != nil {
+		return err
+	}
+
+	name := "HEAD"
+	if state.HeadName != "" {
+		name = state.HeadName
+		message := fmt.Sprintf("rebase (finish): %s onto %s", state.HeadName, state.Onto)
+		if err := object.UpdateRef(repo, state.HeadName, head, identity, message); err != nil {
+			return err
+		}
+		if err := object.DetachHead(repo, head, identity, "rebase (finish): returning to "+state.HeadName); err != nil {
+			return err
+		}
+		if err := object.WriteSymbolicRef(repo, "HEAD", state.HeadName); err != nil {
+			return err
+		}
+	}
+
+	if err := removeStateFiles(repo, rebaseHeadFile, mergeMsgFile); err != nil {
+		return err
+	}
+	if err := sequencer.RemoveRebase(repo); err != nil {
+		return err
+	}
+
+	fmt.Printf("Successfully rebased and updated %s.\n", name)
+	return nil
+}
@@ -0,0 +1,266 @@
+package cmd
+
+import (
+	"fmt"
+	"orf/object"
+	"orf/oid"
+	"orf/repository"
+	"orf/sequencer"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// todoEditor makes the editor replace rebase todo lists with todo, leaving commit messages
+// as they are.
+func todoEditor(t *testing.T, todo string) {
+	dir := t.TempDir()
+	todoFile := filepath.Join(dir, "todo")
+	if err := os.WriteFile(todoFile, []byte(todo), 0644); err != nil {
+		t.Fatalf("Failed to write todo: %v", err)
+	}
+	script := filepath.Join(dir, "editor")
+	contents := fmt.Sprintf("#!/bin/sh\ncase \"$1\" in\n*git-rebase-todo) cp %q \"$1\" ;;\nesac\n", todoFile)
+	if err := os.WriteFile(script, []byte(contents), 0755); err != nil {
+		t.Fatalf("Failed to write editor: %v", err)
+	}
+	t.Setenv("ORF_EDITOR", script)
+}
+
+// rebaseSetup commits base files, then on branch "upstream" a commit of upstream files, and
+// on the current branch (reset to base) one commit for each of the files of ours.
+func rebaseSetup(t *testing.T, repo *repository.Repo, base, upstream map[string]string, ours ...map[string]string) (oid.ObjectID, []oid.ObjectID) {
+	baseCommit := commitFiles(t, repo, base, "base")
+	upstreamCommit := commitFiles(t, repo, upstream, "upstream")
+	branch(t, repo, "upstream", upstreamCommit)
+	resetHard(t, baseCommit)
+
+	var commits []oid.ObjectID
+	for i, files := range ours {
+		commits = append(commits, commitFiles(t, repo, files, fmt.Sprintf("ours %d", i+1)))
+	}
+	return upstreamCommit, commits
+}
+
+// assertOnBranch checks that HEAD is the master branch again, pointing at a commit whose
+// first-parent chain reaches upstream after count commits.
+func assertOnBranch(t *testing.T, repo *repository.Repo, upstream oid.ObjectID, count int) oid.ObjectID {
+	t.Helper()
+	if ref, err := object.SymbolicRef(repo, "HEAD"); err != nil || ref != "refs/heads/master" {
+		t.Errorf("Expected HEAD to be refs/heads/master again, got %q (%v)", ref, err)
+	}
+	tip := resolve(t, repo, "HEAD")
+	commit := tip
+	for i := 0; i < count; i++ {
+		p := parents(t, repo, commit)
+		if len(p) != 1 {
+			t.Fatalf("Expected a linear history, got parents %v", p)
+		}
+		commit = p[0]
+	}
+	if commit != upstream {
+		t.Errorf("Expected %d commits on top of %s, reached %s", count, upstream, commit)
+	}
+	if sequencer.RebaseInProgress(repo) {
+		t.Error("Expected the rebase to be finished")
+	}
+	return tip
+}
+
+func TestRebase(t *testing.T) {
+	repo := createTestRepo(t)
+	upstream, ours := rebaseSetup(t, repo, map[string]string{"a.txt": "a\n"}, map[string]string{"u.txt": "u\n"},
+		map[string]string{"b.txt": "b\n"}, map[string]string{"c.txt": "c\n"})
+
+	if _, err := captureOutput(t, func() error { return Rebase("upstream", "", RebaseOptions{}) }); err != nil {
+		t.Fatalf("Rebase failed: %v", err)
+	}
+
+	tip := assertOnBranch(t, repo, upstream, 2)
+	if message := commitMessage(t, repo, tip); message != "ours 2\n" {
+		t.Errorf("Expected the commits replayed with their messages, got %q", message)
+	}
+	if orig := readState(t, repo, origHeadFile); orig != ours[1].String() {
+		t.Errorf("Expected ORIG_HEAD %s, got %q", ours[1], orig)
+	}
+	for path, expected := range map[string]string{"a.txt": "a\n", "u.txt": "u\n", "b.txt": "b\n", "c.txt": "c\n"} {
+		if data := readFile(t, repo, path); data != expected {
+			t.Errorf("Expected %s = %q, got %q", path, expected, data)
+		}
+	}
+
+	// Rebasing again has nothing to do
+	output, err := captureOutput(t, func() error { return Rebase("upstream", "", RebaseOptions{}) })
+	if err != nil || !strings.Contains(output, "up to date") {
+		t.Errorf("Expected the branch to be up to date, got %q (%v)", output, err)
+	}
+}
+
+func TestRebaseContinue(t *testing.T) {
+	repo := createTestRepo(t)
+	upstream, ours := rebaseSetup(t, repo, map[string]string{"a.txt": "a\n"}, map[string]string{"a.txt": "upstream\n"},
+		map[string]string{"a.txt": "ours\n"}, map[string]string{"b.txt": "b\n"})
+
+	if _, err := captureOutput(t, func() error { return Rebase("upstream", "", RebaseOptions{}) }); err == nil {
+		t.Fatal("Expected the rebase to stop at a conflict")
+	}
+	if pending := readState(t, repo, rebaseHeadFile); pending != ours[0].String() {
+		t.Errorf("Expected REBASE_HEAD %s, got %q", ours[0], pending)
+	}
+	if !sequencer.RebaseInProgress(repo) {
+		t.Fatal("Expected the rebase to be in progress")
+	}
+	if _, err := captureOutput(t, func() error { return Rebase("upstream", "", RebaseOptions{}) }); err == nil {
+		t.Error("Expected a second rebase to be refused")
+	}
+	if _, err := captureOutput(t, RebaseContinue); err == nil {
+		t.Error("Expected --continue to refuse unmerged paths")
+	}
+
+	writeFiles(t, repo, map[string]string{"a.txt": "resolved\n"})
+	stageFiles(t, repo, "a.txt")
+	if _, err := captureOutput(t, RebaseContinue); err != nil {
+		t.Fatalf("RebaseContinue failed: %v", err)
+	}
+
+	tip := assertOnBranch(t, repo, upstream, 2)
+	if message := commitMessage(t, repo, parents(t, repo, tip)[0]); message != "ours 1\n" {
+		t.Errorf("Expected the resolution committed with the original message, got %q", message)
+	}
+	if data := readFile(t, repo, "a.txt"); data != "resolved\n" {
+		t.Errorf("Expected the resolution kept, got %q", data)
+	}
+	if data := readFile(t, repo, "b.txt"); data != "b\n" {
+		t.Errorf("Expected the remaining commit replayed, got %q", data)
+	}
+	if readState(t, repo, rebaseHeadFile) != "" {
+		t.Error("Expected REBASE_HEAD to be removed")
+	}
+}
+
+func TestRebaseSkip(t *testing.T) {
+	repo := createTestRepo(t)
+	upstream, _ := rebaseSetup(t, repo, map[string]string{"a.txt": "a\n"}, map[string]string{"a.txt": "upstream\n"},
+		map[string]string{"a.txt": "ours\n"}, map[string]string{"b.txt": "b\n"})
+
+	captureOutput(t, func() error { return Rebase("upstream", "", RebaseOptions{}) })
+	if _, err := captureOutput(t, RebaseSkip); err != nil {
+		t.Fatalf("RebaseSkip failed: %v", err)
+	}
+
+	assertOnBranch(t, repo, upstream, 1)
+	if data := readFile(t, repo, "a.txt"); data != "upstream\n" {
+		t.Errorf("Expected the skipped commit dropped, got a.txt %q", data)
+	}
+	if data := readFile(t, repo, "b.txt"); data != "b\n" {
+		t.Errorf("Expected the remaining commit replayed, got %q", data)
+	}
+}
+
+func TestRebaseAbort(t *testing.T) {
+	repo := createTestRepo(t)
+	_, ours := rebaseSetup(t, repo, map[string]string{"a.txt": "a\n"}, map[string]string{"a.txt": "upstream\n"},
+		map[string]string{"b.txt": "b\n"}, map[string]string{"a.txt": "ours\n"})
+
+	captureOutput(t, func() error { return Rebase("upstream", "", RebaseOptions{}) })
+	if _, err := captureOutput(t, RebaseAbort); err != nil {
+		t.Fatalf("RebaseAbort failed: %v", err)
+	}
+
+	if ref, err := object.SymbolicRef(repo, "HEAD"); err != nil || ref != "refs/heads/master" {
+		t.Errorf("Expected HEAD to be refs/heads/master again, got %q (%v)", ref, err)
+	}
+	if tip := resolve(t, repo, "HEAD"); tip != ours[1] {
+		t.Errorf("Expected --abort to return to %s, got %s", ours[1], tip)
+	}
+	if data := readFile(t, repo, "a.txt"); data != "ours\n" {
+		t.Errorf("Expected a.txt restored, got %q", data)
+	}
+	if sequencer.RebaseInProgress(repo) || readState(t, repo, rebaseHeadFile) != "" {
+		t.Error("Expected --abort to remove the rebase state")
+	}
+}
+
+func TestRebaseInteractive(t *testing.T) {
+	repo := createTestRepo(t)
+	upstream, ours := rebaseSetup(t, repo, map[string]string{"a.txt": "a\n"}, map[string]string{"u.txt": "u\n"},
+		map[string]string{"b.txt": "b\n"}, map[string]string{"c.txt": "c\n"}, map[string]string{"b.txt": "B\n"},
+		map[string]string{"d.txt": "d\n"}, map[string]string{"e.txt": "e\n"})
+
+	// Abbreviated ids are resolved; the fixup of the third commit keeps the first's message
+	todoEditor(t, fmt.Sprintf("pick %s\nfixup %s\nsquash %s\ndrop %s\nedit %s\n",
+		ours[0].Short(), ours[2], ours[1], ours[3], ours[4]))
+	if _, err := captureOutput(t, func() error { return Rebase("upstream", "", RebaseOptions{Interactive: true}) }); err != nil {
+		t.Fatalf("Rebase failed: %v", err)
+	}
+
+	// Stopped after the edit step
+	if !sequencer.RebaseInProgress(repo) {
+		t.Fatal("Expected the rebase to stop for the edit")
+	}
+	head := resolve(t, repo, "HEAD")
+	squashed := parents(t, repo, head)[0]
+	if p := parents(t, repo, squashed); len(p) != 1 || p[0] != upstream {
+		t.Errorf("Expected the squashed commit on top of upstream, got parents %v", p)
+	}
+	if message := commitMessage(t, repo, squashed); message != "ours 1\n\nours 2\n" {
+		t.Errorf("Expected the squashed messages combined, got %q", message)
+	}
+	for path, expected := range map[string]string{"b.txt": "B\n", "c.txt": "c\n", "d.txt": "<missing>", "e.txt": "e\n"} {
+		if data := readFile(t, repo, path); data != expected {
+			t.Errorf("Expected %s = %q, got %q", path, expected, data)
+		}
+	}
+
+	// Amend the edited commit and finish
+	writeFiles(t, repo, map[string]string{"e.txt": "E\n"})
+	stageFiles(t, repo, "e.txt")
+	if _, err := captureOutput(t, RebaseContinue); err != nil {
+		t.Fatalf("RebaseContinue failed: %v", err)
+	}
+
+	tip := assertOnBranch(t, repo, upstream, 2)
+	if message := commitMessage(t, repo, tip); message != "ours 5\n" {
+		t.Errorf("Expected the amended commit to keep its message, got %q", message)
+	}
+	if p := parents(t, repo, tip); p[0] != squashed {
+		t.Errorf("Expected the amended commit on top of %s, got %v", squashed, p)
+	}
+	if data := readFile(t, repo, "e.txt"); data != "E\n" {
+		t.Errorf("Expected the amendment kept, got %q", data)
+	}
+}
+
+func TestRebaseInteractiveInvalidTodo(t *testing.T) {
+	repo := createTestRepo(t)
+	_, ours := rebaseSetup(t, repo, map[string]string{"a.txt": "a\n"}, map[string]string{"u.txt": "u\n"},
+		map[string]string{"b.txt": "b\n"})
+
+	for _, todo := range []string{
+		"squash " + ours[0].String() + "\n",
+		"revert " + ours[0].String() + "\n",
+		"pick nosuchcommit\n",
+		"frobnicate " + ours[0].String() + "\n",
+	} {
+		todoEditor(t, todo)
+		if _, err := captureOutput(t, func() error { return Rebase("upstream", "", RebaseOptions{Interactive: true}) }); err == nil {
+			t.Errorf("Expected todo %q to be refused", todo)
+		}
+		if sequencer.RebaseInProgress(repo) {
+			t.Fatalf("Expected the refused todo %q to leave no rebase in progress", todo)
+		}
+		if tip := resolve(t, repo, "HEAD"); tip != ours[0] {
+			t.Errorf("Expected HEAD left at %s, got %s", ours[0], tip)
+		}
+	}
+
+	// An empty todo list does nothing
+	todoEditor(t, "# nothing\n")
+	if output, err := captureOutput(t, func() error { return Rebase("upstream", "", RebaseOptions{Interactive: true}) }); err != nil || !strings.Contains(output, "Nothing to do") {
+		t.Errorf("Expected nothing to do, got %q (%v)", output, err)
+	}
+	if sequencer.RebaseInProgress(repo) {
+		t.Error("Expected an empty todo list to leave no rebase in progress")
+	}
+}
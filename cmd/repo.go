@@ -0,0 +1,9 @@
+package cmd
+
+import "orf/repository"
+
+// findRepo returns the repository the current directory is in, or an error outside of one.
+// Tests replace it to run commands against a repository of their own.
+var findRepo = func() (*repository.Repo, error) {
+	return repository.FindRepo(".", false)
+}
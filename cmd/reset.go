@@ -0,0 +1,287 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"orf/index"
+	"orf/object"
+	"orf/oid"
+	"orf/repository"
+	"path/filepath"
+)
+
+// ResetMode selects what a reset updates besides the current branch.
+type ResetMode int
+
+const (
+	// ResetMixed also resets the index, keeping the working tree.
+	ResetMixed ResetMode = iota
+	// ResetSoft only moves the branch, keeping the index and working tree.
+	ResetSoft
+	// ResetHard resets the index and the working tree, discarding local changes.
+	ResetHard
+	// ResetKeep resets the index and the files that differ between HEAD and the target,
+	// refusing if any of those files has local changes.
+	ResetKeep
+)
+
+func (mode ResetMode) String() string {
+	switch mode {
+	case ResetSoft:
+		return "soft"
+	case ResetHard:
+		return "hard"
+	case ResetKeep:
+		return "keep"
+	default:
+		return "mixed"
+	}
+}
+
+// Reset points the current branch (or a detached HEAD) at revision, recording the move in
+// the reflog and the previous commit in ORIG_HEAD, then updates the index and working tree
+// according to mode.
+func Reset(revision string, mode ResetMode) error {
+	repo, err := findRepo()
+	if err != nil {
+		return fmt.Errorf("error finding repo: %w", err)
+	}
+
+	target, err := object.ResolveCommit(repo, revision)
+	if err != nil {
+		return err
+	}
+
+	head, err := object.FindObject(repo, "HEAD", "commit", true)
+	if err != nil && !errors.Is(err, object.ErrNotFound) {
+		return err
+	}
+
+	mergeHeads, err := readMergeHeads(repo)
+	if err != nil {
+		return err
+	}
+	if mode == ResetSoft && len(mergeHeads) > 0 {
+		return fmt.Errorf("cannot do a soft reset in the middle of a merge")
+	}
+
+	switch mode {
+	case ResetMixed:
+		err = resetIndex(repo, target)
+	case ResetHard:
+		err = resetWorkTree(repo, target.String())
+	case ResetKeep:
+		err = resetKeep(repo, head, target)
+	}
+	if err != nil {
+		return err
+	}
+
+	config, err := readOrfConfig()
+	if err != nil {
+		return err
+	}
+	if err := object.UpdateRef(repo, "HEAD", target, getUserOrfConfig(config), "reset: moving to "+revision); err != nil {
+		return err
+	}
+	if head != "" {
+		if err := writeStateFile(repo, origHeadFile, head.String()+"\n"); err != nil {
+			return err
+		}
+	}
+
+	// Moving the branch ends any merge or cherry-pick waiting to be committed
+	if mode != ResetSoft {
+		if err := removeStateFiles(repo, mergeHeadFile, mergeMsgFile, squashMsgFile, cherryPickHeadFile, revertHeadFile); err != nil {
+			return err
+		}
+	}
+
+	if mode == ResetHard {
+		c, err := object.ReadCommit(repo, target)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("HEAD is now at %s %s\n", target.Short(), firstLine(c.Message()))
+	}
+	return nil
+}
+
+// ResetPaths sets the index entries of the given paths (files or directories) to their
+// version in revision, unstaging their changes. Paths not in revision are removed from the
+// index. The working tree and HEAD are left alone.
+func ResetPaths(revision string, paths []string) error {
+	repo, err := findRepo()
+	if err != nil {
+		return fmt.Errorf("error finding repo: %w", err)
+	}
+
+	target, err := object.ResolveCommit(repo, revision)
+	if err != nil {
+		return err
+	}
+	leaves, err := commitLeaves(repo, target)
+	if err != nil {
+		return err
+	}
+
+	idx, err := index.ReadIndex(repo)
+	if err != nil {
+		return err
+	}
+
+	selected, err := matchPaths(repo, paths)
+	if err != nil {
+		return err
+	}
+
+	return resetIndexPaths(repo, idx, leaves, selected)
+}
+
+// resetIndexPaths replaces the index entries of the selected paths, including their conflict
+// stages, with the leaves of those paths, and writes the index.
+func resetIndexPaths(repo *repository.Repo, idx *index.Index, leaves map[string]*object.Leaf, selected func(string) bool) error {
+	var entries []index.IndexEntry
+	for _, entry := range idx.Entries {
+		if !selected(filepath.ToSlash(entry.Name)) {
+			entries = append(entries, entry)
+		}
+	}
+	for path, leaf := range leaves {
+		if !selected(path) {
+			continue
+		}
+		entry, err := resetEntry(repo, path, leaf)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, entry)
+	}
+
+	sortEntries(entries)
+	idx.Entries = entries
+	return idx.WriteIndex(repo)
+}
+
+// resetIndex makes the index match a commit, keeping the working tree. Entries that do not
+// change keep their stat information.
+func resetIndex(repo *repository.Repo, target oid.ObjectID) error {
+	leaves, err := commitLeaves(repo, target)
+	if err != nil {
+		return err
+	}
+	idx, err := index.ReadIndex(repo)
+	if err != nil {
+		return err
+	}
+
+	current := make(map[string]index.IndexEntry)
+	for _, entry := range idx.Entries {
+		if entry.FlagStaged == 0 {
+			current[filepath.ToSlash(entry.Name)] = entry
+		}
+	}
+
+	var entries []index.IndexEntry
+	for path, leaf := range leaves {
+		if entry, ok := current[path]; ok && sameLeaf(entryLeaf(entry), leaf) {
+			entries = append(entries, entry)
+			continue
+		}
+		entry, err := resetEntry(repo, path, leaf)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, entry)
+	}
+
+	sortEntries(entries)
+	idx.Entries = entries
+	return idx.WriteIndex(repo)
+}
+
+// resetKeep moves the index and working tree from head to target like a checkout, only
+// touching the paths that differ between the two. It refuses if any of those paths has
+// staged or unstaged changes, so no local change is lost.
+func resetKeep(repo *repository.Repo, head, target oid.ObjectID) error {
+	headLeaves, err := commitLeaves(repo, head)
+	if err != nil {
+		return err
+	}
+	targetLeaves, err := commitLeaves(repo, target)
+	if err != nil {
+		return err
+	}
+	idx, err := index.ReadIndex(repo)
+	if err != nil {
+		return err
+	}
+	staged := indexLeaves(idx)
+
+	old := make(map[string]*object.Leaf)
+	new := make(map[string]*object.Leaf)
+	changed := make(map[string]bool)
+	for _, leaves := range []map[string]*object.Leaf{headLeaves, targetLeaves} {
+		for path := range leaves {
+			if sameLeaf(headLeaves[path], targetLeaves[path]) {
+				continue
+			}
+			changed[path] = true
+			if leaf, ok := headLeaves[path]; ok {
+				old[path] = leaf
+			}
+			if leaf, ok := targetLeaves[path]; ok {
+				new[path] = leaf
+			}
+		}
+	}
+
+	for path := range changed {
+		if !sameLeaf(staged[path], headLeaves[path]) {
+			return fmt.Errorf("entry '%s' not uptodate; cannot reset with --keep", path)
+		}
+
+		hash, err := worktreeHash(repo, path)
+		if err != nil {
+			return err
+		}
+		if leaf := headLeaves[path]; (leaf == nil && hash != "") || (leaf != nil && hash != leaf.Hash) {
+			return fmt.Errorf("entry '%s' not uptodate; cannot reset with --keep", path)
+		}
+	}
+
+	if err := checkoutLeaves(repo, old, new); err != nil {
+		return err
+	}
+
+	var entries []index.IndexEntry
+	for _, entry := range idx.Entries {
+		if !changed[filepath.ToSlash(entry.Name)] {
+			entries = append(entries, entry)
+		}
+	}
+	for path, leaf := range new {
+		entry, err := leafEntry(repo, path, leaf, 0)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, entry)
+	}
+
+	sortEntries(entries)
+	idx.Entries = entries
+	return idx.WriteIndex(repo)
+}
+
+// resetEntry makes the stage 0 index entry for a leaf. Stat information is only taken from
+// the working tree file when it holds the leaf's contents.
+func resetEntry(repo *repository.Repo, path string, leaf *object.Leaf) (index.IndexEntry, error) {
+	hash, err := worktreeHash(repo, path)
+	if err != nil {
+		return index.IndexEntry{}, err
+	}
+	if hash == leaf.Hash {
+		return leafEntry(repo, path, leaf, 0)
+	}
+	return treeEntry(path, leaf, 0)
+}
@@ -0,0 +1,133 @@
+package cmd
+
+import (
+	"orf/object"
+	"orf/oid"
+	"orf/repository"
+	"testing"
+)
+
+// resetSetup commits a.txt and b.txt, then a commit changing a.txt and adding c.txt. The
+// working tree then gets an unstaged change to b.txt and a staged new file d.txt.
+func resetSetup(t *testing.T, repo *repository.Repo) (oid.ObjectID, oid.ObjectID) {
+	first := commitFiles(t, repo, map[string]string{"a.txt": "1\n", "b.txt": "b\n"}, "first")
+	second := commitFiles(t, repo, map[string]string{"a.txt": "2\n", "c.txt": "c\n"}, "second")
+	writeFiles(t, repo, map[string]string{"b.txt": "local\n", "d.txt": "d\n"})
+	stageFiles(t, repo, "d.txt")
+	return first, second
+}
+
+// assertReset checks that HEAD moved from one commit to another, with ORIG_HEAD and a
+// reflog entry for the branch recording the move.
+func assertReset(t *testing.T, repo *repository.Repo, from, to oid.ObjectID) {
+	t.Helper()
+	if head := resolve(t, repo, "HEAD"); head != to {
+		t.Errorf("Expected HEAD at %s, got %s", to, head)
+	}
+	if orig := readState(t, repo, origHeadFile); orig != from.String() {
+		t.Errorf("Expected ORIG_HEAD %s, got %q", from, orig)
+	}
+
+	entries, err := object.ReadReflog(repo, "refs/heads/master")
+	if err != nil || len(entries) == 0 {
+		t.Fatalf("Expected a reflog for master, got %v (%v)", entries, err)
+	}
+	last := entries[len(entries)-1]
+	if last.Old != from || last.New != to || last.Message != "reset: moving to "+to.String() {
+		t.Errorf("Unexpected reflog entry %s", last.String())
+	}
+}
+
+func TestResetSoft(t *testing.T) {
+	repo := createTestRepo(t)
+	first, second := resetSetup(t, repo)
+
+	if err := Reset(first.String(), ResetSoft); err != nil {
+		t.Fatalf("Reset failed: %v", err)
+	}
+
+	assertReset(t, repo, second, first)
+	assertFiles(t, "index", stagedFiles(t, repo), map[string]string{"a.txt": "2\n", "b.txt": "b\n", "c.txt": "c\n", "d.txt": "d\n"})
+	assertFiles(t, "worktree", worktreeFiles(t, repo, "a.txt", "b.txt", "c.txt", "d.txt"), map[string]string{"a.txt": "2\n", "b.txt": "local\n", "c.txt": "c\n", "d.txt": "d\n"})
+}
+
+func TestResetMixed(t *testing.T) {
+	repo := createTestRepo(t)
+	first, second := resetSetup(t, repo)
+
+	if err := Reset(first.String(), ResetMixed); err != nil {
+		t.Fatalf("Reset failed: %v", err)
+	}
+
+	assertReset(t, repo, second, first)
+	assertFiles(t, "index", stagedFiles(t, repo), map[string]string{"a.txt": "1\n", "b.txt": "b\n", "c.txt": "<missing>", "d.txt": "<missing>"})
+	assertFiles(t, "worktree", worktreeFiles(t, repo, "a.txt", "b.txt", "c.txt", "d.txt"), map[string]string{"a.txt": "2\n", "b.txt": "local\n", "c.txt": "c\n", "d.txt": "d\n"})
+}
+
+func TestResetHard(t *testing.T) {
+	repo := createTestRepo(t)
+	first, second := resetSetup(t, repo)
+
+	if _, err := captureOutput(t, func() error { return Reset(first.String(), ResetHard) }); err != nil {
+		t.Fatalf("Reset failed: %v", err)
+	}
+
+	assertReset(t, repo, second, first)
+	expected := map[string]string{"a.txt": "1\n", "b.txt": "b\n", "c.txt": "<missing>", "d.txt": "<missing>"}
+	assertFiles(t, "index", stagedFiles(t, repo), expected)
+	assertFiles(t, "worktree", worktreeFiles(t, repo, "a.txt", "b.txt", "c.txt", "d.txt"), expected)
+}
+
+func TestResetKeep(t *testing.T) {
+	repo := createTestRepo(t)
+	first, second := resetSetup(t, repo)
+
+	// The local changes are to paths the reset does not touch, so they are kept
+	if err := Reset(first.String(), ResetKeep); err != nil {
+		t.Fatalf("Reset failed: %v", err)
+	}
+
+	assertReset(t, repo, second, first)
+	assertFiles(t, "index", stagedFiles(t, repo), map[string]string{"a.txt": "1\n", "b.txt": "b\n", "c.txt": "<missing>", "d.txt": "d\n"})
+	assertFiles(t, "worktree", worktreeFiles(t, repo, "a.txt", "b.txt", "c.txt", "d.txt"), map[string]string{"a.txt": "1\n", "b.txt": "local\n", "c.txt": "<missing>", "d.txt": "d\n"})
+}
+
+func TestResetKeepRefusesLocalChanges(t *testing.T) {
+	repo := createTestRepo(t)
+	first, second := resetSetup(t, repo)
+
+	// a.txt differs between the commits, so its unstaged change would be lost
+	writeFiles(t, repo, map[string]string{"a.txt": "local\n"})
+	if err := Reset(first.String(), ResetKeep); err == nil {
+		t.Fatal("Expected --keep to refuse overwriting a.txt")
+	}
+	if head := resolve(t, repo, "HEAD"); head != second {
+		t.Errorf("Expected HEAD left at %s, got %s", second, head)
+	}
+	if data := readFile(t, repo, "a.txt"); data != "local\n" {
+		t.Errorf("Expected the local change kept, got %q", data)
+	}
+
+	// So would a staged one
+	stageFiles(t, repo, "a.txt")
+	if err := Reset(first.String(), ResetKeep); err == nil {
+		t.Fatal("Expected --keep to refuse overwriting staged a.txt")
+	}
+	assertFiles(t, "index", stagedFiles(t, repo), map[string]string{"a.txt": "local\n"})
+}
+
+func TestResetPaths(t *testing.T) {
+	repo := createTestRepo(t)
+	first, second := resetSetup(t, repo)
+	stageFiles(t, repo, "b.txt")
+
+	if err := ResetPaths(first.String(), []string{"a.txt", "b.txt", "c.txt"}); err != nil {
+		t.Fatalf("ResetPaths failed: %v", err)
+	}
+
+	if head := resolve(t, repo, "HEAD"); head != second {
+		t.Errorf("Expected HEAD left at %s, got %s", second, head)
+	}
+	assertFiles(t, "index", stagedFiles(t, repo), map[string]string{"a.txt": "1\n", "b.txt": "b\n", "c.txt": "<missing>", "d.txt": "d\n"})
+	assertFiles(t, "worktree", worktreeFiles(t, repo, "a.txt", "b.txt", "c.txt"), map[string]string{"a.txt": "2\n", "b.txt": "local\n", "c.txt": "c\n"})
+}
@@ -0,0 +1,142 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"orf/index"
+	"orf/object"
+	"orf/repository"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// RestoreOptions select what restore rewrites and where from. Staged restores index entries
+// and Worktree restores working tree files; with neither, the working tree is restored.
+// Source is a revision whose tree is restored from; by default the working tree is restored
+// from the index and the index from HEAD.
+type RestoreOptions struct {
+	Staged   bool
+	Worktree bool
+	Source   string
+}
+
+// Restore rewrites the index entries and/or working tree files of the given paths (files
+// or directories) from the source. Paths tracked but missing from the source are removed.
+func Restore(paths []string, opts RestoreOptions) error {
+	repo, err := findRepo()
+	if err != nil {
+		return fmt.Errorf("error finding repo: %w", err)
+	}
+
+	if !opts.Staged && !opts.Worktree {
+		opts.Worktree = true
+	}
+	if opts.Source == "" && opts.Staged {
+		opts.Source = "HEAD"
+	}
+
+	selected, err := matchPaths(repo, paths)
+	if err != nil {
+		return err
+	}
+
+	idx, err := index.ReadIndex(repo)
+	if err != nil {
+		return err
+	}
+	tracked := indexLeaves(idx)
+
+	// Restore from the index, whose unmerged paths have no single version to restore
+	source := tracked
+	if opts.Source != "" {
+		tree, err := object.FindObject(repo, opts.Source, "tree", true)
+		if err != nil {
+			return fmt.Errorf("could not resolve %s: %w", opts.Source, err)
+		}
+		if source, err = object.ReadTreeLeaves(repo, tree); err != nil {
+			return err
+		}
+	}
+
+	var restored []string
+	for path := range source {
+		if selected(path) {
+			restored = append(restored, path)
+		}
+	}
+	var removed []string
+	for path := range tracked {
+		if _, ok := source[path]; !ok && selected(path) {
+			removed = append(removed, path)
+		}
+	}
+	sort.Strings(restored)
+	sort.Strings(removed)
+
+	if len(restored) == 0 && len(removed) == 0 {
+		return fmt.Errorf("pathspec '%s' did not match any file(s) known to orf", paths[0])
+	}
+
+	if opts.Worktree {
+		for _, path := range restored {
+			if source[path].Hash == "" {
+				return fmt.Errorf("path '%s' is unmerged", path)
+			}
+		}
+	}
+
+	if opts.Staged {
+		if err := resetIndexPaths(repo, idx, source, selected); err != nil {
+			return err
+		}
+	}
+
+	if !opts.Worktree {
+		return nil
+	}
+
+	for _, path := range restored {
+		leaf := source[path]
+
+		// Leave files that already hold the right contents alone
+		if hash, err := worktreeHash(repo, path); err != nil {
+			return err
+		} else if hash == leaf.Hash && fileMode(repo, path) == string(leaf.Mode) {
+			continue
+		}
+
+		if err := writeLeaf(repo, path, leaf); err != nil {
+			return err
+		}
+	}
+
+	for _, path := range removed {
+		dest, err := worktreeFile(repo, path)
+		if err != nil {
+			return err
+		}
+		if err := os.Remove(dest); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to remove %s: %v", path, err)
+		}
+		removeEmptyDirs(repo, filepath.Dir(dest))
+	}
+
+	return nil
+}
+
+// fileMode returns the tree mode of a working tree file: 120000 for a symbolic link, 100755
+// for an executable file and 100644 otherwise.
+func fileMode(repo *repository.Repo, path string) string {
+	info, err := os.Lstat(filepath.Join(repo.WorkTree, filepath.FromSlash(path)))
+	switch {
+	case err != nil:
+		return ""
+	case info.Mode()&os.ModeSymlink != 0:
+		return "120000"
+	case info.Mode()&0o111 != 0:
+		return "100755"
+	default:
+		return "100644"
+	}
+}
@@ -0,0 +1,102 @@
+package cmd
+
+import (
+	"orf/oid"
+	"orf/repository"
+	"testing"
+)
+
+// restoreSetup commits a.txt and dir/b.txt, then a commit changing a.txt and adding c.txt.
+// a.txt then gets a staged change and a further unstaged one.
+func restoreSetup(t *testing.T, repo *repository.Repo) oid.ObjectID {
+	first := commitFiles(t, repo, map[string]string{"a.txt": "1\n", "dir/b.txt": "b\n"}, "first")
+	commitFiles(t, repo, map[string]string{"a.txt": "2\n", "c.txt": "c\n"}, "second")
+	writeFiles(t, repo, map[string]string{"a.txt": "staged\n"})
+	stageFiles(t, repo, "a.txt")
+	writeFiles(t, repo, map[string]string{"a.txt": "worktree\n"})
+	return first
+}
+
+func TestRestore(t *testing.T) {
+	tests := []struct {
+		name     string
+		paths    []string
+		opts     RestoreOptions
+		index    map[string]string
+		worktree map[string]string
+	}{
+		{
+			name:     "worktree from the index",
+			paths:    []string{"a.txt", "dir"},
+			opts:     RestoreOptions{},
+			index:    map[string]string{"a.txt": "staged\n", "dir/b.txt": "b\n"},
+			worktree: map[string]string{"a.txt": "staged\n", "dir/b.txt": "b\n"},
+		},
+		{
+			name:     "staged from HEAD",
+			paths:    []string{"a.txt"},
+			opts:     RestoreOptions{Staged: true},
+			index:    map[string]string{"a.txt": "2\n"},
+			worktree: map[string]string{"a.txt": "worktree\n", "dir/b.txt": "changed\n"},
+		},
+		{
+			name:     "staged and worktree from HEAD",
+			paths:    []string{"a.txt", "dir"},
+			opts:     RestoreOptions{Staged: true, Worktree: true},
+			index:    map[string]string{"a.txt": "2\n", "dir/b.txt": "b\n"},
+			worktree: map[string]string{"a.txt": "2\n", "dir/b.txt": "b\n"},
+		},
+		{
+			name:     "worktree from a source",
+			paths:    []string{"a.txt", "c.txt"},
+			opts:     RestoreOptions{Source: "first"},
+			index:    map[string]string{"a.txt": "staged\n", "c.txt": "c\n"},
+			worktree: map[string]string{"a.txt": "1\n", "c.txt": "<missing>"},
+		},
+		{
+			name:     "staged from a source",
+			paths:    []string{"a.txt", "c.txt"},
+			opts:     RestoreOptions{Source: "first", Staged: true},
+			index:    map[string]string{"a.txt": "1\n", "c.txt": "<missing>"},
+			worktree: map[string]string{"a.txt": "worktree\n", "c.txt": "c\n"},
+		},
+		{
+			name:     "staged and worktree from a source",
+			paths:    []string{"a.txt", "c.txt"},
+			opts:     RestoreOptions{Source: "first", Staged: true, Worktree: true},
+			index:    map[string]string{"a.txt": "1\n", "c.txt": "<missing>"},
+			worktree: map[string]string{"a.txt": "1\n", "c.txt": "<missing>"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			repo := createTestRepo(t)
+			first := restoreSetup(t, repo)
+			branch(t, repo, "first", first)
+			writeFiles(t, repo, map[string]string{"dir/b.txt": "changed\n"})
+
+			if err := Restore(test.paths, test.opts); err != nil {
+				t.Fatalf("Restore failed: %v", err)
+			}
+
+			assertFiles(t, "index", stagedFiles(t, repo), test.index)
+			assertFiles(t, "worktree", worktreeFiles(t, repo, "a.txt", "c.txt", "dir/b.txt"), test.worktree)
+		})
+	}
+}
+
+func TestRestoreUnknownPath(t *testing.T) {
+	repo := createTestRepo(t)
+	restoreSetup(t, repo)
+
+	if err := Restore([]string{"missing.txt"}, RestoreOptions{}); err == nil {
+		t.Error("Expected an unknown path to be refused")
+	}
+	if err := Restore([]string{"a.txt"}, RestoreOptions{Source: "nosuchrevision"}); err == nil {
+		t.Error("Expected an unknown source to be refused")
+	}
+	if data := readFile(t, repo, "a.txt"); data != "worktree\n" {
+		t.Errorf("Expected a.txt untouched, got %q", data)
+	}
+}
@@ -0,0 +1,55 @@
+package cmd
+
+import (
+	"fmt"
+	"orf/object"
+	"orf/oid"
+	"orf/revwalk"
+	"strconv"
+	"time"
+)
+
+// RevList prints the hashes of the commits selected by revisions, one per line.
+func RevList(revisions []string, opts revwalk.Options) error {
+	repo, err := findRepo()
+	if err != nil {
+		return fmt.Errorf("error finding repo: %w", err)
+	}
+
+	if len(revisions) == 0 {
+		revisions = []string{"HEAD"}
+	}
+
+	revRange, err := object.ParseRange(repo, revisions)
+	if err != nil {
+		return err
+	}
+
+	walker, err := revwalk.New(repo, revRange, opts)
+	if err != nil {
+		return err
+	}
+
+	return walker.ForEach(func(hash oid.ObjectID, commit *object.Commit) error {
+		fmt.Println(hash)
+		return nil
+	})
+}
+
+// ParseDate parses a --since/--until argument: a unix timestamp, an RFC 3339 time
+// or a YYYY-MM-DD date (midnight local time).
+func ParseDate(value string) (time.Time, error) {
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(seconds, 0), nil
+	}
+
+	if when, err := time.Parse(time.RFC3339, value); err == nil {
+		return when, nil
+	}
+
+	if when, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		return when, nil
+	}
+
+	return time.Time{}, fmt.Errorf("invalid date %s", value)
+}
@@ -3,10 +3,10 @@ package cmd
 import (
 	"fmt"
 	"orf/object"
-	"orf/repository"
+	"strings"
 )
 
-func RevParse(refType string, name string) error {
+func RevParse(name string, refType string) error {
 
 	var format string
 	if refType != "" {
@@ -15,11 +15,32 @@ func RevParse(refType string, name string) error {
 		format = ""
 	}
 
-	repo, err := repository.FindRepo(".", true)
+	repo, err := findRepo()
 	if err != nil {
 		return fmt.Errorf("error finding repo: %v", err)
 	}
 
-	fmt.Print(object.FindObject(repo, name, format, true))
+	// Ranges print each end, with excluded commits prefixed by ^
+	if format == "" && !strings.Contains(name, ":") && (strings.Contains(name, "..") || strings.HasPrefix(name, "^")) {
+		revRange, err := object.ParseRange(repo, []string{name})
+		if err != nil {
+			return err
+		}
+
+		for _, hash := range revRange.Include {
+			fmt.Println(hash)
+		}
+		for _, hash := range revRange.Exclude {
+			fmt.Printf("^%s\n", hash)
+		}
+		return nil
+	}
+
+	hash, err := object.FindObject(repo, name, format, true)
+	if err != nil {
+		return err
+	}
+
+	fmt.Print(hash)
 	return nil
 }
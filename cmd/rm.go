@@ -11,7 +11,7 @@ import (
 )
 
 func Remove(paths []string) error {
-	repo, err := repository.FindRepo(".", false)
+	repo, err := findRepo()
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,607 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"orf/diff"
+	"orf/index"
+	"orf/merge"
+	"orf/object"
+	"orf/oid"
+	"orf/repository"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// stashRef holds the latest stash; its reflog is the stack of older stashes.
+const stashRef = "refs/stash"
+
+// StashOptions control stash push. Message replaces the default "WIP on <branch>: ..."
+// description, and Untracked also stashes (and removes) untracked files.
+type StashOptions struct {
+	Message   string
+	Untracked bool
+}
+
+// StashPush saves the index and the working tree changes as a new stash, then resets the
+// index and working tree to HEAD.
+//
+// A stash is a commit W of the working tree whose parents are HEAD, a commit I of the index
+// and, with untracked files, a root commit U of those files.
+func StashPush(opts StashOptions) error {
+	repo, err := findRepo()
+	if err != nil {
+		return fmt.Errorf("error finding repo: %w", err)
+	}
+
+	head, err := object.FindObject(repo, "HEAD", "commit", true)
+	if errors.Is(err, object.ErrNotFound) {
+		return fmt.Errorf("you do not have the initial commit yet")
+	}
+	if err != nil {
+		return err
+	}
+	headTree, err := commitTree(repo, head)
+	if err != nil {
+		return err
+	}
+
+	idx, err := index.ReadIndex(repo)
+	if err != nil {
+		return err
+	}
+	if conflicts := unmergedPaths(idx); len(conflicts) > 0 {
+		return fmt.Errorf("cannot save the current index state: %s is unmerged", conflicts[0].Path)
+	}
+	indexTree, err := TreeFromIndex(repo, idx.Entries)
+	if err != nil {
+		return err
+	}
+
+	// The working tree version of every tracked file; files missing from it are deleted
+	worktree := make(map[string]*object.Leaf)
+	for path, leaf := range indexLeaves(idx) {
+		if leaf, err = worktreeLeaf(repo, path, leaf); err != nil {
+			return err
+		} else if leaf != nil {
+			worktree[path] = leaf
+		}
+	}
+	worktreeTree, err := object.WriteTreeLeaves(repo, worktree)
+	if err != nil {
+		return err
+	}
+
+	var untracked map[string]*object.Leaf
+	if opts.Untracked {
+		if untracked, err = untrackedLeaves(repo, idx); err != nil {
+			return err
+		}
+	}
+
+	if indexTree == headTree && worktreeTree == headTree && len(untracked) == 0 {
+		fmt.Println("No local changes to save")
+		return nil
+	}
+
+	config, err := readOrfConfig()
+	if err != nil {
+		return err
+	}
+	identity := getUserOrfConfig(config)
+	now := time.Now()
+	signature := fmt.Sprintf("%s %d %s", identity, now.Unix(), now.Format("-0700"))
+
+	headCommit, err := object.ReadCommit(repo, head)
+	if err != nil {
+		return err
+	}
+	branch := "(no branch)"
+	if ref, err := object.SymbolicRef(repo, "HEAD"); err == nil && ref != "" {
+		branch = strings.TrimPrefix(ref, "refs/heads/")
+	}
+	description := fmt.Sprintf("%s: %s %s", branch, head.Short(), firstLine(headCommit.Message()))
+
+	indexCommit, err := writeCommit(repo, indexTree, []oid.ObjectID{head}, signature, signature, "index on "+description)
+	if err != nil {
+		return err
+	}
+	parents := []oid.ObjectID{head, indexCommit}
+
+	if len(untracked) > 0 {
+		untrackedTree, err := object.WriteTreeLeaves(repo, untracked)
+		if err != nil {
+			return err
+		}
+		untrackedCommit, err := writeCommit(repo, untrackedTree, nil, signature, signature, "untracked files on "+description)
+		if err != nil {
+			return err
+		}
+		parents = append(parents, untrackedCommit)
+	}
+
+	message := "WIP on " + description
+	if opts.Message != "" {
+		message = fmt.Sprintf("On %s: %s", branch, opts.Message)
+	}
+	stash, err := writeCommit(repo, worktreeTree, parents, signature, signature, message)
+	if err != nil {
+		return err
+	}
+	if err := object.UpdateRef(repo, stashRef, stash, identity, message); err != nil {
+		return err
+	}
+
+	// Set the changes aside
+	if err := resetWorkTree(repo, "HEAD"); err != nil {
+		return err
+	}
+	for path := range untracked {
+		file := filepath.Join(repo.WorkTree, filepath.FromSlash(path))
+		if err := os.Remove(file); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to remove %s: %v", path, err)
+		}
+		removeEmptyDirs(repo, filepath.Dir(file))
+	}
+
+	fmt.Printf("Saved working directory and index state %s\n", message)
+	return nil
+}
+
+// StashApply applies the changes of a stash (the latest by default) to the working tree,
+// merging them with the current index. Files the stash added are staged. With
+// restoreIndex, the stashed index changes are applied to the index too.
+func StashApply(name string, restoreIndex bool) error {
+	repo, err := findRepo()
+	if err != nil {
+		return fmt.Errorf("error finding repo: %w", err)
+	}
+
+	conflicts, err := applyStash(repo, name, restoreIndex)
+	if err != nil {
+		return err
+	}
+	if len(conflicts) > 0 {
+		printConflicts(conflicts, "Stashed changes")
+		return fmt.Errorf("conflicts applying the stash; resolve them and add the files")
+	}
+	return nil
+}
+
+// StashPop applies a stash like StashApply, then drops it. A stash that applied with
+// conflicts is kept.
+func StashPop(name string, restoreIndex bool) error {
+	repo, err := findRepo()
+	if err != nil {
+		return fmt.Errorf("error finding repo: %w", err)
+	}
+
+	conflicts, err := applyStash(repo, name, restoreIndex)
+	if err != nil {
+		return err
+	}
+	if len(conflicts) > 0 {
+		printConflicts(conflicts, "Stashed changes")
+		return fmt.Errorf("conflicts applying the stash; the stash entry is kept in case you need it again")
+	}
+	return dropStash(repo, name)
+}
+
+// StashList prints the stashes, latest first.
+func StashList() error {
+	repo, err := findRepo()
+	if err != nil {
+		return fmt.Errorf("error finding repo: %w", err)
+	}
+
+	entries, err := object.ReadReflog(repo, stashRef)
+	if err != nil {
+		return err
+	}
+	for n := range entries {
+		fmt.Printf("stash@{%d}: %s\n", n, entries[len(entries)-1-n].Message)
+	}
+	return nil
+}
+
+// StashShow prints a summary of the changes recorded in a stash (the latest by default):
+// the lines added and deleted in each file, relative to the commit it was made on.
+func StashShow(name string) error {
+	repo, err := findRepo()
+	if err != nil {
+		return fmt.Errorf("error finding repo: %w", err)
+	}
+
+	stash, err := readStash(repo, name)
+	if err != nil {
+		return err
+	}
+	base, err := treeToDict(repo, stash.Parents()[0].String())
+	if err != nil {
+		return err
+	}
+	worktree, err := treeToDict(repo, stash.TreeHash().String())
+	if err != nil {
+		return err
+	}
+
+	load := blobLoader(repo)
+	files, insertions, deletions := 0, 0, 0
+	for _, change := range diff.Compare(base, worktree) {
+		var oldLines, newLines []string
+		if change.OldHash != "" {
+			data, err := load(change.OldHash)
+			if err != nil {
+				return err
+			}
+			oldLines = diff.SplitLines(data)
+		}
+		if change.NewHash != "" {
+			data, err := load(change.NewHash)
+			if err != nil {
+				return err
+			}
+			newLines = diff.SplitLines(data)
+		}
+
+		added, deleted := 0, 0
+		for _, edit := range diff.Lines(oldLines, newLines) {
+			switch edit.Op {
+			case diff.Insert:
+				added++
+			case diff.Delete:
+				deleted++
+			}
+		}
+
+		fmt.Printf(" %s | %d %s%s\n", change.Path(), added+deleted, strings.Repeat("+", added), strings.Repeat("-", deleted))
+		files++
+		insertions += added
+		deletions += deleted
+	}
+
+	fmt.Printf(" %d file(s) changed, %d insertion(s)(+), %d deletion(s)(-)\n", files, insertions, deletions)
+	return nil
+}
+
+// StashDrop removes a stash (the latest by default) from the stack.
+func StashDrop(name string) error {
+	repo, err := findRepo()
+	if err != nil {
+		return fmt.Errorf("error finding repo: %w", err)
+	}
+	return dropStash(repo, name)
+}
+
+// StashClear removes every stash.
+func StashClear() error {
+	repo, err := findRepo()
+	if err != nil {
+		return fmt.Errorf("error finding repo: %w", err)
+	}
+	return object.DeleteRef(repo, stashRef)
+}
+
+// stashSelectorRE matches the stash names accepted by stash commands: stash@{<n>} or <n>.
+var stashSelectorRE = regexp.MustCompile(`^(?:stash@\{([0-9]+)\}|([0-9]+))$`)
+
+// stashIndex returns the position of a stash in the stack, 0 being the latest. An empty
+// name is the latest stash.
+func stashIndex(name string) (int, error) {
+	if name == "" {
+		return 0, nil
+	}
+
+	match := stashSelectorRE.FindStringSubmatch(name)
+	if match == nil {
+		return 0, fmt.Errorf("%s is not a valid stash reference", name)
+	}
+	return strconv.Atoi(match[1] + match[2])
+}
+
+// readStash returns the commit of a stash, checking it looks like one.
+func readStash(repo *repository.Repo, name string) (*object.Commit, error) {
+	n, err := stashIndex(name)
+	if err != nil {
+		return nil, err
+	}
+
+	hash, err := object.ResolveCommit(repo, fmt.Sprintf("stash@{%d}", n))
+	if errors.Is(err, object.ErrNotFound) {
+		return nil, fmt.Errorf("stash@{%d} does not exist", n)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	stash, err := object.ReadCommit(repo, hash)
+	if err != nil {
+		return nil, err
+	}
+	if len(stash.Parents()) < 2 {
+		return nil, fmt.Errorf("%s is not a stash-like commit", hash)
+	}
+	return stash, nil
+}
+
+// dropStash removes a stash from the reflog of refs/stash and points refs/stash at the
+// latest remaining one, deleting it when none remain.
+func dropStash(repo *repository.Repo, name string) error {
+	n, err := stashIndex(name)
+	if err != nil {
+		return err
+	}
+
+	entries, err := object.ReadReflog(repo, stashRef)
+	if err != nil {
+		return err
+	}
+	if n >= len(entries) {
+		return fmt.Errorf("stash@{%d} does not exist", n)
+	}
+
+	i := len(entries) - 1 - n
+	dropped := entries[i]
+	entries = append(entries[:i], entries[i+1:]...)
+
+	if len(entries) == 0 {
+		if err := object.DeleteRef(repo, stashRef); err != nil {
+			return err
+		}
+	} else {
+		if err := object.WriteReflog(repo, stashRef, entries); err != nil {
+			return err
+		}
+		ref := filepath.Join(repo.Directory, filepath.FromSlash(stashRef))
+		if err := os.WriteFile(ref, []byte(entries[len(entries)-1].New.String()+"\n"), 0644); err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("Dropped stash@{%d} (%s)\n", n, dropped.New)
+	return nil
+}
+
+// applyStash merges the changes of a stash into the index and working tree and returns the
+// conflicts left to resolve. Nothing is written if a file with local changes, or an
+// untracked file, is in the way.
+func applyStash(repo *repository.Repo, name string, restoreIndex bool) ([]merge.Conflict, error) {
+	stash, err := readStash(repo, name)
+	if err != nil {
+		return nil, err
+	}
+	parents := stash.Parents()
+
+	if heads, err := readMergeHeads(repo); err != nil {
+		return nil, err
+	} else if len(heads) > 0 {
+		return nil, fmt.Errorf("cannot apply a stash in the middle of a merge")
+	}
+
+	idx, err := index.ReadIndex(repo)
+	if err != nil {
+		return nil, err
+	}
+	if conflicts := unmergedPaths(idx); len(conflicts) > 0 {
+		return nil, fmt.Errorf("cannot apply a stash: %s is unmerged", conflicts[0].Path)
+	}
+	ours := indexLeaves(idx)
+	oursTree, err := TreeFromIndex(repo, idx.Entries)
+	if err != nil {
+		return nil, err
+	}
+
+	baseTree, err := commitTree(repo, parents[0])
+	if err != nil {
+		return nil, err
+	}
+
+	mergeOpts := mergeOptions(repo)
+	mergeOpts.BaseLabel, mergeOpts.OursLabel, mergeOpts.TheirsLabel = "Stash base", "Updated upstream", "Stashed changes"
+
+	result, err := merge.Trees(repo, baseTree, oursTree, stash.TreeHash(), mergeOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	// The index to write: the current one, or the current one with the stashed index
+	// changes applied
+	staged := make(map[string]*object.Leaf)
+	for path, leaf := range ours {
+		staged[path] = leaf
+	}
+	if restoreIndex {
+		indexTree, err := commitTree(repo, parents[1])
+		if err != nil {
+			return nil, err
+		}
+		indexResult, err := merge.Trees(repo, baseTree, oursTree, indexTree, mergeOpts)
+		if err != nil {
+			return nil, err
+		}
+		if len(indexResult.Conflicts) > 0 {
+			return nil, fmt.Errorf("conflicts in index; try without --index")
+		}
+		staged = indexResult.Leaves
+	}
+
+	var untracked map[string]*object.Leaf
+	if len(parents) > 2 {
+		if untracked, err = commitLeaves(repo, parents[2]); err != nil {
+			return nil, err
+		}
+	}
+
+	// Only paths the stash changes are written; their working tree files must not have
+	// local changes
+	old := make(map[string]*object.Leaf)
+	new := make(map[string]*object.Leaf)
+	for _, leaves := range []map[string]*object.Leaf{ours, result.Leaves} {
+		for path := range leaves {
+			if sameLeaf(ours[path], result.Leaves[path]) {
+				continue
+			}
+			if leaf, ok := ours[path]; ok {
+				old[path] = leaf
+			}
+			if leaf, ok := result.Leaves[path]; ok {
+				new[path] = leaf
+			}
+		}
+	}
+
+	var dirty []string
+	for path := range old {
+		hash, err := worktreeHash(repo, path)
+		if err != nil {
+			return nil, err
+		}
+		if hash != old[path].Hash {
+			dirty = append(dirty, path)
+		}
+	}
+	if len(dirty) > 0 {
+		sort.Strings(dirty)
+		return nil, fmt.Errorf("your local changes to the following files would be overwritten: %s", strings.Join(dirty, ", "))
+	}
+	if err := checkUntracked(repo, old, new); err != nil {
+		return nil, err
+	}
+	for path := range untracked {
+		if _, err := os.Lstat(filepath.Join(repo.WorkTree, filepath.FromSlash(path))); err == nil {
+			return nil, fmt.Errorf("%s already exists, no checkout", path)
+		}
+	}
+
+	if err := checkoutLeaves(repo, old, new); err != nil {
+		return nil, err
+	}
+	for path, leaf := range untracked {
+		if err := writeLeaf(repo, path, leaf); err != nil {
+			return nil, err
+		}
+	}
+
+	// Files added by the stash are staged, as are conflicts
+	conflicted := make(map[string]bool)
+	for _, conflict := range result.Conflicts {
+		conflicted[conflict.Path] = true
+	}
+	for path, leaf := range new {
+		if _, ok := ours[path]; !ok && !conflicted[path] {
+			staged[path] = leaf
+		}
+	}
+
+	current := make(map[string]index.IndexEntry)
+	for _, entry := range idx.Entries {
+		current[filepath.ToSlash(entry.Name)] = entry
+	}
+
+	var entries []index.IndexEntry
+	for path, leaf := range staged {
+		if conflicted[path] {
+			continue
+		}
+		if entry, ok := current[path]; ok && sameLeaf(entryLeaf(entry), leaf) {
+			entries = append(entries, entry)
+			continue
+		}
+		entry, err := resetEntry(repo, path, leaf)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	for _, conflict := range result.Conflicts {
+		for stage, leaf := range []*object.Leaf{conflict.Base, conflict.Ours, conflict.Theirs} {
+			if leaf == nil {
+				continue
+			}
+			entry, err := treeEntry(conflict.Path, leaf, uint16(stage+1))
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, entry)
+		}
+	}
+
+	sortEntries(entries)
+	idx.Entries = entries
+	if err := idx.WriteIndex(repo); err != nil {
+		return nil, err
+	}
+	return result.Conflicts, nil
+}
+
+// worktreeLeaf returns the working tree version of a tracked file, writing its blob, or nil
+// if the file was deleted. Files that did not change since they were staged reuse the
+// staged leaf.
+func worktreeLeaf(repo *repository.Repo, path string, staged *object.Leaf) (*object.Leaf, error) {
+	hash, err := worktreeHash(repo, path)
+	if err != nil || hash == "" {
+		return nil, err
+	}
+
+	mode := fileMode(repo, path)
+	if hash == staged.Hash && mode == string(staged.Mode) {
+		return staged, nil
+	}
+
+	if hash, err = storeWorktreeFile(repo, path); err != nil {
+		return nil, err
+	}
+	return &object.Leaf{Mode: []byte(mode), Path: path, Hash: hash}, nil
+}
+
+// untrackedLeaves writes a blob for each file of the working tree not in the index and
+// returns them as leaves keyed by path.
+func untrackedLeaves(repo *repository.Repo, idx *index.Index) (map[string]*object.Leaf, error) {
+	tracked := indexLeaves(idx)
+	leaves := make(map[string]*object.Leaf)
+
+	err := filepath.WalkDir(repo.WorkTree, func(file string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if file == repo.Directory {
+			return filepath.SkipDir
+		}
+		if entry.IsDir() {
+			return nil
+		}
+
+		relpath, err := filepath.Rel(repo.WorkTree, file)
+		if err != nil {
+			return err
+		}
+		path := filepath.ToSlash(relpath)
+		if _, ok := tracked[path]; ok {
+			return nil
+		}
+
+		hash, err := storeWorktreeFile(repo, path)
+		if err != nil {
+			return err
+		}
+		leaves[path] = &object.Leaf{Mode: []byte(fileMode(repo, path)), Path: path, Hash: hash}
+		return nil
+	})
+	return leaves, err
+}
+
+// storeWorktreeFile writes the blob of a working tree file to the object store and returns
+// its id.
+func storeWorktreeFile(repo *repository.Repo, path string) (oid.ObjectID, error) {
+	data, err := readWorktreeFile(repo, path)
+	if err != nil {
+		return "", err
+	}
+	return GetHash(data, "blob", repo.ObjectFormat(), repo.Directory)
+}
@@ -0,0 +1,173 @@
+package cmd
+
+import (
+	"orf/object"
+	"orf/repository"
+	"strings"
+	"testing"
+)
+
+// stashList returns the lines StashList prints.
+func stashList(t *testing.T) []string {
+	output, err := captureOutput(t, StashList)
+	if err != nil {
+		t.Fatalf("StashList failed: %v", err)
+	}
+	if output == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimRight(output, "\n"), "\n")
+}
+
+// stash pushes a stash, failing the test on errors.
+func stash(t *testing.T, opts StashOptions) {
+	if _, err := captureOutput(t, func() error { return StashPush(opts) }); err != nil {
+		t.Fatalf("StashPush failed: %v", err)
+	}
+}
+
+// stashSetup commits a.txt and b.txt, then stages a change to a.txt, changes it further in
+// the working tree and deletes b.txt.
+func stashSetup(t *testing.T, repo *repository.Repo) {
+	commitFiles(t, repo, map[string]string{"a.txt": "1\n", "b.txt": "b\n"}, "first")
+	writeFiles(t, repo, map[string]string{"a.txt": "staged\n"})
+	stageFiles(t, repo, "a.txt")
+	writeFiles(t, repo, map[string]string{"a.txt": "worktree\n"})
+	removeFile(t, repo, "b.txt")
+}
+
+func TestStashPushPop(t *testing.T) {
+	repo := createTestRepo(t)
+	stashSetup(t, repo)
+	head := resolve(t, repo, "HEAD")
+
+	stash(t, StashOptions{})
+
+	// The changes are set aside
+	clean := map[string]string{"a.txt": "1\n", "b.txt": "b\n"}
+	assertFiles(t, "index", stagedFiles(t, repo), clean)
+	assertFiles(t, "worktree", worktreeFiles(t, repo, "a.txt", "b.txt"), clean)
+	if list := stashList(t); len(list) != 1 || list[0] != "stash@{0}: WIP on master: "+head.Short()+" first" {
+		t.Errorf("Unexpected stash list %q", list)
+	}
+
+	// The stash is a commit of the working tree on HEAD and a commit of the index
+	stashCommit := resolve(t, repo, "refs/stash")
+	p := parents(t, repo, stashCommit)
+	if len(p) != 2 || p[0] != head {
+		t.Fatalf("Expected the stash to have HEAD and the index as parents, got %v", p)
+	}
+
+	if _, err := captureOutput(t, func() error { return StashPop("", true) }); err != nil {
+		t.Fatalf("StashPop failed: %v", err)
+	}
+	assertFiles(t, "index", stagedFiles(t, repo), map[string]string{"a.txt": "staged\n", "b.txt": "b\n"})
+	assertFiles(t, "worktree", worktreeFiles(t, repo, "a.txt", "b.txt"), map[string]string{"a.txt": "worktree\n", "b.txt": "<missing>"})
+	if list := stashList(t); len(list) != 0 {
+		t.Errorf("Expected pop to drop the stash, got %q", list)
+	}
+}
+
+func TestStashApply(t *testing.T) {
+	repo := createTestRepo(t)
+	stashSetup(t, repo)
+	stash(t, StashOptions{})
+
+	// Without restoring the index, the index keeps HEAD's version of changed files
+	if _, err := captureOutput(t, func() error { return StashApply("", false) }); err != nil {
+		t.Fatalf("StashApply failed: %v", err)
+	}
+	assertFiles(t, "index", stagedFiles(t, repo), map[string]string{"a.txt": "1\n"})
+	assertFiles(t, "worktree", worktreeFiles(t, repo, "a.txt", "b.txt"), map[string]string{"a.txt": "worktree\n", "b.txt": "<missing>"})
+	if list := stashList(t); len(list) != 1 {
+		t.Errorf("Expected apply to keep the stash, got %q", list)
+	}
+
+	// Applying over the same local changes is refused
+	if _, err := captureOutput(t, func() error { return StashApply("", false) }); err == nil {
+		t.Error("Expected applying over local changes to be refused")
+	}
+}
+
+func TestStashStack(t *testing.T) {
+	repo := createTestRepo(t)
+	commitFiles(t, repo, map[string]string{"a.txt": "1\n", "b.txt": "b\n"}, "first")
+
+	writeFiles(t, repo, map[string]string{"a.txt": "one\n"})
+	stash(t, StashOptions{Message: "one"})
+	writeFiles(t, repo, map[string]string{"b.txt": "two\n"})
+	stash(t, StashOptions{Message: "two"})
+
+	if list := stashList(t); len(list) != 2 || list[0] != "stash@{0}: On master: two" || list[1] != "stash@{1}: On master: one" {
+		t.Fatalf("Unexpected stash list %q", list)
+	}
+
+	// The older stash is applied and dropped by name
+	if _, err := captureOutput(t, func() error { return StashApply("stash@{1}", false) }); err != nil {
+		t.Fatalf("StashApply failed: %v", err)
+	}
+	assertFiles(t, "worktree", worktreeFiles(t, repo, "a.txt", "b.txt"), map[string]string{"a.txt": "one\n", "b.txt": "b\n"})
+
+	if _, err := captureOutput(t, func() error { return StashDrop("1") }); err != nil {
+		t.Fatalf("StashDrop failed: %v", err)
+	}
+	if list := stashList(t); len(list) != 1 || list[0] != "stash@{0}: On master: two" {
+		t.Fatalf("Unexpected stash list after drop %q", list)
+	}
+	if _, err := captureOutput(t, func() error { return StashDrop("stash@{1}") }); err == nil {
+		t.Error("Expected dropping a missing stash to fail")
+	}
+
+	if _, err := captureOutput(t, func() error { return StashDrop("") }); err != nil {
+		t.Fatalf("StashDrop failed: %v", err)
+	}
+	if list := stashList(t); len(list) != 0 {
+		t.Errorf("Expected no stashes, got %q", list)
+	}
+	if _, err := object.ResolveRevision(repo, "refs/stash"); err == nil {
+		t.Error("Expected refs/stash to be deleted with the last stash")
+	}
+}
+
+func TestStashUntracked(t *testing.T) {
+	repo := createTestRepo(t)
+	commitFiles(t, repo, map[string]string{"a.txt": "1\n"}, "first")
+	writeFiles(t, repo, map[string]string{"new/u.txt": "u\n"})
+
+	output, err := captureOutput(t, func() error { return StashPush(StashOptions{}) })
+	if err != nil || !strings.Contains(output, "No local changes") {
+		t.Errorf("Expected untracked files alone not to be stashed, got %q (%v)", output, err)
+	}
+
+	stash(t, StashOptions{Untracked: true})
+	if data := readFile(t, repo, "new/u.txt"); data != "<missing>" {
+		t.Errorf("Expected the untracked file set aside, got %q", data)
+	}
+	if _, err := captureOutput(t, func() error { return StashPop("", false) }); err != nil {
+		t.Fatalf("StashPop failed: %v", err)
+	}
+	if data := readFile(t, repo, "new/u.txt"); data != "u\n" {
+		t.Errorf("Expected the untracked file restored, got %q", data)
+	}
+	if _, ok := stagedFiles(t, repo)["new/u.txt"]; ok {
+		t.Error("Expected the restored file to stay untracked")
+	}
+}
+
+func TestStashPopConflicts(t *testing.T) {
+	repo := createTestRepo(t, "[merge]", "conflictStyle = diff3")
+	commitFiles(t, repo, map[string]string{"a.txt": "1\n"}, "first")
+	writeFiles(t, repo, map[string]string{"a.txt": "stashed\n"})
+	stash(t, StashOptions{})
+	commitFiles(t, repo, map[string]string{"a.txt": "committed\n"}, "second")
+
+	if _, err := captureOutput(t, func() error { return StashPop("", false) }); err == nil {
+		t.Fatal("Expected the pop to conflict")
+	}
+	if data := readFile(t, repo, "a.txt"); !strings.Contains(data, "||||||| Stash base:a.txt\n1\n") {
+		t.Errorf("Expected diff3 conflict markers with merge.conflictStyle = diff3, got %q", data)
+	}
+	if list := stashList(t); len(list) != 1 {
+		t.Errorf("Expected a conflicted pop to keep the stash, got %q", list)
+	}
+}
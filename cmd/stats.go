@@ -0,0 +1,58 @@
+package cmd
+
+import (
+	"fmt"
+	"orf/stats"
+)
+
+// Stats prints how many objects of each type the history holds, and the limit largest blobs,
+// deepest trees, commits adding the most and directories with the most history. With
+// jsonOutput the report is printed as JSON, sizes in bytes.
+func Stats(limit int, jsonOutput bool) error {
+	repo, err := findRepo()
+	if err != nil {
+		return fmt.Errorf("error finding repo: %w", err)
+	}
+
+	report, err := stats.Collect(repo, limit)
+	if err != nil {
+		return err
+	}
+
+	if jsonOutput {
+		return printJSON(report)
+	}
+
+	fmt.Println("Objects:")
+	for _, totals := range []struct {
+		name string
+		stats.Totals
+	}{{"commits", report.Commits}, {"trees", report.Trees}, {"blobs", report.Blobs}, {"tags", report.Tags}} {
+		fmt.Printf("  %-8s %8d  %s\n", totals.name, totals.Count, formatSize(totals.Size))
+	}
+
+	fmt.Println("\nLargest blobs:")
+	for _, blob := range report.LargestBlobs {
+		fmt.Printf("  %12s  %s  %s\n", formatSize(blob.Size), blob.ID.Short(), blob.Path)
+	}
+
+	fmt.Println("\nDeepest trees:")
+	for _, tree := range report.DeepestTrees {
+		path := tree.Path
+		if path == "" {
+			path = "(root)"
+		}
+		fmt.Printf("  depth %-4d %s  %s (%d entries)\n", tree.Depth, tree.ID.Short(), path, tree.Entries)
+	}
+
+	fmt.Println("\nBiggest commits:")
+	for _, commit := range report.BiggestCommits {
+		fmt.Printf("  %12s in %4d blobs  %s  %s\n", formatSize(commit.Added), commit.Blobs, commit.ID.Short(), commit.Subject)
+	}
+
+	fmt.Println("\nLargest directories:")
+	for _, directory := range report.Directories {
+		fmt.Printf("  %12s in %4d blobs  %s/\n", formatSize(directory.Size), directory.Blobs, directory.Path)
+	}
+	return nil
+}
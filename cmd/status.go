@@ -1,9 +1,12 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
+	"orf/diff"
 	"orf/index"
 	"orf/object"
+	"orf/oid"
 	"orf/repository"
 
 	"os"
@@ -13,7 +16,7 @@ import (
 )
 
 func Status() error {
-	repo, err := repository.FindRepo(".", false)
+	repo, err := findRepo()
 	if err != nil {
 		return err
 	}
@@ -24,6 +27,7 @@ func Status() error {
 	}
 
 	fmt.Printf(GetBranch(repo))
+	printUnmerged(index)
 	printIndexHead(repo, index)
 	printIndexWorkTree(repo, index)
 
@@ -61,32 +65,69 @@ func GetBranch(repo *repository.Repo) (string, error) {
 	return "", fmt.Errorf("no active branch found")
 }
 
+// printUnmerged lists the paths with conflicts from a merge, by type of conflict.
+func printUnmerged(index *index.Index) {
+	conflicts := unmergedPaths(index)
+	if len(conflicts) == 0 {
+		return
+	}
+
+	fmt.Println("Unmerged paths:")
+	for _, conflict := range conflicts {
+		fmt.Printf("  (%s) %s\n", conflict.Type, conflict.Path)
+	}
+}
+
 func printIndexHead(repo *repository.Repo, index *index.Index) error {
 	fmt.Println("Changes to be committed:")
-	head, err := treeToDict(repo, "HEAD", "")
+	head, err := treeToDict(repo, "HEAD")
+
+	// Before the first commit, everything in the index is new
+	if errors.Is(err, object.ErrNotFound) {
+		head, err = map[string]oid.ObjectID{}, nil
+	}
 
 	if err != nil {
 		return err
 	}
 
+	staged := make(map[string]oid.ObjectID)
 	for _, entry := range index.Entries {
-		if head[entry.Name] != "" {
-			if head[entry.Name] != entry.Sha {
-				fmt.Printf("  (modified) %s\n", entry.Name)
-			}
-
-			delete(head, entry.Name)
-		} else {
-			fmt.Printf("  (new file) %s\n", entry.Name)
+		// Unmerged paths are listed separately
+		if entry.FlagStaged != 0 {
+			continue
 		}
+		staged[entry.Name] = entry.Sha
 	}
 
-	for _, entry := range head {
-		fmt.Printf("  (deleted) %s\n", entry)
+	// Pair deleted and new files into renames (and copies, if configured)
+	changes, err := diff.DetectRenames(diff.Compare(head, staged), head, renameOptions(repo), blobLoader(repo))
+	if err != nil {
+		return err
+	}
+
+	for _, change := range changes {
+		switch change.Type {
+		case diff.Renamed, diff.Copied:
+			fmt.Printf("  (%s) %s -> %s\n", change.Type, change.OldPath, change.NewPath)
+		default:
+			fmt.Printf("  (%s) %s\n", change.Type, change.Path())
+		}
 	}
 	return nil
 }
 
+// blobLoader reads blob contents from the repository, for content similarity checks.
+func blobLoader(repo *repository.Repo) diff.Loader {
+	return func(hash oid.ObjectID) ([]byte, error) {
+		obj, err := object.ReadObject(repo.Directory, hash)
+		if err != nil {
+			return nil, err
+		}
+		return obj.GetData(), nil
+	}
+}
+
 func printIndexWorkTree(repo *repository.Repo, index *index.Index) error {
 	fmt.Println("Changes not staged for commit:")
 	orfDirPrefix := repo.Directory + string(os.PathSeparator)
@@ -123,6 +164,10 @@ func printIndexWorkTree(repo *repository.Repo, index *index.Index) error {
 
 	// Traverse the index and compare the actual files
 	for _, entry := range index.Entries {
+		if entry.FlagStaged != 0 {
+			continue
+		}
+
 		fullPath := filepath.Join(repo.WorkTree, entry.Name)
 
 		// If the file is not in the working tree (deleted)
@@ -146,7 +191,7 @@ func printIndexWorkTree(repo *repository.Repo, index *index.Index) error {
 				if err != nil {
 					return err
 				}
-				newSha, err := GetHash(data, "blob", "")
+				newSha, err := GetHash(data, "blob", repo.ObjectFormat(), "")
 				if err != nil {
 					return err
 				}
@@ -169,50 +214,13 @@ func printIndexWorkTree(repo *repository.Repo, index *index.Index) error {
 	return nil
 }
 
-func treeToDict(repo *repository.Repo, ref string, prefix string) (map[string]string, error) {
-
-	ret := make(map[string]string)
+func treeToDict(repo *repository.Repo, ref string) (map[string]oid.ObjectID, error) {
 
-	// Find the tree object
-	treeHash := object.FindObject(repo, ref, "tree", false)
-	if treeHash == "" {
-		return nil, fmt.Errorf("failed to find tree object for ref: %s", ref)
-	}
-
-	// Read the tree object (it's expected to be a "tree" object)
-	tree, err := object.ReadObject(repo.Directory, treeHash)
+	// Find the tree object (following a commit to its tree)
+	treeHash, err := object.FindObject(repo, ref, "tree", true)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read tree object: %v", err)
-	}
-
-	// Ensure the object is actually a tree
-	t, ok := tree.(*object.Tree)
-	if !ok {
-		return nil, fmt.Errorf("unexpected type for tree: %T, expected *object.Tree", tree)
-	}
-
-	// Loop over the tree leaves (files or subtrees)
-	for _, leaf := range t.Leaves {
-		fullPath := filepath.Join(prefix, leaf.Path)
-
-		// Check if the leaf is a subtree (i.e., directory)
-		isSubtree := leaf.Mode[0] == 0x04 // This checks if the mode starts with '04', indicating a directory
-
-		// If it's a directory (subtree), recurse; otherwise, add it to the map
-		if isSubtree {
-			// Recurse into the subtree and update the map with its contents
-			subtree, err := treeToDict(repo, leaf.Hash, fullPath)
-			if err != nil {
-				return nil, fmt.Errorf("failed to process subtree %s: %v", fullPath, err)
-			}
-			// Merge the subtree map into the result map
-			for k, v := range subtree {
-				ret[k] = v
-			}
-		} else {
-			ret[fullPath] = leaf.Hash
-		}
+		return nil, fmt.Errorf("failed to find tree object for ref %s: %w", ref, err)
 	}
 
-	return ret, nil
+	return object.FlattenTree(repo, treeHash)
 }
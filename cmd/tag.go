@@ -8,13 +8,15 @@ import (
 )
 
 func Tag(name string, target string, willCreateTarget bool) error {
-	repo, err := repository.FindRepo(".", true)
+	repo, err := findRepo()
 	if err != nil {
 		return fmt.Errorf("error finding repo: %v", err)
 	}
 
 	if name != "" {
-		createTag(repo, name, target, willCreateTarget)
+		if err := createTag(repo, name, target, willCreateTarget); err != nil {
+			return err
+		}
 	} else {
 		refs, err := object.ListRefs(repo, "")
 		if err != nil {
@@ -29,37 +31,40 @@ func Tag(name string, target string, willCreateTarget bool) error {
 	return nil
 }
 
-func createTag(repo *repository.Repo, name string, target string, willCreateTarget bool) {
+func createTag(repo *repository.Repo, name string, target string, willCreateTarget bool) error {
 
 	// Get the OrfObject from the object reference
-	hash := object.FindObject(repo, target, "commit", true)
+	hash, err := object.FindObject(repo, target, "commit", true)
+	if err != nil {
+		return err
+	}
+
 	obj, err := object.ReadObject(repo.Directory, hash)
 	if err != nil {
-		fmt.Printf("error reading object: %v", err)
+		return fmt.Errorf("error reading object: %v", err)
 	}
 
 	// Assert the object is of type *object.Commit
-	commit, ok := obj.(*object.Commit)
-	if !ok {
-		fmt.Printf("unexpected type %T for commit, expected *Commit", obj)
+	if _, ok := obj.(*object.Commit); !ok {
+		return fmt.Errorf("unexpected type %T for commit, expected *Commit", obj)
 	}
 
 	if willCreateTarget {
 		// Create tag object (commit)
-		tag := object.CreateTag(commit.GetData())
-		tag.SetKVData("object", hash)
-		tag.SetKVData("type", "commit")
-		tag.SetKVData("tag", name)
-		tag.SetKVData("tagger", "orf <orf@example.com>")
-		tag.SetKVData("message", "Tagging commit "+hash)
+		kvData := kv.CreateOrderedMap()
+		kvData.Add("object", hash.String())
+		kvData.Add("type", "commit")
+		kvData.Add("tag", name)
+		kvData.Add("tagger", "orf <orf@example.com>")
+		kvData.Add("message", "Tagging commit "+hash.String())
+		tag := object.CreateTag(kv.Serialize(kvData))
 
 		tagHash, err := object.WriteObject(repo.Directory, tag)
 		if err != nil {
-			fmt.Printf("error writing tag object: %v", err)
+			return fmt.Errorf("error writing tag object: %v", err)
 		}
-		object.CreateRef(repo, "tags"+name, tagHash)
-
-	} else {
-		object.CreateRef(repo, "tags"+name, hash)
+		return object.CreateRef(repo, "tags/"+name, tagHash)
 	}
+
+	return object.CreateRef(repo, "tags/"+name, hash)
 }
@@ -0,0 +1,45 @@
+package cmd
+
+import (
+	"orf/object"
+	"orf/oid"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTag(t *testing.T) {
+	repo := createTestRepo(t)
+	head := commitFiles(t, repo, map[string]string{"a.txt": "a\n"}, "first")
+
+	if err := Tag("light", "HEAD", false); err != nil {
+		t.Fatalf("Tag failed: %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(repo.Directory, "refs", "tags", "light"))
+	if err != nil {
+		t.Fatalf("Expected refs/tags/light: %v", err)
+	}
+	if strings.TrimSpace(string(data)) != head.String() {
+		t.Errorf("Expected refs/tags/light to point at %s, got %q", head, data)
+	}
+
+	if err := Tag("annotated", "HEAD", true); err != nil {
+		t.Fatalf("Tag failed: %v", err)
+	}
+	data, err = os.ReadFile(filepath.Join(repo.Directory, "refs", "tags", "annotated"))
+	if err != nil {
+		t.Fatalf("Expected refs/tags/annotated: %v", err)
+	}
+	obj, err := object.ReadObject(repo.Directory, oid.ObjectID(strings.TrimSpace(string(data))))
+	if err != nil {
+		t.Fatalf("ReadObject failed: %v", err)
+	}
+	tag, ok := obj.(*object.Tag)
+	if !ok {
+		t.Fatalf("Expected refs/tags/annotated to point at a tag object, got %T", obj)
+	}
+	if tag.Target() != head {
+		t.Errorf("Expected the tag to point at %s, got %s", head, tag.Target())
+	}
+}
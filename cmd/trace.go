@@ -0,0 +1,31 @@
+package cmd
+
+import (
+	"fmt"
+	"orf/commitgraph"
+	"orf/object"
+	"os"
+)
+
+// Trace prints the statistics of the object cache and of the changed-path Bloom filters to
+// stderr when ORF_TRACE is set, to check how often a command re-reads the same objects and how
+// many tree lookups the filters spare.
+func Trace() {
+	if os.Getenv("ORF_TRACE") == "" {
+		return
+	}
+
+	stats := object.ReadCacheStats()
+	lookups := stats.Hits + stats.Misses
+	rate := 0.0
+	if lookups > 0 {
+		rate = 100 * float64(stats.Hits) / float64(lookups)
+	}
+	fmt.Fprintf(os.Stderr, "trace: object cache: %d hits, %d misses (%.1f%% hit rate), %d evictions, %d objects in %d bytes\n",
+		stats.Hits, stats.Misses, rate, stats.Evictions, stats.Objects, stats.Bytes)
+	bloom := commitgraph.ReadBloomStats()
+	if queries := bloom.DefinitelyNot + bloom.Maybe + bloom.Missing; queries > 0 {
+		fmt.Fprintf(os.Stderr, "trace: changed-path filters: %d definitely not, %d maybe, %d without a filter\n",
+			bloom.DefinitelyNot, bloom.Maybe, bloom.Missing)
+	}
+}
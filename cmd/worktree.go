@@ -0,0 +1,543 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"orf/index"
+	"orf/merge"
+	"orf/object"
+	"orf/oid"
+	"orf/repository"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// State files recording an operation in progress, kept in the repository directory.
+const (
+	mergeHeadFile      = "MERGE_HEAD"
+	mergeMsgFile       = "MERGE_MSG"
+	origHeadFile       = "ORIG_HEAD"
+	squashMsgFile      = "SQUASH_MSG"
+	cherryPickHeadFile = "CHERRY_PICK_HEAD"
+	revertHeadFile     = "REVERT_HEAD"
+	rebaseHeadFile     = "REBASE_HEAD"
+	commitEditMsgFile  = "COMMIT_EDITMSG"
+)
+
+// readStateFile returns the contents of a state file, or "" if it does not exist.
+func readStateFile(repo *repository.Repo, name string) (string, error) {
+	data, err := os.ReadFile(filepath.Join(repo.Directory, name))
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+func writeStateFile(repo *repository.Repo, name string, contents string) error {
+	return os.WriteFile(filepath.Join(repo.Directory, name), []byte(contents), 0644)
+}
+
+func removeStateFiles(repo *repository.Repo, names ...string) error {
+	for _, name := range names {
+		err := os.Remove(filepath.Join(repo.Directory, name))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// readMergeHeads returns the commits being merged, one per line of MERGE_HEAD.
+func readMergeHeads(repo *repository.Repo) ([]oid.ObjectID, error) {
+	contents, err := readStateFile(repo, mergeHeadFile)
+	if err != nil {
+		return nil, err
+	}
+
+	var heads []oid.ObjectID
+	for _, line := range strings.Fields(contents) {
+		heads = append(heads, oid.ObjectID(line))
+	}
+	return heads, nil
+}
+
+// commitLeaves returns the blobs of a commit's tree keyed by path. An empty commit id (an
+// unborn branch) has no leaves.
+func commitLeaves(repo *repository.Repo, commit oid.ObjectID) (map[string]*object.Leaf, error) {
+	if commit == "" {
+		return map[string]*object.Leaf{}, nil
+	}
+
+	c, err := object.ReadCommit(repo, commit)
+	if err != nil {
+		return nil, err
+	}
+	return object.ReadTreeLeaves(repo, c.TreeHash())
+}
+
+// commitTree returns the id of a commit's root tree.
+func commitTree(repo *repository.Repo, commit oid.ObjectID) (oid.ObjectID, error) {
+	c, err := object.ReadCommit(repo, commit)
+	if err != nil {
+		return "", err
+	}
+	return c.TreeHash(), nil
+}
+
+// indexLeaves returns the index entries as leaves keyed by path. Unmerged paths get a leaf
+// without a hash, which never matches a tree's version of the path.
+func indexLeaves(idx *index.Index) map[string]*object.Leaf {
+	leaves := make(map[string]*object.Leaf)
+	for _, entry := range idx.Entries {
+		path := filepath.ToSlash(entry.Name)
+		if entry.FlagStaged != 0 {
+			leaves[path] = &object.Leaf{Path: path}
+			continue
+		}
+		leaves[path] = entryLeaf(entry)
+	}
+	return leaves
+}
+
+// unmergedPaths returns the conflicts recorded in the index, one per unmerged path in path
+// order, with the versions found at stages 1, 2 and 3.
+func unmergedPaths(idx *index.Index) []merge.Conflict {
+	byPath := make(map[string]*merge.Conflict)
+	var paths []string
+
+	for _, entry := range idx.Entries {
+		stage := entry.FlagStaged >> 12
+		if stage == 0 {
+			continue
+		}
+
+		path := filepath.ToSlash(entry.Name)
+		conflict, ok := byPath[path]
+		if !ok {
+			conflict = &merge.Conflict{Path: path}
+			byPath[path] = conflict
+			paths = append(paths, path)
+		}
+
+		leaf := entryLeaf(entry)
+		switch stage {
+		case 1:
+			conflict.Base = leaf
+		case 2:
+			conflict.Ours = leaf
+		case 3:
+			conflict.Theirs = leaf
+		}
+	}
+
+	sort.Strings(paths)
+
+	var conflicts []merge.Conflict
+	for _, path := range paths {
+		conflict := byPath[path]
+		conflict.Type = merge.ConflictTypeOf(conflict.Base != nil, conflict.Ours != nil, conflict.Theirs != nil)
+		conflicts = append(conflicts, *conflict)
+	}
+	return conflicts
+}
+
+// entryLeaf returns the tree leaf for an index entry.
+func entryLeaf(entry index.IndexEntry) *object.Leaf {
+	return &object.Leaf{
+		Mode: []byte(fmt.Sprintf("%02o%04o", entry.ModeType, entry.ModePerms)),
+		Path: filepath.ToSlash(entry.Name),
+		Hash: entry.Sha,
+	}
+}
+
+// worktreePath converts a path given on the command line to a slash-separated path relative
+// to the working tree.
+func worktreePath(repo *repository.Repo, path string) (string, error) {
+	abspath, err := filepath.Abs(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to get absolute path: %v", err)
+	}
+
+	relpath, err := filepath.Rel(repo.WorkTree, abspath)
+	if err != nil || relpath == ".." || strings.HasPrefix(relpath, ".."+string(os.PathSeparator)) {
+		return "", fmt.Errorf("outside the worktree: %v", path)
+	}
+
+	return filepath.ToSlash(relpath), nil
+}
+
+// resetWorkTree makes the index and working tree match a commit, discarding staged and
+// unstaged changes to tracked files.
+func resetWorkTree(repo *repository.Repo, revision string) error {
+	target, err := object.ResolveCommit(repo, revision)
+	if err != nil {
+		return err
+	}
+	leaves, err := commitLeaves(repo, target)
+	if err != nil {
+		return err
+	}
+
+	idx, err := index.ReadIndex(repo)
+	if err != nil {
+		return err
+	}
+
+	// Rewrite every file tracked by the index or HEAD, since the working tree may differ
+	current := indexLeaves(idx)
+	head, err := object.FindObject(repo, "HEAD", "commit", true)
+	if err != nil && !errors.Is(err, object.ErrNotFound) {
+		return err
+	}
+	headLeaves, err := commitLeaves(repo, head)
+	if err != nil {
+		return err
+	}
+	for path := range headLeaves {
+		current[path] = nil
+	}
+	for path := range current {
+		current[path] = &object.Leaf{Path: path}
+	}
+
+	return updateWorkTree(repo, current, leaves, nil)
+}
+
+// checkClean makes sure neither the index nor the working tree differ from the leaves of
+// HEAD, so an operation rewriting both cannot lose any work.
+func checkClean(repo *repository.Repo, idx *index.Index, head map[string]*object.Leaf) error {
+	staged := indexLeaves(idx)
+
+	for path, leaf := range head {
+		if other, ok := staged[path]; !ok || other.Hash != leaf.Hash {
+			return fmt.Errorf("your local changes to %s would be overwritten; commit them first", path)
+		}
+	}
+
+	for path, leaf := range staged {
+		if _, ok := head[path]; !ok {
+			return fmt.Errorf("your local changes to %s would be overwritten; commit them first", path)
+		}
+
+		data, err := os.ReadFile(filepath.Join(repo.WorkTree, filepath.FromSlash(path)))
+		if err != nil {
+			return fmt.Errorf("your local changes to %s would be overwritten; commit them first", path)
+		}
+		hash, err := GetHash(data, "blob", repo.ObjectFormat(), "")
+		if err != nil {
+			return err
+		}
+		if hash != leaf.Hash {
+			return fmt.Errorf("your local changes to %s would be overwritten; commit them first", path)
+		}
+	}
+
+	return nil
+}
+
+// checkUntracked makes sure no untracked file is in the way of the new leaves.
+func checkUntracked(repo *repository.Repo, old, new map[string]*object.Leaf) error {
+	for path := range new {
+		if _, ok := old[path]; ok {
+			continue
+		}
+		if _, err := os.Lstat(filepath.Join(repo.WorkTree, filepath.FromSlash(path))); err == nil {
+			return fmt.Errorf("untracked working tree file %s would be overwritten", path)
+		}
+	}
+	return nil
+}
+
+// checkoutLeaves updates the working tree from the old leaves to the new ones: files only
+// in old are removed, and new or changed files are written.
+func checkoutLeaves(repo *repository.Repo, old, new map[string]*object.Leaf) error {
+	for path := range old {
+		if _, ok := new[path]; ok {
+			continue
+		}
+
+		dest, err := worktreeFile(repo, path)
+		if err != nil {
+			return err
+		}
+		if err := os.Remove(dest); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to remove %s: %v", path, err)
+		}
+		removeEmptyDirs(repo, filepath.Dir(dest))
+	}
+
+	for path, leaf := range new {
+		if previous, ok := old[path]; ok && previous.Hash == leaf.Hash && string(previous.Mode) == string(leaf.Mode) {
+			continue
+		}
+
+		if err := writeLeaf(repo, path, leaf); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writeLeaf writes the blob of a leaf to its path in the working tree, as an executable
+// file for mode 100755 and as a symbolic link for mode 120000. Paths leading outside the
+// working tree are refused.
+func writeLeaf(repo *repository.Repo, path string, leaf *object.Leaf) error {
+	dest, err := worktreeFile(repo, path)
+	if err != nil {
+		return err
+	}
+
+	obj, err := object.ReadObject(repo.Directory, leaf.Hash)
+	if err != nil {
+		return fmt.Errorf("failed to read object %s: %v", leaf.Hash, err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dest), os.ModePerm); err != nil {
+		return fmt.Errorf("failed to create directory for %s: %v", path, err)
+	}
+
+	// Replace the file rather than writing through a symbolic link it may be
+	if err := os.Remove(dest); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to replace %s: %v", path, err)
+	}
+
+	if string(leaf.Mode) == "120000" {
+		return os.Symlink(string(obj.GetData()), dest)
+	}
+
+	if err := writeData(dest, obj.GetData()); err != nil {
+		return err
+	}
+
+	perm := os.FileMode(0644)
+	if string(leaf.Mode) == "100755" {
+		perm = 0755
+	}
+	return os.Chmod(dest, perm)
+}
+
+// worktreeFile returns the file path of a slash-separated path in the working tree. It
+// refuses paths that would lead outside the working tree, either through ".." or through a
+// symbolic link in a parent directory.
+func worktreeFile(repo *repository.Repo, path string) (string, error) {
+	clean := filepath.Clean(filepath.FromSlash(path))
+	if filepath.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, ".."+string(os.PathSeparator)) || clean == "." {
+		return "", fmt.Errorf("refusing to update %s outside the worktree", path)
+	}
+
+	parts := strings.Split(clean, string(os.PathSeparator))
+	for i := 1; i < len(parts); i++ {
+		dir := filepath.Join(parts[:i]...)
+		if info, err := os.Lstat(filepath.Join(repo.WorkTree, dir)); err == nil && info.Mode()&os.ModeSymlink != 0 {
+			return "", fmt.Errorf("refusing to update %s beyond the symbolic link %s", path, filepath.ToSlash(dir))
+		}
+	}
+
+	if clean == ".orf" || strings.HasPrefix(clean, ".orf"+string(os.PathSeparator)) {
+		return "", fmt.Errorf("refusing to update %s inside the repository directory", path)
+	}
+
+	return filepath.Join(repo.WorkTree, clean), nil
+}
+
+// stageResolved replaces every index entry for a path, including its conflict stages, with
+// the working tree version of the file at stage 0. A path missing from the working tree is
+// removed from the index.
+func stageResolved(repo *repository.Repo, idx *index.Index, path string, mode []byte) error {
+	var entries []index.IndexEntry
+	for _, entry := range idx.Entries {
+		if filepath.ToSlash(entry.Name) != path {
+			entries = append(entries, entry)
+		}
+	}
+
+	data, err := os.ReadFile(filepath.Join(repo.WorkTree, filepath.FromSlash(path)))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err == nil {
+		hash, err := GetHash(data, "blob", repo.ObjectFormat(), repo.Directory)
+		if err != nil {
+			return err
+		}
+
+		entry, err := leafEntry(repo, path, &object.Leaf{Mode: mode, Path: path, Hash: hash}, 0)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, entry)
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Name < entries[j].Name
+	})
+
+	idx.Entries = entries
+	return idx.WriteIndex(repo)
+}
+
+// removeEmptyDirs removes dir and its parents up to the working tree while they are empty.
+func removeEmptyDirs(repo *repository.Repo, dir string) {
+	for dir != repo.WorkTree && strings.HasPrefix(dir, repo.WorkTree) {
+		if os.Remove(dir) != nil {
+			return
+		}
+		dir = filepath.Dir(dir)
+	}
+}
+
+// indexFromLeaves builds an index holding the leaves at stage 0, except for conflicted
+// paths, which get an entry for each version that exists: 1 for the base, 2 for ours and 3
+// for theirs.
+func indexFromLeaves(repo *repository.Repo, leaves map[string]*object.Leaf, conflicts []merge.Conflict) (*index.Index, error) {
+	conflicted := make(map[string]bool)
+	var entries []index.IndexEntry
+
+	for _, conflict := range conflicts {
+		conflicted[conflict.Path] = true
+
+		for stage, leaf := range []*object.Leaf{conflict.Base, conflict.Ours, conflict.Theirs} {
+			if leaf == nil {
+				continue
+			}
+			entry, err := leafEntry(repo, conflict.Path, leaf, uint16(stage+1))
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, entry)
+		}
+	}
+
+	for path, leaf := range leaves {
+		if conflicted[path] {
+			continue
+		}
+		entry, err := leafEntry(repo, path, leaf, 0)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+
+	sortEntries(entries)
+	return index.CreateIndex(2, entries), nil
+}
+
+// leafEntry makes an index entry for a leaf. Stage 0 entries take their stat information
+// from the working tree file.
+func leafEntry(repo *repository.Repo, path string, leaf *object.Leaf, stage uint16) (index.IndexEntry, error) {
+	entry, err := treeEntry(path, leaf, stage)
+	if err != nil || stage != 0 {
+		return entry, err
+	}
+
+	stat, err := os.Lstat(filepath.Join(repo.WorkTree, filepath.FromSlash(path)))
+	if err != nil {
+		return index.IndexEntry{}, fmt.Errorf("failed to stat file %v: %v", path, err)
+	}
+
+	entry.CTimeSec = uint64(stat.ModTime().Unix())
+	entry.CTimeNsec = uint64(stat.ModTime().Nanosecond())
+	entry.MTimeSec = uint64(stat.ModTime().Unix())
+	entry.MTimeNsec = uint64(stat.ModTime().Nanosecond())
+	entry.Dev = uint64(stat.Sys().(*syscall.Stat_t).Dev)
+	entry.Ino = uint64(stat.Sys().(*syscall.Stat_t).Ino)
+	entry.Uid = stat.Sys().(*syscall.Stat_t).Uid
+	entry.Gid = stat.Sys().(*syscall.Stat_t).Gid
+	entry.Fsize = uint32(stat.Size())
+
+	return entry, nil
+}
+
+// treeEntry makes an index entry for a leaf without stat information, so the working tree
+// file is always compared by content.
+func treeEntry(path string, leaf *object.Leaf, stage uint16) (index.IndexEntry, error) {
+	mode, err := strconv.ParseUint(string(leaf.Mode), 8, 32)
+	if err != nil {
+		return index.IndexEntry{}, fmt.Errorf("invalid mode %q for %s", leaf.Mode, path)
+	}
+
+	return index.IndexEntry{
+		Name:       filepath.FromSlash(path),
+		Sha:        leaf.Hash,
+		ModeType:   uint32(mode >> 12),
+		ModePerms:  uint32(mode & 0o7777),
+		FlagStaged: stage << 12,
+	}, nil
+}
+
+// worktreeHash returns the blob id of a working tree file, or "" if it does not exist.
+func worktreeHash(repo *repository.Repo, path string) (oid.ObjectID, error) {
+	data, err := readWorktreeFile(repo, path)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return GetHash(data, "blob", repo.ObjectFormat(), "")
+}
+
+// readWorktreeFile returns the blob contents of a working tree file: its data, or the target
+// of a symbolic link.
+func readWorktreeFile(repo *repository.Repo, path string) ([]byte, error) {
+	file := filepath.Join(repo.WorkTree, filepath.FromSlash(path))
+	info, err := os.Lstat(file)
+	if err != nil {
+		return nil, err
+	}
+
+	if info.Mode()&os.ModeSymlink != 0 {
+		target, err := os.Readlink(file)
+		return []byte(target), err
+	}
+	return os.ReadFile(file)
+}
+
+// matchPaths returns a function reporting whether a slash-separated path is one of the
+// given command line paths or inside one of them.
+func matchPaths(repo *repository.Repo, paths []string) (func(string) bool, error) {
+	var prefixes []string
+	for _, path := range paths {
+		relpath, err := worktreePath(repo, path)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, relpath)
+	}
+
+	return func(path string) bool {
+		for _, prefix := range prefixes {
+			if prefix == "." || path == prefix || strings.HasPrefix(path, prefix+"/") {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
+
+// sameLeaf reports whether two leaves (either may be nil) have the same contents and mode.
+func sameLeaf(a, b *object.Leaf) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Hash == b.Hash && string(a.Mode) == string(b.Mode)
+}
+
+// sortEntries sorts index entries by path, then stage.
+func sortEntries(entries []index.IndexEntry) {
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Name != entries[j].Name {
+			return entries[i].Name < entries[j].Name
+		}
+		return entries[i].FlagStaged < entries[j].FlagStaged
+	})
+}
@@ -0,0 +1,281 @@
+package commitgraph
+
+import (
+	"encoding/binary"
+	"errors"
+	"math/bits"
+	"orf/object"
+	"orf/oid"
+	"orf/repository"
+	"strings"
+	"sync/atomic"
+)
+
+// Chunks of the changed-path Bloom filters, one filter per commit of the graph.
+const (
+	chunkBloomIndex = 0x42494458 // "BIDX": offset where the filter of each commit ends in BDAT
+	chunkBloomData  = 0x42444154 // "BDAT": a header, then the filters
+)
+
+const (
+	bloomHashVersion  = 2 // murmur3 over unsigned bytes, git's commitGraph.changedPathsVersion 2
+	bloomHashes       = 7
+	bloomBitsPerEntry = 10
+	bloomHeaderSize   = 12
+	bloomMaxChanges   = 512 // Commits changing more paths get a filter matching every path
+
+	bloomSeed0 = 0x293ae76f
+	bloomSeed1 = 0x7e646e2c
+)
+
+// errTooManyChanges stops listing the changes of a commit past bloomMaxChanges.
+var errTooManyChanges = errors.New("too many changed paths")
+
+// bloomFilter is the set of paths a commit changes relative to its first parent: the changed
+// files and every directory holding them. A filter may match paths that did not change, never
+// the other way round. An empty filter is one that was not computed, and matches every path.
+type bloomFilter struct {
+	data   []byte
+	hashes uint32
+}
+
+// newBloomFilter returns the filter of a list of changed paths, or the filter matching every
+// path if there are more than bloomMaxChanges.
+func newBloomFilter(paths []string) *bloomFilter {
+	if len(paths) > bloomMaxChanges {
+		return fullBloomFilter()
+	}
+
+	size := (len(paths)*bloomBitsPerEntry + 7) / 8
+	filter := &bloomFilter{data: make([]byte, max(size, 1)), hashes: bloomHashes}
+	for _, path := range paths {
+		for _, position := range filter.positions(path) {
+			filter.data[position/8] |= 1 << (position % 8)
+		}
+	}
+	return filter
+}
+
+// fullBloomFilter returns the filter of commits changing too many paths, which has every bit set.
+func fullBloomFilter() *bloomFilter {
+	return &bloomFilter{data: []byte{0xff}, hashes: bloomHashes}
+}
+
+// positions returns the bits of the filter a path sets, by double hashing as git does.
+func (filter *bloomFilter) positions(path string) []uint32 {
+	hash0 := murmur3(bloomSeed0, []byte(path))
+	hash1 := murmur3(bloomSeed1, []byte(path))
+	total := uint32(len(filter.data)) * 8
+
+	positions := make([]uint32, filter.hashes)
+	for i := range positions {
+		positions[i] = (hash0 + uint32(i)*hash1) % total
+	}
+	return positions
+}
+
+// MaybeContains reports whether a path may be in the filter.
+func (filter *bloomFilter) MaybeContains(path string) bool {
+	if len(filter.data) == 0 {
+		return true
+	}
+
+	for _, position := range filter.positions(path) {
+		if filter.data[position/8]&(1<<(position%8)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// murmur3 is the 32 bit MurmurHash3 of data.
+func murmur3(seed uint32, data []byte) uint32 {
+	const (
+		c1 = 0xcc9e2d51
+		c2 = 0x1b873593
+	)
+
+	mix := func(k uint32) uint32 {
+		k *= c1
+		k = bits.RotateLeft32(k, 15)
+		return k * c2
+	}
+
+	hash := seed
+	blocks := len(data) / 4
+	for i := 0; i < blocks; i++ {
+		hash ^= mix(binary.LittleEndian.Uint32(data[i*4:]))
+		hash = bits.RotateLeft32(hash, 13)
+		hash = hash*5 + 0xe6546b64
+	}
+
+	var k uint32
+	tail := data[blocks*4:]
+	switch len(tail) {
+	case 3:
+		k ^= uint32(tail[2]) << 16
+		fallthrough
+	case 2:
+		k ^= uint32(tail[1]) << 8
+		fallthrough
+	case 1:
+		k ^= uint32(tail[0])
+		hash ^= mix(k)
+	}
+
+	hash ^= uint32(len(data))
+	hash ^= hash >> 16
+	hash *= 0x85ebca6b
+	hash ^= hash >> 13
+	hash *= 0xc2b2ae35
+	hash ^= hash >> 16
+	return hash
+}
+
+// changedPaths lists the paths whose entries differ between two trees, "" being an empty tree.
+// Directories are listed along with what changed under them, without a trailing slash. It
+// returns errTooManyChanges once there are more than bloomMaxChanges.
+func changedPaths(repo *repository.Repo, oldTree, newTree oid.ObjectID) ([]string, error) {
+	var paths []string
+	if err := diffTrees(repo, oldTree, newTree, "", &paths); err != nil {
+		return nil, err
+	}
+	return paths, nil
+}
+
+func diffTrees(repo *repository.Repo, oldTree, newTree oid.ObjectID, prefix string, paths *[]string) error {
+	if oldTree == newTree {
+		return nil
+	}
+
+	oldLeaves, err := treeLeaves(repo, oldTree)
+	if err != nil {
+		return err
+	}
+	newLeaves, err := treeLeaves(repo, newTree)
+	if err != nil {
+		return err
+	}
+
+	changed := func(name string, oldLeaf, newLeaf *object.Leaf) error {
+		if oldLeaf != nil && newLeaf != nil && oldLeaf.Hash == newLeaf.Hash && string(oldLeaf.Mode) == string(newLeaf.Mode) {
+			return nil
+		}
+
+		*paths = append(*paths, prefix+name)
+		if len(*paths) > bloomMaxChanges {
+			return errTooManyChanges
+		}
+
+		var oldSub, newSub oid.ObjectID
+		if oldLeaf != nil && oldLeaf.IsTree() {
+			oldSub = oldLeaf.Hash
+		}
+		if newLeaf != nil && newLeaf.IsTree() {
+			newSub = newLeaf.Hash
+		}
+		if oldSub == "" && newSub == "" {
+			return nil
+		}
+		return diffTrees(repo, oldSub, newSub, prefix+name+"/", paths)
+	}
+
+	for name, oldLeaf := range oldLeaves {
+		if err := changed(name, oldLeaf, newLeaves[name]); err != nil {
+			return err
+		}
+	}
+	for name, newLeaf := range newLeaves {
+		if _, ok := oldLeaves[name]; !ok {
+			if err := changed(name, nil, newLeaf); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// treeLeaves maps the names in a tree to its entries; "" reads as an empty tree.
+func treeLeaves(repo *repository.Repo, hash oid.ObjectID) (map[string]*object.Leaf, error) {
+	leaves := make(map[string]*object.Leaf)
+	if hash == "" {
+		return leaves, nil
+	}
+
+	obj, err := object.ReadObject(repo.Directory, hash)
+	if err != nil {
+		return nil, err
+	}
+	tree, ok := obj.(*object.Tree)
+	if !ok {
+		return nil, &object.WrongTypeError{ID: hash, Have: obj.GetFormat(), Want: "tree"}
+	}
+
+	for _, leaf := range tree.Leaves {
+		leaves[leaf.Path] = leaf
+	}
+	return leaves, nil
+}
+
+// commitFilter computes the filter of a commit relative to its first parent, given their trees.
+func commitFilter(repo *repository.Repo, parentTree, tree oid.ObjectID) (*bloomFilter, error) {
+	paths, err := changedPaths(repo, parentTree, tree)
+	if errors.Is(err, errTooManyChanges) {
+		return fullBloomFilter(), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return newBloomFilter(paths), nil
+}
+
+// BloomStats counts the path queries answered by changed-path Bloom filters since the process
+// started.
+type BloomStats struct {
+	// DefinitelyNot counts the queries a filter ruled out, sparing a tree lookup.
+	DefinitelyNot int64
+	// Maybe counts the queries a filter could not rule out.
+	Maybe int64
+	// Missing counts the queries about commits without a filter.
+	Missing int64
+}
+
+var bloomCounters struct {
+	definitelyNot atomic.Int64
+	maybe         atomic.Int64
+	missing       atomic.Int64
+}
+
+// ReadBloomStats returns the counts of changed-path Bloom filter queries.
+func ReadBloomStats() BloomStats {
+	return BloomStats{
+		DefinitelyNot: bloomCounters.definitelyNot.Load(),
+		Maybe:         bloomCounters.maybe.Load(),
+		Missing:       bloomCounters.missing.Load(),
+	}
+}
+
+// MaybeChanged reports whether a commit may change a path (or anything under it) relative to
+// its first parent. It is false only when the changed-path Bloom filter of the commit rules the
+// path out; commits without a filter may change any path.
+func (reader *Reader) MaybeChanged(id oid.ObjectID, path string) bool {
+	path = strings.Trim(path, "/")
+
+	var filter *bloomFilter
+	if reader.graph != nil && reader.changedPaths && path != "" {
+		if i := reader.graph.find(id); i != -1 {
+			filter = reader.graph.filter(i)
+		}
+	}
+	if filter == nil {
+		bloomCounters.missing.Add(1)
+		return true
+	}
+
+	if filter.MaybeContains(path) {
+		bloomCounters.maybe.Add(1)
+		return true
+	}
+	bloomCounters.definitelyNot.Add(1)
+	return false
+}
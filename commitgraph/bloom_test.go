@@ -0,0 +1,221 @@
+package commitgraph
+
+import (
+	"fmt"
+	"orf/kv"
+	"orf/object"
+	"orf/oid"
+	"orf/repository"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// writeTestTree writes the tree of files, whose slash-separated paths may be in directories.
+func writeTestTree(t *testing.T, repo *repository.Repo, files map[string]string) oid.ObjectID {
+	children := make(map[string]map[string]string)
+	tree := object.CreateTree(nil)
+	for path, contents := range files {
+		if dir, rest, ok := strings.Cut(path, "/"); ok {
+			if children[dir] == nil {
+				children[dir] = make(map[string]string)
+			}
+			children[dir][rest] = contents
+			continue
+		}
+
+		blob, err := object.WriteObject(repo.Directory, object.CreateBlob([]byte(contents)))
+		if err != nil {
+			t.Fatalf("Failed to write blob: %v", err)
+		}
+		tree.Leaves = append(tree.Leaves, &object.Leaf{Mode: []byte("100644"), Path: path, Hash: blob})
+	}
+	for dir, contents := range children {
+		tree.Leaves = append(tree.Leaves, &object.Leaf{Mode: []byte("40000"), Path: dir, Hash: writeTestTree(t, repo, contents)})
+	}
+	sort.Sort(object.ByPath(tree.Leaves))
+
+	data, err := tree.Serialize()
+	if err != nil {
+		t.Fatalf("Failed to serialize tree: %v", err)
+	}
+	hash, err := object.WriteObject(repo.Directory, object.CreateTree(data))
+	if err != nil {
+		t.Fatalf("Failed to write tree: %v", err)
+	}
+	return hash
+}
+
+// writeTreeCommit writes a commit of a tree of files.
+func writeTreeCommit(t *testing.T, repo *repository.Repo, files map[string]string, parents []oid.ObjectID, when int64) oid.ObjectID {
+	kvData := kv.CreateOrderedMap()
+	kvData.Add("tree", writeTestTree(t, repo, files).String())
+	for _, parent := range parents {
+		kvData.Add("parent", parent.String())
+	}
+	kvData.Add("author", fmt.Sprintf("Test <test@example.com> %d +0000", when))
+	kvData.Add("committer", fmt.Sprintf("Test <test@example.com> %d +0000", when))
+	kvData.Add("message", "change")
+
+	hash, err := object.WriteObject(repo.Directory, object.CreateCommit(kv.Serialize(kvData)))
+	if err != nil {
+		t.Fatalf("Failed to write commit: %v", err)
+	}
+	return hash
+}
+
+func TestMurmur3(t *testing.T) {
+	// The values git's test-tool bloom get_murmur3 prints
+	tests := map[string]uint32{
+		"":             0x00000000,
+		"Hello world!": 0x627b0c2c,
+		"The quick brown fox jumps over the lazy dog": 0x2e4ff723,
+	}
+	for data, expected := range tests {
+		if hash := murmur3(0, []byte(data)); hash != expected {
+			t.Errorf("murmur3(%q) = %#08x, expected %#08x", data, hash, expected)
+		}
+	}
+}
+
+func TestBloomFilter(t *testing.T) {
+	var paths []string
+	for i := 0; i < 100; i++ {
+		paths = append(paths, fmt.Sprintf("dir%d/file%d.txt", i%10, i))
+	}
+	filter := newBloomFilter(paths)
+	if len(filter.data) != 125 {
+		t.Errorf("Expected 10 bits per path, got %d bytes", len(filter.data))
+	}
+
+	for _, path := range paths {
+		if !filter.MaybeContains(path) {
+			t.Errorf("Expected the filter to contain %s", path)
+		}
+	}
+
+	falsePositives := 0
+	for i := 0; i < 1000; i++ {
+		if filter.MaybeContains(fmt.Sprintf("other/file%d.txt", i)) {
+			falsePositives++
+		}
+	}
+	if falsePositives > 50 {
+		t.Errorf("Expected about 1%% false positives, got %d in 1000", falsePositives)
+	}
+
+	if empty := newBloomFilter(nil); len(empty.data) != 1 || empty.MaybeContains("a") {
+		t.Errorf("Expected a one byte filter matching nothing, got %v", empty.data)
+	}
+	if full := newBloomFilter(make([]string, bloomMaxChanges+1)); !full.MaybeContains("a") {
+		t.Errorf("Expected the filter of too many changes to match every path")
+	}
+	if missing := (&bloomFilter{hashes: bloomHashes}); !missing.MaybeContains("a") {
+		t.Errorf("Expected a filter that was not computed to match every path")
+	}
+}
+
+func TestChangedPathFilters(t *testing.T) {
+	repo := createTestRepo(t)
+	files := map[string]string{"README": "readme", "src/main.go": "main", "src/lib/util.go": "util", "docs/guide.md": "guide"}
+	root := writeTreeCommit(t, repo, files, nil, 1000)
+	files["src/lib/util.go"] = "util v2"
+	change := writeTreeCommit(t, repo, files, []oid.ObjectID{root}, 2000)
+	writeTestRef(t, repo, "refs/heads/master", change)
+
+	if _, err := Write(repo); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if err := Verify(repo); err != nil {
+		t.Errorf("Verify failed: %v", err)
+	}
+
+	reader := NewReader(repo)
+	for _, path := range []string{"src", "src/lib", "src/lib/util.go", "/src/lib/"} {
+		if !reader.MaybeChanged(change, path) {
+			t.Errorf("Expected %s to be changed", path)
+		}
+	}
+	for _, path := range []string{"README", "docs", "src/main.go", "src/lib/other.go"} {
+		if reader.MaybeChanged(change, path) {
+			t.Errorf("Expected the filter to rule %s out", path)
+		}
+	}
+	for _, path := range []string{"README", "docs/guide.md"} {
+		if !reader.MaybeChanged(root, path) {
+			t.Errorf("Expected the root commit to add %s", path)
+		}
+	}
+	if !reader.MaybeChanged(change, "") {
+		t.Errorf("Expected the whole tree to be changed")
+	}
+
+	// Filters of the previous graph are kept, even once their trees are gone
+	commit, _, err := reader.graph.Lookup(change)
+	if err != nil {
+		t.Fatalf("Lookup failed: %v", err)
+	}
+	if err := os.Remove(object.LoosePath(repo.Directory, commit.Tree)); err != nil {
+		t.Fatalf("Failed to remove tree: %v", err)
+	}
+	if _, err := Write(repo); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if NewReader(repo).MaybeChanged(change, "README") {
+		t.Errorf("Expected the filter to be kept")
+	}
+}
+
+func TestVerifyChangedPathFilters(t *testing.T) {
+	repo := createTestRepo(t)
+	root := writeTreeCommit(t, repo, map[string]string{"a.txt": "a", "b.txt": "b"}, nil, 1000)
+	writeTestRef(t, repo, "refs/heads/master", root)
+	if _, err := Write(repo); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	graph, err := Open(Path(repo.Directory), repository.ObjectFormat(repo.Directory))
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	commit, _, err := graph.Lookup(root)
+	if err != nil {
+		t.Fatalf("Lookup failed: %v", err)
+	}
+	if err := verifyFilter(repo, graph, 0, commit); err != nil {
+		t.Errorf("Expected the filter to verify, got %v", err)
+	}
+
+	for i := range graph.bloomData {
+		graph.bloomData[i] = 0
+	}
+	if err := verifyFilter(repo, graph, 0, commit); err == nil {
+		t.Errorf("Expected Verify to detect the emptied filter")
+	}
+}
+
+func TestChangedPathFiltersDisabled(t *testing.T) {
+	repo := createTestRepo(t)
+	root := writeTreeCommit(t, repo, map[string]string{"a.txt": "a"}, nil, 1000)
+	change := writeTreeCommit(t, repo, map[string]string{"a.txt": "a", "b.txt": "b"}, []oid.ObjectID{root}, 2000)
+	writeTestRef(t, repo, "refs/heads/master", change)
+	if err := os.WriteFile(filepath.Join(repo.Directory, "config"), []byte("[commitGraph]\n\tchangedPaths = false\n"), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+
+	if _, err := Write(repo); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	graph, err := Load(repo.Directory)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if graph.HasChangedPaths() {
+		t.Errorf("Expected no changed-path filters")
+	}
+	if !NewReader(repo).MaybeChanged(change, "a.txt") {
+		t.Errorf("Expected every path to maybe change without filters")
+	}
+}
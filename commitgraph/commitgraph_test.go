@@ -0,0 +1,248 @@
+package commitgraph
+
+import (
+	"errors"
+	"fmt"
+	"orf/kv"
+	"orf/object"
+	"orf/oid"
+	"orf/repository"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func createTestRepo(t *testing.T) *repository.Repo {
+	workTree := t.TempDir()
+	directory := filepath.Join(workTree, ".orf")
+
+	for _, dir := range []string{"objects", filepath.Join("refs", "heads"), filepath.Join("refs", "tags")} {
+		if err := os.MkdirAll(filepath.Join(directory, dir), os.ModePerm); err != nil {
+			t.Fatalf("Failed to create directories: %v", err)
+		}
+	}
+
+	return &repository.Repo{WorkTree: workTree, Directory: directory}
+}
+
+// writeTestCommit writes a commit with a tree named after its message.
+func writeTestCommit(t *testing.T, repo *repository.Repo, parents []oid.ObjectID, when int64, message string) oid.ObjectID {
+	tree, err := object.WriteObject(repo.Directory, object.CreateBlob([]byte(message)))
+	if err != nil {
+		t.Fatalf("Failed to write tree stand-in: %v", err)
+	}
+
+	kvData := kv.CreateOrderedMap()
+	kvData.Add("tree", tree.String())
+	if len(parents) == 1 {
+		kvData.Add("parent", parents[0].String())
+	} else if len(parents) > 1 {
+		var values []string
+		for _, parent := range parents {
+			values = append(values, parent.String())
+		}
+		kvData.Add("parent", values)
+	}
+	kvData.Add("author", fmt.Sprintf("Test <test@example.com> %d +0200", when))
+	kvData.Add("committer", fmt.Sprintf("Test <test@example.com> %d +0200", when))
+	kvData.Add("message", message)
+
+	hash, err := object.WriteObject(repo.Directory, object.CreateCommit(kv.Serialize(kvData)))
+	if err != nil {
+		t.Fatalf("Failed to write commit: %v", err)
+	}
+	return hash
+}
+
+func writeTestRef(t *testing.T, repo *repository.Repo, name string, hash oid.ObjectID) {
+	if err := os.WriteFile(filepath.Join(repo.Directory, filepath.FromSlash(name)), []byte(hash.String()+"\n"), 0644); err != nil {
+		t.Fatalf("Failed to write ref: %v", err)
+	}
+}
+
+func writeTestTag(t *testing.T, repo *repository.Repo, target oid.ObjectID) oid.ObjectID {
+	kvData := kv.CreateOrderedMap()
+	kvData.Add("object", target.String())
+	kvData.Add("type", "commit")
+	kvData.Add("tag", "v1")
+	kvData.Add("message", "release")
+	hash, err := object.WriteObject(repo.Directory, object.CreateTag(kv.Serialize(kvData)))
+	if err != nil {
+		t.Fatalf("Failed to write tag: %v", err)
+	}
+	return hash
+}
+
+// history writes a root, two branches off it, a merge and an octopus merge of three commits:
+//
+//	root <- left  <- merge <- octopus
+//	     <- right <-/         /
+//	     <- side  <----------/
+type history struct {
+	root, left, right, side, merge, octopus, lost oid.ObjectID
+}
+
+func writeHistory(t *testing.T, repo *repository.Repo) *history {
+	h := &history{}
+	h.root = writeTestCommit(t, repo, nil, 1000, "root")
+	h.left = writeTestCommit(t, repo, []oid.ObjectID{h.root}, 2000, "left")
+	h.right = writeTestCommit(t, repo, []oid.ObjectID{h.root}, 2100, "right")
+	h.side = writeTestCommit(t, repo, []oid.ObjectID{h.root}, 2200, "side")
+	h.merge = writeTestCommit(t, repo, []oid.ObjectID{h.left, h.right}, 3000, "merge")
+	h.octopus = writeTestCommit(t, repo, []oid.ObjectID{h.merge, h.right, h.side}, 4000, "octopus")
+	h.lost = writeTestCommit(t, repo, []oid.ObjectID{h.root}, 5000, "lost")
+
+	writeTestRef(t, repo, "refs/heads/master", h.merge)
+	writeTestRef(t, repo, "refs/tags/v1", writeTestTag(t, repo, h.octopus))
+	return h
+}
+
+func TestWriteAndLookup(t *testing.T) {
+	repo := createTestRepo(t)
+	h := writeHistory(t, repo)
+
+	count, err := Write(repo)
+	if err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if count != 6 {
+		t.Errorf("Expected 6 reachable commits, got %d", count)
+	}
+
+	graph, err := Load(repo.Directory)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if graph.Contains(h.lost) {
+		t.Errorf("Expected the unreachable commit to be left out")
+	}
+
+	expected := []struct {
+		id         oid.ObjectID
+		parents    []oid.ObjectID
+		generation uint32
+		when       int64
+	}{
+		{h.root, nil, 1, 1000},
+		{h.left, []oid.ObjectID{h.root}, 2, 2000},
+		{h.merge, []oid.ObjectID{h.left, h.right}, 3, 3000},
+		{h.octopus, []oid.ObjectID{h.merge, h.right, h.side}, 4, 4000},
+	}
+	for _, e := range expected {
+		commit, ok, err := graph.Lookup(e.id)
+		if err != nil || !ok {
+			t.Fatalf("Lookup(%s) failed: %v", e.id, err)
+		}
+
+		parsed, err := object.ReadCommit(repo, e.id)
+		if err != nil {
+			t.Fatalf("ReadCommit failed: %v", err)
+		}
+		if commit.Tree != parsed.TreeHash() || !equalIDs(commit.Parents, e.parents) {
+			t.Errorf("Expected tree %s and parents %v for %s, got %+v", parsed.TreeHash(), e.parents, e.id, commit)
+		}
+		if commit.Generation != e.generation || commit.When.Unix() != e.when {
+			t.Errorf("Expected generation %d at %d for %s, got %d at %d", e.generation, e.when, e.id, commit.Generation, commit.When.Unix())
+		}
+	}
+
+	if err := Verify(repo); err != nil {
+		t.Errorf("Verify failed on a fresh graph: %v", err)
+	}
+}
+
+func TestReader(t *testing.T) {
+	repo := createTestRepo(t)
+	h := writeHistory(t, repo)
+
+	if _, err := Load(repo.Directory); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound without a graph, got %v", err)
+	}
+	if commit, err := NewReader(repo).Commit(h.merge); err != nil || commit.Generation != GenerationInfinity {
+		t.Errorf("Expected a parsed commit without a graph, got %+v (%v)", commit, err)
+	}
+
+	if _, err := Write(repo); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	// Commits in the graph no longer need their object
+	if err := os.Remove(object.LoosePath(repo.Directory, h.merge)); err != nil {
+		t.Fatalf("Failed to remove commit: %v", err)
+	}
+	reader := NewReader(repo)
+	if commit, err := reader.Commit(h.merge); err != nil || commit.Generation != 3 || len(commit.Parents) != 2 {
+		t.Errorf("Expected the merge from the graph, got %+v (%v)", commit, err)
+	}
+	if commit, err := reader.Commit(h.lost); err != nil || commit.Generation != GenerationInfinity || commit.Parents[0] != h.root {
+		t.Errorf("Expected the commit outside the graph to be parsed, got %+v (%v)", commit, err)
+	}
+	if err := Verify(repo); err == nil {
+		t.Errorf("Expected Verify to report the missing commit")
+	}
+}
+
+func TestReaderDisabled(t *testing.T) {
+	repo := createTestRepo(t)
+	h := writeHistory(t, repo)
+	if _, err := Write(repo); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(repo.Directory, "config"), []byte("[core]\n\tcommitGraph = false\n"), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+
+	if commit, err := NewReader(repo).Commit(h.merge); err != nil || commit.Generation != GenerationInfinity {
+		t.Errorf("Expected the graph to be ignored, got %+v (%v)", commit, err)
+	}
+}
+
+func TestWriteIncompleteHistory(t *testing.T) {
+	repo := createTestRepo(t)
+	h := writeHistory(t, repo)
+
+	// Without the right branch, only the commits before it are complete
+	if err := os.Remove(object.LoosePath(repo.Directory, h.right)); err != nil {
+		t.Fatalf("Failed to remove commit: %v", err)
+	}
+
+	count, err := Write(repo)
+	if err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	graph, err := Load(repo.Directory)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	for _, id := range []oid.ObjectID{h.right, h.merge, h.octopus} {
+		if graph.Contains(id) {
+			t.Errorf("Expected %s to be left out", id)
+		}
+	}
+	if count != 3 || !graph.Contains(h.left) || !graph.Contains(h.side) {
+		t.Errorf("Expected root, left and side in the graph, got %v", graph.IDs())
+	}
+}
+
+func TestVerifyCorruption(t *testing.T) {
+	repo := createTestRepo(t)
+	writeHistory(t, repo)
+	if _, err := Write(repo); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	path := Path(repo.Directory)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read graph: %v", err)
+	}
+	data[len(data)-40] ^= 0xff
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatalf("Failed to write graph: %v", err)
+	}
+
+	if err := Verify(repo); err == nil {
+		t.Errorf("Expected Verify to detect the damage")
+	}
+}
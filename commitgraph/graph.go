@@ -0,0 +1,304 @@
+package commitgraph
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"orf/chunkfile"
+	"orf/oid"
+	"orf/repository"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrNotFound is returned when a repository has no commit-graph.
+var ErrNotFound = errors.New("no commit-graph")
+
+var signature = []byte("CGPH")
+
+// Chunks of a version 1 commit-graph.
+const (
+	chunkOIDFanout  = 0x4f494446 // "OIDF": number of commits by first id byte
+	chunkOIDLookup  = 0x4f49444c // "OIDL": sorted commit ids
+	chunkCommitData = 0x43444154 // "CDAT": tree, parents, generation and date of each commit
+	chunkExtraEdges = 0x45444745 // "EDGE": parents past the first of octopus merges
+)
+
+const (
+	headerSize = 8
+
+	parentNone     = 0x70000000
+	parentOctopus  = 0x80000000
+	lastEdge       = 0x80000000
+	maxGeneration  = 0x3fffffff
+	maxDate        = 1<<34 - 1
+	commitDataBase = 16 // Besides the tree id: 2 parents, then generation and date
+)
+
+// GenerationInfinity is the generation of commits that are not in the graph. A commit in the
+// graph has a generation one more than the highest of its parents (1 for a root commit), so it
+// is never an ancestor of a commit whose generation is lower or equal.
+const GenerationInfinity = math.MaxUint32
+
+// Path returns the path of the commit-graph of a .orf directory.
+func Path(directory string) string {
+	return filepath.Join(directory, "objects", "info", "commit-graph")
+}
+
+// Commit is what the graph records of a commit.
+type Commit struct {
+	ID         oid.ObjectID
+	Tree       oid.ObjectID
+	Parents    []oid.ObjectID
+	When       time.Time
+	Generation uint32
+}
+
+// Graph is an opened commit-graph. Every parent of a commit in the graph is in the graph too.
+type Graph struct {
+	Path     string
+	Checksum oid.ObjectID
+
+	algorithm oid.Algorithm
+	data      []byte
+	ids       []oid.ObjectID
+	commits   []byte
+	edges     []byte
+	chunks    map[uint32][]byte
+
+	// bloomIndex and bloomData hold the changed-path Bloom filters, if the graph has them
+	bloomIndex  []byte
+	bloomData   []byte
+	bloomHashes uint32
+}
+
+// hashVersion is the id of an algorithm in the commit-graph header.
+func hashVersion(algorithm oid.Algorithm) byte {
+	if algorithm == oid.SHA1 {
+		return 1
+	}
+	return 2
+}
+
+// Open reads a commit-graph file whose ids are hashed with algorithm.
+func Open(path string, algorithm oid.Algorithm) (*Graph, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	hashSize := algorithm.Size()
+	if len(data) < headerSize+hashSize || !bytes.Equal(data[:4], signature) || data[4] != 1 {
+		return nil, fmt.Errorf("invalid commit-graph %s", path)
+	}
+	if data[5] != hashVersion(algorithm) {
+		return nil, fmt.Errorf("commit-graph %s does not use %s", path, algorithm)
+	}
+	if data[7] != 0 {
+		return nil, fmt.Errorf("commit-graph %s: chains of graphs are not supported", path)
+	}
+
+	chunks, err := chunkfile.Read(data[:len(data)-hashSize], headerSize, int(data[6]))
+	if err != nil {
+		return nil, fmt.Errorf("invalid commit-graph %s: %w", path, err)
+	}
+
+	fanout, lookup, commits := chunks[chunkOIDFanout], chunks[chunkOIDLookup], chunks[chunkCommitData]
+	if len(fanout) != 256*4 {
+		return nil, fmt.Errorf("invalid commit-graph %s: bad fanout", path)
+	}
+	count := int(binary.BigEndian.Uint32(fanout[255*4:]))
+	if len(lookup) != count*hashSize || len(commits) != count*(hashSize+commitDataBase) {
+		return nil, fmt.Errorf("invalid commit-graph %s: expected %d commits", path, count)
+	}
+
+	graph := &Graph{
+		Path:      path,
+		algorithm: algorithm,
+		data:      data,
+		ids:       make([]oid.ObjectID, count),
+		commits:   commits,
+		edges:     chunks[chunkExtraEdges],
+		chunks:    chunks,
+	}
+	for i := range graph.ids {
+		if graph.ids[i], err = oid.FromBytes(lookup[i*hashSize : (i+1)*hashSize]); err != nil {
+			return nil, err
+		}
+	}
+	if graph.Checksum, err = oid.FromBytes(data[len(data)-hashSize:]); err != nil {
+		return nil, err
+	}
+
+	// Like git, filters of another version or with a bad index are ignored rather than rejected
+	index, filters := chunks[chunkBloomIndex], chunks[chunkBloomData]
+	if len(index) == count*4 && len(filters) >= bloomHeaderSize && binary.BigEndian.Uint32(filters) == bloomHashVersion {
+		if hashes := binary.BigEndian.Uint32(filters[4:]); hashes > 0 && hashes <= 32 {
+			graph.bloomIndex = index
+			graph.bloomData = filters[bloomHeaderSize:]
+			graph.bloomHashes = hashes
+		}
+	}
+
+	return graph, nil
+}
+
+// IDs returns the ids of the commits in the graph, in order.
+func (graph *Graph) IDs() []oid.ObjectID {
+	return graph.ids
+}
+
+// find returns the position of an id in the graph, or -1.
+func (graph *Graph) find(id oid.ObjectID) int {
+	i := sort.Search(len(graph.ids), func(i int) bool { return graph.ids[i] >= id })
+	if i < len(graph.ids) && graph.ids[i] == id {
+		return i
+	}
+	return -1
+}
+
+// Contains reports whether a commit is in the graph.
+func (graph *Graph) Contains(id oid.ObjectID) bool {
+	return graph.find(id) != -1
+}
+
+// Lookup returns what the graph records of a commit, reporting whether it is in the graph.
+func (graph *Graph) Lookup(id oid.ObjectID) (*Commit, bool, error) {
+	i := graph.find(id)
+	if i == -1 {
+		return nil, false, nil
+	}
+
+	commit, err := graph.commit(i)
+	return commit, err == nil, err
+}
+
+// commit decodes the commit at a position of the graph.
+func (graph *Graph) commit(i int) (*Commit, error) {
+	hashSize := graph.algorithm.Size()
+	entry := graph.commits[i*(hashSize+commitDataBase) : (i+1)*(hashSize+commitDataBase)]
+
+	tree, err := oid.FromBytes(entry[:hashSize])
+	if err != nil {
+		return nil, err
+	}
+	commit := &Commit{ID: graph.ids[i], Tree: tree}
+
+	first := binary.BigEndian.Uint32(entry[hashSize:])
+	second := binary.BigEndian.Uint32(entry[hashSize+4:])
+	if first != parentNone {
+		if commit.Parents, err = graph.parents(commit.Parents, first); err != nil {
+			return nil, err
+		}
+	}
+
+	switch {
+	case second == parentNone:
+	case second&parentOctopus == 0:
+		if commit.Parents, err = graph.parents(commit.Parents, second); err != nil {
+			return nil, err
+		}
+	default:
+		for at := int(second &^ parentOctopus); ; at++ {
+			if (at+1)*4 > len(graph.edges) {
+				return nil, fmt.Errorf("invalid commit-graph %s: bad edge of %s", graph.Path, commit.ID)
+			}
+			edge := binary.BigEndian.Uint32(graph.edges[at*4:])
+			if commit.Parents, err = graph.parents(commit.Parents, edge&^lastEdge); err != nil {
+				return nil, err
+			}
+			if edge&lastEdge != 0 {
+				break
+			}
+		}
+	}
+
+	high := binary.BigEndian.Uint32(entry[hashSize+8:])
+	low := binary.BigEndian.Uint32(entry[hashSize+12:])
+	commit.Generation = high >> 2
+	commit.When = time.Unix(int64(high&3)<<32|int64(low), 0)
+
+	return commit, nil
+}
+
+// HasChangedPaths reports whether the graph has changed-path Bloom filters.
+func (graph *Graph) HasChangedPaths() bool {
+	return graph.bloomIndex != nil
+}
+
+// filter returns the changed-path Bloom filter of the commit at a position of the graph, or nil
+// if the graph has none or its offsets are invalid.
+func (graph *Graph) filter(i int) *bloomFilter {
+	if graph.bloomIndex == nil {
+		return nil
+	}
+
+	start := uint32(0)
+	if i > 0 {
+		start = binary.BigEndian.Uint32(graph.bloomIndex[(i-1)*4:])
+	}
+	end := binary.BigEndian.Uint32(graph.bloomIndex[i*4:])
+	if end < start || int(end) > len(graph.bloomData) {
+		return nil
+	}
+	return &bloomFilter{data: graph.bloomData[start:end], hashes: graph.bloomHashes}
+}
+
+// parents appends the commit at position i to parents.
+func (graph *Graph) parents(parents []oid.ObjectID, i uint32) ([]oid.ObjectID, error) {
+	if int(i) >= len(graph.ids) {
+		return nil, fmt.Errorf("invalid commit-graph %s: bad parent position %d", graph.Path, i)
+	}
+	return append(parents, graph.ids[i]), nil
+}
+
+// verifyChecksum checks the trailing checksum of the graph file.
+func (graph *Graph) verifyChecksum() error {
+	hashSize := graph.algorithm.Size()
+	if sum := graph.algorithm.Sum(graph.data[:len(graph.data)-hashSize]); sum != graph.Checksum {
+		return fmt.Errorf("commit-graph %s has checksum %s, expected %s", graph.Path, graph.Checksum, sum)
+	}
+	return nil
+}
+
+// openedGraph is a cached Graph, valid while its file is unchanged.
+type openedGraph struct {
+	modTime time.Time
+	size    int64
+	graph   *Graph
+}
+
+// opened caches the graphs read by Load, keyed by their path.
+var opened sync.Map
+
+// Load returns the commit-graph of a .orf directory, or ErrNotFound. The file is only read
+// again when it changes.
+func Load(directory string) (*Graph, error) {
+	path := Path(directory)
+	info, err := os.Stat(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	if cached, ok := opened.Load(path); ok {
+		cached := cached.(*openedGraph)
+		if cached.modTime.Equal(info.ModTime()) && cached.size == info.Size() {
+			return cached.graph, nil
+		}
+	}
+
+	graph, err := Open(path, repository.ObjectFormat(directory))
+	if err != nil {
+		return nil, err
+	}
+	opened.Store(path, &openedGraph{modTime: info.ModTime(), size: info.Size(), graph: graph})
+	return graph, nil
+}
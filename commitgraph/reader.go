@@ -0,0 +1,60 @@
+package commitgraph
+
+import (
+	"orf/object"
+	"orf/oid"
+	"orf/repository"
+	"time"
+)
+
+// Reader looks commits up in the commit-graph of a repository, and parses the commits that are
+// not in it (or all of them, without a usable graph or with core.commitGraph false).
+type Reader struct {
+	repo         *repository.Repo
+	graph        *Graph
+	changedPaths bool
+}
+
+// NewReader returns a Reader for a repository. A missing or invalid commit-graph is ignored.
+func NewReader(repo *repository.Repo) *Reader {
+	settings := repository.DirectorySettings(repo.Directory)
+	reader := &Reader{repo: repo, changedPaths: settings.ChangedPaths}
+	if settings.CommitGraph {
+		if graph, err := Load(repo.Directory); err == nil {
+			reader.graph = graph
+		}
+	}
+	return reader
+}
+
+// Commit returns the tree, parents, committer date and generation of a commit. Commits that
+// are not in the graph are parsed, and have GenerationInfinity.
+func (reader *Reader) Commit(id oid.ObjectID) (*Commit, error) {
+	if reader.graph != nil {
+		if commit, ok, err := reader.graph.Lookup(id); ok && err == nil {
+			return commit, nil
+		}
+	}
+
+	parsed, err := object.ReadCommit(reader.repo, id)
+	if err != nil {
+		return nil, err
+	}
+	return fromObject(id, parsed), nil
+}
+
+// fromObject returns what a commit-graph records of a parsed commit, with GenerationInfinity.
+func fromObject(id oid.ObjectID, commit *object.Commit) *Commit {
+	var when time.Time
+	if committer, err := commit.Committer(); err == nil {
+		when = time.Unix(committer.When.Unix(), 0)
+	}
+
+	return &Commit{
+		ID:         id,
+		Tree:       commit.TreeHash(),
+		Parents:    commit.Parents(),
+		When:       when,
+		Generation: GenerationInfinity,
+	}
+}
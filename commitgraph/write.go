@@ -0,0 +1,431 @@
+package commitgraph
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"orf/chunkfile"
+	"orf/object"
+	"orf/oid"
+	"orf/repository"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Write replaces the commit-graph of a repository with one holding every commit reachable from
+// its refs, reflogs and HEAD-like files, and returns the number of commits written. Commits
+// whose history cannot be read in full are left out, as every parent of a commit in the graph
+// must be in it too.
+func Write(repo *repository.Repo) (int, error) {
+	tips, err := tips(repo)
+	if err != nil {
+		return 0, err
+	}
+
+	// Filters of commits already in the graph are kept rather than computed again
+	previous, err := Load(repo.Directory)
+	if err != nil {
+		previous = nil
+	}
+
+	w := &writer{
+		repo:        repo,
+		previous:    previous,
+		commits:     make(map[oid.ObjectID]*Commit),
+		generations: make(map[oid.ObjectID]uint32),
+		incomplete:  make(map[oid.ObjectID]bool),
+	}
+	for _, tip := range tips {
+		w.visit(tip)
+	}
+
+	ids := make([]oid.ObjectID, 0, len(w.generations))
+	for id := range w.generations {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	chunks, err := w.chunks(ids)
+	if err != nil {
+		return 0, err
+	}
+	if err := writeFile(repo.Directory, chunks); err != nil {
+		return 0, err
+	}
+
+	return len(ids), nil
+}
+
+// tips returns the commits the roots of a repository point to, peeling tags. Roots that are
+// not commits, or cannot be read, are skipped.
+func tips(repo *repository.Repo) ([]oid.ObjectID, error) {
+	roots, err := object.ListRoots(repo)
+	if err != nil {
+		return nil, err
+	}
+
+	var tips []oid.ObjectID
+	for _, root := range roots {
+		if root.Type == "blob" {
+			continue
+		}
+
+		id := root.ID
+		for {
+			obj, err := object.ReadObject(repo.Directory, id)
+			if err != nil {
+				break
+			}
+			if tag, ok := obj.(*object.Tag); ok {
+				id = tag.Target()
+				continue
+			}
+			if _, ok := obj.(*object.Commit); ok {
+				tips = append(tips, id)
+			}
+			break
+		}
+	}
+
+	return tips, nil
+}
+
+// writer collects the commits of a commit-graph and their generations.
+type writer struct {
+	repo        *repository.Repo
+	previous    *Graph
+	commits     map[oid.ObjectID]*Commit
+	generations map[oid.ObjectID]uint32
+	incomplete  map[oid.ObjectID]bool
+}
+
+// visit computes the generation of a commit and of its ancestors, parents first. Commits that
+// cannot be read, and their descendants, are marked incomplete.
+func (w *writer) visit(tip oid.ObjectID) {
+	done := func(id oid.ObjectID) bool {
+		_, ok := w.generations[id]
+		return ok || w.incomplete[id]
+	}
+
+	stack := []oid.ObjectID{tip}
+	for len(stack) > 0 {
+		id := stack[len(stack)-1]
+		if done(id) {
+			stack = stack[:len(stack)-1]
+			continue
+		}
+
+		commit, ok := w.commits[id]
+		if !ok {
+			parsed, err := object.ReadCommit(w.repo, id)
+			if err != nil {
+				w.incomplete[id] = true
+				stack = stack[:len(stack)-1]
+				continue
+			}
+			commit = fromObject(id, parsed)
+			w.commits[id] = commit
+		}
+
+		pending := false
+		for _, parent := range commit.Parents {
+			if !done(parent) {
+				stack = append(stack, parent)
+				pending = true
+			}
+		}
+		if pending {
+			continue
+		}
+		stack = stack[:len(stack)-1]
+
+		generation := uint32(1)
+		for _, parent := range commit.Parents {
+			if w.incomplete[parent] {
+				w.incomplete[id] = true
+				break
+			}
+			if w.generations[parent] >= generation {
+				generation = w.generations[parent] + 1
+			}
+		}
+		if !w.incomplete[id] {
+			w.generations[id] = min(generation, maxGeneration)
+		}
+	}
+}
+
+// chunks encodes the fanout, ids, commit data and extra edges of the sorted commits.
+func (w *writer) chunks(ids []oid.ObjectID) ([]chunkfile.Chunk, error) {
+	algorithm := w.repo.ObjectFormat()
+	hashSize := algorithm.Size()
+
+	positions := make(map[oid.ObjectID]uint32, len(ids))
+	fanout := make([]byte, 256*4)
+	lookup := make([]byte, 0, len(ids)*hashSize)
+	for i, id := range ids {
+		raw := id.Bytes()
+		if len(raw) != hashSize {
+			return nil, fmt.Errorf("invalid commit id %s", id)
+		}
+		positions[id] = uint32(i)
+		lookup = append(lookup, raw...)
+		for b := int(raw[0]); b < 256; b++ {
+			binary.BigEndian.PutUint32(fanout[b*4:], uint32(i+1))
+		}
+	}
+
+	data := make([]byte, 0, len(ids)*(hashSize+commitDataBase))
+	var edges []byte
+	for _, id := range ids {
+		commit := w.commits[id]
+		data = append(data, commit.Tree.Bytes()...)
+
+		parents := []uint32{parentNone, parentNone}
+		for i, parent := range commit.Parents {
+			if i < 2 {
+				parents[i] = positions[parent]
+			}
+		}
+		if len(commit.Parents) > 2 {
+			parents[1] = parentOctopus | uint32(len(edges)/4)
+			for i, parent := range commit.Parents[1:] {
+				edge := positions[parent]
+				if i == len(commit.Parents)-2 {
+					edge |= lastEdge
+				}
+				edges = binary.BigEndian.AppendUint32(edges, edge)
+			}
+		}
+		data = binary.BigEndian.AppendUint32(data, parents[0])
+		data = binary.BigEndian.AppendUint32(data, parents[1])
+
+		date := encodeDate(commit.When)
+		data = binary.BigEndian.AppendUint32(data, w.generations[id]<<2|uint32(date>>32))
+		data = binary.BigEndian.AppendUint32(data, uint32(date))
+	}
+
+	chunks := []chunkfile.Chunk{
+		{ID: chunkOIDFanout, Data: fanout},
+		{ID: chunkOIDLookup, Data: lookup},
+		{ID: chunkCommitData, Data: data},
+	}
+	if len(edges) > 0 {
+		chunks = append(chunks, chunkfile.Chunk{ID: chunkExtraEdges, Data: edges})
+	}
+
+	if repository.DirectorySettings(w.repo.Directory).ChangedPaths {
+		index, filters := w.filters(ids)
+		chunks = append(chunks, chunkfile.Chunk{ID: chunkBloomIndex, Data: index}, chunkfile.Chunk{ID: chunkBloomData, Data: filters})
+	}
+	return chunks, nil
+}
+
+// filters encodes the changed-path Bloom filter of each of the sorted commits: the offset
+// where each ends, and the filters after a header of their hash version, number of hashes and
+// bits per path.
+func (w *writer) filters(ids []oid.ObjectID) ([]byte, []byte) {
+	index := make([]byte, 0, len(ids)*4)
+	data := binary.BigEndian.AppendUint32(nil, bloomHashVersion)
+	data = binary.BigEndian.AppendUint32(data, bloomHashes)
+	data = binary.BigEndian.AppendUint32(data, bloomBitsPerEntry)
+
+	for _, id := range ids {
+		data = append(data, w.filter(id).data...)
+		index = binary.BigEndian.AppendUint32(index, uint32(len(data)-bloomHeaderSize))
+	}
+	return index, data
+}
+
+// filter returns the changed-path Bloom filter of a commit, from the previous graph if it has
+// one. Commits whose trees cannot be read get an empty filter, which matches every path.
+func (w *writer) filter(id oid.ObjectID) *bloomFilter {
+	if w.previous != nil && w.previous.bloomHashes == bloomHashes {
+		if i := w.previous.find(id); i != -1 {
+			if filter := w.previous.filter(i); filter != nil && len(filter.data) > 0 {
+				return filter
+			}
+		}
+	}
+
+	commit := w.commits[id]
+	var parentTree oid.ObjectID
+	if len(commit.Parents) > 0 {
+		parentTree = w.commits[commit.Parents[0]].Tree
+	}
+
+	filter, err := commitFilter(w.repo, parentTree, commit.Tree)
+	if err != nil {
+		return &bloomFilter{hashes: bloomHashes}
+	}
+	return filter
+}
+
+// writeFile writes the commit-graph of a .orf directory under a temporary name and renames it
+// into place, so readers never see a partial graph.
+func writeFile(directory string, chunks []chunkfile.Chunk) error {
+	dir := filepath.Dir(Path(directory))
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return err
+	}
+
+	file, err := os.CreateTemp(dir, "tmp_graph_")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	settings := repository.DirectorySettings(directory)
+	buffered := bufio.NewWriter(file)
+	hasher := settings.Format.New()
+	out := io.MultiWriter(buffered, hasher)
+
+	header := []byte{'C', 'G', 'P', 'H', 1, hashVersion(settings.Format), byte(len(chunks)), 0}
+	if _, err := out.Write(header); err != nil {
+		return err
+	}
+	if err := chunkfile.Write(out, len(header), chunks); err != nil {
+		return err
+	}
+	if _, err := buffered.Write(hasher.Sum(nil)); err != nil {
+		return err
+	}
+	if err := buffered.Flush(); err != nil {
+		return err
+	}
+
+	if settings.Fsync&repository.FsyncCommitGraph != 0 {
+		if err := file.Sync(); err != nil {
+			return err
+		}
+	}
+	if err := file.Chmod(0644); err != nil {
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(file.Name(), Path(directory))
+}
+
+// Verify checks the commit-graph of a repository: its checksum, and that every commit it
+// records matches the commit object and its changed-path Bloom filter holds the paths it
+// changes. It returns ErrNotFound without a commit-graph.
+func Verify(repo *repository.Repo) error {
+	graph, err := Load(repo.Directory)
+	if err != nil {
+		return err
+	}
+	if err := graph.verifyChecksum(); err != nil {
+		return err
+	}
+
+	for i, id := range graph.ids {
+		if i > 0 && graph.ids[i-1] >= id {
+			return fmt.Errorf("commit-graph ids are not sorted at %s", id)
+		}
+
+		recorded, err := graph.commit(i)
+		if err != nil {
+			return err
+		}
+		obj, err := object.VerifyObject(repo.Directory, id)
+		if err != nil {
+			return fmt.Errorf("commit-graph has %s: %w", id, err)
+		}
+		parsed, ok := obj.(*object.Commit)
+		if !ok {
+			return fmt.Errorf("commit-graph has %s, a %s", id, obj.GetFormat())
+		}
+
+		actual := fromObject(id, parsed)
+		if recorded.Tree != actual.Tree || encodeDate(recorded.When) != encodeDate(actual.When) || !equalIDs(recorded.Parents, actual.Parents) {
+			return fmt.Errorf("commit-graph does not match commit %s", id)
+		}
+
+		generation := uint32(1)
+		for _, parent := range recorded.Parents {
+			p, _, err := graph.Lookup(parent)
+			if err != nil {
+				return err
+			}
+			if p.Generation >= generation {
+				generation = p.Generation + 1
+			}
+		}
+		if recorded.Generation != min(generation, maxGeneration) {
+			return fmt.Errorf("commit-graph has generation %d for %s, expected %d", recorded.Generation, id, generation)
+		}
+
+		if err := verifyFilter(repo, graph, i, recorded); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// verifyFilter checks that the changed-path Bloom filter of the commit at a position of the
+// graph, if it has one, matches every path the commit changes.
+func verifyFilter(repo *repository.Repo, graph *Graph, i int, commit *Commit) error {
+	if !graph.HasChangedPaths() {
+		return nil
+	}
+
+	filter := graph.filter(i)
+	if filter == nil {
+		return fmt.Errorf("commit-graph has an invalid changed-path filter offset for %s", commit.ID)
+	}
+	if len(filter.data) == 0 {
+		return nil
+	}
+
+	var parentTree oid.ObjectID
+	if len(commit.Parents) > 0 {
+		parent, _, err := graph.Lookup(commit.Parents[0])
+		if err != nil {
+			return err
+		}
+		parentTree = parent.Tree
+	}
+
+	// The changes of commits changing too many paths are not listed, nor their filter checked
+	paths, err := changedPaths(repo, parentTree, commit.Tree)
+	if errors.Is(err, errTooManyChanges) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("commit-graph has %s: %w", commit.ID, err)
+	}
+
+	for _, path := range paths {
+		if !filter.MaybeContains(path) {
+			return fmt.Errorf("commit-graph changed-path filter of %s is missing %s", commit.ID, path)
+		}
+	}
+	return nil
+}
+
+// encodeDate returns the commit date recorded in the graph: seconds since the epoch, within
+// the 34 bits the format has for them.
+func encodeDate(when time.Time) uint64 {
+	return uint64(min(max(when.Unix(), 0), maxDate))
+}
+
+func equalIDs(a, b []oid.ObjectID) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
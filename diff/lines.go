@@ -0,0 +1,187 @@
+package diff
+
+import "strings"
+
+// Op is the kind of a line edit.
+type Op int
+
+const (
+	Equal Op = iota
+	Insert
+	Delete
+)
+
+// Edit is one line of a line diff. Old and New are the line's indexes in the old and new
+// input; Old is -1 for inserted lines and New is -1 for deleted lines.
+type Edit struct {
+	Op   Op
+	Old  int
+	New  int
+	Text string
+}
+
+// SplitLines splits data into lines, keeping each line's trailing newline.
+func SplitLines(data []byte) []string {
+	if len(data) == 0 {
+		return nil
+	}
+	lines := strings.SplitAfter(string(data), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// Lines returns a shortest edit script turning a into b, using Myers' O(ND) algorithm.
+// Deletions are listed before insertions within each changed region.
+func Lines(a, b []string) []Edit {
+	n, m := len(a), len(b)
+	max := n + m
+	offset := max + 1
+
+	// v[k+offset] is the furthest x reached on diagonal k; trace keeps a copy per edit distance
+	v := make([]int, 2*max+3)
+	var trace [][]int
+
+search:
+	for d := 0; d <= max; d++ {
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[k-1+offset] < v[k+1+offset]) {
+				x = v[k+1+offset]
+			} else {
+				x = v[k-1+offset] + 1
+			}
+
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[k+offset] = x
+
+			if x >= n && y >= m {
+				trace = append(trace, append([]int{}, v...))
+				break search
+			}
+		}
+		trace = append(trace, append([]int{}, v...))
+	}
+
+	// Walk the trace backwards from (n, m), collecting edits in reverse
+	var edits []Edit
+	x, y := n, m
+	for d := len(trace) - 1; d >= 0 && (x > 0 || y > 0); d-- {
+		k := x - y
+
+		var prevK int
+		if d == 0 {
+			prevK = k
+		} else if k == -d || (k != d && trace[d-1][k-1+offset] < trace[d-1][k+1+offset]) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+
+		prevX := 0
+		if d > 0 {
+			prevX = trace[d-1][prevK+offset]
+		}
+		prevY := prevX - prevK
+
+		for x > prevX && y > prevY {
+			x--
+			y--
+			edits = append(edits, Edit{Op: Equal, Old: x, New: y, Text: a[x]})
+		}
+
+		if d == 0 {
+			break
+		}
+
+		if x == prevX {
+			y--
+			edits = append(edits, Edit{Op: Insert, Old: -1, New: y, Text: b[y]})
+		} else {
+			x--
+			edits = append(edits, Edit{Op: Delete, Old: x, New: -1, Text: a[x]})
+		}
+	}
+
+	for i, j := 0, len(edits)-1; i < j; i, j = i+1, j-1 {
+		edits[i], edits[j] = edits[j], edits[i]
+	}
+
+	return edits
+}
+
+// Hunk is a run of changed lines with the unchanged lines around them, as a unified diff shows
+// it. The starts count from 1, and are the line before the hunk when it has no lines on that
+// side.
+type Hunk struct {
+	OldStart int
+	OldLines int
+	NewStart int
+	NewLines int
+	Edits    []Edit
+}
+
+// Hunks groups the changes of an edit script into hunks with up to context unchanged lines
+// around each change. Changes closer than twice the context share a hunk.
+func Hunks(edits []Edit, context int) []Hunk {
+	var hunks []Hunk
+	for i := 0; i < len(edits); {
+		for i < len(edits) && edits[i].Op == Equal {
+			i++
+		}
+		if i == len(edits) {
+			break
+		}
+
+		// Extend the hunk over every change the next context reaches
+		end := i
+		for {
+			for end < len(edits) && edits[end].Op != Equal {
+				end++
+			}
+			next := end
+			for next < len(edits) && edits[next].Op == Equal {
+				next++
+			}
+			if next == len(edits) || next-end > 2*context {
+				break
+			}
+			end = next
+		}
+
+		start := max(i-context, 0)
+		stop := min(end+context, len(edits))
+		hunk := Hunk{Edits: edits[start:stop]}
+		for _, edit := range edits[:start] {
+			if edit.Op != Insert {
+				hunk.OldStart++
+			}
+			if edit.Op != Delete {
+				hunk.NewStart++
+			}
+		}
+		for _, edit := range hunk.Edits {
+			if edit.Op != Insert {
+				hunk.OldLines++
+			}
+			if edit.Op != Delete {
+				hunk.NewLines++
+			}
+		}
+		if hunk.OldLines > 0 {
+			hunk.OldStart++
+		}
+		if hunk.NewLines > 0 {
+			hunk.NewStart++
+		}
+
+		hunks = append(hunks, hunk)
+		i = stop
+	}
+	return hunks
+}
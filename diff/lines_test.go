@@ -0,0 +1,132 @@
+package diff
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// applyEdits rebuilds both inputs from an edit script.
+func applyEdits(edits []Edit) (string, string) {
+	var old, new strings.Builder
+	for _, edit := range edits {
+		if edit.Op != Insert {
+			old.WriteString(edit.Text)
+		}
+		if edit.Op != Delete {
+			new.WriteString(edit.Text)
+		}
+	}
+	return old.String(), new.String()
+}
+
+func TestSplitLines(t *testing.T) {
+	tests := map[string][]string{
+		"":            nil,
+		"a":           {"a"},
+		"a\n":         {"a\n"},
+		"a\nb\n":      {"a\n", "b\n"},
+		"a\n\nb":      {"a\n", "\n", "b"},
+		"no newline ": {"no newline "},
+	}
+
+	for input, expected := range tests {
+		lines := SplitLines([]byte(input))
+		if strings.Join(lines, "|") != strings.Join(expected, "|") || len(lines) != len(expected) {
+			t.Errorf("SplitLines(%q) = %q; expected %q", input, lines, expected)
+		}
+	}
+}
+
+func TestLines(t *testing.T) {
+	tests := []struct {
+		a, b    string
+		changes int
+	}{
+		{"", "", 0},
+		{"a\nb\nc\n", "a\nb\nc\n", 0},
+		{"", "a\nb\n", 2},
+		{"a\nb\n", "", 2},
+		{"a\nb\nc\n", "a\nx\nc\n", 2},
+		{"a\nb\nc\na\nb\nb\na\n", "c\nb\na\nb\na\nc\n", 5},
+	}
+
+	for _, test := range tests {
+		edits := Lines(SplitLines([]byte(test.a)), SplitLines([]byte(test.b)))
+
+		old, new := applyEdits(edits)
+		if old != test.a || new != test.b {
+			t.Errorf("Edits for %q -> %q rebuild %q -> %q", test.a, test.b, old, new)
+		}
+
+		changes := 0
+		for _, edit := range edits {
+			if edit.Op != Equal {
+				changes++
+			}
+		}
+		if changes != test.changes {
+			t.Errorf("Expected %d changes for %q -> %q, got %d", test.changes, test.a, test.b, changes)
+		}
+	}
+}
+
+func TestLinesIndexes(t *testing.T) {
+	a := []string{"a\n", "b\n", "c\n"}
+	b := []string{"a\n", "x\n", "c\n"}
+
+	expected := []Edit{
+		{Op: Equal, Old: 0, New: 0, Text: "a\n"},
+		{Op: Delete, Old: 1, New: -1, Text: "b\n"},
+		{Op: Insert, Old: -1, New: 1, Text: "x\n"},
+		{Op: Equal, Old: 2, New: 2, Text: "c\n"},
+	}
+
+	edits := Lines(a, b)
+	if len(edits) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, edits)
+	}
+	for i := range expected {
+		if edits[i] != expected[i] {
+			t.Errorf("Expected edit %v, got %v", expected[i], edits[i])
+		}
+	}
+}
+
+func TestHunks(t *testing.T) {
+	var old, new []string
+	for i := 1; i <= 20; i++ {
+		line := fmt.Sprintf("%d\n", i)
+		old = append(old, line)
+		switch i {
+		case 3:
+			new = append(new, "three\n")
+		case 12, 16:
+		default:
+			new = append(new, line)
+		}
+	}
+
+	hunks := Hunks(Lines(old, new), 3)
+	expected := []Hunk{
+		{OldStart: 1, OldLines: 6, NewStart: 1, NewLines: 6},
+		{OldStart: 9, OldLines: 11, NewStart: 9, NewLines: 9},
+	}
+	if len(hunks) != len(expected) {
+		t.Fatalf("Expected %d hunks, got %+v", len(expected), hunks)
+	}
+	for i, hunk := range hunks {
+		if hunk.OldStart != expected[i].OldStart || hunk.OldLines != expected[i].OldLines ||
+			hunk.NewStart != expected[i].NewStart || hunk.NewLines != expected[i].NewLines {
+			t.Errorf("Hunk %d = %+v, expected %+v", i, hunk, expected[i])
+		}
+	}
+
+	added := Hunks(Lines(nil, []string{"a\n", "b\n"}), 3)
+	if len(added) != 1 || added[0].OldStart != 0 || added[0].OldLines != 0 || added[0].NewStart != 1 || added[0].NewLines != 2 {
+		t.Errorf("Unexpected hunk for a new file: %+v", added)
+	}
+	if hunks := Hunks(Lines(old, old), 3); len(hunks) != 0 {
+		t.Errorf("Expected no hunks without changes, got %+v", hunks)
+	}
+}
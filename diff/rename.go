@@ -0,0 +1,380 @@
+package diff
+
+import (
+	"hash/fnv"
+	"orf/oid"
+	"sort"
+	"strings"
+
+	"github.com/go-ini/ini"
+)
+
+// ChangeType describes how a path differs between two snapshots.
+type ChangeType int
+
+const (
+	Added ChangeType = iota
+	Deleted
+	Modified
+	Renamed
+	Copied
+)
+
+func (changeType ChangeType) String() string {
+	switch changeType {
+	case Added:
+		return "new file"
+	case Deleted:
+		return "deleted"
+	case Modified:
+		return "modified"
+	case Renamed:
+		return "renamed"
+	case Copied:
+		return "copied"
+	default:
+		return "unknown"
+	}
+}
+
+// Change is a single difference between an old and a new snapshot of paths.
+// Renames and copies carry both paths and the similarity score (0-100) of their contents.
+type Change struct {
+	Type    ChangeType
+	OldPath string
+	NewPath string
+	OldHash oid.ObjectID
+	NewHash oid.ObjectID
+	Score   int
+}
+
+// Path returns the path the change is reported under (the new path, unless deleted).
+func (change Change) Path() string {
+	if change.Type == Deleted {
+		return change.OldPath
+	}
+	return change.NewPath
+}
+
+// Options controls rename and copy detection.
+type Options struct {
+	Renames   bool
+	Copies    bool
+	Threshold int // Minimum similarity percentage for an inexact rename or copy
+	Limit     int // Maximum number of sources or destinations to score, 0 for no limit
+}
+
+// Loader returns the contents of the blob with the given hash.
+type Loader func(hash oid.ObjectID) ([]byte, error)
+
+// DefaultOptions returns rename detection with a 50% similarity threshold and no copy detection.
+func DefaultOptions() Options {
+	return Options{
+		Renames:   true,
+		Copies:    false,
+		Threshold: 50,
+		Limit:     1000,
+	}
+}
+
+// OptionsFromConfig reads the [diff] section of a repository config:
+// renames (true, false or copies), renameThreshold (percentage) and renameLimit.
+func OptionsFromConfig(config *ini.File) Options {
+	opts := DefaultOptions()
+	if config == nil {
+		return opts
+	}
+
+	section := config.Section("diff")
+
+	switch strings.ToLower(section.Key("renames").String()) {
+	case "false", "no", "off", "0":
+		opts.Renames = false
+	case "copies", "copy":
+		opts.Copies = true
+	}
+
+	if threshold, err := section.Key("renameThreshold").Int(); err == nil && threshold >= 0 && threshold <= 100 {
+		opts.Threshold = threshold
+	}
+
+	if limit, err := section.Key("renameLimit").Int(); err == nil && limit >= 0 {
+		opts.Limit = limit
+	}
+
+	return opts
+}
+
+// Compare returns the added, deleted and modified paths between two path -> hash maps,
+// sorted by path.
+func Compare(old map[string]oid.ObjectID, new map[string]oid.ObjectID) []Change {
+	var changes []Change
+
+	for path, oldHash := range old {
+		newHash, exists := new[path]
+		if !exists {
+			changes = append(changes, Change{Type: Deleted, OldPath: path, OldHash: oldHash})
+		} else if newHash != oldHash {
+			changes = append(changes, Change{Type: Modified, OldPath: path, NewPath: path, OldHash: oldHash, NewHash: newHash})
+		}
+	}
+
+	for path, newHash := range new {
+		if _, exists := old[path]; !exists {
+			changes = append(changes, Change{Type: Added, NewPath: path, NewHash: newHash})
+		}
+	}
+
+	sortChanges(changes)
+	return changes
+}
+
+// DetectRenames pairs deleted and added paths into renames, and (if enabled) added paths with
+// any path of the old snapshot into copies. Identical hashes are matched first, then the remaining
+// pairs are scored by content similarity and matched best-first above the threshold.
+// The old map is the full old snapshot, used as the source set for copies.
+func DetectRenames(changes []Change, old map[string]oid.ObjectID, opts Options, load Loader) ([]Change, error) {
+	if !opts.Renames {
+		return changes, nil
+	}
+
+	var added, deleted []*Change
+	var others []Change
+
+	for i := range changes {
+		change := changes[i]
+		switch change.Type {
+		case Added:
+			added = append(added, &change)
+		case Deleted:
+			deleted = append(deleted, &change)
+		default:
+			others = append(others, change)
+		}
+	}
+
+	if len(added) == 0 {
+		return changes, nil
+	}
+
+	// Copy sources are every old path, not just the deleted ones
+	var copySources []string
+	if opts.Copies {
+		for path := range old {
+			copySources = append(copySources, path)
+		}
+		sort.Strings(copySources)
+	}
+
+	var result []Change
+	usedDeleted := make(map[string]bool)
+	pairedAdded := make(map[string]bool)
+
+	// Exact matches: same hash, prefer a deleted path (rename) over a kept one (copy)
+	deletedPaths := make(map[string]bool)
+	deletedByHash := make(map[oid.ObjectID][]*Change)
+	for _, d := range deleted {
+		deletedPaths[d.OldPath] = true
+		deletedByHash[d.OldHash] = append(deletedByHash[d.OldHash], d)
+	}
+
+	for _, a := range added {
+		for _, d := range deletedByHash[a.NewHash] {
+			if usedDeleted[d.OldPath] {
+				continue
+			}
+			usedDeleted[d.OldPath] = true
+			pairedAdded[a.NewPath] = true
+			result = append(result, Change{Type: Renamed, OldPath: d.OldPath, NewPath: a.NewPath, OldHash: d.OldHash, NewHash: a.NewHash, Score: 100})
+			break
+		}
+
+		if pairedAdded[a.NewPath] || !opts.Copies {
+			continue
+		}
+
+		for _, source := range copySources {
+			if old[source] == a.NewHash {
+				pairedAdded[a.NewPath] = true
+				result = append(result, Change{Type: Copied, OldPath: source, NewPath: a.NewPath, OldHash: old[source], NewHash: a.NewHash, Score: 100})
+				break
+			}
+		}
+	}
+
+	// Inexact matches: score every remaining (source, destination) pair
+	var destinations []*Change
+	for _, a := range added {
+		if !pairedAdded[a.NewPath] {
+			destinations = append(destinations, a)
+		}
+	}
+
+	type source struct {
+		path    string
+		hash    oid.ObjectID
+		deleted bool
+	}
+
+	var sources []source
+	for _, d := range deleted {
+		if !usedDeleted[d.OldPath] {
+			sources = append(sources, source{path: d.OldPath, hash: d.OldHash, deleted: true})
+		}
+	}
+	for _, path := range copySources {
+		if deletedPaths[path] {
+			continue
+		}
+		sources = append(sources, source{path: path, hash: old[path], deleted: false})
+	}
+
+	withinLimit := opts.Limit == 0 || (len(sources) <= opts.Limit && len(destinations) <= opts.Limit)
+	if len(destinations) > 0 && len(sources) > 0 && withinLimit {
+		contents := make(map[oid.ObjectID][]byte)
+		read := func(hash oid.ObjectID) ([]byte, error) {
+			if data, ok := contents[hash]; ok {
+				return data, nil
+			}
+			data, err := load(hash)
+			if err != nil {
+				return nil, err
+			}
+			contents[hash] = data
+			return data, nil
+		}
+
+		type candidate struct {
+			src   int
+			dst   int
+			score int
+		}
+
+		var candidates []candidate
+		for di, dst := range destinations {
+			dstData, err := read(dst.NewHash)
+			if err != nil {
+				return nil, err
+			}
+
+			for si, src := range sources {
+				srcData, err := read(src.hash)
+				if err != nil {
+					return nil, err
+				}
+
+				// Skip pairs whose sizes alone rule out reaching the threshold
+				if maxSimilarity(len(srcData), len(dstData)) < opts.Threshold {
+					continue
+				}
+
+				score := Similarity(srcData, dstData)
+				if score >= opts.Threshold {
+					candidates = append(candidates, candidate{src: si, dst: di, score: score})
+				}
+			}
+		}
+
+		// Best scores first; renames win ties against copies, then order by path for stability
+		sort.SliceStable(candidates, func(i, j int) bool {
+			if candidates[i].score != candidates[j].score {
+				return candidates[i].score > candidates[j].score
+			}
+			if sources[candidates[i].src].deleted != sources[candidates[j].src].deleted {
+				return sources[candidates[i].src].deleted
+			}
+			return destinations[candidates[i].dst].NewPath < destinations[candidates[j].dst].NewPath
+		})
+
+		for _, c := range candidates {
+			src := sources[c.src]
+			dst := destinations[c.dst]
+
+			if pairedAdded[dst.NewPath] || (src.deleted && usedDeleted[src.path]) {
+				continue
+			}
+
+			changeType := Copied
+			if src.deleted {
+				changeType = Renamed
+				usedDeleted[src.path] = true
+			}
+
+			pairedAdded[dst.NewPath] = true
+			result = append(result, Change{Type: changeType, OldPath: src.path, NewPath: dst.NewPath, OldHash: src.hash, NewHash: dst.NewHash, Score: c.score})
+		}
+	}
+
+	// Keep everything that was not paired
+	for _, a := range added {
+		if !pairedAdded[a.NewPath] {
+			result = append(result, *a)
+		}
+	}
+	for _, d := range deleted {
+		if !usedDeleted[d.OldPath] {
+			result = append(result, *d)
+		}
+	}
+	result = append(result, others...)
+
+	sortChanges(result)
+	return result, nil
+}
+
+// Similarity scores how much of the content of a is retained in b, as a percentage (0-100).
+// Both are split into line chunks; the score is the number of bytes in common chunks
+// relative to the larger of the two contents.
+func Similarity(a []byte, b []byte) int {
+	if len(a) == 0 && len(b) == 0 {
+		return 100
+	}
+	if len(a) == 0 || len(b) == 0 {
+		return 0
+	}
+
+	chunksA := countChunks(a)
+	chunksB := countChunks(b)
+
+	common := 0
+	for hash, sizeA := range chunksA {
+		if sizeB, exists := chunksB[hash]; exists {
+			common += min(sizeA, sizeB)
+		}
+	}
+
+	return common * 100 / max(len(a), len(b))
+}
+
+// countChunks hashes each line (capped at 64 bytes per chunk) and totals the bytes per hash.
+func countChunks(data []byte) map[uint64]int {
+	const maxChunk = 64
+
+	chunks := make(map[uint64]int)
+	start := 0
+
+	for i := 0; i < len(data); i++ {
+		if data[i] == '\n' || i-start+1 == maxChunk || i == len(data)-1 {
+			hasher := fnv.New64a()
+			hasher.Write(data[start : i+1])
+			chunks[hasher.Sum64()] += i + 1 - start
+			start = i + 1
+		}
+	}
+
+	return chunks
+}
+
+// maxSimilarity returns the best score two contents of the given sizes could reach.
+func maxSimilarity(sizeA int, sizeB int) int {
+	if sizeA == 0 && sizeB == 0 {
+		return 100
+	}
+	return min(sizeA, sizeB) * 100 / max(sizeA, sizeB)
+}
+
+func sortChanges(changes []Change) {
+	sort.SliceStable(changes, func(i, j int) bool {
+		return changes[i].Path() < changes[j].Path()
+	})
+}
@@ -0,0 +1,196 @@
+package diff
+
+import (
+	"fmt"
+	"orf/oid"
+	"strings"
+	"testing"
+
+	"github.com/go-ini/ini"
+)
+
+func loaderFrom(contents map[oid.ObjectID]string) Loader {
+	return func(hash oid.ObjectID) ([]byte, error) {
+		data, ok := contents[hash]
+		if !ok {
+			return nil, fmt.Errorf("missing blob %s", hash)
+		}
+		return []byte(data), nil
+	}
+}
+
+func TestCompare(t *testing.T) {
+	old := map[string]oid.ObjectID{"a.txt": "1", "b.txt": "2", "c.txt": "3"}
+	new := map[string]oid.ObjectID{"a.txt": "1", "b.txt": "4", "d.txt": "5"}
+
+	changes := Compare(old, new)
+
+	expected := []Change{
+		{Type: Modified, OldPath: "b.txt", NewPath: "b.txt", OldHash: "2", NewHash: "4"},
+		{Type: Deleted, OldPath: "c.txt", OldHash: "3"},
+		{Type: Added, NewPath: "d.txt", NewHash: "5"},
+	}
+
+	if len(changes) != len(expected) {
+		t.Fatalf("Expected %d changes, got %d: %v", len(expected), len(changes), changes)
+	}
+	for i := range expected {
+		if changes[i] != expected[i] {
+			t.Errorf("Expected change %v, got %v", expected[i], changes[i])
+		}
+	}
+}
+
+func TestDetectRenamesExact(t *testing.T) {
+	old := map[string]oid.ObjectID{"old.txt": "1"}
+	new := map[string]oid.ObjectID{"new.txt": "1"}
+
+	changes, err := DetectRenames(Compare(old, new), old, DefaultOptions(), loaderFrom(nil))
+	if err != nil {
+		t.Fatalf("DetectRenames failed: %v", err)
+	}
+
+	if len(changes) != 1 {
+		t.Fatalf("Expected 1 change, got %v", changes)
+	}
+
+	if changes[0].Type != Renamed || changes[0].OldPath != "old.txt" || changes[0].NewPath != "new.txt" || changes[0].Score != 100 {
+		t.Errorf("Expected exact rename old.txt -> new.txt, got %v", changes[0])
+	}
+}
+
+func TestDetectRenamesSimilar(t *testing.T) {
+	base := strings.Repeat("a line that stays the same\n", 20)
+	contents := map[oid.ObjectID]string{
+		"1": base + "original ending\n",
+		"2": base + "edited ending\n",
+		"3": "completely unrelated content\n",
+	}
+
+	old := map[string]oid.ObjectID{"src/file.go": "1", "other.go": "3"}
+	new := map[string]oid.ObjectID{"pkg/file.go": "2"}
+
+	changes, err := DetectRenames(Compare(old, new), old, DefaultOptions(), loaderFrom(contents))
+	if err != nil {
+		t.Fatalf("DetectRenames failed: %v", err)
+	}
+
+	if len(changes) != 2 {
+		t.Fatalf("Expected 2 changes, got %v", changes)
+	}
+
+	// other.go sorts first as a deletion, then the rename under its new path
+	if changes[0].Type != Deleted || changes[0].OldPath != "other.go" {
+		t.Errorf("Expected other.go to be deleted, got %v", changes[0])
+	}
+	if changes[1].Type != Renamed || changes[1].OldPath != "src/file.go" || changes[1].NewPath != "pkg/file.go" {
+		t.Errorf("Expected src/file.go -> pkg/file.go rename, got %v", changes[1])
+	}
+	if changes[1].Score < 90 || changes[1].Score == 100 {
+		t.Errorf("Expected a high but inexact score, got %d", changes[1].Score)
+	}
+}
+
+func TestDetectRenamesThreshold(t *testing.T) {
+	contents := map[oid.ObjectID]string{
+		"1": "one\ntwo\nthree\nfour\n",
+		"2": "one\nfive\nsix\nseven\n",
+	}
+
+	old := map[string]oid.ObjectID{"a.txt": "1"}
+	new := map[string]oid.ObjectID{"b.txt": "2"}
+
+	opts := DefaultOptions()
+	opts.Threshold = 90
+
+	changes, err := DetectRenames(Compare(old, new), old, opts, loaderFrom(contents))
+	if err != nil {
+		t.Fatalf("DetectRenames failed: %v", err)
+	}
+
+	for _, change := range changes {
+		if change.Type == Renamed {
+			t.Errorf("Expected no rename below threshold, got %v", change)
+		}
+	}
+}
+
+func TestDetectCopies(t *testing.T) {
+	base := strings.Repeat("shared content line\n", 10)
+	contents := map[oid.ObjectID]string{
+		"1": base,
+		"2": base + "one more line\n",
+	}
+
+	old := map[string]oid.ObjectID{"kept.txt": "1"}
+	new := map[string]oid.ObjectID{"kept.txt": "1", "exact.txt": "1", "similar.txt": "2"}
+
+	opts := DefaultOptions()
+	opts.Copies = true
+
+	changes, err := DetectRenames(Compare(old, new), old, opts, loaderFrom(contents))
+	if err != nil {
+		t.Fatalf("DetectRenames failed: %v", err)
+	}
+
+	if len(changes) != 2 {
+		t.Fatalf("Expected 2 changes, got %v", changes)
+	}
+
+	for _, change := range changes {
+		if change.Type != Copied || change.OldPath != "kept.txt" {
+			t.Errorf("Expected a copy from kept.txt, got %v", change)
+		}
+	}
+}
+
+func TestDetectRenamesDisabled(t *testing.T) {
+	old := map[string]oid.ObjectID{"old.txt": "1"}
+	new := map[string]oid.ObjectID{"new.txt": "1"}
+
+	opts := DefaultOptions()
+	opts.Renames = false
+
+	changes, err := DetectRenames(Compare(old, new), old, opts, loaderFrom(nil))
+	if err != nil {
+		t.Fatalf("DetectRenames failed: %v", err)
+	}
+
+	if len(changes) != 2 {
+		t.Errorf("Expected a separate delete and add, got %v", changes)
+	}
+}
+
+func TestSimilarity(t *testing.T) {
+	tests := []struct {
+		a, b     string
+		expected int
+	}{
+		{"", "", 100},
+		{"abc\n", "", 0},
+		{"abc\n", "abc\n", 100},
+		{"abc\ndef\n", "abc\nxyz\n", 50},
+	}
+
+	for _, test := range tests {
+		if score := Similarity([]byte(test.a), []byte(test.b)); score != test.expected {
+			t.Errorf("Similarity(%q, %q) = %d; expected %d", test.a, test.b, score, test.expected)
+		}
+	}
+}
+
+func TestOptionsFromConfig(t *testing.T) {
+	config, err := ini.Load([]byte("[diff]\nrenames = copies\nrenameThreshold = 70\nrenameLimit = 10\n"))
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	opts := OptionsFromConfig(config)
+	if !opts.Renames || !opts.Copies || opts.Threshold != 70 || opts.Limit != 10 {
+		t.Errorf("Unexpected options %+v", opts)
+	}
+
+	if opts := OptionsFromConfig(nil); opts != DefaultOptions() {
+		t.Errorf("Expected default options for nil config, got %+v", opts)
+	}
+}
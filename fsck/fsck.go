@@ -0,0 +1,318 @@
+package fsck
+
+import (
+	"errors"
+	"fmt"
+	"orf/bitmap"
+	"orf/object"
+	"orf/oid"
+	"orf/pack"
+	"orf/repository"
+	"sort"
+	"strings"
+)
+
+// Entry is an object named in a report, with its type ("" when it could not be read).
+type Entry struct {
+	ID   oid.ObjectID
+	Type string
+}
+
+func (entry Entry) String() string {
+	if entry.Type == "" {
+		return "object " + entry.ID.String()
+	}
+	return entry.Type + " " + entry.ID.String()
+}
+
+// Error is an object that could not be read, does not hash to its id or is malformed.
+type Error struct {
+	Entry
+	Err error
+}
+
+// BrokenLink is a reference to an object that does not exist. From names the referrer: an
+// object (e.g. "commit 1a2b..."), a ref, a reflog (e.g. "reflog of HEAD") or "index".
+type BrokenLink struct {
+	From string
+	To   Entry
+}
+
+// Report lists the problems found in a repository. Unreachable holds every object no ref,
+// reflog or index entry leads to, and Dangling those of them that no other unreachable
+// object points to either, i.e. the tips of discarded history.
+type Report struct {
+	Checked     int
+	Corrupt     []Error
+	Missing     []Entry
+	BrokenLinks []BrokenLink
+	Dangling    []Entry
+	Unreachable []Entry
+}
+
+// OK reports whether the object database is intact: no object is corrupt or missing.
+// Unreachable objects are not errors; they are left behind by amends, resets and the like.
+func (report *Report) OK() bool {
+	return len(report.Corrupt) == 0 && len(report.Missing) == 0
+}
+
+// link is a reference from one object (or root) to another, of the type it should have
+// ("" when any type will do).
+type link struct {
+	to   oid.ObjectID
+	want string
+}
+
+// checker holds the objects read so far and the links between them.
+type checker struct {
+	repo    *repository.Repo
+	types   map[oid.ObjectID]string
+	links   map[oid.ObjectID][]link
+	corrupt map[oid.ObjectID]bool
+	report  *Report
+}
+
+// Check verifies the packs of a repository and rehashes and parses every object, loose or
+// packed, then follows the links from every ref, reflog entry and index entry to find missing
+// and unreachable objects.
+func Check(repo *repository.Repo) (*Report, error) {
+	c := &checker{
+		repo:    repo,
+		types:   make(map[oid.ObjectID]string),
+		links:   make(map[oid.ObjectID][]link),
+		corrupt: make(map[oid.ObjectID]bool),
+		report:  &Report{},
+	}
+
+	ids, err := object.ListLooseObjects(repo.Directory)
+	if err != nil {
+		return nil, err
+	}
+
+	packs, err := pack.List(repo.Directory)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range packs {
+		if err := p.Verify(); err != nil {
+			c.report.Corrupt = append(c.report.Corrupt, Error{Entry: Entry{ID: p.Checksum, Type: "pack"}, Err: err})
+		}
+		ids = append(ids, p.IDs()...)
+	}
+	c.checkMultiPackIndex()
+
+	// Objects may be both loose and packed
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	unique := ids[:0]
+	for i, id := range ids {
+		if i == 0 || id != ids[i-1] {
+			unique = append(unique, id)
+		}
+	}
+	ids = unique
+
+	for _, id := range ids {
+		c.checkObject(id)
+	}
+	c.report.Checked = len(ids)
+
+	roots, err := object.ListRoots(repo)
+	if err != nil {
+		return nil, err
+	}
+
+	reachable := c.connect(roots)
+	c.findUnreachable(ids, reachable)
+
+	return c.report, nil
+}
+
+// checkMultiPackIndex verifies the multi-pack-index and its bitmaps, if the repository has them.
+func (c *checker) checkMultiPackIndex() {
+	var checksum oid.ObjectID
+	if midx, err := pack.LoadMultiPackIndex(c.repo.Directory); err == nil {
+		checksum = midx.Checksum
+	}
+
+	if err := pack.VerifyMultiPackIndex(c.repo.Directory); err != nil {
+		if !errors.Is(err, pack.ErrNoMultiPackIndex) {
+			c.report.Corrupt = append(c.report.Corrupt, Error{Entry: Entry{ID: checksum, Type: "multi-pack-index"}, Err: err})
+		}
+		return
+	}
+	if err := bitmap.Verify(c.repo); err != nil && !errors.Is(err, bitmap.ErrNotFound) {
+		c.report.Corrupt = append(c.report.Corrupt, Error{Entry: Entry{ID: checksum, Type: "bitmap"}, Err: err})
+	}
+}
+
+// checkObject reads an object, recording its type and links, or the reason it is corrupt.
+func (c *checker) checkObject(id oid.ObjectID) {
+	obj, err := object.VerifyObject(c.repo.Directory, id)
+	if err == nil {
+		c.types[id] = obj.GetFormat()
+		c.links[id], err = objectLinks(id, obj)
+	}
+
+	if err != nil {
+		c.corrupt[id] = true
+		c.report.Corrupt = append(c.report.Corrupt, Error{Entry: Entry{ID: id, Type: c.types[id]}, Err: err})
+	}
+}
+
+// objectLinks validates the structure of a parsed object and returns the objects it points to.
+func objectLinks(id oid.ObjectID, obj object.Object) ([]link, error) {
+	algorithm := id.Algorithm()
+
+	switch o := obj.(type) {
+	case *object.Commit:
+		tree := o.TreeHash()
+		if err := checkID(algorithm, tree); err != nil {
+			return nil, fmt.Errorf("invalid tree: %v", err)
+		}
+		links := []link{{to: tree, want: "tree"}}
+
+		for _, parent := range o.Parents() {
+			if err := checkID(algorithm, parent); err != nil {
+				return nil, fmt.Errorf("invalid parent: %v", err)
+			}
+			links = append(links, link{to: parent, want: "commit"})
+		}
+
+		if _, err := o.Author(); err != nil {
+			return nil, fmt.Errorf("invalid author: %v", err)
+		}
+		if _, err := o.Committer(); err != nil {
+			return nil, fmt.Errorf("invalid committer: %v", err)
+		}
+		return links, nil
+
+	case *object.Tree:
+		var links []link
+		names := make(map[string]bool)
+
+		for _, leaf := range o.Leaves {
+			if leaf.Path == "" || leaf.Path == "." || leaf.Path == ".." || leaf.Path == ".orf" || strings.Contains(leaf.Path, "/") {
+				return nil, fmt.Errorf("invalid entry name %q", leaf.Path)
+			}
+			if names[leaf.Path] {
+				return nil, fmt.Errorf("duplicate entry %q", leaf.Path)
+			}
+			names[leaf.Path] = true
+
+			switch string(leaf.Mode) {
+			case "040000":
+				links = append(links, link{to: leaf.Hash, want: "tree"})
+			case "100644", "100755", "120000":
+				links = append(links, link{to: leaf.Hash, want: "blob"})
+			case "160000":
+				// Submodule commits live in another repository
+			default:
+				return nil, fmt.Errorf("invalid mode %s for %q", leaf.Mode, leaf.Path)
+			}
+		}
+		return links, nil
+
+	case *object.Tag:
+		target := o.Target()
+		if err := checkID(algorithm, target); err != nil {
+			return nil, fmt.Errorf("invalid object: %v", err)
+		}
+
+		switch o.TargetType() {
+		case "blob", "commit", "tag", "tree":
+		default:
+			return nil, fmt.Errorf("invalid type %q", o.TargetType())
+		}
+		return []link{{to: target, want: o.TargetType()}}, nil
+	}
+
+	return nil, nil
+}
+
+// checkID validates an id stored in an object, which must be full hex of the object's own
+// hash algorithm.
+func checkID(algorithm oid.Algorithm, id oid.ObjectID) error {
+	if id == "" {
+		return fmt.Errorf("missing id")
+	}
+
+	parsed, err := oid.FromHex(id.String())
+	if err != nil {
+		return err
+	}
+	if parsed != id || len(id) != algorithm.HexSize() {
+		return fmt.Errorf("invalid object id %q", id)
+	}
+	return nil
+}
+
+// connect marks every object reachable from the roots, reporting links to objects that do
+// not exist and links to objects of the wrong type.
+func (c *checker) connect(roots []object.Root) map[oid.ObjectID]bool {
+	reachable := make(map[oid.ObjectID]bool)
+	missing := make(map[oid.ObjectID]bool)
+
+	var queue []oid.ObjectID
+	follow := func(from string, l link) {
+		have, exists := c.types[l.to]
+		switch {
+		case !exists && !c.corrupt[l.to]:
+			c.report.BrokenLinks = append(c.report.BrokenLinks, BrokenLink{From: from, To: Entry{ID: l.to, Type: l.want}})
+			if !missing[l.to] {
+				missing[l.to] = true
+				c.report.Missing = append(c.report.Missing, Entry{ID: l.to, Type: l.want})
+			}
+			return
+		case exists && l.want != "" && have != l.want:
+			err := &object.WrongTypeError{ID: l.to, Have: have, Want: l.want}
+			c.report.Corrupt = append(c.report.Corrupt, Error{Entry: Entry{ID: l.to, Type: have}, Err: fmt.Errorf("linked from %s: %w", from, err)})
+		}
+
+		if !reachable[l.to] {
+			reachable[l.to] = true
+			queue = append(queue, l.to)
+		}
+	}
+
+	for _, root := range roots {
+		follow(root.From, link{to: root.ID, want: root.Type})
+	}
+
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+
+		from := Entry{ID: id, Type: c.types[id]}.String()
+		for _, l := range c.links[id] {
+			follow(from, l)
+		}
+	}
+
+	return reachable
+}
+
+// findUnreachable reports the objects not reached from any root, and which of them are
+// dangling. Corrupt objects are already reported and are skipped.
+func (c *checker) findUnreachable(ids []oid.ObjectID, reachable map[oid.ObjectID]bool) {
+	referenced := make(map[oid.ObjectID]bool)
+	for _, id := range ids {
+		if reachable[id] {
+			continue
+		}
+		for _, l := range c.links[id] {
+			referenced[l.to] = true
+		}
+	}
+
+	for _, id := range ids {
+		if reachable[id] || c.corrupt[id] {
+			continue
+		}
+
+		entry := Entry{ID: id, Type: c.types[id]}
+		c.report.Unreachable = append(c.report.Unreachable, entry)
+		if !referenced[id] {
+			c.report.Dangling = append(c.report.Dangling, entry)
+		}
+	}
+}
@@ -0,0 +1,345 @@
+package fsck
+
+import (
+	"errors"
+	"orf/kv"
+	"orf/object"
+	"orf/oid"
+	"orf/pack"
+	"orf/repository"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func createTestRepo(t *testing.T) *repository.Repo {
+	workTree := t.TempDir()
+	directory := filepath.Join(workTree, ".orf")
+
+	for _, dir := range []string{"objects", filepath.Join("refs", "heads"), filepath.Join("refs", "tags")} {
+		if err := os.MkdirAll(filepath.Join(directory, dir), os.ModePerm); err != nil {
+			t.Fatalf("Failed to create directories: %v", err)
+		}
+	}
+
+	return &repository.Repo{WorkTree: workTree, Directory: directory}
+}
+
+func writeTestBlob(t *testing.T, repo *repository.Repo, data string) oid.ObjectID {
+	hash, err := object.WriteObject(repo.Directory, object.CreateBlob([]byte(data)))
+	if err != nil {
+		t.Fatalf("Failed to write blob: %v", err)
+	}
+	return hash
+}
+
+func writeTestTree(t *testing.T, repo *repository.Repo, leaves ...*object.Leaf) oid.ObjectID {
+	tree := object.CreateTree(nil)
+	tree.Leaves = leaves
+
+	data, err := tree.Serialize()
+	if err != nil {
+		t.Fatalf("Failed to serialize tree: %v", err)
+	}
+	hash, err := object.WriteObject(repo.Directory, object.CreateTree(data))
+	if err != nil {
+		t.Fatalf("Failed to write tree: %v", err)
+	}
+	return hash
+}
+
+func writeTestCommit(t *testing.T, repo *repository.Repo, tree oid.ObjectID, parents []oid.ObjectID, message string) oid.ObjectID {
+	kvData := kv.CreateOrderedMap()
+	kvData.Add("tree", tree.String())
+	if len(parents) == 1 {
+		kvData.Add("parent", parents[0].String())
+	} else if len(parents) > 1 {
+		var values []string
+		for _, parent := range parents {
+			values = append(values, parent.String())
+		}
+		kvData.Add("parent", values)
+	}
+	kvData.Add("author", "Test <test@example.com> 1700000000 +0000")
+	kvData.Add("committer", "Test <test@example.com> 1700000000 +0000")
+	kvData.Add("message", message)
+
+	hash, err := object.WriteObject(repo.Directory, object.CreateCommit(kv.Serialize(kvData)))
+	if err != nil {
+		t.Fatalf("Failed to write commit: %v", err)
+	}
+	return hash
+}
+
+func writeTestRef(t *testing.T, repo *repository.Repo, name string, hash oid.ObjectID) {
+	if err := os.WriteFile(filepath.Join(repo.Directory, filepath.FromSlash(name)), []byte(hash.String()+"\n"), 0644); err != nil {
+		t.Fatalf("Failed to write ref: %v", err)
+	}
+}
+
+func objectPath(repo *repository.Repo, id oid.ObjectID) string {
+	return filepath.Join(repo.Directory, "objects", string(id[:2]), string(id[2:]))
+}
+
+func hasEntry(entries []Entry, id oid.ObjectID, format string) bool {
+	for _, entry := range entries {
+		if entry.ID == id && entry.Type == format {
+			return true
+		}
+	}
+	return false
+}
+
+func TestCheckClean(t *testing.T) {
+	repo := createTestRepo(t)
+	blob := writeTestBlob(t, repo, "hello\n")
+	tree := writeTestTree(t, repo, &object.Leaf{Mode: []byte("100644"), Path: "hello.txt", Hash: blob})
+	first := writeTestCommit(t, repo, tree, nil, "first")
+	second := writeTestCommit(t, repo, tree, []oid.ObjectID{first}, "second")
+	writeTestRef(t, repo, "refs/heads/master", second)
+	if err := os.WriteFile(filepath.Join(repo.Directory, "HEAD"), []byte("ref: refs/heads/master\n"), 0644); err != nil {
+		t.Fatalf("Failed to write HEAD: %v", err)
+	}
+
+	report, err := Check(repo)
+	if err != nil {
+		t.Fatalf("Check failed: %v", err)
+	}
+
+	if !report.OK() || len(report.Unreachable) != 0 || len(report.Dangling) != 0 {
+		t.Errorf("Expected a clean report, got %+v", report)
+	}
+	if report.Checked != 4 {
+		t.Errorf("Expected 4 objects checked, got %d", report.Checked)
+	}
+}
+
+func TestCheckUnreachable(t *testing.T) {
+	repo := createTestRepo(t)
+	blob := writeTestBlob(t, repo, "kept\n")
+	tree := writeTestTree(t, repo, &object.Leaf{Mode: []byte("100644"), Path: "kept.txt", Hash: blob})
+	base := writeTestCommit(t, repo, tree, nil, "base")
+	writeTestRef(t, repo, "refs/heads/master", base)
+
+	// An amended commit, left behind with its own tree and blob
+	lostBlob := writeTestBlob(t, repo, "lost\n")
+	lostTree := writeTestTree(t, repo, &object.Leaf{Mode: []byte("100644"), Path: "kept.txt", Hash: lostBlob})
+	lost := writeTestCommit(t, repo, lostTree, []oid.ObjectID{base}, "lost")
+
+	// A blob that only the reflog still knows about
+	logged := writeTestBlob(t, repo, "logged\n")
+	other := writeTestCommit(t, repo, writeTestTree(t, repo, &object.Leaf{Mode: []byte("100644"), Path: "logged.txt", Hash: logged}), nil, "logged")
+	entry := &object.ReflogEntry{Old: base.Algorithm().Zero(), New: other, Committer: &object.Signature{Name: "Test", Email: "test@example.com"}, Message: "commit: logged"}
+	if err := object.AppendReflog(repo, "refs/heads/master", entry); err != nil {
+		t.Fatalf("AppendReflog failed: %v", err)
+	}
+
+	report, err := Check(repo)
+	if err != nil {
+		t.Fatalf("Check failed: %v", err)
+	}
+
+	if !report.OK() {
+		t.Errorf("Expected no errors, got %+v", report)
+	}
+	if len(report.Dangling) != 1 || !hasEntry(report.Dangling, lost, "commit") {
+		t.Errorf("Expected only the lost commit to dangle, got %v", report.Dangling)
+	}
+	if len(report.Unreachable) != 3 || !hasEntry(report.Unreachable, lostTree, "tree") || !hasEntry(report.Unreachable, lostBlob, "blob") {
+		t.Errorf("Expected the lost commit, tree and blob to be unreachable, got %v", report.Unreachable)
+	}
+}
+
+func TestCheckMissingAndCorrupt(t *testing.T) {
+	repo := createTestRepo(t)
+	blob := writeTestBlob(t, repo, "gone\n")
+	other := writeTestBlob(t, repo, "damaged\n")
+	tree := writeTestTree(t, repo,
+		&object.Leaf{Mode: []byte("100644"), Path: "gone.txt", Hash: blob},
+		&object.Leaf{Mode: []byte("100644"), Path: "damaged.txt", Hash: other},
+	)
+	commit := writeTestCommit(t, repo, tree, nil, "broken")
+	writeTestRef(t, repo, "refs/heads/master", commit)
+
+	// A tag claiming to point to a tree, while its object is a commit
+	tagData := kv.CreateOrderedMap()
+	tagData.Add("object", commit.String())
+	tagData.Add("type", "tree")
+	tagData.Add("tag", "v1")
+	tagData.Add("message", "wrong type")
+	tag, err := object.WriteObject(repo.Directory, object.CreateTag(kv.Serialize(tagData)))
+	if err != nil {
+		t.Fatalf("Failed to write tag: %v", err)
+	}
+	writeTestRef(t, repo, "refs/tags/v1", tag)
+
+	if err := os.Remove(objectPath(repo, blob)); err != nil {
+		t.Fatalf("Failed to remove blob: %v", err)
+	}
+
+	// Replace the other blob with the (valid) contents of the commit
+	contents, err := os.ReadFile(objectPath(repo, commit))
+	if err != nil {
+		t.Fatalf("Failed to read commit: %v", err)
+	}
+	if err := os.WriteFile(objectPath(repo, other), contents, 0644); err != nil {
+		t.Fatalf("Failed to overwrite blob: %v", err)
+	}
+
+	report, err := Check(repo)
+	if err != nil {
+		t.Fatalf("Check failed: %v", err)
+	}
+
+	if report.OK() {
+		t.Fatalf("Expected errors, got a clean report")
+	}
+	if len(report.Missing) != 1 || !hasEntry(report.Missing, blob, "blob") {
+		t.Errorf("Expected the removed blob to be missing, got %v", report.Missing)
+	}
+	if len(report.BrokenLinks) != 1 || report.BrokenLinks[0].From != "tree "+tree.String() {
+		t.Errorf("Expected a broken link from the tree, got %v", report.BrokenLinks)
+	}
+
+	var corrupt, wrongType bool
+	for _, problem := range report.Corrupt {
+		var typeErr *object.WrongTypeError
+		switch {
+		case problem.ID == other && errors.Is(problem.Err, object.ErrCorrupt):
+			corrupt = true
+		case problem.ID == commit && errors.As(problem.Err, &typeErr):
+			wrongType = true
+		}
+	}
+	if !corrupt || !wrongType || len(report.Corrupt) != 2 {
+		t.Errorf("Expected the rehashed blob and the mistyped tag target to be reported, got %v", report.Corrupt)
+	}
+}
+
+func TestCheckMalformedObjects(t *testing.T) {
+	repo := createTestRepo(t)
+	blob := writeTestBlob(t, repo, "data\n")
+
+	tree := writeTestTree(t, repo, &object.Leaf{Mode: []byte("100644"), Path: "..", Hash: blob})
+
+	kvData := kv.CreateOrderedMap()
+	kvData.Add("tree", "not-a-hash")
+	kvData.Add("author", "Test <test@example.com> 1700000000 +0000")
+	kvData.Add("committer", "Test <test@example.com> 1700000000 +0000")
+	kvData.Add("message", "bad tree")
+	commit, err := object.WriteObject(repo.Directory, object.CreateCommit(kv.Serialize(kvData)))
+	if err != nil {
+		t.Fatalf("Failed to write commit: %v", err)
+	}
+
+	report, err := Check(repo)
+	if err != nil {
+		t.Fatalf("Check failed: %v", err)
+	}
+
+	var found []oid.ObjectID
+	for _, problem := range report.Corrupt {
+		found = append(found, problem.ID)
+	}
+	if len(found) != 2 || (found[0] != tree && found[1] != tree) || (found[0] != commit && found[1] != commit) {
+		t.Errorf("Expected the tree and commit to be malformed, got %v", report.Corrupt)
+	}
+
+	// Malformed objects are not also reported as unreachable
+	if len(report.Unreachable) != 1 || report.Unreachable[0].ID != blob {
+		t.Errorf("Expected only the blob to be unreachable, got %v", report.Unreachable)
+	}
+}
+
+func TestCheckTruncatedObjects(t *testing.T) {
+	repo := createTestRepo(t)
+
+	var ids []oid.ObjectID
+	for _, obj := range []object.Object{
+		object.CreateCommit([]byte("tree abc\n")),
+		object.CreateCommit([]byte("tree abc\nauthor Test")),
+		object.CreateCommit([]byte("no-value\n\nmessage\n")),
+		object.CreateTag([]byte("object abc\ntype commit\n")),
+		object.CreateTag([]byte("object abc\ntag v1\n continued")),
+	} {
+		id, err := object.WriteObject(repo.Directory, obj)
+		if err != nil {
+			t.Fatalf("Failed to write object: %v", err)
+		}
+		ids = append(ids, id)
+
+		if _, err := object.ReadObject(repo.Directory, id); !errors.Is(err, object.ErrCorrupt) {
+			t.Errorf("Expected reading %q to fail with ErrCorrupt, got %v", obj.GetData(), err)
+		}
+	}
+
+	report, err := Check(repo)
+	if err != nil {
+		t.Fatalf("Check failed: %v", err)
+	}
+
+	reported := make(map[oid.ObjectID]bool)
+	for _, problem := range report.Corrupt {
+		if errors.Is(problem.Err, object.ErrCorrupt) {
+			reported[problem.ID] = true
+		}
+	}
+	for _, id := range ids {
+		if !reported[id] {
+			t.Errorf("Expected %s to be reported as corrupt, got %v", id, report.Corrupt)
+		}
+	}
+}
+
+func TestCheckPacked(t *testing.T) {
+	repo := createTestRepo(t)
+	blob := writeTestBlob(t, repo, "packed\n")
+	tree := writeTestTree(t, repo, &object.Leaf{Mode: []byte("100644"), Path: "packed.txt", Hash: blob})
+	commit := writeTestCommit(t, repo, tree, nil, "packed")
+	writeTestRef(t, repo, "refs/heads/master", commit)
+
+	ids := []oid.ObjectID{commit, tree, blob}
+	p, err := pack.Write(repo.Directory, repo.ObjectFormat(), ids, func(id oid.ObjectID) (string, []byte, error) {
+		obj, err := object.ReadObject(repo.Directory, id)
+		if err != nil {
+			return "", nil, err
+		}
+		return obj.GetFormat(), obj.GetData(), nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to write pack: %v", err)
+	}
+
+	// Keep the blob loose as well, which is checked once
+	for _, id := range ids[:2] {
+		if err := os.Remove(objectPath(repo, id)); err != nil {
+			t.Fatalf("Failed to remove loose object: %v", err)
+		}
+	}
+
+	report, err := Check(repo)
+	if err != nil {
+		t.Fatalf("Check failed: %v", err)
+	}
+	if !report.OK() || report.Checked != 3 || len(report.Unreachable) != 0 {
+		t.Errorf("Expected 3 intact reachable objects, got %+v", report)
+	}
+
+	// Damage the pack
+	data, err := os.ReadFile(p.Path)
+	if err != nil {
+		t.Fatalf("Failed to read pack: %v", err)
+	}
+	data[len(data)/2] ^= 0xff
+	if err := os.WriteFile(p.Path, data, 0644); err != nil {
+		t.Fatalf("Failed to write pack: %v", err)
+	}
+
+	report, err = Check(repo)
+	if err != nil {
+		t.Fatalf("Check failed: %v", err)
+	}
+	if report.OK() || report.Corrupt[0].Type != "pack" {
+		t.Errorf("Expected the damaged pack to be reported, got %+v", report.Corrupt)
+	}
+}
@@ -0,0 +1,299 @@
+package gc
+
+import (
+	"errors"
+	"fmt"
+	"orf/bitmap"
+	"orf/commitgraph"
+	"orf/object"
+	"orf/oid"
+	"orf/pack"
+	"orf/repository"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-ini/ini"
+)
+
+// Options controls what gc and prune remove. A zero time expires nothing.
+type Options struct {
+	// PruneExpire: unreachable loose objects last written before it are deleted. The grace
+	// period protects objects another process has just written, or written again (which
+	// refreshes their time), but not yet referenced.
+	PruneExpire time.Time
+
+	// ReflogExpire: reflog entries older than it are removed. ReflogExpireUnreachable: entries
+	// older than it whose commit is no longer part of the ref's history are removed.
+	ReflogExpire            time.Time
+	ReflogExpireUnreachable time.Time
+
+	// DryRun reports what would be pruned without deleting anything.
+	DryRun bool
+
+	// WriteCommitGraph rewrites the commit-graph once the objects are collected.
+	WriteCommitGraph bool
+
+	// WriteBitmaps writes reachability bitmaps for the multi-pack-index once objects are
+	// repacked, so later collections need not walk the whole history.
+	WriteBitmaps bool
+}
+
+// DefaultOptions returns the options used without configuration: objects are pruned after two
+// weeks, reflog entries after 90 days, or 30 days once unreachable, and the commit-graph and
+// bitmaps are written.
+func DefaultOptions(now time.Time) Options {
+	return Options{
+		PruneExpire:             now.AddDate(0, 0, -14),
+		ReflogExpire:            now.AddDate(0, 0, -90),
+		ReflogExpireUnreachable: now.AddDate(0, 0, -30),
+		WriteCommitGraph:        true,
+		WriteBitmaps:            true,
+	}
+}
+
+// OptionsFromConfig reads gc.pruneExpire, gc.reflogExpire, gc.reflogExpireUnreachable,
+// gc.writeCommitGraph and repack.writeBitmaps, keeping the defaults for keys that are not set.
+func OptionsFromConfig(config *ini.File, now time.Time) (Options, error) {
+	opts := DefaultOptions(now)
+	if config == nil {
+		return opts, nil
+	}
+
+	keys := map[string]*time.Time{
+		"pruneExpire":             &opts.PruneExpire,
+		"reflogExpire":            &opts.ReflogExpire,
+		"reflogExpireUnreachable": &opts.ReflogExpireUnreachable,
+	}
+	for key, value := range keys {
+		setting := config.Section("gc").Key(key).String()
+		if setting == "" {
+			continue
+		}
+
+		expire, err := ParseExpiry(setting, now)
+		if err != nil {
+			return opts, fmt.Errorf("invalid gc.%s: %w", key, err)
+		}
+		*value = expire
+	}
+
+	if write, err := config.Section("gc").Key("writeCommitGraph").Bool(); err == nil {
+		opts.WriteCommitGraph = write
+	}
+	if write, err := config.Section("repack").Key("writeBitmaps").Bool(); err == nil {
+		opts.WriteBitmaps = write
+	}
+
+	return opts, nil
+}
+
+// relativeRE matches relative expiry dates such as "2.weeks.ago" or "30 days ago".
+var relativeRE = regexp.MustCompile(`^(\d+)[. ]+(second|minute|hour|day|week|month|year)s?[. ]+ago$`)
+
+// ParseExpiry parses an expiry date: "never" (or "false"), "now" (or "all"), a relative date
+// such as "2.weeks.ago", a unix timestamp, an RFC 3339 time or a YYYY-MM-DD date. Things older
+// than the returned time expire; "never" returns the zero time.
+func ParseExpiry(value string, now time.Time) (time.Time, error) {
+	value = strings.ToLower(strings.TrimSpace(value))
+
+	switch value {
+	case "never", "false":
+		return time.Time{}, nil
+	case "now", "all":
+		return now, nil
+	}
+
+	if match := relativeRE.FindStringSubmatch(value); match != nil {
+		n, err := strconv.Atoi(match[1])
+		if err != nil {
+			return time.Time{}, err
+		}
+
+		switch match[2] {
+		case "second":
+			return now.Add(-time.Duration(n) * time.Second), nil
+		case "minute":
+			return now.Add(-time.Duration(n) * time.Minute), nil
+		case "hour":
+			return now.Add(-time.Duration(n) * time.Hour), nil
+		case "day":
+			return now.AddDate(0, 0, -n), nil
+		case "week":
+			return now.AddDate(0, 0, -7*n), nil
+		case "month":
+			return now.AddDate(0, -n, 0), nil
+		default:
+			return now.AddDate(-n, 0, 0), nil
+		}
+	}
+
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(seconds, 0), nil
+	}
+	if when, err := time.Parse(time.RFC3339, value); err == nil {
+		return when, nil
+	}
+	if when, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		return when, nil
+	}
+
+	return time.Time{}, fmt.Errorf("invalid expiry date %s", value)
+}
+
+// expired reports whether something last changed at when has expired.
+func expired(when time.Time, expire time.Time) bool {
+	return !expire.IsZero() && !when.After(expire)
+}
+
+// Result summarizes a collection.
+type Result struct {
+	ExpiredEntries int
+	PackedRefs     int
+	Packed         int
+	Pruned         []oid.ObjectID
+	CommitGraph    int
+	Bitmaps        int
+}
+
+// Run collects garbage: it expires old reflog entries, packs refs, repacks every reachable
+// object into a single pack with its multi-pack-index and bitmaps, prunes the unreachable loose
+// objects older than opts.PruneExpire and writes the commit-graph. Only one collection runs at
+// a time.
+func Run(repo *repository.Repo, opts Options) (*Result, error) {
+	release, err := lock(repo)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	result := &Result{}
+	if result.ExpiredEntries, err = ExpireReflogs(repo, opts); err != nil {
+		return nil, err
+	}
+	if result.PackedRefs, err = object.PackRefs(repo); err != nil {
+		return nil, err
+	}
+	if result.Packed, err = Repack(repo); err != nil {
+		return nil, err
+	}
+	if opts.WriteBitmaps {
+		result.Bitmaps, err = bitmap.Write(repo)
+		if err != nil && !errors.Is(err, pack.ErrNoMultiPackIndex) {
+			return nil, err
+		}
+	}
+	if result.Pruned, err = prune(repo, opts); err != nil {
+		return nil, err
+	}
+	if opts.WriteCommitGraph {
+		if result.CommitGraph, err = commitgraph.Write(repo); err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+// Prune deletes the unreachable loose objects last written before opts.PruneExpire and returns
+// their ids; with opts.DryRun nothing is deleted.
+func Prune(repo *repository.Repo, opts Options) ([]oid.ObjectID, error) {
+	release, err := lock(repo)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	return prune(repo, opts)
+}
+
+func prune(repo *repository.Repo, opts Options) ([]oid.ObjectID, error) {
+	reachable, _, err := reachableObjects(repo)
+	if err != nil {
+		return nil, err
+	}
+
+	ids, err := object.ListLooseObjects(repo.Directory)
+	if err != nil {
+		return nil, err
+	}
+
+	var pruned []oid.ObjectID
+	for _, id := range ids {
+		if reachable[id] {
+			continue
+		}
+
+		path := object.LoosePath(repo.Directory, id)
+		info, err := os.Stat(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !expired(info.ModTime(), opts.PruneExpire) {
+			continue
+		}
+
+		pruned = append(pruned, id)
+		if opts.DryRun {
+			continue
+		}
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		os.Remove(filepath.Dir(path))
+		object.ForgetObject(repo.Directory, id)
+	}
+
+	if !opts.DryRun {
+		if err := pruneTemporary(repo, opts.PruneExpire); err != nil {
+			return nil, err
+		}
+	}
+	return pruned, nil
+}
+
+// pruneTemporary deletes the temporary files of loose objects and packs last written before
+// expire, left behind by writers that crashed.
+func pruneTemporary(repo *repository.Repo, expire time.Time) error {
+	paths, err := filepath.Glob(filepath.Join(repo.Directory, "objects", "*", "tmp_*"))
+	if err != nil {
+		return err
+	}
+
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil || info.IsDir() || !expired(info.ModTime(), expire) {
+			continue
+		}
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// gcLockFile is held while a collection runs.
+const gcLockFile = "gc.pid"
+
+// lock takes the gc lock of a repository and returns the function releasing it.
+func lock(repo *repository.Repo) (func(), error) {
+	path := filepath.Join(repo.Directory, gcLockFile)
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if errors.Is(err, os.ErrExist) {
+		return nil, fmt.Errorf("gc is already running (remove %s if it is not)", path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Fprintf(file, "%d\n", os.Getpid())
+	file.Close()
+
+	return func() { os.Remove(path) }, nil
+}
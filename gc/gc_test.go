@@ -0,0 +1,385 @@
+package gc
+
+import (
+	"orf/commitgraph"
+	"orf/kv"
+	"orf/object"
+	"orf/oid"
+	"orf/pack"
+	"orf/repository"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func createTestRepo(t *testing.T) *repository.Repo {
+	workTree := t.TempDir()
+	directory := filepath.Join(workTree, ".orf")
+
+	for _, dir := range []string{"objects", filepath.Join("refs", "heads"), filepath.Join("refs", "tags")} {
+		if err := os.MkdirAll(filepath.Join(directory, dir), os.ModePerm); err != nil {
+			t.Fatalf("Failed to create directories: %v", err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(directory, "HEAD"), []byte("ref: refs/heads/master\n"), 0644); err != nil {
+		t.Fatalf("Failed to write HEAD: %v", err)
+	}
+
+	return &repository.Repo{WorkTree: workTree, Directory: directory}
+}
+
+// writeTestCommit writes a commit whose tree maps each file name to a blob with the given contents.
+func writeTestCommit(t *testing.T, repo *repository.Repo, files map[string]string, parents []oid.ObjectID, message string) oid.ObjectID {
+	tree := object.CreateTree(nil)
+	for path, contents := range files {
+		tree.Leaves = append(tree.Leaves, &object.Leaf{Mode: []byte("100644"), Path: path, Hash: writeTestBlob(t, repo, contents)})
+	}
+
+	data, err := tree.Serialize()
+	if err != nil {
+		t.Fatalf("Failed to serialize tree: %v", err)
+	}
+	treeHash, err := object.WriteObject(repo.Directory, object.CreateTree(data))
+	if err != nil {
+		t.Fatalf("Failed to write tree: %v", err)
+	}
+
+	kvData := kv.CreateOrderedMap()
+	kvData.Add("tree", treeHash.String())
+	if len(parents) == 1 {
+		kvData.Add("parent", parents[0].String())
+	}
+	kvData.Add("author", "Test <test@example.com> 1700000000 +0000")
+	kvData.Add("committer", "Test <test@example.com> 1700000000 +0000")
+	kvData.Add("message", message)
+
+	hash, err := object.WriteObject(repo.Directory, object.CreateCommit(kv.Serialize(kvData)))
+	if err != nil {
+		t.Fatalf("Failed to write commit: %v", err)
+	}
+	return hash
+}
+
+func writeTestBlob(t *testing.T, repo *repository.Repo, contents string) oid.ObjectID {
+	hash, err := object.WriteObject(repo.Directory, object.CreateBlob([]byte(contents)))
+	if err != nil {
+		t.Fatalf("Failed to write blob: %v", err)
+	}
+	return hash
+}
+
+func writeTestRef(t *testing.T, repo *repository.Repo, name string, hash oid.ObjectID) {
+	if err := os.WriteFile(filepath.Join(repo.Directory, filepath.FromSlash(name)), []byte(hash.String()+"\n"), 0644); err != nil {
+		t.Fatalf("Failed to write ref: %v", err)
+	}
+}
+
+// age sets the modification time of a loose object to some time ago.
+func age(t *testing.T, repo *repository.Repo, id oid.ObjectID, ago time.Duration) {
+	when := time.Now().Add(-ago)
+	if err := os.Chtimes(object.LoosePath(repo.Directory, id), when, when); err != nil {
+		t.Fatalf("Failed to age %s: %v", id, err)
+	}
+}
+
+func isLoose(repo *repository.Repo, id oid.ObjectID) bool {
+	_, err := os.Stat(object.LoosePath(repo.Directory, id))
+	return err == nil
+}
+
+func TestParseExpiry(t *testing.T) {
+	now := time.Date(2024, 3, 15, 12, 0, 0, 0, time.UTC)
+
+	tests := map[string]time.Time{
+		"never":        {},
+		"false":        {},
+		"now":          now,
+		"2.weeks.ago":  now.AddDate(0, 0, -14),
+		"30 days ago":  now.AddDate(0, 0, -30),
+		"1.hour.ago":   now.Add(-time.Hour),
+		"3.months.ago": now.AddDate(0, -3, 0),
+		"1700000000":   time.Unix(1700000000, 0),
+	}
+	for value, expected := range tests {
+		got, err := ParseExpiry(value, now)
+		if err != nil {
+			t.Errorf("ParseExpiry(%q) failed: %v", value, err)
+			continue
+		}
+		if !got.Equal(expected) {
+			t.Errorf("ParseExpiry(%q) = %v, expected %v", value, got, expected)
+		}
+	}
+
+	if _, err := ParseExpiry("soonish", now); err == nil {
+		t.Errorf("Expected an error for an invalid date")
+	}
+}
+
+func TestRun(t *testing.T) {
+	repo := createTestRepo(t)
+	first := writeTestCommit(t, repo, map[string]string{"a.txt": "one"}, nil, "first")
+	second := writeTestCommit(t, repo, map[string]string{"a.txt": "two"}, []oid.ObjectID{first}, "second")
+	writeTestRef(t, repo, "refs/heads/master", second)
+	writeTestRef(t, repo, "refs/tags/v1", first)
+
+	staleBlob := writeTestBlob(t, repo, "unstaged a month ago")
+	age(t, repo, staleBlob, 30*24*time.Hour)
+	freshBlob := writeTestBlob(t, repo, "just written")
+
+	reachable, _, err := reachableObjects(repo)
+	if err != nil {
+		t.Fatalf("reachableObjects failed: %v", err)
+	}
+
+	result, err := Run(repo, DefaultOptions(time.Now()))
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	if result.Packed != len(reachable) {
+		t.Errorf("Expected %d objects packed, got %d", len(reachable), result.Packed)
+	}
+	if result.PackedRefs != 2 {
+		t.Errorf("Expected 2 refs packed, got %d", result.PackedRefs)
+	}
+	if len(result.Pruned) != 1 || result.Pruned[0] != staleBlob {
+		t.Errorf("Expected only the stale blob to be pruned, got %v", result.Pruned)
+	}
+
+	for id := range reachable {
+		if isLoose(repo, id) {
+			t.Errorf("Expected %s to be packed, not loose", id)
+		}
+		if _, err := object.ReadObject(repo.Directory, id); err != nil {
+			t.Errorf("ReadObject(%s) failed after gc: %v", id, err)
+		}
+	}
+	if isLoose(repo, staleBlob) || !isLoose(repo, freshBlob) {
+		t.Errorf("Expected the stale blob pruned and the fresh one kept")
+	}
+
+	if result.CommitGraph != 2 {
+		t.Errorf("Expected both commits in the commit-graph, got %d", result.CommitGraph)
+	}
+	if _, err := os.Stat(commitgraph.Path(repo.Directory)); err != nil {
+		t.Errorf("Expected a commit-graph, got %v", err)
+	}
+
+	if result.Bitmaps != 2 {
+		t.Errorf("Expected bitmaps for both tips, got %d", result.Bitmaps)
+	}
+	if err := pack.VerifyMultiPackIndex(repo.Directory); err != nil {
+		t.Errorf("Expected a valid multi-pack-index, got %v", err)
+	}
+
+	if hash, err := object.ResolveRevision(repo, "v1"); err != nil || hash != first {
+		t.Errorf("Expected v1 at %s after packing refs, got %s (%v)", first, hash, err)
+	}
+	if _, err := os.Stat(filepath.Join(repo.Directory, gcLockFile)); !os.IsNotExist(err) {
+		t.Errorf("Expected the gc lock to be released, got %v", err)
+	}
+
+	// Collecting again leaves a single pack behind
+	if _, err := Run(repo, DefaultOptions(time.Now())); err != nil {
+		t.Fatalf("Second run failed: %v", err)
+	}
+	packs, err := pack.List(repo.Directory)
+	if err != nil || len(packs) != 1 {
+		t.Fatalf("Expected one pack, got %v (%v)", packs, err)
+	}
+}
+
+func TestRepackLoosensUnreachable(t *testing.T) {
+	repo := createTestRepo(t)
+	kept := writeTestCommit(t, repo, map[string]string{"a.txt": "kept"}, nil, "kept")
+	dropped := writeTestCommit(t, repo, map[string]string{"a.txt": "dropped"}, []oid.ObjectID{kept}, "dropped")
+	writeTestRef(t, repo, "refs/heads/master", dropped)
+
+	if _, err := Repack(repo); err != nil {
+		t.Fatalf("Repack failed: %v", err)
+	}
+	if isLoose(repo, dropped) {
+		t.Fatalf("Expected %s to be packed", dropped)
+	}
+
+	// Reset master, leaving the packed commit unreachable
+	writeTestRef(t, repo, "refs/heads/master", kept)
+	if _, err := Repack(repo); err != nil {
+		t.Fatalf("Repack failed: %v", err)
+	}
+
+	if !isLoose(repo, dropped) {
+		t.Fatalf("Expected the unreachable commit to be written loose")
+	}
+	if found, err := pack.Has(repo.Directory, dropped); err != nil || found {
+		t.Errorf("Expected the unreachable commit to be left out of the pack, got %v (%v)", found, err)
+	}
+
+	// It keeps the time of its old pack, so it is only pruned after the grace period
+	pruned, err := Prune(repo, Options{PruneExpire: time.Now().Add(-time.Hour)})
+	if err != nil || len(pruned) != 0 {
+		t.Errorf("Expected nothing pruned within the grace period, got %v (%v)", pruned, err)
+	}
+
+	pruned, err = Prune(repo, Options{PruneExpire: time.Now().Add(time.Minute), DryRun: true})
+	if err != nil || len(pruned) != 3 {
+		t.Errorf("Expected the commit, tree and blob to be prunable, got %v (%v)", pruned, err)
+	}
+	if !isLoose(repo, dropped) {
+		t.Errorf("Expected a dry run to delete nothing")
+	}
+}
+
+func TestPruneForgetsCachedObjects(t *testing.T) {
+	repo := createTestRepo(t)
+	kept := writeTestCommit(t, repo, map[string]string{"a.txt": "kept"}, nil, "kept")
+	dropped := writeTestCommit(t, repo, map[string]string{"a.txt": "dropped"}, nil, "dropped")
+	writeTestRef(t, repo, "refs/heads/master", kept)
+
+	// Reading the commit caches it
+	if _, err := object.ReadObject(repo.Directory, dropped); err != nil {
+		t.Fatalf("ReadObject failed: %v", err)
+	}
+
+	pruned, err := Prune(repo, Options{PruneExpire: time.Now().Add(time.Minute)})
+	if err != nil || len(pruned) != 3 {
+		t.Fatalf("Expected the commit, tree and blob to be pruned, got %v (%v)", pruned, err)
+	}
+	if _, err := object.ReadObject(repo.Directory, dropped); err == nil {
+		t.Errorf("Expected the pruned commit not to be served from the cache")
+	}
+	if _, err := object.ReadObject(repo.Directory, kept); err != nil {
+		t.Errorf("Expected the reachable commit to be kept: %v", err)
+	}
+}
+
+func TestPruneKeepsRewrittenObjects(t *testing.T) {
+	repo := createTestRepo(t)
+	head := writeTestCommit(t, repo, map[string]string{"a.txt": "kept"}, nil, "kept")
+	writeTestRef(t, repo, "refs/heads/master", head)
+
+	// An old unreachable blob that another process writes again is about to be used
+	blob := writeTestBlob(t, repo, "written again")
+	age(t, repo, blob, 30*24*time.Hour)
+	if rewritten := writeTestBlob(t, repo, "written again"); rewritten != blob {
+		t.Fatalf("Expected the same blob, got %s and %s", blob, rewritten)
+	}
+
+	pruned, err := Prune(repo, DefaultOptions(time.Now()))
+	if err != nil || len(pruned) != 0 {
+		t.Errorf("Expected nothing pruned, got %v (%v)", pruned, err)
+	}
+	if !isLoose(repo, blob) {
+		t.Errorf("Expected the rewritten blob to be kept")
+	}
+}
+
+func TestExpireReflogs(t *testing.T) {
+	repo := createTestRepo(t)
+	base := writeTestCommit(t, repo, map[string]string{"a.txt": "base"}, nil, "base")
+	amended := writeTestCommit(t, repo, map[string]string{"a.txt": "amended"}, []oid.ObjectID{base}, "amended")
+	tip := writeTestCommit(t, repo, map[string]string{"a.txt": "tip"}, []oid.ObjectID{base}, "tip")
+	writeTestRef(t, repo, "refs/heads/master", tip)
+
+	now := time.Now()
+	entry := func(old, new oid.ObjectID, ago time.Duration) object.ReflogEntry {
+		return object.ReflogEntry{
+			Old:       old,
+			New:       new,
+			Committer: &object.Signature{Name: "Test", Email: "test@example.com", When: now.Add(-ago)},
+			Message:   "commit",
+		}
+	}
+	day := 24 * time.Hour
+	entries := []object.ReflogEntry{
+		entry(tip.Algorithm().Zero(), base, 100*day), // expired
+		entry(base, amended, 40*day),                 // unreachable and old
+		entry(amended, tip, 40*day),                  // reachable, kept
+	}
+	if err := object.WriteReflog(repo, "refs/heads/master", entries); err != nil {
+		t.Fatalf("WriteReflog failed: %v", err)
+	}
+
+	stash := []object.ReflogEntry{entry(tip.Algorithm().Zero(), amended, 40*day)}
+	if err := object.WriteReflog(repo, stashRef, stash); err != nil {
+		t.Fatalf("WriteReflog failed: %v", err)
+	}
+	writeTestRef(t, repo, stashRef, amended)
+
+	removed, err := ExpireReflogs(repo, DefaultOptions(now))
+	if err != nil {
+		t.Fatalf("ExpireReflogs failed: %v", err)
+	}
+	if removed != 2 {
+		t.Errorf("Expected 2 entries removed, got %d", removed)
+	}
+
+	kept, err := object.ReadReflog(repo, "refs/heads/master")
+	if err != nil || len(kept) != 1 || kept[0].New != tip {
+		t.Errorf("Expected only the entry for the tip to be kept, got %v (%v)", kept, err)
+	}
+	if kept, err := object.ReadReflog(repo, stashRef); err != nil || len(kept) != 1 {
+		t.Errorf("Expected the stash entry to be kept until gc.reflogExpire, got %v (%v)", kept, err)
+	}
+
+	// Once every stash entry expires, the stash ref goes too
+	if _, err := ExpireReflogs(repo, Options{ReflogExpire: now}); err != nil {
+		t.Fatalf("ExpireReflogs failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(repo.Directory, stashRef)); !os.IsNotExist(err) {
+		t.Errorf("Expected refs/stash to be deleted, got %v", err)
+	}
+}
+
+func TestLock(t *testing.T) {
+	repo := createTestRepo(t)
+	if err := os.WriteFile(filepath.Join(repo.Directory, gcLockFile), []byte("1\n"), 0644); err != nil {
+		t.Fatalf("Failed to write lock: %v", err)
+	}
+
+	if _, err := Run(repo, DefaultOptions(time.Now())); err == nil {
+		t.Errorf("Expected Run to fail while another gc holds the lock")
+	}
+	if _, err := Prune(repo, DefaultOptions(time.Now())); err == nil {
+		t.Errorf("Expected Prune to fail while another gc holds the lock")
+	}
+}
+
+func TestPruneTemporaryFiles(t *testing.T) {
+	repo := createTestRepo(t)
+	blob := writeTestBlob(t, repo, "kept")
+	writeTestRef(t, repo, "refs/heads/master", writeTestCommit(t, repo, map[string]string{"a.txt": "kept"}, nil, "kept"))
+
+	// Files left behind by writers that crashed, one long ago and one just now
+	dir := filepath.Dir(object.LoosePath(repo.Directory, blob))
+	stale := filepath.Join(dir, "tmp_obj_stale")
+	fresh := filepath.Join(dir, "tmp_obj_fresh")
+	for _, path := range []string{stale, fresh} {
+		if err := os.WriteFile(path, []byte("partial"), 0644); err != nil {
+			t.Fatalf("Failed to write temporary file: %v", err)
+		}
+	}
+	when := time.Now().Add(-30 * 24 * time.Hour)
+	if err := os.Chtimes(stale, when, when); err != nil {
+		t.Fatalf("Failed to age temporary file: %v", err)
+	}
+
+	if _, err := Prune(repo, Options{PruneExpire: time.Now().Add(-time.Hour), DryRun: true}); err != nil {
+		t.Fatalf("Prune failed: %v", err)
+	}
+	if _, err := os.Stat(stale); err != nil {
+		t.Errorf("Expected a dry run to keep temporary files, got %v", err)
+	}
+
+	if _, err := Prune(repo, DefaultOptions(time.Now())); err != nil {
+		t.Fatalf("Prune failed: %v", err)
+	}
+	if _, err := os.Stat(stale); !os.IsNotExist(err) {
+		t.Errorf("Expected the stale temporary file to be deleted, got %v", err)
+	}
+	if _, err := os.Stat(fresh); err != nil {
+		t.Errorf("Expected the fresh temporary file to be kept, got %v", err)
+	}
+}
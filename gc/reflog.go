@@ -0,0 +1,108 @@
+package gc
+
+import (
+	"errors"
+	"orf/object"
+	"orf/oid"
+	"orf/repository"
+)
+
+// stashRef is the ref whose reflog holds the stash stack.
+const stashRef = "refs/stash"
+
+// ExpireReflogs removes the expired entries of every reflog and returns how many were removed.
+// Entries older than opts.ReflogExpire go, as do entries older than opts.ReflogExpireUnreachable
+// whose commit is not in the history of the ref's current value. Stashes are never in each
+// other's history, so refs/stash only uses opts.ReflogExpire, and is deleted with its last entry.
+func ExpireReflogs(repo *repository.Repo, opts Options) (int, error) {
+	refs, err := object.ListReflogs(repo)
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for _, ref := range refs {
+		entries, err := object.ReadReflog(repo, ref)
+		if err != nil {
+			return 0, err
+		}
+
+		var history map[oid.ObjectID]bool
+		var kept []object.ReflogEntry
+		for _, entry := range entries {
+			when := entry.Committer.When
+			if expired(when, opts.ReflogExpire) {
+				continue
+			}
+
+			if ref != stashRef && expired(when, opts.ReflogExpireUnreachable) {
+				if history == nil {
+					if history, err = refHistory(repo, ref); err != nil {
+						return 0, err
+					}
+				}
+				if !history[entry.New] {
+					continue
+				}
+			}
+
+			kept = append(kept, entry)
+		}
+
+		if len(kept) == len(entries) {
+			continue
+		}
+		removed += len(entries) - len(kept)
+
+		if ref == stashRef && len(kept) == 0 {
+			err = object.DeleteRef(repo, ref)
+		} else {
+			err = object.WriteReflog(repo, ref, kept)
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return removed, nil
+}
+
+// refHistory returns the current value of a ref and, when it is a commit, all its ancestors.
+// A ref that no longer exists has no history.
+func refHistory(repo *repository.Repo, ref string) (map[oid.ObjectID]bool, error) {
+	history := make(map[oid.ObjectID]bool)
+
+	tip, err := object.ResolveRevision(repo, ref)
+	if errors.Is(err, object.ErrNotFound) {
+		return history, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	queue := []oid.ObjectID{tip}
+	history[tip] = true
+	for len(queue) > 0 {
+		obj, err := object.ReadObject(repo.Directory, queue[0])
+		queue = queue[1:]
+		if errors.Is(err, object.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		commit, ok := obj.(*object.Commit)
+		if !ok {
+			continue
+		}
+		for _, parent := range commit.Parents() {
+			if !history[parent] {
+				history[parent] = true
+				queue = append(queue, parent)
+			}
+		}
+	}
+
+	return history, nil
+}
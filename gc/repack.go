@@ -0,0 +1,134 @@
+package gc
+
+import (
+	"errors"
+	"orf/bitmap"
+	"orf/object"
+	"orf/oid"
+	"orf/pack"
+	"orf/repository"
+	"os"
+	"path/filepath"
+)
+
+// Repack writes every reachable object into a new pack and deletes the loose copies of the
+// objects packed, then the old packs, and rewrites the multi-pack-index over the packs left.
+// Objects of an old pack that the new one leaves out are written loose again, dated like their
+// pack, so prune deletes them once the grace period has passed. It returns the number of
+// objects packed.
+func Repack(repo *repository.Repo) (int, error) {
+	_, order, err := reachableObjects(repo)
+	if err != nil {
+		return 0, err
+	}
+
+	// Missing and corrupt objects are left to fsck, and objects whose header would not hash
+	// back to their id once packed stay loose
+	algorithm := repo.ObjectFormat()
+	var ids []oid.ObjectID
+	for _, id := range order {
+		obj, err := object.ReadObject(repo.Directory, id)
+		if err != nil || object.HashObject(algorithm, obj) != id {
+			continue
+		}
+		ids = append(ids, id)
+	}
+
+	old, err := pack.List(repo.Directory)
+	if err != nil {
+		return 0, err
+	}
+
+	var packed *pack.Pack
+	if len(ids) > 0 {
+		packed, err = pack.Write(repo.Directory, algorithm, ids, func(id oid.ObjectID) (string, []byte, error) {
+			obj, err := object.ReadObject(repo.Directory, id)
+			if err != nil {
+				return "", nil, err
+			}
+			return obj.GetFormat(), obj.GetData(), nil
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	for _, p := range old {
+		if packed != nil && p.Checksum == packed.Checksum {
+			continue
+		}
+		if err := loosen(repo, p, packed); err != nil {
+			return 0, err
+		}
+		if err := pack.Remove(p); err != nil {
+			return 0, err
+		}
+	}
+
+	for _, id := range ids {
+		path := object.LoosePath(repo.Directory, id)
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return 0, err
+		}
+		os.Remove(filepath.Dir(path))
+		object.ForgetObject(repo.Directory, id)
+	}
+
+	if _, err := pack.WriteMultiPackIndex(repo.Directory); err != nil && !errors.Is(err, pack.ErrNoMultiPackIndex) {
+		return 0, err
+	}
+	return len(ids), nil
+}
+
+// loosen writes the objects of an old pack that are neither in the new pack nor loose as loose
+// objects, with the modification time of the old pack.
+func loosen(repo *repository.Repo, old *pack.Pack, packed *pack.Pack) error {
+	info, err := os.Stat(old.Path)
+	if err != nil {
+		return err
+	}
+
+	for _, id := range old.IDs() {
+		if packed != nil && packed.Contains(id) {
+			continue
+		}
+		path := object.LoosePath(repo.Directory, id)
+		if _, err := os.Stat(path); err == nil {
+			continue
+		}
+
+		obj, err := object.ReadObject(repo.Directory, id)
+		if err != nil {
+			return err
+		}
+		if _, err := object.WriteObject(repo.Directory, obj); err != nil {
+			return err
+		}
+		if err := os.Chtimes(path, info.ModTime(), info.ModTime()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// reachableObjects returns the objects reachable from the roots of a repository, as a set and
+// in the order they were found, taking the history of commits with a bitmap from it. Missing
+// objects are included, as they are still referenced.
+func reachableObjects(repo *repository.Repo) (map[oid.ObjectID]bool, []oid.ObjectID, error) {
+	roots, err := object.ListRoots(repo)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	order, err := bitmap.Reachable(repo, roots)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	reachable := make(map[oid.ObjectID]bool, len(order))
+	for _, id := range order {
+		reachable[id] = true
+	}
+	return reachable, order, nil
+}
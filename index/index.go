@@ -2,10 +2,9 @@ package index
 
 import (
 	"encoding/binary"
-	"encoding/hex"
 	"fmt"
-	"io"
 	"math"
+	"orf/oid"
 	"orf/repository"
 	"os"
 	"path/filepath"
@@ -23,7 +22,7 @@ type IndexEntry struct {
 	Uid        uint32
 	Gid        uint32
 	Fsize      uint32
-	Sha        string
+	Sha        oid.ObjectID
 	FlagsValid bool
 	FlagStaged uint16
 	Name       string
@@ -41,82 +40,82 @@ func CreateIndex(version uint32, entries []IndexEntry) *Index {
 	}
 }
 
+// ReadIndex reads .orf/index. A repository without an index file has an empty index.
+// Entries store raw object ids sized by the repository's object format.
 func ReadIndex(repo *repository.Repo) (*Index, error) {
 	// Read index file
-	indexFile, err := repository.GetFilePath(repo.WorkTree, false, "index")
-	if err != nil {
-		return nil, err
-	}
+	indexFile := filepath.Join(repo.Directory, "index")
 
 	if _, err := os.Stat(indexFile); err != nil {
+		if os.IsNotExist(err) {
+			return CreateIndex(2, []IndexEntry{}), nil
+		}
 		return nil, err
 	}
 
-	// Open file
-	file, err := os.Open(indexFile)
+	content, err := os.ReadFile(indexFile)
 	if err != nil {
 		return nil, err
 	}
 
-	// Get header, signature, version, count
-	signature := make([]byte, 4)
-	versionBytes := make([]byte, 4)
-	countBytes := make([]byte, 4)
-
-	content, err := io.ReadAll(file)
-	if err != nil {
-		return nil, err
+	if len(content) < 12 {
+		return nil, fmt.Errorf("index file too short")
 	}
 
 	// Get first 4 bytes of header, assign to signature
-	copy(signature, content[:4])
-	if string(signature) != "DIRC" {
+	if string(content[:4]) != "DIRC" {
 		return nil, fmt.Errorf("invalid signature in index file")
 	}
 
 	// Get next 4 bytes of header, assign to version, convert to big endian
-	copy(versionBytes, content[4:8])
-	version := binary.BigEndian.Uint32(versionBytes)
+	version := binary.BigEndian.Uint32(content[4:8])
 	if version != 2 {
 		return nil, fmt.Errorf("invalid version in index file")
 	}
 
 	// Get next 4 bytes of header, assign to count, convert to big endian
-	copy(countBytes, content[8:12])
-	count := binary.BigEndian.Uint32(countBytes)
+	count := binary.BigEndian.Uint32(content[8:12])
+
+	hashSize := repo.ObjectFormat().Size()
+	flagsIndex := 40 + hashSize
+	nameIndex := flagsIndex + 2
 
 	entries := []IndexEntry{}
-	entryBytes := content[12:]
+	idx := 12
 
 	for i := uint32(0); i < count; i++ {
 
+		if idx+nameIndex > len(content) {
+			return nil, fmt.Errorf("index entry %d is truncated", i)
+		}
+
 		entry := IndexEntry{}
-		entryBytes = entryBytes[i:]
+		entryBytes := content[idx:]
 
 		// Read creation time, as unix timestamps (seconds since epoch, 1970-01-01 00:00:00 UTC)
-		entry.CTimeSec = binary.BigEndian.Uint64(entryBytes[0:4])
+		entry.CTimeSec = uint64(binary.BigEndian.Uint32(entryBytes[0:4]))
 
 		// Read creation time, as nanoseconds
-		entry.CTimeNsec = binary.BigEndian.Uint64(entryBytes[4:8])
+		entry.CTimeNsec = uint64(binary.BigEndian.Uint32(entryBytes[4:8]))
 
 		// Read modification time, as unix timestamps (seconds since epoch, 1970-01-01 00:00:00 UTC)
-		entry.MTimeSec = binary.BigEndian.Uint64(entryBytes[8:12])
+		entry.MTimeSec = uint64(binary.BigEndian.Uint32(entryBytes[8:12]))
 
 		// Read modification time, as nanoseconds
-		entry.MTimeNsec = binary.BigEndian.Uint64(entryBytes[12:16])
+		entry.MTimeNsec = uint64(binary.BigEndian.Uint32(entryBytes[12:16]))
 
 		// Read device number
-		entry.Dev = binary.BigEndian.Uint64(entryBytes[16:20])
+		entry.Dev = uint64(binary.BigEndian.Uint32(entryBytes[16:20]))
 
 		// Read inode number
-		entry.Ino = binary.BigEndian.Uint64(entryBytes[20:24])
+		entry.Ino = uint64(binary.BigEndian.Uint32(entryBytes[20:24]))
 
-		unused := binary.BigEndian.Uint32(entryBytes[24:26])
+		unused := binary.BigEndian.Uint16(entryBytes[24:26])
 		if unused != 0 {
 			return nil, fmt.Errorf("unused field in index entry is not zero")
 		}
 
-		mode := binary.BigEndian.Uint32(entryBytes[26:28])
+		mode := uint32(binary.BigEndian.Uint16(entryBytes[26:28]))
 		entry.ModeType = mode >> 12
 
 		// Check if mode type is (regular file, symbolic link, orflink)
@@ -130,10 +129,13 @@ func ReadIndex(repo *repository.Repo) (*Index, error) {
 		entry.Gid = binary.BigEndian.Uint32(entryBytes[32:36])
 		entry.Fsize = binary.BigEndian.Uint32(entryBytes[36:40])
 
-		// Read SHA-1 hash
-		entry.Sha = fmt.Sprintf("%040x", entryBytes[40:60])
+		// Read the object id (20 bytes for SHA-1, 32 bytes for SHA-256)
+		entry.Sha, err = oid.FromBytes(entryBytes[40:flagsIndex])
+		if err != nil {
+			return nil, err
+		}
 
-		flags := binary.BigEndian.Uint16(entryBytes[60:62])
+		flags := binary.BigEndian.Uint16(entryBytes[flagsIndex:nameIndex])
 		entry.FlagsValid = flags&0b1000000000000000 != 0
 		flagExtended := flags&0b0100000000000000 != 0
 
@@ -143,23 +145,21 @@ func ReadIndex(repo *repository.Repo) (*Index, error) {
 
 		entry.FlagStaged = flags & 0b0011000000000000
 		// Length of name
-		nameLength := flags & 0b0000111111111111
-
-		i += 62
+		nameLength := int(flags & 0b0000111111111111)
 
 		if nameLength < 0xfff {
-			// Check if file[idx + 62:idx + 62 + nameLength] is 0x00
-			if entryBytes[62+nameLength] != 0x00 {
+			// Check if the name is null-terminated
+			if nameIndex+nameLength >= len(entryBytes) || entryBytes[nameIndex+nameLength] != 0x00 {
 				return nil, fmt.Errorf("name in index entry is not null-terminated")
 			}
 			// Read name
-			entry.Name = string(entryBytes[62 : 62+nameLength])
-			i += uint32(nameLength) + 1
+			entry.Name = string(entryBytes[nameIndex : nameIndex+nameLength])
 		} else {
 			return nil, fmt.Errorf("extended name length not implemented")
 		}
 
-		i = uint32(8 * math.Ceil(float64(i)/8))
+		// Entries are padded with NUL bytes to a multiple of 8 bytes
+		idx += int(8 * math.Ceil(float64(nameIndex+nameLength+1)/8))
 
 		// Append entry to entries
 		entries = append(entries, entry)
@@ -193,35 +193,20 @@ func (index *Index) WriteIndex(repo *repository.Repo) error {
 		return err
 	}
 
+	hashSize := repo.ObjectFormat().Size()
+
 	// ENTRIES
-	idx := 0
 	for _, e := range index.Entries {
-		// Write ctime (2 * uint32)
-		if err := binary.Write(f, binary.BigEndian, e.CTimeSec); err != nil {
-			return err
-		}
-		if err := binary.Write(f, binary.BigEndian, e.CTimeNsec); err != nil {
-			return err
-		}
-
-		// Write mtime (2 * uint32)
-		if err := binary.Write(f, binary.BigEndian, e.MTimeSec); err != nil {
-			return err
-		}
-		if err := binary.Write(f, binary.BigEndian, e.MTimeNsec); err != nil {
-			return err
-		}
-
-		// Write dev, ino, mode, uid, gid, fsize (all uint32)
-		if err := binary.Write(f, binary.BigEndian, e.Dev); err != nil {
-			return err
-		}
-		if err := binary.Write(f, binary.BigEndian, e.Ino); err != nil {
-			return err
+		// Write ctime, mtime, dev and ino (all uint32)
+		fields := []uint64{e.CTimeSec, e.CTimeNsec, e.MTimeSec, e.MTimeNsec, e.Dev, e.Ino}
+		for _, field := range fields {
+			if err := binary.Write(f, binary.BigEndian, uint32(field)); err != nil {
+				return err
+			}
 		}
 
 		// Write mode (combine type and permissions into a single uint32)
-		mode := byte(e.ModeType<<12) | byte(e.ModePerms)
+		mode := e.ModeType<<12 | e.ModePerms
 		if err := binary.Write(f, binary.BigEndian, mode); err != nil {
 			return err
 		}
@@ -237,10 +222,10 @@ func (index *Index) WriteIndex(repo *repository.Repo) error {
 			return err
 		}
 
-		// Convert SHA from hex string to bytes (20 bytes)
-		shaBytes, err := hex.DecodeString(e.Sha)
-		if err != nil {
-			return fmt.Errorf("invalid sha: %v", err)
+		// Write the raw object id, which must match the repository's object format
+		shaBytes := e.Sha.Bytes()
+		if len(shaBytes) != hashSize {
+			return fmt.Errorf("invalid sha %q for %s: expected %d bytes", e.Sha, e.Name, hashSize)
 		}
 		if _, err := f.Write(shaBytes); err != nil {
 			return err
@@ -273,16 +258,13 @@ func (index *Index) WriteIndex(repo *repository.Repo) error {
 			return err
 		}
 
-		// Update the index for padding
-		idx += 62 + len(nameBytes) + 1
-
 		// Padding: Ensure entry is aligned on an 8-byte boundary
+		idx := 40 + hashSize + 2 + len(nameBytes) + 1
 		if idx%8 != 0 {
 			pad := 8 - (idx % 8)
 			if err := binary.Write(f, binary.BigEndian, make([]byte, pad)); err != nil {
 				return err
 			}
-			idx += pad
 		}
 	}
 
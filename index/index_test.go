@@ -0,0 +1,100 @@
+package index
+
+import (
+	"orf/oid"
+	"orf/repository"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func createTestRepo(t *testing.T, format oid.Algorithm) *repository.Repo {
+	workTree := t.TempDir()
+	directory := filepath.Join(workTree, ".orf")
+	if err := os.MkdirAll(directory, os.ModePerm); err != nil {
+		t.Fatalf("Failed to create directories: %v", err)
+	}
+
+	config := "[core]\nrepositoryformatversion = 1\n[extensions]\nobjectformat = " + string(format) + "\n"
+	if err := os.WriteFile(filepath.Join(directory, "config"), []byte(config), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+
+	return &repository.Repo{WorkTree: workTree, Directory: directory}
+}
+
+func TestReadMissingIndex(t *testing.T) {
+	repo := createTestRepo(t, oid.SHA256)
+
+	indx, err := ReadIndex(repo)
+	if err != nil {
+		t.Fatalf("ReadIndex failed: %v", err)
+	}
+
+	if indx.Version != 2 || len(indx.Entries) != 0 {
+		t.Errorf("Expected an empty version 2 index, got %+v", indx)
+	}
+}
+
+func TestIndexRoundTrip(t *testing.T) {
+	for _, format := range []oid.Algorithm{oid.SHA1, oid.SHA256} {
+		repo := createTestRepo(t, format)
+
+		entries := []IndexEntry{
+			{
+				CTimeSec:   1700000000,
+				CTimeNsec:  12,
+				MTimeSec:   1700000001,
+				MTimeNsec:  34,
+				Dev:        56,
+				Ino:        78,
+				ModeType:   0b1000,
+				ModePerms:  0o644,
+				Uid:        1000,
+				Gid:        1000,
+				Fsize:      5,
+				Sha:        format.Sum([]byte("a")),
+				FlagStaged: 0,
+				Name:       "a.txt",
+			},
+			{
+				ModeType:   0b1000,
+				ModePerms:  0o755,
+				Fsize:      9,
+				Sha:        format.Sum([]byte("b")),
+				FlagsValid: true,
+				FlagStaged: 2 << 12,
+				Name:       "dir/" + strings.Repeat("b", 20) + ".sh",
+			},
+		}
+
+		if err := CreateIndex(2, entries).WriteIndex(repo); err != nil {
+			t.Fatalf("WriteIndex (%s) failed: %v", format, err)
+		}
+
+		indx, err := ReadIndex(repo)
+		if err != nil {
+			t.Fatalf("ReadIndex (%s) failed: %v", format, err)
+		}
+
+		if len(indx.Entries) != len(entries) {
+			t.Fatalf("Expected %d entries, got %d", len(entries), len(indx.Entries))
+		}
+
+		for i, entry := range entries {
+			if indx.Entries[i] != entry {
+				t.Errorf("%s: expected entry %+v, got %+v", format, entry, indx.Entries[i])
+			}
+		}
+	}
+}
+
+func TestWriteIndexWrongHashSize(t *testing.T) {
+	repo := createTestRepo(t, oid.SHA1)
+
+	entries := []IndexEntry{{ModeType: 0b1000, ModePerms: 0o644, Sha: oid.SHA256.Sum([]byte("a")), Name: "a.txt"}}
+	if err := CreateIndex(2, entries).WriteIndex(repo); err == nil {
+		t.Errorf("Expected an error writing a SHA-256 id to a SHA-1 index")
+	}
+}
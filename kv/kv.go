@@ -40,32 +40,39 @@ func CreateOrderedMap() *OrderedMap {
 // The function handles continuation lines and ensures that values are correctly associated with their keys.
 // If a key already exists in the map, the function appends the new value to a list of values for that key.
 // If the map is nil, it initializes a new map before parsing.
+// Malformed data, such as a line without a key or data ending before the message, is an error.
 func Parse(rawData []byte, startIndex int, dct *OrderedMap) (*OrderedMap, error) {
 
 	if dct == nil {
 		dct = CreateOrderedMap()
 	}
 
+	if startIndex >= len(rawData) {
+		return nil, fmt.Errorf("missing blank line before the message")
+	}
+
 	spaceIndex := utils.FindIndex(rawData, startIndex, ' ')
 	newLineIndex := utils.FindIndex(rawData, startIndex, '\n')
 
+	if newLineIndex == startIndex {
+		// Found a message (end of key-value pairs), stored after the new line
+		dct.Add("message", rawData[startIndex+1:])
+		return dct, nil
+	}
+	if newLineIndex < 0 {
+		return nil, fmt.Errorf("unterminated line %q", rawData[startIndex:])
+	}
 	if spaceIndex < 0 || newLineIndex < spaceIndex {
-		// Found a message (end of key-value pairs)
-		if newLineIndex == startIndex {
-			// Store message after the new line
-			dct.Add("message", rawData[startIndex+1:])
-			return dct, nil
-		}
+		return nil, fmt.Errorf("malformed line %q", rawData[startIndex:newLineIndex])
 	}
 
-	// Base case: found the key-value pair
+	// Base case: found the key-value pair, which continues on lines starting with a space
 	key := string(rawData[startIndex:spaceIndex])
-	endIndex := startIndex
-	for {
+	endIndex := newLineIndex
+	for endIndex+1 < len(rawData) && rawData[endIndex+1] == ' ' {
 		endIndex = utils.FindIndex(rawData, endIndex+1, '\n')
-		// Check if it's the end of continuation line
-		if rawData[endIndex+1] != ' ' {
-			break
+		if endIndex < 0 {
+			return nil, fmt.Errorf("unterminated value of %s", key)
 		}
 	}
 	value := string(bytes.TrimSpace(rawData[spaceIndex+1 : endIndex]))
@@ -149,3 +149,18 @@ func TestOrderedMap_Add(t *testing.T) {
 		t.Errorf("Unexpected data: %v", orderedMap.data)
 	}
 }
+
+func TestParseMalformed(t *testing.T) {
+	for _, data := range []string{
+		"",
+		"tree abc\n",
+		"tree abc",
+		"tree abc\nauthor",
+		"tree\n\nmessage\n",
+		"gpgsig line\n continued",
+	} {
+		if _, err := Parse([]byte(data), 0, nil); err == nil {
+			t.Errorf("Expected an error parsing %q", data)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package logfmt
+
+import (
+	"orf/oid"
+	"strings"
+)
+
+// Graph draws the ASCII history graph shown by `log --graph`. Each column tracks the next
+// commit expected on that line of history. Commits must be rendered in topological order.
+type Graph struct {
+	columns []oid.ObjectID
+}
+
+func NewGraph() *Graph {
+	return &Graph{}
+}
+
+// Render returns the output lines for a commit: the first line of text behind the commit's
+// row, the remaining lines behind the columns that continue past it, then any rows joining or
+// splitting columns for its parents.
+func (graph *Graph) Render(hash oid.ObjectID, parents []oid.ObjectID, text []string) []string {
+	column := graph.index(hash)
+	if column == -1 {
+		graph.columns = append(graph.columns, hash)
+		column = len(graph.columns) - 1
+	}
+
+	var lines []string
+	for i, line := range text {
+		var row []byte
+		for j := range graph.columns {
+			switch {
+			case j == column && i == 0:
+				row = append(row, '*', ' ')
+			case j == column && len(parents) == 0:
+				row = append(row, ' ', ' ')
+			default:
+				row = append(row, '|', ' ')
+			}
+		}
+		lines = append(lines, strings.TrimRight(string(row)+line, " "))
+	}
+
+	var added []oid.ObjectID
+	for _, parent := range parents {
+		if graph.index(parent) == -1 && !containsID(added, parent) {
+			added = append(added, parent)
+		}
+	}
+
+	columns := append([]oid.ObjectID{}, graph.columns[:column]...)
+	columns = append(columns, added...)
+	columns = append(columns, graph.columns[column+1:]...)
+
+	switch {
+	case len(added) > 1:
+		// Open new columns for the extra parents, pushing later columns right
+		row := graph.row()
+		for k := 1; k < len(added); k++ {
+			setCell(&row, 2*column+2*k-1, '\\')
+		}
+		for j := column + 1; j < len(graph.columns); j++ {
+			row[2*j] = ' '
+			setCell(&row, 2*j+2*(len(added)-1)-1, '\\')
+		}
+		lines = append(lines, strings.TrimRight(string(row), " "))
+
+	case len(added) == 0 && (len(parents) > 0 || column < len(graph.columns)-1):
+		// Close the commit's column, joining it to its parent's column on the left
+		row := graph.row()
+		row[2*column] = ' '
+		if len(parents) > 0 && graph.index(parents[0]) < column {
+			row[2*column-1] = '/'
+		}
+		for j := column + 1; j < len(graph.columns); j++ {
+			row[2*j] = ' '
+			row[2*j-1] = '/'
+		}
+		if trimmed := strings.TrimRight(string(row), " "); trimmed != "" {
+			lines = append(lines, trimmed)
+		}
+	}
+
+	graph.columns = columns
+	return lines
+}
+
+// row returns a blank row with a | for each current column.
+func (graph *Graph) row() []byte {
+	return []byte(strings.Repeat("| ", len(graph.columns)))
+}
+
+func (graph *Graph) index(hash oid.ObjectID) int {
+	for i, column := range graph.columns {
+		if column == hash {
+			return i
+		}
+	}
+	return -1
+}
+
+// setCell writes c at position i, growing the row with spaces if needed.
+func setCell(row *[]byte, i int, c byte) {
+	for len(*row) <= i {
+		*row = append(*row, ' ')
+	}
+	(*row)[i] = c
+}
+
+func containsID(ids []oid.ObjectID, id oid.ObjectID) bool {
+	for _, candidate := range ids {
+		if candidate == id {
+			return true
+		}
+	}
+	return false
+}
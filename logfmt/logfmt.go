@@ -0,0 +1,301 @@
+package logfmt
+
+import (
+	"fmt"
+	"orf/object"
+	"orf/oid"
+	"orf/repository"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DateFormat is the layout used for dates in the default log output.
+const DateFormat = "Mon Jan 2 15:04:05 2006 -0700"
+
+// Entry is a commit to be formatted, with the ref names decorating it.
+type Entry struct {
+	Hash   oid.ObjectID
+	Commit *object.Commit
+	Refs   []string
+}
+
+// now is the reference time for relative dates, replaced in tests.
+var now = time.Now
+
+// Medium formats an entry like the default git log output: the id, merge parents,
+// author, date and the indented message, followed by a blank line.
+func Medium(entry *Entry, decorate bool) string {
+	var builder strings.Builder
+
+	builder.WriteString("commit " + entry.Hash.String())
+	if decorate && len(entry.Refs) > 0 {
+		builder.WriteString(" (" + strings.Join(entry.Refs, ", ") + ")")
+	}
+	builder.WriteString("\n")
+
+	if parents := entry.Commit.Parents(); len(parents) > 1 {
+		shortParents := make([]string, len(parents))
+		for i, parent := range parents {
+			shortParents[i] = parent.Short()
+		}
+		builder.WriteString("Merge: " + strings.Join(shortParents, " ") + "\n")
+	}
+
+	if author, err := entry.Commit.Author(); err == nil {
+		builder.WriteString(fmt.Sprintf("Author: %s <%s>\n", author.Name, author.Email))
+		builder.WriteString("Date:   " + author.When.Format(DateFormat) + "\n")
+	}
+
+	builder.WriteString("\n")
+	for _, line := range strings.Split(strings.TrimRight(entry.Commit.Message(), "\n"), "\n") {
+		if line == "" {
+			builder.WriteString("\n")
+			continue
+		}
+		builder.WriteString("    " + line + "\n")
+	}
+	builder.WriteString("\n")
+
+	return builder.String()
+}
+
+// Oneline formats an entry as its abbreviated id and subject.
+func Oneline(entry *Entry, decorate bool) string {
+	line := entry.Hash.Short()
+	if decorate && len(entry.Refs) > 0 {
+		line += " (" + strings.Join(entry.Refs, ", ") + ")"
+	}
+	return line + " " + subject(entry.Commit.Message()) + "\n"
+}
+
+// Format expands the placeholders in template for an entry:
+//
+//	%H %h    commit id, abbreviated commit id
+//	%T %t    tree id, abbreviated tree id
+//	%P %p    parent ids, abbreviated parent ids
+//	%an %ae  author name, email
+//	%ad %ar %at  author date, relative date, unix timestamp
+//	%cn %ce %cd %cr %ct  the same for the committer
+//	%s %b %B  subject, body, raw message
+//	%d %D    ref names as " (a, b)" and as "a, b"
+//	%n %%    newline, a literal %
+//
+// Unknown placeholders are copied as is. A newline is appended to the result.
+func Format(template string, entry *Entry) string {
+	var builder strings.Builder
+
+	for i := 0; i < len(template); i++ {
+		if template[i] != '%' || i+1 == len(template) {
+			builder.WriteByte(template[i])
+			continue
+		}
+
+		value, length, ok := expand(template[i+1:], entry)
+		if !ok {
+			builder.WriteByte('%')
+			continue
+		}
+
+		builder.WriteString(value)
+		i += length
+	}
+
+	builder.WriteString("\n")
+	return builder.String()
+}
+
+// expand returns the value of the placeholder at the start of spec (after the %) and its length.
+func expand(spec string, entry *Entry) (string, int, bool) {
+	commit := entry.Commit
+
+	switch spec[0] {
+	case '%':
+		return "%", 1, true
+	case 'n':
+		return "\n", 1, true
+	case 'H':
+		return entry.Hash.String(), 1, true
+	case 'h':
+		return entry.Hash.Short(), 1, true
+	case 'T':
+		return commit.TreeHash().String(), 1, true
+	case 't':
+		return commit.TreeHash().Short(), 1, true
+	case 'P', 'p':
+		var parents []string
+		for _, parent := range commit.Parents() {
+			if spec[0] == 'p' {
+				parents = append(parents, parent.Short())
+			} else {
+				parents = append(parents, parent.String())
+			}
+		}
+		return strings.Join(parents, " "), 1, true
+	case 's':
+		return subject(commit.Message()), 1, true
+	case 'b':
+		return body(commit.Message()), 1, true
+	case 'B':
+		return commit.Message(), 1, true
+	case 'd':
+		if len(entry.Refs) == 0 {
+			return "", 1, true
+		}
+		return " (" + strings.Join(entry.Refs, ", ") + ")", 1, true
+	case 'D':
+		return strings.Join(entry.Refs, ", "), 1, true
+	case 'a', 'c':
+		if len(spec) < 2 {
+			return "", 0, false
+		}
+
+		signature, err := commit.Author()
+		if spec[0] == 'c' {
+			signature, err = commit.Committer()
+		}
+		if err != nil {
+			signature = &object.Signature{}
+		}
+
+		switch spec[1] {
+		case 'n':
+			return signature.Name, 2, true
+		case 'e':
+			return signature.Email, 2, true
+		case 'd':
+			return signature.When.Format(DateFormat), 2, true
+		case 'r':
+			return RelativeDate(signature.When, now()), 2, true
+		case 't':
+			return fmt.Sprintf("%d", signature.When.Unix()), 2, true
+		}
+	}
+
+	return "", 0, false
+}
+
+// RelativeDate describes when relative to now, e.g. "3 days ago".
+func RelativeDate(when, now time.Time) string {
+	seconds := int64(now.Sub(when).Seconds())
+	if seconds < 0 {
+		return "in the future"
+	}
+
+	units := []struct {
+		name    string
+		seconds int64
+	}{
+		{"year", 365 * 24 * 3600},
+		{"month", 30 * 24 * 3600},
+		{"week", 7 * 24 * 3600},
+		{"day", 24 * 3600},
+		{"hour", 3600},
+		{"minute", 60},
+		{"second", 1},
+	}
+
+	for _, unit := range units {
+		if seconds >= unit.seconds {
+			count := seconds / unit.seconds
+			if count == 1 {
+				return fmt.Sprintf("1 %s ago", unit.name)
+			}
+			return fmt.Sprintf("%d %ss ago", count, unit.name)
+		}
+	}
+
+	return "0 seconds ago"
+}
+
+// Decorations maps each commit to the names of the refs pointing at it, with HEAD first
+// (as "HEAD -> branch" when it is attached), then branches, remote-tracking branches and tags.
+func Decorations(repo *repository.Repo) (map[oid.ObjectID][]string, error) {
+	refs, err := object.ListAllRefs(repo)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(refs))
+	for name := range refs {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if refOrder(names[i]) != refOrder(names[j]) {
+			return refOrder(names[i]) < refOrder(names[j])
+		}
+		return names[i] < names[j]
+	})
+
+	decorations := make(map[oid.ObjectID][]string)
+
+	headRef, _ := object.SymbolicRef(repo, "HEAD")
+	if head, err := object.FindObject(repo, "HEAD", "commit", true); err == nil {
+		label := "HEAD"
+		if headRef != "" {
+			label = "HEAD -> " + shortRefName(headRef)
+		}
+		decorations[head] = append(decorations[head], label)
+	}
+
+	for _, name := range names {
+		if name == headRef {
+			continue
+		}
+
+		hash, err := object.FindObject(repo, name, "commit", true)
+		if err != nil {
+			continue
+		}
+
+		label := shortRefName(name)
+		if strings.HasPrefix(name, "refs/tags/") {
+			label = "tag: " + label
+		}
+		decorations[hash] = append(decorations[hash], label)
+	}
+
+	return decorations, nil
+}
+
+func refOrder(name string) int {
+	switch {
+	case strings.HasPrefix(name, "refs/heads/"):
+		return 0
+	case strings.HasPrefix(name, "refs/remotes/"):
+		return 1
+	case strings.HasPrefix(name, "refs/tags/"):
+		return 2
+	default:
+		return 3
+	}
+}
+
+// shortRefName strips the refs/heads/, refs/tags/ or refs/remotes/ prefix from a ref name.
+func shortRefName(name string) string {
+	for _, prefix := range []string{"refs/heads/", "refs/tags/", "refs/remotes/"} {
+		if strings.HasPrefix(name, prefix) {
+			return name[len(prefix):]
+		}
+	}
+	return strings.TrimPrefix(name, "refs/")
+}
+
+// subject returns the first paragraph of a message, joined into one line.
+func subject(message string) string {
+	paragraph := strings.TrimSpace(message)
+	if end := strings.Index(paragraph, "\n\n"); end != -1 {
+		paragraph = paragraph[:end]
+	}
+	return strings.ReplaceAll(paragraph, "\n", " ")
+}
+
+// body returns the message after the subject paragraph.
+func body(message string) string {
+	message = strings.TrimSpace(message)
+	end := strings.Index(message, "\n\n")
+	if end == -1 {
+		return ""
+	}
+	return strings.TrimSpace(message[end+2:]) + "\n"
+}
@@ -0,0 +1,189 @@
+package logfmt
+
+import (
+	"orf/kv"
+	"orf/object"
+	"orf/oid"
+	"orf/repository"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	commitHash = oid.ObjectID("1111111111111111111111111111111111111111")
+	treeHash   = oid.ObjectID("2222222222222222222222222222222222222222")
+	parentA    = oid.ObjectID("3333333333333333333333333333333333333333")
+	parentB    = oid.ObjectID("4444444444444444444444444444444444444444")
+)
+
+func createTestEntry(t *testing.T, parents []string, message string) *Entry {
+	kvData := kv.CreateOrderedMap()
+	kvData.Add("tree", treeHash.String())
+	if len(parents) == 1 {
+		kvData.Add("parent", parents[0])
+	} else if len(parents) > 1 {
+		kvData.Add("parent", parents)
+	}
+	kvData.Add("author", "Alice <alice@example.com> 1700000000 +0100")
+	kvData.Add("committer", "Bob <bob@example.com> 1700003600 +0000")
+	kvData.Add("message", []byte(message))
+
+	commit := object.CreateCommit(nil)
+	if err := commit.Deserialize(kv.Serialize(kvData)); err != nil {
+		t.Fatalf("Failed to parse commit: %v", err)
+	}
+
+	return &Entry{Hash: commitHash, Commit: commit, Refs: []string{"HEAD -> master", "tag: v1.0"}}
+}
+
+func TestMedium(t *testing.T) {
+	entry := createTestEntry(t, []string{parentA.String(), parentB.String()}, "Merge branch\n\nWith a body\n")
+
+	expected := "commit 1111111111111111111111111111111111111111 (HEAD -> master, tag: v1.0)\n" +
+		"Merge: 33333333 44444444\n" +
+		"Author: Alice <alice@example.com>\n" +
+		"Date:   Tue Nov 14 23:13:20 2023 +0100\n" +
+		"\n" +
+		"    Merge branch\n" +
+		"\n" +
+		"    With a body\n" +
+		"\n"
+
+	if output := Medium(entry, true); output != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, output)
+	}
+
+	if output := Medium(entry, false); strings.Contains(output, "HEAD -> master") {
+		t.Errorf("Expected no decorations, got:\n%s", output)
+	}
+}
+
+func TestOneline(t *testing.T) {
+	entry := createTestEntry(t, []string{parentA.String()}, "Fix the parser\nacross two lines\n\nBody")
+
+	if output := Oneline(entry, false); output != "11111111 Fix the parser across two lines\n" {
+		t.Errorf("Unexpected oneline output %q", output)
+	}
+	if output := Oneline(entry, true); output != "11111111 (HEAD -> master, tag: v1.0) Fix the parser across two lines\n" {
+		t.Errorf("Unexpected decorated oneline output %q", output)
+	}
+}
+
+func TestFormat(t *testing.T) {
+	now = func() time.Time { return time.Unix(1700000000+3*24*3600, 0) }
+	defer func() { now = time.Now }()
+
+	entry := createTestEntry(t, []string{parentA.String(), parentB.String()}, "Subject line\n\nBody text\n")
+
+	tests := map[string]string{
+		"%H":         commitHash.String(),
+		"%h %t":      "11111111 22222222",
+		"%P":         parentA.String() + " " + parentB.String(),
+		"%p":         "33333333 44444444",
+		"%an <%ae>":  "Alice <alice@example.com>",
+		"%cn %ct":    "Bob 1700003600",
+		"%ad":        "Tue Nov 14 23:13:20 2023 +0100",
+		"%ar":        "3 days ago",
+		"%s|%b":      "Subject line|Body text\n",
+		"%d":         " (HEAD -> master, tag: v1.0)",
+		"%D":         "HEAD -> master, tag: v1.0",
+		"a%nb %% %x": "a\nb % %x",
+		"trailing %": "trailing %",
+	}
+
+	for template, expected := range tests {
+		if output := Format(template, entry); output != expected+"\n" {
+			t.Errorf("Format(%q) = %q; expected %q", template, output, expected+"\n")
+		}
+	}
+}
+
+func TestRelativeDate(t *testing.T) {
+	base := time.Unix(1700000000, 0)
+
+	tests := map[time.Duration]string{
+		0:                    "0 seconds ago",
+		90 * time.Second:     "1 minute ago",
+		5 * time.Hour:        "5 hours ago",
+		15 * 24 * time.Hour:  "2 weeks ago",
+		800 * 24 * time.Hour: "2 years ago",
+		-time.Hour:           "in the future",
+	}
+
+	for offset, expected := range tests {
+		if output := RelativeDate(base, base.Add(offset)); output != expected {
+			t.Errorf("RelativeDate(%v) = %q; expected %q", offset, output, expected)
+		}
+	}
+}
+
+func TestGraph(t *testing.T) {
+	// merge <- third <- second <- root, merge <- side <- root, in topological order
+	merge, third, second, side, root := oid.ObjectID("m"), oid.ObjectID("t"), oid.ObjectID("s"), oid.ObjectID("x"), oid.ObjectID("r")
+
+	graph := NewGraph()
+	var lines []string
+	lines = append(lines, graph.Render(merge, []oid.ObjectID{third, side}, []string{"merge", "body"})...)
+	lines = append(lines, graph.Render(third, []oid.ObjectID{second}, []string{"third"})...)
+	lines = append(lines, graph.Render(second, []oid.ObjectID{root}, []string{"second"})...)
+	lines = append(lines, graph.Render(side, []oid.ObjectID{root}, []string{"side"})...)
+	lines = append(lines, graph.Render(root, nil, []string{"root", ""})...)
+
+	expected := []string{
+		"* merge",
+		"| body",
+		"|\\",
+		"* | third",
+		"* | second",
+		"| * side",
+		"|/",
+		"* root",
+		"",
+	}
+
+	if strings.Join(lines, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Expected graph:\n%s\nGot:\n%s", strings.Join(expected, "\n"), strings.Join(lines, "\n"))
+	}
+}
+
+func TestDecorations(t *testing.T) {
+	workTree := t.TempDir()
+	repo := &repository.Repo{WorkTree: workTree, Directory: filepath.Join(workTree, ".orf")}
+
+	kvData := kv.CreateOrderedMap()
+	kvData.Add("tree", treeHash.String())
+	kvData.Add("message", []byte("Commit"))
+	commit, err := object.WriteObject(repo.Directory, object.CreateCommit(kv.Serialize(kvData)))
+	if err != nil {
+		t.Fatalf("Failed to write commit: %v", err)
+	}
+
+	refs := map[string]string{
+		"HEAD":              "ref: refs/heads/master",
+		"refs/heads/master": commit.String(),
+		"refs/heads/topic":  commit.String(),
+		"refs/tags/v1.0":    commit.String(),
+	}
+	for name, content := range refs {
+		path := filepath.Join(repo.Directory, name)
+		if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+			t.Fatalf("Failed to create ref directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content+"\n"), 0644); err != nil {
+			t.Fatalf("Failed to write ref: %v", err)
+		}
+	}
+
+	decorations, err := Decorations(repo)
+	if err != nil {
+		t.Fatalf("Decorations failed: %v", err)
+	}
+
+	expected := "HEAD -> master, topic, tag: v1.0"
+	if actual := strings.Join(decorations[commit], ", "); actual != expected {
+		t.Errorf("Expected %q, got %q", expected, actual)
+	}
+}
@@ -4,44 +4,84 @@ import (
 	"flag"
 	"fmt"
 	"orf/cmd"
+	"orf/revwalk"
+	"orf/sequencer"
 	"os"
+	"regexp"
+	"strings"
 )
 
 func main() {
 
 	if len(os.Args) == 1 {
 		cmd.Help()
-		os.Exit(1)
+		exit(1)
 	}
 
 	switch os.Args[1] {
 	case "init":
 		initCmd := flag.NewFlagSet("init", flag.ExitOnError)
+		objectFormatFlag := initCmd.String("object-format", "sha256", "Hash algorithm for object ids (sha1, sha256)")
 		initCmd.Parse(os.Args[2:])
 
 		if initCmd.NArg() < 1 {
 			fmt.Println("expected path argument")
-			os.Exit(1)
+			exit(1)
 		}
 
 		pathArg := initCmd.Arg(0)
 
-		err := cmd.Init(pathArg)
+		err := cmd.Init(pathArg, *objectFormatFlag)
 		if err != nil {
 			fmt.Printf("error initializing repo: %v/n", err)
-			os.Exit(1)
+			exit(1)
 		}
 
 		fmt.Printf("Succesfully initialized repo\n")
-		os.Exit(1)
+		exit(1)
 
 	case "cat":
 		initCmd := flag.NewFlagSet("cat", flag.ExitOnError)
+		typeFlag := initCmd.Bool("t", false, "Print the type of the object")
+		sizeFlag := initCmd.Bool("s", false, "Print the size of the object")
+		prettyFlag := initCmd.Bool("p", false, "Pretty-print the object, listing trees like ls-tree")
+		batchFlag := initCmd.Bool("batch", false, "Print the header and contents of each object named on stdin")
+		batchCheckFlag := initCmd.Bool("batch-check", false, "Print the header of each object named on stdin")
 		initCmd.Parse(os.Args[2:])
 
+		if *batchFlag || *batchCheckFlag {
+			err := cmd.CatBatch(os.Stdin, os.Stdout, *batchFlag)
+			if err != nil {
+				fmt.Printf("error reading objects: %v\n", err)
+				exit(1)
+			}
+			exit(0)
+		}
+
+		if *typeFlag || *sizeFlag || *prettyFlag {
+			if initCmd.NArg() != 1 {
+				fmt.Println("expected one object argument")
+				exit(1)
+			}
+
+			mode := "pretty"
+			if *typeFlag {
+				mode = "type"
+			} else if *sizeFlag {
+				mode = "size"
+			}
+
+			err := cmd.CatInfo(initCmd.Arg(0), mode)
+			if err != nil {
+				fmt.Printf("error returning object: %v\n", err)
+				exit(1)
+			}
+			exit(0)
+		}
+
 		if initCmd.NArg() < 2 {
 			fmt.Println("expected format and hash argument")
-			os.Exit(1)
+			exit(1)
 		}
 
 		formatArg := initCmd.Arg(0)
@@ -49,17 +89,17 @@ func main() {
 
 		if !contains([]string{"blob", "commit", "tag", "tree"}, formatArg) {
 			fmt.Println("incorrect format argument")
-			os.Exit(1)
+			exit(1)
 		}
 
 		obj, err := cmd.CatObject(hashArg, formatArg)
 		if err != nil {
 			fmt.Printf("error returning object: %v/n", err)
-			os.Exit(1)
+			exit(1)
 		}
 
 		fmt.Printf("%s\n", string(obj.GetData()))
-		os.Exit(1)
+		exit(1)
 
 	case "hash":
 		initCmd := flag.NewFlagSet("hash", flag.ExitOnError)
@@ -68,14 +108,14 @@ func main() {
 
 		if !contains([]string{"blob", "commit", "tag", "tree"}, *formatFlag) {
 			fmt.Println("incorrect type argument")
-			os.Exit(1)
+			exit(1)
 		}
 
 		initCmd.Parse(os.Args[2:])
 
 		if initCmd.NArg() < 1 {
 			fmt.Println("expected path argument")
-			os.Exit(1)
+			exit(1)
 		}
 
 		pathArg := initCmd.Arg(0)
@@ -83,28 +123,480 @@ func main() {
 		hash, err := cmd.HashObject(pathArg, *formatFlag, *writeFlag)
 		if err != nil {
 			fmt.Printf("error writing object: %v\n", err)
-			os.Exit(1)
+			exit(1)
 		}
 
 		fmt.Printf("Object written with hash: %s\n", hash)
-		os.Exit(1)
+		exit(1)
 
 	case "log":
 		initCmd := flag.NewFlagSet("log", flag.ExitOnError)
+		onelineFlag := initCmd.Bool("oneline", false, "Show each commit as its abbreviated id and subject")
+		formatFlag := initCmd.String("format", "medium", "Output format (medium, oneline, dot or a placeholder template)")
+		graphFlag := initCmd.Bool("graph", false, "Draw the history graph beside the log")
+		decorateFlag := initCmd.Bool("decorate", false, "Show the refs pointing at each commit")
+		walkOptions := walkFlags(initCmd)
+
+		// Paths follow a "--" separator, after the revisions
+		args, paths := splitPaths(os.Args[2:])
+		initCmd.Parse(args)
+
+		walk, err := walkOptions(paths)
+		if err != nil {
+			fmt.Printf("error parsing options: %v\n", err)
+			exit(1)
+		}
+
+		opts := cmd.LogOptions{
+			Format:   *formatFlag,
+			Graph:    *graphFlag,
+			Decorate: *decorateFlag,
+			Walk:     walk,
+		}
+		if *onelineFlag {
+			opts.Format = "oneline"
+		}
+
+		err = cmd.Log(initCmd.Args(), opts)
+		if err != nil {
+			fmt.Printf("error logging commit: %v\n", err)
+			exit(1)
+		}
+		exit(1)
+
+	case "diff":
+		initCmd := flag.NewFlagSet("diff", flag.ExitOnError)
+		cachedFlag := initCmd.Bool("cached", false, "Compare a commit (default HEAD) with the index")
+		nameStatusFlag := initCmd.Bool("name-status", false, "Show the status and paths of each change instead of a patch")
 		initCmd.Parse(os.Args[2:])
 
-		commitArg := "HEAD"
+		err := cmd.Diff(initCmd.Args(), cmd.DiffOptions{Cached: *cachedFlag, NameStatus: *nameStatusFlag})
+		if err != nil {
+			fmt.Printf("error showing changes: %v\n", err)
+			exit(1)
+		}
+		exit(0)
+
+	case "rev-list":
+		initCmd := flag.NewFlagSet("rev-list", flag.ExitOnError)
+		walkOptions := walkFlags(initCmd)
+
+		args, paths := splitPaths(os.Args[2:])
+		initCmd.Parse(args)
+
+		opts, err := walkOptions(paths)
+		if err != nil {
+			fmt.Printf("error parsing options: %v\n", err)
+			exit(1)
+		}
+
+		err = cmd.RevList(initCmd.Args(), opts)
+		if err != nil {
+			fmt.Printf("error listing revisions: %v\n", err)
+			exit(1)
+		}
+		exit(0)
+
+	case "merge-base":
+		initCmd := flag.NewFlagSet("merge-base", flag.ExitOnError)
+		allFlag := initCmd.Bool("all", false, "Print all best common ancestors")
+		octopusFlag := initCmd.Bool("octopus", false, "Find the common ancestors of all the commits")
+		isAncestorFlag := initCmd.Bool("is-ancestor", false, "Exit with status 0 if the first commit is an ancestor of the second")
+		forkPointFlag := initCmd.Bool("fork-point", false, "Find where a commit forked from a ref, using the ref's reflog")
+		initCmd.Parse(os.Args[2:])
+
+		switch {
+		case *isAncestorFlag:
+			if initCmd.NArg() != 2 {
+				fmt.Println("expected two commit arguments")
+				exit(1)
+			}
+
+			isAncestor, err := cmd.IsAncestor(initCmd.Arg(0), initCmd.Arg(1))
+			if err != nil {
+				fmt.Printf("error checking ancestry: %v\n", err)
+				exit(128)
+			}
+			if isAncestor {
+				exit(0)
+			}
+			exit(1)
+
+		case *forkPointFlag:
+			if initCmd.NArg() < 1 || initCmd.NArg() > 2 {
+				fmt.Println("expected ref and optional commit argument")
+				exit(1)
+			}
+
+			commitArg := "HEAD"
+			if initCmd.NArg() == 2 {
+				commitArg = initCmd.Arg(1)
+			}
+
+			err := cmd.ForkPoint(initCmd.Arg(0), commitArg)
+			if err != nil {
+				fmt.Printf("error finding fork point: %v\n", err)
+				exit(1)
+			}
+
+		default:
+			if initCmd.NArg() < 1 {
+				fmt.Println("expected commit arguments")
+				exit(1)
+			}
+
+			err := cmd.MergeBase(initCmd.Args(), *allFlag, *octopusFlag)
+			if err != nil {
+				fmt.Printf("error finding merge base: %v\n", err)
+				exit(1)
+			}
+		}
+		exit(0)
+
+	case "merge":
+		initCmd := flag.NewFlagSet("merge", flag.ExitOnError)
+		noFFFlag := initCmd.Bool("no-ff", false, "Create a merge commit even when the merge resolves as a fast-forward")
+		squashFlag := initCmd.Bool("squash", false, "Update the index and working tree without committing the merge")
+		abortFlag := initCmd.Bool("abort", false, "Abandon a conflicted merge, restoring HEAD")
+		continueFlag := initCmd.Bool("continue", false, "Commit a merge once its conflicts are resolved")
+		initCmd.Parse(os.Args[2:])
+
+		var err error
+		switch {
+		case *abortFlag:
+			err = cmd.MergeAbort()
+		case *continueFlag:
+			err = cmd.MergeContinue()
+		default:
+			if initCmd.NArg() != 1 {
+				fmt.Println("expected branch argument")
+				exit(1)
+			}
+			err = cmd.Merge(initCmd.Arg(0), cmd.MergeOptions{NoFF: *noFFFlag, Squash: *squashFlag})
+		}
+		if err != nil {
+			fmt.Printf("error merging: %v\n", err)
+			exit(1)
+		}
+		exit(0)
+
+	case "cherry-pick", "revert":
+		initCmd := flag.NewFlagSet(os.Args[1], flag.ExitOnError)
+		mainlineFlag := initCmd.Int("m", 0, "Parent number (starting from 1) to diff merge commits against")
+		continueFlag := initCmd.Bool("continue", false, "Continue after resolving conflicts")
+		skipFlag := initCmd.Bool("skip", false, "Skip the current commit and continue with the rest")
+		abortFlag := initCmd.Bool("abort", false, "Cancel the operation, restoring HEAD")
+		recordOriginFlag := new(bool)
+		if os.Args[1] == "cherry-pick" {
+			recordOriginFlag = initCmd.Bool("x", false, "Record the picked commit id in the message")
+		}
+		initCmd.Parse(os.Args[2:])
+
+		var err error
+		switch {
+		case *continueFlag:
+			err = cmd.SequencerContinue()
+		case *skipFlag:
+			err = cmd.SequencerSkip()
+		case *abortFlag:
+			err = cmd.SequencerAbort()
+		default:
+			if initCmd.NArg() < 1 {
+				fmt.Println("expected commit arguments")
+				exit(1)
+			}
+
+			opts := sequencer.Options{RecordOrigin: *recordOriginFlag, Mainline: *mainlineFlag}
+			if os.Args[1] == "cherry-pick" {
+				err = cmd.CherryPick(initCmd.Args(), opts)
+			} else {
+				err = cmd.Revert(initCmd.Args(), opts)
+			}
+		}
+		if err != nil {
+			fmt.Printf("error: %v\n", err)
+			exit(1)
+		}
+		exit(0)
+
+	case "reset":
+		initCmd := flag.NewFlagSet("reset", flag.ExitOnError)
+		softFlag := initCmd.Bool("soft", false, "Only move the current branch")
+		mixedFlag := initCmd.Bool("mixed", false, "Move the branch and reset the index (default)")
+		hardFlag := initCmd.Bool("hard", false, "Move the branch and reset the index and working tree")
+		keepFlag := initCmd.Bool("keep", false, "Like --hard, but refuse to discard local changes")
+		args, paths := splitPaths(os.Args[2:])
+		initCmd.Parse(args)
+
+		mode := cmd.ResetMixed
+		modes := 0
+		for _, flag := range []struct {
+			set  bool
+			mode cmd.ResetMode
+		}{{*softFlag, cmd.ResetSoft}, {*mixedFlag, cmd.ResetMixed}, {*hardFlag, cmd.ResetHard}, {*keepFlag, cmd.ResetKeep}} {
+			if flag.set {
+				mode = flag.mode
+				modes++
+			}
+		}
+		if modes > 1 {
+			fmt.Println("--soft, --mixed, --hard and --keep are incompatible")
+			exit(1)
+		}
+
+		revision := "HEAD"
+		if initCmd.NArg() > 1 {
+			fmt.Println("expected at most one revision argument")
+			exit(1)
+		}
 		if initCmd.NArg() == 1 {
-			commitArg = initCmd.Arg(0)
+			revision = initCmd.Arg(0)
+		}
 
+		var err error
+		if len(paths) > 0 {
+			if mode != cmd.ResetMixed {
+				fmt.Printf("cannot do a %s reset with paths\n", mode)
+				exit(1)
+			}
+			err = cmd.ResetPaths(revision, paths)
+		} else {
+			err = cmd.Reset(revision, mode)
+		}
+		if err != nil {
+			fmt.Printf("error resetting: %v\n", err)
+			exit(1)
+		}
+		exit(0)
+
+	case "restore":
+		initCmd := flag.NewFlagSet("restore", flag.ExitOnError)
+		stagedFlag := initCmd.Bool("staged", false, "Restore the index entries (from HEAD by default)")
+		worktreeFlag := initCmd.Bool("worktree", false, "Restore the working tree files (the default)")
+		sourceFlag := initCmd.String("source", "", "Restore from the tree of this revision")
+		args, paths := splitPaths(os.Args[2:])
+		initCmd.Parse(args)
+
+		paths = append(initCmd.Args(), paths...)
+		if len(paths) == 0 {
+			fmt.Println("expected path arguments")
+			exit(1)
 		}
 
-		err := cmd.Log(commitArg)
+		opts := cmd.RestoreOptions{Staged: *stagedFlag, Worktree: *worktreeFlag, Source: *sourceFlag}
+		err := cmd.Restore(paths, opts)
 		if err != nil {
-			fmt.Printf("error logging commit: %v\n", err)
-			os.Exit(1)
+			fmt.Printf("error restoring: %v\n", err)
+			exit(1)
+		}
+		exit(0)
+
+	case "stash":
+		action, args := "push", os.Args[2:]
+		if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+			action, args = args[0], args[1:]
+		}
+
+		initCmd := flag.NewFlagSet("stash "+action, flag.ExitOnError)
+		var err error
+		switch action {
+		case "push", "save":
+			untrackedFlag := initCmd.Bool("u", false, "Also stash untracked files")
+			messageFlag := initCmd.String("m", "", "Description of the stash")
+			initCmd.Parse(args)
+			err = cmd.StashPush(cmd.StashOptions{Message: *messageFlag, Untracked: *untrackedFlag})
+
+		case "apply", "pop":
+			indexFlag := initCmd.Bool("index", false, "Also restore the stashed index changes")
+			initCmd.Parse(args)
+			if action == "pop" {
+				err = cmd.StashPop(initCmd.Arg(0), *indexFlag)
+			} else {
+				err = cmd.StashApply(initCmd.Arg(0), *indexFlag)
+			}
+
+		case "list":
+			err = cmd.StashList()
+		case "show":
+			initCmd.Parse(args)
+			err = cmd.StashShow(initCmd.Arg(0))
+		case "drop":
+			initCmd.Parse(args)
+			err = cmd.StashDrop(initCmd.Arg(0))
+		case "clear":
+			err = cmd.StashClear()
+
+		default:
+			fmt.Printf("unknown stash subcommand %s\n", action)
+			exit(1)
+		}
+		if err != nil {
+			fmt.Printf("error: %v\n", err)
+			exit(1)
+		}
+		exit(0)
+
+	case "commit-graph":
+		if len(os.Args) < 3 {
+			fmt.Println("expected write or verify")
+			exit(1)
+		}
+
+		var err error
+		switch os.Args[2] {
+		case "write":
+			err = cmd.CommitGraphWrite()
+		case "verify":
+			err = cmd.CommitGraphVerify()
+		default:
+			fmt.Printf("unknown commit-graph action %s\n", os.Args[2])
+			exit(1)
+		}
+		if err != nil {
+			fmt.Printf("error with commit-graph: %v\n", err)
+			exit(1)
+		}
+		exit(0)
+
+	case "multi-pack-index":
+		initCmd := flag.NewFlagSet("multi-pack-index", flag.ExitOnError)
+		bitmapFlag := initCmd.Bool("bitmap", false, "Also write reachability bitmaps")
+		initCmd.Parse(os.Args[2:])
+
+		if initCmd.NArg() != 1 {
+			fmt.Println("expected write or verify")
+			exit(1)
+		}
+
+		var err error
+		switch initCmd.Arg(0) {
+		case "write":
+			err = cmd.MultiPackIndexWrite(*bitmapFlag)
+		case "verify":
+			err = cmd.MultiPackIndexVerify()
+		default:
+			fmt.Printf("unknown multi-pack-index action %s\n", initCmd.Arg(0))
+			exit(1)
+		}
+		if err != nil {
+			fmt.Printf("error with multi-pack-index: %v\n", err)
+			exit(1)
+		}
+		exit(0)
+
+	case "count-objects":
+		initCmd := flag.NewFlagSet("count-objects", flag.ExitOnError)
+		verboseFlag := initCmd.Bool("v", false, "Also report packs and garbage files")
+		humanFlag := initCmd.Bool("H", false, "Print sizes in human-readable units")
+		jsonFlag := initCmd.Bool("json", false, "Print the counts as JSON, sizes in bytes")
+		initCmd.Parse(os.Args[2:])
+
+		err := cmd.CountObjects(*verboseFlag, *humanFlag, *jsonFlag)
+		if err != nil {
+			fmt.Printf("error counting objects: %v\n", err)
+			exit(1)
+		}
+		exit(0)
+
+	case "stats":
+		initCmd := flag.NewFlagSet("stats", flag.ExitOnError)
+		limitFlag := initCmd.Int("n", 10, "Number of entries to show in each list (0 for all)")
+		jsonFlag := initCmd.Bool("json", false, "Print the report as JSON, sizes in bytes")
+		initCmd.Parse(os.Args[2:])
+
+		err := cmd.Stats(*limitFlag, *jsonFlag)
+		if err != nil {
+			fmt.Printf("error collecting stats: %v\n", err)
+			exit(1)
 		}
-		os.Exit(1)
+		exit(0)
+
+	case "gc":
+		initCmd := flag.NewFlagSet("gc", flag.ExitOnError)
+		pruneFlag := initCmd.String("prune", "", "Prune unreachable loose objects older than this date (default gc.pruneExpire or 2.weeks.ago)")
+		initCmd.Parse(os.Args[2:])
+
+		err := cmd.GC(*pruneFlag)
+		if err != nil {
+			fmt.Printf("error collecting garbage: %v\n", err)
+			exit(1)
+		}
+		exit(0)
+
+	case "prune":
+		initCmd := flag.NewFlagSet("prune", flag.ExitOnError)
+		dryRunFlag := initCmd.Bool("dry-run", false, "List the objects that would be pruned without removing them")
+		expireFlag := initCmd.String("expire", "", "Only prune objects older than this date (default gc.pruneExpire or 2.weeks.ago)")
+		initCmd.Parse(os.Args[2:])
+
+		err := cmd.Prune(*expireFlag, *dryRunFlag)
+		if err != nil {
+			fmt.Printf("error pruning: %v\n", err)
+			exit(1)
+		}
+		exit(0)
+
+	case "fsck":
+		initCmd := flag.NewFlagSet("fsck", flag.ExitOnError)
+		unreachableFlag := initCmd.Bool("unreachable", false, "Show every unreachable object, not only dangling ones")
+		quarantineFlag := initCmd.Bool("quarantine", false, "Move loose objects whose contents do not match their id to objects/quarantine")
+		initCmd.Parse(os.Args[2:])
+
+		ok, err := cmd.Fsck(*unreachableFlag, *quarantineFlag)
+		if err != nil {
+			fmt.Printf("error checking objects: %v\n", err)
+			exit(1)
+		}
+		if ok {
+			exit(0)
+		}
+		exit(1)
+
+	case "rebase":
+		initCmd := flag.NewFlagSet("rebase", flag.ExitOnError)
+		ontoFlag := initCmd.String("onto", "", "Replay the commits onto this commit instead of the upstream")
+		interactiveFlag := initCmd.Bool("i", false, "Edit the list of commits to replay first")
+		autosquashFlag := initCmd.Bool("autosquash", false, "Move fixup! and squash! commits after the commits they name")
+		continueFlag := initCmd.Bool("continue", false, "Continue after resolving conflicts or amending a commit")
+		skipFlag := initCmd.Bool("skip", false, "Skip the current commit and continue with the rest")
+		abortFlag := initCmd.Bool("abort", false, "Cancel the rebase, restoring the original branch")
+		initCmd.Parse(os.Args[2:])
+
+		var err error
+		switch {
+		case *continueFlag:
+			err = cmd.RebaseContinue()
+		case *skipFlag:
+			err = cmd.RebaseSkip()
+		case *abortFlag:
+			err = cmd.RebaseAbort()
+		default:
+			if initCmd.NArg() < 1 || initCmd.NArg() > 2 {
+				fmt.Println("expected upstream argument and optional branch")
+				exit(1)
+			}
+
+			opts := cmd.RebaseOptions{Onto: *ontoFlag, Interactive: *interactiveFlag, Autosquash: *autosquashFlag}
+			err = cmd.Rebase(initCmd.Arg(0), initCmd.Arg(1), opts)
+		}
+		if err != nil {
+			fmt.Printf("error: %v\n", err)
+			exit(1)
+		}
+		exit(0)
+
+	case "mergetool":
+		initCmd := flag.NewFlagSet("mergetool", flag.ExitOnError)
+		toolFlag := initCmd.String("tool", "", "Merge tool to run instead of merge.tool")
+		initCmd.Parse(os.Args[2:])
+
+		args, paths := splitPaths(initCmd.Args())
+		err := cmd.Mergetool(append(args, paths...), *toolFlag)
+		if err != nil {
+			fmt.Printf("error running merge tool: %v\n", err)
+			exit(1)
+		}
+		exit(0)
 
 	case "ls-tree":
 		initCmd := flag.NewFlagSet("ls-tree", flag.ExitOnError)
@@ -114,7 +606,7 @@ func main() {
 
 		if initCmd.NArg() < 1 {
 			fmt.Println("expected tree argument")
-			os.Exit(1)
+			exit(1)
 		}
 
 		treeArg := initCmd.Arg(0)
@@ -122,24 +614,27 @@ func main() {
 		err := cmd.ListTree(treeArg, *recursiveFlag)
 		if err != nil {
 			fmt.Printf("error listing tree: %v\n", err)
-			os.Exit(1)
+			exit(1)
 		}
-		os.Exit(1)
+		exit(1)
 
 	case "ls-refs":
 		cmd.ListRefs()
-		os.Exit(1)
+		exit(1)
 
 	case "ls-files":
 		initCmd := flag.NewFlagSet("ls-files", flag.ExitOnError)
 		isVerboseFlag := initCmd.Bool("v", false, "List all files in the index")
-		err := cmd.ListFiles(*isVerboseFlag)
+		unmergedFlag := initCmd.Bool("u", false, "List the conflict stages of unmerged paths")
+		initCmd.Parse(os.Args[2:])
+
+		err := cmd.ListFiles(*isVerboseFlag, *unmergedFlag)
 		if err != nil {
 			fmt.Printf("error listing files: %v\n", err)
-			os.Exit(1)
+			exit(1)
 		}
 
-		os.Exit(1)
+		exit(0)
 
 	case "tag":
 		initCmd := flag.NewFlagSet("tag", flag.ExitOnError)
@@ -148,7 +643,7 @@ func main() {
 
 		if initCmd.NArg() < 2 {
 			fmt.Println("expected tag & target object argument")
-			os.Exit(1)
+			exit(1)
 		}
 
 		tagArg := initCmd.Arg(0)
@@ -157,9 +652,9 @@ func main() {
 		err := cmd.Tag(tagArg, targetArg, *willCreateTagFlag)
 		if err != nil {
 			fmt.Printf("error creating tag: %v\n", err)
-			os.Exit(1)
+			exit(1)
 		}
-		os.Exit(1)
+		exit(1)
 
 	case "rev-parse":
 		initCmd := flag.NewFlagSet("rev-parse", flag.ExitOnError)
@@ -168,7 +663,7 @@ func main() {
 		initCmd.Parse(os.Args[2:])
 		if initCmd.NArg() < 1 {
 			fmt.Println("expected ref name argument")
-			os.Exit(1)
+			exit(1)
 		}
 
 		refArg := initCmd.Arg(0)
@@ -176,24 +671,51 @@ func main() {
 		// Check if typeFlag is [blob, commit, tag, tree]
 		if *typeFlag != "" && !contains([]string{"blob", "commit", "tag", "tree"}, *typeFlag) {
 			fmt.Println("incorrect type argument")
-			os.Exit(1)
+			exit(1)
 		}
 
 		err := cmd.RevParse(refArg, *typeFlag)
 		if err != nil {
 			fmt.Printf("error parsing ref: %v\n", err)
-			os.Exit(1)
+			exit(1)
 		}
-		os.Exit(1)
+		exit(1)
 
 	case "checkout":
 		initCmd := flag.NewFlagSet("checkout", flag.ExitOnError)
+		oursFlag := initCmd.Bool("ours", false, "Check out our version of unmerged paths")
+		theirsFlag := initCmd.Bool("theirs", false, "Check out their version of unmerged paths")
 
 		initCmd.Parse(os.Args[2:])
 
+		if *oursFlag || *theirsFlag {
+			if *oursFlag && *theirsFlag {
+				fmt.Println("--ours and --theirs are incompatible")
+				exit(1)
+			}
+			args, paths := splitPaths(initCmd.Args())
+			paths = append(args, paths...)
+			if len(paths) == 0 {
+				fmt.Println("expected path arguments")
+				exit(1)
+			}
+
+			stage := uint16(2)
+			if *theirsFlag {
+				stage = 3
+			}
+
+			err := cmd.CheckoutStage(paths, stage)
+			if err != nil {
+				fmt.Printf("error checking out paths: %v\n", err)
+				exit(1)
+			}
+			exit(0)
+		}
+
 		if initCmd.NArg() < 2 {
 			fmt.Println("expected hash & path argument")
-			os.Exit(1)
+			exit(1)
 		}
 
 		hashArg := initCmd.Arg(0)
@@ -202,17 +724,17 @@ func main() {
 		err := cmd.Checkout(hashArg, pathArg)
 		if err != nil {
 			fmt.Printf("error checking out hash: %v\n", err)
-			os.Exit(1)
+			exit(1)
 		}
-		os.Exit(1)
+		exit(1)
 
 	case "status":
 		err := cmd.Status()
 		if err != nil {
 			fmt.Printf("error getting status: %v\n", err)
-			os.Exit(1)
+			exit(1)
 		}
-		os.Exit(1)
+		exit(0)
 
 	case "add":
 		initCmd := flag.NewFlagSet("add", flag.ExitOnError)
@@ -221,7 +743,7 @@ func main() {
 		// Parse for at least one path argument (paths)
 		if initCmd.NArg() < 1 {
 			fmt.Println("expected paths argument")
-			os.Exit(1)
+			exit(1)
 		}
 
 		pathsArg := initCmd.Args()
@@ -229,7 +751,7 @@ func main() {
 		err := cmd.Add(pathsArg)
 		if err != nil {
 			fmt.Printf("error adding files: %v\n", err)
-			os.Exit(1)
+			exit(1)
 		}
 
 	case "rm":
@@ -237,7 +759,7 @@ func main() {
 		initCmd.Parse(os.Args[2:])
 		if initCmd.NArg() < 1 {
 			fmt.Println("expected paths argument")
-			os.Exit(1)
+			exit(1)
 		}
 
 		pathsArg := initCmd.Args()
@@ -245,9 +767,9 @@ func main() {
 		err := cmd.Remove(pathsArg)
 		if err != nil {
 			fmt.Printf("error removing files: %v\n", err)
-			os.Exit(1)
+			exit(1)
 		}
-		os.Exit(1)
+		exit(1)
 
 	case "commit":
 		initCmd := flag.NewFlagSet("commit", flag.ExitOnError)
@@ -257,21 +779,100 @@ func main() {
 		err := cmd.Commit(*messageFlag)
 		if err != nil {
 			fmt.Printf("error committing files: %v\n", err)
-			os.Exit(1)
+			exit(1)
 		}
-		os.Exit(1)
+		exit(1)
 
 	case "help":
 		cmd.Help()
-		os.Exit(1)
+		exit(1)
 
 	default:
 		fmt.Println("Expected valid subcommands")
-		os.Exit(1)
+		exit(1)
 	}
 
 }
 
+// walkFlags registers the commit ordering and limiting flags shared by log and rev-list. The
+// returned function builds the walk options once the flags are parsed.
+func walkFlags(flagSet *flag.FlagSet) func(paths []string) (revwalk.Options, error) {
+	maxCountFlag := flagSet.Int("n", -1, "Limit the number of commits")
+	flagSet.IntVar(maxCountFlag, "max-count", -1, "Limit the number of commits (same as -n)")
+	skipFlag := flagSet.Int("skip", 0, "Skip a number of commits before listing")
+	sinceFlag := flagSet.String("since", "", "Show commits more recent than a date")
+	untilFlag := flagSet.String("until", "", "Show commits older than a date")
+	topoFlag := flagSet.Bool("topo-order", false, "Show no parents before their children, keeping lines of history together")
+	dateFlag := flagSet.Bool("date-order", false, "Show no parents before their children, otherwise by commit date")
+	reverseFlag := flagSet.Bool("reverse", false, "Show commits in reverse order")
+	firstParentFlag := flagSet.Bool("first-parent", false, "Follow only the first parent of merge commits")
+	followFlag := flagSet.Bool("follow", false, "Follow a single file across renames")
+	authorFlag := flagSet.String("author", "", "Show commits whose author matches a regular expression")
+	committerFlag := flagSet.String("committer", "", "Show commits whose committer matches a regular expression")
+	grepFlag := flagSet.String("grep", "", "Show commits whose message matches a regular expression")
+	pickaxeFlag := flagSet.String("S", "", "Show commits changing the number of occurrences of a string")
+	pickaxeRegexpFlag := flagSet.String("G", "", "Show commits adding or removing lines matching a regular expression")
+
+	return func(paths []string) (revwalk.Options, error) {
+		opts := revwalk.DefaultOptions()
+		opts.MaxCount = *maxCountFlag
+		opts.Skip = *skipFlag
+		opts.Reverse = *reverseFlag
+		opts.FirstParent = *firstParentFlag
+		opts.Follow = *followFlag
+		opts.Paths = paths
+		opts.PickaxeString = *pickaxeFlag
+
+		if *topoFlag {
+			opts.Sort = revwalk.SortTopo
+		} else if *dateFlag {
+			opts.Sort = revwalk.SortDate
+		}
+
+		var err error
+		if *sinceFlag != "" {
+			if opts.Since, err = cmd.ParseDate(*sinceFlag); err != nil {
+				return opts, fmt.Errorf("--since: %v", err)
+			}
+		}
+		if *untilFlag != "" {
+			if opts.Until, err = cmd.ParseDate(*untilFlag); err != nil {
+				return opts, fmt.Errorf("--until: %v", err)
+			}
+		}
+
+		patterns := []struct {
+			value  string
+			target **regexp.Regexp
+		}{
+			{*authorFlag, &opts.Author},
+			{*committerFlag, &opts.Committer},
+			{*grepFlag, &opts.Grep},
+			{*pickaxeRegexpFlag, &opts.PickaxeRegexp},
+		}
+		for _, pattern := range patterns {
+			if pattern.value == "" {
+				continue
+			}
+			if *pattern.target, err = regexp.Compile(pattern.value); err != nil {
+				return opts, fmt.Errorf("invalid pattern %s: %v", pattern.value, err)
+			}
+		}
+
+		return opts, nil
+	}
+}
+
+// splitPaths separates arguments before and after a "--" separator.
+func splitPaths(args []string) ([]string, []string) {
+	for i, arg := range args {
+		if arg == "--" {
+			return args[:i], args[i+1:]
+		}
+	}
+	return args, nil
+}
+
 func contains(candidates []string, target string) bool {
 	for _, candidate := range candidates {
 		if target == candidate {
@@ -280,3 +881,9 @@ func contains(candidates []string, target string) bool {
 	}
 	return false
 }
+
+// exit ends orf with a status code, printing traces first.
+func exit(code int) {
+	cmd.Trace()
+	os.Exit(code)
+}
@@ -0,0 +1,169 @@
+package merge
+
+import (
+	"orf/diff"
+	"strings"
+
+	"github.com/go-ini/ini"
+)
+
+// Style selects how conflicts are written into merged files.
+type Style int
+
+const (
+	// StyleMerge writes our and their side of each conflict.
+	StyleMerge Style = iota
+	// StyleDiff3 also writes the common ancestor's lines between the two sides.
+	StyleDiff3
+)
+
+// Options controls merges: the conflict style, the labels written after the conflict
+// markers and the rename detection that pairs paths before a tree merge.
+type Options struct {
+	Style       Style
+	OursLabel   string
+	BaseLabel   string
+	TheirsLabel string
+	Renames     diff.Options
+}
+
+// DefaultOptions returns options for merge-style conflicts with generic labels.
+func DefaultOptions() Options {
+	return Options{Style: StyleMerge, OursLabel: "ours", BaseLabel: "base", TheirsLabel: "theirs", Renames: diff.DefaultOptions()}
+}
+
+// OptionsFromConfig reads merge.conflictStyle ("merge" or "diff3") and the [diff] rename
+// settings from a repository config. Copies are never detected for a merge.
+func OptionsFromConfig(config *ini.File) Options {
+	opts := DefaultOptions()
+	if config == nil {
+		return opts
+	}
+
+	opts.Renames = diff.OptionsFromConfig(config)
+	opts.Renames.Copies = false
+
+	if strings.ToLower(config.Section("merge").Key("conflictStyle").String()) == "diff3" {
+		opts.Style = StyleDiff3
+	}
+
+	return opts
+}
+
+// FileResult is the outcome of a content merge. Conflicts counts the conflicting regions,
+// which are written into Data between conflict markers.
+type FileResult struct {
+	Data      []byte
+	Conflicts int
+}
+
+// File merges the changes from base to ours and from base to theirs, line by line. Regions
+// changed on only one side take that side; regions changed identically on both sides are
+// taken once; anything else is a conflict.
+func File(base, ours, theirs []byte, opts Options) *FileResult {
+	baseLines := diff.SplitLines(base)
+	oursLines := diff.SplitLines(ours)
+	theirsLines := diff.SplitLines(theirs)
+
+	oursMatch := matches(baseLines, oursLines)
+	theirsMatch := matches(baseLines, theirsLines)
+
+	result := &FileResult{}
+	var output strings.Builder
+
+	b, o, t := 0, 0, 0
+	for b < len(baseLines) || o < len(oursLines) || t < len(theirsLines) {
+
+		// A line unchanged on both sides is copied as is
+		if b < len(baseLines) && oursMatch[b] == o && theirsMatch[b] == t {
+			output.WriteString(baseLines[b])
+			b, o, t = b+1, o+1, t+1
+			continue
+		}
+
+		// Otherwise the changed region runs to the next line unchanged on both sides
+		end := b
+		for end < len(baseLines) && (oursMatch[end] < o || theirsMatch[end] < t) {
+			end++
+		}
+
+		oursEnd, theirsEnd := len(oursLines), len(theirsLines)
+		if end < len(baseLines) {
+			oursEnd, theirsEnd = oursMatch[end], theirsMatch[end]
+		}
+
+		baseChunk := baseLines[b:end]
+		oursChunk := oursLines[o:oursEnd]
+		theirsChunk := theirsLines[t:theirsEnd]
+
+		switch {
+		case equalLines(oursChunk, baseChunk):
+			writeLines(&output, theirsChunk, false)
+		case equalLines(theirsChunk, baseChunk), equalLines(oursChunk, theirsChunk):
+			writeLines(&output, oursChunk, false)
+		default:
+			result.Conflicts++
+			writeMarker(&output, "<<<<<<<", opts.OursLabel)
+			writeLines(&output, oursChunk, true)
+			if opts.Style == StyleDiff3 {
+				writeMarker(&output, "|||||||", opts.BaseLabel)
+				writeLines(&output, baseChunk, true)
+			}
+			writeMarker(&output, "=======", "")
+			writeLines(&output, theirsChunk, true)
+			writeMarker(&output, ">>>>>>>", opts.TheirsLabel)
+		}
+
+		b, o, t = end, oursEnd, theirsEnd
+	}
+
+	result.Data = []byte(output.String())
+	return result
+}
+
+// matches maps each line of base to its index in other, or -1 if it was changed.
+func matches(base, other []string) []int {
+	match := make([]int, len(base))
+	for i := range match {
+		match[i] = -1
+	}
+
+	for _, edit := range diff.Lines(base, other) {
+		if edit.Op == diff.Equal {
+			match[edit.Old] = edit.New
+		}
+	}
+
+	return match
+}
+
+func equalLines(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// writeLines copies lines to the output. Inside a conflict, a final line without a newline
+// gets one so the following marker starts on its own line.
+func writeLines(output *strings.Builder, lines []string, inConflict bool) {
+	for _, line := range lines {
+		output.WriteString(line)
+	}
+	if inConflict && len(lines) > 0 && !strings.HasSuffix(lines[len(lines)-1], "\n") {
+		output.WriteString("\n")
+	}
+}
+
+func writeMarker(output *strings.Builder, marker string, label string) {
+	output.WriteString(marker)
+	if label != "" {
+		output.WriteString(" " + label)
+	}
+	output.WriteString("\n")
+}
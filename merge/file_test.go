@@ -0,0 +1,82 @@
+package merge
+
+import (
+	"testing"
+
+	"github.com/go-ini/ini"
+)
+
+func TestFileClean(t *testing.T) {
+	tests := []struct {
+		name               string
+		base, ours, theirs string
+		expected           string
+	}{
+		{"unchanged", "a\nb\nc\n", "a\nb\nc\n", "a\nb\nc\n", "a\nb\nc\n"},
+		{"ours only", "a\nb\nc\n", "a\nB\nc\n", "a\nb\nc\n", "a\nB\nc\n"},
+		{"theirs only", "a\nb\nc\n", "a\nb\nc\n", "a\nb\nC\n", "a\nb\nC\n"},
+		{"separate regions", "a\nb\nc\nd\ne\n", "A\nb\nc\nd\ne\n", "a\nb\nc\nd\nE\n", "A\nb\nc\nd\nE\n"},
+		{"same change", "a\nb\nc\n", "a\nX\nc\n", "a\nX\nc\n", "a\nX\nc\n"},
+		{"insertions", "a\nc\n", "a\nb\nc\n", "a\nc\nd\n", "a\nb\nc\nd\n"},
+		{"deletion", "a\nb\nc\n", "a\nc\n", "a\nb\nc\n", "a\nc\n"},
+		{"empty base", "", "a\n", "a\n", "a\n"},
+	}
+
+	for _, test := range tests {
+		result := File([]byte(test.base), []byte(test.ours), []byte(test.theirs), DefaultOptions())
+		if result.Conflicts != 0 {
+			t.Errorf("%s: expected no conflicts, got %d:\n%s", test.name, result.Conflicts, result.Data)
+		}
+		if string(result.Data) != test.expected {
+			t.Errorf("%s: merged %q; expected %q", test.name, result.Data, test.expected)
+		}
+	}
+}
+
+func TestFileConflict(t *testing.T) {
+	base := []byte("a\nb\nc\n")
+	ours := []byte("a\nours\nc\n")
+	theirs := []byte("a\ntheirs\nc\n")
+
+	opts := Options{Style: StyleMerge, OursLabel: "HEAD", BaseLabel: "base", TheirsLabel: "topic"}
+	result := File(base, ours, theirs, opts)
+
+	expected := "a\n<<<<<<< HEAD\nours\n=======\ntheirs\n>>>>>>> topic\nc\n"
+	if result.Conflicts != 1 {
+		t.Errorf("Expected 1 conflict, got %d", result.Conflicts)
+	}
+	if string(result.Data) != expected {
+		t.Errorf("Merged %q; expected %q", result.Data, expected)
+	}
+
+	opts.Style = StyleDiff3
+	result = File(base, ours, theirs, opts)
+
+	expected = "a\n<<<<<<< HEAD\nours\n||||||| base\nb\n=======\ntheirs\n>>>>>>> topic\nc\n"
+	if string(result.Data) != expected {
+		t.Errorf("Merged %q; expected %q", result.Data, expected)
+	}
+}
+
+func TestFileConflictWithoutNewline(t *testing.T) {
+	result := File([]byte("a"), []byte("b"), []byte("c"), Options{})
+
+	expected := "<<<<<<<\nb\n=======\nc\n>>>>>>>\n"
+	if string(result.Data) != expected {
+		t.Errorf("Merged %q; expected %q", result.Data, expected)
+	}
+}
+
+func TestOptionsFromConfig(t *testing.T) {
+	config, err := ini.Load([]byte("[merge]\n\tconflictStyle = diff3\n"))
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	if opts := OptionsFromConfig(config); opts.Style != StyleDiff3 {
+		t.Errorf("Expected diff3 style, got %v", opts.Style)
+	}
+	if opts := OptionsFromConfig(ini.Empty()); opts.Style != StyleMerge {
+		t.Errorf("Expected merge style, got %v", opts.Style)
+	}
+}
@@ -0,0 +1,440 @@
+package merge
+
+import (
+	"fmt"
+	"orf/diff"
+	"orf/mergebase"
+	"orf/object"
+	"orf/oid"
+	"orf/repository"
+	"path"
+	"sort"
+	"strings"
+)
+
+// ConflictType describes how the two sides of a merge disagree about a path.
+type ConflictType int
+
+const (
+	BothModified ConflictType = iota
+	BothAdded
+	DeletedByUs
+	DeletedByThem
+	AddedByUs
+	AddedByThem
+	BothDeleted
+)
+
+func (conflictType ConflictType) String() string {
+	switch conflictType {
+	case BothModified:
+		return "both modified"
+	case BothAdded:
+		return "added by both"
+	case DeletedByUs:
+		return "deleted by us"
+	case DeletedByThem:
+		return "deleted by them"
+	case AddedByUs:
+		return "added by us"
+	case AddedByThem:
+		return "added by them"
+	case BothDeleted:
+		return "both deleted"
+	default:
+		return "unknown"
+	}
+}
+
+// ConflictTypeOf classifies a conflict by which of its versions exist, e.g. from the
+// stages of an unmerged path in the index.
+func ConflictTypeOf(base, ours, theirs bool) ConflictType {
+	switch {
+	case ours && theirs && base:
+		return BothModified
+	case ours && theirs:
+		return BothAdded
+	case theirs && base:
+		return DeletedByUs
+	case ours && base:
+		return DeletedByThem
+	case ours:
+		return AddedByUs
+	case theirs:
+		return AddedByThem
+	default:
+		return BothDeleted
+	}
+}
+
+// Conflict is a path the merge could not resolve, with its version in the base and on each
+// side (nil where the path does not exist).
+type Conflict struct {
+	Path   string
+	Type   ConflictType
+	Base   *object.Leaf
+	Ours   *object.Leaf
+	Theirs *object.Leaf
+}
+
+// Result is the outcome of a tree merge. Leaves holds every path of the merged tree: clean
+// paths hold their merged version, conflicted content holds the file with conflict markers
+// and modify/delete conflicts hold the modified version. A file that one side has where the
+// other has a directory is moved aside to <path>~<label>. Tree is the id of that tree.
+type Result struct {
+	Tree      oid.ObjectID
+	Leaves    map[string]*object.Leaf
+	Conflicts []Conflict
+}
+
+// Trees merges the changes from base to ours and from base to theirs. Empty tree ids stand
+// for empty trees. Paths renamed on one side are merged with the other side's version of
+// the original path.
+func Trees(repo *repository.Repo, base, ours, theirs oid.ObjectID, opts Options) (*Result, error) {
+	baseLeaves, err := object.ReadTreeLeaves(repo, base)
+	if err != nil {
+		return nil, err
+	}
+	oursLeaves, err := object.ReadTreeLeaves(repo, ours)
+	if err != nil {
+		return nil, err
+	}
+	theirsLeaves, err := object.ReadTreeLeaves(repo, theirs)
+	if err != nil {
+		return nil, err
+	}
+
+	oursRenames, err := renames(repo, baseLeaves, oursLeaves, opts.Renames)
+	if err != nil {
+		return nil, err
+	}
+	theirsRenames, err := renames(repo, baseLeaves, theirsLeaves, opts.Renames)
+	if err != nil {
+		return nil, err
+	}
+	followRenames(baseLeaves, theirsLeaves, oursRenames, theirsRenames)
+	followRenames(baseLeaves, oursLeaves, theirsRenames, oursRenames)
+
+	paths := make(map[string]bool)
+	for _, leaves := range []map[string]*object.Leaf{baseLeaves, oursLeaves, theirsLeaves} {
+		for path := range leaves {
+			paths[path] = true
+		}
+	}
+
+	sorted := make([]string, 0, len(paths))
+	for path := range paths {
+		sorted = append(sorted, path)
+	}
+	sort.Strings(sorted)
+
+	result := &Result{Leaves: make(map[string]*object.Leaf)}
+
+	for _, path := range sorted {
+		b, o, t := baseLeaves[path], oursLeaves[path], theirsLeaves[path]
+
+		switch {
+		case sameLeaf(o, t):
+			result.keep(path, o)
+		case sameLeaf(b, o):
+			result.keep(path, t)
+		case sameLeaf(b, t):
+			result.keep(path, o)
+
+		case o == nil:
+			result.keep(path, t)
+			result.Conflicts = append(result.Conflicts, Conflict{Path: path, Type: DeletedByUs, Base: b, Theirs: t})
+		case t == nil:
+			result.keep(path, o)
+			result.Conflicts = append(result.Conflicts, Conflict{Path: path, Type: DeletedByThem, Base: b, Ours: o})
+
+		default:
+			leaf, conflicted, err := mergeContents(repo, path, b, o, t, opts)
+			if err != nil {
+				return nil, err
+			}
+			result.keep(path, leaf)
+
+			if conflicted {
+				conflictType := BothModified
+				if b == nil {
+					conflictType = BothAdded
+				}
+				result.Conflicts = append(result.Conflicts, Conflict{Path: path, Type: conflictType, Base: b, Ours: o, Theirs: t})
+			}
+		}
+	}
+	result.moveFilesAside(baseLeaves, oursLeaves, theirsLeaves, opts)
+
+	result.Tree, err = object.WriteTreeLeaves(repo, result.Leaves)
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// Commits merges theirs into ours. If the commits have several best common ancestors, those
+// are first merged into a virtual ancestor (recursively), which serves as the merge base.
+func Commits(repo *repository.Repo, ours, theirs oid.ObjectID, opts Options) (*Result, error) {
+	bases, err := mergebase.All(repo, ours, theirs)
+	if err != nil {
+		return nil, err
+	}
+
+	baseTree, err := virtualBase(repo, bases, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	oursTree, err := commitTree(repo, ours)
+	if err != nil {
+		return nil, err
+	}
+	theirsTree, err := commitTree(repo, theirs)
+	if err != nil {
+		return nil, err
+	}
+
+	return Trees(repo, baseTree, oursTree, theirsTree, opts)
+}
+
+// virtualBase merges a list of merge bases into one tree. Conflicts in these inner merges
+// are kept, markers and all, in the virtual tree.
+func virtualBase(repo *repository.Repo, bases []oid.ObjectID, opts Options) (oid.ObjectID, error) {
+	if len(bases) == 0 {
+		return "", nil
+	}
+
+	tree, err := commitTree(repo, bases[0])
+	if err != nil {
+		return "", err
+	}
+
+	inner := opts
+	inner.OursLabel = "Temporary merge branch 1"
+	inner.TheirsLabel = "Temporary merge branch 2"
+
+	merged := bases[:1]
+	for _, next := range bases[1:] {
+		// The bases of the virtual commit so far (a merge of the merged commits) and the next one
+		innerBases, err := mergebase.All(repo, next, merged...)
+		if err != nil {
+			return "", err
+		}
+
+		innerBase, err := virtualBase(repo, innerBases, inner)
+		if err != nil {
+			return "", err
+		}
+
+		nextTree, err := commitTree(repo, next)
+		if err != nil {
+			return "", err
+		}
+
+		result, err := Trees(repo, innerBase, tree, nextTree, inner)
+		if err != nil {
+			return "", err
+		}
+
+		tree = result.Tree
+		merged = append(merged, next)
+	}
+
+	return tree, nil
+}
+
+// mergeContents merges the three versions of a file, writing the merged blob. It reports
+// whether the merge left conflict markers.
+func mergeContents(repo *repository.Repo, path string, base, ours, theirs *object.Leaf, opts Options) (*object.Leaf, bool, error) {
+	var baseData []byte
+	if base != nil {
+		data, err := readBlob(repo, base.Hash)
+		if err != nil {
+			return nil, false, err
+		}
+		baseData = data
+	}
+
+	oursData, err := readBlob(repo, ours.Hash)
+	if err != nil {
+		return nil, false, err
+	}
+	theirsData, err := readBlob(repo, theirs.Hash)
+	if err != nil {
+		return nil, false, err
+	}
+
+	labels := opts
+	labels.OursLabel = label(opts.OursLabel, path)
+	labels.BaseLabel = label(opts.BaseLabel, path)
+	labels.TheirsLabel = label(opts.TheirsLabel, path)
+
+	merged := File(baseData, oursData, theirsData, labels)
+
+	hash, err := object.WriteObject(repo.Directory, object.CreateBlob(merged.Data))
+	if err != nil {
+		return nil, false, err
+	}
+
+	// Take the mode change from whichever side made one
+	mode := ours.Mode
+	if base != nil && string(ours.Mode) == string(base.Mode) {
+		mode = theirs.Mode
+	}
+
+	return &object.Leaf{Mode: mode, Path: path, Hash: hash}, merged.Conflicts > 0, nil
+}
+
+// renames returns the paths of base renamed on a side, mapped to their new paths.
+func renames(repo *repository.Repo, base, side map[string]*object.Leaf, opts diff.Options) (map[string]string, error) {
+	changes := diff.Compare(leafHashes(base), leafHashes(side))
+
+	changes, err := diff.DetectRenames(changes, leafHashes(base), opts, func(hash oid.ObjectID) ([]byte, error) {
+		return readBlob(repo, hash)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	renamed := make(map[string]string)
+	for _, change := range changes {
+		if change.Type == diff.Renamed {
+			renamed[change.OldPath] = change.NewPath
+		}
+	}
+	return renamed, nil
+}
+
+// followRenames moves the base and other side's versions of each path renamed on one side
+// to its new path, so the path-by-path merge combines the rename with the other side's
+// changes. Renames to a path the other side also uses, or that the other side renamed
+// elsewhere, are left to be merged as an add and a delete.
+func followRenames(base, other map[string]*object.Leaf, renamed, otherRenamed map[string]string) {
+	for oldPath, newPath := range renamed {
+		if otherPath, ok := otherRenamed[oldPath]; ok {
+			// Both sides renamed the path to the same place: merge the contents there
+			if otherPath == newPath && base[newPath] == nil {
+				moveLeaf(base, oldPath, newPath)
+			}
+			continue
+		}
+
+		leaf := other[oldPath]
+		if leaf == nil || other[newPath] != nil || base[newPath] != nil {
+			continue
+		}
+
+		moveLeaf(other, oldPath, newPath)
+		moveLeaf(base, oldPath, newPath)
+	}
+}
+
+func moveLeaf(leaves map[string]*object.Leaf, oldPath, newPath string) {
+	leaf := leaves[oldPath]
+	delete(leaves, oldPath)
+	leaves[newPath] = &object.Leaf{Mode: leaf.Mode, Path: newPath, Hash: leaf.Hash}
+}
+
+func leafHashes(leaves map[string]*object.Leaf) map[string]oid.ObjectID {
+	hashes := make(map[string]oid.ObjectID, len(leaves))
+	for path, leaf := range leaves {
+		hashes[path] = leaf.Hash
+	}
+	return hashes
+}
+
+// moveFilesAside resolves file/directory collisions, where one side has a file at a path the
+// other side has a directory at: the file is moved to <path>~<label of its side>, so the tree
+// stays valid and both can be checked out, and the path is recorded as a conflict.
+func (result *Result) moveFilesAside(base, ours, theirs map[string]*object.Leaf, opts Options) {
+	collisions := make(map[string]bool)
+	for leafPath := range result.Leaves {
+		for dir := path.Dir(leafPath); dir != "."; dir = path.Dir(dir) {
+			if _, ok := result.Leaves[dir]; ok {
+				collisions[dir] = true
+			}
+		}
+	}
+	if len(collisions) == 0 {
+		return
+	}
+
+	conflicted := make(map[string]bool)
+	for _, conflict := range result.Conflicts {
+		conflicted[conflict.Path] = true
+	}
+
+	sorted := make([]string, 0, len(collisions))
+	for file := range collisions {
+		sorted = append(sorted, file)
+	}
+	sort.Strings(sorted)
+
+	for _, file := range sorted {
+		side := opts.TheirsLabel
+		if ours[file] != nil {
+			side = opts.OursLabel
+		}
+
+		// Branch names may hold slashes, which would put the file in a directory
+		aside := file + "~" + strings.ReplaceAll(side, "/", "_")
+		for i := 0; result.Leaves[aside] != nil; i++ {
+			aside = fmt.Sprintf("%s~%s_%d", file, strings.ReplaceAll(side, "/", "_"), i)
+		}
+
+		leaf := result.Leaves[file]
+		delete(result.Leaves, file)
+		result.Leaves[aside] = &object.Leaf{Mode: leaf.Mode, Path: aside, Hash: leaf.Hash}
+
+		if !conflicted[file] {
+			b, o, t := base[file], ours[file], theirs[file]
+			result.Conflicts = append(result.Conflicts, Conflict{Path: file, Type: ConflictTypeOf(b != nil, o != nil, t != nil), Base: b, Ours: o, Theirs: t})
+		}
+	}
+
+	sort.Slice(result.Conflicts, func(i, j int) bool {
+		return result.Conflicts[i].Path < result.Conflicts[j].Path
+	})
+}
+
+func (result *Result) keep(path string, leaf *object.Leaf) {
+	if leaf != nil {
+		result.Leaves[path] = leaf
+	}
+}
+
+func sameLeaf(a, b *object.Leaf) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Hash == b.Hash && string(a.Mode) == string(b.Mode)
+}
+
+// label appends the path to a conflict marker label, e.g. "HEAD:file.txt".
+func label(name string, path string) string {
+	if name == "" {
+		return path
+	}
+	return name + ":" + path
+}
+
+func commitTree(repo *repository.Repo, hash oid.ObjectID) (oid.ObjectID, error) {
+	commit, err := object.ReadCommit(repo, hash)
+	if err != nil {
+		return "", err
+	}
+	return commit.TreeHash(), nil
+}
+
+func readBlob(repo *repository.Repo, hash oid.ObjectID) ([]byte, error) {
+	obj, err := object.ReadObject(repo.Directory, hash)
+	if err != nil {
+		return nil, err
+	}
+	if obj.GetFormat() != "blob" {
+		return nil, fmt.Errorf("object %s is a %s, not a blob", hash, obj.GetFormat())
+	}
+	return obj.GetData(), nil
+}
@@ -0,0 +1,294 @@
+package merge
+
+import (
+	"fmt"
+	"orf/kv"
+	"orf/object"
+	"orf/oid"
+	"orf/repository"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func createTestRepo(t *testing.T) *repository.Repo {
+	workTree := t.TempDir()
+	directory := filepath.Join(workTree, ".orf")
+
+	for _, dir := range []string{"objects", filepath.Join("refs", "heads"), filepath.Join("refs", "tags")} {
+		if err := os.MkdirAll(filepath.Join(directory, dir), os.ModePerm); err != nil {
+			t.Fatalf("Failed to create directories: %v", err)
+		}
+	}
+
+	return &repository.Repo{WorkTree: workTree, Directory: directory}
+}
+
+// writeTestCommit writes a commit whose tree maps each path to a blob with the given contents.
+func writeTestCommit(t *testing.T, repo *repository.Repo, files map[string]string, parents []oid.ObjectID, when int64) oid.ObjectID {
+	leaves := make(map[string]*object.Leaf)
+	for path, contents := range files {
+		blob, err := object.WriteObject(repo.Directory, object.CreateBlob([]byte(contents)))
+		if err != nil {
+			t.Fatalf("Failed to write blob: %v", err)
+		}
+		leaves[path] = &object.Leaf{Mode: []byte("100644"), Path: path, Hash: blob}
+	}
+
+	treeHash, err := object.WriteTreeLeaves(repo, leaves)
+	if err != nil {
+		t.Fatalf("Failed to write tree: %v", err)
+	}
+
+	kvData := kv.CreateOrderedMap()
+	kvData.Add("tree", treeHash.String())
+	if len(parents) == 1 {
+		kvData.Add("parent", parents[0].String())
+	} else if len(parents) > 1 {
+		var values []string
+		for _, parent := range parents {
+			values = append(values, parent.String())
+		}
+		kvData.Add("parent", values)
+	}
+	kvData.Add("author", fmt.Sprintf("Test <test@example.com> %d +0000", when))
+	kvData.Add("committer", fmt.Sprintf("Test <test@example.com> %d +0000", when))
+	kvData.Add("message", []byte("commit\n"))
+
+	hash, err := object.WriteObject(repo.Directory, object.CreateCommit(kv.Serialize(kvData)))
+	if err != nil {
+		t.Fatalf("Failed to write commit: %v", err)
+	}
+	return hash
+}
+
+func readLeaf(t *testing.T, repo *repository.Repo, result *Result, path string) string {
+	leaf, ok := result.Leaves[path]
+	if !ok {
+		t.Fatalf("Merged tree has no %s", path)
+	}
+
+	data, err := readBlob(repo, leaf.Hash)
+	if err != nil {
+		t.Fatalf("Failed to read %s: %v", path, err)
+	}
+	return string(data)
+}
+
+func TestCommitsClean(t *testing.T) {
+	repo := createTestRepo(t)
+
+	base := writeTestCommit(t, repo, map[string]string{"a.txt": "1\n2\n3\n", "dir/b.txt": "b\n", "gone.txt": "x\n"}, nil, 1000)
+	ours := writeTestCommit(t, repo, map[string]string{"a.txt": "one\n2\n3\n", "dir/b.txt": "b\n", "gone.txt": "x\n", "new.txt": "new\n"}, []oid.ObjectID{base}, 2000)
+	theirs := writeTestCommit(t, repo, map[string]string{"a.txt": "1\n2\nthree\n", "dir/b.txt": "B\n"}, []oid.ObjectID{base}, 2100)
+
+	result, err := Commits(repo, ours, theirs, DefaultOptions())
+	if err != nil {
+		t.Fatalf("Merge failed: %v", err)
+	}
+
+	if len(result.Conflicts) != 0 {
+		t.Errorf("Expected no conflicts, got %v", result.Conflicts)
+	}
+	if data := readLeaf(t, repo, result, "a.txt"); data != "one\n2\nthree\n" {
+		t.Errorf("Merged a.txt = %q", data)
+	}
+	if data := readLeaf(t, repo, result, "dir/b.txt"); data != "B\n" {
+		t.Errorf("Merged dir/b.txt = %q", data)
+	}
+	if data := readLeaf(t, repo, result, "new.txt"); data != "new\n" {
+		t.Errorf("Merged new.txt = %q", data)
+	}
+	if _, ok := result.Leaves["gone.txt"]; ok {
+		t.Errorf("Expected gone.txt to be deleted")
+	}
+
+	// The merged tree holds exactly the merged leaves
+	leaves, err := object.ReadTreeLeaves(repo, result.Tree)
+	if err != nil {
+		t.Fatalf("Failed to read merged tree: %v", err)
+	}
+	if len(leaves) != len(result.Leaves) {
+		t.Errorf("Merged tree has %d leaves; expected %d", len(leaves), len(result.Leaves))
+	}
+}
+
+func TestCommitsConflicts(t *testing.T) {
+	repo := createTestRepo(t)
+
+	base := writeTestCommit(t, repo, map[string]string{"both.txt": "base\n", "modified.txt": "m\n", "deleted.txt": "d\n"}, nil, 1000)
+	ours := writeTestCommit(t, repo, map[string]string{"both.txt": "ours\n", "modified.txt": "M\n", "added.txt": "ours\n"}, []oid.ObjectID{base}, 2000)
+	theirs := writeTestCommit(t, repo, map[string]string{"both.txt": "theirs\n", "deleted.txt": "D\n", "added.txt": "theirs\n"}, []oid.ObjectID{base}, 2100)
+
+	opts := Options{Style: StyleMerge, OursLabel: "HEAD", TheirsLabel: "topic"}
+	result, err := Commits(repo, ours, theirs, opts)
+	if err != nil {
+		t.Fatalf("Merge failed: %v", err)
+	}
+
+	expected := map[string]ConflictType{
+		"added.txt":    BothAdded,
+		"both.txt":     BothModified,
+		"deleted.txt":  DeletedByUs,
+		"modified.txt": DeletedByThem,
+	}
+	if len(result.Conflicts) != len(expected) {
+		t.Fatalf("Expected %d conflicts, got %v", len(expected), result.Conflicts)
+	}
+	for _, conflict := range result.Conflicts {
+		if conflict.Type != expected[conflict.Path] {
+			t.Errorf("%s: conflict %v; expected %v", conflict.Path, conflict.Type, expected[conflict.Path])
+		}
+	}
+
+	if data := readLeaf(t, repo, result, "both.txt"); data != "<<<<<<< HEAD:both.txt\nours\n=======\ntheirs\n>>>>>>> topic:both.txt\n" {
+		t.Errorf("Merged both.txt = %q", data)
+	}
+	if data := readLeaf(t, repo, result, "deleted.txt"); data != "D\n" {
+		t.Errorf("Merged deleted.txt = %q", data)
+	}
+	if data := readLeaf(t, repo, result, "modified.txt"); data != "M\n" {
+		t.Errorf("Merged modified.txt = %q", data)
+	}
+}
+
+func TestCommitsCrissCross(t *testing.T) {
+	repo := createTestRepo(t)
+
+	// Two merge bases b and c, which changed different lines of the file
+	a := writeTestCommit(t, repo, map[string]string{"f.txt": "1\n2\n3\n4\n5\n"}, nil, 1000)
+	b := writeTestCommit(t, repo, map[string]string{"f.txt": "B\n2\n3\n4\n5\n"}, []oid.ObjectID{a}, 2000)
+	c := writeTestCommit(t, repo, map[string]string{"f.txt": "1\n2\n3\n4\nC\n"}, []oid.ObjectID{a}, 2100)
+	m1 := writeTestCommit(t, repo, map[string]string{"f.txt": "B\n2\n3\n4\nC\n"}, []oid.ObjectID{b, c}, 3000)
+	m2 := writeTestCommit(t, repo, map[string]string{"f.txt": "B\n2\n3\n4\nC\n"}, []oid.ObjectID{c, b}, 3100)
+	x := writeTestCommit(t, repo, map[string]string{"f.txt": "B\nX\n3\n4\nC\n"}, []oid.ObjectID{m1}, 4000)
+	y := writeTestCommit(t, repo, map[string]string{"f.txt": "B\n2\n3\nY\nC\n"}, []oid.ObjectID{m2}, 4100)
+
+	// Merging against either base alone conflicts; the virtual base merges cleanly
+	result, err := Commits(repo, x, y, DefaultOptions())
+	if err != nil {
+		t.Fatalf("Merge failed: %v", err)
+	}
+
+	if len(result.Conflicts) != 0 {
+		t.Errorf("Expected no conflicts, got %v", result.Conflicts)
+	}
+	if data := readLeaf(t, repo, result, "f.txt"); data != "B\nX\n3\nY\nC\n" {
+		t.Errorf("Merged f.txt = %q", data)
+	}
+}
+
+func TestTreesRenames(t *testing.T) {
+	repo := createTestRepo(t)
+
+	lines := "1\n2\n3\n4\n5\n6\n7\n8\n"
+	base := writeTestCommit(t, repo, map[string]string{"old.txt": lines, "moved.txt": lines}, nil, 1000)
+	// Ours renames old.txt with an edit, and moved.txt as is; theirs edits both in place
+	ours := writeTestCommit(t, repo, map[string]string{"new.txt": "one\n2\n3\n4\n5\n6\n7\n8\n", "dir/moved.txt": lines}, []oid.ObjectID{base}, 2000)
+	theirs := writeTestCommit(t, repo, map[string]string{"old.txt": "1\n2\n3\n4\n5\n6\n7\neight\n", "moved.txt": "1\n2\nthree\n4\n5\n6\n7\n8\n"}, []oid.ObjectID{base}, 2100)
+
+	for _, merge := range []struct {
+		name         string
+		ours, theirs oid.ObjectID
+	}{
+		{"renamed by ours", ours, theirs},
+		{"renamed by theirs", theirs, ours},
+	} {
+		result, err := Commits(repo, merge.ours, merge.theirs, DefaultOptions())
+		if err != nil {
+			t.Fatalf("%s: Merge failed: %v", merge.name, err)
+		}
+
+		if len(result.Conflicts) != 0 {
+			t.Errorf("%s: Expected no conflicts, got %v", merge.name, result.Conflicts)
+		}
+		if len(result.Leaves) != 2 {
+			t.Errorf("%s: Expected only the renamed paths, got %v", merge.name, result.Leaves)
+		}
+		if data := readLeaf(t, repo, result, "new.txt"); data != "one\n2\n3\n4\n5\n6\n7\neight\n" {
+			t.Errorf("%s: Merged new.txt = %q", merge.name, data)
+		}
+		if data := readLeaf(t, repo, result, "dir/moved.txt"); data != "1\n2\nthree\n4\n5\n6\n7\n8\n" {
+			t.Errorf("%s: Merged dir/moved.txt = %q", merge.name, data)
+		}
+	}
+
+	// Without rename detection the edit to the deleted path conflicts
+	opts := DefaultOptions()
+	opts.Renames.Renames = false
+	result, err := Commits(repo, ours, theirs, opts)
+	if err != nil {
+		t.Fatalf("Merge failed: %v", err)
+	}
+	if len(result.Conflicts) != 2 {
+		t.Errorf("Expected two modify/delete conflicts, got %v", result.Conflicts)
+	}
+}
+
+func TestTreesFileDirectory(t *testing.T) {
+	repo := createTestRepo(t)
+
+	base := writeTestCommit(t, repo, map[string]string{"README": "readme\n"}, nil, 1000)
+	ours := writeTestCommit(t, repo, map[string]string{"README": "readme\n", "a": "file\n"}, []oid.ObjectID{base}, 2000)
+	theirs := writeTestCommit(t, repo, map[string]string{"README": "readme\n", "a/x.txt": "nested\n"}, []oid.ObjectID{base}, 2100)
+
+	opts := DefaultOptions()
+	opts.OursLabel, opts.TheirsLabel = "HEAD", "feature/dir"
+	for _, merge := range []struct {
+		name         string
+		ours, theirs oid.ObjectID
+		aside        string
+		conflictType ConflictType
+	}{
+		{"file in ours", ours, theirs, "a~HEAD", AddedByUs},
+		{"file in theirs", theirs, ours, "a~feature_dir", AddedByThem},
+	} {
+		result, err := Commits(repo, merge.ours, merge.theirs, opts)
+		if err != nil {
+			t.Fatalf("%s: Merge failed: %v", merge.name, err)
+		}
+
+		if len(result.Conflicts) != 1 || result.Conflicts[0].Path != "a" || result.Conflicts[0].Type != merge.conflictType {
+			t.Fatalf("%s: Expected a conflict on a, got %+v", merge.name, result.Conflicts)
+		}
+		if _, ok := result.Leaves["a"]; ok {
+			t.Errorf("%s: Expected the file to be moved out of the way of the directory", merge.name)
+		}
+		if data := readLeaf(t, repo, result, merge.aside); data != "file\n" {
+			t.Errorf("%s: Expected the file at %s, got %q", merge.name, merge.aside, data)
+		}
+		if data := readLeaf(t, repo, result, "a/x.txt"); data != "nested\n" {
+			t.Errorf("%s: Expected a/x.txt = %q, got %q", merge.name, "nested\n", data)
+		}
+
+		// The merged tree holds the moved file and the directory
+		leaves, err := object.ReadTreeLeaves(repo, result.Tree)
+		if err != nil {
+			t.Fatalf("%s: ReadTreeLeaves failed: %v", merge.name, err)
+		}
+		if len(leaves) != 3 || leaves[merge.aside] == nil || leaves["a/x.txt"] == nil {
+			t.Errorf("%s: Expected README, %s and a/x.txt in the tree, got %v", merge.name, merge.aside, leaves)
+		}
+	}
+}
+
+func TestConflictTypeOf(t *testing.T) {
+	tests := []struct {
+		base, ours, theirs bool
+		expected           ConflictType
+	}{
+		{true, true, true, BothModified},
+		{false, true, true, BothAdded},
+		{true, false, true, DeletedByUs},
+		{true, true, false, DeletedByThem},
+		{false, true, false, AddedByUs},
+		{false, false, true, AddedByThem},
+		{true, false, false, BothDeleted},
+	}
+
+	for _, test := range tests {
+		if conflictType := ConflictTypeOf(test.base, test.ours, test.theirs); conflictType != test.expected {
+			t.Errorf("ConflictTypeOf(%t, %t, %t) = %v; expected %v", test.base, test.ours, test.theirs, conflictType, test.expected)
+		}
+	}
+}
@@ -0,0 +1,217 @@
+package mergebase
+
+import (
+	"fmt"
+	"orf/commitgraph"
+	"orf/object"
+	"orf/oid"
+	"orf/repository"
+	"sort"
+)
+
+// All returns the best common ancestors of one and a hypothetical merge of others, like
+// `git merge-base --all`. A common ancestor is best if no other common ancestor descends
+// from it. The bases are ordered youngest first.
+func All(repo *repository.Repo, one oid.ObjectID, others ...oid.ObjectID) ([]oid.ObjectID, error) {
+	if len(others) == 0 {
+		return nil, fmt.Errorf("merge-base needs at least two commits")
+	}
+
+	reader := commitgraph.NewReader(repo)
+	reachable, err := ancestors(reader, one)
+	if err != nil {
+		return nil, err
+	}
+
+	fromOthers, err := ancestors(reader, others...)
+	if err != nil {
+		return nil, err
+	}
+
+	common := make(map[oid.ObjectID]*commitgraph.Commit)
+	for hash, commit := range reachable {
+		if _, ok := fromOthers[hash]; ok {
+			common[hash] = commit
+		}
+	}
+
+	return best(reader, common)
+}
+
+// Octopus returns the best common ancestors of all the given commits, like
+// `git merge-base --octopus`.
+func Octopus(repo *repository.Repo, commits ...oid.ObjectID) ([]oid.ObjectID, error) {
+	if len(commits) == 0 {
+		return nil, fmt.Errorf("merge-base needs at least one commit")
+	}
+
+	reader := commitgraph.NewReader(repo)
+	common, err := ancestors(reader, commits[0])
+	if err != nil {
+		return nil, err
+	}
+
+	for _, commit := range commits[1:] {
+		reachable, err := ancestors(reader, commit)
+		if err != nil {
+			return nil, err
+		}
+		for hash := range common {
+			if _, ok := reachable[hash]; !ok {
+				delete(common, hash)
+			}
+		}
+	}
+
+	return best(reader, common)
+}
+
+// IsAncestor reports whether ancestor is reachable from descendant (a commit is its own ancestor).
+// With a commit-graph, the walk skips the commits whose generation shows they are older than
+// ancestor.
+func IsAncestor(repo *repository.Repo, ancestor, descendant oid.ObjectID) (bool, error) {
+	if ancestor == descendant {
+		return true, nil
+	}
+
+	reader := commitgraph.NewReader(repo)
+	target, err := reader.Commit(ancestor)
+	if err != nil {
+		return false, err
+	}
+
+	stack := []oid.ObjectID{descendant}
+	seen := make(map[oid.ObjectID]bool)
+
+	for len(stack) > 0 {
+		current := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		if seen[current] {
+			continue
+		}
+		seen[current] = true
+
+		commit, err := reader.Commit(current)
+		if err != nil {
+			return false, err
+		}
+		if commit.Generation < target.Generation {
+			continue
+		}
+
+		for _, parent := range commit.Parents {
+			if parent == ancestor {
+				return true, nil
+			}
+			stack = append(stack, parent)
+		}
+	}
+
+	return false, nil
+}
+
+// ForkPoint finds where commit forked from ref, taking into account every value ref has had
+// according to its reflog, like `git merge-base --fork-point`. This finds the right base even
+// if ref was since rewound or rebased. It returns an error wrapping object.ErrNotFound if
+// none of ref's past values is a merge base of commit.
+func ForkPoint(repo *repository.Repo, ref string, commit oid.ObjectID) (oid.ObjectID, error) {
+	entries, err := object.ReadReflog(repo, ref)
+	if err != nil {
+		return "", err
+	}
+
+	// Candidates are the ref's current value and its reflog values, newest first
+	var candidates []oid.ObjectID
+	if current, err := object.FindObject(repo, ref, "commit", true); err == nil {
+		candidates = append(candidates, current)
+	}
+	for i := len(entries) - 1; i >= 0; i-- {
+		if !entries[i].New.IsZero() && !containsID(candidates, entries[i].New) {
+			candidates = append(candidates, entries[i].New)
+		}
+	}
+
+	if len(candidates) == 0 {
+		return "", fmt.Errorf("%w: no history for %s", object.ErrNotFound, ref)
+	}
+
+	bases, err := All(repo, commit, candidates...)
+	if err != nil {
+		return "", err
+	}
+
+	for _, candidate := range candidates {
+		if containsID(bases, candidate) {
+			return candidate, nil
+		}
+	}
+
+	return "", fmt.Errorf("%w: no fork point of %s from %s", object.ErrNotFound, commit, ref)
+}
+
+// ancestors returns every commit reachable from the given commits, including themselves.
+func ancestors(reader *commitgraph.Reader, starts ...oid.ObjectID) (map[oid.ObjectID]*commitgraph.Commit, error) {
+	reachable := make(map[oid.ObjectID]*commitgraph.Commit)
+	stack := append([]oid.ObjectID{}, starts...)
+
+	for len(stack) > 0 {
+		current := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		if _, ok := reachable[current]; ok {
+			continue
+		}
+
+		commit, err := reader.Commit(current)
+		if err != nil {
+			return nil, err
+		}
+
+		reachable[current] = commit
+		stack = append(stack, commit.Parents...)
+	}
+
+	return reachable, nil
+}
+
+// best removes every common ancestor that is an ancestor of another one. Since the set of
+// common ancestors is closed under ancestry, these are exactly the commits reachable from the
+// parents of the set.
+func best(reader *commitgraph.Reader, common map[oid.ObjectID]*commitgraph.Commit) ([]oid.ObjectID, error) {
+	var parents []oid.ObjectID
+	for _, commit := range common {
+		parents = append(parents, commit.Parents...)
+	}
+
+	redundant, err := ancestors(reader, parents...)
+	if err != nil {
+		return nil, err
+	}
+
+	var bases []oid.ObjectID
+	for hash := range common {
+		if _, ok := redundant[hash]; !ok {
+			bases = append(bases, hash)
+		}
+	}
+
+	sort.Slice(bases, func(i, j int) bool {
+		whenI, whenJ := common[bases[i]].When, common[bases[j]].When
+		if !whenI.Equal(whenJ) {
+			return whenI.After(whenJ)
+		}
+		return bases[i] < bases[j]
+	})
+
+	return bases, nil
+}
+
+func containsID(ids []oid.ObjectID, id oid.ObjectID) bool {
+	for _, candidate := range ids {
+		if candidate == id {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,231 @@
+package mergebase
+
+import (
+	"errors"
+	"fmt"
+	"orf/commitgraph"
+	"orf/kv"
+	"orf/object"
+	"orf/oid"
+	"orf/repository"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func createTestRepo(t *testing.T) *repository.Repo {
+	workTree := t.TempDir()
+	directory := filepath.Join(workTree, ".orf")
+
+	for _, dir := range []string{"objects", filepath.Join("refs", "heads")} {
+		if err := os.MkdirAll(filepath.Join(directory, dir), os.ModePerm); err != nil {
+			t.Fatalf("Failed to create directories: %v", err)
+		}
+	}
+
+	return &repository.Repo{WorkTree: workTree, Directory: directory}
+}
+
+func writeTestCommit(t *testing.T, repo *repository.Repo, parents []oid.ObjectID, when int64) oid.ObjectID {
+	kvData := kv.CreateOrderedMap()
+	kvData.Add("tree", oid.Default.Zero().String())
+	if len(parents) == 1 {
+		kvData.Add("parent", parents[0].String())
+	} else if len(parents) > 1 {
+		var values []string
+		for _, parent := range parents {
+			values = append(values, parent.String())
+		}
+		kvData.Add("parent", values)
+	}
+	kvData.Add("author", fmt.Sprintf("Test <test@example.com> %d +0000", when))
+	kvData.Add("committer", fmt.Sprintf("Test <test@example.com> %d +0000", when))
+	kvData.Add("message", []byte(fmt.Sprintf("Commit at %d", when)))
+
+	hash, err := object.WriteObject(repo.Directory, object.CreateCommit(kv.Serialize(kvData)))
+	if err != nil {
+		t.Fatalf("Failed to write commit: %v", err)
+	}
+	return hash
+}
+
+// crissCross builds a history with two best merge bases:
+//
+//	a <- b <- m1 <- x
+//	  <- c <- m2 <- y
+//
+// where m1 merges b and c, and m2 merges c and b.
+type crissCross struct {
+	repo                  *repository.Repo
+	a, b, c, m1, m2, x, y oid.ObjectID
+}
+
+func createCrissCross(t *testing.T) *crissCross {
+	repo := createTestRepo(t)
+
+	h := &crissCross{repo: repo}
+	h.a = writeTestCommit(t, repo, nil, 1000)
+	h.b = writeTestCommit(t, repo, []oid.ObjectID{h.a}, 2000)
+	h.c = writeTestCommit(t, repo, []oid.ObjectID{h.a}, 2100)
+	h.m1 = writeTestCommit(t, repo, []oid.ObjectID{h.b, h.c}, 3000)
+	h.m2 = writeTestCommit(t, repo, []oid.ObjectID{h.c, h.b}, 3100)
+	h.x = writeTestCommit(t, repo, []oid.ObjectID{h.m1}, 4000)
+	h.y = writeTestCommit(t, repo, []oid.ObjectID{h.m2}, 4100)
+
+	return h
+}
+
+func assertBases(t *testing.T, name string, actual []oid.ObjectID, err error, expected ...oid.ObjectID) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("%s failed: %v", name, err)
+	}
+	if len(actual) != len(expected) {
+		t.Errorf("%s: expected %v, got %v", name, expected, actual)
+		return
+	}
+	for i := range expected {
+		if actual[i] != expected[i] {
+			t.Errorf("%s: expected %v, got %v", name, expected, actual)
+			return
+		}
+	}
+}
+
+func TestAll(t *testing.T) {
+	h := createCrissCross(t)
+
+	bases, err := All(h.repo, h.x, h.y)
+	assertBases(t, "x y", bases, err, h.c, h.b)
+
+	bases, err = All(h.repo, h.b, h.c)
+	assertBases(t, "b c", bases, err, h.a)
+
+	bases, err = All(h.repo, h.x, h.b)
+	assertBases(t, "x b", bases, err, h.b)
+
+	// c against a merge of x and y
+	bases, err = All(h.repo, h.c, h.x, h.y)
+	assertBases(t, "c x y", bases, err, h.c)
+
+	if _, err := All(h.repo, h.x); err == nil {
+		t.Errorf("Expected an error for a single commit")
+	}
+}
+
+func TestOctopus(t *testing.T) {
+	h := createCrissCross(t)
+
+	bases, err := Octopus(h.repo, h.x, h.y, h.b)
+	assertBases(t, "x y b", bases, err, h.b)
+
+	bases, err = Octopus(h.repo, h.x, h.y)
+	assertBases(t, "x y", bases, err, h.c, h.b)
+
+	bases, err = Octopus(h.repo, h.b, h.c, h.x)
+	assertBases(t, "b c x", bases, err, h.a)
+}
+
+func TestIsAncestor(t *testing.T) {
+	h := createCrissCross(t)
+
+	tests := []struct {
+		ancestor, descendant oid.ObjectID
+		expected             bool
+	}{
+		{h.a, h.x, true},
+		{h.c, h.x, true},
+		{h.b, h.b, true},
+		{h.x, h.a, false},
+		{h.x, h.y, false},
+		{h.m1, h.y, false},
+	}
+
+	for _, test := range tests {
+		isAncestor, err := IsAncestor(h.repo, test.ancestor, test.descendant)
+		if err != nil {
+			t.Fatalf("IsAncestor failed: %v", err)
+		}
+		if isAncestor != test.expected {
+			t.Errorf("IsAncestor(%s, %s) = %v; expected %v", test.ancestor.Short(), test.descendant.Short(), isAncestor, test.expected)
+		}
+	}
+}
+
+func TestCommitGraph(t *testing.T) {
+	h := createCrissCross(t)
+	for name, id := range map[string]oid.ObjectID{"x": h.x, "y": h.y} {
+		if err := os.WriteFile(filepath.Join(h.repo.Directory, "refs", "heads", name), []byte(id.String()+"\n"), 0644); err != nil {
+			t.Fatalf("Failed to write ref: %v", err)
+		}
+	}
+	if _, err := commitgraph.Write(h.repo); err != nil {
+		t.Fatalf("Failed to write commit-graph: %v", err)
+	}
+
+	// A commit written after the graph is parsed, the others come from the graph alone
+	z := writeTestCommit(t, h.repo, []oid.ObjectID{h.x}, 5000)
+	for _, id := range []oid.ObjectID{h.a, h.b, h.c, h.m1, h.m2, h.x, h.y} {
+		if err := os.Remove(object.LoosePath(h.repo.Directory, id)); err != nil {
+			t.Fatalf("Failed to remove commit: %v", err)
+		}
+	}
+
+	bases, err := All(h.repo, h.x, h.y)
+	assertBases(t, "x y", bases, err, h.c, h.b)
+
+	bases, err = All(h.repo, z, h.y)
+	assertBases(t, "z y", bases, err, h.c, h.b)
+
+	tests := []struct {
+		ancestor, descendant oid.ObjectID
+		expected             bool
+	}{
+		{h.a, z, true},
+		{h.m1, z, true},
+		{z, h.x, false},
+		{h.m1, h.y, false},
+		{h.y, h.a, false},
+	}
+	for _, test := range tests {
+		isAncestor, err := IsAncestor(h.repo, test.ancestor, test.descendant)
+		if err != nil {
+			t.Fatalf("IsAncestor failed: %v", err)
+		}
+		if isAncestor != test.expected {
+			t.Errorf("IsAncestor(%s, %s) = %v; expected %v", test.ancestor.Short(), test.descendant.Short(), isAncestor, test.expected)
+		}
+	}
+}
+
+func TestForkPoint(t *testing.T) {
+	repo := createTestRepo(t)
+	identity := "Test <test@example.com>"
+
+	// upstream pointed at b when topic forked from it, then was rewound and moved to c
+	a := writeTestCommit(t, repo, nil, 1000)
+	b := writeTestCommit(t, repo, []oid.ObjectID{a}, 2000)
+	c := writeTestCommit(t, repo, []oid.ObjectID{a}, 3000)
+	topic := writeTestCommit(t, repo, []oid.ObjectID{b}, 4000)
+
+	if _, err := ForkPoint(repo, "refs/heads/upstream", topic); !errors.Is(err, object.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound without any history, got %v", err)
+	}
+
+	for _, hash := range []oid.ObjectID{b, c} {
+		if err := object.UpdateRef(repo, "refs/heads/upstream", hash, identity, "update"); err != nil {
+			t.Fatalf("UpdateRef failed: %v", err)
+		}
+	}
+
+	bases, err := All(repo, topic, c)
+	assertBases(t, "merge-base", bases, err, a)
+
+	forkPoint, err := ForkPoint(repo, "refs/heads/upstream", topic)
+	if err != nil {
+		t.Fatalf("ForkPoint failed: %v", err)
+	}
+	if forkPoint != b {
+		t.Errorf("Expected fork point %s, got %s", b, forkPoint)
+	}
+}
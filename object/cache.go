@@ -0,0 +1,151 @@
+package object
+
+import (
+	"container/list"
+	"orf/oid"
+	"orf/repository"
+	"sync"
+	"sync/atomic"
+)
+
+// objectCache is a least recently used cache of the parsed commits, trees and tags of a .orf
+// directory, bounded by the size of their data. Objects never change once written, so entries
+// stay valid until they are evicted. Blobs are not cached: they can be large, and are rarely
+// read twice.
+type objectCache struct {
+	mu      sync.Mutex
+	limit   int64
+	size    int64
+	order   *list.List // Most recently used first
+	entries map[oid.ObjectID]*list.Element
+}
+
+type cacheEntry struct {
+	id   oid.ObjectID
+	obj  Object
+	size int64
+}
+
+// caches holds the object cache of each .orf directory, keyed by its path.
+var caches sync.Map
+
+// cacheCounters are shared by every cache.
+var cacheCounters struct {
+	hits, misses, evictions atomic.Uint64
+}
+
+// CacheStats describes the use of the object caches since the process started.
+type CacheStats struct {
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+	Objects   int
+	Bytes     int64
+}
+
+// ReadCacheStats returns the statistics of the object caches of every directory read from.
+func ReadCacheStats() CacheStats {
+	stats := CacheStats{
+		Hits:      cacheCounters.hits.Load(),
+		Misses:    cacheCounters.misses.Load(),
+		Evictions: cacheCounters.evictions.Load(),
+	}
+
+	caches.Range(func(_, value any) bool {
+		cache := value.(*objectCache)
+		cache.mu.Lock()
+		stats.Objects += cache.order.Len()
+		stats.Bytes += cache.size
+		cache.mu.Unlock()
+		return true
+	})
+	return stats
+}
+
+// cacheFor returns the object cache of a directory, or nil when core.objectCacheLimit disables it.
+func cacheFor(directory string) *objectCache {
+	if cache, ok := caches.Load(directory); ok {
+		return cache.(*objectCache)
+	}
+
+	limit := repository.DirectorySettings(directory).ObjectCacheLimit
+	if directory == "" || limit <= 0 {
+		return nil
+	}
+
+	cache, _ := caches.LoadOrStore(directory, &objectCache{
+		limit:   limit,
+		order:   list.New(),
+		entries: make(map[oid.ObjectID]*list.Element),
+	})
+	return cache.(*objectCache)
+}
+
+// get returns a cached object, marking it as the most recently used.
+func (cache *objectCache) get(id oid.ObjectID) (Object, bool) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	element, ok := cache.entries[id]
+	if !ok {
+		return nil, false
+	}
+	cache.order.MoveToFront(element)
+	cacheCounters.hits.Add(1)
+	return element.Value.(*cacheEntry).obj, true
+}
+
+// add caches a commit, tree or tag just read, evicting the least recently used objects to stay
+// within the limit. Other objects, and objects larger than the limit, are not cached.
+func (cache *objectCache) add(id oid.ObjectID, obj Object) {
+	switch obj.(type) {
+	case *Commit, *Tree, *Tag:
+	default:
+		return
+	}
+	cacheCounters.misses.Add(1)
+
+	size := int64(len(obj.GetData()))
+	if size > cache.limit {
+		return
+	}
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	// Another reader may have cached it meanwhile
+	if _, ok := cache.entries[id]; ok {
+		return
+	}
+
+	cache.entries[id] = cache.order.PushFront(&cacheEntry{id: id, obj: obj, size: size})
+	cache.size += size
+	for cache.size > cache.limit {
+		cache.remove(cache.order.Back())
+		cacheCounters.evictions.Add(1)
+	}
+}
+
+// ForgetObject drops an object deleted from a directory from its cache, so it is no longer served
+// after its file is gone.
+func ForgetObject(directory string, id oid.ObjectID) {
+	if cache := cacheFor(directory); cache != nil {
+		cache.forget(id)
+	}
+}
+
+// forget drops an object from the cache.
+func (cache *objectCache) forget(id oid.ObjectID) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	if element, ok := cache.entries[id]; ok {
+		cache.remove(element)
+	}
+}
+
+func (cache *objectCache) remove(element *list.Element) {
+	entry := cache.order.Remove(element).(*cacheEntry)
+	delete(cache.entries, entry.id)
+	cache.size -= entry.size
+}
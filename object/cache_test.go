@@ -0,0 +1,159 @@
+package object
+
+import (
+	"fmt"
+	"orf/kv"
+	"orf/oid"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// writeCacheTestCommit writes a commit with a message of the given size, returning its id.
+func writeCacheTestCommit(t *testing.T, directory string, message string) oid.ObjectID {
+	kvData := kv.CreateOrderedMap()
+	kvData.Add("tree", "0000000000000000000000000000000000000000000000000000000000000000")
+	kvData.Add("author", "Test <test@example.com> 1700000000 +0000")
+	kvData.Add("committer", "Test <test@example.com> 1700000000 +0000")
+	kvData.Add("message", message)
+
+	hash, err := WriteObject(directory, CreateCommit(kv.Serialize(kvData)))
+	if err != nil {
+		t.Fatalf("WriteObject failed: %v", err)
+	}
+	return hash
+}
+
+func writeCacheConfig(t *testing.T, directory string, limit string) {
+	if err := os.WriteFile(filepath.Join(directory, "config"), []byte("[core]\n\tobjectCacheLimit = "+limit+"\n"), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+}
+
+func TestReadObjectCache(t *testing.T) {
+	directory := t.TempDir()
+	commit := writeCacheTestCommit(t, directory, "cached")
+	blob, err := WriteObject(directory, CreateBlob([]byte("not cached")))
+	if err != nil {
+		t.Fatalf("WriteObject failed: %v", err)
+	}
+
+	before := ReadCacheStats()
+	first, err := ReadObject(directory, commit)
+	if err != nil {
+		t.Fatalf("ReadObject failed: %v", err)
+	}
+
+	// Later reads come from memory, even once the file is gone
+	if err := os.Remove(LoosePath(directory, commit)); err != nil {
+		t.Fatalf("Failed to remove object: %v", err)
+	}
+	second, err := ReadObject(directory, commit)
+	if err != nil || second != first {
+		t.Errorf("Expected the cached commit, got %v (%v)", second, err)
+	}
+	if _, err := VerifyObject(directory, commit); err == nil {
+		t.Errorf("Expected VerifyObject to read from disk")
+	}
+
+	if _, err := ReadObject(directory, blob); err != nil {
+		t.Fatalf("ReadObject failed: %v", err)
+	}
+	if err := os.Remove(LoosePath(directory, blob)); err != nil {
+		t.Fatalf("Failed to remove object: %v", err)
+	}
+	if _, err := ReadObject(directory, blob); err == nil {
+		t.Errorf("Expected blobs not to be cached")
+	}
+
+	after := ReadCacheStats()
+	if after.Hits-before.Hits != 1 || after.Misses-before.Misses != 1 {
+		t.Errorf("Expected 1 hit and 1 miss, got %+v then %+v", before, after)
+	}
+}
+
+func TestReadObjectCacheEviction(t *testing.T) {
+	directory := t.TempDir()
+
+	var ids []oid.ObjectID
+	for i := 0; i < 3; i++ {
+		ids = append(ids, writeCacheTestCommit(t, directory, fmt.Sprintf("commit %d", i)))
+	}
+	first, err := readObject(directory, ids[0], false)
+	if err != nil {
+		t.Fatalf("readObject failed: %v", err)
+	}
+
+	// Room for two of the three commits, configured before the cache is first used
+	writeCacheConfig(t, directory, fmt.Sprint(2*len(first.GetData())))
+
+	before := ReadCacheStats()
+	for _, id := range []oid.ObjectID{ids[0], ids[1], ids[0], ids[2]} {
+		if _, err := ReadObject(directory, id); err != nil {
+			t.Fatalf("ReadObject failed: %v", err)
+		}
+	}
+	after := ReadCacheStats()
+	if after.Evictions-before.Evictions != 1 {
+		t.Errorf("Expected one eviction, got %+v then %+v", before, after)
+	}
+
+	// The least recently used commit was evicted, the one read again was kept
+	cache := cacheFor(directory)
+	if _, ok := cache.get(ids[1]); ok {
+		t.Errorf("Expected %s to be evicted", ids[1])
+	}
+	if _, ok := cache.get(ids[0]); !ok {
+		t.Errorf("Expected %s to be kept", ids[0])
+	}
+	if cache.size > cache.limit {
+		t.Errorf("Expected the cache to stay within %d bytes, got %d", cache.limit, cache.size)
+	}
+}
+
+func TestReadObjectCacheDisabled(t *testing.T) {
+	directory := t.TempDir()
+	writeCacheConfig(t, directory, "0")
+	commit := writeCacheTestCommit(t, directory, "uncached")
+
+	if _, err := ReadObject(directory, commit); err != nil {
+		t.Fatalf("ReadObject failed: %v", err)
+	}
+	if cacheFor(directory) != nil {
+		t.Errorf("Expected no cache with core.objectCacheLimit = 0")
+	}
+}
+
+func TestReadObjectCacheConcurrent(t *testing.T) {
+	directory := t.TempDir()
+	writeCacheConfig(t, directory, "1k")
+
+	var ids []oid.ObjectID
+	for i := 0; i < 50; i++ {
+		ids = append(ids, writeCacheTestCommit(t, directory, fmt.Sprintf("commit %d", i)))
+	}
+
+	var wg sync.WaitGroup
+	for worker := 0; worker < 8; worker++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			for i := range ids {
+				id := ids[(i+worker*7)%len(ids)]
+				obj, err := ReadObject(directory, id)
+				if err != nil || obj.GetFormat() != "commit" {
+					t.Errorf("ReadObject(%s) failed: %v", id, err)
+				}
+			}
+		}(worker)
+	}
+	wg.Wait()
+
+	cache := cacheFor(directory)
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	if cache.size > cache.limit || cache.order.Len() != len(cache.entries) {
+		t.Errorf("Expected a consistent cache within its limit, got %d bytes for %d entries", cache.size, len(cache.entries))
+	}
+}
@@ -1,6 +1,10 @@
 package object
 
-import "orf/kv"
+import (
+	"fmt"
+	"orf/kv"
+	"orf/oid"
+)
 
 // Represents a commit object, with key-value data forming the commit message.
 type Commit struct {
@@ -49,3 +53,71 @@ func (commit *Commit) Deserialize(data []byte) error {
 	commit.kvData = kvData
 	return nil
 }
+
+// TreeHash returns the hash of the commit's root tree.
+func (commit *Commit) TreeHash() oid.ObjectID {
+	values := getValues(commit.kvData, "tree")
+	if len(values) == 0 {
+		return ""
+	}
+	return oid.ObjectID(values[0])
+}
+
+// Parents returns the hashes of the commit's parents, in order.
+func (commit *Commit) Parents() []oid.ObjectID {
+	var parents []oid.ObjectID
+	for _, value := range getValues(commit.kvData, "parent") {
+		parents = append(parents, oid.ObjectID(value))
+	}
+	return parents
+}
+
+// Message returns the full commit message.
+func (commit *Commit) Message() string {
+	values := getValues(commit.kvData, "message")
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+// Author returns the parsed author line of the commit.
+func (commit *Commit) Author() (*Signature, error) {
+	values := getValues(commit.kvData, "author")
+	if len(values) == 0 {
+		return nil, fmt.Errorf("commit has no author")
+	}
+	return ParseSignature(values[0])
+}
+
+// Committer returns the parsed committer line of the commit.
+func (commit *Commit) Committer() (*Signature, error) {
+	values := getValues(commit.kvData, "committer")
+	if len(values) == 0 {
+		return nil, fmt.Errorf("commit has no committer")
+	}
+	return ParseSignature(values[0])
+}
+
+// getValues normalizes the value(s) stored under key to a list of strings.
+func getValues(kvData *kv.OrderedMap, key string) []string {
+	if kvData == nil {
+		return nil
+	}
+
+	value, exists := kvData.Get(key)
+	if !exists {
+		return nil
+	}
+
+	switch v := value.(type) {
+	case string:
+		return []string{v}
+	case []string:
+		return v
+	case []byte:
+		return []string{string(v)}
+	default:
+		return nil
+	}
+}
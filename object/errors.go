@@ -0,0 +1,64 @@
+package object
+
+import (
+	"errors"
+	"fmt"
+	"orf/oid"
+	"strings"
+)
+
+// ErrNotFound is returned when a name does not resolve to any object.
+var ErrNotFound = errors.New("no such object")
+
+// ErrCorrupt is matched by the CorruptObjectError returned when an object's contents cannot be
+// read or do not match its id.
+var ErrCorrupt = errors.New("corrupt object")
+
+// CorruptObjectError is returned when an object's contents cannot be decompressed or unpacked
+// (Err), or hash to another id (Actual).
+type CorruptObjectError struct {
+	ID     oid.ObjectID
+	Actual oid.ObjectID
+	Err    error
+}
+
+func (e *CorruptObjectError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("corrupt object %s: %v", e.ID, e.Err)
+	}
+	return fmt.Sprintf("corrupt object %s: contents hash to %s", e.ID, e.Actual)
+}
+
+func (e *CorruptObjectError) Unwrap() error {
+	return e.Err
+}
+
+// Is makes errors.Is(err, ErrCorrupt) hold for every CorruptObjectError.
+func (e *CorruptObjectError) Is(target error) bool {
+	return target == ErrCorrupt
+}
+
+// AmbiguousError is returned when a name resolves to more than one object.
+type AmbiguousError struct {
+	Name       string
+	Candidates []oid.ObjectID
+}
+
+func (e *AmbiguousError) Error() string {
+	candidates := make([]string, len(e.Candidates))
+	for i, candidate := range e.Candidates {
+		candidates[i] = candidate.String()
+	}
+	return fmt.Sprintf("ambiguous reference %s: candidates are:\n - %s", e.Name, strings.Join(candidates, "\n - "))
+}
+
+// WrongTypeError is returned when an object exists but is not of the requested type.
+type WrongTypeError struct {
+	ID   oid.ObjectID
+	Have string
+	Want string
+}
+
+func (e *WrongTypeError) Error() string {
+	return fmt.Sprintf("object %s is a %s, not a %s", e.ID, e.Have, e.Want)
+}
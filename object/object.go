@@ -3,16 +3,18 @@ package object
 import (
 	"bytes"
 	"compress/zlib"
-	"crypto/sha256"
-	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
+	"orf/oid"
+	"orf/pack"
 	"orf/repository"
 	"os"
 	"path/filepath"
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // Defines the basic methods that any object type must implement (Blob, Commit, Tag, Tree).
@@ -41,21 +43,170 @@ func (base *Base) GetData() []byte {
 	return base.data
 }
 
-// ReadObject reads an object hash (SHA-256) from a .orf repository and returns an Object.
+// ReadObject reads an object by its id (SHA-1 or SHA-256) from a .orf repository and returns an Object.
 // The type of the returned Object depends on the object associated with the given hash.
-func ReadObject(directory string, hash string) (Object, error) {
+// Unless core.verifyObjects is false, the contents are rehashed and a *CorruptObjectError is
+// returned if they do not match the id. Commits, trees and tags are kept in a cache bounded by
+// core.objectCacheLimit, and are shared by the callers reading them: they must not be modified.
+func ReadObject(directory string, hash oid.ObjectID) (Object, error) {
+	cache := cacheFor(directory)
+	if cache != nil {
+		if obj, ok := cache.get(hash); ok {
+			return obj, nil
+		}
+	}
+
+	obj, err := readObject(directory, hash, repository.VerifyObjects(directory))
+	if err == nil && cache != nil {
+		cache.add(hash, obj)
+	}
+	return obj, err
+}
+
+// VerifyObject reads an object like ReadObject, but always from disk, and always rehashes its
+// contents, whatever core.verifyObjects says.
+func VerifyObject(directory string, hash oid.ObjectID) (Object, error) {
+	return readObject(directory, hash, true)
+}
+
+func readObject(directory string, hash oid.ObjectID, verify bool) (Object, error) {
+	data, err := readRawObject(directory, hash)
+	if err != nil {
+		return nil, err
+	}
+
+	if verify {
+		if sum := hash.Algorithm().Sum(data); sum != hash {
+			return nil, &CorruptObjectError{ID: hash, Actual: sum}
+		}
+	}
+
+	obj, err := decodeObject(hash, data)
+	if err != nil {
+		return nil, &CorruptObjectError{ID: hash, Err: err}
+	}
+	return obj, nil
+}
+
+// quarantineDir holds the loose objects moved aside by QuarantineObject, under objects/.
+const quarantineDir = "quarantine"
+
+// QuarantineObject moves the loose file of a (corrupt) object to objects/quarantine/<id>, out of
+// the way of readers and of a fresh copy of the object, and returns its new path. Packed objects
+// cannot be moved aside on their own.
+func QuarantineObject(directory string, hash oid.ObjectID) (string, error) {
+	path := LoosePath(directory, hash)
+	if _, err := os.Stat(path); err != nil {
+		if os.IsNotExist(err) {
+			return "", fmt.Errorf("%s is not a loose object", hash)
+		}
+		return "", err
+	}
+
+	dir := filepath.Join(directory, "objects", quarantineDir)
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return "", err
+	}
+
+	// Keep earlier quarantined copies of the same object
+	target := filepath.Join(dir, hash.String())
+	for i := 1; ; i++ {
+		if _, err := os.Lstat(target); os.IsNotExist(err) {
+			break
+		}
+		target = filepath.Join(dir, fmt.Sprintf("%s.%d", hash, i))
+	}
+
+	if err := os.Rename(path, target); err != nil {
+		return "", err
+	}
+	os.Remove(filepath.Dir(path))
+	ForgetObject(directory, hash)
+	return target, nil
+}
+
+// ListLooseObjects returns the ids of the loose objects in a .orf directory, in order. Files
+// under objects/ that are not named like an object are ignored.
+func ListLooseObjects(directory string) ([]oid.ObjectID, error) {
+	objectsDir := filepath.Join(directory, "objects")
+	dirs, err := os.ReadDir(objectsDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
 
-	hashDir := hash[0:2]
-	hashFile := hash[2:]
+	var ids []oid.ObjectID
+	for _, dir := range dirs {
+		if !dir.IsDir() || len(dir.Name()) != 2 {
+			continue
+		}
+
+		files, err := os.ReadDir(filepath.Join(objectsDir, dir.Name()))
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range files {
+			id, err := oid.FromHex(dir.Name() + file.Name())
+			if err != nil || file.IsDir() || string(id) != dir.Name()+file.Name() {
+				continue
+			}
+			ids = append(ids, id)
+		}
+	}
+
+	return ids, nil
+}
+
+// LoosePath returns the path of the loose file of an object, e.g. .orf/objects/1a/2b3c...
+func LoosePath(directory string, hash oid.ObjectID) string {
+	return filepath.Join(directory, "objects", string(hash[:2]), string(hash[2:]))
+}
+
+// readRawObject returns the contents of an object, header included, reading it from its loose
+// file or else from a pack.
+func readRawObject(directory string, hash oid.ObjectID) ([]byte, error) {
+	data, err := readLooseObject(directory, hash)
+	if !errors.Is(err, ErrNotFound) {
+		return data, err
+	}
+
+	format, content, packErr := pack.ReadObject(directory, hash)
+	if errors.Is(packErr, pack.ErrNotFound) {
+		return nil, err
+	}
+	if packErr != nil {
+		return nil, &CorruptObjectError{ID: hash, Err: packErr}
+	}
+
+	return encodeObject(&Base{format: format, size: uint32(len(content)), data: content}), nil
+}
+
+// readLooseObject returns the decompressed contents of a loose object, header included.
+func readLooseObject(directory string, hash oid.ObjectID) ([]byte, error) {
+
+	if len(hash) < 3 {
+		return nil, fmt.Errorf("%w: invalid object hash %q", ErrNotFound, hash)
+	}
+
+	hashDir := string(hash[0:2])
+	hashFile := string(hash[2:])
 
 	path, err := repository.GetFilePath(directory, false, "objects", hashDir, hashFile)
 	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("%w: %s", ErrNotFound, hash)
+		}
 		return nil, err
 	}
 
 	// Open file at path
 	file, err := os.Open(path)
 	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("%w: %s", ErrNotFound, hash)
+		}
 		return nil, err
 	}
 	defer file.Close()
@@ -63,217 +214,221 @@ func ReadObject(directory string, hash string) (Object, error) {
 	// Decompress file with zlib
 	zlibReader, err := zlib.NewReader(file)
 	if err != nil {
-		return nil, err
+		return nil, &CorruptObjectError{ID: hash, Err: err}
 	}
 	defer zlibReader.Close()
 
 	var rawData bytes.Buffer
 	_, err = io.Copy(&rawData, zlibReader)
 	if err != nil {
-		return nil, err
+		return nil, &CorruptObjectError{ID: hash, Err: err}
 	}
 
+	return rawData.Bytes(), nil
+}
+
+// decodeObject parses the "<format> <size>\x00" header and the data of an object.
+func decodeObject(hash oid.ObjectID, data []byte) (Object, error) {
+
 	// Get object format
-	data := rawData.Bytes()
 	formatIndex := bytes.IndexByte(data, ' ')
 	if formatIndex == -1 {
 		return nil, fmt.Errorf("invalid object format index")
 	}
 	objectFormat := string(data[:formatIndex])
 
-	// Get object size
-	startSizeIndex := formatIndex + 1
-	endSizeIndex := formatIndex + 5
-
-	size, err := strconv.Atoi(string(data[startSizeIndex:endSizeIndex]))
-	if err != nil {
-		return nil, fmt.Errorf("invalid size format: %v", err)
-	}
-
-	// Get object data
-	dataIndex := bytes.IndexByte(data[endSizeIndex:], '\x00')
+	// Get object size, which runs up to the NUL before the data
+	dataIndex := bytes.IndexByte(data[formatIndex:], '\x00')
 	if dataIndex == -1 {
 		return nil, fmt.Errorf("invalid data format index")
 	}
 
 	// Convert data index to absolute index
-	dataIndex = endSizeIndex + dataIndex
+	dataIndex = formatIndex + dataIndex
+
+	size, err := strconv.Atoi(string(data[formatIndex+1 : dataIndex]))
+	if err != nil {
+		return nil, fmt.Errorf("invalid size format: %v", err)
+	}
 
-	fmt.Println(data, size, dataIndex)
 	if size != len(data)-(dataIndex+1) {
 		return nil, fmt.Errorf("object size mismatch")
 	}
 
-	// Create object based on format
+	// Create object based on format, parsing structured objects
+	content := data[dataIndex+1:]
 	switch objectFormat {
 	case "blob":
-		return CreateBlob(data[dataIndex+1:]), nil
+		return CreateBlob(content), nil
 	case "commit":
-		return CreateCommit(data[dataIndex+1:]), nil
+		commit := CreateCommit(content)
+		if err := commit.Deserialize(content); err != nil {
+			return nil, fmt.Errorf("invalid commit: %w", err)
+		}
+		return commit, nil
 	case "tree":
-		return CreateTree(data[dataIndex+1:]), nil
+		tree := CreateTree(content)
+		tree.Algorithm = hash.Algorithm()
+		if err := tree.Deserialize(content); err != nil {
+			return nil, fmt.Errorf("invalid tree: %w", err)
+		}
+		return tree, nil
+	case "tag":
+		tag := CreateTag(content)
+		if err := tag.Deserialize(content); err != nil {
+			return nil, fmt.Errorf("invalid tag: %w", err)
+		}
+		return tag, nil
 	default:
 		return nil, fmt.Errorf("unknown object type: %s", objectFormat)
 	}
 }
 
-// Writes an Object to a .orf repository and returns the SHA-256 hash of the written object.
-// If the directory is not specified, it returns the hash without writing the object to the repository.
-func WriteObject(directory string, object Object) (string, error) {
-
-	header := []byte(object.GetFormat() + " ")
-	length := fmt.Sprintf("%04d", object.GetSize())
-
-	result := append(header, length...)
-	result = append(result, '\x00')
-	result = append(result, object.GetData()...)
+// Writes an Object to a .orf repository and returns the id of the written object, hashed with
+// the repository's object format. If the directory is not specified, it returns the hash
+// (using the default object format) without writing the object to the repository.
+// The object is compressed into a temporary file that is renamed into place once complete, so
+// readers and crashes never leave a partial object behind; core.fsync (loose-object) flushes it
+// to disk first.
+func WriteObject(directory string, object Object) (oid.ObjectID, error) {
 
-	sha := sha256.Sum256(result)
-	shaHex := hex.EncodeToString(sha[:])
+	result := encodeObject(object)
+	settings := repository.DirectorySettings(directory)
+	shaHex := settings.Format.Sum(result)
 
 	if directory == "" {
 		// Return hex if no .orf path specified
 		return shaHex, nil
 	}
 
-	dirPath := filepath.Join(directory, "objects", shaHex[:2])
-	filePath := filepath.Join(directory, "objects", shaHex[:2], shaHex[2:])
+	dirPath := filepath.Join(directory, "objects", string(shaHex[:2]))
+	filePath := filepath.Join(directory, "objects", string(shaHex[:2]), string(shaHex[2:]))
 
-	if err := os.MkdirAll(dirPath, os.ModePerm); err != nil {
-		return "", fmt.Errorf("no directory with path %v found: %w", dirPath, err)
+	// An object already stored only has its time refreshed, so it is not pruned as an old
+	// unreachable object while it is being used again. If that fails, e.g. because prune has
+	// just deleted it, the object is written again
+	now := time.Now()
+	if err := os.Chtimes(filePath, now, now); err == nil {
+		return shaHex, nil
 	}
 
-	// Check if file already exists
-	if _, err := os.Stat(filePath); err == nil {
-		return shaHex, nil
+	if err := os.MkdirAll(dirPath, os.ModePerm); err != nil {
+		return "", fmt.Errorf("no directory with path %v found: %w", dirPath, err)
 	}
 
-	file, err := os.Create(filePath)
+	file, err := os.CreateTemp(dirPath, tempObjectPrefix)
 	if err != nil {
 		return "", fmt.Errorf("fail to create file: %w", err)
 	}
+	defer os.Remove(file.Name())
 	defer file.Close()
 
-	// Compress to zlib
-	writer := zlib.NewWriter(file)
-	defer writer.Close()
+	fsync := settings.Fsync&repository.FsyncLooseObject != 0
+	if err := writeCompressed(file, result, settings.LooseCompression, fsync); err != nil {
+		return "", fmt.Errorf("fail to write object %s: %w", shaHex, err)
+	}
 
-	if _, err := writer.Write(result); err != nil {
-		return "", fmt.Errorf("fail to compress file: %w", err)
+	// Another writer may have stored the same object meanwhile, with the same contents
+	if err := os.Rename(file.Name(), filePath); err != nil {
+		return "", fmt.Errorf("fail to store object %s: %w", shaHex, err)
+	}
+	if fsync {
+		if err := syncDir(dirPath); err != nil {
+			return "", err
+		}
 	}
 
 	return shaHex, nil
 }
 
-func FindObject(repo *repository.Repo, name string, format string, follow bool) string {
-	shaList, err := ResolveObject(repo, name)
+// tempObjectPrefix starts the names of loose objects being written.
+const tempObjectPrefix = "tmp_obj_"
+
+// writeCompressed compresses data into a file at the given zlib level and closes it, flushing
+// it to disk first with fsync.
+func writeCompressed(file *os.File, data []byte, level int, fsync bool) error {
+	writer, err := zlib.NewWriterLevel(file, level)
 	if err != nil {
-		return ""
+		return err
 	}
-
-	if len(shaList) == 0 {
-		fmt.Printf("No such reference %s.", name)
-		return ""
+	if _, err := writer.Write(data); err != nil {
+		return err
 	}
-
-	if len(shaList) > 1 {
-		fmt.Printf("Ambiguous reference %s: Candidates are:\n - %s.", name, shaList)
-		return ""
+	if err := writer.Close(); err != nil {
+		return err
 	}
 
-	sha := shaList[0]
-	if format == "" {
-		return sha
+	if err := file.Chmod(0644); err != nil {
+		return err
 	}
-
-	for {
-		obj, err := ReadObject(repo.Directory, sha)
-		if err != nil {
-			fmt.Printf("Error reading object %s: %v", sha, err)
-			return ""
-		}
-
-		if string(obj.GetFormat()) == format {
-			fmt.Printf("Found %s object %s.", format, sha)
-			return sha
-		}
-
-		if !follow {
-			fmt.Printf("Object %s is a %s, not a %s.", sha, obj.GetFormat(), format)
-			return ""
+	if fsync {
+		if err := file.Sync(); err != nil {
+			return err
 		}
+	}
+	return file.Close()
+}
 
-		// Follow tags
-		if string(obj.GetFormat()) == "tag" {
-
-			// Assert tag
-			tag, ok := obj.(*Tag)
-			if !ok {
-				fmt.Printf("Object %s is not a tag", sha)
-				return ""
-			}
-
-			value, found := tag.GetKVData().Get("object")
-
-			if !found {
-				fmt.Printf("Tag %s does not have an object", sha)
-				return ""
-			}
-
-			// Assert string
-			valueStr, ok := value.(string)
-			if !ok {
-				fmt.Printf("Tag %s object is not a string", sha)
-				return ""
-			}
-
-			sha = valueStr
-
-		} else if string(obj.GetFormat()) == "commit" && format == "tree" {
-
-			// Assert commit
-			commit, ok := obj.(*Commit)
-			if !ok {
-				fmt.Printf("Object %s is not a commit", sha)
-				return ""
-			}
+// syncDir flushes a directory to disk, making the files renamed into it durable.
+func syncDir(path string) error {
+	dir, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
 
-			value, found := commit.GetKVData().Get("tree")
+// FindObject resolves a revision name to the hash of an object of the given format (any format
+// if empty). If follow is true, tags are peeled and commits are followed to their tree until the
+// format matches. It returns an error wrapping ErrNotFound, an *AmbiguousError or a *WrongTypeError.
+func FindObject(repo *repository.Repo, name string, format string, follow bool) (oid.ObjectID, error) {
+	sha, err := ResolveRevision(repo, name)
+	if err != nil {
+		return "", err
+	}
 
-			if !found {
-				fmt.Printf("Commit %s does not have an tree", sha)
-				return ""
-			}
+	if format == "" {
+		return sha, nil
+	}
 
-			// Assert string
-			valueStr, ok := value.(string)
-			if !ok {
-				fmt.Printf("Commit %s tree is not a string", sha)
-				return ""
-			}
+	if follow {
+		return peel(repo, sha, format)
+	}
 
-			sha = valueStr
+	obj, err := ReadObject(repo.Directory, sha)
+	if err != nil {
+		return "", err
+	}
 
-		} else {
-			return ""
-		}
+	if obj.GetFormat() != format {
+		return "", &WrongTypeError{ID: sha, Have: obj.GetFormat(), Want: format}
 	}
+
+	return sha, nil
 }
 
-func ResolveObject(repo *repository.Repo, name string) ([]string, error) {
+// ResolveObject returns every object hash a bare name could refer to: HEAD (or @) and other
+// *HEAD pseudo-refs, full refs/... names, hash prefixes, tags, branches and remote-tracking branches.
+// Candidates are deduplicated, so a tag and a branch naming the same commit are not ambiguous.
+func ResolveObject(repo *repository.Repo, name string) ([]oid.ObjectID, error) {
 
-	var candidates []string
-	hashRE := regexp.MustCompile(`^[0-9A-Fa-f]{4,40}$`)
+	var candidates []oid.ObjectID
+	hashRE := regexp.MustCompile(`^[0-9A-Fa-f]{4,64}$`)
 
 	// If the name is empty, return nil.
 	if strings.TrimSpace(name) == "" {
 		return nil, nil
 	}
 
-	// Head is nonambiguous
-	if name == "HEAD" {
-		if headRef, found := resolveRef(repo, "HEAD"); found != nil {
-			candidates = append(candidates, headRef)
+	if name == "@" {
+		name = "HEAD"
+	}
+
+	// HEAD (and ORIG_HEAD, MERGE_HEAD, ...) and full ref names are nonambiguous
+	if pseudoRefRE.MatchString(name) || strings.HasPrefix(name, "refs/") {
+		if ref, err := resolveRef(repo, name); err == nil && ref != "" {
+			candidates = append(candidates, ref)
 		}
 		return candidates, nil
 	}
@@ -282,38 +437,75 @@ func ResolveObject(repo *repository.Repo, name string) ([]string, error) {
 	if hashRE.MatchString(name) {
 		name = strings.ToLower(name)
 		prefix := name[:2]
-		path, err := repository.GetDir(repo.WorkTree, false, "objects", prefix)
-
-		if err != nil {
-			return nil, err
-		}
+		path := filepath.Join(repo.Directory, "objects", prefix)
 
 		// Check if the directory exists
-		if _, err := os.Stat(path); os.IsNotExist(err) {
-			return nil, nil
+		if _, err := os.Stat(path); err == nil {
+			rem := name[2:]
+			files, err := os.ReadDir(path)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, f := range files {
+				if strings.HasPrefix(f.Name(), rem) {
+					candidates = append(candidates, oid.ObjectID(prefix+f.Name()))
+				}
+			}
 		}
 
-		rem := name[2:]
-		files, err := os.ReadDir(path)
+		packed, err := pack.FindPrefix(repo.Directory, name)
 		if err != nil {
 			return nil, err
 		}
-
-		for _, f := range files {
-			if strings.HasPrefix(f.Name(), rem) {
-				candidates = append(candidates, prefix+f.Name())
+		for _, id := range packed {
+			if !containsID(candidates, id) {
+				candidates = append(candidates, id)
 			}
 		}
 	}
 
-	// Try for references.
-	if asTag, found := resolveRef(repo, "refs/tags/"+name); found != nil {
-		candidates = append(candidates, asTag)
+	// Try for references: refs/<name> (e.g. refs/stash), tags, branches, then
+	// remote-tracking branches (and a remote's HEAD).
+	refNames := []string{
+		"refs/" + name,
+		"refs/tags/" + name,
+		"refs/heads/" + name,
+		"refs/remotes/" + name,
+		"refs/remotes/" + name + "/HEAD",
 	}
 
-	if asBranch, found := resolveRef(repo, "refs/heads/"+name); found != nil {
-		candidates = append(candidates, asBranch)
+	for _, refName := range refNames {
+		if ref, err := resolveRef(repo, refName); err == nil && ref != "" && !containsID(candidates, ref) {
+			candidates = append(candidates, ref)
+		}
 	}
 
 	return candidates, nil
 }
+
+// HashObject returns the id of an object under the given algorithm, without writing it.
+func HashObject(algorithm oid.Algorithm, object Object) oid.ObjectID {
+	return algorithm.Sum(encodeObject(object))
+}
+
+// encodeObject prepends the "<format> <size>\x00" header to the object's data.
+func encodeObject(object Object) []byte {
+	header := []byte(object.GetFormat() + " ")
+	length := fmt.Sprintf("%04d", object.GetSize())
+
+	result := append(header, length...)
+	result = append(result, '\x00')
+	result = append(result, object.GetData()...)
+
+	return result
+}
+
+func containsID(ids []oid.ObjectID, id oid.ObjectID) bool {
+	for _, candidate := range ids {
+		if candidate == id {
+			return true
+		}
+	}
+	return false
+}
@@ -5,10 +5,14 @@ import (
 	"compress/zlib"
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
+	"fmt"
 	"io"
+	"orf/oid"
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 )
 
 type MockRepository struct{}
@@ -65,7 +69,7 @@ func TestReadObject(t *testing.T) {
 	}
 
 	// Test
-	obj, err := ReadObject(directory, hashHex)
+	obj, err := ReadObject(directory, oid.ObjectID(hashHex))
 	if err != nil {
 		t.Fatalf("ReadObject failed: %v", err)
 	}
@@ -99,8 +103,8 @@ func TestWriteObject(t *testing.T) {
 		t.Fatalf("WriteObject failed: %v", err)
 	}
 
-	hashDir := hashHex[:2]
-	hashFile := hashHex[2:]
+	hashDir := string(hashHex[:2])
+	hashFile := string(hashHex[2:])
 	objectPath := filepath.Join(directory, "objects", hashDir, hashFile)
 
 	if _, err := os.Stat(objectPath); os.IsNotExist(err) {
@@ -130,3 +134,276 @@ func TestWriteObject(t *testing.T) {
 		t.Errorf("Expected data %s, got %s", expectedData, rawData.Bytes())
 	}
 }
+
+func TestWriteObjectExisting(t *testing.T) {
+	directory := t.TempDir()
+	hash, err := WriteObject(directory, CreateBlob([]byte("written twice")))
+	if err != nil {
+		t.Fatalf("WriteObject failed: %v", err)
+	}
+	path := LoosePath(directory, hash)
+
+	// Writing it again refreshes its time, so prune treats it as new
+	old := time.Now().Add(-30 * 24 * time.Hour)
+	if err := os.Chtimes(path, old, old); err != nil {
+		t.Fatalf("Chtimes failed: %v", err)
+	}
+	if _, err := WriteObject(directory, CreateBlob([]byte("written twice"))); err != nil {
+		t.Fatalf("WriteObject failed: %v", err)
+	}
+	if info, err := os.Stat(path); err != nil || time.Since(info.ModTime()) > time.Hour {
+		t.Errorf("Expected the object's time to be refreshed, got %v (%v)", info, err)
+	}
+
+	// Once deleted, e.g. by prune, it is written again rather than assumed to exist
+	if err := os.RemoveAll(filepath.Dir(path)); err != nil {
+		t.Fatalf("Failed to remove object: %v", err)
+	}
+	if _, err := WriteObject(directory, CreateBlob([]byte("written twice"))); err != nil {
+		t.Fatalf("WriteObject failed: %v", err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("Expected the object to be written again: %v", err)
+	}
+}
+
+func TestReadLargeObject(t *testing.T) {
+	directory := t.TempDir()
+	data := bytes.Repeat([]byte("0123456789"), 2000)
+
+	hash, err := WriteObject(directory, CreateBlob(data))
+	if err != nil {
+		t.Fatalf("WriteObject failed: %v", err)
+	}
+
+	obj, err := ReadObject(directory, hash)
+	if err != nil {
+		t.Fatalf("ReadObject failed: %v", err)
+	}
+
+	if !bytes.Equal(obj.GetData(), data) {
+		t.Errorf("Expected %d bytes back, got %d", len(data), len(obj.GetData()))
+	}
+}
+
+func TestReadObjectMissing(t *testing.T) {
+	directory := t.TempDir()
+
+	for _, hash := range []oid.ObjectID{"", "ab", "abcdef0123"} {
+		if _, err := ReadObject(directory, hash); !errors.Is(err, ErrNotFound) {
+			t.Errorf("ReadObject(%q): expected ErrNotFound, got %v", hash, err)
+		}
+	}
+}
+
+func TestFindObject(t *testing.T) {
+	history := createTestHistory(t)
+
+	hash, err := FindObject(history.repo, "HEAD", "commit", false)
+	if err != nil || hash != history.merge {
+		t.Errorf("Expected HEAD commit %s, got %s (%v)", history.merge, hash, err)
+	}
+
+	hash, err = FindObject(history.repo, "HEAD", "tree", true)
+	if err != nil || hash != history.tree {
+		t.Errorf("Expected HEAD tree %s, got %s (%v)", history.tree, hash, err)
+	}
+
+	_, err = FindObject(history.repo, "HEAD", "tree", false)
+	var wrongType *WrongTypeError
+	if !errors.As(err, &wrongType) {
+		t.Fatalf("Expected a WrongTypeError, got %v", err)
+	}
+	if wrongType.ID != history.merge || wrongType.Have != "commit" || wrongType.Want != "tree" {
+		t.Errorf("Unexpected WrongTypeError %+v", wrongType)
+	}
+
+	if _, err := FindObject(history.repo, "HEAD", "blob", true); !errors.As(err, &wrongType) {
+		t.Errorf("Expected a WrongTypeError peeling a commit to a blob, got %v", err)
+	}
+
+	if _, err := FindObject(history.repo, "nonexistent", "", false); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+
+	writeTestRef(t, history.repo, "refs/tags/dup", history.root)
+	writeTestRef(t, history.repo, "refs/heads/dup", history.side)
+
+	var ambiguous *AmbiguousError
+	if _, err := FindObject(history.repo, "dup", "commit", true); !errors.As(err, &ambiguous) {
+		t.Errorf("Expected an AmbiguousError, got %v", err)
+	}
+}
+
+func TestVerifyObject(t *testing.T) {
+	directory := t.TempDir()
+
+	hash, err := WriteObject(directory, CreateBlob([]byte("intact")))
+	if err != nil {
+		t.Fatalf("WriteObject failed: %v", err)
+	}
+	if _, err := VerifyObject(directory, hash); err != nil {
+		t.Errorf("VerifyObject failed on an intact object: %v", err)
+	}
+
+	// Store other contents under a made up id
+	prefix := "ab"
+	if string(hash[:2]) == prefix {
+		prefix = "cd"
+	}
+	forged := oid.ObjectID(prefix + string(hash[2:]))
+	contents, err := os.ReadFile(filepath.Join(directory, "objects", string(hash[:2]), string(hash[2:])))
+	if err != nil {
+		t.Fatalf("Failed to read object file: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Join(directory, "objects", prefix), os.ModePerm); err != nil {
+		t.Fatalf("Failed to create directories: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(directory, "objects", prefix, string(hash[2:])), contents, 0644); err != nil {
+		t.Fatalf("Failed to write object file: %v", err)
+	}
+
+	if _, err := VerifyObject(directory, forged); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("Expected ErrCorrupt for a mismatched object, got %v", err)
+	}
+
+	// Files that are not objects are not listed
+	if err := os.WriteFile(filepath.Join(directory, "objects", prefix, "tmp_obj"), nil, 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	ids, err := ListLooseObjects(directory)
+	if err != nil {
+		t.Fatalf("ListLooseObjects failed: %v", err)
+	}
+	if len(ids) != 2 || !containsID(ids, hash) || !containsID(ids, forged) {
+		t.Errorf("Expected %s and %s, got %v", hash, forged, ids)
+	}
+}
+
+func TestReadObjectVerification(t *testing.T) {
+	directory := t.TempDir()
+
+	hash, err := WriteObject(directory, CreateBlob([]byte("original")))
+	if err != nil {
+		t.Fatalf("WriteObject failed: %v", err)
+	}
+	other, err := WriteObject(directory, CreateBlob([]byte("tampered")))
+	if err != nil {
+		t.Fatalf("WriteObject failed: %v", err)
+	}
+
+	// Overwrite the first object with the contents of the second
+	contents, err := os.ReadFile(LoosePath(directory, other))
+	if err != nil {
+		t.Fatalf("Failed to read object file: %v", err)
+	}
+	if err := os.WriteFile(LoosePath(directory, hash), contents, 0644); err != nil {
+		t.Fatalf("Failed to write object file: %v", err)
+	}
+
+	_, err = ReadObject(directory, hash)
+	var corruptErr *CorruptObjectError
+	if !errors.As(err, &corruptErr) || corruptErr.ID != hash || corruptErr.Actual != other || !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("Expected a CorruptObjectError hashing to %s, got %v", other, err)
+	}
+
+	// Damaged compressed data is corrupt too
+	if err := os.WriteFile(LoosePath(directory, other), []byte("not zlib"), 0644); err != nil {
+		t.Fatalf("Failed to write object file: %v", err)
+	}
+	if _, err := ReadObject(directory, other); !errors.As(err, &corruptErr) || corruptErr.Err == nil {
+		t.Errorf("Expected a CorruptObjectError for undecompressable data, got %v", err)
+	}
+
+	path, err := QuarantineObject(directory, hash)
+	if err != nil {
+		t.Fatalf("QuarantineObject failed: %v", err)
+	}
+	if filepath.Dir(path) != filepath.Join(directory, "objects", quarantineDir) {
+		t.Errorf("Expected the object under objects/%s, got %s", quarantineDir, path)
+	}
+	if _, err := ReadObject(directory, hash); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected the quarantined object to be gone, got %v", err)
+	}
+
+	// A fresh copy can be written again, and quarantined without clobbering the first one
+	if _, err := WriteObject(directory, CreateBlob([]byte("original"))); err != nil {
+		t.Fatalf("WriteObject failed: %v", err)
+	}
+	if obj, err := ReadObject(directory, hash); err != nil || string(obj.GetData()) != "original" {
+		t.Errorf("Expected the rewritten object to read back, got %v", err)
+	}
+	if second, err := QuarantineObject(directory, hash); err != nil || second == path {
+		t.Errorf("Expected a second quarantined copy next to %s, got %s (%v)", path, second, err)
+	}
+}
+
+func TestReadObjectWithoutVerification(t *testing.T) {
+	directory := t.TempDir()
+	if err := os.WriteFile(filepath.Join(directory, "config"), []byte("[core]\n\tverifyObjects = false\n"), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+
+	hash, err := WriteObject(directory, CreateBlob([]byte("original")))
+	if err != nil {
+		t.Fatalf("WriteObject failed: %v", err)
+	}
+	other, err := WriteObject(directory, CreateBlob([]byte("tampered")))
+	if err != nil {
+		t.Fatalf("WriteObject failed: %v", err)
+	}
+	contents, err := os.ReadFile(LoosePath(directory, other))
+	if err != nil {
+		t.Fatalf("Failed to read object file: %v", err)
+	}
+	if err := os.WriteFile(LoosePath(directory, hash), contents, 0644); err != nil {
+		t.Fatalf("Failed to write object file: %v", err)
+	}
+
+	// The tampered contents are trusted when core.verifyObjects is false, but not by VerifyObject
+	if obj, err := ReadObject(directory, hash); err != nil || string(obj.GetData()) != "tampered" {
+		t.Errorf("Expected the unverified contents, got %v", err)
+	}
+	if _, err := VerifyObject(directory, hash); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("Expected VerifyObject to detect the mismatch, got %v", err)
+	}
+}
+
+func TestWriteObjectSettings(t *testing.T) {
+	data := bytes.Repeat([]byte("compressible "), 1000)
+
+	sizes := make(map[int]int64)
+	for _, level := range []int{0, 9} {
+		directory := t.TempDir()
+		config := fmt.Sprintf("[core]\n\tcompression = %d\n\tfsync = loose-object\n", level)
+		if err := os.WriteFile(filepath.Join(directory, "config"), []byte(config), 0644); err != nil {
+			t.Fatalf("Failed to write config: %v", err)
+		}
+
+		hash, err := WriteObject(directory, CreateBlob(data))
+		if err != nil {
+			t.Fatalf("WriteObject failed: %v", err)
+		}
+		obj, err := ReadObject(directory, hash)
+		if err != nil || !bytes.Equal(obj.GetData(), data) {
+			t.Fatalf("Expected the object to read back at level %d, got %v", level, err)
+		}
+
+		info, err := os.Stat(LoosePath(directory, hash))
+		if err != nil {
+			t.Fatalf("Failed to stat object: %v", err)
+		}
+		sizes[level] = info.Size()
+
+		// Only the object is left in its directory, no temporary file
+		files, err := os.ReadDir(filepath.Dir(LoosePath(directory, hash)))
+		if err != nil || len(files) != 1 {
+			t.Errorf("Expected only the object file, got %v (%v)", files, err)
+		}
+	}
+
+	if sizes[0] <= sizes[9] || sizes[0] < int64(len(data)) {
+		t.Errorf("Expected level 0 to store the data uncompressed, got sizes %v", sizes)
+	}
+}
@@ -0,0 +1,205 @@
+package object
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"orf/oid"
+	"orf/repository"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// packedRefsFile holds refs moved out of their loose files by PackRefs, one "<hash> <name>"
+// line each. A loose ref file takes precedence over a packed entry of the same name.
+const packedRefsFile = "packed-refs"
+
+// readPackedRefs returns the refs in packed-refs by full name. Peeled tag lines ("^<hash>")
+// are skipped.
+func readPackedRefs(repo *repository.Repo) (map[string]oid.ObjectID, error) {
+	refs := make(map[string]oid.ObjectID)
+
+	file, err := os.Open(filepath.Join(repo.Directory, packedRefsFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return refs, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "^") {
+			continue
+		}
+
+		hash, name, ok := strings.Cut(line, " ")
+		if !ok {
+			return nil, fmt.Errorf("invalid line in %s: %s", packedRefsFile, line)
+		}
+		refs[name] = oid.ObjectID(hash)
+	}
+
+	return refs, scanner.Err()
+}
+
+// writePackedRefs replaces packed-refs through its lock file, which the caller holds.
+func writePackedRefs(repo *repository.Repo, lock *os.File, refs map[string]oid.ObjectID) error {
+	names := make([]string, 0, len(refs))
+	for name := range refs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	writer := bufio.NewWriter(lock)
+	fmt.Fprintln(writer, "# pack-refs with: sorted")
+	for _, name := range names {
+		fmt.Fprintf(writer, "%s %s\n", refs[name], name)
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	if err := lock.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(lock.Name(), filepath.Join(repo.Directory, packedRefsFile))
+}
+
+// lockFile creates <path>.lock, failing if another process holds it. The caller removes the
+// lock when done, or renames it over path to commit its contents.
+func lockFile(path string) (*os.File, error) {
+	lock, err := os.OpenFile(path+".lock", os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+	if errors.Is(err, os.ErrExist) {
+		return nil, fmt.Errorf("unable to lock %s: %s.lock exists; another process may be running", filepath.Base(path), path)
+	}
+	return lock, err
+}
+
+// unlock closes a lock file and removes it, unless it was already renamed into place.
+func unlock(lock *os.File) {
+	lock.Close()
+	os.Remove(lock.Name())
+}
+
+// PackRefs moves every loose ref under refs/ into packed-refs and removes the loose files,
+// returning the number of refs packed. Symbolic refs are left alone, as is a loose file that
+// changes while the refs are packed.
+func PackRefs(repo *repository.Repo) (int, error) {
+	lock, err := lockFile(filepath.Join(repo.Directory, packedRefsFile))
+	if err != nil {
+		return 0, err
+	}
+	defer unlock(lock)
+
+	refs, err := readPackedRefs(repo)
+	if err != nil {
+		return 0, err
+	}
+
+	loose, err := looseRefs(repo)
+	if err != nil {
+		return 0, err
+	}
+	for name, hash := range loose {
+		refs[name] = hash
+	}
+
+	if err := writePackedRefs(repo, lock, refs); err != nil {
+		return 0, err
+	}
+
+	for name, hash := range loose {
+		path := filepath.Join(repo.Directory, filepath.FromSlash(name))
+		if current, err := os.ReadFile(path); err != nil || strings.TrimSpace(string(current)) != hash.String() {
+			continue
+		}
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return 0, err
+		}
+		removeEmptyRefDirs(repo, filepath.Dir(path))
+	}
+
+	return len(loose), nil
+}
+
+// looseRefs returns the refs under refs/ stored in their own files and holding a hash.
+func looseRefs(repo *repository.Repo) (map[string]oid.ObjectID, error) {
+	refs := make(map[string]oid.ObjectID)
+
+	err := filepath.WalkDir(filepath.Join(repo.Directory, "refs"), func(path string, entry os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		hash, err := oid.FromHex(string(content))
+		if err != nil {
+			return nil
+		}
+
+		name, err := filepath.Rel(repo.Directory, path)
+		if err != nil {
+			return err
+		}
+		refs[filepath.ToSlash(name)] = hash
+		return nil
+	})
+	if errors.Is(err, os.ErrNotExist) {
+		return refs, nil
+	}
+
+	return refs, err
+}
+
+// removeEmptyRefDirs removes dir and its parents while they are empty, stopping at refs/ and
+// at the refs/heads and refs/tags directories every repository has.
+func removeEmptyRefDirs(repo *repository.Repo, dir string) {
+	keep := map[string]bool{
+		filepath.Join(repo.Directory, "refs"):          true,
+		filepath.Join(repo.Directory, "refs", "heads"): true,
+		filepath.Join(repo.Directory, "refs", "tags"):  true,
+	}
+
+	for strings.HasPrefix(dir, filepath.Join(repo.Directory, "refs")) && !keep[dir] {
+		if err := os.Remove(dir); err != nil {
+			return
+		}
+		dir = filepath.Dir(dir)
+	}
+}
+
+// deletePackedRef removes a ref from packed-refs, if it is there.
+func deletePackedRef(repo *repository.Repo, name string) error {
+	refs, err := readPackedRefs(repo)
+	if err != nil {
+		return err
+	}
+	if _, ok := refs[name]; !ok {
+		return nil
+	}
+
+	lock, err := lockFile(filepath.Join(repo.Directory, packedRefsFile))
+	if err != nil {
+		return err
+	}
+	defer unlock(lock)
+
+	// Read again under the lock, in case another process changed the file meanwhile
+	if refs, err = readPackedRefs(repo); err != nil {
+		return err
+	}
+	delete(refs, name)
+
+	return writePackedRefs(repo, lock, refs)
+}
@@ -0,0 +1,82 @@
+package object
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPackRefs(t *testing.T) {
+	history := createTestHistory(t)
+	repo := history.repo
+	writeTestRef(t, repo, "refs/heads/topic/one", history.side)
+	writeTestRef(t, repo, "refs/tags/v1", history.root)
+
+	before, err := ListAllRefs(repo)
+	if err != nil {
+		t.Fatalf("ListAllRefs failed: %v", err)
+	}
+
+	packed, err := PackRefs(repo)
+	if err != nil {
+		t.Fatalf("PackRefs failed: %v", err)
+	}
+	if packed != len(before) {
+		t.Errorf("Expected %d refs packed, got %d", len(before), packed)
+	}
+
+	for _, path := range []string{"refs/heads/master", "refs/heads/topic", "refs/tags/v1"} {
+		if _, err := os.Stat(filepath.Join(repo.Directory, path)); !os.IsNotExist(err) {
+			t.Errorf("Expected %s to be removed, got %v", path, err)
+		}
+	}
+	for _, path := range []string{"refs/heads", "refs/tags", "packed-refs"} {
+		if _, err := os.Stat(filepath.Join(repo.Directory, path)); err != nil {
+			t.Errorf("Expected %s to exist: %v", path, err)
+		}
+	}
+
+	after, err := ListAllRefs(repo)
+	if err != nil {
+		t.Fatalf("ListAllRefs failed: %v", err)
+	}
+	if len(after) != len(before) {
+		t.Errorf("Expected refs %v, got %v", before, after)
+	}
+	for name, hash := range before {
+		if after[name] != hash {
+			t.Errorf("Expected %s at %s, got %s", name, hash, after[name])
+		}
+	}
+
+	for name, expected := range map[string]string{"master": history.merge.String(), "topic/one": history.side.String(), "v1": history.root.String(), "HEAD": history.merge.String()} {
+		if hash, err := ResolveRevision(repo, name); err != nil || hash.String() != expected {
+			t.Errorf("ResolveRevision(%s) = %s (%v), expected %s", name, hash, err, expected)
+		}
+	}
+
+	// A loose ref written later takes precedence over its packed entry
+	if err := UpdateRef(repo, "HEAD", history.second, "Test <test@example.com>", "reset: moving to second"); err != nil {
+		t.Fatalf("UpdateRef failed: %v", err)
+	}
+	if hash, err := ResolveRevision(repo, "master"); err != nil || hash != history.second {
+		t.Errorf("Expected master at %s, got %s (%v)", history.second, hash, err)
+	}
+
+	// Deleting a packed ref removes its entry
+	if err := DeleteRef(repo, "refs/tags/v1"); err != nil {
+		t.Fatalf("DeleteRef failed: %v", err)
+	}
+	if _, err := ResolveRevision(repo, "v1"); err == nil {
+		t.Errorf("Expected v1 to be deleted")
+	}
+
+	// Packing is refused while another process holds the lock
+	lock := filepath.Join(repo.Directory, "packed-refs.lock")
+	if err := os.WriteFile(lock, nil, 0644); err != nil {
+		t.Fatalf("Failed to write lock: %v", err)
+	}
+	if _, err := PackRefs(repo); err == nil {
+		t.Errorf("Expected PackRefs to fail while packed-refs is locked")
+	}
+}
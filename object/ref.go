@@ -1,8 +1,10 @@
 package object
 
 import (
+	"errors"
 	"fmt"
 	"orf/kv"
+	"orf/oid"
 	"orf/repository"
 	"os"
 	"path/filepath"
@@ -10,6 +12,8 @@ import (
 	"strings"
 )
 
+// ListRefs returns the refs under path (the refs/ directory when empty) as nested maps, one
+// per directory level, holding the hash each ref points to. Packed refs are included.
 func ListRefs(repo *repository.Repo, path string) (*kv.OrderedMap, error) {
 
 	if path == "" {
@@ -20,36 +24,81 @@ func ListRefs(repo *repository.Repo, path string) (*kv.OrderedMap, error) {
 		path = newPath
 	}
 
-	output := kv.CreateOrderedMap()
+	refs := make(map[string]oid.ObjectID)
+	err := filepath.WalkDir(path, func(file string, entry os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			return nil
+		}
 
-	files, err := os.ReadDir(path)
+		data, err := resolveRef(repo, file)
+		if err != nil {
+			return fmt.Errorf("error resolving ref: %v", err)
+		}
+		name, err := filepath.Rel(path, file)
+		if err != nil {
+			return err
+		}
+		refs[filepath.ToSlash(name)] = data
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	sort.Slice(files, func(i, j int) bool {
-		return files[i].Name() < files[j].Name()
-	})
+	// Add the packed refs under path that have no loose file
+	prefix, err := filepath.Rel(repo.Directory, path)
+	if err != nil {
+		return nil, err
+	}
+	prefix = filepath.ToSlash(prefix) + "/"
+
+	packed, err := readPackedRefs(repo)
+	if err != nil {
+		return nil, err
+	}
+	for name, hash := range packed {
+		if _, ok := refs[strings.TrimPrefix(name, prefix)]; strings.HasPrefix(name, prefix) && !ok {
+			refs[strings.TrimPrefix(name, prefix)] = hash
+		}
+	}
 
-	for _, file := range files {
-		newPath := filepath.Join(path, file.Name())
+	return nestRefs(refs), nil
+}
 
-		if file.IsDir() {
-			refs, err := ListRefs(repo, newPath)
-			if err != nil {
-				return nil, err
+// nestRefs turns refs keyed by slash-separated name into nested maps, sorted by name at each level.
+func nestRefs(refs map[string]oid.ObjectID) *kv.OrderedMap {
+	names := make([][]string, 0, len(refs))
+	for name := range refs {
+		names = append(names, strings.Split(name, "/"))
+	}
+	sort.Slice(names, func(i, j int) bool {
+		a, b := names[i], names[j]
+		for k := 0; k < len(a) && k < len(b); k++ {
+			if a[k] != b[k] {
+				return a[k] < b[k]
 			}
-			output.Add(file.Name(), refs)
-		} else {
-			data, err := resolveRef(repo, newPath)
-			if err != nil {
-				return nil, fmt.Errorf("error resolving ref: %v", err)
+		}
+		return len(a) < len(b)
+	})
+
+	output := kv.CreateOrderedMap()
+	for _, components := range names {
+		level := output
+		for _, component := range components[:len(components)-1] {
+			next, ok := level.Get(component)
+			if !ok {
+				next = kv.CreateOrderedMap()
+				level.Add(component, next)
 			}
-			output.Add(file.Name(), data)
+			level = next.(*kv.OrderedMap)
 		}
+		level.Add(components[len(components)-1], refs[strings.Join(components, "/")])
 	}
 
-	return output, nil
+	return output
 }
 
 func ShowRef(repo *repository.Repo, refs *kv.OrderedMap, withHash bool, prefix string) {
@@ -58,7 +107,7 @@ func ShowRef(repo *repository.Repo, refs *kv.OrderedMap, withHash bool, prefix s
 	for _, k := range order {
 		v, _ := refs.Get(k)
 
-		if refStr, ok := v.(string); ok {
+		if refStr, ok := v.(oid.ObjectID); ok {
 			if withHash {
 				// Print the reference with the hash
 				fmt.Printf("%s %s%s\n", refStr, prefix, k)
@@ -79,7 +128,7 @@ func ShowRef(repo *repository.Repo, refs *kv.OrderedMap, withHash bool, prefix s
 	}
 }
 
-func CreateRef(repo *repository.Repo, refName string, target string) error {
+func CreateRef(repo *repository.Repo, refName string, target oid.ObjectID) error {
 
 	refPath, err := repository.GetFilePath(repo.Directory, true, "refs", refName)
 	if err != nil {
@@ -87,7 +136,7 @@ func CreateRef(repo *repository.Repo, refName string, target string) error {
 	}
 
 	// Open file in refs/refName, then write hash with newline
-	err = os.WriteFile(refPath, []byte(target+"\n"), 0644)
+	err = os.WriteFile(refPath, []byte(target.String()+"\n"), 0644)
 	if err != nil {
 		return err
 	}
@@ -95,14 +144,26 @@ func CreateRef(repo *repository.Repo, refName string, target string) error {
 	return nil
 }
 
-func resolveRef(repo *repository.Repo, ref string) (string, error) {
+// resolveRef reads a reference (relative to the .orf directory, or an absolute path) and
+// follows symbolic references ("ref: refs/heads/...") until it reaches an object hash.
+func resolveRef(repo *repository.Repo, ref string) (oid.ObjectID, error) {
 
-	path, err := repository.GetFilePath(repo.Directory, true, ref)
-	if err != nil {
-		return "", err
+	path := ref
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(repo.Directory, ref)
 	}
 
 	fileInfo, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) && !filepath.IsAbs(ref) && strings.HasPrefix(ref, "refs/") {
+		// Refs without a loose file may be in packed-refs
+		packed, packedErr := readPackedRefs(repo)
+		if packedErr != nil {
+			return "", packedErr
+		}
+		if hash, ok := packed[ref]; ok {
+			return hash, nil
+		}
+	}
 	if err != nil {
 		return "", err
 	}
@@ -112,34 +173,89 @@ func resolveRef(repo *repository.Repo, ref string) (string, error) {
 		return "", nil
 	}
 
-	// Open the file for reading
-	file, err := os.Open(path)
+	content, err := os.ReadFile(path)
 	if err != nil {
 		return "", err
 	}
-	defer file.Close()
 
-	var data string
-	_, err = fmt.Fscanf(file, "%s", &data)
-	if err != nil && err.Error() != "EOF" {
-		return "", err
+	// Drop the final \n (and any surrounding whitespace)
+	data := strings.TrimSpace(string(content))
+
+	// If the content starts with "ref: ", resolve it, otherwise return the data as is
+	if strings.HasPrefix(data, "ref: ") {
+		return resolveRef(repo, strings.TrimSpace(data[5:]))
 	}
 
-	// Drop final \n, similar to `data[:-1]` in Python
-	if len(data) > 0 && data[len(data)-1] == '\n' {
-		data = data[:len(data)-1]
+	return oid.ObjectID(data), nil
+}
+
+// ListAllRefs returns every reference under refs/ by full name (e.g. "refs/heads/master"),
+// mapped to the object hash it points to.
+func ListAllRefs(repo *repository.Repo) (map[string]oid.ObjectID, error) {
+	output := make(map[string]oid.ObjectID)
+
+	if _, err := os.Stat(filepath.Join(repo.Directory, "refs")); os.IsNotExist(err) {
+		return output, nil
 	}
 
-	// If the content starts with "ref: ", resolve it, otherwise return the data as is
-	if strings.HasPrefix(data, "ref: ") {
+	refs, err := ListRefs(repo, filepath.Join(repo.Directory, "refs"))
+	if err != nil {
+		return nil, err
+	}
 
-		data, err = resolveRef(repo, data[5:])
-		if err != nil {
-			return "", err
+	flattenRefs(refs, "refs", output)
+	return output, nil
+}
+
+// flattenRefs walks the nested map returned by ListRefs, joining names with "/".
+func flattenRefs(refs *kv.OrderedMap, prefix string, output map[string]oid.ObjectID) {
+	for _, k := range refs.GetOrder() {
+		v, _ := refs.Get(k)
+
+		switch value := v.(type) {
+		case oid.ObjectID:
+			if value != "" {
+				output[prefix+"/"+k] = value
+			}
+		case *kv.OrderedMap:
+			flattenRefs(value, prefix+"/"+k, output)
 		}
+	}
+}
 
-		return data, nil
+// SymbolicRef returns the ref a symbolic reference such as HEAD points to
+// (e.g. "refs/heads/master"), or "" if it holds a hash directly.
+func SymbolicRef(repo *repository.Repo, name string) (string, error) {
+	content, err := os.ReadFile(filepath.Join(repo.Directory, name))
+	if err != nil {
+		return "", err
 	}
 
-	return data, nil
+	data := strings.TrimSpace(string(content))
+	if strings.HasPrefix(data, "ref: ") {
+		return strings.TrimSpace(data[5:]), nil
+	}
+
+	return "", nil
+}
+
+// WriteSymbolicRef points a symbolic reference such as HEAD at another ref (e.g.
+// "refs/heads/master").
+func WriteSymbolicRef(repo *repository.Repo, name string, target string) error {
+	return os.WriteFile(filepath.Join(repo.Directory, name), []byte("ref: "+target+"\n"), 0644)
+}
+
+// DeleteRef removes a ref, loose or packed, and its reflog. Removing a ref that does not
+// exist is not an error.
+func DeleteRef(repo *repository.Repo, name string) error {
+	if err := deletePackedRef(repo, name); err != nil {
+		return err
+	}
+
+	for _, path := range []string{filepath.Join(repo.Directory, filepath.FromSlash(name)), reflogPath(repo, name)} {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
 }
@@ -0,0 +1,213 @@
+package object
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"orf/oid"
+	"orf/repository"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ReflogEntry records one update of a ref: its old and new values, who made the
+// change and when, and a short description such as "commit: Fix the parser".
+type ReflogEntry struct {
+	Old       oid.ObjectID
+	New       oid.ObjectID
+	Committer *Signature
+	Message   string
+}
+
+// String formats the entry as a reflog line: "<old> <new> <signature>\t<message>".
+func (entry *ReflogEntry) String() string {
+	return fmt.Sprintf("%s %s %s\t%s", entry.Old, entry.New, entry.Committer, entry.Message)
+}
+
+// reflogPath returns the path of the reflog for a ref, e.g. .orf/logs/refs/heads/master.
+func reflogPath(repo *repository.Repo, ref string) string {
+	return filepath.Join(repo.Directory, "logs", filepath.FromSlash(ref))
+}
+
+// ReadReflog returns the entries of a ref's reflog, oldest first. A ref without a
+// reflog has no entries.
+func ReadReflog(repo *repository.Repo, ref string) ([]ReflogEntry, error) {
+	file, err := os.Open(reflogPath(repo, ref))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var entries []ReflogEntry
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+
+		entry, err := parseReflogEntry(line)
+		if err != nil {
+			return nil, fmt.Errorf("error reading reflog for %s: %v", ref, err)
+		}
+		entries = append(entries, *entry)
+	}
+
+	return entries, scanner.Err()
+}
+
+func parseReflogEntry(line string) (*ReflogEntry, error) {
+	header, message, _ := strings.Cut(line, "\t")
+
+	fields := strings.SplitN(header, " ", 3)
+	if len(fields) != 3 {
+		return nil, fmt.Errorf("invalid reflog entry: %s", line)
+	}
+
+	committer, err := ParseSignature(fields[2])
+	if err != nil {
+		return nil, err
+	}
+
+	return &ReflogEntry{
+		Old:       oid.ObjectID(fields[0]),
+		New:       oid.ObjectID(fields[1]),
+		Committer: committer,
+		Message:   message,
+	}, nil
+}
+
+// AppendReflog adds an entry to the end of a ref's reflog.
+func AppendReflog(repo *repository.Repo, ref string, entry *ReflogEntry) error {
+	path := reflogPath(repo, ref)
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = fmt.Fprintln(file, entry.String())
+	return err
+}
+
+// WriteReflog replaces the entries of a ref's reflog, oldest first. Writing no entries
+// removes the reflog.
+func WriteReflog(repo *repository.Repo, ref string, entries []ReflogEntry) error {
+	path := reflogPath(repo, ref)
+	if len(entries) == 0 {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+
+	var data strings.Builder
+	for _, entry := range entries {
+		data.WriteString(entry.String() + "\n")
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return err
+	}
+	return os.WriteFile(path, []byte(data.String()), 0644)
+}
+
+// UpdateRef points a ref at target and records the change in its reflog. Symbolic refs
+// such as HEAD are followed, and both HEAD and the branch it points to are logged.
+// identity is the "Name <email>" of whoever made the change.
+func UpdateRef(repo *repository.Repo, ref string, target oid.ObjectID, identity string, message string) error {
+	refs := []string{ref}
+	for {
+		symbolic, err := SymbolicRef(repo, refs[len(refs)-1])
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		if symbolic == "" {
+			break
+		}
+		if len(refs) > 10 {
+			return fmt.Errorf("too many levels of symbolic refs: %s", ref)
+		}
+		refs = append(refs, symbolic)
+	}
+
+	return writeRef(repo, refs, target, identity, message)
+}
+
+// DetachHead points HEAD directly at target, leaving the branch it pointed to unchanged,
+// and records the change in HEAD's reflog.
+func DetachHead(repo *repository.Repo, target oid.ObjectID, identity string, message string) error {
+	return writeRef(repo, []string{"HEAD"}, target, identity, message)
+}
+
+// writeRef points the last of a chain of refs at target and logs the change in the reflog
+// of every ref in the chain.
+func writeRef(repo *repository.Repo, refs []string, target oid.ObjectID, identity string, message string) error {
+	name := refs[len(refs)-1]
+	old, err := resolveRef(repo, name)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if old == "" {
+		old = target.Algorithm().Zero()
+	}
+
+	path := filepath.Join(repo.Directory, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, []byte(target.String()+"\n"), 0644); err != nil {
+		return err
+	}
+
+	committer, err := ParseSignature(fmt.Sprintf("%s %d %s", identity, time.Now().Unix(), time.Now().Format("-0700")))
+	if err != nil {
+		return err
+	}
+
+	entry := &ReflogEntry{Old: old, New: target, Committer: committer, Message: message}
+	for _, logged := range refs {
+		if err := AppendReflog(repo, logged, entry); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ListReflogs returns the names of every ref with a reflog (e.g. "HEAD" or
+// "refs/heads/master"), in order.
+func ListReflogs(repo *repository.Repo) ([]string, error) {
+	logsDir := filepath.Join(repo.Directory, "logs")
+
+	var refs []string
+	err := filepath.WalkDir(logsDir, func(path string, entry os.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) && path == logsDir {
+				return nil
+			}
+			return err
+		}
+		if entry.IsDir() {
+			return nil
+		}
+
+		name, err := filepath.Rel(logsDir, path)
+		if err != nil {
+			return err
+		}
+		refs = append(refs, filepath.ToSlash(name))
+		return nil
+	})
+
+	return refs, err
+}
@@ -0,0 +1,129 @@
+package object
+
+import (
+	"errors"
+	"fmt"
+	"orf/oid"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestUpdateRefWritesReflog(t *testing.T) {
+	history := createTestHistory(t)
+	repo := history.repo
+
+	if err := os.Remove(filepath.Join(repo.Directory, "refs", "heads", "master")); err != nil {
+		t.Fatalf("Failed to remove master: %v", err)
+	}
+
+	if err := UpdateRef(repo, "HEAD", history.root, "Test <test@example.com>", "commit (initial): Initial commit"); err != nil {
+		t.Fatalf("UpdateRef failed: %v", err)
+	}
+	if err := UpdateRef(repo, "HEAD", history.second, "Test <test@example.com>", "commit: Second commit"); err != nil {
+		t.Fatalf("UpdateRef failed: %v", err)
+	}
+
+	hash, err := ResolveRevision(repo, "master")
+	if err != nil || hash != history.second {
+		t.Errorf("Expected master at %s, got %s (%v)", history.second, hash, err)
+	}
+
+	for _, ref := range []string{"HEAD", "refs/heads/master"} {
+		entries, err := ReadReflog(repo, ref)
+		if err != nil {
+			t.Fatalf("ReadReflog(%s) failed: %v", ref, err)
+		}
+
+		if len(entries) != 2 {
+			t.Fatalf("Expected 2 entries in %s reflog, got %d", ref, len(entries))
+		}
+
+		if !entries[0].Old.IsZero() || entries[0].New != history.root {
+			t.Errorf("Unexpected first entry %+v", entries[0])
+		}
+		if entries[1].Old != history.root || entries[1].New != history.second || entries[1].Message != "commit: Second commit" {
+			t.Errorf("Unexpected second entry %+v", entries[1])
+		}
+		if entries[1].Committer.Name != "Test" || entries[1].Committer.Email != "test@example.com" {
+			t.Errorf("Unexpected committer %+v", entries[1].Committer)
+		}
+	}
+
+	// A branch that was never updated has no reflog
+	entries, err := ReadReflog(repo, "refs/heads/side")
+	if err != nil || len(entries) != 0 {
+		t.Errorf("Expected an empty reflog, got %v (%v)", entries, err)
+	}
+}
+
+func TestUpdateRefDetachedHead(t *testing.T) {
+	history := createTestHistory(t)
+	repo := history.repo
+
+	if err := os.WriteFile(filepath.Join(repo.Directory, "HEAD"), []byte(history.merge.String()+"\n"), 0644); err != nil {
+		t.Fatalf("Failed to detach HEAD: %v", err)
+	}
+
+	if err := UpdateRef(repo, "HEAD", history.side, "Test <test@example.com>", "checkout: moving to side"); err != nil {
+		t.Fatalf("UpdateRef failed: %v", err)
+	}
+
+	if symbolic, err := SymbolicRef(repo, "HEAD"); err != nil || symbolic != "" {
+		t.Errorf("Expected HEAD to stay detached, got %q (%v)", symbolic, err)
+	}
+
+	master, err := ResolveRevision(repo, "master")
+	if err != nil || master != history.merge {
+		t.Errorf("Expected master to be unchanged, got %s (%v)", master, err)
+	}
+
+	entries, err := ReadReflog(repo, "HEAD")
+	if err != nil || len(entries) != 1 || entries[0].Old != history.merge || entries[0].New != history.side {
+		t.Errorf("Unexpected HEAD reflog %+v (%v)", entries, err)
+	}
+}
+
+func TestWriteReflogAndDeleteRef(t *testing.T) {
+	history := createTestHistory(t)
+	repo := history.repo
+
+	for _, target := range []oid.ObjectID{history.root, history.second, history.side} {
+		if err := UpdateRef(repo, "refs/stash", target, "Test <test@example.com>", "WIP on master"); err != nil {
+			t.Fatalf("UpdateRef failed: %v", err)
+		}
+	}
+
+	// stash@{0} is the newest entry, stash@{2} the oldest
+	for n, expected := range []oid.ObjectID{history.side, history.second, history.root} {
+		hash, err := ResolveRevision(repo, fmt.Sprintf("stash@{%d}", n))
+		if err != nil || hash != expected {
+			t.Errorf("stash@{%d} = %s (%v); expected %s", n, hash, err, expected)
+		}
+	}
+	if _, err := ResolveRevision(repo, "stash@{3}"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound past the end of the reflog, got %v", err)
+	}
+
+	// Drop the middle entry
+	entries, err := ReadReflog(repo, "refs/stash")
+	if err != nil {
+		t.Fatalf("ReadReflog failed: %v", err)
+	}
+	if err := WriteReflog(repo, "refs/stash", append(entries[:1:1], entries[2:]...)); err != nil {
+		t.Fatalf("WriteReflog failed: %v", err)
+	}
+	if hash, err := ResolveRevision(repo, "stash@{1}"); err != nil || hash != history.root {
+		t.Errorf("stash@{1} = %s (%v) after dropping an entry; expected %s", hash, err, history.root)
+	}
+
+	if err := DeleteRef(repo, "refs/stash"); err != nil {
+		t.Fatalf("DeleteRef failed: %v", err)
+	}
+	if _, err := ResolveRevision(repo, "stash"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected stash to be gone, got %v", err)
+	}
+	if entries, err := ReadReflog(repo, "refs/stash"); err != nil || len(entries) != 0 {
+		t.Errorf("Expected no reflog after DeleteRef, got %v (%v)", entries, err)
+	}
+}
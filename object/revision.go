@@ -0,0 +1,570 @@
+package object
+
+import (
+	"container/heap"
+	"fmt"
+	"orf/index"
+	"orf/oid"
+	"orf/repository"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Range is a set of commits described by revision arguments: commits reachable from Include
+// but not from Exclude. Symmetric marks an `A...B` range, where the merge bases of the
+// included commits are excluded as well.
+type Range struct {
+	Include   []oid.ObjectID
+	Exclude   []oid.ObjectID
+	Symmetric bool
+}
+
+// operator is a single suffix applied to a revision, e.g. ~2, ^1 or ^{tree}.
+type operator struct {
+	kind byte // '~', '^' or '{'
+	n    int
+	arg  string
+}
+
+var pseudoRefRE = regexp.MustCompile(`^[A-Z_]*HEAD$`)
+
+// ResolveRevision resolves a revision expression to a single object hash. Supported forms:
+//
+//	<name>          HEAD, @, a hash prefix, a full refs/... name, a tag, branch or remote-tracking branch
+//	<name>@{<n>}    the n-th previous value of a ref, from its reflog (@{<n>} for HEAD)
+//	<rev>~<n>       the n-th first-parent ancestor
+//	<rev>^<n>       the n-th parent (^0 is the commit itself)
+//	<rev>^{<type>}  the object peeled to a commit, tree, blob or tag (^{} peels tags)
+//	<rev>^{/<re>}   the youngest commit reachable from rev whose message matches
+//	<rev>:<path>    the object at path in the revision's tree
+//	:<path>         the blob staged in the index (:<n>:<path> for stage n)
+//	:/<regex>       the youngest commit reachable from any ref whose message matches
+//
+// It returns an error wrapping ErrNotFound if nothing matches, or an *AmbiguousError.
+func ResolveRevision(repo *repository.Repo, spec string) (oid.ObjectID, error) {
+
+	if strings.TrimSpace(spec) == "" {
+		return "", fmt.Errorf("%w: empty revision", ErrNotFound)
+	}
+
+	// Commit message search from every ref
+	if strings.HasPrefix(spec, ":/") {
+		starts, err := allRefHeads(repo)
+		if err != nil {
+			return "", err
+		}
+		return searchMessage(repo, starts, spec[2:])
+	}
+
+	// Path in the index
+	if strings.HasPrefix(spec, ":") {
+		return resolveIndexPath(repo, spec[1:])
+	}
+
+	// Path in a revision's tree
+	if colon := findPathSeparator(spec); colon != -1 {
+		hash, err := ResolveRevision(repo, spec[:colon])
+		if err != nil {
+			return "", err
+		}
+
+		treeHash, err := peel(repo, hash, "tree")
+		if err != nil {
+			return "", err
+		}
+
+		return LookupPath(repo, treeHash, spec[colon+1:])
+	}
+
+	name, operators, err := splitOperators(spec)
+	if err != nil {
+		return "", err
+	}
+
+	hash, err := resolveName(repo, name)
+	if err != nil {
+		return "", err
+	}
+
+	for _, op := range operators {
+		hash, err = applyOperator(repo, hash, op)
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", spec, err)
+		}
+	}
+
+	return hash, nil
+}
+
+// ResolveCommit resolves a revision expression and peels it to a commit.
+func ResolveCommit(repo *repository.Repo, spec string) (oid.ObjectID, error) {
+	hash, err := ResolveRevision(repo, spec)
+	if err != nil {
+		return "", err
+	}
+	return peel(repo, hash, "commit")
+}
+
+// ParseRange resolves revision arguments into a Range. Arguments may be single revisions
+// (included), `^A` (excluded), `A..B` (B included, A excluded) or `A...B` (symmetric difference).
+// An empty side of `..` or `...` defaults to HEAD.
+func ParseRange(repo *repository.Repo, args []string) (*Range, error) {
+	revRange := &Range{}
+
+	for _, arg := range args {
+		switch {
+		case strings.HasPrefix(arg, "^") && len(arg) > 1:
+			hash, err := ResolveCommit(repo, arg[1:])
+			if err != nil {
+				return nil, err
+			}
+			revRange.Exclude = append(revRange.Exclude, hash)
+
+		case !strings.Contains(arg, ":") && strings.Contains(arg, "..."):
+			parts := strings.SplitN(arg, "...", 2)
+			for _, part := range parts {
+				hash, err := ResolveCommit(repo, defaultHead(part))
+				if err != nil {
+					return nil, err
+				}
+				revRange.Include = append(revRange.Include, hash)
+			}
+			revRange.Symmetric = true
+
+		case !strings.Contains(arg, ":") && strings.Contains(arg, ".."):
+			parts := strings.SplitN(arg, "..", 2)
+			exclude, err := ResolveCommit(repo, defaultHead(parts[0]))
+			if err != nil {
+				return nil, err
+			}
+			include, err := ResolveCommit(repo, defaultHead(parts[1]))
+			if err != nil {
+				return nil, err
+			}
+			revRange.Exclude = append(revRange.Exclude, exclude)
+			revRange.Include = append(revRange.Include, include)
+
+		default:
+			hash, err := ResolveCommit(repo, arg)
+			if err != nil {
+				return nil, err
+			}
+			revRange.Include = append(revRange.Include, hash)
+		}
+	}
+
+	return revRange, nil
+}
+
+func defaultHead(name string) string {
+	if name == "" {
+		return "HEAD"
+	}
+	return name
+}
+
+// resolveName resolves a bare name (no suffix operators) to exactly one hash.
+func resolveName(repo *repository.Repo, name string) (oid.ObjectID, error) {
+	if match := reflogSelectorRE.FindStringSubmatch(name); match != nil {
+		n, err := strconv.Atoi(match[2])
+		if err != nil {
+			return "", fmt.Errorf("invalid revision %s: %v", name, err)
+		}
+		return resolveReflogSelector(repo, match[1], n)
+	}
+
+	if name == "" {
+		return "", fmt.Errorf("%w: missing revision name", ErrNotFound)
+	}
+
+	candidates, err := ResolveObject(repo, name)
+	if err != nil {
+		return "", err
+	}
+
+	if len(candidates) == 0 {
+		return "", fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+
+	if len(candidates) > 1 {
+		return "", &AmbiguousError{Name: name, Candidates: candidates}
+	}
+
+	return candidates[0], nil
+}
+
+// reflogSelectorRE matches <name>@{<n>}.
+var reflogSelectorRE = regexp.MustCompile(`^(.*)@\{([0-9]+)\}$`)
+
+// resolveReflogSelector returns the value a ref had n updates ago, from its reflog. An empty
+// name (or @) selects HEAD.
+func resolveReflogSelector(repo *repository.Repo, name string, n int) (oid.ObjectID, error) {
+	ref, err := refName(repo, name)
+	if err != nil {
+		return "", err
+	}
+
+	entries, err := ReadReflog(repo, ref)
+	if err != nil {
+		return "", err
+	}
+	if n >= len(entries) {
+		return "", fmt.Errorf("%w: log for %s only has %d entries", ErrNotFound, ref, len(entries))
+	}
+	return entries[len(entries)-1-n].New, nil
+}
+
+// refName returns the full name of the ref a short name refers to, trying the same places
+// as ResolveObject: e.g. "master" is "refs/heads/master" and "stash" is "refs/stash".
+func refName(repo *repository.Repo, name string) (string, error) {
+	if name == "" || name == "@" {
+		return "HEAD", nil
+	}
+	if pseudoRefRE.MatchString(name) || strings.HasPrefix(name, "refs/") {
+		return name, nil
+	}
+
+	for _, ref := range []string{"refs/" + name, "refs/tags/" + name, "refs/heads/" + name, "refs/remotes/" + name} {
+		if hash, err := resolveRef(repo, ref); err == nil && hash != "" {
+			return ref, nil
+		}
+	}
+	return "", fmt.Errorf("%w: %s", ErrNotFound, name)
+}
+
+// findPathSeparator returns the index of the first ':' outside of a ^{...} block, or -1.
+func findPathSeparator(spec string) int {
+	depth := 0
+	for i, c := range spec {
+		switch c {
+		case '{':
+			depth++
+		case '}':
+			depth--
+		case ':':
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// splitOperators splits a revision into its base name and its suffix operators.
+func splitOperators(spec string) (string, []operator, error) {
+	end := strings.IndexAny(spec, "~^")
+	if end == -1 {
+		return spec, nil, nil
+	}
+
+	name := spec[:end]
+	rest := spec[end:]
+
+	var operators []operator
+	for len(rest) > 0 {
+		kind := rest[0]
+		rest = rest[1:]
+
+		// ^{type} and ^{/regex}
+		if kind == '^' && strings.HasPrefix(rest, "{") {
+			closeIndex := strings.Index(rest, "}")
+			if closeIndex == -1 {
+				return "", nil, fmt.Errorf("invalid revision %s: unterminated ^{", spec)
+			}
+			operators = append(operators, operator{kind: '{', arg: rest[1:closeIndex]})
+			rest = rest[closeIndex+1:]
+			continue
+		}
+
+		if kind != '~' && kind != '^' {
+			return "", nil, fmt.Errorf("invalid revision %s", spec)
+		}
+
+		// Count defaults to 1 (HEAD~ == HEAD~1, HEAD^ == HEAD^1)
+		digits := 0
+		for digits < len(rest) && rest[digits] >= '0' && rest[digits] <= '9' {
+			digits++
+		}
+
+		n := 1
+		if digits > 0 {
+			value, err := strconv.Atoi(rest[:digits])
+			if err != nil {
+				return "", nil, fmt.Errorf("invalid revision %s: %v", spec, err)
+			}
+			n = value
+		}
+
+		operators = append(operators, operator{kind: kind, n: n})
+		rest = rest[digits:]
+	}
+
+	return name, operators, nil
+}
+
+// applyOperator applies one suffix operator to a hash.
+func applyOperator(repo *repository.Repo, hash oid.ObjectID, op operator) (oid.ObjectID, error) {
+	switch op.kind {
+	case '~':
+		hash, err := peel(repo, hash, "commit")
+		if err != nil {
+			return "", err
+		}
+
+		for i := 0; i < op.n; i++ {
+			commit, err := ReadCommit(repo, hash)
+			if err != nil {
+				return "", err
+			}
+
+			parents := commit.Parents()
+			if len(parents) == 0 {
+				return "", fmt.Errorf("%w: commit %s has no parent", ErrNotFound, hash)
+			}
+			hash = parents[0]
+		}
+		return hash, nil
+
+	case '^':
+		commitHash, err := peel(repo, hash, "commit")
+		if err != nil {
+			return "", err
+		}
+
+		if op.n == 0 {
+			return commitHash, nil
+		}
+
+		commit, err := ReadCommit(repo, commitHash)
+		if err != nil {
+			return "", err
+		}
+
+		parents := commit.Parents()
+		if op.n > len(parents) {
+			return "", fmt.Errorf("%w: commit %s has no parent %d", ErrNotFound, commitHash, op.n)
+		}
+		return parents[op.n-1], nil
+
+	default:
+		if strings.HasPrefix(op.arg, "/") {
+			commitHash, err := peel(repo, hash, "commit")
+			if err != nil {
+				return "", err
+			}
+			return searchMessage(repo, []oid.ObjectID{commitHash}, op.arg[1:])
+		}
+
+		return peel(repo, hash, op.arg)
+	}
+}
+
+// peel follows tags (and commits to their trees, when asking for a tree) until it reaches
+// an object of the given format. An empty format peels tags only; "object" accepts anything.
+func peel(repo *repository.Repo, hash oid.ObjectID, format string) (oid.ObjectID, error) {
+	for {
+		if format == "object" {
+			return hash, nil
+		}
+
+		obj, err := ReadObject(repo.Directory, hash)
+		if err != nil {
+			return "", err
+		}
+
+		if obj.GetFormat() == format || (format == "" && obj.GetFormat() != "tag") {
+			return hash, nil
+		}
+
+		switch o := obj.(type) {
+		case *Tag:
+			if o.Target() == "" {
+				return "", fmt.Errorf("tag %s does not have an object", hash)
+			}
+			hash = o.Target()
+
+		case *Commit:
+			if format != "tree" {
+				return "", &WrongTypeError{ID: hash, Have: "commit", Want: format}
+			}
+			hash = o.TreeHash()
+
+		default:
+			return "", &WrongTypeError{ID: hash, Have: obj.GetFormat(), Want: format}
+		}
+	}
+}
+
+// LookupPath finds the object at a slash-separated path within a tree. It returns an error
+// wrapping ErrNotFound if the path does not exist.
+func LookupPath(repo *repository.Repo, treeHash oid.ObjectID, path string) (oid.ObjectID, error) {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return treeHash, nil
+	}
+
+	hash := treeHash
+	components := strings.Split(path, "/")
+
+	for i, component := range components {
+		obj, err := ReadObject(repo.Directory, hash)
+		if err != nil {
+			return "", err
+		}
+
+		tree, ok := obj.(*Tree)
+		if !ok {
+			return "", fmt.Errorf("%w: path %s (%s is not a tree)", ErrNotFound, path, strings.Join(components[:i], "/"))
+		}
+
+		found := false
+		for _, leaf := range tree.Leaves {
+			if leaf.Path == component {
+				hash = leaf.Hash
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return "", fmt.Errorf("%w: path %s", ErrNotFound, path)
+		}
+	}
+
+	return hash, nil
+}
+
+// resolveIndexPath resolves "<path>" or "<stage>:<path>" against the index.
+func resolveIndexPath(repo *repository.Repo, spec string) (oid.ObjectID, error) {
+	stage := uint16(0)
+	if len(spec) > 2 && spec[1] == ':' && spec[0] >= '0' && spec[0] <= '3' {
+		stage = uint16(spec[0] - '0')
+		spec = spec[2:]
+	}
+
+	indx, err := index.ReadIndex(repo)
+	if err != nil {
+		return "", err
+	}
+
+	for _, entry := range indx.Entries {
+		if entry.Name == spec && entry.FlagStaged>>12 == stage {
+			return entry.Sha, nil
+		}
+	}
+
+	return "", fmt.Errorf("%w: path %s in the index (stage %d)", ErrNotFound, spec, stage)
+}
+
+// allRefHeads returns the commits pointed to by HEAD and every ref.
+func allRefHeads(repo *repository.Repo) ([]oid.ObjectID, error) {
+	var starts []oid.ObjectID
+
+	if head, err := resolveRef(repo, "HEAD"); err == nil && head != "" {
+		starts = append(starts, head)
+	}
+
+	refs, err := ListAllRefs(repo)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, hash := range refs {
+		if commitHash, err := peel(repo, hash, "commit"); err == nil {
+			starts = append(starts, commitHash)
+		}
+	}
+
+	return starts, nil
+}
+
+// searchMessage walks history from the given commits, youngest first, and returns the first
+// commit whose message matches the pattern.
+func searchMessage(repo *repository.Repo, starts []oid.ObjectID, pattern string) (oid.ObjectID, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return "", fmt.Errorf("invalid message pattern %s: %v", pattern, err)
+	}
+
+	queue := &commitQueue{}
+	seen := make(map[oid.ObjectID]bool)
+
+	push := func(hash oid.ObjectID) error {
+		if seen[hash] {
+			return nil
+		}
+		seen[hash] = true
+
+		commit, err := ReadCommit(repo, hash)
+		if err != nil {
+			return err
+		}
+
+		var when time.Time
+		if committer, err := commit.Committer(); err == nil {
+			when = committer.When
+		}
+
+		heap.Push(queue, queuedCommit{hash: hash, commit: commit, when: when})
+		return nil
+	}
+
+	for _, start := range starts {
+		if err := push(start); err != nil {
+			return "", err
+		}
+	}
+
+	for queue.Len() > 0 {
+		next := heap.Pop(queue).(queuedCommit)
+
+		if re.MatchString(next.commit.Message()) {
+			return next.hash, nil
+		}
+
+		for _, parent := range next.commit.Parents() {
+			if err := push(parent); err != nil {
+				return "", err
+			}
+		}
+	}
+
+	return "", fmt.Errorf("%w: no commit message matches %s", ErrNotFound, pattern)
+}
+
+// ReadCommit reads an object and returns it as a commit, or a *WrongTypeError.
+func ReadCommit(repo *repository.Repo, hash oid.ObjectID) (*Commit, error) {
+	obj, err := ReadObject(repo.Directory, hash)
+	if err != nil {
+		return nil, err
+	}
+
+	commit, ok := obj.(*Commit)
+	if !ok {
+		return nil, &WrongTypeError{ID: hash, Have: obj.GetFormat(), Want: "commit"}
+	}
+
+	return commit, nil
+}
+
+// commitQueue is a max-heap of commits ordered by committer date.
+type queuedCommit struct {
+	hash   oid.ObjectID
+	commit *Commit
+	when   time.Time
+}
+
+type commitQueue []queuedCommit
+
+func (q commitQueue) Len() int            { return len(q) }
+func (q commitQueue) Less(i, j int) bool  { return q[i].when.After(q[j].when) }
+func (q commitQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *commitQueue) Push(x interface{}) { *q = append(*q, x.(queuedCommit)) }
+func (q *commitQueue) Pop() interface{} {
+	old := *q
+	item := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return item
+}
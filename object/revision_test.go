@@ -0,0 +1,405 @@
+package object
+
+import (
+	"errors"
+	"fmt"
+	"orf/index"
+	"orf/kv"
+	"orf/oid"
+	"orf/repository"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func createTestRepo(t *testing.T) *repository.Repo {
+	workTree := t.TempDir()
+	directory := filepath.Join(workTree, ".orf")
+
+	for _, dir := range []string{"objects", filepath.Join("refs", "heads"), filepath.Join("refs", "tags")} {
+		if err := os.MkdirAll(filepath.Join(directory, dir), os.ModePerm); err != nil {
+			t.Fatalf("Failed to create directories: %v", err)
+		}
+	}
+
+	if err := os.WriteFile(filepath.Join(directory, "HEAD"), []byte("ref: refs/heads/master\n"), 0644); err != nil {
+		t.Fatalf("Failed to write HEAD: %v", err)
+	}
+
+	return &repository.Repo{WorkTree: workTree, Directory: directory}
+}
+
+func writeTestBlob(t *testing.T, repo *repository.Repo, data string) oid.ObjectID {
+	hash, err := WriteObject(repo.Directory, CreateBlob([]byte(data)))
+	if err != nil {
+		t.Fatalf("Failed to write blob: %v", err)
+	}
+	return hash
+}
+
+func writeTestTree(t *testing.T, repo *repository.Repo, leaves ...*Leaf) oid.ObjectID {
+	tree := CreateTree(nil)
+	tree.Leaves = leaves
+
+	data, err := tree.Serialize()
+	if err != nil {
+		t.Fatalf("Failed to serialize tree: %v", err)
+	}
+
+	hash, err := WriteObject(repo.Directory, CreateTree(data))
+	if err != nil {
+		t.Fatalf("Failed to write tree: %v", err)
+	}
+	return hash
+}
+
+func writeTestCommit(t *testing.T, repo *repository.Repo, tree oid.ObjectID, parents []oid.ObjectID, when int64, message string) oid.ObjectID {
+	kvData := kv.CreateOrderedMap()
+	kvData.Add("tree", tree.String())
+	if len(parents) == 1 {
+		kvData.Add("parent", parents[0].String())
+	} else if len(parents) > 1 {
+		var values []string
+		for _, parent := range parents {
+			values = append(values, parent.String())
+		}
+		kvData.Add("parent", values)
+	}
+	kvData.Add("author", fmt.Sprintf("Test <test@example.com> %d +0000", when))
+	kvData.Add("committer", fmt.Sprintf("Test <test@example.com> %d +0000", when))
+	kvData.Add("message", []byte(message))
+
+	hash, err := WriteObject(repo.Directory, CreateCommit(kv.Serialize(kvData)))
+	if err != nil {
+		t.Fatalf("Failed to write commit: %v", err)
+	}
+	return hash
+}
+
+func writeTestRef(t *testing.T, repo *repository.Repo, name string, hash oid.ObjectID) {
+	path := filepath.Join(repo.Directory, name)
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		t.Fatalf("Failed to create ref directory: %v", err)
+	}
+	if err := os.WriteFile(path, []byte(hash.String()+"\n"), 0644); err != nil {
+		t.Fatalf("Failed to write ref %s: %v", name, err)
+	}
+}
+
+// testHistory builds:
+//
+//	root <- second <- merge (HEAD, master)
+//	     <- side   <-/
+type testHistory struct {
+	repo   *repository.Repo
+	tree   oid.ObjectID
+	root   oid.ObjectID
+	second oid.ObjectID
+	side   oid.ObjectID
+	merge  oid.ObjectID
+}
+
+func createTestHistory(t *testing.T) *testHistory {
+	repo := createTestRepo(t)
+	tree := writeTestTree(t, repo, &Leaf{Mode: []byte("100644"), Path: "file.txt", Hash: writeTestBlob(t, repo, "hello")})
+
+	history := &testHistory{repo: repo, tree: tree}
+	history.root = writeTestCommit(t, repo, tree, nil, 1000, "Initial commit")
+	history.second = writeTestCommit(t, repo, tree, []oid.ObjectID{history.root}, 2000, "Second commit")
+	history.side = writeTestCommit(t, repo, tree, []oid.ObjectID{history.root}, 3000, "Fix on side branch")
+	history.merge = writeTestCommit(t, repo, tree, []oid.ObjectID{history.second, history.side}, 4000, "Merge side")
+
+	writeTestRef(t, repo, "refs/heads/master", history.merge)
+	writeTestRef(t, repo, "refs/heads/side", history.side)
+
+	return history
+}
+
+func TestResolveRevisionAncestry(t *testing.T) {
+	history := createTestHistory(t)
+
+	tests := map[string]oid.ObjectID{
+		"HEAD":                       history.merge,
+		"@":                          history.merge,
+		"master":                     history.merge,
+		"refs/heads/side":            history.side,
+		"HEAD~":                      history.second,
+		"HEAD~1":                     history.second,
+		"HEAD~2":                     history.root,
+		"HEAD^":                      history.second,
+		"HEAD^2":                     history.side,
+		"HEAD^0":                     history.merge,
+		"HEAD^2~1":                   history.root,
+		"master^^":                   history.root,
+		"HEAD^{tree}":                history.tree,
+		"HEAD^{commit}":              history.merge,
+		history.side.Short():         history.side,
+		history.root.String() + "^0": history.root,
+	}
+
+	for spec, expected := range tests {
+		hash, err := ResolveRevision(history.repo, spec)
+		if err != nil {
+			t.Errorf("ResolveRevision(%q) failed: %v", spec, err)
+			continue
+		}
+		if hash != expected {
+			t.Errorf("ResolveRevision(%q) = %s; expected %s", spec, hash, expected)
+		}
+	}
+}
+
+func TestResolveRevisionTagsAndRemotes(t *testing.T) {
+	history := createTestHistory(t)
+
+	tagData := kv.CreateOrderedMap()
+	tagData.Add("object", history.second.String())
+	tagData.Add("type", "commit")
+	tagData.Add("tag", "v1.0")
+	tagData.Add("tagger", "Test <test@example.com> 5000 +0000")
+	tagData.Add("message", []byte("Release"))
+
+	tag, err := WriteObject(history.repo.Directory, CreateTag(kv.Serialize(tagData)))
+	if err != nil {
+		t.Fatalf("Failed to write tag: %v", err)
+	}
+
+	writeTestRef(t, history.repo, "refs/tags/v1.0", tag)
+	writeTestRef(t, history.repo, "refs/remotes/origin/main", history.side)
+	writeTestRef(t, history.repo, "refs/remotes/origin/HEAD", history.root)
+
+	tests := map[string]oid.ObjectID{
+		"v1.0":          tag,
+		"v1.0^{}":       history.second,
+		"v1.0^{commit}": history.second,
+		"v1.0^{tree}":   history.tree,
+		"v1.0~1":        history.root,
+		"origin/main":   history.side,
+		"origin":        history.root,
+	}
+
+	for spec, expected := range tests {
+		hash, err := ResolveRevision(history.repo, spec)
+		if err != nil {
+			t.Errorf("ResolveRevision(%q) failed: %v", spec, err)
+			continue
+		}
+		if hash != expected {
+			t.Errorf("ResolveRevision(%q) = %s; expected %s", spec, hash, expected)
+		}
+	}
+}
+
+func TestResolveRevisionMessageSearch(t *testing.T) {
+	history := createTestHistory(t)
+
+	hash, err := ResolveRevision(history.repo, ":/commit")
+	if err != nil {
+		t.Fatalf("ResolveRevision failed: %v", err)
+	}
+	if hash != history.second {
+		t.Errorf("Expected youngest matching commit %s, got %s", history.second, hash)
+	}
+
+	hash, err = ResolveRevision(history.repo, "HEAD^2^{/Initial}")
+	if err != nil {
+		t.Fatalf("ResolveRevision failed: %v", err)
+	}
+	if hash != history.root {
+		t.Errorf("Expected %s, got %s", history.root, hash)
+	}
+
+	if _, err := ResolveRevision(history.repo, ":/no such message"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestResolveRevisionErrors(t *testing.T) {
+	history := createTestHistory(t)
+
+	for _, spec := range []string{"missing", "HEAD~3", "HEAD^3", "refs/heads/missing", ""} {
+		if _, err := ResolveRevision(history.repo, spec); !errors.Is(err, ErrNotFound) {
+			t.Errorf("ResolveRevision(%q): expected ErrNotFound, got %v", spec, err)
+		}
+	}
+
+	if _, err := ResolveRevision(history.repo, "HEAD^{tree"); err == nil {
+		t.Errorf("Expected an error for an unterminated ^{")
+	}
+
+	writeTestRef(t, history.repo, "refs/tags/dup", history.root)
+	writeTestRef(t, history.repo, "refs/heads/dup", history.side)
+
+	_, err := ResolveRevision(history.repo, "dup")
+	var ambiguous *AmbiguousError
+	if !errors.As(err, &ambiguous) {
+		t.Fatalf("Expected an AmbiguousError, got %v", err)
+	}
+	if len(ambiguous.Candidates) != 2 {
+		t.Errorf("Expected 2 candidates, got %v", ambiguous.Candidates)
+	}
+
+	// Same target under two names is not ambiguous
+	writeTestRef(t, history.repo, "refs/tags/side", history.side)
+	if hash, err := ResolveRevision(history.repo, "side"); err != nil || hash != history.side {
+		t.Errorf("Expected side to resolve to %s, got %s (%v)", history.side, hash, err)
+	}
+}
+
+func TestParseRange(t *testing.T) {
+	history := createTestHistory(t)
+
+	revRange, err := ParseRange(history.repo, []string{"side..master"})
+	if err != nil {
+		t.Fatalf("ParseRange failed: %v", err)
+	}
+	if len(revRange.Include) != 1 || revRange.Include[0] != history.merge || len(revRange.Exclude) != 1 || revRange.Exclude[0] != history.side || revRange.Symmetric {
+		t.Errorf("Unexpected range for side..master: %+v", revRange)
+	}
+
+	revRange, err = ParseRange(history.repo, []string{"HEAD~1...side"})
+	if err != nil {
+		t.Fatalf("ParseRange failed: %v", err)
+	}
+	if len(revRange.Include) != 2 || revRange.Include[0] != history.second || revRange.Include[1] != history.side || !revRange.Symmetric {
+		t.Errorf("Unexpected range for HEAD~1...side: %+v", revRange)
+	}
+
+	revRange, err = ParseRange(history.repo, []string{"HEAD", "^side", "side.."})
+	if err != nil {
+		t.Fatalf("ParseRange failed: %v", err)
+	}
+	if len(revRange.Include) != 2 || len(revRange.Exclude) != 2 || revRange.Include[1] != history.merge || revRange.Exclude[0] != history.side {
+		t.Errorf("Unexpected range for HEAD ^side side..: %+v", revRange)
+	}
+}
+
+func TestParseSignature(t *testing.T) {
+	signature, err := ParseSignature("John Doe <johndoe@jd.oe> 1527025023 +0200")
+	if err != nil {
+		t.Fatalf("ParseSignature failed: %v", err)
+	}
+
+	if signature.Name != "John Doe" || signature.Email != "johndoe@jd.oe" {
+		t.Errorf("Unexpected name/email: %+v", signature)
+	}
+
+	if signature.When.Unix() != 1527025023 {
+		t.Errorf("Expected timestamp 1527025023, got %d", signature.When.Unix())
+	}
+
+	if signature.String() != "John Doe <johndoe@jd.oe> 1527025023 +0200" {
+		t.Errorf("Unexpected round trip: %s", signature.String())
+	}
+
+	if _, err := ParseSignature("no email 1234 +0000"); err == nil {
+		t.Errorf("Expected an error for a signature without an email")
+	}
+}
+
+func TestResolveRevisionPaths(t *testing.T) {
+	history := createTestHistory(t)
+	repo := history.repo
+
+	blob := writeTestBlob(t, repo, "nested")
+	subtree := writeTestTree(t, repo, &Leaf{Mode: []byte("100644"), Path: "inner.txt", Hash: blob})
+	root := writeTestTree(t, repo,
+		&Leaf{Mode: []byte("40000"), Path: "dir", Hash: subtree},
+		&Leaf{Mode: []byte("100644"), Path: "top.txt", Hash: blob},
+	)
+	commit := writeTestCommit(t, repo, root, []oid.ObjectID{history.merge}, 5000, "Add dir")
+	writeTestRef(t, repo, "refs/heads/master", commit)
+
+	tests := map[string]oid.ObjectID{
+		"HEAD:":              root,
+		"HEAD:top.txt":       blob,
+		"HEAD:dir":           subtree,
+		"HEAD:dir/inner.txt": blob,
+		"HEAD~1:file.txt":    mustLookup(t, repo, history.tree, "file.txt"),
+	}
+
+	for spec, expected := range tests {
+		hash, err := ResolveRevision(repo, spec)
+		if err != nil {
+			t.Errorf("ResolveRevision(%q) failed: %v", spec, err)
+			continue
+		}
+		if hash != expected {
+			t.Errorf("ResolveRevision(%q) = %s; expected %s", spec, hash, expected)
+		}
+	}
+
+	for _, spec := range []string{"HEAD:missing.txt", "HEAD:top.txt/inner.txt", "HEAD:dir/missing"} {
+		if _, err := ResolveRevision(repo, spec); !errors.Is(err, ErrNotFound) {
+			t.Errorf("ResolveRevision(%q): expected ErrNotFound, got %v", spec, err)
+		}
+	}
+}
+
+func TestResolveRevisionIndexPath(t *testing.T) {
+	history := createTestHistory(t)
+	repo := history.repo
+
+	ours := writeTestBlob(t, repo, "ours")
+	theirs := writeTestBlob(t, repo, "theirs")
+
+	entries := []index.IndexEntry{
+		{ModeType: 0b1000, ModePerms: 0o644, Sha: ours, FlagStaged: 0, Name: "clean.txt"},
+		{ModeType: 0b1000, ModePerms: 0o644, Sha: ours, FlagStaged: 2 << 12, Name: "conflict.txt"},
+		{ModeType: 0b1000, ModePerms: 0o644, Sha: theirs, FlagStaged: 3 << 12, Name: "conflict.txt"},
+	}
+	if err := index.CreateIndex(2, entries).WriteIndex(repo); err != nil {
+		t.Fatalf("WriteIndex failed: %v", err)
+	}
+
+	tests := map[string]oid.ObjectID{
+		":clean.txt":      ours,
+		":0:clean.txt":    ours,
+		":2:conflict.txt": ours,
+		":3:conflict.txt": theirs,
+	}
+
+	for spec, expected := range tests {
+		hash, err := ResolveRevision(repo, spec)
+		if err != nil {
+			t.Errorf("ResolveRevision(%q) failed: %v", spec, err)
+			continue
+		}
+		if hash != expected {
+			t.Errorf("ResolveRevision(%q) = %s; expected %s", spec, hash, expected)
+		}
+	}
+
+	if _, err := ResolveRevision(repo, ":conflict.txt"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for stage 0 of a conflicted path, got %v", err)
+	}
+}
+
+func TestSHA1Repository(t *testing.T) {
+	repo := createTestRepo(t)
+	if err := os.WriteFile(filepath.Join(repo.Directory, "config"), []byte("[core]\nrepositoryformatversion = 1\n[extensions]\nobjectformat = sha1\n"), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+
+	blob := writeTestBlob(t, repo, "hello")
+	if len(blob) != 40 {
+		t.Fatalf("Expected a SHA-1 id, got %s", blob)
+	}
+
+	tree := writeTestTree(t, repo, &Leaf{Mode: []byte("100644"), Path: "file.txt", Hash: blob})
+	commit := writeTestCommit(t, repo, tree, nil, 1000, "Initial commit")
+	writeTestRef(t, repo, "refs/heads/master", commit)
+
+	hash, err := ResolveRevision(repo, "HEAD:file.txt")
+	if err != nil || hash != blob {
+		t.Errorf("Expected %s, got %s (%v)", blob, hash, err)
+	}
+}
+
+func mustLookup(t *testing.T, repo *repository.Repo, tree oid.ObjectID, path string) oid.ObjectID {
+	hash, err := LookupPath(repo, tree, path)
+	if err != nil {
+		t.Fatalf("LookupPath failed: %v", err)
+	}
+	return hash
+}
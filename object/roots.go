@@ -0,0 +1,110 @@
+package object
+
+import (
+	"bufio"
+	"orf/index"
+	"orf/oid"
+	"orf/repository"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Root is an object kept alive by something other than an object: a ref, a HEAD-like file, a
+// reflog entry or an index entry. From names it (e.g. "refs/heads/master", "reflog of HEAD"
+// or "index") and Type is the type the object must have, or "" for any.
+type Root struct {
+	From string
+	ID   oid.ObjectID
+	Type string
+}
+
+// ListRoots returns every root of a repository: the objects that must be kept, along with
+// everything they lead to.
+func ListRoots(repo *repository.Repo) ([]Root, error) {
+	var roots []Root
+
+	refs, err := ListAllRefs(repo)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(refs))
+	for name := range refs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		roots = append(roots, Root{From: name, ID: refs[name]})
+	}
+
+	// HEAD, ORIG_HEAD, MERGE_HEAD (one line per merged commit) and the like. Symbolic refs
+	// point into refs/, which is already covered.
+	files, err := os.ReadDir(repo.Directory)
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		if file.IsDir() || !pseudoRefRE.MatchString(file.Name()) {
+			continue
+		}
+
+		ids, err := readIDs(filepath.Join(repo.Directory, file.Name()))
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range ids {
+			roots = append(roots, Root{From: file.Name(), ID: id})
+		}
+	}
+
+	reflogs, err := ListReflogs(repo)
+	if err != nil {
+		return nil, err
+	}
+	for _, ref := range reflogs {
+		entries, err := ReadReflog(repo, ref)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			for _, id := range []oid.ObjectID{entry.Old, entry.New} {
+				if !id.IsZero() {
+					roots = append(roots, Root{From: "reflog of " + ref, ID: id})
+				}
+			}
+		}
+	}
+
+	idx, err := index.ReadIndex(repo)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range idx.Entries {
+		roots = append(roots, Root{From: "index", ID: entry.Sha, Type: "blob"})
+	}
+
+	return roots, nil
+}
+
+// readIDs returns the object ids at the start of each line of a file such as MERGE_HEAD.
+func readIDs(path string) ([]oid.ObjectID, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var ids []oid.ObjectID
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if id, err := oid.FromHex(fields[0]); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	return ids, scanner.Err()
+}
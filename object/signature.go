@@ -0,0 +1,78 @@
+package object
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Signature is an author, committer or tagger line: "Name <email> <unix seconds> <+hhmm>".
+type Signature struct {
+	Name  string
+	Email string
+	When  time.Time
+}
+
+// ParseSignature parses a signature line into its name, email and timestamp (in its own timezone).
+func ParseSignature(line string) (*Signature, error) {
+	openIndex := strings.LastIndex(line, "<")
+	closeIndex := strings.LastIndex(line, ">")
+	if openIndex == -1 || closeIndex < openIndex {
+		return nil, fmt.Errorf("invalid signature: %s", line)
+	}
+
+	signature := &Signature{
+		Name:  strings.TrimSpace(line[:openIndex]),
+		Email: line[openIndex+1 : closeIndex],
+	}
+
+	fields := strings.Fields(line[closeIndex+1:])
+	if len(fields) == 0 {
+		return signature, nil
+	}
+
+	seconds, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid signature timestamp: %s", fields[0])
+	}
+
+	location := time.UTC
+	if len(fields) > 1 {
+		location, err = parseTimezone(fields[1])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	signature.When = time.Unix(seconds, 0).In(location)
+	return signature, nil
+}
+
+// String formats the signature back into its line form.
+func (signature *Signature) String() string {
+	return fmt.Sprintf("%s <%s> %d %s", signature.Name, signature.Email, signature.When.Unix(), signature.When.Format("-0700"))
+}
+
+// parseTimezone converts a "+hhmm" offset into a fixed time.Location.
+func parseTimezone(tz string) (*time.Location, error) {
+	if len(tz) != 5 || (tz[0] != '+' && tz[0] != '-') {
+		return nil, fmt.Errorf("invalid signature timezone: %s", tz)
+	}
+
+	hours, err := strconv.Atoi(tz[1:3])
+	if err != nil {
+		return nil, fmt.Errorf("invalid signature timezone: %s", tz)
+	}
+	minutes, err := strconv.Atoi(tz[3:5])
+	if err != nil {
+		return nil, fmt.Errorf("invalid signature timezone: %s", tz)
+	}
+
+	offset := hours*3600 + minutes*60
+	if tz[0] == '-' {
+		offset = -offset
+	}
+
+	return time.FixedZone(tz, offset), nil
+}
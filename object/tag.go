@@ -1,6 +1,9 @@
 package object
 
-import "orf/kv"
+import (
+	"orf/kv"
+	"orf/oid"
+)
 
 // Represents a tag object, with key-value data forming the tag message.
 type Tag struct {
@@ -52,3 +55,21 @@ func (tag *Tag) Deserialize(data []byte) error {
 	tag.kvData = kvData
 	return nil
 }
+
+// Target returns the hash of the object the tag points to.
+func (tag *Tag) Target() oid.ObjectID {
+	values := getValues(tag.kvData, "object")
+	if len(values) == 0 {
+		return ""
+	}
+	return oid.ObjectID(values[0])
+}
+
+// TargetType returns the type of the object the tag points to, e.g. "commit".
+func (tag *Tag) TargetType() string {
+	values := getValues(tag.kvData, "type")
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
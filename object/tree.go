@@ -2,22 +2,26 @@ package object
 
 import (
 	"bytes"
-	"encoding/hex"
 	"fmt"
+	"orf/oid"
+	"orf/repository"
 	"orf/utils"
 	"sort"
+	"strings"
 )
 
 // Represents a tree object, with leaves representing all Leaf objects.
+// Algorithm determines the width of the raw ids in the serialized leaves.
 type Tree struct {
 	Base
-	Leaves []*Leaf
+	Leaves    []*Leaf
+	Algorithm oid.Algorithm
 }
 
 type Leaf struct {
 	Mode []byte
 	Path string
-	Hash string
+	Hash oid.ObjectID
 }
 
 func CreateTree(data []byte) *Tree {
@@ -27,7 +31,8 @@ func CreateTree(data []byte) *Tree {
 			size:   uint32(len(data)), // Set the size based on the data length
 			data:   data,              // Set the data directly in the Object
 		},
-		Leaves: []*Leaf{},
+		Leaves:    []*Leaf{},
+		Algorithm: oid.Default,
 	}
 }
 
@@ -53,9 +58,9 @@ func (tree *Tree) Serialize() ([]byte, error) {
 		output = utils.Append(output, []byte(leaf.Path)...)
 		output = utils.Append(output, '\x00')
 
-		hashBytes, err := convertHexToBytes(leaf.Hash)
-		if err != nil {
-			return nil, err
+		hashBytes := leaf.Hash.Bytes()
+		if hashBytes == nil {
+			return nil, fmt.Errorf("invalid hash %q for %s", leaf.Hash, leaf.Path)
 		}
 
 		output = utils.Append(output, hashBytes...)
@@ -65,7 +70,12 @@ func (tree *Tree) Serialize() ([]byte, error) {
 }
 
 func (tree *Tree) Deserialize(data []byte) error {
-	leaves, err := parseTree(data)
+	algorithm := tree.Algorithm
+	if algorithm == "" {
+		algorithm = oid.Default
+	}
+
+	leaves, err := parseTree(data, algorithm.Size())
 	if err != nil {
 		return err
 	}
@@ -74,8 +84,9 @@ func (tree *Tree) Deserialize(data []byte) error {
 	return nil
 }
 
-// parseTree parses the byte data and constructs a list of Leaves (mode, path, hash).
-func parseTree(rawData []byte) ([]*Leaf, error) {
+// parseTree parses the byte data and constructs a list of Leaves (mode, path, hash),
+// reading hashSize raw bytes for each hash.
+func parseTree(rawData []byte, hashSize int) ([]*Leaf, error) {
 	startIndex := 0
 	maxLength := len(rawData)
 
@@ -84,7 +95,7 @@ func parseTree(rawData []byte) ([]*Leaf, error) {
 	var err error
 
 	for startIndex < maxLength {
-		startIndex, leaf, err = parseLeaf(rawData, startIndex)
+		startIndex, leaf, err = parseLeaf(rawData, startIndex, hashSize)
 		if err != nil {
 			return nil, err
 		}
@@ -93,7 +104,7 @@ func parseTree(rawData []byte) ([]*Leaf, error) {
 	return tree, nil
 }
 
-func parseLeaf(rawData []byte, startIndex int) (int, *Leaf, error) {
+func parseLeaf(rawData []byte, startIndex int, hashSize int) (int, *Leaf, error) {
 
 	modeIndex := utils.FindIndex(rawData, startIndex, ' ')
 	if modeIndex-startIndex != 5 && modeIndex-startIndex != 6 {
@@ -102,21 +113,45 @@ func parseLeaf(rawData []byte, startIndex int) (int, *Leaf, error) {
 
 	mode := rawData[startIndex:modeIndex]
 	if len(mode) == 5 {
-		mode = utils.Prepend(rawData, ' ')
+		mode = utils.Prepend(mode, '0')
 	}
 
 	pathIndex := utils.FindIndex(rawData, startIndex, '\x00')
+	if pathIndex == -1 || pathIndex+1+hashSize > len(rawData) {
+		return -1, nil, fmt.Errorf("error parsing leaf, truncated entry")
+	}
 	path := rawData[modeIndex+1 : pathIndex]
 
-	hash := hex.EncodeToString(rawData[pathIndex+1 : pathIndex+21])
+	hash, err := oid.FromBytes(rawData[pathIndex+1 : pathIndex+1+hashSize])
+	if err != nil {
+		return -1, nil, err
+	}
 
-	return pathIndex + 21, &Leaf{
+	return pathIndex + 1 + hashSize, &Leaf{
 		Mode: mode,
 		Path: string(path),
 		Hash: hash,
 	}, nil
 }
 
+// IsTree reports whether the leaf points to a subtree (directory).
+func (leaf *Leaf) IsTree() bool {
+	return string(leaf.Mode) == "40000" || string(leaf.Mode) == "040000"
+}
+
+// Type returns the type of the object a leaf points to: "tree" for subtrees, "commit" for
+// submodules (mode 160000) and "blob" for files and symlinks.
+func (leaf *Leaf) Type() string {
+	switch {
+	case leaf.IsTree():
+		return "tree"
+	case string(leaf.Mode) == "160000":
+		return "commit"
+	default:
+		return "blob"
+	}
+}
+
 // ByPath is a sort.Interface that follows these custom rules:
 // Directories (that is, tree entries) are sorted with a final / added.
 // It matters, because directories are sorted after files, and therefore is less than files.
@@ -144,16 +179,128 @@ func isModeDirectory(mode []byte) bool {
 	return bytes.HasPrefix(mode, []byte("10"))
 }
 
-// convertHexToBytes converts a hash string (in hex format) to a byte format, truncated by 20 bytes.
-func convertHexToBytes(hash string) ([]byte, error) {
-	result, err := hex.DecodeString(hash)
+// FlattenTree maps the slash-separated path of every blob under a tree to its hash.
+func FlattenTree(repo *repository.Repo, treeHash oid.ObjectID) (map[string]oid.ObjectID, error) {
+	leaves, err := ReadTreeLeaves(repo, treeHash)
 	if err != nil {
 		return nil, err
 	}
 
-	if len(result) > 20 {
-		result = result[:20]
+	output := make(map[string]oid.ObjectID, len(leaves))
+	for path, leaf := range leaves {
+		output[path] = leaf.Hash
+	}
+	return output, nil
+}
+
+// ReadTreeLeaves maps the slash-separated path of every blob under a tree to its leaf, whose
+// Path is set to the full path. An empty hash reads as an empty tree.
+func ReadTreeLeaves(repo *repository.Repo, treeHash oid.ObjectID) (map[string]*Leaf, error) {
+	output := make(map[string]*Leaf)
+	if treeHash == "" {
+		return output, nil
+	}
+
+	if err := readTreeLeaves(repo, treeHash, "", output); err != nil {
+		return nil, err
+	}
+	return output, nil
+}
+
+func readTreeLeaves(repo *repository.Repo, treeHash oid.ObjectID, prefix string, output map[string]*Leaf) error {
+	obj, err := ReadObject(repo.Directory, treeHash)
+	if err != nil {
+		return err
+	}
+
+	tree, ok := obj.(*Tree)
+	if !ok {
+		return &WrongTypeError{ID: treeHash, Have: obj.GetFormat(), Want: "tree"}
+	}
+
+	for _, leaf := range tree.Leaves {
+		path := prefix + leaf.Path
+
+		if leaf.IsTree() {
+			if err := readTreeLeaves(repo, leaf.Hash, path+"/", output); err != nil {
+				return err
+			}
+		} else {
+			output[path] = &Leaf{Mode: leaf.Mode, Path: path, Hash: leaf.Hash}
+		}
+	}
+
+	return nil
+}
+
+// WriteTreeLeaves writes the trees holding a set of blobs keyed by slash-separated path, and
+// returns the id of the root tree. A path cannot be both a blob and a directory of others.
+func WriteTreeLeaves(repo *repository.Repo, leaves map[string]*Leaf) (oid.ObjectID, error) {
+	children := make(map[string][]*Leaf)
+	directories := map[string]bool{"": true}
+
+	for path, leaf := range leaves {
+		dir, name := splitPath(path)
+		children[dir] = append(children[dir], &Leaf{Mode: leaf.Mode, Path: name, Hash: leaf.Hash})
+
+		for dir != "" {
+			if _, ok := leaves[dir]; ok {
+				return "", fmt.Errorf("%s is both a file and a directory", dir)
+			}
+			directories[dir] = true
+			dir, _ = splitPath(dir)
+		}
+	}
+
+	// Write the deepest directories first, so each subtree id is known before its parent
+	var paths []string
+	for dir := range directories {
+		paths = append(paths, dir)
+	}
+	sort.Slice(paths, func(i, j int) bool {
+		return depth(paths[i]) > depth(paths[j])
+	})
+
+	var root oid.ObjectID
+	for _, dir := range paths {
+		tree := CreateTree(nil)
+		tree.Leaves = children[dir]
+
+		data, err := tree.Serialize()
+		if err != nil {
+			return "", err
+		}
+
+		hash, err := WriteObject(repo.Directory, CreateTree(data))
+		if err != nil {
+			return "", err
+		}
+
+		if dir == "" {
+			root = hash
+			continue
+		}
+
+		parent, name := splitPath(dir)
+		children[parent] = append(children[parent], &Leaf{Mode: []byte("40000"), Path: name, Hash: hash})
+	}
+
+	return root, nil
+}
+
+// depth returns the number of components in a directory path ("" is the root, at depth 0).
+func depth(dir string) int {
+	if dir == "" {
+		return 0
 	}
+	return strings.Count(dir, "/") + 1
+}
 
-	return result, nil
+// splitPath splits a slash-separated path into its directory ("" at the root) and base name.
+func splitPath(path string) (string, string) {
+	slash := strings.LastIndex(path, "/")
+	if slash == -1 {
+		return "", path
+	}
+	return path[:slash], path[slash+1:]
 }
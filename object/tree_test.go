@@ -3,6 +3,7 @@ package object
 import (
 	"bytes"
 	"encoding/hex"
+	"orf/oid"
 	"sort"
 	"testing"
 )
@@ -51,6 +52,7 @@ func TestTreeSerialization(t *testing.T) {
 func TestTreeDeserialization(t *testing.T) {
 	data := append([]byte("100644 file.txt\x00"), hexToBytes(t, "e69de29bb2d1d6434b8b29ae775ad8c2e48c5391")...)
 	tree := CreateTree([]byte{})
+	tree.Algorithm = oid.SHA1
 
 	err := tree.Deserialize(data)
 	if err != nil {
@@ -70,8 +72,8 @@ func TestTreeDeserialization(t *testing.T) {
 		t.Errorf("Expected path 'file.txt', got %s", leaf.Path)
 	}
 
-	if leaf.Path != "e69de29bb2d1d6434b8b29ae775ad8c2e48c5391" {
-		t.Errorf("Expected hash 'e69de29bb2d1d6434b8b29ae775ad8c2e48c5391', got %s", leaf.Path)
+	if leaf.Hash != "e69de29bb2d1d6434b8b29ae775ad8c2e48c5391" {
+		t.Errorf("Expected hash 'e69de29bb2d1d6434b8b29ae775ad8c2e48c5391', got %s", leaf.Hash)
 	}
 }
 
@@ -92,6 +94,56 @@ func TestByPathSorting(t *testing.T) {
 	}
 }
 
+func TestWriteTreeLeaves(t *testing.T) {
+	repo := createTestRepo(t)
+	blob := writeTestBlob(t, repo, "content")
+	script := writeTestBlob(t, repo, "#!/bin/sh")
+
+	leaves := map[string]*Leaf{
+		"README":           {Mode: []byte("100644"), Path: "README", Hash: blob},
+		"src/main.go":      {Mode: []byte("100644"), Path: "src/main.go", Hash: blob},
+		"src/util/util.go": {Mode: []byte("100644"), Path: "src/util/util.go", Hash: blob},
+		"bin/run":          {Mode: []byte("100755"), Path: "bin/run", Hash: script},
+	}
+
+	root, err := WriteTreeLeaves(repo, leaves)
+	if err != nil {
+		t.Fatalf("WriteTreeLeaves failed: %v", err)
+	}
+
+	read, err := ReadTreeLeaves(repo, root)
+	if err != nil {
+		t.Fatalf("ReadTreeLeaves failed: %v", err)
+	}
+
+	if len(read) != len(leaves) {
+		t.Fatalf("Expected %d leaves, got %d", len(leaves), len(read))
+	}
+	for path, leaf := range leaves {
+		if read[path] == nil || read[path].Hash != leaf.Hash || string(read[path].Mode) != string(leaf.Mode) {
+			t.Errorf("Expected %s to round trip, got %+v", path, read[path])
+		}
+	}
+
+	if hash, err := LookupPath(repo, root, "src/util/util.go"); err != nil || hash != blob {
+		t.Errorf("Expected nested lookup to find the blob, got %s (%v)", hash, err)
+	}
+
+	empty, err := WriteTreeLeaves(repo, nil)
+	if err != nil {
+		t.Fatalf("WriteTreeLeaves failed for an empty tree: %v", err)
+	}
+	if read, err := ReadTreeLeaves(repo, empty); err != nil || len(read) != 0 {
+		t.Errorf("Expected an empty tree, got %v (%v)", read, err)
+	}
+
+	// A blob cannot share its name with a subtree
+	leaves["src"] = &Leaf{Mode: []byte("100644"), Path: "src", Hash: blob}
+	if _, err := WriteTreeLeaves(repo, leaves); err == nil {
+		t.Errorf("Expected an error for a path that is both a file and a directory")
+	}
+}
+
 func hexToBytes(t *testing.T, hexStr string) []byte {
 	bytes, err := hex.DecodeString(hexStr)
 	if err != nil {
@@ -99,3 +151,20 @@ func hexToBytes(t *testing.T, hexStr string) []byte {
 	}
 	return bytes
 }
+
+func TestLeafType(t *testing.T) {
+	tests := map[string]string{
+		"040000": "tree",
+		"40000":  "tree",
+		"100644": "blob",
+		"100755": "blob",
+		"120000": "blob",
+		"160000": "commit",
+	}
+	for mode, expected := range tests {
+		leaf := &Leaf{Mode: []byte(mode), Path: "path"}
+		if got := leaf.Type(); got != expected {
+			t.Errorf("Type() of mode %s = %s, expected %s", mode, got, expected)
+		}
+	}
+}
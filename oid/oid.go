@@ -0,0 +1,127 @@
+package oid
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"strings"
+)
+
+// Algorithm is the hash function a repository uses to name its objects.
+type Algorithm string
+
+const (
+	SHA1   Algorithm = "sha1"
+	SHA256 Algorithm = "sha256"
+)
+
+// Default is the object format of repositories that do not declare one.
+const Default = SHA256
+
+// ParseAlgorithm returns the Algorithm with the given name (sha1 or sha256).
+func ParseAlgorithm(name string) (Algorithm, error) {
+	switch Algorithm(strings.ToLower(strings.TrimSpace(name))) {
+	case SHA1:
+		return SHA1, nil
+	case SHA256:
+		return SHA256, nil
+	default:
+		return "", fmt.Errorf("unknown object format: %s", name)
+	}
+}
+
+// Size returns the length in bytes of a raw object id.
+func (algorithm Algorithm) Size() int {
+	if algorithm == SHA1 {
+		return sha1.Size
+	}
+	return sha256.Size
+}
+
+// HexSize returns the length of an object id in hex characters.
+func (algorithm Algorithm) HexSize() int {
+	return algorithm.Size() * 2
+}
+
+// New returns a new hash.Hash computing this algorithm.
+func (algorithm Algorithm) New() hash.Hash {
+	if algorithm == SHA1 {
+		return sha1.New()
+	}
+	return sha256.New()
+}
+
+// Sum hashes data and returns its ObjectID.
+func (algorithm Algorithm) Sum(data []byte) ObjectID {
+	hasher := algorithm.New()
+	hasher.Write(data)
+	return ObjectID(hex.EncodeToString(hasher.Sum(nil)))
+}
+
+// Zero returns the all-zeros ObjectID, used for "no object" (e.g. in reflogs).
+func (algorithm Algorithm) Zero() ObjectID {
+	return ObjectID(strings.Repeat("0", algorithm.HexSize()))
+}
+
+// ObjectID is the name of an object: its hash as lowercase hex,
+// 40 characters for SHA-1 and 64 characters for SHA-256.
+type ObjectID string
+
+// FromHex validates a full hex object id (either algorithm) and normalizes it to lowercase.
+func FromHex(value string) (ObjectID, error) {
+	value = strings.ToLower(strings.TrimSpace(value))
+
+	if len(value) != SHA1.HexSize() && len(value) != SHA256.HexSize() {
+		return "", fmt.Errorf("invalid object id %q: wrong length", value)
+	}
+
+	if _, err := hex.DecodeString(value); err != nil {
+		return "", fmt.Errorf("invalid object id %q: %v", value, err)
+	}
+
+	return ObjectID(value), nil
+}
+
+// FromBytes converts a raw 20 or 32 byte hash into an ObjectID.
+func FromBytes(raw []byte) (ObjectID, error) {
+	if len(raw) != SHA1.Size() && len(raw) != SHA256.Size() {
+		return "", fmt.Errorf("invalid object id: %d bytes", len(raw))
+	}
+	return ObjectID(hex.EncodeToString(raw)), nil
+}
+
+// Bytes returns the raw hash, or nil if the id is not valid hex.
+func (id ObjectID) Bytes() []byte {
+	raw, err := hex.DecodeString(string(id))
+	if err != nil {
+		return nil
+	}
+	return raw
+}
+
+// Algorithm infers the hash algorithm from the length of the id.
+func (id ObjectID) Algorithm() Algorithm {
+	if len(id) == SHA1.HexSize() {
+		return SHA1
+	}
+	return SHA256
+}
+
+// IsZero reports whether the id is empty or all zeros.
+func (id ObjectID) IsZero() bool {
+	return strings.Trim(string(id), "0") == ""
+}
+
+// Short returns an abbreviated id for display.
+func (id ObjectID) Short() string {
+	if len(id) <= 8 {
+		return string(id)
+	}
+	return string(id[:8])
+}
+
+func (id ObjectID) String() string {
+	return string(id)
+}
@@ -0,0 +1,68 @@
+package oid
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestParseAlgorithm(t *testing.T) {
+	tests := map[string]Algorithm{"sha1": SHA1, "SHA256": SHA256, " sha256 ": SHA256}
+
+	for name, expected := range tests {
+		algorithm, err := ParseAlgorithm(name)
+		if err != nil || algorithm != expected {
+			t.Errorf("ParseAlgorithm(%q) = %s, %v; expected %s", name, algorithm, err, expected)
+		}
+	}
+
+	if _, err := ParseAlgorithm("md5"); err == nil {
+		t.Errorf("Expected an error for md5")
+	}
+}
+
+func TestAlgorithmSum(t *testing.T) {
+	data := []byte("blob 0\x00")
+
+	if id := SHA1.Sum(data); len(id) != 40 || id.Algorithm() != SHA1 {
+		t.Errorf("Expected a 40 character SHA-1 id, got %s", id)
+	}
+
+	if id := SHA256.Sum(data); len(id) != 64 || id.Algorithm() != SHA256 {
+		t.Errorf("Expected a 64 character SHA-256 id, got %s", id)
+	}
+
+	if !SHA1.Zero().IsZero() || !SHA256.Zero().IsZero() || !ObjectID("").IsZero() {
+		t.Errorf("Expected zero ids to report IsZero")
+	}
+}
+
+func TestFromHexAndBytes(t *testing.T) {
+	id, err := FromHex("E69DE29BB2D1D6434B8B29AE775AD8C2E48C5391")
+	if err != nil {
+		t.Fatalf("FromHex failed: %v", err)
+	}
+	if id != "e69de29bb2d1d6434b8b29ae775ad8c2e48c5391" {
+		t.Errorf("Expected a lowercase id, got %s", id)
+	}
+
+	roundTrip, err := FromBytes(id.Bytes())
+	if err != nil || roundTrip != id {
+		t.Errorf("Expected round trip to %s, got %s (%v)", id, roundTrip, err)
+	}
+
+	raw := bytes.Repeat([]byte{0xab}, 32)
+	id, err = FromBytes(raw)
+	if err != nil || len(id) != 64 || !bytes.Equal(id.Bytes(), raw) {
+		t.Errorf("Expected a 64 character id for 32 bytes, got %s (%v)", id, err)
+	}
+
+	for _, invalid := range []string{"", "abc", "zz9de29bb2d1d6434b8b29ae775ad8c2e48c5391"} {
+		if _, err := FromHex(invalid); err == nil {
+			t.Errorf("FromHex(%q): expected an error", invalid)
+		}
+	}
+
+	if _, err := FromBytes(make([]byte, 21)); err == nil {
+		t.Errorf("Expected an error for 21 bytes")
+	}
+}
@@ -0,0 +1,593 @@
+package pack
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"orf/chunkfile"
+	"orf/oid"
+	"orf/repository"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNoMultiPackIndex is returned when a .orf directory has no multi-pack-index.
+var ErrNoMultiPackIndex = errors.New("no multi-pack-index")
+
+var midxSignature = []byte("MIDX")
+
+// Chunks of a version 1 multi-pack-index.
+const (
+	chunkPackNames     = 0x504e414d // "PNAM": names of the pack indexes, sorted
+	chunkOIDFanout     = 0x4f494446 // "OIDF": number of objects by first id byte
+	chunkOIDLookup     = 0x4f49444c // "OIDL": sorted object ids
+	chunkObjectOffsets = 0x4f4f4646 // "OOFF": pack and offset of each object
+	chunkLargeOffsets  = 0x4c4f4646 // "LOFF": offsets past 2GB
+	chunkReverseIndex  = 0x52494458 // "RIDX": objects in pseudo-pack order
+)
+
+const midxHeaderSize = 12
+
+// MultiPackIndexPath returns the path of the multi-pack-index of a .orf directory.
+func MultiPackIndexPath(directory string) string {
+	return filepath.Join(Dir(directory), "multi-pack-index")
+}
+
+// MultiPackIndex is an opened multi-pack-index: one sorted list of the objects of several
+// packs, each with the pack and offset to read it from, so looking an object up does not read
+// every pack index. An object in several packs is listed once.
+//
+// The pseudo-pack order lists the objects by pack, then by offset, as if the packs were one;
+// reachability bitmaps number their bits in that order.
+type MultiPackIndex struct {
+	Path      string
+	Checksum  oid.ObjectID
+	PackNames []string
+
+	algorithm oid.Algorithm
+	ids       []oid.ObjectID
+	packs     []uint32
+	offsets   []int64
+	order     []uint32 // position in ids of each object, in pseudo-pack order
+	positions []uint32 // position in the pseudo-pack order of each object of ids
+}
+
+// midxHashVersion is the id of an algorithm in the multi-pack-index header.
+func midxHashVersion(algorithm oid.Algorithm) byte {
+	if algorithm == oid.SHA1 {
+		return 1
+	}
+	return 2
+}
+
+// OpenMultiPackIndex reads a multi-pack-index whose ids are hashed with algorithm.
+func OpenMultiPackIndex(path string, algorithm oid.Algorithm) (*MultiPackIndex, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	hashSize := algorithm.Size()
+	if len(data) < midxHeaderSize+hashSize || !bytes.Equal(data[:4], midxSignature) || data[4] != 1 {
+		return nil, fmt.Errorf("invalid multi-pack-index %s", path)
+	}
+	if data[5] != midxHashVersion(algorithm) {
+		return nil, fmt.Errorf("multi-pack-index %s does not use %s", path, algorithm)
+	}
+	if data[7] != 0 {
+		return nil, fmt.Errorf("multi-pack-index %s: chains of indexes are not supported", path)
+	}
+	packCount := int(binary.BigEndian.Uint32(data[8:]))
+
+	chunks, err := chunkfile.Read(data[:len(data)-hashSize], midxHeaderSize, int(data[6]))
+	if err != nil {
+		return nil, fmt.Errorf("invalid multi-pack-index %s: %w", path, err)
+	}
+
+	fanout, lookup, offsets := chunks[chunkOIDFanout], chunks[chunkOIDLookup], chunks[chunkObjectOffsets]
+	if len(fanout) != 256*4 {
+		return nil, fmt.Errorf("invalid multi-pack-index %s: bad fanout", path)
+	}
+	count := int(binary.BigEndian.Uint32(fanout[255*4:]))
+	if len(lookup) != count*hashSize || len(offsets) != count*8 {
+		return nil, fmt.Errorf("invalid multi-pack-index %s: expected %d objects", path, count)
+	}
+
+	midx := &MultiPackIndex{
+		Path:      path,
+		algorithm: algorithm,
+		ids:       make([]oid.ObjectID, count),
+		packs:     make([]uint32, count),
+		offsets:   make([]int64, count),
+	}
+
+	names := strings.Split(strings.TrimRight(string(chunks[chunkPackNames]), "\x00"), "\x00")
+	if len(names) != packCount || !sort.StringsAreSorted(names) {
+		return nil, fmt.Errorf("invalid multi-pack-index %s: expected %d sorted pack names", path, packCount)
+	}
+	midx.PackNames = names
+
+	large := chunks[chunkLargeOffsets]
+	for i := 0; i < count; i++ {
+		if midx.ids[i], err = oid.FromBytes(lookup[i*hashSize : (i+1)*hashSize]); err != nil {
+			return nil, err
+		}
+
+		midx.packs[i] = binary.BigEndian.Uint32(offsets[i*8:])
+		if int(midx.packs[i]) >= packCount {
+			return nil, fmt.Errorf("invalid multi-pack-index %s: bad pack of %s", path, midx.ids[i])
+		}
+
+		offset := binary.BigEndian.Uint32(offsets[i*8+4:])
+		if offset&0x80000000 == 0 {
+			midx.offsets[i] = int64(offset)
+			continue
+		}
+		at := int(offset&0x7fffffff) * 8
+		if at+8 > len(large) {
+			return nil, fmt.Errorf("invalid multi-pack-index %s: bad offset of %s", path, midx.ids[i])
+		}
+		midx.offsets[i] = int64(binary.BigEndian.Uint64(large[at:]))
+	}
+
+	if err := midx.readOrder(chunks[chunkReverseIndex]); err != nil {
+		return nil, fmt.Errorf("invalid multi-pack-index %s: %w", path, err)
+	}
+	if midx.Checksum, err = oid.FromBytes(data[len(data)-hashSize:]); err != nil {
+		return nil, err
+	}
+	return midx, nil
+}
+
+// readOrder reads the pseudo-pack order from the reverse index chunk, or computes it if the
+// index has none.
+func (midx *MultiPackIndex) readOrder(reverse []byte) error {
+	count := len(midx.ids)
+	if reverse == nil {
+		midx.order = pseudoPackOrder(midx.packs, midx.offsets)
+	} else {
+		if len(reverse) != count*4 {
+			return fmt.Errorf("bad reverse index")
+		}
+		midx.order = make([]uint32, count)
+		for i := range midx.order {
+			midx.order[i] = binary.BigEndian.Uint32(reverse[i*4:])
+		}
+	}
+
+	midx.positions = make([]uint32, count)
+	seen := make([]bool, count)
+	for position, i := range midx.order {
+		if int(i) >= count || seen[i] {
+			return fmt.Errorf("bad reverse index")
+		}
+		seen[i] = true
+		midx.positions[i] = uint32(position)
+	}
+	return nil
+}
+
+// pseudoPackOrder sorts the positions of objects by pack, then offset.
+func pseudoPackOrder(packs []uint32, offsets []int64) []uint32 {
+	order := make([]uint32, len(packs))
+	for i := range order {
+		order[i] = uint32(i)
+	}
+	sort.Slice(order, func(a, b int) bool {
+		i, j := order[a], order[b]
+		if packs[i] != packs[j] {
+			return packs[i] < packs[j]
+		}
+		return offsets[i] < offsets[j]
+	})
+	return order
+}
+
+// IDs returns the ids of the objects in the index, in order.
+func (midx *MultiPackIndex) IDs() []oid.ObjectID {
+	return midx.ids
+}
+
+// find returns the position of an id in the index, or -1.
+func (midx *MultiPackIndex) find(id oid.ObjectID) int {
+	i := sort.Search(len(midx.ids), func(i int) bool { return midx.ids[i] >= id })
+	if i < len(midx.ids) && midx.ids[i] == id {
+		return i
+	}
+	return -1
+}
+
+// Contains reports whether the index lists an object.
+func (midx *MultiPackIndex) Contains(id oid.ObjectID) bool {
+	return midx.find(id) != -1
+}
+
+// Position returns the position of an object in the pseudo-pack order, reporting whether the
+// index lists it.
+func (midx *MultiPackIndex) Position(id oid.ObjectID) (int, bool) {
+	i := midx.find(id)
+	if i == -1 {
+		return 0, false
+	}
+	return int(midx.positions[i]), true
+}
+
+// IndexPosition returns the position of an object in the sorted ids, reporting whether the
+// index lists it.
+func (midx *MultiPackIndex) IndexPosition(id oid.ObjectID) (int, bool) {
+	i := midx.find(id)
+	return i, i != -1
+}
+
+// ObjectAt returns the object at a position of the pseudo-pack order.
+func (midx *MultiPackIndex) ObjectAt(position int) oid.ObjectID {
+	return midx.ids[midx.order[position]]
+}
+
+// packPath returns the path of the .pack file of a pack of the index.
+func (midx *MultiPackIndex) packPath(pack uint32) string {
+	return filepath.Join(filepath.Dir(midx.Path), strings.TrimSuffix(midx.PackNames[pack], ".idx")+".pack")
+}
+
+// read returns the type and data of the object at position i of the sorted ids.
+func (midx *MultiPackIndex) read(i int) (string, []byte, error) {
+	return readObjectAt(midx.packPath(midx.packs[i]), midx.ids[i], midx.offsets[i])
+}
+
+// Types returns the type of every object, in pseudo-pack order. Only the headers of the
+// entries are read.
+func (midx *MultiPackIndex) Types() ([]string, error) {
+	types := make([]string, len(midx.order))
+
+	var file *os.File
+	current := -1
+	defer func() {
+		if file != nil {
+			file.Close()
+		}
+	}()
+
+	for position, i := range midx.order {
+		if pack := int(midx.packs[i]); pack != current {
+			if file != nil {
+				file.Close()
+			}
+			var err error
+			if file, err = os.Open(midx.packPath(midx.packs[i])); err != nil {
+				return nil, err
+			}
+			current = pack
+		}
+
+		format, _, err := readHeader(bufio.NewReader(io.NewSectionReader(file, midx.offsets[i], 1<<62)))
+		if err != nil {
+			return nil, fmt.Errorf("error reading %s from %s: %w", midx.ids[i], filepath.Base(file.Name()), err)
+		}
+		types[position] = format
+	}
+	return types, nil
+}
+
+// BitmapPath returns the path of the reachability bitmaps of the index, which are named after
+// its checksum.
+func (midx *MultiPackIndex) BitmapPath() string {
+	return filepath.Join(filepath.Dir(midx.Path), "multi-pack-index-"+midx.Checksum.String()+".bitmap")
+}
+
+// WriteMultiPackIndex replaces the multi-pack-index of a .orf directory with one over all its
+// packs and returns it, or removes it and returns ErrNoMultiPackIndex if there are no packs.
+// An object in several packs is read from the most recently written one. Bitmaps of a previous
+// index are removed, as their bits no longer match.
+func WriteMultiPackIndex(directory string) (*MultiPackIndex, error) {
+	packs, err := List(directory)
+	if err != nil {
+		return nil, err
+	}
+	if len(packs) == 0 {
+		if err := os.Remove(MultiPackIndexPath(directory)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		if err := removeBitmaps(directory, ""); err != nil {
+			return nil, err
+		}
+		return nil, ErrNoMultiPackIndex
+	}
+
+	type location struct {
+		pack    uint32
+		offset  int64
+		modTime time.Time
+	}
+	locations := make(map[oid.ObjectID]location)
+	names := make([]string, len(packs))
+	for i, p := range packs {
+		names[i] = filepath.Base(strings.TrimSuffix(p.Path, ".pack") + ".idx")
+		info, err := os.Stat(p.Path)
+		if err != nil {
+			return nil, err
+		}
+
+		for j, id := range p.ids {
+			if previous, ok := locations[id]; ok && !info.ModTime().After(previous.modTime) {
+				continue
+			}
+			locations[id] = location{pack: uint32(i), offset: p.offsets[j], modTime: info.ModTime()}
+		}
+	}
+
+	ids := make([]oid.ObjectID, 0, len(locations))
+	for id := range locations {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	settings := repository.DirectorySettings(directory)
+	hashSize := settings.Format.Size()
+
+	var pnam []byte
+	for _, name := range names {
+		pnam = append(append(pnam, name...), 0)
+	}
+	for len(pnam)%4 != 0 {
+		pnam = append(pnam, 0)
+	}
+
+	fanout := make([]byte, 256*4)
+	lookup := make([]byte, 0, len(ids)*hashSize)
+	offsets := make([]byte, 0, len(ids)*8)
+	var large []byte
+	packIDs := make([]uint32, len(ids))
+	packOffsets := make([]int64, len(ids))
+	for i, id := range ids {
+		raw := id.Bytes()
+		if len(raw) != hashSize {
+			return nil, fmt.Errorf("invalid object id %s", id)
+		}
+		lookup = append(lookup, raw...)
+		for b := int(raw[0]); b < 256; b++ {
+			binary.BigEndian.PutUint32(fanout[b*4:], uint32(i+1))
+		}
+
+		loc := locations[id]
+		packIDs[i], packOffsets[i] = loc.pack, loc.offset
+		offsets = binary.BigEndian.AppendUint32(offsets, loc.pack)
+		if loc.offset < 0x80000000 {
+			offsets = binary.BigEndian.AppendUint32(offsets, uint32(loc.offset))
+			continue
+		}
+		offsets = binary.BigEndian.AppendUint32(offsets, 0x80000000|uint32(len(large)/8))
+		large = binary.BigEndian.AppendUint64(large, uint64(loc.offset))
+	}
+
+	var reverse []byte
+	for _, i := range pseudoPackOrder(packIDs, packOffsets) {
+		reverse = binary.BigEndian.AppendUint32(reverse, i)
+	}
+
+	chunks := []chunkfile.Chunk{
+		{ID: chunkPackNames, Data: pnam},
+		{ID: chunkOIDFanout, Data: fanout},
+		{ID: chunkOIDLookup, Data: lookup},
+		{ID: chunkObjectOffsets, Data: offsets},
+	}
+	if len(large) > 0 {
+		chunks = append(chunks, chunkfile.Chunk{ID: chunkLargeOffsets, Data: large})
+	}
+	chunks = append(chunks, chunkfile.Chunk{ID: chunkReverseIndex, Data: reverse})
+
+	header := make([]byte, midxHeaderSize)
+	copy(header, midxSignature)
+	header[4], header[5], header[6] = 1, midxHashVersion(settings.Format), byte(len(chunks))
+	binary.BigEndian.PutUint32(header[8:], uint32(len(names)))
+
+	if err := writeMultiPackIndex(directory, header, chunks); err != nil {
+		return nil, err
+	}
+
+	midx, err := OpenMultiPackIndex(MultiPackIndexPath(directory), settings.Format)
+	if err != nil {
+		return nil, err
+	}
+	if err := removeBitmaps(directory, midx.BitmapPath()); err != nil {
+		return nil, err
+	}
+	return midx, nil
+}
+
+// writeMultiPackIndex writes the header and chunks of a multi-pack-index and its checksum
+// under a temporary name, then renames it into place.
+func writeMultiPackIndex(directory string, header []byte, chunks []chunkfile.Chunk) error {
+	settings := repository.DirectorySettings(directory)
+	fsync := settings.Fsync&repository.FsyncPackMetadata != 0
+
+	file, err := os.CreateTemp(Dir(directory), "tmp_midx_")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	buffered := bufio.NewWriter(file)
+	hasher := settings.Format.New()
+	out := io.MultiWriter(buffered, hasher)
+	if _, err := out.Write(header); err != nil {
+		return err
+	}
+	if err := chunkfile.Write(out, len(header), chunks); err != nil {
+		return err
+	}
+	if _, err := writeChecksum(buffered, hasher); err != nil {
+		return err
+	}
+	if err := buffered.Flush(); err != nil {
+		return err
+	}
+
+	if err := file.Chmod(0644); err != nil {
+		return err
+	}
+	if err := closeFile(file, fsync); err != nil {
+		return err
+	}
+	if err := os.Rename(file.Name(), MultiPackIndexPath(directory)); err != nil {
+		return err
+	}
+	if fsync {
+		return syncDir(Dir(directory))
+	}
+	return nil
+}
+
+// removeBitmaps deletes the multi-pack-index bitmaps of a .orf directory, except keep.
+func removeBitmaps(directory string, keep string) error {
+	paths, err := filepath.Glob(filepath.Join(Dir(directory), "multi-pack-index-*.bitmap"))
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		if path == keep {
+			continue
+		}
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// VerifyMultiPackIndex checks the multi-pack-index of a .orf directory: its checksum, and that
+// it lists every object of its packs at the offset the pack's own index gives. It returns
+// ErrNoMultiPackIndex without one.
+func VerifyMultiPackIndex(directory string) error {
+	path := MultiPackIndexPath(directory)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return ErrNoMultiPackIndex
+	}
+	if err != nil {
+		return err
+	}
+
+	algorithm := repository.ObjectFormat(directory)
+	midx, err := OpenMultiPackIndex(path, algorithm)
+	if err != nil {
+		return err
+	}
+	hashSize := algorithm.Size()
+	if sum := algorithm.Sum(data[:len(data)-hashSize]); sum != midx.Checksum {
+		return fmt.Errorf("multi-pack-index has checksum %s, expected %s", midx.Checksum, sum)
+	}
+
+	for i, id := range midx.ids {
+		if i > 0 && midx.ids[i-1] >= id {
+			return fmt.Errorf("multi-pack-index ids are not sorted at %s", id)
+		}
+	}
+
+	packs := make([]*Pack, len(midx.PackNames))
+	for p, name := range midx.PackNames {
+		if packs[p], err = Open(filepath.Join(Dir(directory), name), algorithm); err != nil {
+			return fmt.Errorf("multi-pack-index names %s: %w", name, err)
+		}
+		for _, id := range packs[p].ids {
+			if !midx.Contains(id) {
+				return fmt.Errorf("multi-pack-index is missing %s of %s", id, name)
+			}
+		}
+	}
+
+	for i, id := range midx.ids {
+		pack := packs[midx.packs[i]]
+		j := pack.find(id)
+		if j == -1 {
+			return fmt.Errorf("multi-pack-index reads %s from %s, which does not hold it", id, midx.PackNames[midx.packs[i]])
+		}
+		if midx.offsets[i] != pack.offsets[j] {
+			return fmt.Errorf("multi-pack-index has offset %d for %s, %s has %d", midx.offsets[i], id, midx.PackNames[midx.packs[i]], pack.offsets[j])
+		}
+	}
+	return nil
+}
+
+// openedIndex is a cached MultiPackIndex, valid while its file is unchanged.
+type openedIndex struct {
+	modTime time.Time
+	size    int64
+	midx    *MultiPackIndex
+}
+
+// openedIndexes caches the multi-pack-indexes read by LoadMultiPackIndex, keyed by their path.
+var openedIndexes sync.Map
+
+// LoadMultiPackIndex returns the multi-pack-index of a .orf directory, or ErrNoMultiPackIndex.
+// The file is only read again when it changes.
+func LoadMultiPackIndex(directory string) (*MultiPackIndex, error) {
+	path := MultiPackIndexPath(directory)
+	info, err := os.Stat(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, ErrNoMultiPackIndex
+		}
+		return nil, err
+	}
+
+	if cached, ok := openedIndexes.Load(path); ok {
+		cached := cached.(*openedIndex)
+		if cached.modTime.Equal(info.ModTime()) && cached.size == info.Size() {
+			return cached.midx, nil
+		}
+	}
+
+	midx, err := OpenMultiPackIndex(path, repository.ObjectFormat(directory))
+	if err != nil {
+		return nil, err
+	}
+	openedIndexes.Store(path, &openedIndex{modTime: info.ModTime(), size: info.Size(), midx: midx})
+	return midx, nil
+}
+
+// UsableMultiPackIndex returns the multi-pack-index of a .orf directory if object lookups use
+// it: core.multiPackIndex is not false, it can be read and every pack it names still exists.
+// It returns nil otherwise.
+func UsableMultiPackIndex(directory string) *MultiPackIndex {
+	entries, err := readDir(directory)
+	if err != nil {
+		return nil
+	}
+	return usableMultiPackIndex(directory, entries)
+}
+
+// usableMultiPackIndex is UsableMultiPackIndex given the entries of objects/pack.
+func usableMultiPackIndex(directory string, entries []os.DirEntry) *MultiPackIndex {
+	if !repository.DirectorySettings(directory).MultiPackIndex {
+		return nil
+	}
+
+	present := false
+	names := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		names[entry.Name()] = true
+		present = present || entry.Name() == "multi-pack-index"
+	}
+	if !present {
+		return nil
+	}
+
+	midx, err := LoadMultiPackIndex(directory)
+	if err != nil {
+		return nil
+	}
+	for _, name := range midx.PackNames {
+		if !names[name] || !names[strings.TrimSuffix(name, ".idx")+".pack"] {
+			return nil
+		}
+	}
+	return midx
+}
@@ -0,0 +1,138 @@
+package pack
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMultiPackIndex(t *testing.T) {
+	directory := t.TempDir()
+	first, objects := writeTestPack(t, directory,
+		testObject{"blob", []byte("shared")},
+		testObject{"commit", []byte("tree abc\n\nfirst\n")},
+	)
+	second, more := writeTestPack(t, directory,
+		testObject{"blob", []byte("shared")},
+		testObject{"tree", []byte("100644 a\x00")},
+	)
+	for id, object := range more {
+		objects[id] = object
+	}
+
+	// The shared blob is read from the newest pack
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(second.Path, later, later); err != nil {
+		t.Fatalf("Failed to date pack: %v", err)
+	}
+
+	midx, err := WriteMultiPackIndex(directory)
+	if err != nil {
+		t.Fatalf("WriteMultiPackIndex failed: %v", err)
+	}
+	if len(midx.IDs()) != 3 || len(midx.PackNames) != 2 {
+		t.Fatalf("Expected 3 objects of 2 packs, got %d of %v", len(midx.IDs()), midx.PackNames)
+	}
+	if UsableMultiPackIndex(directory) == nil {
+		t.Fatalf("Expected the multi-pack-index to be used")
+	}
+	if err := VerifyMultiPackIndex(directory); err != nil {
+		t.Errorf("VerifyMultiPackIndex failed: %v", err)
+	}
+
+	for id, object := range objects {
+		format, data, err := ReadObject(directory, id)
+		if err != nil || format != object.format || !bytes.Equal(data, object.data) {
+			t.Errorf("ReadObject(%s) = %s %q (%v), expected %s %q", id, format, data, err, object.format, object.data)
+		}
+		if found, err := FindPrefix(directory, string(id[:8])); err != nil || len(found) != 1 {
+			t.Errorf("FindPrefix(%s) = %v (%v), expected one id", id[:8], found, err)
+		}
+
+		position, ok := midx.Position(id)
+		if !ok || midx.ObjectAt(position) != id {
+			t.Errorf("Expected %s at position %d", id, position)
+		}
+	}
+
+	shared := midx.find(first.IDs()[0])
+	if !second.Contains(first.IDs()[0]) {
+		shared = midx.find(first.IDs()[1])
+	}
+	if midx.PackNames[midx.packs[shared]] != filepath.Base(second.Path[:len(second.Path)-len(".pack")]+".idx") {
+		t.Errorf("Expected the shared blob to be read from the newest pack")
+	}
+
+	types, err := midx.Types()
+	if err != nil {
+		t.Fatalf("Types failed: %v", err)
+	}
+	for position, format := range types {
+		if expected := objects[midx.ObjectAt(position)].format; format != expected {
+			t.Errorf("Expected %s at position %d to be a %s, got %s", midx.ObjectAt(position), position, expected, format)
+		}
+	}
+
+	// Once a pack it covers is gone, the index is no longer used
+	if err := Remove(first); err != nil {
+		t.Fatalf("Remove failed: %v", err)
+	}
+	if UsableMultiPackIndex(directory) != nil {
+		t.Errorf("Expected the stale multi-pack-index to be ignored")
+	}
+	for id := range more {
+		if found, err := Has(directory, id); err != nil || !found {
+			t.Errorf("Expected %s to be found without the multi-pack-index, got %v, %v", id, found, err)
+		}
+	}
+
+	if err := Remove(second); err != nil {
+		t.Fatalf("Remove failed: %v", err)
+	}
+	if _, err := WriteMultiPackIndex(directory); !errors.Is(err, ErrNoMultiPackIndex) {
+		t.Errorf("Expected ErrNoMultiPackIndex without packs, got %v", err)
+	}
+	if _, err := os.Stat(MultiPackIndexPath(directory)); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected the multi-pack-index to be removed, got %v", err)
+	}
+}
+
+func TestMultiPackIndexLargeOffsets(t *testing.T) {
+	packs := []uint32{1, 0, 0, 1}
+	offsets := []int64{12, 1 << 33, 40, 8}
+	order := pseudoPackOrder(packs, offsets)
+	expected := []uint32{2, 1, 3, 0}
+	for i := range expected {
+		if order[i] != expected[i] {
+			t.Fatalf("Expected pseudo-pack order %v, got %v", expected, order)
+		}
+	}
+}
+
+func TestVerifyMultiPackIndexCorruption(t *testing.T) {
+	directory := t.TempDir()
+	writeTestPack(t, directory, testObject{"blob", []byte("one")}, testObject{"blob", []byte("two")})
+	if _, err := WriteMultiPackIndex(directory); err != nil {
+		t.Fatalf("WriteMultiPackIndex failed: %v", err)
+	}
+
+	path := MultiPackIndexPath(directory)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read multi-pack-index: %v", err)
+	}
+	data[len(data)-40] ^= 0xff
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatalf("Failed to write multi-pack-index: %v", err)
+	}
+
+	if err := VerifyMultiPackIndex(directory); err == nil {
+		t.Errorf("Expected VerifyMultiPackIndex to detect the damage")
+	}
+	if err := VerifyMultiPackIndex(t.TempDir()); !errors.Is(err, ErrNoMultiPackIndex) {
+		t.Errorf("Expected ErrNoMultiPackIndex, got %v", err)
+	}
+}
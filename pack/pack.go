@@ -0,0 +1,459 @@
+package pack
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"orf/oid"
+	"orf/repository"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Object types as stored in the header of a pack entry.
+const (
+	typeCommit   = 1
+	typeTree     = 2
+	typeBlob     = 3
+	typeTag      = 4
+	typeOfsDelta = 6
+	typeRefDelta = 7
+)
+
+var typeNames = map[byte]string{typeCommit: "commit", typeTree: "tree", typeBlob: "blob", typeTag: "tag"}
+
+// ErrNotFound is returned when no pack holds an object.
+var ErrNotFound = errors.New("object not in any pack")
+
+// ErrCorruptEntry is returned for a pack entry whose header cannot be right.
+var ErrCorruptEntry = errors.New("corrupt pack entry")
+
+// maxInflation is the most deflate can expand its input by, so an entry's data is never
+// larger than this many times the bytes left in the pack.
+const maxInflation = 1032
+
+// idxMagic starts a version 2 pack index.
+var idxMagic = []byte{0xff, 't', 'O', 'c'}
+
+// Pack is an opened pack: the .pack file holding the objects and the ids and offsets read
+// from its .idx file. IDs are sorted.
+type Pack struct {
+	Path     string
+	Checksum oid.ObjectID
+
+	algorithm oid.Algorithm
+	ids       []oid.ObjectID
+	offsets   []int64
+	crcs      []uint32
+}
+
+// Dir returns the directory holding the packs of a .orf directory.
+func Dir(directory string) string {
+	return filepath.Join(directory, "objects", "pack")
+}
+
+// Open reads the .idx file of a pack. Object ids are hashed with algorithm.
+func Open(idxPath string, algorithm oid.Algorithm) (*Pack, error) {
+	data, err := os.ReadFile(idxPath)
+	if err != nil {
+		return nil, err
+	}
+
+	hashSize := algorithm.Size()
+	header := 8 + 256*4
+	if len(data) < header+2*hashSize || !bytes.Equal(data[:4], idxMagic) || binary.BigEndian.Uint32(data[4:8]) != 2 {
+		return nil, fmt.Errorf("invalid pack index %s", idxPath)
+	}
+
+	count := int(binary.BigEndian.Uint32(data[header-4 : header]))
+	namesEnd := header + count*hashSize
+	crcsEnd := namesEnd + count*4
+	offsetsEnd := crcsEnd + count*4
+	if len(data) < offsetsEnd+2*hashSize {
+		return nil, fmt.Errorf("invalid pack index %s: truncated", idxPath)
+	}
+	large := data[offsetsEnd : len(data)-2*hashSize]
+
+	pack := &Pack{
+		Path:      strings.TrimSuffix(idxPath, ".idx") + ".pack",
+		algorithm: algorithm,
+		ids:       make([]oid.ObjectID, count),
+		offsets:   make([]int64, count),
+		crcs:      make([]uint32, count),
+	}
+
+	for i := 0; i < count; i++ {
+		if pack.ids[i], err = oid.FromBytes(data[header+i*hashSize : header+(i+1)*hashSize]); err != nil {
+			return nil, err
+		}
+		pack.crcs[i] = binary.BigEndian.Uint32(data[namesEnd+i*4:])
+
+		offset := binary.BigEndian.Uint32(data[crcsEnd+i*4:])
+		if offset&0x80000000 == 0 {
+			pack.offsets[i] = int64(offset)
+			continue
+		}
+
+		// Offsets past 2GB are kept in a table of 8 byte offsets
+		at := int(offset&0x7fffffff) * 8
+		if at+8 > len(large) {
+			return nil, fmt.Errorf("invalid pack index %s: bad offset", idxPath)
+		}
+		pack.offsets[i] = int64(binary.BigEndian.Uint64(large[at:]))
+	}
+
+	if pack.Checksum, err = oid.FromBytes(data[len(data)-2*hashSize : len(data)-hashSize]); err != nil {
+		return nil, err
+	}
+	return pack, nil
+}
+
+// IDs returns the ids of the objects in the pack, in order.
+func (pack *Pack) IDs() []oid.ObjectID {
+	return pack.ids
+}
+
+// find returns the position of an id in the index, or -1.
+func (pack *Pack) find(id oid.ObjectID) int {
+	i := sort.Search(len(pack.ids), func(i int) bool { return pack.ids[i] >= id })
+	if i < len(pack.ids) && pack.ids[i] == id {
+		return i
+	}
+	return -1
+}
+
+// Contains reports whether the pack holds an object.
+func (pack *Pack) Contains(id oid.ObjectID) bool {
+	return pack.find(id) != -1
+}
+
+// Read returns the type and data of an object in the pack, or ErrNotFound.
+func (pack *Pack) Read(id oid.ObjectID) (string, []byte, error) {
+	i := pack.find(id)
+	if i == -1 {
+		return "", nil, ErrNotFound
+	}
+	return readObjectAt(pack.Path, id, pack.offsets[i])
+}
+
+// readObjectAt reads the type and data of the object at offset in a .pack file.
+func readObjectAt(path string, id oid.ObjectID, offset int64) (string, []byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", nil, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return "", nil, err
+	}
+
+	format, data, _, err := readEntry(file, offset, info.Size())
+	if err != nil {
+		return "", nil, fmt.Errorf("error reading %s from %s: %w", id, filepath.Base(path), err)
+	}
+	return format, data, nil
+}
+
+// readEntry reads the entry at offset of a pack of length bytes: its type, its inflated data
+// and its size in the pack.
+func readEntry(file io.ReaderAt, offset int64, length int64) (string, []byte, int64, error) {
+	if offset < 0 || offset >= length {
+		return "", nil, 0, fmt.Errorf("%w: offset %d is outside the pack", ErrCorruptEntry, offset)
+	}
+	counter := &countingReader{reader: bufio.NewReader(io.NewSectionReader(file, offset, length-offset))}
+
+	format, size, err := readHeader(counter)
+	if err != nil {
+		return "", nil, 0, err
+	}
+
+	// A damaged or forged header must not make us allocate more than the pack can hold
+	if remaining := uint64(length - offset); size > remaining*maxInflation {
+		return "", nil, 0, fmt.Errorf("%w: size %d is more than its %d bytes can inflate to", ErrCorruptEntry, size, remaining)
+	}
+
+	zlibReader, err := zlib.NewReader(counter)
+	if err != nil {
+		return "", nil, 0, err
+	}
+	defer zlibReader.Close()
+
+	data := make([]byte, size)
+	if _, err := io.ReadFull(zlibReader, data); err != nil {
+		return "", nil, 0, err
+	}
+
+	// Reading to the end checks the zlib checksum and consumes the whole stream
+	if n, err := io.Copy(io.Discard, zlibReader); err != nil {
+		return "", nil, 0, err
+	} else if n != 0 {
+		return "", nil, 0, fmt.Errorf("entry longer than its size")
+	}
+
+	return format, data, counter.count, nil
+}
+
+// readHeader reads the header of an entry: the type and inflated size of its object.
+func readHeader(reader io.ByteReader) (string, uint64, error) {
+	first, err := reader.ReadByte()
+	if err != nil {
+		return "", 0, err
+	}
+	kind := (first >> 4) & 7
+	size := uint64(first & 0x0f)
+	for shift := 4; first&0x80 != 0; shift += 7 {
+		if first, err = reader.ReadByte(); err != nil {
+			return "", 0, err
+		}
+		size |= uint64(first&0x7f) << shift
+	}
+
+	format, ok := typeNames[kind]
+	if !ok {
+		if kind == typeOfsDelta || kind == typeRefDelta {
+			return "", 0, fmt.Errorf("deltified entries are not supported")
+		}
+		return "", 0, fmt.Errorf("invalid entry type %d", kind)
+	}
+	return format, size, nil
+}
+
+// countingReader counts the bytes read through it, so the packed size of an entry is known.
+// It reads one byte at a time, so zlib does not read past the end of the entry.
+type countingReader struct {
+	reader io.ByteReader
+	count  int64
+}
+
+func (counter *countingReader) ReadByte() (byte, error) {
+	b, err := counter.reader.ReadByte()
+	if err == nil {
+		counter.count++
+	}
+	return b, err
+}
+
+func (counter *countingReader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	b, err := counter.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	p[0] = b
+	return 1, nil
+}
+
+// Verify checks the checksums of the pack and its index, and that every entry inflates to
+// the size in its header and matches the CRC in the index.
+func (pack *Pack) Verify() error {
+	data, err := os.ReadFile(pack.Path)
+	if err != nil {
+		return err
+	}
+
+	hashSize := pack.algorithm.Size()
+	if len(data) < 12+hashSize || string(data[:4]) != "PACK" {
+		return fmt.Errorf("invalid pack %s", filepath.Base(pack.Path))
+	}
+	if int(binary.BigEndian.Uint32(data[8:12])) != len(pack.ids) {
+		return fmt.Errorf("pack %s holds %d objects, its index %d", filepath.Base(pack.Path), binary.BigEndian.Uint32(data[8:12]), len(pack.ids))
+	}
+	if sum := pack.algorithm.Sum(data[:len(data)-hashSize]); sum != pack.Checksum || !bytes.Equal(data[len(data)-hashSize:], pack.Checksum.Bytes()) {
+		return fmt.Errorf("pack %s does not match its checksum", filepath.Base(pack.Path))
+	}
+
+	idxPath := strings.TrimSuffix(pack.Path, ".pack") + ".idx"
+	idx, err := os.ReadFile(idxPath)
+	if err != nil {
+		return err
+	}
+	if len(idx) < hashSize || !bytes.Equal(pack.algorithm.Sum(idx[:len(idx)-hashSize]).Bytes(), idx[len(idx)-hashSize:]) {
+		return fmt.Errorf("index %s does not match its checksum", filepath.Base(idxPath))
+	}
+
+	reader := bytes.NewReader(data)
+	for i, id := range pack.ids {
+		_, _, length, err := readEntry(reader, pack.offsets[i], int64(len(data)-hashSize))
+		if err != nil {
+			return fmt.Errorf("error reading %s from %s: %w", id, filepath.Base(pack.Path), err)
+		}
+		if pack.offsets[i]+length > int64(len(data)-hashSize) {
+			return fmt.Errorf("entry %s runs past the end of %s", id, filepath.Base(pack.Path))
+		}
+		if crc32.ChecksumIEEE(data[pack.offsets[i]:pack.offsets[i]+length]) != pack.crcs[i] {
+			return fmt.Errorf("entry %s of %s does not match its CRC", id, filepath.Base(pack.Path))
+		}
+	}
+
+	return nil
+}
+
+// openedPack is a cached Pack, valid while its index is unchanged.
+type openedPack struct {
+	modTime time.Time
+	size    int64
+	pack    *Pack
+}
+
+// opened caches the packs read by List, keyed by the path of their index.
+var opened sync.Map
+
+// List returns the packs of a .orf directory, ordered by name. Indexes are only read again
+// when they change.
+func List(directory string) ([]*Pack, error) {
+	entries, err := readDir(directory)
+	if err != nil {
+		return nil, err
+	}
+	return listPacks(directory, entries, nil)
+}
+
+// readDir lists objects/pack, which may not exist yet.
+func readDir(directory string) ([]os.DirEntry, error) {
+	entries, err := os.ReadDir(Dir(directory))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	return entries, err
+}
+
+// listPacks opens the packs among the entries of objects/pack, skipping the indexes named in
+// skip.
+func listPacks(directory string, entries []os.DirEntry, skip map[string]bool) ([]*Pack, error) {
+	var packs []*Pack
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasPrefix(name, "pack-") || !strings.HasSuffix(name, ".idx") || skip[name] {
+			continue
+		}
+
+		path := filepath.Join(Dir(directory), name)
+		info, err := entry.Info()
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return nil, err
+		}
+
+		if cached, ok := opened.Load(path); ok {
+			cached := cached.(*openedPack)
+			if cached.modTime.Equal(info.ModTime()) && cached.size == info.Size() {
+				packs = append(packs, cached.pack)
+				continue
+			}
+		}
+
+		pack, err := Open(path, repository.ObjectFormat(directory))
+		if err != nil {
+			return nil, err
+		}
+		opened.Store(path, &openedPack{modTime: info.ModTime(), size: info.Size(), pack: pack})
+		packs = append(packs, pack)
+	}
+
+	return packs, nil
+}
+
+// sources returns the multi-pack-index of a .orf directory, or nil if it has none that is
+// usable, and the packs it does not cover. Only the indexes of those packs are read.
+func sources(directory string) (*MultiPackIndex, []*Pack, error) {
+	entries, err := readDir(directory)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	midx := usableMultiPackIndex(directory, entries)
+	var covered map[string]bool
+	if midx != nil {
+		covered = make(map[string]bool, len(midx.PackNames))
+		for _, name := range midx.PackNames {
+			covered[name] = true
+		}
+	}
+
+	packs, err := listPacks(directory, entries, covered)
+	if err != nil {
+		return nil, nil, err
+	}
+	return midx, packs, nil
+}
+
+// ReadObject returns the type and data of an object from whichever pack of a .orf directory
+// holds it, or ErrNotFound.
+func ReadObject(directory string, id oid.ObjectID) (string, []byte, error) {
+	midx, packs, err := sources(directory)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if midx != nil {
+		if i := midx.find(id); i != -1 {
+			return midx.read(i)
+		}
+	}
+	for _, pack := range packs {
+		if pack.Contains(id) {
+			return pack.Read(id)
+		}
+	}
+	return "", nil, ErrNotFound
+}
+
+// Has reports whether a pack of a .orf directory holds an object.
+func Has(directory string, id oid.ObjectID) (bool, error) {
+	midx, packs, err := sources(directory)
+	if err != nil {
+		return false, err
+	}
+
+	if midx != nil && midx.Contains(id) {
+		return true, nil
+	}
+	for _, pack := range packs {
+		if pack.Contains(id) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// FindPrefix returns the ids of the packed objects starting with a lowercase hex prefix.
+func FindPrefix(directory string, prefix string) ([]oid.ObjectID, error) {
+	midx, packs, err := sources(directory)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []oid.ObjectID
+	if midx != nil {
+		ids = findPrefix(midx.ids, prefix)
+	}
+	for _, pack := range packs {
+		ids = append(ids, findPrefix(pack.ids, prefix)...)
+	}
+	return ids, nil
+}
+
+// findPrefix returns the ids of a sorted list starting with prefix.
+func findPrefix(sorted []oid.ObjectID, prefix string) []oid.ObjectID {
+	i := sort.Search(len(sorted), func(i int) bool { return string(sorted[i]) >= prefix })
+	j := i
+	for j < len(sorted) && strings.HasPrefix(string(sorted[j]), prefix) {
+		j++
+	}
+	return sorted[i:j:j]
+}
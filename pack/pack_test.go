@@ -0,0 +1,173 @@
+package pack
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"orf/oid"
+	"os"
+	"strings"
+	"testing"
+)
+
+type testObject struct {
+	format string
+	data   []byte
+}
+
+// writeTestPack packs objects named by the hash of their contents and returns the pack.
+func writeTestPack(t *testing.T, directory string, objects ...testObject) (*Pack, map[oid.ObjectID]testObject) {
+	byID := make(map[oid.ObjectID]testObject)
+	var ids []oid.ObjectID
+	for _, object := range objects {
+		id := oid.SHA256.Sum(append([]byte(object.format+" "), object.data...))
+		byID[id] = object
+		ids = append(ids, id)
+	}
+
+	pack, err := Write(directory, oid.SHA256, ids, func(id oid.ObjectID) (string, []byte, error) {
+		object, ok := byID[id]
+		if !ok {
+			return "", nil, fmt.Errorf("unknown object %s", id)
+		}
+		return object.format, object.data, nil
+	})
+	if err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	return pack, byID
+}
+
+func TestWriteAndRead(t *testing.T) {
+	directory := t.TempDir()
+	large := bytes.Repeat([]byte("0123456789abcdef"), 4096)
+	pack, objects := writeTestPack(t, directory,
+		testObject{"blob", []byte("hello\n")},
+		testObject{"blob", nil},
+		testObject{"blob", large},
+		testObject{"commit", []byte("tree abc\n\nmessage\n")},
+		testObject{"tree", []byte("100644 a\x00")},
+		testObject{"tag", []byte("object abc\ntype commit\n")},
+	)
+
+	if len(pack.IDs()) != len(objects) {
+		t.Fatalf("Expected %d objects, got %d", len(objects), len(pack.IDs()))
+	}
+	if !strings.HasSuffix(pack.Path, "pack-"+pack.Checksum.String()+".pack") {
+		t.Errorf("Expected the pack to be named by its checksum, got %s", pack.Path)
+	}
+
+	for id, object := range objects {
+		format, data, err := ReadObject(directory, id)
+		if err != nil {
+			t.Fatalf("ReadObject(%s) failed: %v", id, err)
+		}
+		if format != object.format || !bytes.Equal(data, object.data) {
+			t.Errorf("ReadObject(%s) = %s %q, expected %s %q", id, format, data, object.format, object.data)
+		}
+	}
+
+	if _, _, err := ReadObject(directory, oid.SHA256.Sum([]byte("absent"))); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for an absent object, got %v", err)
+	}
+
+	if err := pack.Verify(); err != nil {
+		t.Errorf("Verify failed on an intact pack: %v", err)
+	}
+
+	for id := range objects {
+		found, err := FindPrefix(directory, string(id[:6]))
+		if err != nil {
+			t.Fatalf("FindPrefix failed: %v", err)
+		}
+		if len(found) != 1 || found[0] != id {
+			t.Errorf("FindPrefix(%s) = %v, expected %s", id[:6], found, id)
+		}
+	}
+}
+
+func TestListAndRemove(t *testing.T) {
+	directory := t.TempDir()
+
+	packs, err := List(directory)
+	if err != nil || len(packs) != 0 {
+		t.Fatalf("Expected no packs in an empty repository, got %v, %v", packs, err)
+	}
+
+	first, _ := writeTestPack(t, directory, testObject{"blob", []byte("one")})
+	second, objects := writeTestPack(t, directory, testObject{"blob", []byte("two")})
+
+	if packs, err = List(directory); err != nil || len(packs) != 2 {
+		t.Fatalf("Expected two packs, got %v, %v", packs, err)
+	}
+
+	if err := Remove(first); err != nil {
+		t.Fatalf("Remove failed: %v", err)
+	}
+	if packs, err = List(directory); err != nil || len(packs) != 1 || packs[0].Checksum != second.Checksum {
+		t.Fatalf("Expected only the second pack, got %v, %v", packs, err)
+	}
+
+	for id := range objects {
+		if found, err := Has(directory, id); err != nil || !found {
+			t.Errorf("Expected %s to be packed, got %v, %v", id, found, err)
+		}
+	}
+}
+
+func TestVerifyCorruption(t *testing.T) {
+	directory := t.TempDir()
+	pack, _ := writeTestPack(t, directory, testObject{"blob", []byte("some data that gets damaged")})
+
+	data, err := os.ReadFile(pack.Path)
+	if err != nil {
+		t.Fatalf("Failed to read pack: %v", err)
+	}
+	data[20] ^= 0xff
+	if err := os.WriteFile(pack.Path, data, 0644); err != nil {
+		t.Fatalf("Failed to write pack: %v", err)
+	}
+
+	if err := pack.Verify(); err == nil {
+		t.Errorf("Expected Verify to fail on a damaged pack")
+	}
+}
+
+func TestReadForgedSize(t *testing.T) {
+	directory := t.TempDir()
+	pack, _ := writeTestPack(t, directory, testObject{"blob", []byte("a small object")})
+	id := oid.SHA256.Sum([]byte("blob a small object"))
+
+	data, err := os.ReadFile(pack.Path)
+	if err != nil {
+		t.Fatalf("Failed to read pack: %v", err)
+	}
+
+	// Skip the header of the only entry, after the 12 byte pack header
+	end := 12
+	for data[end]&0x80 != 0 {
+		end++
+	}
+	end++
+
+	for _, size := range []uint64{1 << 36, 1<<63 - 1} {
+		// Rewrite the header to claim the blob inflates to size bytes
+		header := []byte{typeBlob<<4 | byte(size&0x0f) | 0x80}
+		for rest := size >> 4; rest != 0; rest >>= 7 {
+			b := byte(rest & 0x7f)
+			if rest>>7 != 0 {
+				b |= 0x80
+			}
+			header = append(header, b)
+		}
+
+		forged := append(append(append([]byte{}, data[:12]...), header...), data[end:]...)
+		if err := os.WriteFile(pack.Path, forged, 0644); err != nil {
+			t.Fatalf("Failed to write pack: %v", err)
+		}
+
+		if _, _, err := pack.Read(id); !errors.Is(err, ErrCorruptEntry) {
+			t.Errorf("Expected a corrupt entry error for size %d, got %v", size, err)
+		}
+	}
+}
@@ -0,0 +1,272 @@
+package pack
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"orf/oid"
+	"orf/repository"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+var typeCodes = map[string]byte{"commit": typeCommit, "tree": typeTree, "blob": typeBlob, "tag": typeTag}
+
+// ReadFunc returns the type and data of an object to be packed.
+type ReadFunc func(id oid.ObjectID) (string, []byte, error)
+
+// Write packs objects into objects/pack/pack-<checksum>.pack, with its .idx, and returns the
+// opened pack. The data of each object is read with read, in the order of ids. Both files are
+// written under temporary names first, and the index is renamed into place last, so readers
+// never see a partial pack. Objects are compressed at pack.compression, and core.fsync (pack,
+// pack-metadata) flushes the files to disk before they are renamed.
+func Write(directory string, algorithm oid.Algorithm, ids []oid.ObjectID, read ReadFunc) (*Pack, error) {
+	if err := os.MkdirAll(Dir(directory), os.ModePerm); err != nil {
+		return nil, err
+	}
+	settings := repository.DirectorySettings(directory)
+
+	packFile, err := os.CreateTemp(Dir(directory), "tmp_pack_")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(packFile.Name())
+	defer packFile.Close()
+
+	checksum, entries, err := writePack(packFile, algorithm, ids, read, settings.PackCompression)
+	if err != nil {
+		return nil, err
+	}
+	if err := closeFile(packFile, settings.Fsync&repository.FsyncPack != 0); err != nil {
+		return nil, err
+	}
+
+	idxFile, err := os.CreateTemp(Dir(directory), "tmp_idx_")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(idxFile.Name())
+	defer idxFile.Close()
+
+	if err := writeIndex(idxFile, algorithm, checksum, entries); err != nil {
+		return nil, err
+	}
+	if err := closeFile(idxFile, settings.Fsync&repository.FsyncPackMetadata != 0); err != nil {
+		return nil, err
+	}
+
+	base := filepath.Join(Dir(directory), "pack-"+checksum.String())
+	if err := os.Rename(packFile.Name(), base+".pack"); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(idxFile.Name(), base+".idx"); err != nil {
+		return nil, err
+	}
+	if settings.Fsync&(repository.FsyncPack|repository.FsyncPackMetadata) != 0 {
+		if err := syncDir(Dir(directory)); err != nil {
+			return nil, err
+		}
+	}
+
+	return Open(base+".idx", algorithm)
+}
+
+// closeFile closes a written file, flushing it to disk first with fsync.
+func closeFile(file *os.File, fsync bool) error {
+	if fsync {
+		if err := file.Sync(); err != nil {
+			return err
+		}
+	}
+	return file.Close()
+}
+
+// syncDir flushes a directory to disk, making the files renamed into it durable.
+func syncDir(path string) error {
+	dir, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
+
+// indexEntry is where an object was written in a pack.
+type indexEntry struct {
+	id     oid.ObjectID
+	offset int64
+	crc    uint32
+}
+
+// writePack writes the pack header, one undeltified entry per object and the checksum
+// trailer, returning the checksum and the entries to index.
+func writePack(file io.Writer, algorithm oid.Algorithm, ids []oid.ObjectID, read ReadFunc, level int) (oid.ObjectID, []indexEntry, error) {
+	buffered := bufio.NewWriter(file)
+	hasher := algorithm.New()
+	out := io.MultiWriter(buffered, hasher)
+
+	header := make([]byte, 12)
+	copy(header, "PACK")
+	binary.BigEndian.PutUint32(header[4:], 2)
+	binary.BigEndian.PutUint32(header[8:], uint32(len(ids)))
+	if _, err := out.Write(header); err != nil {
+		return "", nil, err
+	}
+
+	offset := int64(len(header))
+	entries := make([]indexEntry, 0, len(ids))
+	seen := make(map[oid.ObjectID]bool)
+	for _, id := range ids {
+		if seen[id] {
+			return "", nil, fmt.Errorf("object %s packed twice", id)
+		}
+		seen[id] = true
+
+		format, data, err := read(id)
+		if err != nil {
+			return "", nil, err
+		}
+
+		entry, err := encodeEntry(format, data, level)
+		if err != nil {
+			return "", nil, fmt.Errorf("error packing %s: %w", id, err)
+		}
+		if _, err := out.Write(entry); err != nil {
+			return "", nil, err
+		}
+
+		entries = append(entries, indexEntry{id: id, offset: offset, crc: crc32.ChecksumIEEE(entry)})
+		offset += int64(len(entry))
+	}
+
+	checksum, err := writeChecksum(buffered, hasher)
+	if err != nil {
+		return "", nil, err
+	}
+	return checksum, entries, buffered.Flush()
+}
+
+// encodeEntry returns the pack entry of an object: a header holding its type and size,
+// followed by its data, compressed at the given zlib level.
+func encodeEntry(format string, data []byte, level int) ([]byte, error) {
+	code, ok := typeCodes[format]
+	if !ok {
+		return nil, fmt.Errorf("cannot pack object of type %q", format)
+	}
+
+	var entry bytes.Buffer
+	size := uint64(len(data))
+	b := code<<4 | byte(size&0x0f)
+	size >>= 4
+	for size != 0 {
+		entry.WriteByte(b | 0x80)
+		b = byte(size & 0x7f)
+		size >>= 7
+	}
+	entry.WriteByte(b)
+
+	writer, err := zlib.NewWriterLevel(&entry, level)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := writer.Write(data); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return entry.Bytes(), nil
+}
+
+// writeChecksum appends the hash of everything written so far, which is also returned.
+func writeChecksum(buffered io.Writer, hasher hash.Hash) (oid.ObjectID, error) {
+	sum := hasher.Sum(nil)
+	if _, err := buffered.Write(sum); err != nil {
+		return "", err
+	}
+	return oid.FromBytes(sum)
+}
+
+// writeIndex writes a version 2 pack index: the fanout table, the sorted ids, their CRCs
+// and offsets, then the pack checksum and the index's own checksum.
+func writeIndex(file io.Writer, algorithm oid.Algorithm, checksum oid.ObjectID, entries []indexEntry) error {
+	sort.Slice(entries, func(i, j int) bool { return entries[i].id < entries[j].id })
+
+	buffered := bufio.NewWriter(file)
+	hasher := algorithm.New()
+	out := io.MultiWriter(buffered, hasher)
+
+	// Errors stick to the buffered writer and are returned by Flush
+	write := func(data []byte) {
+		out.Write(data)
+	}
+	word := func(value uint32) {
+		var b [4]byte
+		binary.BigEndian.PutUint32(b[:], value)
+		write(b[:])
+	}
+
+	write(idxMagic)
+	word(2)
+
+	var fanout [256]uint32
+	for _, entry := range entries {
+		raw := entry.id.Bytes()
+		if len(raw) != algorithm.Size() {
+			return fmt.Errorf("invalid object id %s", entry.id)
+		}
+		fanout[raw[0]]++
+	}
+	var total uint32
+	for _, count := range fanout {
+		total += count
+		word(total)
+	}
+
+	for _, entry := range entries {
+		write(entry.id.Bytes())
+	}
+	for _, entry := range entries {
+		word(entry.crc)
+	}
+
+	var large []int64
+	for _, entry := range entries {
+		if entry.offset < 0x80000000 {
+			word(uint32(entry.offset))
+			continue
+		}
+		word(0x80000000 | uint32(len(large)))
+		large = append(large, entry.offset)
+	}
+	for _, offset := range large {
+		var b [8]byte
+		binary.BigEndian.PutUint64(b[:], uint64(offset))
+		write(b[:])
+	}
+
+	write(checksum.Bytes())
+	if _, err := writeChecksum(buffered, hasher); err != nil {
+		return err
+	}
+	return buffered.Flush()
+}
+
+// Remove deletes a pack, its index first so readers stop finding it.
+func Remove(pack *Pack) error {
+	base := pack.Path[:len(pack.Path)-len(".pack")]
+	for _, path := range []string{base + ".idx", base + ".pack"} {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	opened.Delete(base + ".idx")
+	return nil
+}
@@ -3,6 +3,7 @@ package repository
 import (
 	"errors"
 	"fmt"
+	"orf/oid"
 	"os"
 	"path/filepath"
 	"strings"
@@ -22,6 +23,12 @@ type Repo struct {
 // repository, including branches, objects, refs, description, HEAD, and config files.
 // It returns a pointer to the Repo struct if no errors have occurred.
 func CreateRepo(path string) (*Repo, error) {
+	return CreateRepoWithFormat(path, oid.Default)
+}
+
+// CreateRepoWithFormat creates a new repository like CreateRepo, naming objects with the given
+// hash algorithm. The choice is recorded as the extensions.objectformat config key.
+func CreateRepoWithFormat(path string, format oid.Algorithm) (*Repo, error) {
 
 	repo, _ := initializeRepo(path, true)
 
@@ -54,10 +61,14 @@ func CreateRepo(path string) (*Repo, error) {
 
 	// Create .orf/config
 	configPath := filepath.Join(repo.WorkTree, ".orf", "config")
-	configContent := strings.ReplaceAll(`[core]
-		repositoryformatversion = 0
+	configContent := strings.ReplaceAll(fmt.Sprintf(`[core]
+		repositoryformatversion = 1
 		filemode = false
-		bare = false`, "\t", "")
+		bare = false
+
+		[extensions]
+		objectformat = %s
+		`, format), "\t", "")
 
 	if err := os.WriteFile(configPath, []byte(configContent), 0644); err != nil {
 		return nil, err
@@ -132,15 +143,32 @@ func readConfig(path string, force bool) (*ini.File, error) {
 	}
 
 	if !force {
+		// Version 1 allows extensions, such as the object format
 		version, err := config.Section("core").Key("repositoryformatversion").Int()
-		if err != nil || version != 0 {
+		if err != nil || (version != 0 && version != 1) {
 			return nil, fmt.Errorf("unsupported repositoryformatversion: %d", version)
 		}
+
+		if format := config.Section("extensions").Key("objectformat").String(); format != "" {
+			if _, err := oid.ParseAlgorithm(format); err != nil {
+				return nil, err
+			}
+		}
 	}
 
 	return config, nil
 }
 
+// ObjectFormat returns the hash algorithm the repository names its objects with.
+func (repo *Repo) ObjectFormat() oid.Algorithm {
+	if repo.Config != nil {
+		if format, err := oid.ParseAlgorithm(repo.Config.Section("extensions").Key("objectformat").String()); err == nil {
+			return format
+		}
+	}
+	return ObjectFormat(repo.Directory)
+}
+
 // GetFilePath returns the path to a file within the repository's work tree.
 // It will create the directory structure leading to the file (barring the actual file), ensuring it exists.
 func GetFilePath(WorkTree string, force bool, paths ...string) (string, error) {
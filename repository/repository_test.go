@@ -1,9 +1,11 @@
 package repository
 
 import (
+	"orf/oid"
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -179,3 +181,110 @@ func TestReadConfig(t *testing.T) {
 	assert.NotNil(t, config)
 	assert.Equal(t, 0, config.Section("core").Key("repositoryformatversion").MustInt())
 }
+
+func TestCreateRepoWithFormat(t *testing.T) {
+	path := t.TempDir()
+	repo, err := CreateRepoWithFormat(path, oid.SHA1)
+	assert.NoError(t, err)
+
+	config, err := readConfig(repo.Directory, false)
+	assert.NoError(t, err)
+	assert.Equal(t, "sha1", config.Section("extensions").Key("objectformat").String())
+	assert.Equal(t, 1, config.Section("core").Key("repositoryformatversion").MustInt())
+
+	assert.Equal(t, oid.SHA1, ObjectFormat(repo.Directory))
+	assert.Equal(t, oid.SHA256, ObjectFormat(t.TempDir()))
+}
+
+func TestReadConfigUnknownFormat(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), ".orf")
+	err := os.MkdirAll(configPath, os.ModePerm)
+	assert.NoError(t, err)
+
+	configContent := "[core]\nrepositoryformatversion = 1\n[extensions]\nobjectformat = md5\n"
+	err = os.WriteFile(filepath.Join(configPath, "config"), []byte(configContent), 0644)
+	assert.NoError(t, err)
+
+	_, err = readConfig(configPath, false)
+	assert.Error(t, err)
+}
+
+func TestParseFsync(t *testing.T) {
+	tests := map[string]FsyncComponents{
+		"":                       FsyncDefault,
+		"loose-object":           FsyncDefault | FsyncLooseObject,
+		"none":                   0,
+		"none,loose-object":      FsyncLooseObject,
+		"-pack-metadata":         FsyncPack | FsyncCommitGraph,
+		"objects, -pack":         FsyncLooseObject | FsyncPackMetadata | FsyncCommitGraph,
+		"all,-loose-object,pack": FsyncDefault,
+		"reference,index":        FsyncDefault,
+	}
+	for value, expected := range tests {
+		components, err := ParseFsync(value)
+		assert.NoError(t, err, value)
+		assert.Equal(t, expected, components, value)
+	}
+
+	_, err := ParseFsync("loose-objects")
+	assert.Error(t, err)
+}
+
+func TestDirectorySettings(t *testing.T) {
+	directory := t.TempDir()
+	assert.Equal(t, DefaultSettings(), DirectorySettings(directory))
+
+	configContent := "[core]\n\tcompression = 9\n\tlooseCompression = 1\n\tverifyObjects = false\n\tfsync = none,loose-object\n" +
+		"[pack]\n\tcompression = 12\n" +
+		"[extensions]\n\tobjectformat = sha1\n" +
+		"[commitGraph]\n\tchangedPaths = false\n"
+	err := os.WriteFile(filepath.Join(directory, "config"), []byte(configContent), 0644)
+	assert.NoError(t, err)
+
+	settings := DirectorySettings(directory)
+	assert.Equal(t, oid.SHA1, settings.Format)
+	assert.False(t, settings.VerifyObjects)
+	assert.Equal(t, 1, settings.LooseCompression)
+	assert.Equal(t, 9, settings.PackCompression, "an invalid pack.compression keeps core.compression")
+	assert.Equal(t, FsyncLooseObject, settings.Fsync)
+	assert.True(t, settings.CommitGraph)
+	assert.False(t, settings.ChangedPaths)
+	assert.Equal(t, oid.SHA1, ObjectFormat(directory))
+}
+
+func TestDirectorySettingsChanged(t *testing.T) {
+	directory := t.TempDir()
+	path := filepath.Join(directory, "config")
+	assert.NoError(t, os.WriteFile(path, []byte("[core]\n\tverifyObjects = false\n"), 0644))
+	assert.False(t, DirectorySettings(directory).VerifyObjects)
+
+	// A config written again in the same process is read again
+	assert.NoError(t, os.WriteFile(path, []byte("[core]\n\tverifyObjects = true\n\tlooseCompression = 1\n"), 0644))
+	settings := DirectorySettings(directory)
+	assert.True(t, settings.VerifyObjects)
+	assert.Equal(t, 1, settings.LooseCompression)
+
+	// Even when its size is unchanged
+	assert.NoError(t, os.WriteFile(path, []byte("[core]\n\tverifyObjects = true\n\tlooseCompression = 9\n"), 0644))
+	later := time.Now().Add(time.Minute)
+	assert.NoError(t, os.Chtimes(path, later, later))
+	assert.Equal(t, 9, DirectorySettings(directory).LooseCompression)
+
+	// Without a config, the defaults apply again
+	assert.NoError(t, os.Remove(path))
+	assert.Equal(t, DefaultSettings(), DirectorySettings(directory))
+}
+
+func TestParseSize(t *testing.T) {
+	tests := map[string]int64{"0": 0, "512": 512, "4k": 4 << 10, "96m": 96 << 20, "1G": 1 << 30}
+	for value, expected := range tests {
+		size, err := ParseSize(value)
+		assert.NoError(t, err, value)
+		assert.Equal(t, expected, size, value)
+	}
+
+	for _, value := range []string{"", "m", "-1", "12x"} {
+		_, err := ParseSize(value)
+		assert.Error(t, err, value)
+	}
+}
@@ -0,0 +1,266 @@
+package repository
+
+import (
+	"compress/zlib"
+	"fmt"
+	"orf/oid"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-ini/ini"
+)
+
+// FsyncComponents is a set of the files core.fsync flushes to disk once written.
+type FsyncComponents int
+
+const (
+	// FsyncLooseObject: loose objects, and the directory entry they are renamed to.
+	FsyncLooseObject FsyncComponents = 1 << iota
+	// FsyncPack: pack files.
+	FsyncPack
+	// FsyncPackMetadata: pack indexes.
+	FsyncPackMetadata
+	// FsyncCommitGraph: the commit-graph.
+	FsyncCommitGraph
+
+	fsyncObjects = FsyncLooseObject | FsyncPack
+	fsyncAll     = FsyncLooseObject | FsyncPack | FsyncPackMetadata | FsyncCommitGraph
+
+	// FsyncDefault matches git: packs, their indexes and the commit-graph, but not loose objects.
+	FsyncDefault = FsyncPack | FsyncPackMetadata | FsyncCommitGraph
+)
+
+// fsyncNames maps the components of core.fsync to the files orf writes. Components git knows
+// of that name no file written here (such as reference or index) are accepted and ignored.
+var fsyncNames = map[string]FsyncComponents{
+	"loose-object":     FsyncLooseObject,
+	"pack":             FsyncPack,
+	"pack-metadata":    FsyncPackMetadata,
+	"objects":          fsyncObjects,
+	"derived-metadata": FsyncPackMetadata | FsyncCommitGraph,
+	"committed":        fsyncObjects,
+	"added":            fsyncAll,
+	"all":              fsyncAll,
+	"reference":        0,
+	"index":            0,
+	"commit-graph":     FsyncCommitGraph,
+}
+
+// ParseFsync parses core.fsync, a comma separated list of components added to FsyncDefault,
+// or removed from it when prefixed with "-". "none" clears the components listed before it.
+func ParseFsync(value string) (FsyncComponents, error) {
+	components := FsyncDefault
+	for _, name := range strings.Split(value, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		if name == "none" {
+			components = 0
+			continue
+		}
+
+		remove := strings.HasPrefix(name, "-")
+		component, ok := fsyncNames[strings.TrimPrefix(name, "-")]
+		if !ok {
+			return 0, fmt.Errorf("unknown core.fsync component %q", name)
+		}
+		if remove {
+			components &^= component
+		} else {
+			components |= component
+		}
+	}
+	return components, nil
+}
+
+// Settings are the core settings of a .orf directory that reading and writing objects use.
+type Settings struct {
+	// Format is the hash algorithm objects are named with (extensions.objectformat).
+	Format oid.Algorithm
+	// VerifyObjects rehashes objects when they are read (core.verifyObjects, true by default).
+	VerifyObjects bool
+	// LooseCompression and PackCompression are the zlib levels (-1 to 9) of loose objects
+	// (core.looseCompression) and packs (pack.compression), both defaulting to core.compression.
+	LooseCompression int
+	PackCompression  int
+	// Fsync are the files flushed to disk once written (core.fsync).
+	Fsync FsyncComponents
+	// CommitGraph looks commits up in the commit-graph (core.commitGraph, true by default).
+	CommitGraph bool
+	// ChangedPaths writes changed-path Bloom filters into the commit-graph, and uses them to
+	// skip commits in path-limited walks (commitGraph.changedPaths, true by default).
+	ChangedPaths bool
+	// MultiPackIndex looks packed objects up in the multi-pack-index (core.multiPackIndex, true
+	// by default), and UseBitmaps answers reachability queries from its bitmaps
+	// (pack.useBitmaps, true by default).
+	MultiPackIndex bool
+	UseBitmaps     bool
+	// ObjectCacheLimit bounds the bytes of parsed objects kept in memory (core.objectCacheLimit,
+	// 0 disables the cache).
+	ObjectCacheLimit int64
+
+	// Config is the config the settings were read from, or nil, for the options of other
+	// packages (such as diff.OptionsFromConfig). It must not be modified.
+	Config *ini.File
+}
+
+// DefaultSettings are the settings of repositories without a config.
+func DefaultSettings() Settings {
+	return Settings{
+		Format:           oid.Default,
+		VerifyObjects:    true,
+		LooseCompression: zlib.DefaultCompression,
+		PackCompression:  zlib.DefaultCompression,
+		Fsync:            FsyncDefault,
+		CommitGraph:      true,
+		ChangedPaths:     true,
+		MultiPackIndex:   true,
+		UseBitmaps:       true,
+		ObjectCacheLimit: 32 << 20,
+	}
+}
+
+// SettingsFromConfig reads the settings of a config, keeping the defaults for keys that are
+// not set or not valid.
+func SettingsFromConfig(config *ini.File) Settings {
+	settings := DefaultSettings()
+	if config == nil {
+		return settings
+	}
+	settings.Config = config
+
+	if format, err := oid.ParseAlgorithm(config.Section("extensions").Key("objectformat").String()); err == nil {
+		settings.Format = format
+	}
+
+	core := config.Section("core")
+	if verify, err := core.Key("verifyObjects").Bool(); err == nil {
+		settings.VerifyObjects = verify
+	}
+
+	if level, ok := compressionLevel(core.Key("compression")); ok {
+		settings.LooseCompression = level
+		settings.PackCompression = level
+	}
+	if level, ok := compressionLevel(core.Key("looseCompression")); ok {
+		settings.LooseCompression = level
+	}
+	if level, ok := compressionLevel(config.Section("pack").Key("compression")); ok {
+		settings.PackCompression = level
+	}
+
+	if value := core.Key("fsync").String(); value != "" {
+		if components, err := ParseFsync(value); err == nil {
+			settings.Fsync = components
+		}
+	}
+
+	if commitGraph, err := core.Key("commitGraph").Bool(); err == nil {
+		settings.CommitGraph = commitGraph
+	}
+	if changedPaths, err := config.Section("commitGraph").Key("changedPaths").Bool(); err == nil {
+		settings.ChangedPaths = changedPaths
+	}
+	if multiPackIndex, err := core.Key("multiPackIndex").Bool(); err == nil {
+		settings.MultiPackIndex = multiPackIndex
+	}
+	if useBitmaps, err := config.Section("pack").Key("useBitmaps").Bool(); err == nil {
+		settings.UseBitmaps = useBitmaps
+	}
+	if limit, err := ParseSize(core.Key("objectCacheLimit").String()); err == nil {
+		settings.ObjectCacheLimit = limit
+	}
+
+	return settings
+}
+
+// ParseSize parses a size in bytes, optionally suffixed with k, m or g (e.g. "96m").
+func ParseSize(value string) (int64, error) {
+	value = strings.ToLower(strings.TrimSpace(value))
+
+	multiplier := int64(1)
+	switch {
+	case strings.HasSuffix(value, "k"):
+		multiplier = 1 << 10
+	case strings.HasSuffix(value, "m"):
+		multiplier = 1 << 20
+	case strings.HasSuffix(value, "g"):
+		multiplier = 1 << 30
+	}
+	digits := value
+	if multiplier != 1 {
+		digits = value[:len(value)-1]
+	}
+
+	size, err := strconv.ParseInt(digits, 10, 64)
+	if err != nil || size < 0 {
+		return 0, fmt.Errorf("invalid size %q", value)
+	}
+	return size * multiplier, nil
+}
+
+// compressionLevel reads a zlib level from a key, reporting whether it is set and valid.
+func compressionLevel(key *ini.Key) (int, bool) {
+	level, err := key.Int()
+	if err != nil || level < zlib.DefaultCompression || level > zlib.BestCompression {
+		return 0, false
+	}
+	return level, true
+}
+
+// cachedSettings are the settings read from a config, valid while the file is unchanged.
+type cachedSettings struct {
+	modTime  time.Time
+	size     int64
+	settings Settings
+}
+
+// settingsCache caches the settings of each .orf directory, keyed by its path.
+var settingsCache sync.Map
+
+// DirectorySettings returns the settings read from <directory>/config. Directories without a
+// config use DefaultSettings. The config is only read again when it changes.
+func DirectorySettings(directory string) Settings {
+	if directory == "" {
+		return DefaultSettings()
+	}
+
+	// Only cache settings read from an existing config, a repository may still be initializing
+	path := filepath.Join(directory, "config")
+	info, err := os.Stat(path)
+	if err != nil {
+		return DefaultSettings()
+	}
+
+	if cached, ok := settingsCache.Load(directory); ok {
+		if cached := cached.(cachedSettings); cached.modTime.Equal(info.ModTime()) && cached.size == info.Size() {
+			return cached.settings
+		}
+	}
+
+	config, err := ini.Load(path)
+	if err != nil {
+		return DefaultSettings()
+	}
+
+	settings := SettingsFromConfig(config)
+	settingsCache.Store(directory, cachedSettings{modTime: info.ModTime(), size: info.Size(), settings: settings})
+	return settings
+}
+
+// ObjectFormat returns the hash algorithm declared in <directory>/config (extensions.objectformat).
+// Repositories without a config or without the extension use oid.Default.
+func ObjectFormat(directory string) oid.Algorithm {
+	return DirectorySettings(directory).Format
+}
+
+// VerifyObjects reports whether objects read from <directory> should be rehashed against their
+// id (core.verifyObjects, true unless set to false).
+func VerifyObjects(directory string) bool {
+	return DirectorySettings(directory).VerifyObjects
+}
@@ -0,0 +1,165 @@
+package revwalk
+
+import (
+	"orf/diff"
+	"orf/object"
+	"orf/oid"
+	"regexp"
+	"strings"
+)
+
+// matches applies the author, committer, message and pickaxe filters to a commit.
+func (walker *Walker) matches(n *node) (bool, error) {
+	opts := walker.opts
+
+	if opts.Author != nil && !matchSignature(opts.Author, n.commit.Author) {
+		return false, nil
+	}
+	if opts.Committer != nil && !matchSignature(opts.Committer, n.commit.Committer) {
+		return false, nil
+	}
+	if opts.Grep != nil && !opts.Grep.MatchString(n.commit.Message()) {
+		return false, nil
+	}
+
+	if opts.PickaxeString == "" && opts.PickaxeRegexp == nil {
+		return true, nil
+	}
+
+	// Like git, merges are not searched
+	parents := n.info.Parents
+	if len(parents) > 1 {
+		return false, nil
+	}
+
+	changes, err := walker.changes(n.info.Tree, parents)
+	if err != nil {
+		return false, err
+	}
+
+	for _, change := range changes {
+		if !walker.inPaths(change.Path()) {
+			continue
+		}
+
+		oldData, err := walker.blob(change.OldHash)
+		if err != nil {
+			return false, err
+		}
+		newData, err := walker.blob(change.NewHash)
+		if err != nil {
+			return false, err
+		}
+
+		if opts.PickaxeString != "" && strings.Count(string(oldData), opts.PickaxeString) != strings.Count(string(newData), opts.PickaxeString) {
+			return true, nil
+		}
+		if opts.PickaxeRegexp != nil && changedLineMatches(opts.PickaxeRegexp, oldData, newData) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// followRename switches the followed path to its old name if the commit renamed it.
+func (walker *Walker) followRename(n *node, parent oid.ObjectID) error {
+	info, err := walker.graph.Commit(parent)
+	if err != nil {
+		return err
+	}
+
+	oldFiles, err := object.FlattenTree(walker.repo, info.Tree)
+	if err != nil {
+		return err
+	}
+
+	changes, err := walker.changes(n.info.Tree, []oid.ObjectID{parent})
+	if err != nil {
+		return err
+	}
+
+	changes, err = diff.DetectRenames(changes, oldFiles, walker.renames, walker.blob)
+	if err != nil {
+		return err
+	}
+
+	for _, change := range changes {
+		if change.Type == diff.Renamed && change.NewPath == walker.opts.Paths[0] {
+			walker.opts.Paths = []string{change.OldPath}
+			walker.trees = make(map[oid.ObjectID][]oid.ObjectID)
+			return nil
+		}
+	}
+
+	return nil
+}
+
+// changes lists the files changed by a commit, given its tree, relative to its first parent
+// (or an empty tree, for a root commit).
+func (walker *Walker) changes(tree oid.ObjectID, parents []oid.ObjectID) ([]diff.Change, error) {
+	newFiles, err := object.FlattenTree(walker.repo, tree)
+	if err != nil {
+		return nil, err
+	}
+
+	oldFiles := make(map[string]oid.ObjectID)
+	if len(parents) > 0 {
+		parent, err := walker.graph.Commit(parents[0])
+		if err != nil {
+			return nil, err
+		}
+		oldFiles, err = object.FlattenTree(walker.repo, parent.Tree)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return diff.Compare(oldFiles, newFiles), nil
+}
+
+// blob reads the contents of a blob; an empty hash reads as no content.
+func (walker *Walker) blob(hash oid.ObjectID) ([]byte, error) {
+	if hash == "" {
+		return nil, nil
+	}
+
+	obj, err := object.ReadObject(walker.repo.Directory, hash)
+	if err != nil {
+		return nil, err
+	}
+	return obj.GetData(), nil
+}
+
+// inPaths reports whether a file is covered by the limiting paths (all files, if there are none).
+func (walker *Walker) inPaths(path string) bool {
+	if len(walker.opts.Paths) == 0 {
+		return true
+	}
+
+	for _, limit := range walker.opts.Paths {
+		limit = strings.Trim(limit, "/")
+		if limit == "" || path == limit || strings.HasPrefix(path, limit+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func matchSignature(re *regexp.Regexp, signature func() (*object.Signature, error)) bool {
+	parsed, err := signature()
+	if err != nil {
+		return false
+	}
+	return re.MatchString(parsed.Name + " <" + parsed.Email + ">")
+}
+
+// changedLineMatches reports whether any line added or removed between old and new matches re.
+func changedLineMatches(re *regexp.Regexp, oldData, newData []byte) bool {
+	for _, edit := range diff.Lines(diff.SplitLines(oldData), diff.SplitLines(newData)) {
+		if edit.Op != diff.Equal && re.MatchString(edit.Text) {
+			return true
+		}
+	}
+	return false
+}
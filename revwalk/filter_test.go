@@ -0,0 +1,103 @@
+package revwalk
+
+import (
+	"orf/object"
+	"orf/oid"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+)
+
+// filterHistory builds a line of four commits: Alice adds old.txt, Bob renames it to new.txt,
+// Alice adds a line to it and Bob changes other.txt.
+type filterHistory struct {
+	revRange *object.Range
+	add      oid.ObjectID
+	rename   oid.ObjectID
+	edit     oid.ObjectID
+	other    oid.ObjectID
+}
+
+func createFilterHistory(t *testing.T) (*filterHistory, func(Options) []oid.ObjectID) {
+	repo := createTestRepo(t)
+	alice := "Alice <alice@example.com>"
+	bob := "Bob <bob@example.com>"
+
+	h := &filterHistory{}
+	h.add = writeTestCommitBy(t, repo, alice, map[string]string{"old.txt": "hello\nworld\n", "other.txt": "x\n"}, nil, 1000, "Add file")
+	h.rename = writeTestCommitBy(t, repo, bob, map[string]string{"new.txt": "hello\nworld\n", "other.txt": "x\n"}, []oid.ObjectID{h.add}, 2000, "Rename file")
+	h.edit = writeTestCommitBy(t, repo, alice, map[string]string{"new.txt": "hello\nthere\nworld\n", "other.txt": "x\n"}, []oid.ObjectID{h.rename}, 3000, "Add greeting")
+	h.other = writeTestCommitBy(t, repo, bob, map[string]string{"new.txt": "hello\nthere\nworld\n", "other.txt": "y\n"}, []oid.ObjectID{h.edit}, 4000, "Touch other")
+	h.revRange = &object.Range{Include: []oid.ObjectID{h.other}}
+
+	return h, func(opts Options) []oid.ObjectID {
+		return collect(t, repo, h.revRange, opts)
+	}
+}
+
+func TestWalkSignatureAndMessageFilters(t *testing.T) {
+	h, walk := createFilterHistory(t)
+
+	opts := DefaultOptions()
+	opts.Author = regexp.MustCompile("Alice")
+	assertHashes(t, "author", walk(opts), []oid.ObjectID{h.edit, h.add})
+
+	opts = DefaultOptions()
+	opts.Committer = regexp.MustCompile("bob@example")
+	assertHashes(t, "committer", walk(opts), []oid.ObjectID{h.other, h.rename})
+
+	opts = DefaultOptions()
+	opts.Grep = regexp.MustCompile("^(Add|Touch)")
+	opts.Author = regexp.MustCompile("Bob")
+	assertHashes(t, "grep and author", walk(opts), []oid.ObjectID{h.other})
+}
+
+func TestWalkFollow(t *testing.T) {
+	h, walk := createFilterHistory(t)
+
+	opts := DefaultOptions()
+	opts.Paths = []string{"new.txt"}
+	assertHashes(t, "path", walk(opts), []oid.ObjectID{h.edit, h.rename})
+
+	opts.Follow = true
+	assertHashes(t, "follow", walk(opts), []oid.ObjectID{h.edit, h.rename, h.add})
+
+	opts.Paths = nil
+	if _, err := New(nil, h.revRange, opts); err == nil {
+		t.Errorf("Expected an error for --follow without a path")
+	}
+}
+
+func TestWalkFollowRenameSettings(t *testing.T) {
+	repo := createTestRepo(t)
+	add := writeTestCommitBy(t, repo, "Alice <alice@example.com>", map[string]string{"old.txt": "one\ntwo\nthree\nfour\n"}, nil, 1000, "Add file")
+	rename := writeTestCommitBy(t, repo, "Alice <alice@example.com>", map[string]string{"new.txt": "one\ntwo\nthree\nfive\n"}, []oid.ObjectID{add}, 2000, "Rename and edit file")
+	revRange := &object.Range{Include: []oid.ObjectID{rename}}
+
+	opts := DefaultOptions()
+	opts.Paths = []string{"new.txt"}
+	opts.Follow = true
+	assertHashes(t, "default threshold", collect(t, repo, revRange, opts), []oid.ObjectID{rename, add})
+
+	// A stricter threshold than the similarity of the edited file stops the follow
+	if err := os.WriteFile(filepath.Join(repo.Directory, "config"), []byte("[diff]\n\trenameThreshold = 90\n"), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	assertHashes(t, "diff.renameThreshold", collect(t, repo, revRange, opts), []oid.ObjectID{rename})
+}
+
+func TestWalkPickaxe(t *testing.T) {
+	h, walk := createFilterHistory(t)
+
+	opts := DefaultOptions()
+	opts.PickaxeString = "there"
+	assertHashes(t, "-S there", walk(opts), []oid.ObjectID{h.edit})
+
+	opts = DefaultOptions()
+	opts.PickaxeRegexp = regexp.MustCompile("wor")
+	assertHashes(t, "-G wor", walk(opts), []oid.ObjectID{h.rename, h.add})
+
+	opts.Paths = []string{"other.txt"}
+	assertHashes(t, "-G wor -- other.txt", walk(opts), nil)
+}
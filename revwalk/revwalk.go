@@ -0,0 +1,538 @@
+package revwalk
+
+import (
+	"container/heap"
+	"errors"
+	"fmt"
+	"io"
+	"orf/commitgraph"
+	"orf/diff"
+	"orf/mergebase"
+	"orf/object"
+	"orf/oid"
+	"orf/repository"
+	"regexp"
+	"time"
+)
+
+// Sort selects the order in which a Walker returns commits.
+type Sort int
+
+const (
+	// SortDefault returns commits youngest first by committer date, without waiting for
+	// all children of a commit to be shown first (like git rev-list with no ordering flags).
+	SortDefault Sort = iota
+	// SortDate never shows a commit before all of its children, ordering by committer date otherwise.
+	SortDate
+	// SortTopo never shows a commit before all of its children, and avoids interleaving
+	// commits from separate lines of history.
+	SortTopo
+)
+
+// Options controls which commits a Walker returns and in what order.
+type Options struct {
+	Sort    Sort
+	Reverse bool
+
+	// MaxCount limits the number of commits returned; a negative value means no limit.
+	MaxCount int
+	Skip     int
+
+	// Since and Until limit commits by committer date; zero values are ignored.
+	Since time.Time
+	Until time.Time
+
+	// FirstParent follows only the first parent of merge commits.
+	FirstParent bool
+
+	// Paths limits the walk to commits that change one of the given paths. Merges that match
+	// one of their parents for every path are skipped, and only that parent is followed.
+	Paths []string
+
+	// Follow continues a single path across renames: when the commit adding the path renamed
+	// it, the walk goes on with the old name.
+	Follow bool
+
+	// Author, Committer and Grep keep only commits whose author ("Name <email>"), committer
+	// or message matches.
+	Author    *regexp.Regexp
+	Committer *regexp.Regexp
+	Grep      *regexp.Regexp
+
+	// PickaxeString keeps only commits that change the number of occurrences of a string
+	// in a file (-S); PickaxeRegexp keeps those adding or removing a matching line (-G).
+	PickaxeString string
+	PickaxeRegexp *regexp.Regexp
+}
+
+// DefaultOptions returns options that walk every commit in the default order.
+func DefaultOptions() Options {
+	return Options{MaxCount: -1}
+}
+
+// Walker iterates over the commits of a Range.
+type Walker struct {
+	repo  *repository.Repo
+	opts  Options
+	graph *commitgraph.Reader
+
+	// renames are the rename detection settings of the repository, for Follow
+	renames diff.Options
+
+	queue  *nodeQueue
+	seen   map[oid.ObjectID]bool
+	hidden map[oid.ObjectID]bool
+	trees  map[oid.ObjectID][]oid.ObjectID
+
+	sorted   []*node // commits in output order, once SortDate or SortTopo has run
+	prepared bool
+	reversed []*node
+
+	skipped  int
+	returned int
+}
+
+// node is a commit visited by the walk, with the parents the walk followed. Walking only needs
+// what the commit-graph records of it (info); the commit is parsed once it may be shown.
+type node struct {
+	hash    oid.ObjectID
+	info    *commitgraph.Commit
+	commit  *object.Commit
+	when    time.Time
+	parents []oid.ObjectID
+	show    bool
+	order   int
+}
+
+// New creates a Walker over the commits reachable from revRange.Include but not from
+// revRange.Exclude. For a symmetric range, the merge bases of the included commits (and
+// so every commit reachable from all of them) are excluded too.
+func New(repo *repository.Repo, revRange *object.Range, opts Options) (*Walker, error) {
+	if opts.Follow && len(opts.Paths) != 1 {
+		return nil, fmt.Errorf("--follow requires exactly one path")
+	}
+
+	walker := &Walker{
+		repo:    repo,
+		opts:    opts,
+		graph:   commitgraph.NewReader(repo),
+		renames: diff.OptionsFromConfig(repository.DirectorySettings(repo.Directory).Config),
+		queue:   &nodeQueue{},
+		seen:    make(map[oid.ObjectID]bool),
+		hidden:  make(map[oid.ObjectID]bool),
+		trees:   make(map[oid.ObjectID][]oid.ObjectID),
+	}
+
+	for _, hash := range revRange.Exclude {
+		if err := walker.ancestors(hash, walker.hidden); err != nil {
+			return nil, err
+		}
+	}
+
+	if revRange.Symmetric && len(revRange.Include) > 1 {
+		bases, err := mergebase.Octopus(repo, revRange.Include...)
+		if err != nil {
+			return nil, err
+		}
+		for _, base := range bases {
+			if err := walker.ancestors(base, walker.hidden); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	for _, hash := range revRange.Include {
+		if err := walker.push(hash); err != nil {
+			return nil, err
+		}
+	}
+
+	return walker, nil
+}
+
+// Next returns the next commit, or io.EOF once the walk is done.
+func (walker *Walker) Next() (oid.ObjectID, *object.Commit, error) {
+	if walker.opts.Reverse {
+		if !walker.prepared {
+			for {
+				n, err := walker.limited()
+				if err != nil {
+					return "", nil, err
+				}
+				if n == nil {
+					break
+				}
+				walker.reversed = append(walker.reversed, n)
+			}
+			walker.prepared = true
+		}
+
+		if len(walker.reversed) == 0 {
+			return "", nil, io.EOF
+		}
+
+		n := walker.reversed[len(walker.reversed)-1]
+		walker.reversed = walker.reversed[:len(walker.reversed)-1]
+		return n.hash, n.commit, nil
+	}
+
+	n, err := walker.limited()
+	if err != nil {
+		return "", nil, err
+	}
+	if n == nil {
+		return "", nil, io.EOF
+	}
+	return n.hash, n.commit, nil
+}
+
+// ForEach calls fn for every remaining commit, stopping at the first error.
+func (walker *Walker) ForEach(fn func(hash oid.ObjectID, commit *object.Commit) error) error {
+	for {
+		hash, commit, err := walker.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(hash, commit); err != nil {
+			return err
+		}
+	}
+}
+
+// limited returns the next commit that passes the path, date and content filters, applying
+// Skip and MaxCount.
+func (walker *Walker) limited() (*node, error) {
+	for {
+		if walker.opts.MaxCount >= 0 && walker.returned >= walker.opts.MaxCount {
+			return nil, nil
+		}
+
+		n, err := walker.ordered()
+		if err != nil || n == nil {
+			return nil, err
+		}
+
+		if !n.show {
+			continue
+		}
+		if !walker.opts.Since.IsZero() && n.when.Before(walker.opts.Since) {
+			continue
+		}
+		if !walker.opts.Until.IsZero() && n.when.After(walker.opts.Until) {
+			continue
+		}
+
+		if n.commit == nil {
+			if n.commit, err = object.ReadCommit(walker.repo, n.hash); err != nil {
+				return nil, err
+			}
+		}
+
+		matched, err := walker.matches(n)
+		if err != nil {
+			return nil, err
+		}
+		if !matched {
+			continue
+		}
+
+		if walker.skipped < walker.opts.Skip {
+			walker.skipped++
+			continue
+		}
+
+		walker.returned++
+		return n, nil
+	}
+}
+
+// ordered returns the next commit in the requested order, whether or not it will be shown.
+func (walker *Walker) ordered() (*node, error) {
+	if walker.opts.Sort == SortDefault {
+		return walker.walk()
+	}
+
+	if walker.sorted == nil {
+		var all []*node
+		for {
+			n, err := walker.walk()
+			if err != nil {
+				return nil, err
+			}
+			if n == nil {
+				break
+			}
+			all = append(all, n)
+		}
+		walker.sorted = sortTopological(all, walker.opts.Sort)
+	}
+
+	if len(walker.sorted) == 0 {
+		return nil, nil
+	}
+
+	n := walker.sorted[0]
+	walker.sorted = walker.sorted[1:]
+	return n, nil
+}
+
+// walk pops the youngest queued commit and queues the parents it should follow.
+func (walker *Walker) walk() (*node, error) {
+	if walker.queue.Len() == 0 {
+		return nil, nil
+	}
+
+	n := heap.Pop(walker.queue).(*node)
+
+	parents := n.info.Parents
+	if walker.opts.FirstParent && len(parents) > 1 {
+		parents = parents[:1]
+	}
+
+	n.show = true
+	if len(walker.opts.Paths) > 0 {
+		var err error
+		parents, n.show, err = walker.simplify(n, parents)
+		if err != nil {
+			return nil, err
+		}
+	}
+	n.parents = parents
+
+	for _, parent := range parents {
+		if err := walker.push(parent); err != nil {
+			return nil, err
+		}
+	}
+
+	return n, nil
+}
+
+// push queues a commit unless it has already been seen or is excluded.
+func (walker *Walker) push(hash oid.ObjectID) error {
+	if walker.seen[hash] || walker.hidden[hash] {
+		return nil
+	}
+	walker.seen[hash] = true
+
+	info, err := walker.graph.Commit(hash)
+	if err != nil {
+		return err
+	}
+
+	heap.Push(walker.queue, &node{hash: hash, info: info, when: info.When, order: len(walker.seen)})
+	return nil
+}
+
+// simplify decides whether a commit touches the limiting paths and which parents to follow.
+// A commit identical to one of its parents at every path is hidden, and only that parent is
+// followed; a root commit is shown if any of the paths exist in it.
+func (walker *Walker) simplify(n *node, parents []oid.ObjectID) ([]oid.ObjectID, bool, error) {
+	// The changed-path Bloom filters tell most commits identical to their first parent apart
+	// without reading their trees
+	if len(parents) > 0 && !walker.maybeChanged(n.hash) {
+		return parents[:1], false, nil
+	}
+
+	entries, err := walker.pathEntries(n.hash, n.info.Tree)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if len(parents) == 0 {
+		for _, entry := range entries {
+			if entry != "" {
+				return parents, true, nil
+			}
+		}
+		return parents, false, nil
+	}
+
+	var firstEntries []oid.ObjectID
+	for i, parent := range parents {
+		info, err := walker.graph.Commit(parent)
+		if err != nil {
+			return nil, false, err
+		}
+
+		parentEntries, err := walker.pathEntries(parent, info.Tree)
+		if err != nil {
+			return nil, false, err
+		}
+
+		if equalEntries(entries, parentEntries) {
+			return []oid.ObjectID{parent}, false, nil
+		}
+		if i == 0 {
+			firstEntries = parentEntries
+		}
+	}
+
+	// The followed path was added here; keep following it under its old name if it was renamed
+	if walker.opts.Follow && entries[0] != "" && firstEntries[0] == "" {
+		if err := walker.followRename(n, parents[0]); err != nil {
+			return nil, false, err
+		}
+	}
+
+	return parents, true, nil
+}
+
+// maybeChanged reports whether a commit may change any of the limiting paths relative to its
+// first parent.
+func (walker *Walker) maybeChanged(hash oid.ObjectID) bool {
+	for _, path := range walker.opts.Paths {
+		if walker.graph.MaybeChanged(hash, path) {
+			return true
+		}
+	}
+	return false
+}
+
+// pathEntries returns the object at each limiting path in the tree of a commit, or "" if the
+// path does not exist.
+func (walker *Walker) pathEntries(hash oid.ObjectID, tree oid.ObjectID) ([]oid.ObjectID, error) {
+	if entries, ok := walker.trees[hash]; ok {
+		return entries, nil
+	}
+
+	entries := make([]oid.ObjectID, len(walker.opts.Paths))
+	for i, path := range walker.opts.Paths {
+		entry, err := object.LookupPath(walker.repo, tree, path)
+		if err != nil && !errors.Is(err, object.ErrNotFound) {
+			return nil, err
+		}
+		entries[i] = entry
+	}
+
+	walker.trees[hash] = entries
+	return entries, nil
+}
+
+// ancestors adds hash and every commit reachable from it to set.
+func (walker *Walker) ancestors(hash oid.ObjectID, set map[oid.ObjectID]bool) error {
+	stack := []oid.ObjectID{hash}
+
+	for len(stack) > 0 {
+		current := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		if set[current] {
+			continue
+		}
+		set[current] = true
+
+		info, err := walker.graph.Commit(current)
+		if err != nil {
+			return err
+		}
+		stack = append(stack, info.Parents...)
+	}
+
+	return nil
+}
+
+// sortTopological orders the walked commits so that no commit comes before its children.
+// SortDate breaks ties by committer date; SortTopo keeps following the most recently shown
+// commit's parents before starting another line of history.
+func sortTopological(nodes []*node, sort Sort) []*node {
+	byHash := make(map[oid.ObjectID]*node, len(nodes))
+	for _, n := range nodes {
+		byHash[n.hash] = n
+	}
+
+	children := make(map[oid.ObjectID]int, len(nodes))
+	for _, n := range nodes {
+		for _, parent := range n.parents {
+			if _, ok := byHash[parent]; ok {
+				children[parent]++
+			}
+		}
+	}
+
+	var sorted []*node
+	if sort == SortDate {
+		ready := &nodeQueue{}
+		for _, n := range nodes {
+			if children[n.hash] == 0 {
+				heap.Push(ready, n)
+			}
+		}
+
+		for ready.Len() > 0 {
+			n := heap.Pop(ready).(*node)
+			sorted = append(sorted, n)
+
+			for _, parent := range n.parents {
+				if p, ok := byHash[parent]; ok {
+					children[parent]--
+					if children[parent] == 0 {
+						heap.Push(ready, p)
+					}
+				}
+			}
+		}
+		return sorted
+	}
+
+	// Tips are pushed oldest first so the youngest is popped first
+	var stack []*node
+	for i := len(nodes) - 1; i >= 0; i-- {
+		if children[nodes[i].hash] == 0 {
+			stack = append(stack, nodes[i])
+		}
+	}
+
+	for len(stack) > 0 {
+		n := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		sorted = append(sorted, n)
+
+		// Push parents in reverse so the first parent is shown next
+		for i := len(n.parents) - 1; i >= 0; i-- {
+			if p, ok := byHash[n.parents[i]]; ok {
+				children[p.hash]--
+				if children[p.hash] == 0 {
+					stack = append(stack, p)
+				}
+			}
+		}
+	}
+
+	return sorted
+}
+
+func equalEntries(a, b []oid.ObjectID) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// nodeQueue is a max-heap of commits ordered by committer date, then by the order in which
+// they were first seen.
+type nodeQueue []*node
+
+func (q nodeQueue) Len() int { return len(q) }
+func (q nodeQueue) Less(i, j int) bool {
+	if q[i].when.Equal(q[j].when) {
+		return q[i].order < q[j].order
+	}
+	return q[i].when.After(q[j].when)
+}
+func (q nodeQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *nodeQueue) Push(x interface{}) { *q = append(*q, x.(*node)) }
+func (q *nodeQueue) Pop() interface{} {
+	old := *q
+	item := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return item
+}
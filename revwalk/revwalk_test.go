@@ -0,0 +1,359 @@
+package revwalk
+
+import (
+	"fmt"
+	"orf/commitgraph"
+	"orf/kv"
+	"orf/object"
+	"orf/oid"
+	"orf/repository"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func createTestRepo(t testing.TB) *repository.Repo {
+	workTree := t.TempDir()
+	directory := filepath.Join(workTree, ".orf")
+
+	for _, dir := range []string{"objects", filepath.Join("refs", "heads"), filepath.Join("refs", "tags")} {
+		if err := os.MkdirAll(filepath.Join(directory, dir), os.ModePerm); err != nil {
+			t.Fatalf("Failed to create directories: %v", err)
+		}
+	}
+
+	return &repository.Repo{WorkTree: workTree, Directory: directory}
+}
+
+// writeTestCommit writes a commit whose tree maps each file name to a blob with the given contents.
+func writeTestCommit(t *testing.T, repo *repository.Repo, files map[string]string, parents []oid.ObjectID, when int64, message string) oid.ObjectID {
+	return writeTestCommitBy(t, repo, "Test <test@example.com>", files, parents, when, message)
+}
+
+// writeTestCommitBy writes a commit with the given author and committer.
+func writeTestCommitBy(t *testing.T, repo *repository.Repo, author string, files map[string]string, parents []oid.ObjectID, when int64, message string) oid.ObjectID {
+	tree := object.CreateTree(nil)
+	for path, contents := range files {
+		blob, err := object.WriteObject(repo.Directory, object.CreateBlob([]byte(contents)))
+		if err != nil {
+			t.Fatalf("Failed to write blob: %v", err)
+		}
+		tree.Leaves = append(tree.Leaves, &object.Leaf{Mode: []byte("100644"), Path: path, Hash: blob})
+	}
+
+	data, err := tree.Serialize()
+	if err != nil {
+		t.Fatalf("Failed to serialize tree: %v", err)
+	}
+	treeHash, err := object.WriteObject(repo.Directory, object.CreateTree(data))
+	if err != nil {
+		t.Fatalf("Failed to write tree: %v", err)
+	}
+
+	kvData := kv.CreateOrderedMap()
+	kvData.Add("tree", treeHash.String())
+	if len(parents) == 1 {
+		kvData.Add("parent", parents[0].String())
+	} else if len(parents) > 1 {
+		var values []string
+		for _, parent := range parents {
+			values = append(values, parent.String())
+		}
+		kvData.Add("parent", values)
+	}
+	kvData.Add("author", fmt.Sprintf("%s %d +0000", author, when))
+	kvData.Add("committer", fmt.Sprintf("%s %d +0000", author, when))
+	kvData.Add("message", []byte(message))
+
+	hash, err := object.WriteObject(repo.Directory, object.CreateCommit(kv.Serialize(kvData)))
+	if err != nil {
+		t.Fatalf("Failed to write commit: %v", err)
+	}
+	return hash
+}
+
+// testHistory builds:
+//
+//	root <- second <- third <- merge
+//	     <- side   <----------/
+//
+// second and third change a.txt, side changes b.txt.
+type testHistory struct {
+	repo   *repository.Repo
+	root   oid.ObjectID
+	second oid.ObjectID
+	third  oid.ObjectID
+	side   oid.ObjectID
+	merge  oid.ObjectID
+}
+
+func createTestHistory(t *testing.T) *testHistory {
+	repo := createTestRepo(t)
+
+	history := &testHistory{repo: repo}
+	history.root = writeTestCommit(t, repo, map[string]string{"a.txt": "a1", "b.txt": "b1"}, nil, 1000, "Initial commit")
+	history.second = writeTestCommit(t, repo, map[string]string{"a.txt": "a2", "b.txt": "b1"}, []oid.ObjectID{history.root}, 2000, "Second commit")
+	history.side = writeTestCommit(t, repo, map[string]string{"a.txt": "a1", "b.txt": "b2"}, []oid.ObjectID{history.root}, 3000, "Side commit")
+	history.third = writeTestCommit(t, repo, map[string]string{"a.txt": "a3", "b.txt": "b1"}, []oid.ObjectID{history.second}, 3500, "Third commit")
+	history.merge = writeTestCommit(t, repo, map[string]string{"a.txt": "a3", "b.txt": "b2"}, []oid.ObjectID{history.third, history.side}, 4000, "Merge side")
+
+	return history
+}
+
+func collect(t *testing.T, repo *repository.Repo, revRange *object.Range, opts Options) []oid.ObjectID {
+	walker, err := New(repo, revRange, opts)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	var hashes []oid.ObjectID
+	err = walker.ForEach(func(hash oid.ObjectID, commit *object.Commit) error {
+		hashes = append(hashes, hash)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Walk failed: %v", err)
+	}
+	return hashes
+}
+
+func assertHashes(t *testing.T, name string, actual, expected []oid.ObjectID) {
+	t.Helper()
+	if len(actual) != len(expected) {
+		t.Errorf("%s: expected %v, got %v", name, expected, actual)
+		return
+	}
+	for i := range expected {
+		if actual[i] != expected[i] {
+			t.Errorf("%s: expected %v, got %v", name, expected, actual)
+			return
+		}
+	}
+}
+
+func TestWalkOrders(t *testing.T) {
+	h := createTestHistory(t)
+	head := &object.Range{Include: []oid.ObjectID{h.merge}}
+
+	opts := DefaultOptions()
+	assertHashes(t, "default", collect(t, h.repo, head, opts), []oid.ObjectID{h.merge, h.third, h.side, h.second, h.root})
+
+	opts.Sort = SortTopo
+	assertHashes(t, "topo", collect(t, h.repo, head, opts), []oid.ObjectID{h.merge, h.third, h.second, h.side, h.root})
+
+	opts.Sort = SortDate
+	assertHashes(t, "date", collect(t, h.repo, head, opts), []oid.ObjectID{h.merge, h.third, h.side, h.second, h.root})
+
+	opts = DefaultOptions()
+	opts.Reverse = true
+	assertHashes(t, "reverse", collect(t, h.repo, head, opts), []oid.ObjectID{h.root, h.second, h.side, h.third, h.merge})
+}
+
+func TestWalkDateOrderWithClockSkew(t *testing.T) {
+	repo := createTestRepo(t)
+
+	// older has a later date than its child, so a plain date walk shows it too early
+	older := writeTestCommit(t, repo, nil, nil, 3000, "Older")
+	skewed := writeTestCommit(t, repo, nil, []oid.ObjectID{older}, 1000, "Skewed")
+	tip := writeTestCommit(t, repo, nil, []oid.ObjectID{skewed}, 4000, "Tip")
+	other := writeTestCommit(t, repo, nil, []oid.ObjectID{older}, 2000, "Other")
+
+	revRange := &object.Range{Include: []oid.ObjectID{tip, other}}
+
+	assertHashes(t, "default", collect(t, repo, revRange, DefaultOptions()), []oid.ObjectID{tip, other, older, skewed})
+
+	opts := DefaultOptions()
+	opts.Sort = SortDate
+	assertHashes(t, "date", collect(t, repo, revRange, opts), []oid.ObjectID{tip, other, skewed, older})
+}
+
+func TestWalkRanges(t *testing.T) {
+	h := createTestHistory(t)
+
+	exclude := &object.Range{Include: []oid.ObjectID{h.merge}, Exclude: []oid.ObjectID{h.third}}
+	assertHashes(t, "third..merge", collect(t, h.repo, exclude, DefaultOptions()), []oid.ObjectID{h.merge, h.side})
+
+	symmetric := &object.Range{Include: []oid.ObjectID{h.third, h.side}, Symmetric: true}
+	assertHashes(t, "third...side", collect(t, h.repo, symmetric, DefaultOptions()), []oid.ObjectID{h.third, h.side, h.second})
+}
+
+func TestWalkLimits(t *testing.T) {
+	h := createTestHistory(t)
+	head := &object.Range{Include: []oid.ObjectID{h.merge}}
+
+	opts := DefaultOptions()
+	opts.MaxCount = 2
+	opts.Skip = 1
+	assertHashes(t, "skip and max-count", collect(t, h.repo, head, opts), []oid.ObjectID{h.third, h.side})
+
+	opts = DefaultOptions()
+	opts.MaxCount = 2
+	opts.Reverse = true
+	assertHashes(t, "reverse max-count", collect(t, h.repo, head, opts), []oid.ObjectID{h.third, h.merge})
+
+	opts = DefaultOptions()
+	opts.MaxCount = 0
+	assertHashes(t, "max-count 0", collect(t, h.repo, head, opts), nil)
+
+	opts = DefaultOptions()
+	opts.Since = time.Unix(2500, 0)
+	opts.Until = time.Unix(3600, 0)
+	assertHashes(t, "since and until", collect(t, h.repo, head, opts), []oid.ObjectID{h.third, h.side})
+
+	opts = DefaultOptions()
+	opts.FirstParent = true
+	assertHashes(t, "first-parent", collect(t, h.repo, head, opts), []oid.ObjectID{h.merge, h.third, h.second, h.root})
+}
+
+func TestWalkPaths(t *testing.T) {
+	h := createTestHistory(t)
+	head := &object.Range{Include: []oid.ObjectID{h.merge}}
+
+	opts := DefaultOptions()
+	opts.Paths = []string{"b.txt"}
+	assertHashes(t, "b.txt", collect(t, h.repo, head, opts), []oid.ObjectID{h.side, h.root})
+
+	opts.Paths = []string{"a.txt"}
+	assertHashes(t, "a.txt", collect(t, h.repo, head, opts), []oid.ObjectID{h.third, h.second, h.root})
+
+	opts.Paths = []string{"missing.txt"}
+	assertHashes(t, "missing.txt", collect(t, h.repo, head, opts), nil)
+}
+
+func TestWalkWithCommitGraph(t *testing.T) {
+	h := createTestHistory(t)
+	if err := os.WriteFile(filepath.Join(h.repo.Directory, "refs", "heads", "master"), []byte(h.merge.String()+"\n"), 0644); err != nil {
+		t.Fatalf("Failed to write ref: %v", err)
+	}
+	if _, err := commitgraph.Write(h.repo); err != nil {
+		t.Fatalf("Failed to write commit-graph: %v", err)
+	}
+
+	head := &object.Range{Include: []oid.ObjectID{h.merge}}
+	opts := DefaultOptions()
+	opts.Sort = SortTopo
+	assertHashes(t, "topo", collect(t, h.repo, head, opts), []oid.ObjectID{h.merge, h.third, h.second, h.side, h.root})
+
+	opts = DefaultOptions()
+	opts.Paths = []string{"b.txt"}
+	assertHashes(t, "b.txt", collect(t, h.repo, head, opts), []oid.ObjectID{h.side, h.root})
+
+	// The changed-path filter of the merge rules a.txt out
+	before := commitgraph.ReadBloomStats()
+	opts.Paths = []string{"a.txt"}
+	assertHashes(t, "a.txt", collect(t, h.repo, head, opts), []oid.ObjectID{h.third, h.second, h.root})
+	if stats := commitgraph.ReadBloomStats(); stats.DefinitelyNot == before.DefinitelyNot {
+		t.Errorf("Expected the changed-path filters to rule commits out, got %+v", stats)
+	}
+
+	// Excluded commits are walked from the graph alone, and only shown commits are parsed
+	for _, id := range []oid.ObjectID{h.root, h.second} {
+		if err := os.Remove(object.LoosePath(h.repo.Directory, id)); err != nil {
+			t.Fatalf("Failed to remove commit: %v", err)
+		}
+	}
+	exclude := &object.Range{Include: []oid.ObjectID{h.merge}, Exclude: []oid.ObjectID{h.second}}
+	assertHashes(t, "second..merge", collect(t, h.repo, exclude, DefaultOptions()), []oid.ObjectID{h.merge, h.third, h.side})
+}
+
+// writeBenchmarkHistory writes a line of commits, each changing one of 100 files spread over 10
+// directories, and points master at the last one.
+func writeBenchmarkHistory(b *testing.B, repo *repository.Repo, commits int) oid.ObjectID {
+	write := func(obj object.Object) oid.ObjectID {
+		hash, err := object.WriteObject(repo.Directory, obj)
+		if err != nil {
+			b.Fatalf("Failed to write object: %v", err)
+		}
+		return hash
+	}
+	writeTree := func(leaves []*object.Leaf) oid.ObjectID {
+		tree := object.CreateTree(nil)
+		tree.Leaves = leaves
+		data, err := tree.Serialize()
+		if err != nil {
+			b.Fatalf("Failed to serialize tree: %v", err)
+		}
+		return write(object.CreateTree(data))
+	}
+
+	dirs := make([][]*object.Leaf, 10)
+	root := make([]*object.Leaf, 10)
+	for d := range dirs {
+		for f := 0; f < 10; f++ {
+			blob := write(object.CreateBlob([]byte(fmt.Sprintf("file %d%d", d, f))))
+			dirs[d] = append(dirs[d], &object.Leaf{Mode: []byte("100644"), Path: fmt.Sprintf("file%d.txt", f), Hash: blob})
+		}
+		root[d] = &object.Leaf{Mode: []byte("40000"), Path: fmt.Sprintf("dir%d", d), Hash: writeTree(dirs[d])}
+	}
+
+	var head oid.ObjectID
+	for i := 0; i < commits; i++ {
+		d, f := i%10, i/10%10
+		dirs[d][f] = &object.Leaf{Mode: []byte("100644"), Path: dirs[d][f].Path, Hash: write(object.CreateBlob([]byte(fmt.Sprintf("version %d", i))))}
+		root[d] = &object.Leaf{Mode: []byte("40000"), Path: root[d].Path, Hash: writeTree(dirs[d])}
+
+		kvData := kv.CreateOrderedMap()
+		kvData.Add("tree", writeTree(root).String())
+		if head != "" {
+			kvData.Add("parent", head.String())
+		}
+		kvData.Add("author", fmt.Sprintf("Test <test@example.com> %d +0000", 1000+i))
+		kvData.Add("committer", fmt.Sprintf("Test <test@example.com> %d +0000", 1000+i))
+		kvData.Add("message", []byte(fmt.Sprintf("Change %d", i)))
+		head = write(object.CreateCommit(kv.Serialize(kvData)))
+	}
+
+	if err := os.WriteFile(filepath.Join(repo.Directory, "refs", "heads", "master"), []byte(head.String()+"\n"), 0644); err != nil {
+		b.Fatalf("Failed to write ref: %v", err)
+	}
+	return head
+}
+
+// BenchmarkPathLimitedWalk walks the history of one file out of 100 in 2000 commits, by
+// reading trees, with a commit-graph, and with its changed-path Bloom filters.
+func BenchmarkPathLimitedWalk(b *testing.B) {
+	setups := []struct {
+		name   string
+		config string
+		graph  bool
+	}{
+		{"trees", "", false},
+		{"commit-graph", "[commitGraph]\n\tchangedPaths = false\n", true},
+		{"changed-paths", "", true},
+	}
+
+	for _, setup := range setups {
+		repo := createTestRepo(b)
+		if err := os.WriteFile(filepath.Join(repo.Directory, "config"), []byte(setup.config), 0644); err != nil {
+			b.Fatalf("Failed to write config: %v", err)
+		}
+		head := writeBenchmarkHistory(b, repo, 2000)
+		if setup.graph {
+			if _, err := commitgraph.Write(repo); err != nil {
+				b.Fatalf("Failed to write commit-graph: %v", err)
+			}
+		}
+
+		b.Run(setup.name, func(b *testing.B) {
+			opts := DefaultOptions()
+			opts.Paths = []string{"dir3/file7.txt"}
+			for i := 0; i < b.N; i++ {
+				walker, err := New(repo, &object.Range{Include: []oid.ObjectID{head}}, opts)
+				if err != nil {
+					b.Fatalf("New failed: %v", err)
+				}
+				count := 0
+				if err := walker.ForEach(func(oid.ObjectID, *object.Commit) error {
+					count++
+					return nil
+				}); err != nil {
+					b.Fatalf("Walk failed: %v", err)
+				}
+				if count != 21 {
+					b.Fatalf("Expected 21 commits, got %d", count)
+				}
+			}
+		})
+	}
+}
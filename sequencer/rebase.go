@@ -0,0 +1,138 @@
+package sequencer
+
+import (
+	"errors"
+	"orf/oid"
+	"orf/repository"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// RebaseState is a rebase in progress. HeadName is the branch being rebased (e.g.
+// "refs/heads/topic", or "" for a detached HEAD) and OrigHead its commit before the rebase;
+// Onto is the new base. Todo holds the steps not yet done and Done those already done.
+// Amend is HEAD when an edit step stopped, so the commit can be amended on continue, and
+// EditSquash records that the squash chain being built needs its message edited.
+type RebaseState struct {
+	HeadName    string
+	OrigHead    oid.ObjectID
+	Onto        oid.ObjectID
+	Todo        []Step
+	Done        []Step
+	Interactive bool
+	Amend       oid.ObjectID
+	EditSquash  bool
+}
+
+// ErrNoRebase is returned when no rebase is in progress.
+var ErrNoRebase = errors.New("no rebase in progress")
+
+// rebaseDir returns .orf/rebase-merge, which holds the state of a rebase.
+func rebaseDir(repo *repository.Repo) string {
+	return filepath.Join(repo.Directory, "rebase-merge")
+}
+
+// TodoPath returns the path of the todo list of a rebase, which an interactive rebase lets
+// the user edit.
+func TodoPath(repo *repository.Repo) string {
+	return filepath.Join(rebaseDir(repo), "git-rebase-todo")
+}
+
+// RebaseInProgress reports whether a rebase has been started and not finished or aborted.
+func RebaseInProgress(repo *repository.Repo) bool {
+	_, err := os.Stat(rebaseDir(repo))
+	return err == nil
+}
+
+// ReadRebase loads the rebase in progress, or returns ErrNoRebase.
+func ReadRebase(repo *repository.Repo) (*RebaseState, error) {
+	if !RebaseInProgress(repo) {
+		return nil, ErrNoRebase
+	}
+
+	read := func(name string) (string, error) {
+		data, err := os.ReadFile(filepath.Join(rebaseDir(repo), name))
+		if errors.Is(err, os.ErrNotExist) {
+			return "", nil
+		}
+		return strings.TrimSpace(string(data)), err
+	}
+
+	state := &RebaseState{}
+	values := map[string]*string{
+		"head-name": &state.HeadName,
+		"orig-head": (*string)(&state.OrigHead),
+		"onto":      (*string)(&state.Onto),
+		"amend":     (*string)(&state.Amend),
+	}
+	for name, value := range values {
+		contents, err := read(name)
+		if err != nil {
+			return nil, err
+		}
+		*value = contents
+	}
+
+	for name, steps := range map[string]*[]Step{"git-rebase-todo": &state.Todo, "done": &state.Done} {
+		contents, err := read(name)
+		if err != nil {
+			return nil, err
+		}
+		if *steps, err = ParseTodo(contents); err != nil {
+			return nil, err
+		}
+	}
+
+	for name, flag := range map[string]*bool{"interactive": &state.Interactive, "squash-edit": &state.EditSquash} {
+		_, err := os.Stat(filepath.Join(rebaseDir(repo), name))
+		*flag = err == nil
+	}
+
+	return state, nil
+}
+
+// Write saves the state, replacing any saved before.
+func (state *RebaseState) Write(repo *repository.Repo) error {
+	if err := os.MkdirAll(rebaseDir(repo), os.ModePerm); err != nil {
+		return err
+	}
+
+	files := map[string]string{
+		"head-name":       state.HeadName,
+		"orig-head":       state.OrigHead.String(),
+		"onto":            state.Onto.String(),
+		"amend":           state.Amend.String(),
+		"git-rebase-todo": FormatTodo(state.Todo),
+		"done":            FormatTodo(state.Done),
+		"interactive":     "",
+		"squash-edit":     "",
+	}
+	flags := map[string]bool{"interactive": state.Interactive, "squash-edit": state.EditSquash}
+
+	for name, contents := range files {
+		path := filepath.Join(rebaseDir(repo), name)
+
+		// Empty values and unset flags are stored as missing files
+		if set, isFlag := flags[name]; (isFlag && !set) || (!isFlag && contents == "" && name != "git-rebase-todo") {
+			if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+			continue
+		}
+
+		if !strings.HasSuffix(contents, "\n") && contents != "" {
+			contents += "\n"
+		}
+		if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// RemoveRebase deletes the saved state, ending the rebase.
+func RemoveRebase(repo *repository.Repo) error {
+	return os.RemoveAll(rebaseDir(repo))
+}
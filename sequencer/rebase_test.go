@@ -0,0 +1,69 @@
+package sequencer
+
+import (
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestRebaseStateRoundTrip(t *testing.T) {
+	repo := createTestRepo(t)
+
+	if _, err := ReadRebase(repo); !errors.Is(err, ErrNoRebase) {
+		t.Fatalf("Expected ErrNoRebase, got %v", err)
+	}
+
+	state := &RebaseState{
+		HeadName:    "refs/heads/topic",
+		OrigHead:    "1111111111111111111111111111111111111111",
+		Onto:        "2222222222222222222222222222222222222222",
+		Todo:        []Step{{Action: Edit, Commit: "3333333333333333333333333333333333333333", Subject: "Change"}},
+		Done:        []Step{{Action: Exec, Command: "make"}},
+		Interactive: true,
+		EditSquash:  true,
+	}
+
+	if err := state.Write(repo); err != nil {
+		t.Fatalf("Failed to write state: %v", err)
+	}
+	if !RebaseInProgress(repo) {
+		t.Fatalf("Expected a rebase in progress")
+	}
+
+	read, err := ReadRebase(repo)
+	if err != nil {
+		t.Fatalf("Failed to read state: %v", err)
+	}
+	if read.HeadName != state.HeadName || read.OrigHead != state.OrigHead || read.Onto != state.Onto || read.Amend != "" {
+		t.Errorf("Read %+v; expected %+v", read, state)
+	}
+	if !read.Interactive || !read.EditSquash {
+		t.Errorf("Expected the interactive and squash-edit flags to be set")
+	}
+	if len(read.Todo) != 1 || read.Todo[0] != state.Todo[0] || len(read.Done) != 1 || read.Done[0] != state.Done[0] {
+		t.Errorf("Read todo %v and done %v", read.Todo, read.Done)
+	}
+
+	// Clearing values removes their files
+	state.EditSquash = false
+	state.Todo = nil
+	if err := state.Write(repo); err != nil {
+		t.Fatalf("Failed to write state: %v", err)
+	}
+	if read, err = ReadRebase(repo); err != nil {
+		t.Fatalf("Failed to read state: %v", err)
+	}
+	if read.EditSquash || len(read.Todo) != 0 {
+		t.Errorf("Expected squash-edit and the todo list to be cleared")
+	}
+	if _, err := os.Stat(TodoPath(repo)); err != nil {
+		t.Errorf("Expected an empty todo file: %v", err)
+	}
+
+	if err := RemoveRebase(repo); err != nil {
+		t.Fatalf("Failed to remove state: %v", err)
+	}
+	if RebaseInProgress(repo) {
+		t.Errorf("Expected no rebase in progress after RemoveRebase")
+	}
+}
@@ -0,0 +1,101 @@
+package sequencer
+
+import (
+	"errors"
+	"fmt"
+	"orf/oid"
+	"orf/repository"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-ini/ini"
+)
+
+// Options are the options of the whole sequence. RecordOrigin appends "(cherry picked from
+// commit ...)" to picked messages; Mainline selects the parent (starting at 1) that merge
+// commits are diffed against.
+type Options struct {
+	RecordOrigin bool
+	Mainline     int
+}
+
+// State is a sequence in progress. Head is HEAD before the first step, which --abort goes
+// back to. Todo holds the steps not yet done; while a step has conflicts it stays first.
+type State struct {
+	Head    oid.ObjectID
+	Todo    []Step
+	Options Options
+}
+
+// ErrNoSequence is returned when no sequence is in progress.
+var ErrNoSequence = errors.New("no cherry-pick or revert in progress")
+
+// dir returns .orf/sequencer, which holds the files head, todo and opts.
+func dir(repo *repository.Repo) string {
+	return filepath.Join(repo.Directory, "sequencer")
+}
+
+// InProgress reports whether a sequence has been started and not finished or aborted.
+func InProgress(repo *repository.Repo) bool {
+	_, err := os.Stat(filepath.Join(dir(repo), "todo"))
+	return err == nil
+}
+
+// Read loads the sequence in progress, or returns ErrNoSequence.
+func Read(repo *repository.Repo) (*State, error) {
+	if !InProgress(repo) {
+		return nil, ErrNoSequence
+	}
+
+	head, err := os.ReadFile(filepath.Join(dir(repo), "head"))
+	if err != nil {
+		return nil, err
+	}
+
+	state := &State{Head: oid.ObjectID(strings.TrimSpace(string(head)))}
+
+	todo, err := os.ReadFile(filepath.Join(dir(repo), "todo"))
+	if err != nil {
+		return nil, err
+	}
+	if state.Todo, err = ParseTodo(string(todo)); err != nil {
+		return nil, err
+	}
+
+	opts, err := ini.Load(filepath.Join(dir(repo), "opts"))
+	if err != nil {
+		return nil, fmt.Errorf("error reading sequencer options: %v", err)
+	}
+	section := opts.Section("options")
+	state.Options.RecordOrigin, _ = section.Key("record-origin").Bool()
+	state.Options.Mainline, _ = section.Key("mainline").Int()
+
+	return state, nil
+}
+
+// Write saves the state, replacing any saved before.
+func (state *State) Write(repo *repository.Repo) error {
+	if err := os.MkdirAll(dir(repo), os.ModePerm); err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(filepath.Join(dir(repo), "head"), []byte(state.Head.String()+"\n"), 0644); err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(filepath.Join(dir(repo), "todo"), []byte(FormatTodo(state.Todo)), 0644); err != nil {
+		return err
+	}
+
+	opts := ini.Empty()
+	section := opts.Section("options")
+	section.Key("record-origin").SetValue(fmt.Sprintf("%t", state.Options.RecordOrigin))
+	section.Key("mainline").SetValue(fmt.Sprintf("%d", state.Options.Mainline))
+	return opts.SaveTo(filepath.Join(dir(repo), "opts"))
+}
+
+// Remove deletes the saved state, ending the sequence.
+func Remove(repo *repository.Repo) error {
+	return os.RemoveAll(dir(repo))
+}
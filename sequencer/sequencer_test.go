@@ -0,0 +1,85 @@
+package sequencer
+
+import (
+	"errors"
+	"orf/oid"
+	"orf/repository"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func createTestRepo(t *testing.T) *repository.Repo {
+	workTree := t.TempDir()
+	directory := filepath.Join(workTree, ".orf")
+
+	if err := os.MkdirAll(directory, os.ModePerm); err != nil {
+		t.Fatalf("Failed to create directories: %v", err)
+	}
+
+	return &repository.Repo{WorkTree: workTree, Directory: directory}
+}
+
+func TestStateRoundTrip(t *testing.T) {
+	repo := createTestRepo(t)
+
+	if InProgress(repo) {
+		t.Fatalf("Expected no sequence in progress")
+	}
+	if _, err := Read(repo); !errors.Is(err, ErrNoSequence) {
+		t.Fatalf("Expected ErrNoSequence, got %v", err)
+	}
+
+	head := oid.SHA1.Zero()
+	state := &State{
+		Head: head,
+		Todo: []Step{
+			{Action: Pick, Commit: oid.ObjectID("1111111111111111111111111111111111111111"), Subject: "Fix the parser"},
+			{Action: Revert, Commit: oid.ObjectID("2222222222222222222222222222222222222222")},
+		},
+		Options: Options{RecordOrigin: true, Mainline: 2},
+	}
+
+	if err := state.Write(repo); err != nil {
+		t.Fatalf("Failed to write state: %v", err)
+	}
+	if !InProgress(repo) {
+		t.Fatalf("Expected a sequence in progress")
+	}
+
+	read, err := Read(repo)
+	if err != nil {
+		t.Fatalf("Failed to read state: %v", err)
+	}
+
+	if read.Head != head {
+		t.Errorf("Head = %s; expected %s", read.Head, head)
+	}
+	if read.Options != state.Options {
+		t.Errorf("Options = %+v; expected %+v", read.Options, state.Options)
+	}
+	if len(read.Todo) != len(state.Todo) {
+		t.Fatalf("Todo = %v; expected %v", read.Todo, state.Todo)
+	}
+	for i := range state.Todo {
+		if read.Todo[i] != state.Todo[i] {
+			t.Errorf("Todo[%d] = %+v; expected %+v", i, read.Todo[i], state.Todo[i])
+		}
+	}
+
+	if err := Remove(repo); err != nil {
+		t.Fatalf("Failed to remove state: %v", err)
+	}
+	if InProgress(repo) {
+		t.Errorf("Expected no sequence in progress after Remove")
+	}
+}
+
+func TestParseStep(t *testing.T) {
+	if _, err := parseStep("merge 1111111111111111111111111111111111111111"); err == nil {
+		t.Errorf("Expected an error for an unknown action")
+	}
+	if _, err := parseStep("pick"); err == nil {
+		t.Errorf("Expected an error for a missing commit")
+	}
+}
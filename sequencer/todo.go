@@ -0,0 +1,207 @@
+package sequencer
+
+import (
+	"fmt"
+	"orf/oid"
+	"strings"
+)
+
+// Action is what a step does with its commit.
+type Action string
+
+const (
+	// Pick applies the changes a commit introduced.
+	Pick Action = "pick"
+	// Revert applies the reverse of the changes a commit introduced.
+	Revert Action = "revert"
+	// Reword picks a commit and lets the user edit its message.
+	Reword Action = "reword"
+	// Edit picks a commit and stops, so it can be amended.
+	Edit Action = "edit"
+	// Squash melds a commit into the previous one, combining their messages.
+	Squash Action = "squash"
+	// Fixup melds a commit into the previous one, keeping the previous message.
+	Fixup Action = "fixup"
+	// Drop removes a commit.
+	Drop Action = "drop"
+	// Exec runs a shell command.
+	Exec Action = "exec"
+)
+
+// abbreviations maps the one-letter forms accepted in todo lists to their actions.
+var abbreviations = map[string]Action{
+	"p": Pick,
+	"r": Reword,
+	"e": Edit,
+	"s": Squash,
+	"f": Fixup,
+	"d": Drop,
+	"x": Exec,
+}
+
+// Step is one line of the todo list: an action, the commit it applies to and the commit's
+// subject (kept for readability only), or for exec the command to run.
+type Step struct {
+	Action  Action
+	Commit  oid.ObjectID
+	Subject string
+	Command string
+}
+
+// String formats the step as a todo line: "<action> <commit> <subject>" or
+// "exec <command>".
+func (step Step) String() string {
+	if step.Action == Exec {
+		return fmt.Sprintf("%s %s", step.Action, step.Command)
+	}
+	return strings.TrimSpace(fmt.Sprintf("%s %s %s", step.Action, step.Commit, step.Subject))
+}
+
+// TodoHelp explains the todo list format, for the comments of an interactive todo list.
+const TodoHelp = `
+# Commands:
+# p, pick <commit> = use commit
+# r, reword <commit> = use commit, but edit the commit message
+# e, edit <commit> = use commit, but stop for amending
+# s, squash <commit> = use commit, but meld into previous commit
+# f, fixup <commit> = like "squash", but discard this commit's log message
+# x, exec <command> = run command (the rest of the line) using shell
+# d, drop <commit> = remove commit
+#
+# These lines can be re-ordered; they are executed from top to bottom.
+# If you remove a line here THAT COMMIT WILL BE LOST.
+# However, if you remove everything, the rebase will be aborted.
+`
+
+// ParseTodo parses a todo list, one step per line. Blank lines and lines starting with '#'
+// are ignored.
+func ParseTodo(todo string) ([]Step, error) {
+	var steps []Step
+	for _, line := range strings.Split(todo, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		step, err := parseStep(line)
+		if err != nil {
+			return nil, err
+		}
+		steps = append(steps, step)
+	}
+	return steps, nil
+}
+
+func parseStep(line string) (Step, error) {
+	name, rest, _ := strings.Cut(line, " ")
+	rest = strings.TrimSpace(rest)
+
+	action := Action(name)
+	if abbreviation, ok := abbreviations[name]; ok {
+		action = abbreviation
+	}
+
+	switch action {
+	case Exec:
+		if rest == "" {
+			return Step{}, fmt.Errorf("missing command: %s", line)
+		}
+		return Step{Action: Exec, Command: rest}, nil
+
+	case Pick, Revert, Reword, Edit, Squash, Fixup, Drop:
+		commit, subject, _ := strings.Cut(rest, " ")
+		if commit == "" {
+			return Step{}, fmt.Errorf("missing commit: %s", line)
+		}
+		return Step{Action: action, Commit: oid.ObjectID(commit), Subject: strings.TrimSpace(subject)}, nil
+
+	default:
+		return Step{}, fmt.Errorf("invalid todo action %q", name)
+	}
+}
+
+// FormatTodo formats steps as a todo list, one per line.
+func FormatTodo(steps []Step) string {
+	var todo strings.Builder
+	for _, step := range steps {
+		todo.WriteString(step.String() + "\n")
+	}
+	return todo.String()
+}
+
+// Autosquash moves each picked commit whose subject starts with "fixup! " or "squash! "
+// right after the commit it names (by subject, subject prefix or commit id prefix), turning
+// it into a fixup or squash step. Commits whose target is not found earlier in the list
+// stay where they are.
+func Autosquash(steps []Step) []Step {
+	attached := make(map[int][]Step)
+	moved := make(map[int]bool)
+
+	for i, step := range steps {
+		action, target, ok := squashTarget(step.Subject)
+		if !ok || step.Action != Pick {
+			continue
+		}
+
+		root := findTarget(steps[:i], moved, target)
+		if root == -1 {
+			continue
+		}
+
+		step.Action = action
+		attached[root] = append(attached[root], step)
+		moved[i] = true
+	}
+
+	var result []Step
+	for i, step := range steps {
+		if moved[i] {
+			continue
+		}
+		result = append(result, step)
+		result = append(result, attached[i]...)
+	}
+	return result
+}
+
+// squashTarget returns the action and target named by a "fixup! " or "squash! " subject.
+// Repeated prefixes ("fixup! fixup! x") name the same target.
+func squashTarget(subject string) (Action, string, bool) {
+	var action Action
+	for {
+		var prefix Action
+		switch {
+		case strings.HasPrefix(subject, "fixup! "):
+			prefix = Fixup
+		case strings.HasPrefix(subject, "squash! "):
+			prefix = Squash
+		default:
+			return action, strings.TrimSpace(subject), action != ""
+		}
+
+		subject = strings.TrimPrefix(subject, string(prefix)+"! ")
+		if action == "" {
+			action = prefix
+		}
+	}
+}
+
+// findTarget returns the index of the step a fixup names: an exact subject match if there
+// is one, otherwise the first subject starting with target or commit id starting with it.
+func findTarget(steps []Step, moved map[int]bool, target string) int {
+	if target == "" {
+		return -1
+	}
+
+	for i, step := range steps {
+		if !moved[i] && step.Subject == target {
+			return i
+		}
+	}
+	for i, step := range steps {
+		if !moved[i] && (strings.HasPrefix(step.Subject, target) || strings.HasPrefix(step.Commit.String(), target)) {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,89 @@
+package sequencer
+
+import (
+	"orf/oid"
+	"testing"
+)
+
+func TestParseTodo(t *testing.T) {
+	todo := `pick 1111111 Add the parser
+# a comment
+
+r 2222222 Fix a typo
+f 3333333
+x go test ./...
+drop 4444444 Remove everything
+`
+
+	steps, err := ParseTodo(todo)
+	if err != nil {
+		t.Fatalf("Failed to parse todo: %v", err)
+	}
+
+	expected := []Step{
+		{Action: Pick, Commit: "1111111", Subject: "Add the parser"},
+		{Action: Reword, Commit: "2222222", Subject: "Fix a typo"},
+		{Action: Fixup, Commit: "3333333"},
+		{Action: Exec, Command: "go test ./..."},
+		{Action: Drop, Commit: "4444444", Subject: "Remove everything"},
+	}
+	if len(steps) != len(expected) {
+		t.Fatalf("Parsed %v; expected %v", steps, expected)
+	}
+	for i := range expected {
+		if steps[i] != expected[i] {
+			t.Errorf("Step %d = %+v; expected %+v", i, steps[i], expected[i])
+		}
+	}
+
+	// Formatting and parsing again gives the same steps
+	again, err := ParseTodo(FormatTodo(steps))
+	if err != nil {
+		t.Fatalf("Failed to parse formatted todo: %v", err)
+	}
+	for i := range steps {
+		if again[i] != steps[i] {
+			t.Errorf("Round trip step %d = %+v; expected %+v", i, again[i], steps[i])
+		}
+	}
+
+	if _, err := ParseTodo("exec\n"); err == nil {
+		t.Errorf("Expected an error for exec without a command")
+	}
+}
+
+func TestAutosquash(t *testing.T) {
+	pick := func(commit string, subject string) Step {
+		return Step{Action: Pick, Commit: oid.ObjectID(commit), Subject: subject}
+	}
+
+	steps := []Step{
+		pick("aaaa", "Add the parser"),
+		pick("bbbb", "Add the lexer"),
+		pick("cccc", "fixup! Add the parser"),
+		pick("dddd", "squash! Add the lexer"),
+		pick("eeee", "fixup! fixup! Add the parser"),
+		pick("ffff", "fixup! bbbb"),
+		pick("0000", "fixup! Something else"),
+	}
+
+	result := Autosquash(steps)
+
+	expected := []Step{
+		pick("aaaa", "Add the parser"),
+		{Action: Fixup, Commit: "cccc", Subject: "fixup! Add the parser"},
+		{Action: Fixup, Commit: "eeee", Subject: "fixup! fixup! Add the parser"},
+		pick("bbbb", "Add the lexer"),
+		{Action: Squash, Commit: "dddd", Subject: "squash! Add the lexer"},
+		{Action: Fixup, Commit: "ffff", Subject: "fixup! bbbb"},
+		pick("0000", "fixup! Something else"),
+	}
+	if len(result) != len(expected) {
+		t.Fatalf("Autosquash = %v; expected %v", result, expected)
+	}
+	for i := range expected {
+		if result[i] != expected[i] {
+			t.Errorf("Step %d = %+v; expected %+v", i, result[i], expected[i])
+		}
+	}
+}
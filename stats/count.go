@@ -0,0 +1,161 @@
+// Package stats measures a repository: how its objects are stored, and which blobs, trees,
+// commits and directories of its history take the most room.
+package stats
+
+import (
+	"errors"
+	"orf/object"
+	"orf/pack"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Counts describes the object database of a repository, the way git count-objects does.
+// Sizes are the bytes used on disk.
+type Counts struct {
+	// Count and Size are those of the loose objects
+	Count int   `json:"count"`
+	Size  int64 `json:"size"`
+
+	// InPack counts the objects of every pack, so an object in two packs counts twice.
+	// SizePack includes the indexes, the multi-pack-index and its bitmaps.
+	InPack   int   `json:"in_pack"`
+	Packs    int   `json:"packs"`
+	SizePack int64 `json:"size_pack"`
+
+	// PrunePackable counts the loose objects that are also packed
+	PrunePackable int `json:"prune_packable"`
+
+	// Garbage lists the files of the object database that are neither objects, packs nor
+	// their indexes, such as the temporary files of an interrupted write
+	Garbage     []string `json:"garbage"`
+	SizeGarbage int64    `json:"size_garbage"`
+}
+
+// CountObjects counts the loose and packed objects of a .orf directory, and finds the garbage
+// among the loose object directories and objects/pack.
+func CountObjects(directory string) (*Counts, error) {
+	counts := &Counts{Garbage: []string{}}
+	if err := countLoose(directory, counts); err != nil {
+		return nil, err
+	}
+	if err := countPacks(directory, counts); err != nil {
+		return nil, err
+	}
+	sort.Strings(counts.Garbage)
+	return counts, nil
+}
+
+// countLoose counts the files of the two-character directories of objects/.
+func countLoose(directory string, counts *Counts) error {
+	ids, err := object.ListLooseObjects(directory)
+	if err != nil {
+		return err
+	}
+	loose := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		loose[object.LoosePath(directory, id)] = true
+
+		packed, err := pack.Has(directory, id)
+		if err != nil {
+			return err
+		}
+		if packed {
+			counts.PrunePackable++
+		}
+	}
+
+	objectsDir := filepath.Join(directory, "objects")
+	dirs, err := os.ReadDir(objectsDir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	for _, dir := range dirs {
+		if !dir.IsDir() || len(dir.Name()) != 2 || strings.Trim(dir.Name(), "0123456789abcdef") != "" {
+			continue
+		}
+
+		files, err := os.ReadDir(filepath.Join(objectsDir, dir.Name()))
+		if err != nil {
+			return err
+		}
+		for _, file := range files {
+			path := filepath.Join(objectsDir, dir.Name(), file.Name())
+			info, err := file.Info()
+			if err != nil {
+				if errors.Is(err, os.ErrNotExist) {
+					continue
+				}
+				return err
+			}
+
+			if loose[path] {
+				counts.Count++
+				counts.Size += info.Size()
+			} else {
+				counts.Garbage = append(counts.Garbage, path)
+				counts.SizeGarbage += info.Size()
+			}
+		}
+	}
+	return nil
+}
+
+// countPacks counts the packs of objects/pack. A pack without its index, an index without its
+// pack and any file other than the multi-pack-index and its bitmaps are garbage.
+func countPacks(directory string, counts *Counts) error {
+	entries, err := os.ReadDir(pack.Dir(directory))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+
+	names := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		names[entry.Name()] = true
+	}
+
+	packs, err := pack.List(directory)
+	if err != nil {
+		return err
+	}
+	for _, p := range packs {
+		if !names[filepath.Base(p.Path)] {
+			continue
+		}
+		counts.Packs++
+		counts.InPack += len(p.IDs())
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		info, err := entry.Info()
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return err
+		}
+
+		base, ext, _ := strings.Cut(name, ".")
+		switch {
+		case strings.HasPrefix(name, "pack-") && ext == "pack" && names[base+".idx"],
+			strings.HasPrefix(name, "pack-") && ext == "idx" && names[base+".pack"]:
+			counts.SizePack += info.Size()
+		case name == "multi-pack-index",
+			strings.HasPrefix(name, "multi-pack-index-") && ext == "bitmap":
+			counts.SizePack += info.Size()
+		default:
+			counts.Garbage = append(counts.Garbage, filepath.Join(pack.Dir(directory), name))
+			counts.SizeGarbage += info.Size()
+		}
+	}
+	return nil
+}
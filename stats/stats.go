@@ -0,0 +1,284 @@
+package stats
+
+import (
+	"errors"
+	"orf/object"
+	"orf/oid"
+	"orf/repository"
+	"orf/revwalk"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Report describes the history of a repository: the number and total size of its objects of
+// each type, and the largest of them. Sizes are those of the object contents, uncompressed.
+type Report struct {
+	Commits Totals `json:"commits"`
+	Trees   Totals `json:"trees"`
+	Blobs   Totals `json:"blobs"`
+	Tags    Totals `json:"tags"`
+
+	LargestBlobs   []Blob      `json:"largest_blobs"`
+	DeepestTrees   []Tree      `json:"deepest_trees"`
+	BiggestCommits []Commit    `json:"biggest_commits"`
+	Directories    []Directory `json:"directories"`
+}
+
+// Totals counts the objects of a type.
+type Totals struct {
+	Count int   `json:"count"`
+	Size  int64 `json:"size"`
+}
+
+// Blob is a blob of the history, with the first path it was found at.
+type Blob struct {
+	ID   oid.ObjectID `json:"id"`
+	Path string       `json:"path"`
+	Size int64        `json:"size"`
+}
+
+// Tree is a tree of the history, with the first path it was found at ("" for a root tree),
+// the number of directories above it and its number of entries.
+type Tree struct {
+	ID      oid.ObjectID `json:"id"`
+	Path    string       `json:"path"`
+	Depth   int          `json:"depth"`
+	Entries int          `json:"entries"`
+}
+
+// Commit is a commit with the blobs it added to the history: those none of its ancestors
+// have.
+type Commit struct {
+	ID      oid.ObjectID `json:"id"`
+	Subject string       `json:"subject"`
+	Added   int64        `json:"added"`
+	Blobs   int          `json:"blobs"`
+}
+
+// Directory is a directory with every blob ever added under it, each counted once at the
+// first path it was found at.
+type Directory struct {
+	Path  string `json:"path"`
+	Size  int64  `json:"size"`
+	Blobs int    `json:"blobs"`
+}
+
+// collector gathers the report, reading each object once.
+type collector struct {
+	repo        *repository.Repo
+	report      *Report
+	seen        map[oid.ObjectID]bool
+	directories map[string]*Directory
+}
+
+// Collect walks every commit a ref, reflog or HEAD-like file leads to, oldest first, and
+// reports on the objects they reach, keeping the limit largest of each list. Missing objects
+// are skipped; fsck reports them.
+func Collect(repo *repository.Repo, limit int) (*Report, error) {
+	c := &collector{
+		repo: repo,
+		report: &Report{
+			LargestBlobs:   []Blob{},
+			DeepestTrees:   []Tree{},
+			BiggestCommits: []Commit{},
+			Directories:    []Directory{},
+		},
+		seen:        make(map[oid.ObjectID]bool),
+		directories: make(map[string]*Directory),
+	}
+
+	tips, err := c.tips()
+	if err != nil {
+		return nil, err
+	}
+	if len(tips) > 0 {
+		walker, err := revwalk.New(repo, &object.Range{Include: tips}, revwalk.Options{Sort: revwalk.SortTopo, Reverse: true, MaxCount: -1})
+		if err != nil {
+			return nil, err
+		}
+		if err := walker.ForEach(c.commit); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, directory := range c.directories {
+		c.report.Directories = append(c.report.Directories, *directory)
+	}
+	c.report.sort(limit)
+	return c.report, nil
+}
+
+// tips returns the commits the roots of the repository lead to, counting the tags on the way.
+// Index entries are not history and are left out.
+func (c *collector) tips() ([]oid.ObjectID, error) {
+	roots, err := object.ListRoots(c.repo)
+	if err != nil {
+		return nil, err
+	}
+
+	var tips []oid.ObjectID
+	for _, root := range roots {
+		if root.Type == "blob" {
+			continue
+		}
+
+		id := root.ID
+		for {
+			obj, err := object.ReadObject(c.repo.Directory, id)
+			if errors.Is(err, object.ErrNotFound) {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+
+			if tag, ok := obj.(*object.Tag); ok {
+				if !c.seen[id] {
+					c.seen[id] = true
+					c.report.Tags.add(obj)
+				}
+				id = tag.Target()
+				continue
+			}
+			if _, ok := obj.(*object.Commit); ok {
+				tips = append(tips, id)
+			}
+			break
+		}
+	}
+	return tips, nil
+}
+
+// commit counts a commit and the trees and blobs it adds.
+func (c *collector) commit(id oid.ObjectID, commit *object.Commit) error {
+	c.report.Commits.add(commit)
+
+	subject, _, _ := strings.Cut(strings.TrimSpace(commit.Message()), "\n")
+	entry := Commit{ID: id, Subject: subject}
+	if err := c.tree(commit.TreeHash(), "", 0, &entry); err != nil {
+		return err
+	}
+	c.report.BiggestCommits = append(c.report.BiggestCommits, entry)
+	return nil
+}
+
+// tree counts a tree found at name, and the trees and blobs under it, for the commit adding
+// them. Trees already seen are skipped, along with everything under them.
+func (c *collector) tree(id oid.ObjectID, name string, depth int, commit *Commit) error {
+	if c.seen[id] {
+		return nil
+	}
+	c.seen[id] = true
+
+	obj, err := object.ReadObject(c.repo.Directory, id)
+	if errors.Is(err, object.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	tree, ok := obj.(*object.Tree)
+	if !ok {
+		return nil
+	}
+
+	c.report.Trees.add(tree)
+	c.report.DeepestTrees = append(c.report.DeepestTrees, Tree{ID: id, Path: name, Depth: depth, Entries: len(tree.Leaves)})
+
+	for _, leaf := range tree.Leaves {
+		child := path.Join(name, leaf.Path)
+		switch {
+		case leaf.IsTree():
+			err = c.tree(leaf.Hash, child, depth+1, commit)
+		case string(leaf.Mode) != "160000":
+			err = c.blob(leaf.Hash, child, commit)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// blob counts a blob found at name for the commit adding it, and adds its size to the
+// directories above it.
+func (c *collector) blob(id oid.ObjectID, name string, commit *Commit) error {
+	if c.seen[id] {
+		return nil
+	}
+	c.seen[id] = true
+
+	obj, err := object.ReadObject(c.repo.Directory, id)
+	if errors.Is(err, object.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	size := int64(len(obj.GetData()))
+	c.report.Blobs.add(obj)
+	c.report.LargestBlobs = append(c.report.LargestBlobs, Blob{ID: id, Path: name, Size: size})
+	commit.Added += size
+	commit.Blobs++
+
+	for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+		directory, ok := c.directories[dir]
+		if !ok {
+			directory = &Directory{Path: dir}
+			c.directories[dir] = directory
+		}
+		directory.Size += size
+		directory.Blobs++
+	}
+	return nil
+}
+
+func (totals *Totals) add(obj object.Object) {
+	totals.Count++
+	totals.Size += int64(len(obj.GetData()))
+}
+
+// sort orders the lists of the report, largest first, and keeps the first limit entries of
+// each (all of them if limit is not positive). Commits of the same size stay oldest first,
+// and other ties are broken by path, then id.
+func (report *Report) sort(limit int) {
+	sort.Slice(report.LargestBlobs, func(i, j int) bool {
+		a, b := report.LargestBlobs[i], report.LargestBlobs[j]
+		if a.Size != b.Size {
+			return a.Size > b.Size
+		}
+		if a.Path != b.Path {
+			return a.Path < b.Path
+		}
+		return a.ID < b.ID
+	})
+	sort.Slice(report.DeepestTrees, func(i, j int) bool {
+		a, b := report.DeepestTrees[i], report.DeepestTrees[j]
+		if a.Depth != b.Depth {
+			return a.Depth > b.Depth
+		}
+		if a.Path != b.Path {
+			return a.Path < b.Path
+		}
+		return a.ID < b.ID
+	})
+	sort.SliceStable(report.BiggestCommits, func(i, j int) bool {
+		return report.BiggestCommits[i].Added > report.BiggestCommits[j].Added
+	})
+	sort.Slice(report.Directories, func(i, j int) bool {
+		a, b := report.Directories[i], report.Directories[j]
+		if a.Size != b.Size {
+			return a.Size > b.Size
+		}
+		return a.Path < b.Path
+	})
+
+	if limit > 0 {
+		report.LargestBlobs = report.LargestBlobs[:min(limit, len(report.LargestBlobs))]
+		report.DeepestTrees = report.DeepestTrees[:min(limit, len(report.DeepestTrees))]
+		report.BiggestCommits = report.BiggestCommits[:min(limit, len(report.BiggestCommits))]
+		report.Directories = report.Directories[:min(limit, len(report.Directories))]
+	}
+}
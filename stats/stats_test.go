@@ -0,0 +1,225 @@
+package stats
+
+import (
+	"orf/kv"
+	"orf/object"
+	"orf/oid"
+	"orf/pack"
+	"orf/repository"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func createTestRepo(t *testing.T) *repository.Repo {
+	workTree := t.TempDir()
+	directory := filepath.Join(workTree, ".orf")
+
+	for _, dir := range []string{"objects", filepath.Join("refs", "heads"), filepath.Join("refs", "tags")} {
+		if err := os.MkdirAll(filepath.Join(directory, dir), os.ModePerm); err != nil {
+			t.Fatalf("Failed to create directories: %v", err)
+		}
+	}
+
+	return &repository.Repo{WorkTree: workTree, Directory: directory}
+}
+
+// writeTestTree writes the tree of files, whose slash-separated paths may be in directories.
+func writeTestTree(t *testing.T, repo *repository.Repo, files map[string]string) oid.ObjectID {
+	children := make(map[string]map[string]string)
+	tree := object.CreateTree(nil)
+	for path, contents := range files {
+		if dir, rest, ok := strings.Cut(path, "/"); ok {
+			if children[dir] == nil {
+				children[dir] = make(map[string]string)
+			}
+			children[dir][rest] = contents
+			continue
+		}
+
+		blob, err := object.WriteObject(repo.Directory, object.CreateBlob([]byte(contents)))
+		if err != nil {
+			t.Fatalf("Failed to write blob: %v", err)
+		}
+		tree.Leaves = append(tree.Leaves, &object.Leaf{Mode: []byte("100644"), Path: path, Hash: blob})
+	}
+	for dir, contents := range children {
+		tree.Leaves = append(tree.Leaves, &object.Leaf{Mode: []byte("40000"), Path: dir, Hash: writeTestTree(t, repo, contents)})
+	}
+	sort.Sort(object.ByPath(tree.Leaves))
+
+	data, err := tree.Serialize()
+	if err != nil {
+		t.Fatalf("Failed to serialize tree: %v", err)
+	}
+	hash, err := object.WriteObject(repo.Directory, object.CreateTree(data))
+	if err != nil {
+		t.Fatalf("Failed to write tree: %v", err)
+	}
+	return hash
+}
+
+// writeTestCommit writes a commit of a tree of files.
+func writeTestCommit(t *testing.T, repo *repository.Repo, files map[string]string, parents []oid.ObjectID, message string) oid.ObjectID {
+	kvData := kv.CreateOrderedMap()
+	kvData.Add("tree", writeTestTree(t, repo, files).String())
+	for _, parent := range parents {
+		kvData.Add("parent", parent.String())
+	}
+	kvData.Add("author", "Test <test@example.com> 1700000000 +0000")
+	kvData.Add("committer", "Test <test@example.com> 1700000000 +0000")
+	kvData.Add("message", message)
+
+	hash, err := object.WriteObject(repo.Directory, object.CreateCommit(kv.Serialize(kvData)))
+	if err != nil {
+		t.Fatalf("Failed to write commit: %v", err)
+	}
+	return hash
+}
+
+func writeTestRef(t *testing.T, repo *repository.Repo, name string, hash oid.ObjectID) {
+	if err := os.WriteFile(filepath.Join(repo.Directory, filepath.FromSlash(name)), []byte(hash.String()+"\n"), 0644); err != nil {
+		t.Fatalf("Failed to write ref: %v", err)
+	}
+}
+
+func TestCountObjects(t *testing.T) {
+	repo := createTestRepo(t)
+	commit := writeTestCommit(t, repo, map[string]string{"a.txt": "a"}, nil, "first")
+	writeTestRef(t, repo, "refs/heads/master", commit)
+
+	counts, err := CountObjects(repo.Directory)
+	if err != nil {
+		t.Fatalf("CountObjects failed: %v", err)
+	}
+	if counts.Count != 3 || counts.Size == 0 || counts.Packs != 0 || len(counts.Garbage) != 0 {
+		t.Errorf("Expected 3 loose objects and nothing else, got %+v", counts)
+	}
+
+	obj, err := object.ReadObject(repo.Directory, commit)
+	if err != nil {
+		t.Fatalf("ReadObject failed: %v", err)
+	}
+	packed, err := pack.Write(repo.Directory, repo.ObjectFormat(), []oid.ObjectID{commit}, func(oid.ObjectID) (string, []byte, error) {
+		return obj.GetFormat(), obj.GetData(), nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to write pack: %v", err)
+	}
+	if _, err := pack.WriteMultiPackIndex(repo.Directory); err != nil {
+		t.Fatalf("Failed to write multi-pack-index: %v", err)
+	}
+
+	garbage := []string{
+		filepath.Join(repo.Directory, "objects", "ab", "tmp_obj_123"),
+		filepath.Join(pack.Dir(repo.Directory), "tmp_pack_456"),
+		filepath.Join(pack.Dir(repo.Directory), "pack-orphan.pack"),
+	}
+	for _, path := range garbage {
+		if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte("junk"), 0644); err != nil {
+			t.Fatalf("Failed to write garbage: %v", err)
+		}
+	}
+
+	counts, err = CountObjects(repo.Directory)
+	if err != nil {
+		t.Fatalf("CountObjects failed: %v", err)
+	}
+	if counts.Count != 3 || counts.InPack != 1 || counts.Packs != 1 || counts.PrunePackable != 1 {
+		t.Errorf("Expected 3 loose objects, one of them also in the pack, got %+v", counts)
+	}
+
+	info, err := os.Stat(packed.Path)
+	if err != nil {
+		t.Fatalf("Failed to stat pack: %v", err)
+	}
+	if counts.SizePack <= info.Size() {
+		t.Errorf("Expected the size of the pack and its indexes, got %d", counts.SizePack)
+	}
+
+	sort.Strings(garbage)
+	if strings.Join(counts.Garbage, "\n") != strings.Join(garbage, "\n") || counts.SizeGarbage != 12 {
+		t.Errorf("Expected garbage %v of 12 bytes, got %v of %d", garbage, counts.Garbage, counts.SizeGarbage)
+	}
+
+	if counts, err := CountObjects(t.TempDir()); err != nil || counts.Count != 0 {
+		t.Errorf("Expected nothing in an empty directory, got %+v (%v)", counts, err)
+	}
+}
+
+func TestCollect(t *testing.T) {
+	repo := createTestRepo(t)
+	first := writeTestCommit(t, repo, map[string]string{
+		"README":          "readme",
+		"src/main.go":     "package main",
+		"src/lib/deep.go": "package lib",
+	}, nil, "first\n\nwith a body")
+	second := writeTestCommit(t, repo, map[string]string{
+		"README":          "readme",
+		"src/main.go":     "package main",
+		"src/lib/deep.go": "package lib",
+		"assets/big.bin":  strings.Repeat("x", 1000),
+	}, []oid.ObjectID{first}, "add assets")
+	third := writeTestCommit(t, repo, map[string]string{
+		"README":          "readme, longer",
+		"src/main.go":     "package main",
+		"src/lib/deep.go": "package lib",
+		"assets/big.bin":  strings.Repeat("x", 1000),
+	}, []oid.ObjectID{second}, "edit readme")
+	writeTestRef(t, repo, "refs/heads/master", third)
+
+	report, err := Collect(repo, 2)
+	if err != nil {
+		t.Fatalf("Collect failed: %v", err)
+	}
+
+	if report.Commits.Count != 3 || report.Blobs.Count != 5 || report.Tags.Count != 0 {
+		t.Errorf("Expected 3 commits and 5 blobs, got %+v and %+v", report.Commits, report.Blobs)
+	}
+	if report.Blobs.Size != 6+12+11+1000+14 {
+		t.Errorf("Expected 1043 bytes of blobs, got %d", report.Blobs.Size)
+	}
+
+	if len(report.LargestBlobs) != 2 || report.LargestBlobs[0].Path != "assets/big.bin" || report.LargestBlobs[1].Path != "README" {
+		t.Errorf("Unexpected largest blobs %+v", report.LargestBlobs)
+	}
+	if len(report.DeepestTrees) != 2 || report.DeepestTrees[0].Path != "src/lib" || report.DeepestTrees[0].Depth != 2 {
+		t.Errorf("Unexpected deepest trees %+v", report.DeepestTrees)
+	}
+
+	commits := report.BiggestCommits
+	if len(commits) != 2 || commits[0].ID != second || commits[0].Added != 1000 || commits[1].ID != first || commits[1].Blobs != 3 {
+		t.Errorf("Unexpected biggest commits %+v", commits)
+	}
+	if commits[1].Subject != "first" {
+		t.Errorf("Expected the subject line only, got %q", commits[1].Subject)
+	}
+
+	directories := report.Directories
+	if len(directories) != 2 || directories[0].Path != "assets" || directories[1].Path != "src" || directories[1].Size != 23 || directories[1].Blobs != 2 {
+		t.Errorf("Unexpected directories %+v", directories)
+	}
+
+	all, err := Collect(repo, 0)
+	if err != nil {
+		t.Fatalf("Collect failed: %v", err)
+	}
+	if len(all.LargestBlobs) != 5 || len(all.Directories) != 3 {
+		t.Errorf("Expected every blob and directory without a limit, got %d and %d", len(all.LargestBlobs), len(all.Directories))
+	}
+}
+
+func TestCollectEmpty(t *testing.T) {
+	report, err := Collect(createTestRepo(t), 10)
+	if err != nil {
+		t.Fatalf("Collect failed: %v", err)
+	}
+	if report.Commits.Count != 0 || report.LargestBlobs == nil || len(report.LargestBlobs) != 0 {
+		t.Errorf("Expected an empty report with empty lists, got %+v", report)
+	}
+}